@@ -7,15 +7,19 @@ import (
 	"fmt"
 
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 func main() {
-    var connectionStr, migrationsPath string
+    var driverName, connectionStr, migrationsPath string
   
-    flag.StringVar(&connectionStr, "connection-string", "", "postgres connection string for db")
-    flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations")
+    flag.StringVar(&driverName, "driver", "postgres", "database driver: postgres or sqlite")
+    flag.StringVar(&connectionStr, "connection-string", "", "postgres connection string or sqlite database path")
+    flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations, migrations/sqlite for sqlite")
     flag.Parse()
   
     if connectionStr == "" {
@@ -26,7 +30,13 @@ func main() {
         panic("migrations-path is required") 
     }
 
-    db, err := sql.Open("postgres", connectionStr)
+    // The postgres database is reached through the pgx driver, as the storage does.
+    sqlDriver := driverName
+    if driverName == "postgres" {
+        sqlDriver = "pgx"
+    }
+
+    db, err := sql.Open(sqlDriver, connectionStr)
 
     if err != nil {
         panic(err)
@@ -34,11 +44,23 @@ func main() {
 
     defer db.Close()
 
-    driver, err := postgres.WithInstance(db, &postgres.Config{})
+    var driver database.Driver
+    switch driverName {
+    case "postgres":
+        driver, err = pgx.WithInstance(db, &pgx.Config{})
+    case "sqlite":
+        driver, err = sqlite.WithInstance(db, &sqlite.Config{})
+    default:
+        panic("unknown driver " + driverName)
+    }
+
+    if err != nil {
+        panic(err)
+    }
 
     m, err := migrate.NewWithDatabaseInstance(
         "file://"+migrationsPath,
-        "postgres",
+        driverName,
         driver,
     )
 
@@ -56,4 +78,3 @@ func main() {
 
     fmt.Println("migrations applied successfully")
 }
-
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"grpc-service-ref/internal/app"
 	"grpc-service-ref/internal/config"
 	"log/slog"
@@ -23,14 +22,15 @@ func main() {
 
     log.Info("starting application", slog.Any("cfg", cfg))
   
-    psqlInfo := fmt.Sprintf(
-        "host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-        cfg.PGConn.Host, cfg.PGConn.Port, cfg.PGConn.User, cfg.PGConn.Password, cfg.PGConn.DbName,
-    )
-
-    application := app.New(log, cfg.GRPC.Port, psqlInfo, cfg.TokenTTL)
+    application := app.New(log, cfg.GRPC, cfg.Storage, cfg.PGConn, cfg.TokenSecret, cfg.TokenTTL, cfg.HTTP, cfg.SAML, cfg.Outbox, cfg.Webhooks, cfg.Email, cfg.Registration, cfg.Phone, cfg.Profile, cfg.Privacy, cfg.PII, cfg.Organizations, cfg.Authz)
 
     go application.GRPCSrc.MustRun()
+
+    if application.HTTPSrv != nil {
+        go application.HTTPSrv.MustRun()
+    }
+
+    go application.Outbox.Run()
     
     stop := make(chan os.Signal, 1)
     signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
@@ -39,7 +39,19 @@ func main() {
 
     log.Info("stopping applicaition", slog.String("signal", sign.String()))
 
+    application.Events.Close()
+
     application.GRPCSrc.Stop()
+
+    if application.HTTPSrv != nil {
+        application.HTTPSrv.Stop()
+    }
+
+    application.Outbox.Stop()
+
+    if err := application.Storage.Close(); err != nil {
+        log.Error("failed to close storage", slog.String("err", err.Error()))
+    }
     
     log.Info("applicaiton stopped")
 }
@@ -1,34 +1,408 @@
 package app
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	grpcapp "grpc-service-ref/internal/app/grpc"
+	httpapp "grpc-service-ref/internal/app/http"
+	outboxapp "grpc-service-ref/internal/app/outbox"
+	"grpc-service-ref/internal/config"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/lib/broker"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/lib/fieldcrypt"
+	"grpc-service-ref/internal/lib/sms"
+	"grpc-service-ref/internal/services/admin"
 	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/services/authz"
+	"grpc-service-ref/internal/services/group"
+	"grpc-service-ref/internal/services/invite"
+	"grpc-service-ref/internal/services/organization"
+	"grpc-service-ref/internal/services/outbox"
+	"grpc-service-ref/internal/services/phone"
+	"grpc-service-ref/internal/services/pii"
+	"grpc-service-ref/internal/services/privacy"
+	"grpc-service-ref/internal/services/profile"
+	"grpc-service-ref/internal/services/saml"
+	"grpc-service-ref/internal/services/webhooks"
+	"grpc-service-ref/internal/storage/memory"
 	"grpc-service-ref/internal/storage/postgres"
+	"grpc-service-ref/internal/storage/sqlite"
+	"io"
 	"log/slog"
+	"net/url"
+	"os"
 	"time"
+
+	"github.com/crewjam/saml/samlsp"
+)
+
+// Storage drivers selectable by the storage.driver config value.
+const (
+    DriverPostgres = "postgres"
+    DriverSQLite   = "sqlite"
+    DriverMemory   = "memory"
 )
 
+// Storage is implemented by every storage backend.
+type Storage interface {
+    auth.UserSaver
+    auth.UserProvider
+    auth.SessionStore
+    auth.APIKeyStore
+    auth.ServiceAccountStore
+    auth.StatusStore
+    auth.OrgStore
+    auth.InviteStore
+    auth.GroupStore
+    admin.UserStore
+    admin.AuditLog
+    saml.UserSaver
+    saml.IdentityStore
+    phone.CodeStore
+    phone.UserStore
+    profile.UserStore
+    organization.Store
+    invite.Store
+    group.Store
+    authz.Store
+    privacy.Store
+    privacy.WorkerStore
+    audit.Store
+    outbox.Store
+    webhooks.Store
+    webhooks.SenderStore
+    io.Closer
+}
+
 type App struct {
     GRPCSrc *grpcapp.App
+    // HTTPSrv is nil when no HTTP endpoints are enabled.
+    HTTPSrv *httpapp.App
+    Outbox  *outboxapp.App
+    // Events fans recorded audit events out to WatchAuthEvents streams.
+    // Closing it ends the streams, which a graceful stop of the gRPC server waits for.
+    Events *broker.Broker
+    // Storage is closed last, once nothing uses it anymore.
+    Storage Storage
 }
 
 func New(
     log *slog.Logger,
-    grpcPort int,
-    connectionString string,
+    grpcCfg config.GRPCConfig,
+    storageCfg config.StorageConfig,
+    pgCfg config.PGConn,
+    tokenSecret string,
     tokenTTL time.Duration,
+    httpCfg config.HTTPConfig,
+    samlCfg config.SAMLConfig,
+    outboxCfg config.OutboxConfig,
+    webhooksCfg config.WebhooksConfig,
+    emailCfg config.EmailConfig,
+    registrationCfg config.RegistrationConfig,
+    phoneCfg config.PhoneConfig,
+    profileCfg config.ProfileConfig,
+    privacyCfg config.PrivacyConfig,
+    piiCfg config.PIIConfig,
+    orgsCfg config.OrganizationsConfig,
+    authzCfg config.AuthzConfig,
 ) *App {
-    //storage, err := sqlite.New(storagePath)
-    storage, err := postgres.New(connectionString)
+    keyring := mustLoadKeyring(piiCfg)
+
+    storage, err := openStorage(log, storageCfg, pgCfg, keyring, piiCfg.Decrypt)
     if err != nil {
         panic(err)
     }
 
-    authService := auth.New(log, storage, storage, tokenTTL)
+    events := broker.New(grpcCfg.WatchBuffer)
+
+    auditor := audit.New(log, storage, events)
+
+    emails := emailaddr.Normalizer{ProviderRules: emailCfg.ProviderRules}
+
+    registration := auth.RegistrationOptions{
+        Mode:            auth.RegistrationMode(registrationCfg.Mode),
+        AllowedDomains:  registrationCfg.AllowedDomains,
+        RequireEmail:    registrationCfg.RequireEmail,
+        RequireUsername: registrationCfg.RequireUsername,
+        RequirePhone:    registrationCfg.RequirePhone,
+    }
+    if !registration.Mode.Valid() {
+        panic("invalid registration.mode: " + registrationCfg.Mode)
+    }
+
+    if _, err := profile.ParsePaths(profileCfg.TokenClaims); err != nil {
+        panic("invalid profile.token_claims: " + err.Error())
+    }
+
+    authService := auth.New(log, storage, storage, storage, storage, storage, storage, storage, storage, storage, auditor, emails, registration, profileCfg.TokenClaims, []byte(tokenSecret), tokenTTL)
+
+    phoneService := phone.New(log, phoneOptions(log, phoneCfg), storage, storage, mustOpenSMS(log, phoneCfg.SMS), authService, auditor)
+
+    adminService := admin.New(log, storage, storage, storage, auditor)
+
+    webhooksService := webhooks.New(log, storage, storage)
+
+    profileService := profile.New(log, profile.Options{
+        MaxNamespaceSize: profileCfg.MaxNamespaceSize,
+    }, storage, storage, auditor)
+
+    privacyService := privacy.New(log, storage)
+
+    inviteService := invite.New(log, invite.Options{
+        CodeTTL: registrationCfg.InviteCodeTTL,
+        Emails:  emails,
+    }, storage, auditor)
+
+    orgService := organization.New(log, organization.Options{
+        InvitationTTL: orgsCfg.InvitationTTL,
+        Emails:        emails,
+    }, storage, auditor)
+
+    groupService := group.New(log, storage, auditor)
+
+    authzService := authz.New(log, mustLoadAuthzSchema(authzCfg), storage)
+
+    grpcApp := grpcapp.New(log, authService, phoneService, authService, adminService, privacyService, inviteService, webhooksService, profileService, orgService, groupService, authzService, events, grpcCfg.Port)
+
+    var httpApp *httpapp.App
+    if samlCfg.Enabled {
+        samlOpts := mustLoadSAMLOptions(samlCfg)
+        samlOpts.Emails = emails
+
+        samlService := saml.New(log, samlOpts, storage, storage, storage, authService, authService)
+
+        httpApp = httpapp.New(log, samlService, httpCfg.Port, httpCfg.Timeout)
+    }
+
+    sender := webhooks.NewSender(log, storage, webhooks.SenderOptions{
+        PollInterval: webhooksCfg.PollInterval,
+        BatchSize:    webhooksCfg.BatchSize,
+        Timeout:      webhooksCfg.Timeout,
+        MaxAttempts:  webhooksCfg.MaxAttempts,
+        MinBackoff:   webhooksCfg.MinBackoff,
+        MaxBackoff:   webhooksCfg.MaxBackoff,
+        DisableAfter: webhooksCfg.DisableAfter,
+    })
+
+    sinks := []outbox.Sink{sender}
+    if outboxCfg.LogSink {
+        sinks = append(sinks, outbox.NewLogSink(log))
+    }
+    if outboxCfg.Webhook.URL != "" {
+        sinks = append(sinks, outbox.NewWebhookSink(outboxCfg.Webhook.URL, outboxCfg.Webhook.Timeout))
+    }
+
+    relay := outbox.New(log, storage, outbox.Options{
+        PollInterval: outboxCfg.PollInterval,
+        BatchSize:    outboxCfg.BatchSize,
+        MaxAttempts:  outboxCfg.MaxAttempts,
+        MinBackoff:   outboxCfg.MinBackoff,
+        MaxBackoff:   outboxCfg.MaxBackoff,
+    }, sinks...)
+
+    dataJobs := privacy.NewWorker(log, storage, storage, auditor, privacy.WorkerOptions{
+        PollInterval: privacyCfg.PollInterval,
+        BatchSize:    privacyCfg.BatchSize,
+        MaxAttempts:  privacyCfg.MaxAttempts,
+        MinBackoff:   privacyCfg.MinBackoff,
+        MaxBackoff:   privacyCfg.MaxBackoff,
+        ArchiveTTL:   privacyCfg.ArchiveTTL,
+    })
+
+    workers := []outboxapp.Worker{relay, sender, dataJobs}
+    if keyring != nil {
+        // openStorage accepts a keyring for the postgres driver only, which re-encrypts.
+        workers = append(workers, pii.NewReencrypter(log, storage.(pii.Store), pii.Options{
+            PollInterval: piiCfg.PollInterval,
+            BatchSize:    piiCfg.BatchSize,
+        }))
+    }
 
-    grpcApp := grpcapp.New(log, authService, grpcPort)
-    
     return &App{
         GRPCSrc: grpcApp,
+        HTTPSrv: httpApp,
+        Outbox:  outboxapp.New(log, workers...),
+        Events:  events,
+        Storage: storage,
+    }
+}
+
+// openStorage opens the storage backend of the configured driver.
+// Personal data is encrypted with the keyring, if any, which only the postgres driver supports.
+func openStorage(
+    log *slog.Logger,
+    cfg config.StorageConfig,
+    pgCfg config.PGConn,
+    keyring *fieldcrypt.Keyring,
+    decryptPII bool,
+) (Storage, error) {
+    if keyring != nil && cfg.Driver != DriverPostgres {
+        return nil, fmt.Errorf("pii encryption is not supported by the %s storage driver", cfg.Driver)
+    }
+
+    switch cfg.Driver {
+    case DriverPostgres:
+        return postgres.New(pgCfg.ConnectionString(), postgres.Options{
+            MaxConns:          pgCfg.MaxConns,
+            MinConns:          pgCfg.MinConns,
+            MaxConnLifetime:   pgCfg.MaxConnLifetime,
+            MaxConnIdleTime:   pgCfg.MaxConnIdleTime,
+            ConnectAttempts:   pgCfg.ConnectAttempts,
+            ConnectRetryDelay: pgCfg.ConnectRetryDelay,
+            Tracer:            postgres.NewTracer(log, pgCfg.SlowQuery, nil),
+
+            Replicas:             pgCfg.Replicas,
+            ReplicaCheckInterval: pgCfg.ReplicaCheckInterval,
+            ReadYourWritesWindow: pgCfg.ReadYourWritesWindow,
+
+            Keyring:    keyring,
+            DecryptPII: decryptPII,
+        })
+    case DriverSQLite:
+        return sqlite.New(cfg.Path)
+    case DriverMemory:
+        return memory.New(), nil
+    default:
+        return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+    }
+}
+
+// mustLoadKeyring returns the keyring encrypting personal data, or nil if no master keys are set.
+func mustLoadKeyring(cfg config.PIIConfig) *fieldcrypt.Keyring {
+    if len(cfg.MasterKeys) == 0 {
+        return nil
+    }
+
+    masters := make(map[int][]byte, len(cfg.MasterKeys))
+    for version, encoded := range cfg.MasterKeys {
+        key, err := fieldcrypt.ParseKey(encoded)
+        if err != nil {
+            panic(fmt.Sprintf("invalid pii.master_keys %d: %s", version, err))
+        }
+        masters[version] = key
+    }
+
+    indexKey, err := fieldcrypt.ParseKey(cfg.IndexKey)
+    if err != nil {
+        panic("invalid pii.index_key: " + err.Error())
+    }
+
+    keyring, err := fieldcrypt.NewKeyring(masters, cfg.MasterKeyVersion, indexKey)
+    if err != nil {
+        panic("invalid pii keys: " + err.Error())
+    }
+
+    return keyring
+}
+
+func phoneOptions(log *slog.Logger, cfg config.PhoneConfig) phone.Options {
+    hashKey := []byte(cfg.HashKey)
+    if len(hashKey) == 0 {
+        log.Warn("phone.hash_key is not set, codes are hashed with a random key valid until restart")
+
+        hashKey = make([]byte, 32)
+        if _, err := rand.Read(hashKey); err != nil {
+            panic("failed to generate phone code hash key: " + err.Error())
+        }
+    }
+
+    return phone.Options{
+        HashKey:        hashKey,
+        CodeLength:     cfg.CodeLength,
+        CodeTTL:        cfg.CodeTTL,
+        MaxAttempts:    cfg.MaxAttempts,
+        ResendCooldown: cfg.ResendCooldown,
+        MaxPerWindow:   cfg.MaxPerWindow,
+        Window:         cfg.Window,
+        Message:        cfg.Message,
+    }
+}
+
+// mustOpenSMS returns the SMS provider of the config.
+func mustOpenSMS(log *slog.Logger, cfg config.SMSConfig) sms.Provider {
+    switch cfg.Provider {
+    case "log":
+        return sms.NewLogProvider(log)
+    case "file":
+        return sms.NewFileProvider(cfg.Path)
+    case "http":
+        if cfg.URL == "" {
+            panic("phone.sms.url is required for the http provider")
+        }
+        return sms.NewHTTPProvider(sms.HTTPOptions{
+            URL:     cfg.URL,
+            Token:   cfg.Token,
+            From:    cfg.From,
+            Timeout: cfg.Timeout,
+        })
+    default:
+        panic(fmt.Sprintf("unknown sms provider %q", cfg.Provider))
+    }
+}
+
+// mustLoadAuthzSchema parses the authorization schema, an empty one when no schema is configured.
+func mustLoadAuthzSchema(cfg config.AuthzConfig) *authz.Schema {
+    var src []byte
+    if cfg.SchemaPath != "" {
+        var err error
+        if src, err = os.ReadFile(cfg.SchemaPath); err != nil {
+            panic("failed to read authz schema: " + err.Error())
+        }
+    }
+
+    schema, err := authz.ParseSchema(string(src))
+    if err != nil {
+        panic("invalid authz schema: " + err.Error())
+    }
+
+    return schema
+}
+
+func mustLoadSAMLOptions(cfg config.SAMLConfig) saml.Options {
+    rootURL, err := url.Parse(cfg.RootURL)
+    if err != nil {
+        panic("invalid saml root url: " + err.Error())
+    }
+
+    keyPair, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+    if err != nil {
+        panic("failed to load saml key pair: " + err.Error())
+    }
+
+    key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+    if !ok {
+        panic("saml key must be an RSA private key")
+    }
+
+    cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+    if err != nil {
+        panic("failed to parse saml certificate: " + err.Error())
+    }
+
+    raw, err := os.ReadFile(cfg.IDPMetadataPath)
+    if err != nil {
+        panic("failed to read idp metadata: " + err.Error())
+    }
+
+    idpMetadata, err := samlsp.ParseMetadata(raw)
+    if err != nil {
+        panic("failed to parse idp metadata: " + err.Error())
+    }
+
+    return saml.Options{
+        EntityID:          cfg.EntityID,
+        RootURL:           rootURL,
+        Key:               key,
+        Certificate:       cert,
+        IDPMetadata:       idpMetadata,
+        AllowIDPInitiated: cfg.AllowIDPInitiated,
+        AllowCreate:       cfg.AllowCreate,
+        Mapping: saml.AttributeMapping{
+            Email: cfg.EmailAttribute,
+        },
     }
 }
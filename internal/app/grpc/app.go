@@ -2,7 +2,16 @@ package grpcapp
 
 import (
 	"fmt"
+	admingrpc "grpc-service-ref/internal/grpc/admin"
 	authgrpc "grpc-service-ref/internal/grpc/auth"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/grpc/consistency"
+	groupsgrpc "grpc-service-ref/internal/grpc/groups"
+	organizationsgrpc "grpc-service-ref/internal/grpc/organizations"
+	permissionsgrpc "grpc-service-ref/internal/grpc/permissions"
+	profilesgrpc "grpc-service-ref/internal/grpc/profiles"
+	serviceaccountsgrpc "grpc-service-ref/internal/grpc/serviceaccounts"
+	webhooksgrpc "grpc-service-ref/internal/grpc/webhooks"
 	"log/slog"
 	"net"
 
@@ -19,11 +28,39 @@ type App struct {
 func New(
     log *slog.Logger,
     authService authgrpc.Auth,
+    phoneService authgrpc.Phone,
+    serviceAccounts serviceaccountsgrpc.ServiceAccounts,
+    adminService admingrpc.Admin,
+    privacyService admingrpc.Privacy,
+    inviteService admingrpc.Invites,
+    webhooksService webhooksgrpc.Webhooks,
+    profilesService profilesgrpc.Profiles,
+    organizationsService organizationsgrpc.Organizations,
+    groupsService groupsgrpc.Groups,
+    permissionsService permissionsgrpc.Permissions,
+    events authgrpc.Events,
     port int,
 ) *App {
-    gRPCServer := grpc.NewServer()
+    policy := authn.Merge(authgrpc.Policy, serviceaccountsgrpc.Policy, admingrpc.Policy, webhooksgrpc.Policy, profilesgrpc.Policy, organizationsgrpc.Policy, groupsgrpc.Policy, permissionsgrpc.Policy)
 
-    authgrpc.Register(gRPCServer, authService)
+    gRPCServer := grpc.NewServer(
+        grpc.ChainUnaryInterceptor(
+            consistency.UnaryServerInterceptor(),
+            authn.UnaryServerInterceptor(authService, policy),
+        ),
+        grpc.ChainStreamInterceptor(
+            authn.StreamServerInterceptor(authService, policy),
+        ),
+    )
+
+    authgrpc.Register(gRPCServer, authService, phoneService, events)
+    permissionsgrpc.Register(gRPCServer, permissionsService)
+    serviceaccountsgrpc.Register(gRPCServer, serviceAccounts)
+    admingrpc.Register(gRPCServer, adminService, privacyService, inviteService)
+    webhooksgrpc.Register(gRPCServer, webhooksService)
+    profilesgrpc.Register(gRPCServer, profilesService)
+    organizationsgrpc.Register(gRPCServer, organizationsService)
+    groupsgrpc.Register(gRPCServer, groupsService)
 
     return &App{
         log:        log,
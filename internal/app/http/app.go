@@ -0,0 +1,90 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	samlhttp "grpc-service-ref/internal/http/saml"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+type App struct {
+    log        *slog.Logger
+    httpServer *http.Server
+    port       int
+    timeout    time.Duration
+}
+
+// New creates new HTTP server app.
+// SAML endpoints are registered only when samlService is not nil.
+func New(
+    log *slog.Logger,
+    samlService samlhttp.SAML,
+    port int,
+    timeout time.Duration,
+) *App {
+    mux := http.NewServeMux()
+
+    if samlService != nil {
+        samlhttp.Register(mux, samlService)
+    }
+
+    return &App{
+        log: log,
+        httpServer: &http.Server{
+            Addr:              fmt.Sprintf(":%d", port),
+            Handler:           mux,
+            ReadHeaderTimeout: timeout,
+            ReadTimeout:       timeout,
+            WriteTimeout:      timeout,
+        },
+        port:    port,
+        timeout: timeout,
+    }
+}
+
+// MustRun runs HTTP server and panics if any errors occurs.
+func (a *App) MustRun() {
+    if err := a.Run(); err != nil {
+        panic(err)
+    }
+}
+
+func (a *App) Run() error {
+    const op = "httpapp.Run"
+
+    log := a.log.With(
+        slog.String("op", op),
+        slog.Int("port", a.port),
+    )
+
+    l, err := net.Listen("tcp", a.httpServer.Addr)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    log.Info("http server is running", slog.String("addr", l.Addr().String()))
+
+    if err := a.httpServer.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// Stop stops HTTP server waiting for in-flight requests up to the request timeout.
+func (a *App) Stop() {
+    const op = "httpapp.Stop"
+
+    a.log.With(slog.String("op", op)).Info("stopping HTTP server", slog.Int("port", a.port))
+
+    ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+    defer cancel()
+
+    if err := a.httpServer.Shutdown(ctx); err != nil {
+        a.log.Error("failed to stop HTTP server", slog.String("err", err.Error()))
+    }
+}
@@ -0,0 +1,60 @@
+package outboxapp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Worker runs until its context is canceled.
+type Worker interface {
+    Run(ctx context.Context)
+}
+
+type App struct {
+    log     *slog.Logger
+    workers []Worker
+    ctx     context.Context
+    cancel  context.CancelFunc
+    wg      sync.WaitGroup
+}
+
+// New creates new app running the outbox relay, the workers delivering what it publishes
+// and other background workers, such as the data job worker.
+func New(log *slog.Logger, workers ...Worker) *App {
+    ctx, cancel := context.WithCancel(context.Background())
+
+    a := &App{
+        log:     log,
+        workers: workers,
+        ctx:     ctx,
+        cancel:  cancel,
+    }
+    // Stop waits for the workers even if it is called while Run is starting them.
+    a.wg.Add(len(workers))
+
+    return a
+}
+
+// Run runs the workers until the app is stopped.
+func (a *App) Run() {
+    for _, w := range a.workers {
+        go func() {
+            defer a.wg.Done()
+            w.Run(a.ctx)
+        }()
+    }
+
+    a.wg.Wait()
+}
+
+// Stop stops the workers and waits for the batches in flight to finish.
+// Events of an interrupted batch are delivered again after a restart.
+func (a *App) Stop() {
+    const op = "outboxapp.Stop"
+
+    a.log.With(slog.String("op", op)).Info("stopping outbox workers")
+
+    a.cancel()
+    a.wg.Wait()
+}
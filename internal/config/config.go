@@ -2,21 +2,201 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"time"
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-    Env         string        `yaml:"env" env-default:"local"`
-    TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-    PGConn      PGConn        `yaml:"postgres_connection" env-required:"./data"`
-    GRPC        GRPCConfig    `yaml:"grpc"`
+    Env           string              `yaml:"env" env-default:"local"`
+    TokenTTL      time.Duration       `yaml:"token_ttl" env-required:"true"`
+    // TokenSecret is the HMAC key signing issued tokens. Changing it invalidates them.
+    TokenSecret   string              `yaml:"token_secret" env:"TOKEN_SECRET" env-required:"true"`
+    Storage       StorageConfig       `yaml:"storage"`
+    PGConn        PGConn              `yaml:"postgres_connection" env-required:"./data"`
+    GRPC          GRPCConfig          `yaml:"grpc"`
+    HTTP          HTTPConfig          `yaml:"http"`
+    SAML          SAMLConfig          `yaml:"saml"`
+    Outbox        OutboxConfig        `yaml:"outbox"`
+    Webhooks      WebhooksConfig      `yaml:"webhooks"`
+    Email         EmailConfig         `yaml:"email"`
+    Registration  RegistrationConfig  `yaml:"registration"`
+    Phone         PhoneConfig         `yaml:"phone"`
+    Profile       ProfileConfig       `yaml:"profile"`
+    Privacy       PrivacyConfig       `yaml:"privacy"`
+    PII           PIIConfig           `yaml:"pii"`
+    Organizations OrganizationsConfig `yaml:"organizations"`
+    Authz         AuthzConfig         `yaml:"authz"`
+}
+
+// StorageConfig selects the storage backend.
+// The memory driver keeps data in the process, for tests and local development.
+// The sqlite driver keeps it in the database file at Path, for single-node and edge deployments.
+type StorageConfig struct {
+    Driver string `yaml:"driver" env-default:"postgres"`
+    Path   string `yaml:"path" env-default:"./sso.db"`
 }
 
 type GRPCConfig struct {
-    Port    int           `yaml:"port"` 
-    Timeout time.Duration `yaml:"timeout"`
+    Port        int           `yaml:"port"` 
+    Timeout     time.Duration `yaml:"timeout"`
+    // WatchBuffer is the number of events buffered for every WatchAuthEvents stream.
+    // Streams that fall further behind are disconnected.
+    WatchBuffer int           `yaml:"watch_buffer" env-default:"256"`
+}
+
+type HTTPConfig struct {
+    Port    int           `yaml:"port" env-default:"8080"`
+    Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// SAMLConfig describes this service as a SAML 2.0 service provider.
+// Endpoints are served over HTTP under RootURL: /saml/metadata, /saml/login and /saml/acs.
+type SAMLConfig struct {
+    Enabled           bool   `yaml:"enabled"`
+    EntityID          string `yaml:"entity_id"`
+    RootURL           string `yaml:"root_url"`
+    CertPath          string `yaml:"cert_path"`
+    KeyPath           string `yaml:"key_path"`
+    IDPMetadataPath   string `yaml:"idp_metadata_path"`
+    AllowIDPInitiated bool   `yaml:"allow_idp_initiated"`
+    // AllowCreate registers users that have no account yet on their first SAML login,
+    // when the registration mode lets them register without an invite code.
+    AllowCreate       bool   `yaml:"allow_create"`
+    EmailAttribute    string `yaml:"email_attribute" env-default:"email"`
+}
+
+// OutboxConfig configures delivery of domain events written to the outbox.
+// Events go to the log when LogSink is set and to the webhook when its URL is set.
+type OutboxConfig struct {
+    PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+    BatchSize    int           `yaml:"batch_size" env-default:"100"`
+    MaxAttempts  int           `yaml:"max_attempts" env-default:"10"`
+    MinBackoff   time.Duration `yaml:"min_backoff" env-default:"1s"`
+    MaxBackoff   time.Duration `yaml:"max_backoff" env-default:"10m"`
+    LogSink      bool          `yaml:"log_sink" env-default:"true"`
+    Webhook      WebhookConfig `yaml:"webhook"`
+}
+
+type WebhookConfig struct {
+    URL     string        `yaml:"url"`
+    Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// WebhooksConfig configures delivery of app webhooks.
+type WebhooksConfig struct {
+    PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+    BatchSize    int           `yaml:"batch_size" env-default:"100"`
+    Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+    MaxAttempts  int           `yaml:"max_attempts" env-default:"8"`
+    MinBackoff   time.Duration `yaml:"min_backoff" env-default:"30s"`
+    MaxBackoff   time.Duration `yaml:"max_backoff" env-default:"1h"`
+    // DisableAfter failed attempts in a row disable a subscription.
+    DisableAfter int           `yaml:"disable_after" env-default:"20"`
+}
+
+// EmailConfig configures normalization of email addresses.
+type EmailConfig struct {
+    // ProviderRules normalizes aliases of mailboxes at well-known providers to the mailbox,
+    // e.g. b.o.b+news@googlemail.com to bob@gmail.com. Switching it changes the normalized
+    // form of existing accounts, which are not rewritten: their users still log in with the
+    // address as stored, and the address as stored can't be registered again.
+    ProviderRules bool `yaml:"provider_rules"`
+}
+
+// RegistrationConfig selects who may register and the identifiers new users must register with.
+// Users always need at least one of an email, a username and a phone number.
+type RegistrationConfig struct {
+    // Mode is one of open, invite_only, domain_allowlist and closed.
+    // Invite codes let users register in invite_only mode and outside the allowed domains.
+    Mode            string        `yaml:"mode" env-default:"open"`
+    // AllowedDomains are the email domains users register with in domain_allowlist mode.
+    AllowedDomains  []string      `yaml:"allowed_domains"`
+    // InviteCodeTTL is how long invite codes created without an expiry can be registered with.
+    InviteCodeTTL   time.Duration `yaml:"invite_code_ttl" env-default:"168h"`
+    RequireEmail    bool          `yaml:"require_email" env-default:"true"`
+    RequireUsername bool          `yaml:"require_username"`
+    RequirePhone    bool          `yaml:"require_phone"`
+}
+
+// PhoneConfig configures the codes sent by SMS to verify phone numbers and to log in with them.
+type PhoneConfig struct {
+    // HashKey keys the hashes of stored codes. A random key is used when it is empty,
+    // which voids codes sent before a restart and works only with a single instance.
+    HashKey        string        `yaml:"hash_key"`
+    CodeLength     int           `yaml:"code_length" env-default:"6"`
+    CodeTTL        time.Duration `yaml:"code_ttl" env-default:"5m"`
+    MaxAttempts    int           `yaml:"max_attempts" env-default:"5"`
+    ResendCooldown time.Duration `yaml:"resend_cooldown" env-default:"1m"`
+    // MaxPerWindow codes are sent to a number within the window, whoever asks for them.
+    MaxPerWindow   int           `yaml:"max_per_window" env-default:"5"`
+    Window         time.Duration `yaml:"window" env-default:"1h"`
+    Message        string        `yaml:"message" env-default:"Your verification code is %s"`
+    SMS            SMSConfig     `yaml:"sms"`
+}
+
+// SMSConfig selects the SMS provider: log writes messages to the log, file appends them
+// to the file at Path and http posts them to the gateway at URL.
+type SMSConfig struct {
+    Provider string        `yaml:"provider" env-default:"log"`
+    Path     string        `yaml:"path" env-default:"./sms.log"`
+    URL      string        `yaml:"url"`
+    Token    string        `yaml:"token"`
+    From     string        `yaml:"from"`
+    Timeout  time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// ProfileConfig configures user profiles.
+type ProfileConfig struct {
+    // MaxNamespaceSize is the size of the JSON encoded attributes an app keeps for a user, in bytes.
+    MaxNamespaceSize int      `yaml:"max_namespace_size" env-default:"16384"`
+    // TokenClaims are the field mask paths of the profile fields projected into the tokens of users.
+    // Attributes are projected only into tokens of logins to the app owning their namespace.
+    TokenClaims      []string `yaml:"token_claims"`
+}
+
+// PrivacyConfig configures the background jobs exporting and erasing the data of users.
+type PrivacyConfig struct {
+    PollInterval time.Duration `yaml:"poll_interval" env-default:"5s"`
+    BatchSize    int           `yaml:"batch_size" env-default:"10"`
+    MaxAttempts  int           `yaml:"max_attempts" env-default:"5"`
+    MinBackoff   time.Duration `yaml:"min_backoff" env-default:"1m"`
+    MaxBackoff   time.Duration `yaml:"max_backoff" env-default:"1h"`
+    // ArchiveTTL is how long the archive of an export can be downloaded.
+    ArchiveTTL   time.Duration `yaml:"archive_ttl" env-default:"168h"`
+}
+
+// PIIConfig configures encryption of emails and phone numbers at rest, those of users as well as
+// those of phone codes and invitations, which the postgres driver supports. Encryption is on when master keys are set.
+type PIIConfig struct {
+    // MasterKeys are base64 encoded 32-byte keys by version, which wrap the data keys.
+    // A retired version is kept until the re-encrypter has moved its data to the current one.
+    MasterKeys       map[int]string `yaml:"master_keys"`
+    MasterKeyVersion int            `yaml:"master_key_version"`
+    // IndexKey is the base64 encoded 32-byte key of the blind indexes encrypted values are looked up by.
+    // It can't be changed once data is encrypted.
+    IndexKey         string         `yaml:"index_key"`
+    // Decrypt turns encryption off: new data is written in plaintext and encrypted data is decrypted.
+    Decrypt          bool           `yaml:"decrypt"`
+    // PollInterval is how often the re-encrypter looks for data not encrypted with the current key.
+    PollInterval     time.Duration  `yaml:"poll_interval" env-default:"1m"`
+    BatchSize        int            `yaml:"batch_size" env-default:"100"`
+}
+
+// OrganizationsConfig configures organizations.
+type OrganizationsConfig struct {
+    // InvitationTTL is how long invitations to an organization can be accepted.
+    InvitationTTL time.Duration `yaml:"invitation_ttl" env-default:"168h"`
+}
+
+// AuthzConfig configures the fine-grained permissions checked from relationships between objects.
+type AuthzConfig struct {
+    // SchemaPath is the file defining the types of objects, their relations and permissions.
+    // Without a schema no relationships can be written.
+    SchemaPath string `yaml:"schema_path"`
 }
 
 type PGConn struct {
@@ -25,6 +205,116 @@ type PGConn struct {
     User     string `yaml:"user"`
     Password string `yaml:"password"`
     DbName   string `yaml:"dbname"`
+
+    MaxConns          int           `yaml:"max_conns" env-default:"25"`
+    MinConns          int           `yaml:"min_conns" env-default:"2"`
+    MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" env-default:"30m"`
+    MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time" env-default:"5m"`
+    // ConnectAttempts is the number of pings at startup before the database is considered unreachable.
+    ConnectAttempts   int           `yaml:"connect_attempts" env-default:"5"`
+    // ConnectRetryDelay is the wait after the first failed ping, doubled after every next one.
+    ConnectRetryDelay time.Duration `yaml:"connect_retry_delay" env-default:"1s"`
+    // SlowQuery is the duration above which queries are logged as slow.
+    SlowQuery         time.Duration `yaml:"slow_query" env-default:"500ms"`
+
+    // Replicas are connection strings of read replicas serving user lookups.
+    // Replicas that fail health checks are skipped and reads fall back to the primary.
+    Replicas             []string      `yaml:"replicas"`
+    ReplicaCheckInterval time.Duration `yaml:"replica_check_interval" env-default:"5s"`
+    // ReadYourWritesWindow is how long reads of a written user go to the primary, covering the replication lag.
+    ReadYourWritesWindow time.Duration `yaml:"read_your_writes_window" env-default:"5s"`
+}
+
+// ConnectionString returns the connection string of the database.
+func (c PGConn) ConnectionString() string {
+    return fmt.Sprintf(
+        "host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+        c.Host, c.Port, c.User, c.Password, c.DbName,
+    )
+}
+
+// redacted replaces secrets in logged configs.
+const redacted = "[REDACTED]"
+
+// secretValue logs whether a secret is set without its value.
+func secretValue(secret string) slog.Value {
+    if secret == "" {
+        return slog.StringValue("")
+    }
+    return slog.StringValue(redacted)
+}
+
+// LogValue logs the config with its secrets redacted.
+func (c Config) LogValue() slog.Value {
+    return slog.GroupValue(
+        slog.String("env", c.Env),
+        slog.Duration("token_ttl", c.TokenTTL),
+        slog.Attr{Key: "token_secret", Value: secretValue(c.TokenSecret)},
+        slog.Any("storage", c.Storage),
+        slog.Any("postgres_connection", c.PGConn),
+        slog.Any("grpc", c.GRPC),
+        slog.Any("http", c.HTTP),
+        slog.Any("saml", c.SAML),
+        slog.Any("outbox", c.Outbox),
+        slog.Any("webhooks", c.Webhooks),
+        slog.Any("email", c.Email),
+        slog.Any("registration", c.Registration),
+        slog.Any("phone", c.Phone),
+        slog.Any("profile", c.Profile),
+        slog.Any("privacy", c.Privacy),
+        slog.Any("pii", c.PII),
+        slog.Any("organizations", c.Organizations),
+        slog.Any("authz", c.Authz),
+    )
+}
+
+// LogValue logs the connection without the password; replicas are counted, their
+// connection strings carry passwords too.
+func (c PGConn) LogValue() slog.Value {
+    return slog.GroupValue(
+        slog.String("host", c.Host),
+        slog.Int("port", c.Port),
+        slog.String("user", c.User),
+        slog.Attr{Key: "password", Value: secretValue(c.Password)},
+        slog.String("dbname", c.DbName),
+        slog.Int("max_conns", c.MaxConns),
+        slog.Int("min_conns", c.MinConns),
+        slog.Int("replicas", len(c.Replicas)),
+    )
+}
+
+// LogValue logs the phone config without the hash key and the SMS gateway token.
+func (c PhoneConfig) LogValue() slog.Value {
+    return slog.GroupValue(
+        slog.Attr{Key: "hash_key", Value: secretValue(c.HashKey)},
+        slog.Int("code_length", c.CodeLength),
+        slog.Duration("code_ttl", c.CodeTTL),
+        slog.Int("max_attempts", c.MaxAttempts),
+        slog.Duration("resend_cooldown", c.ResendCooldown),
+        slog.Int("max_per_window", c.MaxPerWindow),
+        slog.Duration("window", c.Window),
+        slog.Group("sms",
+            slog.String("provider", c.SMS.Provider),
+            slog.String("url", c.SMS.URL),
+            slog.Attr{Key: "token", Value: secretValue(c.SMS.Token)},
+        ),
+    )
+}
+
+// LogValue logs the key versions of the PII config, not the keys.
+func (c PIIConfig) LogValue() slog.Value {
+    versions := make([]int, 0, len(c.MasterKeys))
+    for version := range c.MasterKeys {
+        versions = append(versions, version)
+    }
+    slices.Sort(versions)
+
+    return slog.GroupValue(
+        slog.Any("master_key_versions", versions),
+        slog.Int("master_key_version", c.MasterKeyVersion),
+        slog.Attr{Key: "index_key", Value: secretValue(c.IndexKey)},
+        slog.Bool("decrypt", c.Decrypt),
+    )
 }
 
 func MustLoad() *Config {
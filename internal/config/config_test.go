@@ -0,0 +1,47 @@
+package config_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"grpc-service-ref/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_LogValueRedactsSecrets(t *testing.T) {
+    cfg := &config.Config{
+        TokenSecret: "token-secret",
+        PGConn: config.PGConn{
+            Host:     "db",
+            Password: "pg-password",
+            Replicas: []string{"host=replica password=replica-password"},
+        },
+        Phone: config.PhoneConfig{
+            HashKey: "hash-key",
+            SMS:     config.SMSConfig{Provider: "http", Token: "sms-token"},
+        },
+        PII: config.PIIConfig{
+            MasterKeys: map[int]string{1: "master-key"},
+            IndexKey:   "index-key",
+        },
+    }
+
+    for name, handler := range map[string]func(*bytes.Buffer) slog.Handler{
+        "Text": func(buf *bytes.Buffer) slog.Handler { return slog.NewTextHandler(buf, nil) },
+        "JSON": func(buf *bytes.Buffer) slog.Handler { return slog.NewJSONHandler(buf, nil) },
+    } {
+        t.Run(name, func(t *testing.T) {
+            var buf bytes.Buffer
+            slog.New(handler(&buf)).Info("starting application", slog.Any("cfg", cfg))
+
+            out := buf.String()
+            assert.Contains(t, out, "db")
+            assert.Contains(t, out, "http")
+            for _, secret := range []string{"token-secret", "pg-password", "replica-password", "hash-key", "sms-token", "master-key", "index-key"} {
+                assert.NotContains(t, out, secret)
+            }
+        })
+    }
+}
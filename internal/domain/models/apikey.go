@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// APIKeyPrefix starts every API key so they can be told apart from tokens and recognized in leaks.
+const APIKeyPrefix = "sso_"
+
+// Scopes limit the RPCs an API key may call. Tokens issued by Login are not limited.
+const (
+    ScopeSessionsRead  = "sessions:read"
+    ScopeSessionsWrite = "sessions:write"
+    ScopeAPIKeysRead   = "api_keys:read"
+    ScopeAPIKeysWrite  = "api_keys:write"
+
+    ScopeServiceAccountsRead  = "service_accounts:read"
+    ScopeServiceAccountsWrite = "service_accounts:write"
+
+    ScopeUsersRead  = "users:read"
+    ScopeUsersWrite = "users:write"
+    ScopeAuditRead  = "audit:read"
+
+    ScopeWebhooksRead  = "webhooks:read"
+    ScopeWebhooksWrite = "webhooks:write"
+
+    ScopeProfileRead  = "profile:read"
+    ScopeProfileWrite = "profile:write"
+
+    ScopeOrgsRead  = "orgs:read"
+    ScopeOrgsWrite = "orgs:write"
+
+    ScopeGroupsRead  = "groups:read"
+    ScopeGroupsWrite = "groups:write"
+
+    ScopeRelationshipsRead  = "relationships:read"
+    ScopeRelationshipsWrite = "relationships:write"
+)
+
+// Scopes lists every scope an API key can be given.
+var Scopes = []string{
+    ScopeSessionsRead,
+    ScopeSessionsWrite,
+    ScopeAPIKeysRead,
+    ScopeAPIKeysWrite,
+    ScopeServiceAccountsRead,
+    ScopeServiceAccountsWrite,
+    ScopeUsersRead,
+    ScopeUsersWrite,
+    ScopeAuditRead,
+    ScopeWebhooksRead,
+    ScopeWebhooksWrite,
+    ScopeProfileRead,
+    ScopeProfileWrite,
+    ScopeOrgsRead,
+    ScopeOrgsWrite,
+    ScopeGroupsRead,
+    ScopeGroupsWrite,
+    ScopeRelationshipsRead,
+    ScopeRelationshipsWrite,
+}
+
+// APIKey is a long-lived credential of a user or a service account. Only the hash of the key is stored.
+type APIKey struct {
+    ID         int64
+    Principal  Principal // owner of the key, without roles
+    Name       string
+    Prefix     string
+    Hash       []byte
+    Scopes     []string
+    ExpiresAt  time.Time // zero if the key never expires
+    LastUsedAt time.Time // zero if the key has never been used
+    CreatedAt  time.Time
+}
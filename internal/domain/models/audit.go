@@ -0,0 +1,160 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"maps"
+	"strings"
+	"time"
+)
+
+// Types of audit events.
+const (
+    EventUserRegistered      = "user.registered"
+    EventLoginSucceeded      = "login.succeeded"
+    EventLoginFailed         = "login.failed"
+    EventSessionRevoked      = "session.revoked"
+    EventSessionsRevoked     = "sessions.revoked"
+    EventPasswordReset       = "user.password_reset"
+    EventUserRolesChanged    = "user.roles_changed"
+    EventUserStatusChanged   = "user.status_changed"
+    EventAPIKeyCreated       = "api_key.created"
+    EventAPIKeyRevoked       = "api_key.revoked"
+    EventServiceAccountLogin = "service_account.login"
+    EventPhoneCodeSent       = "phone.code_sent"
+    EventPhoneVerified       = "user.phone_verified"
+    EventProfileUpdated      = "user.profile_updated"
+    EventUserDataExported    = "user.data_exported"
+    EventUserErased          = "user.erased"
+
+    EventServiceAccountCreated       = "service_account.created"
+    EventServiceAccountRolesChanged  = "service_account.roles_changed"
+    EventServiceAccountSecretRotated = "service_account.secret_rotated"
+    EventServiceAccountDisabled      = "service_account.disabled"
+
+    EventOrgCreated            = "org.created"
+    EventOrgMemberAdded        = "org.member_added"
+    EventOrgMemberRolesChanged = "org.member_roles_changed"
+    EventOrgMemberRemoved      = "org.member_removed"
+    EventOrgInvitationCreated  = "org.invitation_created"
+    EventOrgInvitationRevoked  = "org.invitation_revoked"
+
+    EventInviteCodeCreated = "invite_code.created"
+    EventInviteCodeRevoked = "invite_code.revoked"
+
+    EventGroupCreated       = "group.created"
+    EventGroupDeleted       = "group.deleted"
+    EventGroupRolesChanged  = "group.roles_changed"
+    EventGroupMemberAdded   = "group.member_added"
+    EventGroupMemberRemoved = "group.member_removed"
+)
+
+// DetailOrg is the detail key of the id of the organization an event happened in, if any.
+const DetailOrg = "org_id"
+
+// DetailApp is the detail key of the app an event happened in, if known.
+const DetailApp = "app"
+
+// Fields of audit events holding personal data, the keys of AuditEvent.Sealed.
+// Personal details are keyed by SealedDetailPrefix and the detail key.
+const (
+    SealedIP           = "ip"
+    SealedUserAgent    = "user_agent"
+    SealedDetailPrefix = "details."
+)
+
+// PersonalDetails are the detail keys holding identifiers of users.
+var PersonalDetails = []string{"email", "username", "phone"}
+
+// Outcomes of audit events.
+const (
+    OutcomeSuccess = "success"
+    OutcomeFailure = "failure"
+)
+
+// AuditEvent is an entry of the audit log. Entries form a hash chain:
+// the hash of every entry covers its fields and the hash of the previous entry.
+type AuditEvent struct {
+    ID        int64
+    Type      string
+    Actor     string // principal that caused the event, formatted by Principal.String, if known
+    Subject   string // principal the event is about, if known
+    IP        string
+    UserAgent string
+    Outcome   string
+    Reason    string // why an event failed
+    Details   map[string]string
+    // Sealed holds the personal data of the event as stored: its fields encrypted with keys that
+    // erasing the user they belong to destroys. Sealed fields are empty once their key is destroyed.
+    Sealed    map[string]string
+    CreatedAt time.Time
+    PrevHash  []byte
+    Hash      []byte
+}
+
+// App returns the app the event happened in, or an empty string if it is not known.
+func (e AuditEvent) App() string {
+    return e.Details[DetailApp]
+}
+
+// ComputeHash returns the hash of the event chained to the previous hash.
+// CreatedAt is hashed with microsecond precision, the precision it is stored with.
+// Sealed fields are hashed in their sealed form only, so the hash does not change
+// when they can no longer be opened.
+func (e AuditEvent) ComputeHash() []byte {
+    details := maps.Clone(e.Details)
+    if details == nil {
+        details = map[string]string{}
+    }
+    ip, userAgent := e.IP, e.UserAgent
+    for field := range e.Sealed {
+        switch field {
+        case SealedIP:
+            ip = ""
+        case SealedUserAgent:
+            userAgent = ""
+        default:
+            delete(details, strings.TrimPrefix(field, SealedDetailPrefix))
+        }
+    }
+
+    // Map keys are encoded sorted, so the encoding is canonical.
+    payload, _ := json.Marshal(struct {
+        Type      string            `json:"type"`
+        Actor     string            `json:"actor"`
+        Subject   string            `json:"subject"`
+        IP        string            `json:"ip"`
+        UserAgent string            `json:"user_agent"`
+        Outcome   string            `json:"outcome"`
+        Reason    string            `json:"reason"`
+        Details   map[string]string `json:"details"`
+        Sealed    map[string]string `json:"sealed,omitempty"`
+        CreatedAt string            `json:"created_at"`
+    }{
+        Type:      e.Type,
+        Actor:     e.Actor,
+        Subject:   e.Subject,
+        IP:        ip,
+        UserAgent: userAgent,
+        Outcome:   e.Outcome,
+        Reason:    e.Reason,
+        Details:   details,
+        Sealed:    e.Sealed,
+        CreatedAt: e.CreatedAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+    })
+
+    h := sha256.New()
+    h.Write(e.PrevHash)
+    h.Write(payload)
+
+    return h.Sum(nil)
+}
+
+// AuditFilter selects audit events. Zero fields match every event.
+type AuditFilter struct {
+    Type    string
+    Actor   string
+    Subject string
+    Since   time.Time
+    Until   time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+// Caller is the authenticated identity an RPC is made by.
+type Caller struct {
+    Principal Principal
+    SessionID string   // set for tokens issued by Login
+    APIKeyID  int64    // set for API keys
+    Scopes    []string // nil unless the caller uses an API key
+}
+
+// HasScope reports whether the caller may use the scope.
+// Callers that do not use an API key have every scope.
+func (c Caller) HasScope(scope string) bool {
+    if c.APIKeyID == 0 {
+        return true
+    }
+
+    for _, s := range c.Scopes {
+        if s == scope {
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// DataJobKind is what a data job does with the data of a user.
+type DataJobKind string
+
+const (
+    // DataJobExport jobs collect the personal data of a user into an archive.
+    DataJobExport DataJobKind = "export"
+    // DataJobErase jobs erase the personal data of a user.
+    DataJobErase DataJobKind = "erase"
+)
+
+// DataJobStatus is the progress of a data job.
+type DataJobStatus string
+
+const (
+    DataJobPending   DataJobStatus = "pending"
+    DataJobRunning   DataJobStatus = "running"
+    DataJobSucceeded DataJobStatus = "succeeded"
+    // DataJobFailed jobs ran out of attempts and are no longer retried.
+    DataJobFailed DataJobStatus = "failed"
+)
+
+// DataJob is a data-subject request, exporting or erasing the data of a user, run in the background.
+type DataJob struct {
+    ID     int64
+    Kind   DataJobKind
+    UserID int64
+    Status DataJobStatus
+    // Actor is the principal that requested the job, formatted by Principal.String.
+    Actor         string
+    Reason        string
+    Attempts      int
+    NextAttemptAt time.Time
+    LastError     string
+    // Archive is the JSON archive of a succeeded export, nil once it expires
+    // or the user is erased.
+    Archive    []byte
+    CreatedAt  time.Time
+    StartedAt  time.Time // zero until the job is first claimed
+    FinishedAt time.Time // zero until the job succeeds or fails
+    ExpiresAt  time.Time // when the archive of an export is removed, zero for other jobs
+}
+
+// Finished reports whether the job is no longer run.
+func (j DataJob) Finished() bool {
+    return j.Status == DataJobSucceeded || j.Status == DataJobFailed
+}
+
+// UserData is the personal data stored about a user, outside of the audit log.
+type UserData struct {
+    User        User
+    Identities  []Identity
+    Sessions    []UserSession // including revoked and expired ones
+    APIKeys     []UserAPIKey  // including revoked ones
+    Transitions []StatusTransition
+    Memberships []UserOrganization
+}
+
+// UserSession is a session along with when it was revoked, zero if it was not.
+type UserSession struct {
+    Session
+    RevokedAt time.Time
+}
+
+// UserAPIKey is an API key along with when it was revoked, zero if it was not.
+type UserAPIKey struct {
+    APIKey
+    RevokedAt time.Time
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Group gathers users and other groups, its members, and grants its roles to all of them,
+// members of nested groups included. Groups are global: the roles they grant are global roles.
+type Group struct {
+    ID          int64
+    Name        string // unique
+    Description string
+    Roles       []string
+    CreatedAt   time.Time
+}
+
+// GroupMembers are the direct members of a group, ordered by id.
+type GroupMembers struct {
+    UserIDs  []int64
+    GroupIDs []int64 // nested groups, whose members are members of the group as well
+}
+
+// UserGroup is a group a user is a member of, directly or through nested groups.
+type UserGroup struct {
+    Group Group
+    // Path lists the ids of the groups that make the user a member, starting with the group
+    // the user is a direct member of and ending with Group. It is one of the shortest paths.
+    Path []int64
+}
+
+// Direct reports whether the user is a direct member of the group.
+func (g UserGroup) Direct() bool {
+    return len(g.Path) == 1
+}
+
+// RoleGrant explains how a user got a role: directly, with no Path, or through the groups in Path,
+// from the group the user is a direct member of to the group granting the role.
+type RoleGrant struct {
+    Role string
+    Path []Group
+}
+
+// Access is the effective access of a user: its own roles along with the roles of all its groups,
+// and the permissions they grant.
+type Access struct {
+    Roles       []string // sorted
+    Permissions []string // sorted
+    Groups      []UserGroup
+    Grants      []RoleGrant // direct grants first, then grants through groups by group id
+}
+
+// ResolveAccess flattens the groups of the user into its effective access.
+func ResolveAccess(roles []string, groups []UserGroup) Access {
+    byID := make(map[int64]Group, len(groups))
+    for _, g := range groups {
+        byID[g.Group.ID] = g.Group
+    }
+
+    access := Access{Groups: groups}
+    for _, role := range roles {
+        access.Grants = append(access.Grants, RoleGrant{Role: role})
+    }
+    for _, g := range groups {
+        path := make([]Group, 0, len(g.Path))
+        for _, id := range g.Path {
+            path = append(path, byID[id])
+        }
+        for _, role := range g.Group.Roles {
+            access.Grants = append(access.Grants, RoleGrant{Role: role, Path: path})
+        }
+    }
+
+    for _, grant := range access.Grants {
+        access.Roles = append(access.Roles, grant.Role)
+        access.Permissions = append(access.Permissions, RolePermissions[grant.Role]...)
+    }
+    slices.Sort(access.Roles)
+    access.Roles = slices.Compact(access.Roles)
+    slices.Sort(access.Permissions)
+    access.Permissions = slices.Compact(access.Permissions)
+
+    return access
+}
+
+// GroupNames returns the names of the groups, sorted.
+func (a Access) GroupNames() []string {
+    names := make([]string, 0, len(a.Groups))
+    for _, g := range a.Groups {
+        names = append(names, g.Group.Name)
+    }
+    slices.Sort(names)
+
+    return names
+}
+
+// PermissionGrants returns the grants of the roles that grant the permission, nil if none does.
+func (a Access) PermissionGrants(permission string) []RoleGrant {
+    var grants []RoleGrant
+    for _, grant := range a.Grants {
+        if slices.Contains(RolePermissions[grant.Role], permission) {
+            grants = append(grants, grant)
+        }
+    }
+
+    return grants
+}
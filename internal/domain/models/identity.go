@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Identity is an account at an external identity provider linked to a user.
+type Identity struct {
+    ID        int64
+    UserID    int64
+    Provider  string
+    Subject   string
+    CreatedAt time.Time
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+// InviteCode lets its holder register while registration is restricted. The user registered
+// with it gets its roles and, when OrgID is set, becomes a member of the organization with
+// its org roles. Codes are single-use; only their hash is stored.
+type InviteCode struct {
+    ID        int64
+    Hash      []byte
+    Email     string // the only email the code registers, any when empty
+    Roles     []string
+    OrgID     int64 // zero for none
+    OrgRoles  []string
+    Creator   string // principal that created the code, formatted by Principal.String
+    CreatedAt time.Time
+    ExpiresAt time.Time
+    UsedAt    time.Time // zero until a user registers with the code
+    UsedBy    int64     // user registered with the code, zero until it is used
+    RevokedAt time.Time // zero unless the code is revoked
+}
+
+// Pending reports whether the code can still be registered with.
+func (c InviteCode) Pending(now time.Time) bool {
+    return c.UsedAt.IsZero() && c.RevokedAt.IsZero() && c.ExpiresAt.After(now)
+}
+
+// HashInviteCode returns the hash invite codes are stored and looked up by.
+// Codes carry 192 random bits, so a fast unsalted hash is enough.
+func HashInviteCode(code string) []byte {
+    sum := sha256.Sum256([]byte(code))
+    return sum[:]
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Organization is a tenant: a customer whose users are its members.
+// Users may be members of several organizations and log in to one of them at a time.
+type Organization struct {
+    ID        int64
+    Slug      string // unique name users log in to the organization with
+    Name      string
+    CreatedAt time.Time
+}
+
+// Roles with built-in permissions within an organization. Members may carry other roles,
+// they are passed to clients in tokens.
+const (
+    OrgRoleAdmin  = "admin"
+    OrgRoleMember = "member"
+)
+
+// Permissions within an organization. Members get them through the roles of their membership.
+const (
+    PermissionMembersRead  = "members:read"
+    PermissionMembersWrite = "members:write"
+)
+
+// OrgRolePermissions lists the permissions granted by each built-in organization role.
+// They apply within the organization only: admins of an organization read the users that are its members.
+var OrgRolePermissions = map[string][]string{
+    OrgRoleAdmin:  {PermissionMembersRead, PermissionMembersWrite, PermissionUsersRead},
+    OrgRoleMember: {PermissionMembersRead},
+}
+
+// Membership makes a user a member of an organization.
+type Membership struct {
+    OrgID     int64
+    UserID    int64
+    Roles     []string
+    CreatedAt time.Time
+}
+
+// HasPermission reports whether any role of the membership grants the permission.
+func (m Membership) HasPermission(permission string) bool {
+    for _, role := range m.Roles {
+        if slices.Contains(OrgRolePermissions[role], permission) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// UserOrganization is an organization along with the membership of a user.
+type UserOrganization struct {
+    Organization Organization
+    Roles        []string
+    JoinedAt     time.Time
+}
+
+// OrgInvitation invites the owner of an email to become a member of an organization
+// with the roles. Only the hash of the invitation token is stored.
+type OrgInvitation struct {
+    ID         int64
+    OrgID      int64
+    Email      string
+    Roles      []string
+    Hash       []byte
+    Inviter    string // principal that created the invitation, formatted by Principal.String
+    CreatedAt  time.Time
+    ExpiresAt  time.Time
+    AcceptedAt time.Time // zero until the invitation is accepted
+    AcceptedBy int64     // user that accepted the invitation, zero until it is accepted
+    RevokedAt  time.Time // zero unless the invitation is revoked
+}
+
+// Pending reports whether the invitation can still be accepted.
+func (i OrgInvitation) Pending(now time.Time) bool {
+    return i.AcceptedAt.IsZero() && i.RevokedAt.IsZero() && i.ExpiresAt.After(now)
+}
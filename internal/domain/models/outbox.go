@@ -0,0 +1,95 @@
+package models
+
+import "time"
+
+// Types of domain events published to other services.
+const (
+    DomainEventUserRegistered    = "user.registered"
+    DomainEventPasswordChanged   = "user.password_changed"
+    DomainEventUserStatusChanged = "user.status_changed"
+    DomainEventUserRolesChanged  = "user.roles_changed"
+    DomainEventPhoneVerified     = "user.phone_verified"
+    DomainEventProfileUpdated    = "user.profile_updated"
+    DomainEventUserErased        = "user.erased"
+)
+
+// DomainEvents lists the types of domain events.
+var DomainEvents = []string{
+    DomainEventUserRegistered,
+    DomainEventPasswordChanged,
+    DomainEventUserStatusChanged,
+    DomainEventUserRolesChanged,
+    DomainEventPhoneVerified,
+    DomainEventProfileUpdated,
+    DomainEventUserErased,
+}
+
+// OutboxStatus is the delivery state of an outbox event.
+type OutboxStatus string
+
+const (
+    OutboxPending   OutboxStatus = "pending"
+    OutboxDelivered OutboxStatus = "delivered"
+    // OutboxDead events ran out of delivery attempts and are no longer retried.
+    OutboxDead OutboxStatus = "dead"
+)
+
+// OutboxEvent is a domain event written in the same transaction as the change it describes
+// and delivered to sinks by the relay afterwards, at least once.
+type OutboxEvent struct {
+    ID            int64
+    Type          string
+    AggregateID   string // principal the event is about, formatted by Principal.String
+    Payload       []byte // JSON encoded event
+    Status        OutboxStatus
+    Attempts      int
+    NextAttemptAt time.Time
+    LastError     string
+    CreatedAt     time.Time
+    DeliveredAt   time.Time
+}
+
+// UserRegistered is the payload of user.registered events.
+// Payloads carry no personal data, as they outlive it in the outbox and webhook deliveries;
+// consumers read the user to get its identifiers.
+type UserRegistered struct {
+    UserID int64 `json:"user_id"`
+}
+
+// PasswordChanged is the payload of user.password_changed events.
+type PasswordChanged struct {
+    UserID int64 `json:"user_id"`
+}
+
+// UserStatusChanged is the payload of user.status_changed events.
+type UserStatusChanged struct {
+    UserID int64      `json:"user_id"`
+    From   UserStatus `json:"from"`
+    To     UserStatus `json:"to"`
+    Reason string     `json:"reason,omitempty"`
+}
+
+// UserRolesChanged is the payload of user.roles_changed events.
+type UserRolesChanged struct {
+    UserID int64    `json:"user_id"`
+    Roles  []string `json:"roles"`
+}
+
+// PhoneVerified is the payload of user.phone_verified events.
+// Consumers read the user to get the phone number.
+type PhoneVerified struct {
+    UserID int64 `json:"user_id"`
+}
+
+// ProfileUpdated is the payload of user.profile_updated events.
+// Consumers read the profile to get the new values of the fields.
+type ProfileUpdated struct {
+    UserID int64    `json:"user_id"`
+    Fields []string `json:"fields"` // field mask paths of the updated fields
+}
+
+// UserErased is the payload of user.erased events. Consumers erase what they keep about the user.
+// Payloads of earlier events about the user are reduced to the user id.
+type UserErased struct {
+    UserID int64 `json:"user_id"`
+}
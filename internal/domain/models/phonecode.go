@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// PhoneCodePurpose tells what a one-time code sent to a phone number is for.
+type PhoneCodePurpose string
+
+const (
+    // PhoneCodeVerify codes prove a user owns the phone number they add.
+    PhoneCodeVerify PhoneCodePurpose = "verify"
+    // PhoneCodeLogin codes log the user with the phone number in.
+    PhoneCodeLogin PhoneCodePurpose = "login"
+)
+
+// PhoneCode is a one-time code sent to a phone number by SMS. Only the hash of the code is stored.
+type PhoneCode struct {
+    ID         int64
+    Phone      string
+    Purpose    PhoneCodePurpose
+    UserID     int64 // user the code was sent for
+    Hash       []byte
+    Attempts   int // wrong and right guesses of the code so far
+    CreatedAt  time.Time
+    ExpiresAt  time.Time
+    ConsumedAt time.Time // zero until the code is used
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PrincipalType tells apart the kinds of identities that can be authenticated.
+type PrincipalType string
+
+const (
+    PrincipalUser           PrincipalType = "user"
+    PrincipalServiceAccount PrincipalType = "service_account"
+)
+
+// Roles with built-in permissions. Principals may carry other roles, they are passed to clients in tokens.
+const (
+    RoleAdmin   = "admin"
+    RoleSupport = "support"
+)
+
+// Permissions gate admin RPCs. Principals get them through their roles.
+const (
+    PermissionUsersRead          = "users:read"
+    PermissionUsersWrite         = "users:write"
+    PermissionSessionsWrite      = "sessions:write"
+    PermissionAuditRead          = "audit:read"
+    PermissionOrgsRead           = "orgs:read"
+    PermissionOrgsWrite          = "orgs:write"
+    PermissionGroupsRead         = "groups:read"
+    PermissionGroupsWrite        = "groups:write"
+    PermissionRelationshipsRead  = "relationships:read"
+    PermissionRelationshipsWrite = "relationships:write"
+)
+
+// RolePermissions lists the permissions granted by each built-in role.
+var RolePermissions = map[string][]string{
+    RoleAdmin: {
+        PermissionUsersRead,
+        PermissionUsersWrite,
+        PermissionSessionsWrite,
+        PermissionAuditRead,
+        PermissionOrgsRead,
+        PermissionOrgsWrite,
+        PermissionGroupsRead,
+        PermissionGroupsWrite,
+        PermissionRelationshipsRead,
+        PermissionRelationshipsWrite,
+    },
+    RoleSupport: {
+        PermissionUsersRead,
+        PermissionSessionsWrite,
+        PermissionOrgsRead,
+        PermissionGroupsRead,
+        PermissionRelationshipsRead,
+    },
+}
+
+// Principal is an authenticated identity, a user or a service account.
+// Tokens and authorization treat both the same way.
+type Principal struct {
+    Type  PrincipalType
+    ID    int64
+    Roles []string
+    // OrgID is the organization a user logged in to, zero outside of any. Principals acting
+    // in an organization have the roles of their membership in OrgRoles and no global roles.
+    OrgID    int64
+    OrgRoles []string
+    // Groups are the names of the groups a user is a member of, directly or not.
+    // Roles include the roles the groups grant. Principals acting in an organization have no groups.
+    Groups []string
+}
+
+// UserPrincipal returns the principal of the user.
+func UserPrincipal(user User) Principal {
+    return Principal{Type: PrincipalUser, ID: user.ID, Roles: user.Roles}
+}
+
+// IsUser reports whether the principal is a user.
+func (p Principal) IsUser() bool {
+    return p.Type == PrincipalUser
+}
+
+// HasRole reports whether the principal has the role.
+func (p Principal) HasRole(role string) bool {
+    return slices.Contains(p.Roles, role)
+}
+
+// HasPermission reports whether any role of the principal grants the permission.
+// Within an organization the permissions are granted by the organization roles.
+func (p Principal) HasPermission(permission string) bool {
+    if p.OrgID != 0 {
+        return Membership{Roles: p.OrgRoles}.HasPermission(permission)
+    }
+
+    for _, role := range p.Roles {
+        if slices.Contains(RolePermissions[role], permission) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// String formats the principal as "type:id", the form used in the sub claim of tokens.
+func (p Principal) String() string {
+    return string(p.Type) + ":" + strconv.FormatInt(p.ID, 10)
+}
+
+// ParsePrincipal parses a principal formatted by Principal.String. Roles and the organization are not set.
+func ParsePrincipal(s string) (Principal, error) {
+    typ, rawID, ok := strings.Cut(s, ":")
+    if !ok {
+        return Principal{}, fmt.Errorf("invalid principal %q", s)
+    }
+
+    switch PrincipalType(typ) {
+    case PrincipalUser, PrincipalServiceAccount:
+    default:
+        return Principal{}, fmt.Errorf("unknown principal type %q", typ)
+    }
+
+    id, err := strconv.ParseInt(rawID, 10, 64)
+    if err != nil || id <= 0 {
+        return Principal{}, fmt.Errorf("invalid principal id %q", rawID)
+    }
+
+    return Principal{Type: PrincipalType(typ), ID: id}, nil
+}
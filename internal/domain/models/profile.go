@@ -0,0 +1,163 @@
+package models
+
+import "maps"
+
+// Standard profile fields, named as in field masks.
+const (
+    ProfileDisplayName = "display_name"
+    ProfileGivenName   = "given_name"
+    ProfileFamilyName  = "family_name"
+    ProfileLocale      = "locale"
+    ProfileTimezone    = "timezone"
+    ProfileAvatarURL   = "avatar_url"
+)
+
+// ProfileFields lists the standard profile fields.
+var ProfileFields = []string{
+    ProfileDisplayName,
+    ProfileGivenName,
+    ProfileFamilyName,
+    ProfileLocale,
+    ProfileTimezone,
+    ProfileAvatarURL,
+}
+
+// ProfileAttributes is the field mask path of the custom attributes.
+const ProfileAttributes = "attributes"
+
+// Profile is the profile data of a user, shared by every app.
+type Profile struct {
+    DisplayName string
+    GivenName   string
+    FamilyName  string
+    Locale      string // BCP 47 language tag
+    Timezone    string // IANA time zone
+    AvatarURL   string
+    Attributes  Attributes
+}
+
+// Attributes are custom attributes of a user by namespace. Namespaces are named after the apps
+// that own them, their values are decoded JSON values.
+type Attributes map[string]map[string]any
+
+// Clone returns a copy of the attributes with copies of the namespaces.
+// Values are not copied, they are treated as immutable.
+func (a Attributes) Clone() Attributes {
+    if a == nil {
+        return nil
+    }
+
+    clone := make(Attributes, len(a))
+    for namespace, attrs := range a {
+        clone[namespace] = maps.Clone(attrs)
+    }
+
+    return clone
+}
+
+// Field returns the value of the standard field, or an empty string for unknown fields.
+func (p Profile) Field(name string) string {
+    switch name {
+    case ProfileDisplayName:
+        return p.DisplayName
+    case ProfileGivenName:
+        return p.GivenName
+    case ProfileFamilyName:
+        return p.FamilyName
+    case ProfileLocale:
+        return p.Locale
+    case ProfileTimezone:
+        return p.Timezone
+    case ProfileAvatarURL:
+        return p.AvatarURL
+    default:
+        return ""
+    }
+}
+
+// SetField sets the standard field. Unknown fields are ignored.
+func (p *Profile) SetField(name, value string) {
+    switch name {
+    case ProfileDisplayName:
+        p.DisplayName = value
+    case ProfileGivenName:
+        p.GivenName = value
+    case ProfileFamilyName:
+        p.FamilyName = value
+    case ProfileLocale:
+        p.Locale = value
+    case ProfileTimezone:
+        p.Timezone = value
+    case ProfileAvatarURL:
+        p.AvatarURL = value
+    }
+}
+
+// AttributePath names a namespace of attributes or, when Key is set, an attribute in it.
+type AttributePath struct {
+    Namespace string
+    Key       string
+}
+
+// String formats the path as a field mask path.
+func (p AttributePath) String() string {
+    if p.Key == "" {
+        return ProfileAttributes + "." + p.Namespace
+    }
+    return ProfileAttributes + "." + p.Namespace + "." + p.Key
+}
+
+// ProfileUpdate sets the named parts of a profile to their values in Profile.
+type ProfileUpdate struct {
+    Fields []string // standard fields
+    // Attributes are set to their values in Profile, or removed when Profile has none.
+    Attributes []AttributePath
+    Profile    Profile
+}
+
+// Paths returns the field mask paths of the update.
+func (u ProfileUpdate) Paths() []string {
+    paths := make([]string, 0, len(u.Fields)+len(u.Attributes))
+    paths = append(paths, u.Fields...)
+    for _, path := range u.Attributes {
+        paths = append(paths, path.String())
+    }
+
+    return paths
+}
+
+// Apply returns the profile with the update applied. Namespaces left empty are removed.
+func (p Profile) Apply(u ProfileUpdate) Profile {
+    for _, field := range u.Fields {
+        p.SetField(field, u.Profile.Field(field))
+    }
+
+    if len(u.Attributes) == 0 {
+        return p
+    }
+
+    attrs := p.Attributes.Clone()
+    if attrs == nil {
+        attrs = Attributes{}
+    }
+
+    for _, path := range u.Attributes {
+        if path.Key == "" {
+            attrs[path.Namespace] = maps.Clone(u.Profile.Attributes[path.Namespace])
+        } else if value, ok := u.Profile.Attributes[path.Namespace][path.Key]; ok {
+            if attrs[path.Namespace] == nil {
+                attrs[path.Namespace] = map[string]any{}
+            }
+            attrs[path.Namespace][path.Key] = value
+        } else {
+            delete(attrs[path.Namespace], path.Key)
+        }
+
+        if len(attrs[path.Namespace]) == 0 {
+            delete(attrs, path.Namespace)
+        }
+    }
+    p.Attributes = attrs
+
+    return p
+}
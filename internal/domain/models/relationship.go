@@ -0,0 +1,82 @@
+package models
+
+// ObjectRef names an object of a type of the relationship schema, e.g. document:readme.
+type ObjectRef struct {
+    Type string
+    ID   string
+}
+
+// String formats the object as "type:id".
+func (o ObjectRef) String() string {
+    return o.Type + ":" + o.ID
+}
+
+// SubjectRef is the subject of a relationship: the object itself or, with a Relation,
+// every subject having the relation on the object, e.g. group:eng#member.
+type SubjectRef struct {
+    Object   ObjectRef
+    Relation string
+}
+
+// String formats the subject as "type:id" or "type:id#relation".
+func (s SubjectRef) String() string {
+    if s.Relation == "" {
+        return s.Object.String()
+    }
+    return s.Object.String() + "#" + s.Relation
+}
+
+// Relationship gives the subject the relation on the resource, e.g. document:readme#editor@user:1.
+type Relationship struct {
+    Resource ObjectRef
+    Relation string
+    Subject  SubjectRef
+}
+
+// String formats the relationship as "resource#relation@subject".
+func (r Relationship) String() string {
+    return r.Resource.String() + "#" + r.Relation + "@" + r.Subject.String()
+}
+
+// RelationshipOperation tells how a RelationshipUpdate changes the relationship.
+type RelationshipOperation string
+
+const (
+    // RelationshipTouch creates the relationship unless it exists.
+    RelationshipTouch RelationshipOperation = "touch"
+    // RelationshipDelete deletes the relationship if it exists.
+    RelationshipDelete RelationshipOperation = "delete"
+)
+
+type RelationshipUpdate struct {
+    Operation    RelationshipOperation
+    Relationship Relationship
+}
+
+// RelationshipFilter selects relationships. Empty fields match any value,
+// a Subject matches the subject exactly, its relation included.
+type RelationshipFilter struct {
+    ResourceType string
+    ResourceID   string
+    Relation     string
+    Subject      *SubjectRef
+}
+
+// Matches reports whether the relationship is selected by the filter.
+func (f RelationshipFilter) Matches(r Relationship) bool {
+    return (f.ResourceType == "" || f.ResourceType == r.Resource.Type) &&
+        (f.ResourceID == "" || f.ResourceID == r.Resource.ID) &&
+        (f.Relation == "" || f.Relation == r.Relation) &&
+        (f.Subject == nil || *f.Subject == r.Subject)
+}
+
+// PermissionTree is the tree of the subjects having a relation or a permission on a resource.
+// Relations list their subjects, usersets among them unexpanded. Permissions have a child per
+// operand, arrows a child per object they follow; their subjects are the union of those of the children.
+type PermissionTree struct {
+    Resource ObjectRef
+    // Relation is the relation, the permission or the "relation->permission" arrow of the node.
+    Relation string
+    Subjects []SubjectRef
+    Children []PermissionTree
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ServiceAccount is a non-human principal. It has no password and authenticates
+// with API keys or with its client credentials.
+type ServiceAccount struct {
+    ID         int64
+    Name       string
+    Owner      Owner
+    ClientID   string
+    SecretHash []byte
+    Roles      []string
+    CreatedAt  time.Time
+    DisabledAt time.Time // zero if the account is enabled
+}
+
+// Principal returns the principal of the service account.
+func (sa ServiceAccount) Principal() Principal {
+    return Principal{Type: PrincipalServiceAccount, ID: sa.ID, Roles: sa.Roles}
+}
+
+// OwnerKind tells apart the kinds of service account owners.
+type OwnerKind string
+
+const (
+    OwnerApp  OwnerKind = "app"
+    OwnerTeam OwnerKind = "team"
+)
+
+// Owner is the app or team responsible for a service account.
+type Owner struct {
+    Kind OwnerKind
+    Name string
+}
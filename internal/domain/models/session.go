@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Session is a login of a user from a single client.
+// Tokens reference their session with the sid claim.
+type Session struct {
+    ID         string
+    UserID     int64
+    UserAgent  string
+    IP         string
+    CreatedAt  time.Time
+    LastSeenAt time.Time
+    ExpiresAt  time.Time
+}
+
+// ClientInfo describes the client a login request came from.
+type ClientInfo struct {
+    UserAgent string
+    IP        string
+    App       string // app the client logs in to, if it tells
+    Org       string // slug of the organization the user logs in to, empty to log in outside of any
+}
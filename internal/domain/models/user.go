@@ -1,7 +1,99 @@
 package models
 
+import (
+	"slices"
+	"time"
+)
+
+// UserStatus tells whether a user may log in.
+type UserStatus string
+
+const (
+    // UserStatusPending users registered but are not verified yet.
+    UserStatusPending UserStatus = "pending"
+    UserStatusActive  UserStatus = "active"
+    // UserStatusSuspended users are banned, until SuspendedUntil if it is set.
+    UserStatusSuspended UserStatus = "suspended"
+    // UserStatusLocked users are locked out for security reasons until an admin unlocks them.
+    UserStatusLocked UserStatus = "locked"
+    // UserStatusDeleted users are soft-deleted. Their records are kept.
+    UserStatusDeleted UserStatus = "deleted"
+)
+
+// UserStatuses lists every status.
+var UserStatuses = []UserStatus{
+    UserStatusPending,
+    UserStatusActive,
+    UserStatusSuspended,
+    UserStatusLocked,
+    UserStatusDeleted,
+}
+
+// statusTransitions lists the statuses each status can change to.
+var statusTransitions = map[UserStatus][]UserStatus{
+    UserStatusPending:   {UserStatusActive, UserStatusDeleted},
+    UserStatusActive:    {UserStatusSuspended, UserStatusLocked, UserStatusDeleted},
+    UserStatusSuspended: {UserStatusActive, UserStatusSuspended, UserStatusDeleted},
+    UserStatusLocked:    {UserStatusActive, UserStatusDeleted},
+    UserStatusDeleted:   {UserStatusActive},
+}
+
+// CanTransition reports whether a user may change from one status to the other.
+// A suspension can be changed by suspending again, e.g. to extend it.
+func CanTransition(from, to UserStatus) bool {
+    return slices.Contains(statusTransitions[from], to)
+}
+
 type User struct {
-    ID       int64
+    ID              int64
+    Email           string    // empty if the user registered without one
+    Username        string    // empty if the user has none
+    Phone           string    // E.164 phone number, empty if the user has none
+    PhoneVerifiedAt time.Time // zero until the user proves to own the phone number
+    PassHash        []byte
+    Roles           []string
+    Status          UserStatus
+    StatusReason    string    // why the user has the status, empty for active users
+    SuspendedUntil  time.Time // zero unless the user is suspended for a limited time
+    Profile         Profile
+    CreatedAt       time.Time
+}
+
+// SuspensionExpired reports whether the user is suspended for a time that has passed.
+func (u User) SuspensionExpired(now time.Time) bool {
+    return u.Status == UserStatusSuspended && !u.SuspendedUntil.IsZero() && !u.SuspendedUntil.After(now)
+}
+
+// UserIdentifiers are the identifiers of a new user. Empty ones are not set.
+type UserIdentifiers struct {
     Email    string
-    PassHash []byte
+    Username string
+    Phone    string
+}
+
+// StatusTransition is a recorded status change of a user.
+type StatusTransition struct {
+    ID     int64
+    UserID int64
+    From   UserStatus
+    To     UserStatus
+    Reason string
+    Until  time.Time // end of a suspension, zero if there is none
+    // Actor is the principal that made the change, formatted by Principal.String, or ActorSystem.
+    Actor     string
+    CreatedAt time.Time
+}
+
+// ActorSystem made status changes the service applies by itself, such as the end of a suspension.
+const ActorSystem = "system"
+
+// UserFilter selects users in admin listings. Zero fields match every user.
+type UserFilter struct {
+    // EmailPrefix matches emails case-insensitively. Emails encrypted at rest can't be matched by
+    // prefix: they match whole addresses only, through their blind index, while those not encrypted
+    // yet still match by prefix.
+    EmailPrefix string
+    Status      UserStatus
+    Role        string
+    OrgID       int64 // members of the organization
 }
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// WebhookSubscription asks for domain events of the given types to be posted to an app endpoint.
+type WebhookSubscription struct {
+    ID         int64
+    App        string // name of the app the subscription belongs to
+    URL        string
+    EventTypes []string
+    // Secret signs the payloads. It is kept as is since every delivery needs it.
+    Secret string
+    // ConsecutiveFailures counts failed delivery attempts since the last successful one.
+    ConsecutiveFailures int
+    CreatedAt           time.Time
+    DisabledAt          time.Time // zero if the subscription is enabled
+}
+
+// Enabled tells whether events are delivered to the subscription.
+func (s WebhookSubscription) Enabled() bool {
+    return s.DisabledAt.IsZero()
+}
+
+// WebhookDeliveryStatus is the state of a webhook delivery.
+type WebhookDeliveryStatus string
+
+const (
+    WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+    WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+    // WebhookDeliveryFailed deliveries ran out of attempts, they can be replayed.
+    WebhookDeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is a domain event to be posted to a subscription.
+type WebhookDelivery struct {
+    ID             int64
+    SubscriptionID int64
+    EventID        int64 // id of the outbox event
+    EventType      string
+    Payload        []byte // JSON body of the requests
+    Status         WebhookDeliveryStatus
+    Attempts       int
+    NextAttemptAt  time.Time
+    LastStatusCode int // zero if no response was received
+    LastError      string
+    CreatedAt      time.Time
+    DeliveredAt    time.Time
+    ReplayOf       int64 // id of the replayed delivery, zero for the first delivery of the event
+}
+
+// WebhookAttempt is a single request of a webhook delivery.
+type WebhookAttempt struct {
+    ID          int64
+    DeliveryID  int64
+    StatusCode  int // zero if no response was received
+    Error       string
+    Duration    time.Duration
+    AttemptedAt time.Time
+}
+
+// Succeeded tells whether the endpoint accepted the request.
+func (a WebhookAttempt) Succeeded() bool {
+    return a.Error == "" && a.StatusCode >= 200 && a.StatusCode <= 299
+}
@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"context"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/auditpb"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *serverAPI) QueryAuditLog(
+    ctx context.Context,
+    req *ssov1.QueryAuditLogRequest,
+) (*ssov1.QueryAuditLogResponse, error) {
+    if req.GetPageSize() < 0 {
+        return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+    }
+
+    filter := models.AuditFilter{
+        Type:    req.GetType(),
+        Actor:   req.GetActor(),
+        Subject: req.GetSubject(),
+    }
+    if req.GetSince() != nil {
+        filter.Since = req.GetSince().AsTime()
+    }
+    if req.GetUntil() != nil {
+        filter.Until = req.GetUntil().AsTime()
+    }
+    if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Until.After(filter.Since) {
+        return nil, status.Error(codes.InvalidArgument, "until must be after since")
+    }
+
+    events, next, err := s.admin.AuditEvents(ctx, filter, req.GetPageToken(), int(req.GetPageSize()))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.QueryAuditLogResponse{
+        Events:        make([]*ssov1.AuditEvent, 0, len(events)),
+        NextPageToken: next,
+    }
+    for _, event := range events {
+        resp.Events = append(resp.Events, auditpb.FromEvent(event))
+    }
+
+    return resp, nil
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/invite"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Invites creates the codes users register with while registration is restricted.
+type Invites interface {
+    CreateInviteCode(ctx context.Context,
+        creator string,
+        opts invite.CodeOptions,
+    ) (code models.InviteCode, plain string, err error)
+    RevokeInviteCode(ctx context.Context, actor string, id int64) error
+}
+
+func (s *serverAPI) CreateInviteCode(
+    ctx context.Context,
+    req *ssov1.CreateInviteCodeRequest,
+) (*ssov1.CreateInviteCodeResponse, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    opts := invite.CodeOptions{
+        Email:    req.GetEmail(),
+        Roles:    req.GetRoles(),
+        OrgID:    req.GetOrganizationId(),
+        OrgRoles: req.GetOrganizationRoles(),
+    }
+    if req.GetExpiresAt() != nil {
+        opts.ExpiresAt = req.GetExpiresAt().AsTime()
+    }
+
+    code, plain, err := s.invites.CreateInviteCode(ctx, caller.Principal.String(), opts)
+    if err != nil {
+        return nil, toInviteStatus(err)
+    }
+
+    return &ssov1.CreateInviteCodeResponse{
+        InviteCode: &ssov1.InviteCode{
+            Id:                code.ID,
+            Email:             code.Email,
+            Roles:             code.Roles,
+            OrganizationId:    code.OrgID,
+            OrganizationRoles: code.OrgRoles,
+            CreatedAt:         timestamppb.New(code.CreatedAt),
+            ExpiresAt:         timestamppb.New(code.ExpiresAt),
+        },
+        Code: plain,
+    }, nil
+}
+
+func (s *serverAPI) RevokeInviteCode(
+    ctx context.Context,
+    req *ssov1.RevokeInviteCodeRequest,
+) (*ssov1.RevokeInviteCodeResponse, error) {
+    if req.GetId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "id is required")
+    }
+
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if err := s.invites.RevokeInviteCode(ctx, caller.Principal.String(), req.GetId()); err != nil {
+        return nil, toInviteStatus(err)
+    }
+
+    return &ssov1.RevokeInviteCodeResponse{}, nil
+}
+
+func toInviteStatus(err error) error {
+    switch {
+    case errors.Is(err, invite.ErrInvalidEmail):
+        return status.Error(codes.InvalidArgument, "invalid email")
+    case errors.Is(err, invite.ErrInvalidRole):
+        return status.Error(codes.InvalidArgument, "roles must not be empty and organization roles need an organization")
+    case errors.Is(err, invite.ErrInvalidExpiry):
+        return status.Error(codes.InvalidArgument, "expires_at must be in the future")
+    case errors.Is(err, invite.ErrOrgNotFound):
+        return status.Error(codes.NotFound, "organization not found")
+    case errors.Is(err, invite.ErrCodeNotFound):
+        return status.Error(codes.NotFound, "invite code not found or no longer pending")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/privacy"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Privacy runs data-subject requests as background jobs.
+type Privacy interface {
+    ExportUserData(ctx context.Context, actor string, userID int64) (models.DataJob, error)
+    EraseUser(ctx context.Context, actor string, userID int64, reason string) (models.DataJob, error)
+    DataJob(ctx context.Context, id int64) (models.DataJob, error)
+}
+
+func (s *serverAPI) ExportUserData(
+    ctx context.Context,
+    req *ssov1.ExportUserDataRequest,
+) (*ssov1.ExportUserDataResponse, error) {
+    if req.GetUserId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if err := s.checkTenant(ctx, req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    job, err := s.privacy.ExportUserData(ctx, caller.Principal.String(), req.GetUserId())
+    if err != nil {
+        return nil, toPrivacyStatus(err)
+    }
+
+    return &ssov1.ExportUserDataResponse{
+        Job: toDataJob(job),
+    }, nil
+}
+
+func (s *serverAPI) EraseUser(
+    ctx context.Context,
+    req *ssov1.EraseUserRequest,
+) (*ssov1.EraseUserResponse, error) {
+    actor, err := s.checkTarget(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetReason() == "" {
+        return nil, status.Error(codes.InvalidArgument, "reason is required")
+    }
+
+    job, err := s.privacy.EraseUser(ctx, actor, req.GetUserId(), req.GetReason())
+    if err != nil {
+        return nil, toPrivacyStatus(err)
+    }
+
+    return &ssov1.EraseUserResponse{
+        Job: toDataJob(job),
+    }, nil
+}
+
+func (s *serverAPI) GetDataJob(
+    ctx context.Context,
+    req *ssov1.GetDataJobRequest,
+) (*ssov1.GetDataJobResponse, error) {
+    if req.GetJobId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "job_id is required")
+    }
+
+    job, err := s.privacy.DataJob(ctx, req.GetJobId())
+    if err != nil {
+        return nil, toPrivacyStatus(err)
+    }
+
+    return &ssov1.GetDataJobResponse{
+        Job:     toDataJob(job),
+        Archive: job.Archive,
+    }, nil
+}
+
+func toPrivacyStatus(err error) error {
+    switch {
+    case errors.Is(err, privacy.ErrUserNotFound):
+        return status.Error(codes.NotFound, "user not found")
+    case errors.Is(err, privacy.ErrJobNotFound):
+        return status.Error(codes.NotFound, "data job not found")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toDataJob(job models.DataJob) *ssov1.DataJob {
+    j := &ssov1.DataJob{
+        Id:        job.ID,
+        Kind:      string(job.Kind),
+        UserId:    job.UserID,
+        Status:    string(job.Status),
+        Actor:     job.Actor,
+        Reason:    job.Reason,
+        Attempts:  int32(job.Attempts),
+        LastError: job.LastError,
+        CreatedAt: timestamppb.New(job.CreatedAt),
+    }
+    if !job.StartedAt.IsZero() {
+        j.StartedAt = timestamppb.New(job.StartedAt)
+    }
+    if !job.FinishedAt.IsZero() {
+        j.FinishedAt = timestamppb.New(job.FinishedAt)
+    }
+    if !job.ExpiresAt.IsZero() {
+        j.ExpiresAt = timestamppb.New(job.ExpiresAt)
+    }
+
+    return j
+}
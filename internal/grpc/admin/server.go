@@ -0,0 +1,423 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/admin"
+	"slices"
+	"time"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Admin interface {
+    ListUsers(ctx context.Context,
+        filter models.UserFilter,
+        pageToken string,
+        pageSize int,
+    ) (users []models.User, nextPageToken string, err error)
+    User(ctx context.Context, id int64) (models.User, error)
+    ChangeUserStatus(ctx context.Context,
+        actor string,
+        id int64,
+        status models.UserStatus,
+        reason string,
+        until time.Time,
+    ) error
+    StatusTransitions(ctx context.Context, id int64) ([]models.StatusTransition, error)
+    CheckMember(ctx context.Context, orgID, userID int64) error
+    ForceResetPassword(ctx context.Context, id int64) (password string, err error)
+    SetUserRoles(ctx context.Context, id int64, roles []string) error
+    UserSessions(ctx context.Context, id int64) ([]models.Session, error)
+    RevokeUserSession(ctx context.Context, actor string, id int64, sessionID string) error
+    RevokeUserSessions(ctx context.Context, actor string, id int64) (int64, error)
+    AuditEvents(ctx context.Context,
+        filter models.AuditFilter,
+        pageToken string,
+        pageSize int,
+    ) (events []models.AuditEvent, nextPageToken string, err error)
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedAdminServer
+    admin   Admin
+    privacy Privacy
+    invites Invites
+}
+
+func Register(gRPC *grpc.Server, admin Admin, privacy Privacy, invites Invites) {
+    ssov1.RegisterAdminServer(gRPC, &serverAPI{admin: admin, privacy: privacy, invites: invites})
+}
+
+// Policy describes how the Admin methods are authenticated.
+var Policy = authn.Policy{
+    ssov1.Admin_ListUsers_FullMethodName:                 {Scope: models.ScopeUsersRead, Permission: models.PermissionUsersRead},
+    ssov1.Admin_GetUser_FullMethodName:                   {Scope: models.ScopeUsersRead, Permission: models.PermissionUsersRead},
+    ssov1.Admin_SetUserStatus_FullMethodName:             {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_ListUserStatusTransitions_FullMethodName: {Scope: models.ScopeUsersRead, Permission: models.PermissionUsersRead},
+    ssov1.Admin_ForceResetPassword_FullMethodName:        {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_DeleteUser_FullMethodName:                {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_SetUserRoles_FullMethodName:              {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_ListUserSessions_FullMethodName:          {Scope: models.ScopeUsersRead, Permission: models.PermissionUsersRead},
+    ssov1.Admin_RevokeUserSession_FullMethodName:         {Scope: models.ScopeUsersWrite, Permission: models.PermissionSessionsWrite},
+    ssov1.Admin_RevokeUserSessions_FullMethodName:        {Scope: models.ScopeUsersWrite, Permission: models.PermissionSessionsWrite},
+    ssov1.Admin_QueryAuditLog_FullMethodName:             {Scope: models.ScopeAuditRead, Permission: models.PermissionAuditRead},
+    ssov1.Admin_ExportUserData_FullMethodName:            {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_EraseUser_FullMethodName:                 {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_GetDataJob_FullMethodName:                {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_CreateInviteCode_FullMethodName:          {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+    ssov1.Admin_RevokeInviteCode_FullMethodName:          {Scope: models.ScopeUsersWrite, Permission: models.PermissionUsersWrite},
+}
+
+const (
+    emptyValue = 0
+)
+
+func (s *serverAPI) ListUsers(
+    ctx context.Context,
+    req *ssov1.ListUsersRequest,
+) (*ssov1.ListUsersResponse, error) {
+    if req.GetPageSize() < 0 {
+        return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+    }
+
+    filter := models.UserFilter{
+        EmailPrefix: req.GetEmailPrefix(),
+        Status:      models.UserStatus(req.GetStatus()),
+        Role:        req.GetRole(),
+    }
+    if filter.Status != "" && !slices.Contains(models.UserStatuses, filter.Status) {
+        return nil, status.Error(codes.InvalidArgument, "unknown status")
+    }
+    if caller, ok := authn.CallerFromContext(ctx); ok {
+        // Callers acting in an organization list its members only.
+        filter.OrgID = caller.Principal.OrgID
+    }
+
+    users, next, err := s.admin.ListUsers(ctx, filter, req.GetPageToken(), int(req.GetPageSize()))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListUsersResponse{
+        Users:         make([]*ssov1.User, 0, len(users)),
+        NextPageToken: next,
+    }
+    for _, user := range users {
+        resp.Users = append(resp.Users, toUser(user))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) GetUser(
+    ctx context.Context,
+    req *ssov1.GetUserRequest,
+) (*ssov1.GetUserResponse, error) {
+    if req.GetUserId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    if err := s.checkTenant(ctx, req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    user, err := s.admin.User(ctx, req.GetUserId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.GetUserResponse{
+        User: toUser(user),
+    }, nil
+}
+
+func (s *serverAPI) SetUserStatus(
+    ctx context.Context,
+    req *ssov1.SetUserStatusRequest,
+) (*ssov1.SetUserStatusResponse, error) {
+    actor, err := s.checkTarget(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    userStatus := models.UserStatus(req.GetStatus())
+    if !slices.Contains(models.UserStatuses, userStatus) {
+        return nil, status.Error(codes.InvalidArgument, "unknown status")
+    }
+
+    if req.GetReason() == "" {
+        return nil, status.Error(codes.InvalidArgument, "reason is required")
+    }
+
+    var until time.Time
+    if req.GetUntil() != nil {
+        until = req.GetUntil().AsTime()
+    }
+
+    err = s.admin.ChangeUserStatus(ctx, actor, req.GetUserId(), userStatus, req.GetReason(), until)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.SetUserStatusResponse{}, nil
+}
+
+func (s *serverAPI) ListUserStatusTransitions(
+    ctx context.Context,
+    req *ssov1.ListUserStatusTransitionsRequest,
+) (*ssov1.ListUserStatusTransitionsResponse, error) {
+    if req.GetUserId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    if err := s.checkTenant(ctx, req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    transitions, err := s.admin.StatusTransitions(ctx, req.GetUserId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListUserStatusTransitionsResponse{
+        Transitions: make([]*ssov1.UserStatusTransition, 0, len(transitions)),
+    }
+    for _, t := range transitions {
+        transition := &ssov1.UserStatusTransition{
+            FromStatus: string(t.From),
+            ToStatus:   string(t.To),
+            Reason:     t.Reason,
+            Actor:      t.Actor,
+            CreatedAt:  timestamppb.New(t.CreatedAt),
+        }
+        if !t.Until.IsZero() {
+            transition.Until = timestamppb.New(t.Until)
+        }
+        resp.Transitions = append(resp.Transitions, transition)
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) ForceResetPassword(
+    ctx context.Context,
+    req *ssov1.ForceResetPasswordRequest,
+) (*ssov1.ForceResetPasswordResponse, error) {
+    if _, err := s.checkTarget(ctx, req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    password, err := s.admin.ForceResetPassword(ctx, req.GetUserId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.ForceResetPasswordResponse{
+        Password: password,
+    }, nil
+}
+
+func (s *serverAPI) DeleteUser(
+    ctx context.Context,
+    req *ssov1.DeleteUserRequest,
+) (*ssov1.DeleteUserResponse, error) {
+    actor, err := s.checkTarget(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetReason() == "" {
+        return nil, status.Error(codes.InvalidArgument, "reason is required")
+    }
+
+    err = s.admin.ChangeUserStatus(ctx, actor, req.GetUserId(), models.UserStatusDeleted, req.GetReason(), time.Time{})
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.DeleteUserResponse{}, nil
+}
+
+func (s *serverAPI) SetUserRoles(
+    ctx context.Context,
+    req *ssov1.SetUserRolesRequest,
+) (*ssov1.SetUserRolesResponse, error) {
+    if _, err := s.checkTarget(ctx, req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    if err := s.admin.SetUserRoles(ctx, req.GetUserId(), req.GetRoles()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.SetUserRolesResponse{}, nil
+}
+
+func (s *serverAPI) ListUserSessions(
+    ctx context.Context,
+    req *ssov1.ListUserSessionsRequest,
+) (*ssov1.ListUserSessionsResponse, error) {
+    if req.GetUserId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    if err := s.checkTenant(ctx, req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    sessions, err := s.admin.UserSessions(ctx, req.GetUserId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListUserSessionsResponse{
+        Sessions: make([]*ssov1.Session, 0, len(sessions)),
+    }
+    for _, session := range sessions {
+        resp.Sessions = append(resp.Sessions, &ssov1.Session{
+            Id:         session.ID,
+            UserAgent:  session.UserAgent,
+            Ip:         session.IP,
+            CreatedAt:  timestamppb.New(session.CreatedAt),
+            LastSeenAt: timestamppb.New(session.LastSeenAt),
+            ExpiresAt:  timestamppb.New(session.ExpiresAt),
+        })
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) RevokeUserSession(
+    ctx context.Context,
+    req *ssov1.RevokeUserSessionRequest,
+) (*ssov1.RevokeUserSessionResponse, error) {
+    actor, err := s.checkTarget(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSessionId() == "" {
+        return nil, status.Error(codes.InvalidArgument, "session_id is required")
+    }
+
+    if err := s.admin.RevokeUserSession(ctx, actor, req.GetUserId(), req.GetSessionId()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RevokeUserSessionResponse{}, nil
+}
+
+func (s *serverAPI) RevokeUserSessions(
+    ctx context.Context,
+    req *ssov1.RevokeUserSessionsRequest,
+) (*ssov1.RevokeUserSessionsResponse, error) {
+    actor, err := s.checkTarget(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    revoked, err := s.admin.RevokeUserSessions(ctx, actor, req.GetUserId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RevokeUserSessionsResponse{
+        Revoked: revoked,
+    }, nil
+}
+
+// checkTarget checks the user id of a request changing the user, see validateTarget and checkTenant,
+// and returns the caller as the actor of the change.
+func (s *serverAPI) checkTarget(ctx context.Context, userID int64) (string, error) {
+    actor, err := validateTarget(ctx, userID)
+    if err != nil {
+        return "", err
+    }
+
+    if err := s.checkTenant(ctx, userID); err != nil {
+        return "", err
+    }
+
+    return actor, nil
+}
+
+// checkTenant hides the users outside of the organization of callers acting in one.
+// Such callers get no other permissions than reading users, see models.OrgRolePermissions,
+// the check guards the changes as well in case they do. The storage hides the users too,
+// see storage.WithTenant; the check reports them missing before a request is validated further.
+func (s *serverAPI) checkTenant(ctx context.Context, userID int64) error {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok || caller.Principal.OrgID == 0 {
+        return nil
+    }
+
+    if err := s.admin.CheckMember(ctx, caller.Principal.OrgID, userID); err != nil {
+        return toStatus(err)
+    }
+
+    return nil
+}
+
+// validateTarget checks the user id of a request that could lock the caller out of its own account
+// and returns the caller as the actor of the change.
+func validateTarget(ctx context.Context, userID int64) (string, error) {
+    if userID == emptyValue {
+        return "", status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return "", status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if caller.Principal.IsUser() && caller.Principal.ID == userID {
+        return "", status.Error(codes.FailedPrecondition, "cannot apply to the own account")
+    }
+
+    return caller.Principal.String(), nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, admin.ErrUserNotFound):
+        return status.Error(codes.NotFound, "user not found")
+    case errors.Is(err, admin.ErrInvalidPageToken):
+        return status.Error(codes.InvalidArgument, "invalid page token")
+    case errors.Is(err, admin.ErrInvalidRole):
+        return status.Error(codes.InvalidArgument, "invalid role")
+    case errors.Is(err, admin.ErrInvalidStatus):
+        return status.Error(codes.FailedPrecondition, "invalid status transition")
+    case errors.Is(err, admin.ErrStatusConflict):
+        return status.Error(codes.Aborted, "user status changed concurrently")
+    case errors.Is(err, admin.ErrSessionNotFound):
+        return status.Error(codes.NotFound, "session not found")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toUser(user models.User) *ssov1.User {
+    u := &ssov1.User{
+        Id:            user.ID,
+        Email:         user.Email,
+        Username:      user.Username,
+        Phone:         user.Phone,
+        PhoneVerified: !user.PhoneVerifiedAt.IsZero(),
+        Status:        string(user.Status),
+        Roles:         user.Roles,
+        CreatedAt:     timestamppb.New(user.CreatedAt),
+        StatusReason:  user.StatusReason,
+    }
+    if !user.SuspendedUntil.IsZero() {
+        u.SuspendedUntil = timestamppb.New(user.SuspendedUntil)
+    }
+
+    return u
+}
@@ -0,0 +1,62 @@
+package admin_test
+
+import (
+	"context"
+	"testing"
+
+	"grpc-service-ref/internal/domain/models"
+	admingrpc "grpc-service-ref/internal/grpc/admin"
+	"grpc-service-ref/internal/grpc/authn"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// validator authenticates every token as the caller.
+type validator models.Caller
+
+func (v validator) ValidateToken(context.Context, string) (models.Caller, error) {
+    return models.Caller(v), nil
+}
+
+func TestPolicy_UserSessions(t *testing.T) {
+    tests := []struct {
+        name         string
+        roles        []string
+        method       string
+        expectedCode codes.Code
+    }{
+        {name: "RevokeUserSession", roles: []string{models.RoleSupport}, method: ssov1.Admin_RevokeUserSession_FullMethodName},
+        {name: "RevokeUserSessions", roles: []string{models.RoleSupport}, method: ssov1.Admin_RevokeUserSessions_FullMethodName},
+        {name: "ListUserSessions", roles: []string{models.RoleSupport}, method: ssov1.Admin_ListUserSessions_FullMethodName},
+        {name: "SetUserStatus", roles: []string{models.RoleSupport}, method: ssov1.Admin_SetUserStatus_FullMethodName, expectedCode: codes.PermissionDenied},
+        {name: "NoRole", method: ssov1.Admin_RevokeUserSession_FullMethodName, expectedCode: codes.PermissionDenied},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            caller := validator{Principal: models.Principal{Type: models.PrincipalUser, ID: 1, Roles: tt.roles}}
+            interceptor := authn.UnaryServerInterceptor(caller, admingrpc.Policy)
+
+            ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer token"))
+            var called bool
+            _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(context.Context, any) (any, error) {
+                called = true
+                return nil, nil
+            })
+
+            if tt.expectedCode != codes.OK {
+                require.Equal(t, tt.expectedCode, status.Code(err))
+                assert.False(t, called)
+                return
+            }
+            require.NoError(t, err)
+            assert.True(t, called)
+        })
+    }
+}
@@ -0,0 +1,27 @@
+// Package auditpb converts audit events to their messages for the services exposing them.
+package auditpb
+
+import (
+	"grpc-service-ref/internal/domain/models"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromEvent converts the audit event to its message.
+func FromEvent(event models.AuditEvent) *ssov1.AuditEvent {
+    return &ssov1.AuditEvent{
+        Id:        event.ID,
+        Type:      event.Type,
+        Actor:     event.Actor,
+        Subject:   event.Subject,
+        Ip:        event.IP,
+        UserAgent: event.UserAgent,
+        Outcome:   event.Outcome,
+        Reason:    event.Reason,
+        Details:   event.Details,
+        CreatedAt: timestamppb.New(event.CreatedAt),
+        PrevHash:  event.PrevHash,
+        Hash:      event.Hash,
+    }
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/auditpb"
+	"grpc-service-ref/internal/lib/broker"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Events is the source of the audit events streamed by WatchAuthEvents.
+type Events interface {
+    Subscribe(filter broker.Filter) (*broker.Subscription, error)
+}
+
+func (s *serverAPI) WatchAuthEvents(
+    req *ssov1.WatchAuthEventsRequest,
+    stream grpc.ServerStreamingServer[ssov1.AuditEvent],
+) error {
+    filter := broker.Filter{
+        Apps:  req.GetApps(),
+        Types: req.GetEventTypes(),
+    }
+    for _, id := range req.GetUserIds() {
+        filter.Principals = append(filter.Principals, models.Principal{Type: models.PrincipalUser, ID: id}.String())
+    }
+
+    sub, err := s.events.Subscribe(filter)
+    if err != nil {
+        if errors.Is(err, broker.ErrClosed) {
+            return status.Error(codes.Unavailable, "server is shutting down")
+        }
+        return status.Error(codes.Internal, "internal error")
+    }
+    defer sub.Close()
+
+    // Headers tell the client it is subscribed before the first event arrives.
+    if err := stream.SendHeader(metadata.MD{}); err != nil {
+        return err
+    }
+
+    for {
+        select {
+        case <-stream.Context().Done():
+            return nil
+        case <-sub.Done():
+            if errors.Is(sub.Err(), broker.ErrSlowConsumer) {
+                return status.Error(codes.ResourceExhausted, "events are not received fast enough")
+            }
+            return status.Error(codes.Unavailable, "server is shutting down")
+        case event := <-sub.Events():
+            if err := stream.Send(auditpb.FromEvent(event)); err != nil {
+                return err
+            }
+        }
+    }
+}
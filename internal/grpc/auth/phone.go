@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/phone"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Phone verifies phone numbers and logs users in with codes sent to them by SMS.
+type Phone interface {
+    StartVerification(ctx context.Context, userID int64, number string) (phone.Sent, error)
+    ConfirmVerification(ctx context.Context, userID int64, number, code string) error
+    SendLoginCode(ctx context.Context, number string) (phone.Sent, error)
+    LoginWithCode(ctx context.Context, number, code string, client models.ClientInfo) (token string, err error)
+}
+
+func (s *serverAPI) StartPhoneVerification(
+    ctx context.Context,
+    req *ssov1.StartPhoneVerificationRequest,
+) (*ssov1.StartPhoneVerificationResponse, error) {
+    caller, err := phoneCaller(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetPhone() == "" {
+        return nil, status.Error(codes.InvalidArgument, "phone is required")
+    }
+
+    sent, err := s.phone.StartVerification(ctx, caller.Principal.ID, req.GetPhone())
+    if err != nil {
+        return nil, phoneError(err)
+    }
+
+    return &ssov1.StartPhoneVerificationResponse{
+        ExpiresAt:   timestamppb.New(sent.ExpiresAt),
+        ResendAfter: timestamppb.New(sent.ResendAfter),
+    }, nil
+}
+
+func (s *serverAPI) ConfirmPhoneVerification(
+    ctx context.Context,
+    req *ssov1.ConfirmPhoneVerificationRequest,
+) (*ssov1.ConfirmPhoneVerificationResponse, error) {
+    caller, err := phoneCaller(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := validateCode(req.GetPhone(), req.GetCode()); err != nil {
+        return nil, err
+    }
+
+    if err := s.phone.ConfirmVerification(ctx, caller.Principal.ID, req.GetPhone(), req.GetCode()); err != nil {
+        return nil, phoneError(err)
+    }
+
+    return &ssov1.ConfirmPhoneVerificationResponse{}, nil
+}
+
+func (s *serverAPI) SendLoginCode(
+    ctx context.Context,
+    req *ssov1.SendLoginCodeRequest,
+) (*ssov1.SendLoginCodeResponse, error) {
+    if req.GetPhone() == "" {
+        return nil, status.Error(codes.InvalidArgument, "phone is required")
+    }
+
+    sent, err := s.phone.SendLoginCode(ctx, req.GetPhone())
+    if err != nil {
+        return nil, phoneError(err)
+    }
+
+    return &ssov1.SendLoginCodeResponse{
+        ExpiresAt:   timestamppb.New(sent.ExpiresAt),
+        ResendAfter: timestamppb.New(sent.ResendAfter),
+    }, nil
+}
+
+func (s *serverAPI) LoginWithCode(
+    ctx context.Context,
+    req *ssov1.LoginWithCodeRequest,
+) (*ssov1.LoginWithCodeResponse, error) {
+    if err := validateCode(req.GetPhone(), req.GetCode()); err != nil {
+        return nil, err
+    }
+
+    client := authn.ClientInfo(ctx)
+    client.App = req.GetApp()
+
+    token, err := s.phone.LoginWithCode(ctx, req.GetPhone(), req.GetCode(), client)
+    if err != nil {
+        if st := authn.UserStatusError(err); st != nil {
+            return nil, st
+        }
+        return nil, phoneError(err)
+    }
+
+    return &ssov1.LoginWithCodeResponse{
+        Token: token,
+    }, nil
+}
+
+// phoneCaller returns the caller if it is a user. Other principals have no phone numbers.
+func phoneCaller(ctx context.Context) (models.Caller, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return models.Caller{}, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if !caller.Principal.IsUser() {
+        return models.Caller{}, status.Error(codes.PermissionDenied, "only users have phone numbers")
+    }
+
+    return caller, nil
+}
+
+func validateCode(number, code string) error {
+    if number == "" {
+        return status.Error(codes.InvalidArgument, "phone is required")
+    }
+
+    if code == "" {
+        return status.Error(codes.InvalidArgument, "code is required")
+    }
+
+    return nil
+}
+
+// phoneError maps errors of the phone service to gRPC statuses.
+func phoneError(err error) error {
+    switch {
+    case errors.Is(err, phone.ErrInvalidPhone):
+        return status.Error(codes.InvalidArgument, "invalid phone number")
+    case errors.Is(err, phone.ErrInvalidCode):
+        return status.Error(codes.InvalidArgument, "invalid code")
+    case errors.Is(err, phone.ErrCodeExpired):
+        return status.Error(codes.InvalidArgument, "code expired")
+    case errors.Is(err, phone.ErrTooManyAttempts):
+        return status.Error(codes.ResourceExhausted, "too many attempts, request a new code")
+    case errors.Is(err, phone.ErrResendCooldown):
+        return status.Error(codes.ResourceExhausted, "a code was sent recently, try again later")
+    case errors.Is(err, phone.ErrTooManyCodes):
+        return status.Error(codes.ResourceExhausted, "too many codes sent to the number, try again later")
+    case errors.Is(err, phone.ErrPhoneTaken):
+        return status.Error(codes.AlreadyExists, "phone number is already taken")
+    case errors.Is(err, phone.ErrUserNotFound):
+        return status.Error(codes.NotFound, "user not found")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
@@ -4,33 +4,73 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
 	"grpc-service-ref/internal/services/auth"
+	"time"
 
 	ssov1 "github.com/nonam00/protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Auth interface {
-    Login (ctx context.Context,
-        email string,
+    Login(ctx context.Context,
+        login string,
         password string,
+        client models.ClientInfo,
     ) (token string, err error)
     RegisterNewUser(ctx context.Context,
-        email string,
+        ids models.UserIdentifiers,
         password string,
+        inviteCode string,
     ) (userID int64, err error)
+    LoginServiceAccount(ctx context.Context, clientID, clientSecret string) (token string, err error)
+    ValidateToken(ctx context.Context, token string) (models.Caller, error)
+    ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+    RevokeSession(ctx context.Context, userID int64, sessionID string) error
+    RevokeAllSessions(ctx context.Context, userID int64, exceptSessionID string) (int64, error)
+    CreateAPIKey(ctx context.Context,
+        owner models.Principal,
+        name string,
+        scopes []string,
+        expiresAt time.Time,
+    ) (key string, apiKey models.APIKey, err error)
+    ListAPIKeys(ctx context.Context, owner models.Principal) ([]models.APIKey, error)
+    RevokeAPIKey(ctx context.Context, owner models.Principal, keyID int64) error
 }
 
 type serverAPI struct {
     ssov1.UnimplementedAuthServer
-    auth Auth
+    auth   Auth
+    phone  Phone
+    events Events
 }
 
-func Register(gRPC *grpc.Server, auth Auth) {
-    ssov1.RegisterAuthServer(gRPC, &serverAPI{auth: auth})
+func Register(gRPC *grpc.Server, auth Auth, phone Phone, events Events) {
+    ssov1.RegisterAuthServer(gRPC, &serverAPI{auth: auth, phone: phone, events: events})
+}
+
+// Policy describes how the Auth methods are authenticated.
+var Policy = authn.Policy{
+    ssov1.Auth_Register_FullMethodName:            {Public: true},
+    ssov1.Auth_Login_FullMethodName:               {Public: true},
+    ssov1.Auth_LoginServiceAccount_FullMethodName: {Public: true},
+    ssov1.Auth_ListSessions_FullMethodName:        {Scope: models.ScopeSessionsRead},
+    ssov1.Auth_RevokeSession_FullMethodName:       {Scope: models.ScopeSessionsWrite},
+    ssov1.Auth_RevokeAllSessions_FullMethodName:   {Scope: models.ScopeSessionsWrite},
+    ssov1.Auth_CreateAPIKey_FullMethodName:        {Scope: models.ScopeAPIKeysWrite},
+    ssov1.Auth_ListAPIKeys_FullMethodName:         {Scope: models.ScopeAPIKeysRead},
+    ssov1.Auth_RevokeAPIKey_FullMethodName:        {Scope: models.ScopeAPIKeysWrite},
+    ssov1.Auth_WatchAuthEvents_FullMethodName:     {Scope: models.ScopeAuditRead, Permission: models.PermissionAuditRead},
+    // Phone numbers are verified by their users only, so API keys get no scope for it.
+    ssov1.Auth_StartPhoneVerification_FullMethodName:   {},
+    ssov1.Auth_ConfirmPhoneVerification_FullMethodName: {},
+    ssov1.Auth_SendLoginCode_FullMethodName:            {Public: true},
+    ssov1.Auth_LoginWithCode_FullMethodName:            {Public: true},
 }
 
 const (
@@ -45,12 +85,22 @@ func (s *serverAPI) Login(
         return nil, err
     }
 
-    token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword())
+    client := authn.ClientInfo(ctx)
+    client.App = req.GetApp()
+    client.Org = req.GetOrganization()
+
+    token, err := s.auth.Login(ctx, loginIdentifier(req), req.GetPassword(), client)
 
     if err != nil {
         if errors.Is(err, auth.ErrInvalidCredentials) {
             return nil, status.Error(codes.InvalidArgument, "invalid credentials")
         }
+        if errors.Is(err, auth.ErrNotMember) {
+            return nil, status.Error(codes.PermissionDenied, "not a member of the organization")
+        }
+        if st := authn.UserStatusError(err); st != nil {
+            return nil, st
+        }
         return nil, status.Error(codes.Internal, "internal error")
     }
 
@@ -63,48 +113,331 @@ func (s *serverAPI) Login(
     }, nil
 }
 
+func (s *serverAPI) LoginServiceAccount(
+    ctx context.Context,
+    req *ssov1.LoginServiceAccountRequest,
+) (*ssov1.LoginServiceAccountResponse, error) {
+    if req.GetClientId() == "" {
+        return nil, status.Error(codes.InvalidArgument, "client_id is required")
+    }
+
+    if req.GetClientSecret() == "" {
+        return nil, status.Error(codes.InvalidArgument, "client_secret is required")
+    }
+
+    token, err := s.auth.LoginServiceAccount(ctx, req.GetClientId(), req.GetClientSecret())
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidCredentials) {
+            return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+        }
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.LoginServiceAccountResponse{
+        Token: token,
+    }, nil
+}
+
 func (s *serverAPI) Register(
     ctx context.Context,
     req *ssov1.RegisterRequest,
 ) (*ssov1.RegisterResponse, error) {
-    if err := validateRegiter(req); err != nil {
+    // The identifiers are validated by the service, as the ones required depend on its options.
+    userID, err := s.auth.RegisterNewUser(ctx, models.UserIdentifiers{
+        Email:    req.GetEmail(),
+        Username: req.GetUsername(),
+        Phone:    req.GetPhone(),
+    }, req.GetPassword(), req.GetInviteCode())
+    if err != nil {
+        for _, alreadyExists := range []error{auth.ErrUserExists, auth.ErrUsernameTaken, auth.ErrPhoneTaken} {
+            if errors.Is(err, alreadyExists) {
+                return nil, status.Error(codes.AlreadyExists, alreadyExists.Error())
+            }
+        }
+        for _, invalid := range []error{
+            auth.ErrInvalidEmail,
+            auth.ErrInvalidUsername,
+            auth.ErrInvalidPhone,
+            auth.ErrEmailRequired,
+            auth.ErrUsernameRequired,
+            auth.ErrPhoneRequired,
+            auth.ErrIdentifierRequired,
+            auth.ErrInvalidInviteCode,
+        } {
+            if errors.Is(err, invalid) {
+                return nil, status.Error(codes.InvalidArgument, invalid.Error())
+            }
+        }
+        // The registration mode turns the user away whatever the request carries.
+        for _, denied := range []error{auth.ErrRegistrationClosed, auth.ErrInviteRequired, auth.ErrDomainNotAllowed} {
+            if errors.Is(err, denied) {
+                return nil, status.Error(codes.PermissionDenied, denied.Error())
+            }
+        }
+
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.RegisterResponse{
+        UserId: userID,
+    }, nil
+}
+
+func (s *serverAPI) ListSessions(
+    ctx context.Context,
+    req *ssov1.ListSessionsRequest,
+) (*ssov1.ListSessionsResponse, error) {
+    caller, err := userCaller(ctx)
+    if err != nil {
         return nil, err
     }
 
-    userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword())
+    sessions, err := s.auth.ListSessions(ctx, caller.Principal.ID)
     if err != nil {
-        if errors.Is(err, auth.ErrUserExists) {
-            return nil, status.Error(codes.AlreadyExists, "user already exists")
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    resp := &ssov1.ListSessionsResponse{
+        Sessions: make([]*ssov1.Session, 0, len(sessions)),
+    }
+    for _, session := range sessions {
+        resp.Sessions = append(resp.Sessions, &ssov1.Session{
+            Id:         session.ID,
+            UserAgent:  session.UserAgent,
+            Ip:         session.IP,
+            CreatedAt:  timestamppb.New(session.CreatedAt),
+            LastSeenAt: timestamppb.New(session.LastSeenAt),
+            ExpiresAt:  timestamppb.New(session.ExpiresAt),
+            Current:    caller.SessionID != "" && session.ID == caller.SessionID,
+        })
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) RevokeSession(
+    ctx context.Context,
+    req *ssov1.RevokeSessionRequest,
+) (*ssov1.RevokeSessionResponse, error) {
+    caller, err := userCaller(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSessionId() == "" {
+        return nil, status.Error(codes.InvalidArgument, "session_id is required")
+    }
+
+    if err := s.auth.RevokeSession(ctx, caller.Principal.ID, req.GetSessionId()); err != nil {
+        if errors.Is(err, auth.ErrSessionNotFound) {
+            return nil, status.Error(codes.NotFound, "session not found")
         }
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.RevokeSessionResponse{}, nil
+}
 
+func (s *serverAPI) RevokeAllSessions(
+    ctx context.Context,
+    req *ssov1.RevokeAllSessionsRequest,
+) (*ssov1.RevokeAllSessionsResponse, error) {
+    caller, err := userCaller(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    var except string
+    if req.GetKeepCurrent() {
+        except = caller.SessionID
+    }
+
+    revoked, err := s.auth.RevokeAllSessions(ctx, caller.Principal.ID, except)
+    if err != nil {
         return nil, status.Error(codes.Internal, "internal error")
     }
 
-    return &ssov1.RegisterResponse{
-        UserId: userID,
+    return &ssov1.RevokeAllSessionsResponse{
+        Revoked: revoked,
     }, nil
 }
 
-func validateLogin(req *ssov1.LoginRequest) error {
-    if req.GetEmail() == "" {
-        return status.Error(codes.InvalidArgument, "email is required")
+func (s *serverAPI) CreateAPIKey(
+    ctx context.Context,
+    req *ssov1.CreateAPIKeyRequest,
+) (*ssov1.CreateAPIKeyResponse, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if err := validateCreateAPIKey(req); err != nil {
+        return nil, err
+    }
+
+    owner, err := apiKeyOwner(caller, req.GetServiceAccountId())
+    if err != nil {
+        return nil, err
+    }
+
+    // An API key cannot create keys with more access than it has itself.
+    for _, scope := range req.GetScopes() {
+        if !caller.HasScope(scope) {
+            return nil, status.Error(codes.PermissionDenied, "scope is not granted to the caller: "+scope)
+        }
+    }
+
+    var expiresAt time.Time
+    if req.GetExpiresAt() != nil {
+        expiresAt = req.GetExpiresAt().AsTime()
+    }
+
+    key, apiKey, err := s.auth.CreateAPIKey(ctx, owner, req.GetName(), req.GetScopes(), expiresAt)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidScope) {
+            return nil, status.Error(codes.InvalidArgument, "invalid scope")
+        }
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.CreateAPIKeyResponse{
+        ApiKey: toAPIKey(apiKey),
+        Key:    key,
+    }, nil
+}
+
+func (s *serverAPI) ListAPIKeys(
+    ctx context.Context,
+    req *ssov1.ListAPIKeysRequest,
+) (*ssov1.ListAPIKeysResponse, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "authorization token is required")
     }
 
-    if req.GetEmail() == "" {
-        return status.Error(codes.InvalidArgument, "email is required")
+    owner, err := apiKeyOwner(caller, req.GetServiceAccountId())
+    if err != nil {
+        return nil, err
+    }
+
+    keys, err := s.auth.ListAPIKeys(ctx, owner)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    resp := &ssov1.ListAPIKeysResponse{
+        ApiKeys: make([]*ssov1.APIKey, 0, len(keys)),
+    }
+    for _, key := range keys {
+        resp.ApiKeys = append(resp.ApiKeys, toAPIKey(key))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) RevokeAPIKey(
+    ctx context.Context,
+    req *ssov1.RevokeAPIKeyRequest,
+) (*ssov1.RevokeAPIKeyResponse, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if req.GetApiKeyId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "api_key_id is required")
+    }
+
+    owner, err := apiKeyOwner(caller, req.GetServiceAccountId())
+    if err != nil {
+        return nil, err
+    }
+
+    if err := s.auth.RevokeAPIKey(ctx, owner, req.GetApiKeyId()); err != nil {
+        if errors.Is(err, auth.ErrAPIKeyNotFound) {
+            return nil, status.Error(codes.NotFound, "api key not found")
+        }
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.RevokeAPIKeyResponse{}, nil
+}
+
+// userCaller returns the caller if it is a user. Other principals have no sessions.
+func userCaller(ctx context.Context) (models.Caller, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return models.Caller{}, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if !caller.Principal.IsUser() {
+        return models.Caller{}, status.Error(codes.PermissionDenied, "only users have sessions")
+    }
+
+    return caller, nil
+}
+
+// apiKeyOwner returns the principal whose API keys the call acts on:
+// the caller itself or, for admins, the given service account.
+func apiKeyOwner(caller models.Caller, serviceAccountID int64) (models.Principal, error) {
+    if serviceAccountID == emptyValue {
+        return caller.Principal, nil
+    }
+
+    if !caller.Principal.HasRole(models.RoleAdmin) {
+        return models.Principal{}, status.Error(codes.PermissionDenied, "role admin is required")
+    }
+
+    return models.Principal{Type: models.PrincipalServiceAccount, ID: serviceAccountID}, nil
+}
+
+func toAPIKey(key models.APIKey) *ssov1.APIKey {
+    apiKey := &ssov1.APIKey{
+        Id:        key.ID,
+        Name:      key.Name,
+        Prefix:    key.Prefix,
+        Scopes:    key.Scopes,
+        CreatedAt: timestamppb.New(key.CreatedAt),
+    }
+    if !key.ExpiresAt.IsZero() {
+        apiKey.ExpiresAt = timestamppb.New(key.ExpiresAt)
+    }
+    if !key.LastUsedAt.IsZero() {
+        apiKey.LastUsedAt = timestamppb.New(key.LastUsedAt)
+    }
+
+    return apiKey
+}
+
+func validateLogin(req *ssov1.LoginRequest) error {
+    if loginIdentifier(req) == "" {
+        return status.Error(codes.InvalidArgument, "identifier is required")
     }
 
     return nil
 }
 
-func validateRegiter(req *ssov1.RegisterRequest) error {
-    if req.GetEmail() == "" {
-        return status.Error(codes.InvalidArgument, "email is required")
+// loginIdentifier returns the identifier the user logs in with, falling back to the email
+// of clients that predate identifiers.
+func loginIdentifier(req *ssov1.LoginRequest) string {
+    if req.GetIdentifier() != "" {
+        return req.GetIdentifier()
+    }
+    return req.GetEmail()
+}
+
+func validateCreateAPIKey(req *ssov1.CreateAPIKeyRequest) error {
+    if req.GetName() == "" {
+        return status.Error(codes.InvalidArgument, "name is required")
+    }
+
+    if len(req.GetScopes()) == 0 {
+        return status.Error(codes.InvalidArgument, "at least one scope is required")
     }
 
-    if req.GetEmail() == "" {
-        return status.Error(codes.InvalidArgument, "email is required")
+    if req.GetExpiresAt() != nil && !req.GetExpiresAt().AsTime().After(time.Now()) {
+        return status.Error(codes.InvalidArgument, "expires_at must be in the future")
     }
-  
+
     return nil
 }
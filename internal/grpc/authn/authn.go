@@ -0,0 +1,193 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/storage"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type TokenValidator interface {
+    ValidateToken(ctx context.Context, token string) (models.Caller, error)
+}
+
+// Rule describes how calls of a method are authenticated.
+type Rule struct {
+    // Public methods are called without credentials.
+    Public bool
+    // Scope an API key needs to call the method.
+    // API keys cannot call methods that have no scope.
+    Scope string
+    // Role the principal needs to call the method, if any.
+    Role string
+    // Permission one of the principal roles must grant to call the method, if any.
+    Permission string
+}
+
+// Policy maps full method names to their rules.
+// Methods without a rule require credentials and cannot be called with API keys.
+type Policy map[string]Rule
+
+// Merge combines policies of several services into one.
+func Merge(policies ...Policy) Policy {
+    merged := make(Policy)
+    for _, policy := range policies {
+        for method, rule := range policy {
+            merged[method] = rule
+        }
+    }
+
+    return merged
+}
+
+type callerKey struct{}
+
+// UnaryServerInterceptor authenticates calls by the bearer credential in the authorization metadata
+// and stores the caller in the request context, along with its organization as the storage tenant.
+func UnaryServerInterceptor(validator TokenValidator, policy Policy) grpc.UnaryServerInterceptor {
+    return func(
+        ctx context.Context,
+        req any,
+        info *grpc.UnaryServerInfo,
+        handler grpc.UnaryHandler,
+    ) (any, error) {
+        ctx = audit.WithClient(ctx, ClientInfo(ctx))
+
+        rule := policy[info.FullMethod]
+        if rule.Public {
+            return handler(ctx, req)
+        }
+
+        ctx, err := authenticate(ctx, validator, rule)
+        if err != nil {
+            return nil, err
+        }
+
+        return handler(ctx, req)
+    }
+}
+
+// StreamServerInterceptor authenticates streaming calls the way UnaryServerInterceptor does
+// and stores the caller in the stream context.
+func StreamServerInterceptor(validator TokenValidator, policy Policy) grpc.StreamServerInterceptor {
+    return func(
+        srv any,
+        ss grpc.ServerStream,
+        info *grpc.StreamServerInfo,
+        handler grpc.StreamHandler,
+    ) error {
+        ctx := audit.WithClient(ss.Context(), ClientInfo(ss.Context()))
+
+        rule := policy[info.FullMethod]
+        if !rule.Public {
+            var err error
+            ctx, err = authenticate(ctx, validator, rule)
+            if err != nil {
+                return err
+            }
+        }
+
+        return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+    }
+}
+
+// serverStream is a server stream with a replaced context.
+type serverStream struct {
+    grpc.ServerStream
+    ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+    return s.ctx
+}
+
+// CallerFromContext returns the caller the call is authenticated as.
+func CallerFromContext(ctx context.Context) (models.Caller, bool) {
+    caller, ok := ctx.Value(callerKey{}).(models.Caller)
+    return caller, ok
+}
+
+func authenticate(ctx context.Context, validator TokenValidator, rule Rule) (context.Context, error) {
+    token, err := bearerToken(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    caller, err := validator.ValidateToken(ctx, token)
+    if err != nil {
+        if errors.Is(err, auth.ErrInvalidToken) {
+            return nil, status.Error(codes.Unauthenticated, "invalid token")
+        }
+        if st := UserStatusError(err); st != nil {
+            return nil, st
+        }
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    if caller.APIKeyID != 0 && (rule.Scope == "" || !caller.HasScope(rule.Scope)) {
+        return nil, status.Error(codes.PermissionDenied, "api key scope does not allow this call")
+    }
+
+    if rule.Role != "" && !caller.Principal.HasRole(rule.Role) {
+        return nil, status.Error(codes.PermissionDenied, "role "+rule.Role+" is required")
+    }
+
+    if rule.Permission != "" && !caller.Principal.HasPermission(rule.Permission) {
+        return nil, status.Error(codes.PermissionDenied, "permission "+rule.Permission+" is required")
+    }
+
+    ctx = audit.WithActor(ctx, caller.Principal.String())
+    // Callers acting in an organization read and change the users of the organization only.
+    ctx = storage.WithTenant(ctx, caller.Principal.OrgID)
+
+    return context.WithValue(ctx, callerKey{}, caller), nil
+}
+
+// ClientInfo describes the caller by its user agent and peer address.
+func ClientInfo(ctx context.Context) models.ClientInfo {
+    var info models.ClientInfo
+
+    if md, ok := metadata.FromIncomingContext(ctx); ok {
+        if ua := md.Get("user-agent"); len(ua) > 0 {
+            info.UserAgent = ua[0]
+        }
+    }
+
+    if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+        info.IP = p.Addr.String()
+        if host, _, err := net.SplitHostPort(info.IP); err == nil {
+            info.IP = host
+        }
+    }
+
+    return info
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+    md, ok := metadata.FromIncomingContext(ctx)
+    if !ok {
+        return "", status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    values := md.Get("authorization")
+    if len(values) == 0 {
+        return "", status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    scheme, token, ok := strings.Cut(values[0], " ")
+    if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+        return "", status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+    }
+
+    return token, nil
+}
@@ -0,0 +1,59 @@
+package authn
+
+import (
+	"errors"
+	"grpc-service-ref/internal/services/auth"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the error details clients tell statuses apart by.
+const errorDomain = "sso"
+
+// Reasons of the error details of users whose status does not allow them to authenticate.
+const (
+    ReasonUserPending   = "USER_PENDING"
+    ReasonUserSuspended = "USER_SUSPENDED"
+    ReasonUserLocked    = "USER_LOCKED"
+)
+
+// UserStatusError converts the error of a user whose status does not allow it to authenticate
+// to a gRPC status carrying the reason in its details. It returns nil for other errors.
+func UserStatusError(err error) error {
+    var (
+        code     codes.Code
+        msg      string
+        reason   string
+        metadata map[string]string
+    )
+
+    switch {
+    case errors.Is(err, auth.ErrUserPending):
+        code, msg, reason = codes.FailedPrecondition, "user is pending verification", ReasonUserPending
+    case errors.Is(err, auth.ErrUserSuspended):
+        code, msg, reason = codes.PermissionDenied, "user is suspended", ReasonUserSuspended
+
+        var suspended *auth.SuspendedError
+        if errors.As(err, &suspended) {
+            metadata = map[string]string{"until": suspended.Until.UTC().Format(time.RFC3339)}
+        }
+    case errors.Is(err, auth.ErrUserLocked):
+        code, msg, reason = codes.PermissionDenied, "user is locked", ReasonUserLocked
+    default:
+        return nil
+    }
+
+    st, detailsErr := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{
+        Reason:   reason,
+        Domain:   errorDomain,
+        Metadata: metadata,
+    })
+    if detailsErr != nil {
+        return status.Error(code, msg)
+    }
+
+    return st.Err()
+}
@@ -0,0 +1,31 @@
+// Package consistency lets callers demand that their reads see the latest writes.
+package consistency
+
+import (
+	"context"
+	"grpc-service-ref/internal/storage"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+    // Header is the metadata key carrying the read consistency a call demands.
+    Header = "x-read-consistency"
+    // Primary demands reads from the primary database rather than from read replicas.
+    Primary = "primary"
+)
+
+// UnaryServerInterceptor routes the reads of calls demanding the Primary consistency to the primary database.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+        if md, ok := metadata.FromIncomingContext(ctx); ok {
+            if values := md.Get(Header); len(values) > 0 && strings.EqualFold(values[0], Primary) {
+                ctx = storage.WithPrimaryReads(ctx)
+            }
+        }
+
+        return handler(ctx, req)
+    }
+}
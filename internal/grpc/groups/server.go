@@ -0,0 +1,369 @@
+package groups
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/group"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Groups interface {
+    CreateGroup(ctx context.Context,
+        actor string,
+        name string,
+        description string,
+        roles []string,
+    ) (models.Group, error)
+    Group(ctx context.Context, id int64) (models.Group, models.GroupMembers, error)
+    ListGroups(ctx context.Context, pageToken string, pageSize int) (groups []models.Group, nextPageToken string, err error)
+    SetGroupRoles(ctx context.Context, actor string, id int64, roles []string) error
+    DeleteGroup(ctx context.Context, actor string, id int64) error
+    AddUser(ctx context.Context, actor string, groupID, userID int64) error
+    RemoveUser(ctx context.Context, actor string, groupID, userID int64) error
+    AddSubgroup(ctx context.Context, actor string, groupID, subgroupID int64) error
+    RemoveSubgroup(ctx context.Context, actor string, groupID, subgroupID int64) error
+    Access(ctx context.Context, caller models.Principal, userID int64) (models.Access, error)
+    CheckPermission(ctx context.Context,
+        caller models.Principal,
+        userID int64,
+        permission string,
+    ) ([]models.RoleGrant, error)
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedGroupsServer
+    groups Groups
+}
+
+func Register(gRPC *grpc.Server, groups Groups) {
+    ssov1.RegisterGroupsServer(gRPC, &serverAPI{groups: groups})
+}
+
+// Policy describes how the Groups methods are authenticated and authorized.
+// Who may read the access of a user is checked by the service.
+var Policy = authn.Policy{
+    ssov1.Groups_CreateGroup_FullMethodName:        {Scope: models.ScopeGroupsWrite, Permission: models.PermissionGroupsWrite},
+    ssov1.Groups_GetGroup_FullMethodName:           {Scope: models.ScopeGroupsRead, Permission: models.PermissionGroupsRead},
+    ssov1.Groups_ListGroups_FullMethodName:         {Scope: models.ScopeGroupsRead, Permission: models.PermissionGroupsRead},
+    ssov1.Groups_DeleteGroup_FullMethodName:        {Scope: models.ScopeGroupsWrite, Permission: models.PermissionGroupsWrite},
+    ssov1.Groups_SetGroupRoles_FullMethodName:      {Scope: models.ScopeGroupsWrite, Permission: models.PermissionGroupsWrite},
+    ssov1.Groups_AddGroupMember_FullMethodName:     {Scope: models.ScopeGroupsWrite, Permission: models.PermissionGroupsWrite},
+    ssov1.Groups_RemoveGroupMember_FullMethodName:  {Scope: models.ScopeGroupsWrite, Permission: models.PermissionGroupsWrite},
+    ssov1.Groups_GetEffectiveAccess_FullMethodName: {Scope: models.ScopeGroupsRead},
+    ssov1.Groups_CheckPermission_FullMethodName:    {Scope: models.ScopeGroupsRead},
+}
+
+const (
+    emptyValue = 0
+)
+
+func (s *serverAPI) CreateGroup(
+    ctx context.Context,
+    req *ssov1.CreateGroupRequest,
+) (*ssov1.CreateGroupResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetName() == "" {
+        return nil, status.Error(codes.InvalidArgument, "name is required")
+    }
+
+    g, err := s.groups.CreateGroup(ctx, caller.String(), req.GetName(), req.GetDescription(), req.GetRoles())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.CreateGroupResponse{
+        Group: toGroup(g),
+    }, nil
+}
+
+func (s *serverAPI) GetGroup(
+    ctx context.Context,
+    req *ssov1.GetGroupRequest,
+) (*ssov1.GetGroupResponse, error) {
+    if req.GetGroupId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "group_id is required")
+    }
+
+    g, members, err := s.groups.Group(ctx, req.GetGroupId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.GetGroupResponse{
+        Group:       toGroup(g),
+        UserIds:     members.UserIDs,
+        SubgroupIds: members.GroupIDs,
+    }, nil
+}
+
+func (s *serverAPI) ListGroups(
+    ctx context.Context,
+    req *ssov1.ListGroupsRequest,
+) (*ssov1.ListGroupsResponse, error) {
+    if req.GetPageSize() < 0 {
+        return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+    }
+
+    groups, next, err := s.groups.ListGroups(ctx, req.GetPageToken(), int(req.GetPageSize()))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListGroupsResponse{
+        Groups:        make([]*ssov1.Group, 0, len(groups)),
+        NextPageToken: next,
+    }
+    for _, g := range groups {
+        resp.Groups = append(resp.Groups, toGroup(g))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) DeleteGroup(
+    ctx context.Context,
+    req *ssov1.DeleteGroupRequest,
+) (*ssov1.DeleteGroupResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetGroupId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "group_id is required")
+    }
+
+    if err := s.groups.DeleteGroup(ctx, caller.String(), req.GetGroupId()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.DeleteGroupResponse{}, nil
+}
+
+func (s *serverAPI) SetGroupRoles(
+    ctx context.Context,
+    req *ssov1.SetGroupRolesRequest,
+) (*ssov1.SetGroupRolesResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetGroupId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "group_id is required")
+    }
+
+    if err := s.groups.SetGroupRoles(ctx, caller.String(), req.GetGroupId(), req.GetRoles()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.SetGroupRolesResponse{}, nil
+}
+
+func (s *serverAPI) AddGroupMember(
+    ctx context.Context,
+    req *ssov1.AddGroupMemberRequest,
+) (*ssov1.AddGroupMemberResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := validateMember(req.GetGroupId(), req.GetUserId(), req.GetSubgroupId()); err != nil {
+        return nil, err
+    }
+
+    if req.GetUserId() != emptyValue {
+        err = s.groups.AddUser(ctx, caller.String(), req.GetGroupId(), req.GetUserId())
+    } else {
+        err = s.groups.AddSubgroup(ctx, caller.String(), req.GetGroupId(), req.GetSubgroupId())
+    }
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.AddGroupMemberResponse{}, nil
+}
+
+func (s *serverAPI) RemoveGroupMember(
+    ctx context.Context,
+    req *ssov1.RemoveGroupMemberRequest,
+) (*ssov1.RemoveGroupMemberResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := validateMember(req.GetGroupId(), req.GetUserId(), req.GetSubgroupId()); err != nil {
+        return nil, err
+    }
+
+    if req.GetUserId() != emptyValue {
+        err = s.groups.RemoveUser(ctx, caller.String(), req.GetGroupId(), req.GetUserId())
+    } else {
+        err = s.groups.RemoveSubgroup(ctx, caller.String(), req.GetGroupId(), req.GetSubgroupId())
+    }
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RemoveGroupMemberResponse{}, nil
+}
+
+func (s *serverAPI) GetEffectiveAccess(
+    ctx context.Context,
+    req *ssov1.GetEffectiveAccessRequest,
+) (*ssov1.GetEffectiveAccessResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    userID, err := targetUser(caller, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    access, err := s.groups.Access(ctx, caller, userID)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.GetEffectiveAccessResponse{
+        Roles:       access.Roles,
+        Permissions: access.Permissions,
+        Groups:      make([]*ssov1.GroupMembership, 0, len(access.Groups)),
+    }
+    for _, ug := range access.Groups {
+        resp.Groups = append(resp.Groups, &ssov1.GroupMembership{
+            Group: toGroup(ug.Group),
+            Path:  ug.Path,
+        })
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) CheckPermission(
+    ctx context.Context,
+    req *ssov1.CheckPermissionRequest,
+) (*ssov1.CheckPermissionResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetPermission() == "" {
+        return nil, status.Error(codes.InvalidArgument, "permission is required")
+    }
+
+    userID, err := targetUser(caller, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    grants, err := s.groups.CheckPermission(ctx, caller, userID, req.GetPermission())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.CheckPermissionResponse{
+        Allowed: len(grants) > 0,
+        Grants:  make([]*ssov1.PermissionGrant, 0, len(grants)),
+    }
+    for _, grant := range grants {
+        path := make([]*ssov1.Group, 0, len(grant.Path))
+        for _, g := range grant.Path {
+            path = append(path, toGroup(g))
+        }
+        resp.Grants = append(resp.Grants, &ssov1.PermissionGrant{
+            Role: grant.Role,
+            Path: path,
+        })
+    }
+
+    return resp, nil
+}
+
+// validateMember checks that a member request names the group and exactly one of a user and a subgroup.
+func validateMember(groupID, userID, subgroupID int64) error {
+    if groupID == emptyValue {
+        return status.Error(codes.InvalidArgument, "group_id is required")
+    }
+    if (userID == emptyValue) == (subgroupID == emptyValue) {
+        return status.Error(codes.InvalidArgument, "exactly one of user_id and subgroup_id is required")
+    }
+
+    return nil
+}
+
+// targetUser returns the user a request is about, the caller itself when the request names none.
+func targetUser(caller models.Principal, userID int64) (int64, error) {
+    if userID != emptyValue {
+        return userID, nil
+    }
+    if !caller.IsUser() {
+        return 0, status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    return caller.ID, nil
+}
+
+func callerPrincipal(ctx context.Context) (models.Principal, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return models.Principal{}, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    return caller.Principal, nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, group.ErrForbidden):
+        return status.Error(codes.PermissionDenied, "not allowed to read the access of the user")
+    case errors.Is(err, group.ErrGroupNotFound):
+        return status.Error(codes.NotFound, "group not found")
+    case errors.Is(err, group.ErrGroupExists):
+        return status.Error(codes.AlreadyExists, "group already exists")
+    case errors.Is(err, group.ErrInvalidName):
+        return status.Error(codes.InvalidArgument, "name must be 1 to 128 characters")
+    case errors.Is(err, group.ErrInvalidDescription):
+        return status.Error(codes.InvalidArgument, "description must be at most 1024 characters")
+    case errors.Is(err, group.ErrInvalidRole):
+        return status.Error(codes.InvalidArgument, "invalid role")
+    case errors.Is(err, group.ErrInvalidPageToken):
+        return status.Error(codes.InvalidArgument, "invalid page token")
+    case errors.Is(err, group.ErrUserNotFound):
+        return status.Error(codes.NotFound, "user not found")
+    case errors.Is(err, group.ErrMemberExists):
+        return status.Error(codes.AlreadyExists, "already a member of the group")
+    case errors.Is(err, group.ErrMemberNotFound):
+        return status.Error(codes.NotFound, "not a member of the group")
+    case errors.Is(err, group.ErrCycle):
+        return status.Error(codes.FailedPrecondition, "group nesting would form a cycle")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toGroup(g models.Group) *ssov1.Group {
+    return &ssov1.Group{
+        Id:          g.ID,
+        Name:        g.Name,
+        Description: g.Description,
+        Roles:       g.Roles,
+        CreatedAt:   timestamppb.New(g.CreatedAt),
+    }
+}
@@ -0,0 +1,375 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/organization"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Organizations interface {
+    CreateOrganization(ctx context.Context,
+        caller models.Principal,
+        slug string,
+        name string,
+        ownerID int64,
+    ) (models.Organization, error)
+    Organization(ctx context.Context, caller models.Principal, id int64) (models.Organization, error)
+    UserOrganizations(ctx context.Context, caller models.Principal) ([]models.UserOrganization, error)
+    Members(ctx context.Context,
+        caller models.Principal,
+        orgID int64,
+        pageToken string,
+        pageSize int,
+    ) (members []models.Membership, nextPageToken string, err error)
+    SetMemberRoles(ctx context.Context, caller models.Principal, orgID, userID int64, roles []string) error
+    RemoveMember(ctx context.Context, caller models.Principal, orgID, userID int64) error
+    CreateInvitation(ctx context.Context,
+        caller models.Principal,
+        orgID int64,
+        email string,
+        roles []string,
+    ) (inv models.OrgInvitation, token string, err error)
+    RevokeInvitation(ctx context.Context, caller models.Principal, orgID, id int64) error
+    AcceptInvitation(ctx context.Context,
+        caller models.Principal,
+        token string,
+    ) (models.Organization, models.Membership, error)
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedOrganizationsServer
+    orgs Organizations
+}
+
+func Register(gRPC *grpc.Server, orgs Organizations) {
+    ssov1.RegisterOrganizationsServer(gRPC, &serverAPI{orgs: orgs})
+}
+
+// Policy describes how the Organizations methods are authenticated.
+// What the caller may do within an organization is checked by the service.
+var Policy = authn.Policy{
+    ssov1.Organizations_CreateOrganization_FullMethodName: {Scope: models.ScopeOrgsWrite, Permission: models.PermissionOrgsWrite},
+    ssov1.Organizations_GetOrganization_FullMethodName:    {Scope: models.ScopeOrgsRead},
+    ssov1.Organizations_ListOrganizations_FullMethodName:  {Scope: models.ScopeOrgsRead},
+    ssov1.Organizations_ListMembers_FullMethodName:        {Scope: models.ScopeOrgsRead},
+    ssov1.Organizations_SetMemberRoles_FullMethodName:     {Scope: models.ScopeOrgsWrite},
+    ssov1.Organizations_RemoveMember_FullMethodName:       {Scope: models.ScopeOrgsWrite},
+    ssov1.Organizations_CreateInvitation_FullMethodName:   {Scope: models.ScopeOrgsWrite},
+    ssov1.Organizations_RevokeInvitation_FullMethodName:   {Scope: models.ScopeOrgsWrite},
+    ssov1.Organizations_AcceptInvitation_FullMethodName:   {Scope: models.ScopeOrgsWrite},
+}
+
+const (
+    emptyValue = 0
+)
+
+func (s *serverAPI) CreateOrganization(
+    ctx context.Context,
+    req *ssov1.CreateOrganizationRequest,
+) (*ssov1.CreateOrganizationResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSlug() == "" {
+        return nil, status.Error(codes.InvalidArgument, "slug is required")
+    }
+
+    ownerID := req.GetOwnerUserId()
+    if ownerID == emptyValue {
+        if !caller.IsUser() {
+            return nil, status.Error(codes.InvalidArgument, "owner_user_id is required")
+        }
+        ownerID = caller.ID
+    }
+
+    org, err := s.orgs.CreateOrganization(ctx, caller, req.GetSlug(), req.GetName(), ownerID)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.CreateOrganizationResponse{
+        Organization: toOrganization(org),
+    }, nil
+}
+
+func (s *serverAPI) GetOrganization(
+    ctx context.Context,
+    req *ssov1.GetOrganizationRequest,
+) (*ssov1.GetOrganizationResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetOrganizationId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "organization_id is required")
+    }
+
+    org, err := s.orgs.Organization(ctx, caller, req.GetOrganizationId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.GetOrganizationResponse{
+        Organization: toOrganization(org),
+    }, nil
+}
+
+func (s *serverAPI) ListOrganizations(
+    ctx context.Context,
+    _ *ssov1.ListOrganizationsRequest,
+) (*ssov1.ListOrganizationsResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    orgs, err := s.orgs.UserOrganizations(ctx, caller)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListOrganizationsResponse{
+        Organizations: make([]*ssov1.ListOrganizationsResponse_Entry, 0, len(orgs)),
+    }
+    for _, uo := range orgs {
+        resp.Organizations = append(resp.Organizations, &ssov1.ListOrganizationsResponse_Entry{
+            Organization: toOrganization(uo.Organization),
+            Roles:        uo.Roles,
+        })
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) ListMembers(
+    ctx context.Context,
+    req *ssov1.ListMembersRequest,
+) (*ssov1.ListMembersResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetOrganizationId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "organization_id is required")
+    }
+    if req.GetPageSize() < 0 {
+        return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+    }
+
+    members, next, err := s.orgs.Members(ctx, caller, req.GetOrganizationId(), req.GetPageToken(), int(req.GetPageSize()))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListMembersResponse{
+        Members:       make([]*ssov1.Member, 0, len(members)),
+        NextPageToken: next,
+    }
+    for _, m := range members {
+        resp.Members = append(resp.Members, toMember(m))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) SetMemberRoles(
+    ctx context.Context,
+    req *ssov1.SetMemberRolesRequest,
+) (*ssov1.SetMemberRolesResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := validateMember(req.GetOrganizationId(), req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    err = s.orgs.SetMemberRoles(ctx, caller, req.GetOrganizationId(), req.GetUserId(), req.GetRoles())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.SetMemberRolesResponse{}, nil
+}
+
+func (s *serverAPI) RemoveMember(
+    ctx context.Context,
+    req *ssov1.RemoveMemberRequest,
+) (*ssov1.RemoveMemberResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := validateMember(req.GetOrganizationId(), req.GetUserId()); err != nil {
+        return nil, err
+    }
+
+    if err := s.orgs.RemoveMember(ctx, caller, req.GetOrganizationId(), req.GetUserId()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RemoveMemberResponse{}, nil
+}
+
+func (s *serverAPI) CreateInvitation(
+    ctx context.Context,
+    req *ssov1.CreateInvitationRequest,
+) (*ssov1.CreateInvitationResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetOrganizationId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "organization_id is required")
+    }
+    if req.GetEmail() == "" {
+        return nil, status.Error(codes.InvalidArgument, "email is required")
+    }
+
+    inv, token, err := s.orgs.CreateInvitation(ctx, caller, req.GetOrganizationId(), req.GetEmail(), req.GetRoles())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.CreateInvitationResponse{
+        Invitation: &ssov1.Invitation{
+            Id:             inv.ID,
+            OrganizationId: inv.OrgID,
+            Email:          inv.Email,
+            Roles:          inv.Roles,
+            CreatedAt:      timestamppb.New(inv.CreatedAt),
+            ExpiresAt:      timestamppb.New(inv.ExpiresAt),
+        },
+        Token: token,
+    }, nil
+}
+
+func (s *serverAPI) RevokeInvitation(
+    ctx context.Context,
+    req *ssov1.RevokeInvitationRequest,
+) (*ssov1.RevokeInvitationResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetOrganizationId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "organization_id is required")
+    }
+    if req.GetInvitationId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "invitation_id is required")
+    }
+
+    if err := s.orgs.RevokeInvitation(ctx, caller, req.GetOrganizationId(), req.GetInvitationId()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RevokeInvitationResponse{}, nil
+}
+
+func (s *serverAPI) AcceptInvitation(
+    ctx context.Context,
+    req *ssov1.AcceptInvitationRequest,
+) (*ssov1.AcceptInvitationResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetToken() == "" {
+        return nil, status.Error(codes.InvalidArgument, "token is required")
+    }
+
+    org, member, err := s.orgs.AcceptInvitation(ctx, caller, req.GetToken())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.AcceptInvitationResponse{
+        Organization: toOrganization(org),
+        Member:       toMember(member),
+    }, nil
+}
+
+func validateMember(orgID, userID int64) error {
+    if orgID == emptyValue {
+        return status.Error(codes.InvalidArgument, "organization_id is required")
+    }
+    if userID == emptyValue {
+        return status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    return nil
+}
+
+func callerPrincipal(ctx context.Context) (models.Principal, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return models.Principal{}, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    return caller.Principal, nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, organization.ErrForbidden):
+        return status.Error(codes.PermissionDenied, "not allowed to manage the organization")
+    case errors.Is(err, organization.ErrOrgNotFound):
+        return status.Error(codes.NotFound, "organization not found")
+    case errors.Is(err, organization.ErrOrgExists):
+        return status.Error(codes.AlreadyExists, "organization already exists")
+    case errors.Is(err, organization.ErrInvalidSlug):
+        return status.Error(codes.InvalidArgument, "slug must be 2 to 63 lowercase letters, digits and dashes")
+    case errors.Is(err, organization.ErrInvalidName):
+        return status.Error(codes.InvalidArgument, "invalid name")
+    case errors.Is(err, organization.ErrInvalidRole):
+        return status.Error(codes.InvalidArgument, "invalid role")
+    case errors.Is(err, organization.ErrInvalidEmail):
+        return status.Error(codes.InvalidArgument, "invalid email")
+    case errors.Is(err, organization.ErrInvalidPageToken):
+        return status.Error(codes.InvalidArgument, "invalid page token")
+    case errors.Is(err, organization.ErrUserNotFound):
+        return status.Error(codes.NotFound, "user not found")
+    case errors.Is(err, organization.ErrMemberNotFound):
+        return status.Error(codes.NotFound, "member not found")
+    case errors.Is(err, organization.ErrMemberExists):
+        return status.Error(codes.AlreadyExists, "user is already a member")
+    case errors.Is(err, organization.ErrInvitationNotFound):
+        return status.Error(codes.NotFound, "invitation not found")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toOrganization(org models.Organization) *ssov1.Organization {
+    return &ssov1.Organization{
+        Id:        org.ID,
+        Slug:      org.Slug,
+        Name:      org.Name,
+        CreatedAt: timestamppb.New(org.CreatedAt),
+    }
+}
+
+func toMember(m models.Membership) *ssov1.Member {
+    return &ssov1.Member{
+        UserId:   m.UserID,
+        Roles:    m.Roles,
+        JoinedAt: timestamppb.New(m.CreatedAt),
+    }
+}
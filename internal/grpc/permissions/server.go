@@ -0,0 +1,231 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/authz"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Permissions interface {
+    WriteRelationships(ctx context.Context, updates []models.RelationshipUpdate) (token string, err error)
+    Check(ctx context.Context,
+        resource models.ObjectRef,
+        permission string,
+        subject models.SubjectRef,
+        token string,
+    ) (allowed bool, checkedAt string, err error)
+    LookupResources(ctx context.Context,
+        resourceType string,
+        permission string,
+        subject models.SubjectRef,
+        token string,
+    ) (ids []string, lookedUpAt string, err error)
+    Expand(ctx context.Context,
+        resource models.ObjectRef,
+        permission string,
+        token string,
+    ) (tree models.PermissionTree, expandedAt string, err error)
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedPermissionsServer
+    permissions Permissions
+}
+
+func Register(gRPC *grpc.Server, permissions Permissions) {
+    ssov1.RegisterPermissionsServer(gRPC, &serverAPI{permissions: permissions})
+}
+
+// Policy describes how the Permissions methods are authenticated and authorized.
+var Policy = authn.Policy{
+    ssov1.Permissions_WriteRelationships_FullMethodName: {Scope: models.ScopeRelationshipsWrite, Permission: models.PermissionRelationshipsWrite},
+    ssov1.Permissions_Check_FullMethodName:              {Scope: models.ScopeRelationshipsRead, Permission: models.PermissionRelationshipsRead},
+    ssov1.Permissions_LookupResources_FullMethodName:    {Scope: models.ScopeRelationshipsRead, Permission: models.PermissionRelationshipsRead},
+    ssov1.Permissions_Expand_FullMethodName:             {Scope: models.ScopeRelationshipsRead, Permission: models.PermissionRelationshipsRead},
+}
+
+func (s *serverAPI) WriteRelationships(
+    ctx context.Context,
+    req *ssov1.WriteRelationshipsRequest,
+) (*ssov1.WriteRelationshipsResponse, error) {
+    if len(req.GetUpdates()) == 0 {
+        return nil, status.Error(codes.InvalidArgument, "updates are required")
+    }
+
+    updates := make([]models.RelationshipUpdate, 0, len(req.GetUpdates()))
+    for _, u := range req.GetUpdates() {
+        r := u.GetRelationship()
+        if r.GetRelation() == "" {
+            return nil, status.Error(codes.InvalidArgument, "relationship relation is required")
+        }
+        if err := validateObject("relationship resource", r.GetResource()); err != nil {
+            return nil, err
+        }
+        if err := validateObject("relationship subject", r.GetSubject().GetObject()); err != nil {
+            return nil, err
+        }
+
+        updates = append(updates, models.RelationshipUpdate{
+            Operation: models.RelationshipOperation(u.GetOperation()),
+            Relationship: models.Relationship{
+                Resource: toObject(r.GetResource()),
+                Relation: r.GetRelation(),
+                Subject:  toSubject(r.GetSubject()),
+            },
+        })
+    }
+
+    token, err := s.permissions.WriteRelationships(ctx, updates)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.WriteRelationshipsResponse{
+        WrittenAt: token,
+    }, nil
+}
+
+func (s *serverAPI) Check(
+    ctx context.Context,
+    req *ssov1.CheckRequest,
+) (*ssov1.CheckResponse, error) {
+    if err := validateObject("resource", req.GetResource()); err != nil {
+        return nil, err
+    }
+    if req.GetPermission() == "" {
+        return nil, status.Error(codes.InvalidArgument, "permission is required")
+    }
+    if err := validateObject("subject", req.GetSubject().GetObject()); err != nil {
+        return nil, err
+    }
+
+    allowed, checkedAt, err := s.permissions.Check(ctx,
+        toObject(req.GetResource()), req.GetPermission(), toSubject(req.GetSubject()), req.GetConsistencyToken())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.CheckResponse{
+        Allowed:   allowed,
+        CheckedAt: checkedAt,
+    }, nil
+}
+
+func (s *serverAPI) LookupResources(
+    ctx context.Context,
+    req *ssov1.LookupResourcesRequest,
+) (*ssov1.LookupResourcesResponse, error) {
+    if req.GetResourceType() == "" {
+        return nil, status.Error(codes.InvalidArgument, "resource_type is required")
+    }
+    if req.GetPermission() == "" {
+        return nil, status.Error(codes.InvalidArgument, "permission is required")
+    }
+    if err := validateObject("subject", req.GetSubject().GetObject()); err != nil {
+        return nil, err
+    }
+
+    ids, lookedUpAt, err := s.permissions.LookupResources(ctx,
+        req.GetResourceType(), req.GetPermission(), toSubject(req.GetSubject()), req.GetConsistencyToken())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.LookupResourcesResponse{
+        ResourceIds: ids,
+        LookedUpAt:  lookedUpAt,
+    }, nil
+}
+
+func (s *serverAPI) Expand(
+    ctx context.Context,
+    req *ssov1.ExpandRequest,
+) (*ssov1.ExpandResponse, error) {
+    if err := validateObject("resource", req.GetResource()); err != nil {
+        return nil, err
+    }
+    if req.GetPermission() == "" {
+        return nil, status.Error(codes.InvalidArgument, "permission is required")
+    }
+
+    tree, expandedAt, err := s.permissions.Expand(ctx,
+        toObject(req.GetResource()), req.GetPermission(), req.GetConsistencyToken())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.ExpandResponse{
+        Tree:       toTree(tree),
+        ExpandedAt: expandedAt,
+    }, nil
+}
+
+func validateObject(name string, object *ssov1.ObjectReference) error {
+    if object.GetType() == "" || object.GetId() == "" {
+        return status.Error(codes.InvalidArgument, name+" type and id are required")
+    }
+
+    return nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, authz.ErrInvalidObject):
+        return status.Error(codes.InvalidArgument, "object ids are up to 128 letters, digits and _ . / | = + @ -")
+    case errors.Is(err, authz.ErrInvalidOperation):
+        return status.Error(codes.InvalidArgument, "operation must be touch or delete")
+    case errors.Is(err, authz.ErrInvalidRelationship):
+        return status.Error(codes.InvalidArgument, "relationship not allowed by the schema")
+    case errors.Is(err, authz.ErrTooManyUpdates):
+        return status.Error(codes.InvalidArgument, "at most 1000 updates are allowed")
+    case errors.Is(err, authz.ErrUnknownType):
+        return status.Error(codes.InvalidArgument, "type not defined in the schema")
+    case errors.Is(err, authz.ErrUnknownRelation):
+        return status.Error(codes.InvalidArgument, "relation or permission not defined in the schema")
+    case errors.Is(err, authz.ErrInvalidToken):
+        return status.Error(codes.InvalidArgument, "invalid consistency token")
+    case errors.Is(err, authz.ErrTooComplex):
+        return status.Error(codes.ResourceExhausted, "relationships too deep or too many to follow")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toObject(object *ssov1.ObjectReference) models.ObjectRef {
+    return models.ObjectRef{Type: object.GetType(), ID: object.GetId()}
+}
+
+func toSubject(subject *ssov1.SubjectReference) models.SubjectRef {
+    return models.SubjectRef{Object: toObject(subject.GetObject()), Relation: subject.GetRelation()}
+}
+
+func fromObject(object models.ObjectRef) *ssov1.ObjectReference {
+    return &ssov1.ObjectReference{Type: object.Type, Id: object.ID}
+}
+
+func toTree(tree models.PermissionTree) *ssov1.PermissionTree {
+    resp := &ssov1.PermissionTree{
+        Resource: fromObject(tree.Resource),
+        Relation: tree.Relation,
+        Subjects: make([]*ssov1.SubjectReference, 0, len(tree.Subjects)),
+        Children: make([]*ssov1.PermissionTree, 0, len(tree.Children)),
+    }
+    for _, subject := range tree.Subjects {
+        resp.Subjects = append(resp.Subjects, &ssov1.SubjectReference{
+            Object:   fromObject(subject.Object),
+            Relation: subject.Relation,
+        })
+    }
+    for _, child := range tree.Children {
+        resp.Children = append(resp.Children, toTree(child))
+    }
+
+    return resp
+}
@@ -0,0 +1,178 @@
+package profiles
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/profile"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type Profiles interface {
+    Profile(ctx context.Context, caller models.Principal, userID int64, paths []string) (models.Profile, error)
+    UpdateProfile(ctx context.Context,
+        caller models.Principal,
+        userID int64,
+        paths []string,
+        profile models.Profile,
+    ) (models.Profile, error)
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedProfilesServer
+    profiles Profiles
+}
+
+func Register(gRPC *grpc.Server, profiles Profiles) {
+    ssov1.RegisterProfilesServer(gRPC, &serverAPI{profiles: profiles})
+}
+
+// Policy describes how the Profiles methods are authenticated.
+// Which profiles and fields the caller may access is checked by the service.
+var Policy = authn.Policy{
+    ssov1.Profiles_GetProfile_FullMethodName:    {Scope: models.ScopeProfileRead},
+    ssov1.Profiles_UpdateProfile_FullMethodName: {Scope: models.ScopeProfileWrite},
+}
+
+const (
+    emptyValue = 0
+)
+
+func (s *serverAPI) GetProfile(
+    ctx context.Context,
+    req *ssov1.GetProfileRequest,
+) (*ssov1.GetProfileResponse, error) {
+    caller, userID, err := profileOwner(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    p, err := s.profiles.Profile(ctx, caller, userID, req.GetReadMask().GetPaths())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp, err := toProfile(p)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.GetProfileResponse{
+        Profile: resp,
+    }, nil
+}
+
+func (s *serverAPI) UpdateProfile(
+    ctx context.Context,
+    req *ssov1.UpdateProfileRequest,
+) (*ssov1.UpdateProfileResponse, error) {
+    caller, userID, err := profileOwner(ctx, req.GetUserId())
+    if err != nil {
+        return nil, err
+    }
+
+    if len(req.GetUpdateMask().GetPaths()) == 0 {
+        return nil, status.Error(codes.InvalidArgument, "update_mask is required")
+    }
+
+    p, err := s.profiles.UpdateProfile(ctx, caller, userID, req.GetUpdateMask().GetPaths(), fromProfile(req.GetProfile()))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp, err := toProfile(p)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "internal error")
+    }
+
+    return &ssov1.UpdateProfileResponse{
+        Profile: resp,
+    }, nil
+}
+
+// profileOwner returns the caller and the user whose profile the call acts on:
+// the given user or, if there is none, the caller itself.
+func profileOwner(ctx context.Context, userID int64) (models.Principal, int64, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return models.Principal{}, 0, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    if userID != emptyValue {
+        return caller.Principal, userID, nil
+    }
+
+    if !caller.Principal.IsUser() {
+        return models.Principal{}, 0, status.Error(codes.InvalidArgument, "user_id is required")
+    }
+
+    return caller.Principal, caller.Principal.ID, nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, profile.ErrForbidden):
+        return status.Error(codes.PermissionDenied, "not allowed to access the profile fields")
+    case errors.Is(err, profile.ErrUserNotFound):
+        return status.Error(codes.NotFound, "user not found")
+    case errors.Is(err, profile.ErrMaskRequired):
+        return status.Error(codes.InvalidArgument, "update_mask is required")
+    case errors.Is(err, profile.ErrInvalidPath),
+        errors.Is(err, profile.ErrInvalidValue),
+        errors.Is(err, profile.ErrNamespaceSize):
+        // The messages name the path or the field and what is expected of it.
+        return status.Error(codes.InvalidArgument, errors.Unwrap(err).Error())
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toProfile(p models.Profile) (*ssov1.Profile, error) {
+    resp := &ssov1.Profile{
+        DisplayName: p.DisplayName,
+        GivenName:   p.GivenName,
+        FamilyName:  p.FamilyName,
+        Locale:      p.Locale,
+        Timezone:    p.Timezone,
+        AvatarUrl:   p.AvatarURL,
+    }
+
+    if len(p.Attributes) > 0 {
+        resp.Attributes = make(map[string]*structpb.Struct, len(p.Attributes))
+    }
+    for namespace, attrs := range p.Attributes {
+        s, err := structpb.NewStruct(attrs)
+        if err != nil {
+            return nil, err
+        }
+        resp.Attributes[namespace] = s
+    }
+
+    return resp, nil
+}
+
+func fromProfile(p *ssov1.Profile) models.Profile {
+    profile := models.Profile{
+        DisplayName: p.GetDisplayName(),
+        GivenName:   p.GetGivenName(),
+        FamilyName:  p.GetFamilyName(),
+        Locale:      p.GetLocale(),
+        Timezone:    p.GetTimezone(),
+        AvatarURL:   p.GetAvatarUrl(),
+    }
+
+    if len(p.GetAttributes()) > 0 {
+        profile.Attributes = make(models.Attributes, len(p.GetAttributes()))
+    }
+    for namespace, attrs := range p.GetAttributes() {
+        profile.Attributes[namespace] = attrs.AsMap()
+    }
+
+    return profile
+}
@@ -0,0 +1,217 @@
+package serviceaccounts
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/auth"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ServiceAccounts interface {
+    CreateServiceAccount(ctx context.Context,
+        name string,
+        owner models.Owner,
+        roles []string,
+    ) (secret string, sa models.ServiceAccount, err error)
+    ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error)
+    ListServiceAccounts(ctx context.Context, owner models.Owner) ([]models.ServiceAccount, error)
+    SetServiceAccountRoles(ctx context.Context, id int64, roles []string) error
+    RotateServiceAccountSecret(ctx context.Context, id int64) (secret string, err error)
+    DisableServiceAccount(ctx context.Context, id int64) error
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedServiceAccountsServer
+    accounts ServiceAccounts
+}
+
+func Register(gRPC *grpc.Server, accounts ServiceAccounts) {
+    ssov1.RegisterServiceAccountsServer(gRPC, &serverAPI{accounts: accounts})
+}
+
+// Policy describes how the ServiceAccounts methods are authenticated.
+var Policy = authn.Policy{
+    ssov1.ServiceAccounts_CreateServiceAccount_FullMethodName:       {Scope: models.ScopeServiceAccountsWrite, Role: models.RoleAdmin},
+    ssov1.ServiceAccounts_GetServiceAccount_FullMethodName:          {Scope: models.ScopeServiceAccountsRead, Role: models.RoleAdmin},
+    ssov1.ServiceAccounts_ListServiceAccounts_FullMethodName:        {Scope: models.ScopeServiceAccountsRead, Role: models.RoleAdmin},
+    ssov1.ServiceAccounts_SetServiceAccountRoles_FullMethodName:     {Scope: models.ScopeServiceAccountsWrite, Role: models.RoleAdmin},
+    ssov1.ServiceAccounts_RotateServiceAccountSecret_FullMethodName: {Scope: models.ScopeServiceAccountsWrite, Role: models.RoleAdmin},
+    ssov1.ServiceAccounts_DisableServiceAccount_FullMethodName:      {Scope: models.ScopeServiceAccountsWrite, Role: models.RoleAdmin},
+}
+
+const (
+    emptyValue = 0
+)
+
+func (s *serverAPI) CreateServiceAccount(
+    ctx context.Context,
+    req *ssov1.CreateServiceAccountRequest,
+) (*ssov1.CreateServiceAccountResponse, error) {
+    if req.GetName() == "" {
+        return nil, status.Error(codes.InvalidArgument, "name is required")
+    }
+
+    owner, err := toOwner(req.GetOwnerKind(), req.GetOwner())
+    if err != nil {
+        return nil, err
+    }
+    if owner.Kind == "" {
+        return nil, status.Error(codes.InvalidArgument, "owner is required")
+    }
+
+    secret, sa, err := s.accounts.CreateServiceAccount(ctx, req.GetName(), owner, req.GetRoles())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.CreateServiceAccountResponse{
+        ServiceAccount: toServiceAccount(sa),
+        ClientSecret:   secret,
+    }, nil
+}
+
+func (s *serverAPI) GetServiceAccount(
+    ctx context.Context,
+    req *ssov1.GetServiceAccountRequest,
+) (*ssov1.GetServiceAccountResponse, error) {
+    if req.GetServiceAccountId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "service_account_id is required")
+    }
+
+    sa, err := s.accounts.ServiceAccount(ctx, req.GetServiceAccountId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.GetServiceAccountResponse{
+        ServiceAccount: toServiceAccount(sa),
+    }, nil
+}
+
+func (s *serverAPI) ListServiceAccounts(
+    ctx context.Context,
+    req *ssov1.ListServiceAccountsRequest,
+) (*ssov1.ListServiceAccountsResponse, error) {
+    owner, err := toOwner(req.GetOwnerKind(), req.GetOwner())
+    if err != nil {
+        return nil, err
+    }
+
+    accounts, err := s.accounts.ListServiceAccounts(ctx, owner)
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListServiceAccountsResponse{
+        ServiceAccounts: make([]*ssov1.ServiceAccount, 0, len(accounts)),
+    }
+    for _, sa := range accounts {
+        resp.ServiceAccounts = append(resp.ServiceAccounts, toServiceAccount(sa))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) SetServiceAccountRoles(
+    ctx context.Context,
+    req *ssov1.SetServiceAccountRolesRequest,
+) (*ssov1.SetServiceAccountRolesResponse, error) {
+    if req.GetServiceAccountId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "service_account_id is required")
+    }
+
+    if err := s.accounts.SetServiceAccountRoles(ctx, req.GetServiceAccountId(), req.GetRoles()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.SetServiceAccountRolesResponse{}, nil
+}
+
+func (s *serverAPI) RotateServiceAccountSecret(
+    ctx context.Context,
+    req *ssov1.RotateServiceAccountSecretRequest,
+) (*ssov1.RotateServiceAccountSecretResponse, error) {
+    if req.GetServiceAccountId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "service_account_id is required")
+    }
+
+    secret, err := s.accounts.RotateServiceAccountSecret(ctx, req.GetServiceAccountId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RotateServiceAccountSecretResponse{
+        ClientSecret: secret,
+    }, nil
+}
+
+func (s *serverAPI) DisableServiceAccount(
+    ctx context.Context,
+    req *ssov1.DisableServiceAccountRequest,
+) (*ssov1.DisableServiceAccountResponse, error) {
+    if req.GetServiceAccountId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "service_account_id is required")
+    }
+
+    if err := s.accounts.DisableServiceAccount(ctx, req.GetServiceAccountId()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.DisableServiceAccountResponse{}, nil
+}
+
+// toOwner validates the owner of a request. Both fields empty is a zero owner.
+func toOwner(kind, name string) (models.Owner, error) {
+    if kind == "" && name == "" {
+        return models.Owner{}, nil
+    }
+
+    switch models.OwnerKind(kind) {
+    case models.OwnerApp, models.OwnerTeam:
+    default:
+        return models.Owner{}, status.Error(codes.InvalidArgument, "owner_kind must be app or team")
+    }
+
+    if name == "" {
+        return models.Owner{}, status.Error(codes.InvalidArgument, "owner is required")
+    }
+
+    return models.Owner{Kind: models.OwnerKind(kind), Name: name}, nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, auth.ErrInvalidRole):
+        return status.Error(codes.InvalidArgument, "invalid role")
+    case errors.Is(err, auth.ErrServiceAccountExists):
+        return status.Error(codes.AlreadyExists, "service account already exists")
+    case errors.Is(err, auth.ErrServiceAccountNotFound):
+        return status.Error(codes.NotFound, "service account not found")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toServiceAccount(sa models.ServiceAccount) *ssov1.ServiceAccount {
+    account := &ssov1.ServiceAccount{
+        Id:        sa.ID,
+        Name:      sa.Name,
+        OwnerKind: string(sa.Owner.Kind),
+        Owner:     sa.Owner.Name,
+        ClientId:  sa.ClientID,
+        Roles:     sa.Roles,
+        CreatedAt: timestamppb.New(sa.CreatedAt),
+    }
+    if !sa.DisabledAt.IsZero() {
+        account.DisabledAt = timestamppb.New(sa.DisabledAt)
+    }
+
+    return account
+}
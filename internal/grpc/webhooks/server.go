@@ -0,0 +1,401 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/grpc/authn"
+	"grpc-service-ref/internal/services/webhooks"
+
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Webhooks interface {
+    CreateSubscription(ctx context.Context,
+        caller models.Principal,
+        app string,
+        url string,
+        eventTypes []string,
+    ) (models.WebhookSubscription, error)
+    Subscription(ctx context.Context, caller models.Principal, id int64) (models.WebhookSubscription, error)
+    Subscriptions(ctx context.Context, caller models.Principal, app string) ([]models.WebhookSubscription, error)
+    UpdateSubscription(ctx context.Context,
+        caller models.Principal,
+        id int64,
+        url string,
+        eventTypes []string,
+    ) (models.WebhookSubscription, error)
+    SetSubscriptionEnabled(ctx context.Context,
+        caller models.Principal,
+        id int64,
+        enabled bool,
+    ) (models.WebhookSubscription, error)
+    RotateSecret(ctx context.Context, caller models.Principal, id int64) (models.WebhookSubscription, error)
+    DeleteSubscription(ctx context.Context, caller models.Principal, id int64) error
+    Deliveries(ctx context.Context,
+        caller models.Principal,
+        subscriptionID int64,
+        pageToken string,
+        pageSize int,
+    ) (deliveries []models.WebhookDelivery, nextPageToken string, err error)
+    Delivery(ctx context.Context,
+        caller models.Principal,
+        id int64,
+    ) (models.WebhookDelivery, []models.WebhookAttempt, error)
+    ReplayDelivery(ctx context.Context, caller models.Principal, id int64) (models.WebhookDelivery, error)
+}
+
+type serverAPI struct {
+    ssov1.UnimplementedWebhooksServer
+    webhooks Webhooks
+}
+
+func Register(gRPC *grpc.Server, webhooks Webhooks) {
+    ssov1.RegisterWebhooksServer(gRPC, &serverAPI{webhooks: webhooks})
+}
+
+// Policy describes how the Webhooks methods are authenticated.
+// Which apps the caller may manage is checked by the service.
+var Policy = authn.Policy{
+    ssov1.Webhooks_CreateWebhookSubscription_FullMethodName:     {Scope: models.ScopeWebhooksWrite},
+    ssov1.Webhooks_GetWebhookSubscription_FullMethodName:        {Scope: models.ScopeWebhooksRead},
+    ssov1.Webhooks_ListWebhookSubscriptions_FullMethodName:      {Scope: models.ScopeWebhooksRead},
+    ssov1.Webhooks_UpdateWebhookSubscription_FullMethodName:     {Scope: models.ScopeWebhooksWrite},
+    ssov1.Webhooks_SetWebhookSubscriptionEnabled_FullMethodName: {Scope: models.ScopeWebhooksWrite},
+    ssov1.Webhooks_RotateWebhookSecret_FullMethodName:           {Scope: models.ScopeWebhooksWrite},
+    ssov1.Webhooks_DeleteWebhookSubscription_FullMethodName:     {Scope: models.ScopeWebhooksWrite},
+    ssov1.Webhooks_ListWebhookDeliveries_FullMethodName:         {Scope: models.ScopeWebhooksRead},
+    ssov1.Webhooks_GetWebhookDelivery_FullMethodName:            {Scope: models.ScopeWebhooksRead},
+    ssov1.Webhooks_ReplayWebhookDelivery_FullMethodName:         {Scope: models.ScopeWebhooksWrite},
+}
+
+const (
+    emptyValue = 0
+)
+
+func (s *serverAPI) CreateWebhookSubscription(
+    ctx context.Context,
+    req *ssov1.CreateWebhookSubscriptionRequest,
+) (*ssov1.CreateWebhookSubscriptionResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetApp() == "" {
+        return nil, status.Error(codes.InvalidArgument, "app is required")
+    }
+
+    sub, err := s.webhooks.CreateSubscription(ctx, caller, req.GetApp(), req.GetUrl(), req.GetEventTypes())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.CreateWebhookSubscriptionResponse{
+        Subscription: toSubscription(sub),
+        Secret:       sub.Secret,
+    }, nil
+}
+
+func (s *serverAPI) GetWebhookSubscription(
+    ctx context.Context,
+    req *ssov1.GetWebhookSubscriptionRequest,
+) (*ssov1.GetWebhookSubscriptionResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSubscriptionId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "subscription_id is required")
+    }
+
+    sub, err := s.webhooks.Subscription(ctx, caller, req.GetSubscriptionId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.GetWebhookSubscriptionResponse{
+        Subscription: toSubscription(sub),
+    }, nil
+}
+
+func (s *serverAPI) ListWebhookSubscriptions(
+    ctx context.Context,
+    req *ssov1.ListWebhookSubscriptionsRequest,
+) (*ssov1.ListWebhookSubscriptionsResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetApp() == "" {
+        return nil, status.Error(codes.InvalidArgument, "app is required")
+    }
+
+    subs, err := s.webhooks.Subscriptions(ctx, caller, req.GetApp())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListWebhookSubscriptionsResponse{
+        Subscriptions: make([]*ssov1.WebhookSubscription, 0, len(subs)),
+    }
+    for _, sub := range subs {
+        resp.Subscriptions = append(resp.Subscriptions, toSubscription(sub))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) UpdateWebhookSubscription(
+    ctx context.Context,
+    req *ssov1.UpdateWebhookSubscriptionRequest,
+) (*ssov1.UpdateWebhookSubscriptionResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSubscriptionId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "subscription_id is required")
+    }
+
+    sub, err := s.webhooks.UpdateSubscription(ctx, caller, req.GetSubscriptionId(), req.GetUrl(), req.GetEventTypes())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.UpdateWebhookSubscriptionResponse{
+        Subscription: toSubscription(sub),
+    }, nil
+}
+
+func (s *serverAPI) SetWebhookSubscriptionEnabled(
+    ctx context.Context,
+    req *ssov1.SetWebhookSubscriptionEnabledRequest,
+) (*ssov1.SetWebhookSubscriptionEnabledResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSubscriptionId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "subscription_id is required")
+    }
+
+    sub, err := s.webhooks.SetSubscriptionEnabled(ctx, caller, req.GetSubscriptionId(), req.GetEnabled())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.SetWebhookSubscriptionEnabledResponse{
+        Subscription: toSubscription(sub),
+    }, nil
+}
+
+func (s *serverAPI) RotateWebhookSecret(
+    ctx context.Context,
+    req *ssov1.RotateWebhookSecretRequest,
+) (*ssov1.RotateWebhookSecretResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSubscriptionId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "subscription_id is required")
+    }
+
+    sub, err := s.webhooks.RotateSecret(ctx, caller, req.GetSubscriptionId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.RotateWebhookSecretResponse{
+        Secret: sub.Secret,
+    }, nil
+}
+
+func (s *serverAPI) DeleteWebhookSubscription(
+    ctx context.Context,
+    req *ssov1.DeleteWebhookSubscriptionRequest,
+) (*ssov1.DeleteWebhookSubscriptionResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSubscriptionId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "subscription_id is required")
+    }
+
+    if err := s.webhooks.DeleteSubscription(ctx, caller, req.GetSubscriptionId()); err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.DeleteWebhookSubscriptionResponse{}, nil
+}
+
+func (s *serverAPI) ListWebhookDeliveries(
+    ctx context.Context,
+    req *ssov1.ListWebhookDeliveriesRequest,
+) (*ssov1.ListWebhookDeliveriesResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetSubscriptionId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "subscription_id is required")
+    }
+    if req.GetPageSize() < 0 {
+        return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+    }
+
+    deliveries, next, err := s.webhooks.Deliveries(ctx, caller,
+        req.GetSubscriptionId(), req.GetPageToken(), int(req.GetPageSize()))
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.ListWebhookDeliveriesResponse{
+        Deliveries:    make([]*ssov1.WebhookDelivery, 0, len(deliveries)),
+        NextPageToken: next,
+    }
+    for _, d := range deliveries {
+        resp.Deliveries = append(resp.Deliveries, toDelivery(d))
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) GetWebhookDelivery(
+    ctx context.Context,
+    req *ssov1.GetWebhookDeliveryRequest,
+) (*ssov1.GetWebhookDeliveryResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetDeliveryId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "delivery_id is required")
+    }
+
+    d, attempts, err := s.webhooks.Delivery(ctx, caller, req.GetDeliveryId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    resp := &ssov1.GetWebhookDeliveryResponse{
+        Delivery: toDelivery(d),
+        Attempts: make([]*ssov1.WebhookAttempt, 0, len(attempts)),
+    }
+    for _, a := range attempts {
+        resp.Attempts = append(resp.Attempts, &ssov1.WebhookAttempt{
+            StatusCode:  int32(a.StatusCode),
+            Error:       a.Error,
+            DurationMs:  a.Duration.Milliseconds(),
+            AttemptedAt: timestamppb.New(a.AttemptedAt),
+        })
+    }
+
+    return resp, nil
+}
+
+func (s *serverAPI) ReplayWebhookDelivery(
+    ctx context.Context,
+    req *ssov1.ReplayWebhookDeliveryRequest,
+) (*ssov1.ReplayWebhookDeliveryResponse, error) {
+    caller, err := callerPrincipal(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if req.GetDeliveryId() == emptyValue {
+        return nil, status.Error(codes.InvalidArgument, "delivery_id is required")
+    }
+
+    d, err := s.webhooks.ReplayDelivery(ctx, caller, req.GetDeliveryId())
+    if err != nil {
+        return nil, toStatus(err)
+    }
+
+    return &ssov1.ReplayWebhookDeliveryResponse{
+        Delivery: toDelivery(d),
+    }, nil
+}
+
+func callerPrincipal(ctx context.Context) (models.Principal, error) {
+    caller, ok := authn.CallerFromContext(ctx)
+    if !ok {
+        return models.Principal{}, status.Error(codes.Unauthenticated, "authorization token is required")
+    }
+
+    return caller.Principal, nil
+}
+
+func toStatus(err error) error {
+    switch {
+    case errors.Is(err, webhooks.ErrForbidden):
+        return status.Error(codes.PermissionDenied, "not allowed to manage webhooks of the app")
+    case errors.Is(err, webhooks.ErrSubscriptionNotFound):
+        return status.Error(codes.NotFound, "webhook subscription not found")
+    case errors.Is(err, webhooks.ErrDeliveryNotFound):
+        return status.Error(codes.NotFound, "webhook delivery not found")
+    case errors.Is(err, webhooks.ErrInvalidURL):
+        return status.Error(codes.InvalidArgument, "url must be an absolute http or https url")
+    case errors.Is(err, webhooks.ErrInvalidEventType):
+        return status.Error(codes.InvalidArgument, "event_types must be known domain event types")
+    case errors.Is(err, webhooks.ErrInvalidPageToken):
+        return status.Error(codes.InvalidArgument, "invalid page token")
+    case errors.Is(err, webhooks.ErrSubscriptionDisabled):
+        return status.Error(codes.FailedPrecondition, "webhook subscription is disabled")
+    default:
+        return status.Error(codes.Internal, "internal error")
+    }
+}
+
+func toSubscription(sub models.WebhookSubscription) *ssov1.WebhookSubscription {
+    s := &ssov1.WebhookSubscription{
+        Id:                  sub.ID,
+        App:                 sub.App,
+        Url:                 sub.URL,
+        EventTypes:          sub.EventTypes,
+        ConsecutiveFailures: int32(sub.ConsecutiveFailures),
+        CreatedAt:           timestamppb.New(sub.CreatedAt),
+    }
+    if !sub.DisabledAt.IsZero() {
+        s.DisabledAt = timestamppb.New(sub.DisabledAt)
+    }
+
+    return s
+}
+
+func toDelivery(d models.WebhookDelivery) *ssov1.WebhookDelivery {
+    delivery := &ssov1.WebhookDelivery{
+        Id:             d.ID,
+        SubscriptionId: d.SubscriptionID,
+        EventId:        d.EventID,
+        EventType:      d.EventType,
+        Status:         string(d.Status),
+        Attempts:       int32(d.Attempts),
+        LastStatusCode: int32(d.LastStatusCode),
+        LastError:      d.LastError,
+        CreatedAt:      timestamppb.New(d.CreatedAt),
+        ReplayOf:       d.ReplayOf,
+    }
+    if d.Status == models.WebhookDeliveryPending && !d.NextAttemptAt.IsZero() {
+        delivery.NextAttemptAt = timestamppb.New(d.NextAttemptAt)
+    }
+    if !d.DeliveredAt.IsZero() {
+        delivery.DeliveredAt = timestamppb.New(d.DeliveredAt)
+    }
+
+    return delivery
+}
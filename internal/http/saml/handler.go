@@ -0,0 +1,133 @@
+package saml
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/services/saml"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type SAML interface {
+    Metadata() ([]byte, error)
+    AuthnRequestURL(relayState string) (string, error)
+    Login(ctx context.Context, samlResponse string, client models.ClientInfo) (token string, err error)
+}
+
+// tokenCookie is the cookie the token of a SAML login is set in.
+const tokenCookie = "token"
+
+type handler struct {
+    saml SAML
+}
+
+// Register registers the service provider endpoints on the mux.
+func Register(mux *http.ServeMux, saml SAML) {
+    h := &handler{saml: saml}
+
+    mux.HandleFunc("GET /saml/metadata", h.metadata)
+    mux.HandleFunc("GET /saml/login", h.login)
+    mux.HandleFunc("POST /saml/acs", h.acs)
+}
+
+func (h *handler) metadata(w http.ResponseWriter, r *http.Request) {
+    buf, err := h.saml.Metadata()
+    if err != nil {
+        http.Error(w, "internal error", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/samlmetadata+xml")
+    w.Write(buf)
+}
+
+func (h *handler) login(w http.ResponseWriter, r *http.Request) {
+    redirectURL, err := h.saml.AuthnRequestURL(r.URL.Query().Get("relay_state"))
+    if err != nil {
+        http.Error(w, "internal error", http.StatusInternalServerError)
+        return
+    }
+
+    http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// acs consumes the SAML response the identity provider posts and logs its user in.
+// The token is set in the tokenCookie cookie for browsers, which are redirected to the relay state
+// if it is a local path, and is returned in the JSON body otherwise. The cookie lasts for the
+// browser session, the token expires by itself.
+func (h *handler) acs(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "invalid form", http.StatusBadRequest)
+        return
+    }
+
+    samlResponse := r.PostForm.Get("SAMLResponse")
+    if samlResponse == "" {
+        http.Error(w, "SAMLResponse is required", http.StatusBadRequest)
+        return
+    }
+
+    token, err := h.saml.Login(r.Context(), samlResponse, clientInfo(r))
+    if err != nil {
+        switch {
+        case errors.Is(err, saml.ErrInvalidAssertion), errors.Is(err, saml.ErrAssertionReplayed):
+            http.Error(w, "invalid assertion", http.StatusUnauthorized)
+        case errors.Is(err, saml.ErrUserNotProvisioned):
+            http.Error(w, "user is not provisioned", http.StatusForbidden)
+        case errors.Is(err, auth.ErrUserPending):
+            http.Error(w, "user is pending verification", http.StatusForbidden)
+        case errors.Is(err, auth.ErrUserSuspended):
+            http.Error(w, "user is suspended", http.StatusForbidden)
+        case errors.Is(err, auth.ErrUserLocked):
+            http.Error(w, "user is locked", http.StatusForbidden)
+        case errors.Is(err, auth.ErrUserDeleted):
+            http.Error(w, "user is deleted", http.StatusForbidden)
+        default:
+            http.Error(w, "internal error", http.StatusInternalServerError)
+        }
+        return
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     tokenCookie,
+        Value:    token,
+        Path:     "/",
+        HttpOnly: true,
+        Secure:   true,
+        SameSite: http.SameSiteLaxMode,
+    })
+
+    if relayState := r.PostForm.Get("RelayState"); isLocalPath(relayState) {
+        http.Redirect(w, r, relayState, http.StatusSeeOther)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(struct {
+        Token string `json:"token"`
+    }{Token: token})
+}
+
+// clientInfo describes the caller by its user agent and peer address.
+func clientInfo(r *http.Request) models.ClientInfo {
+    ip := r.RemoteAddr
+    if host, _, err := net.SplitHostPort(ip); err == nil {
+        ip = host
+    }
+
+    return models.ClientInfo{
+        UserAgent: r.UserAgent(),
+        IP:        ip,
+    }
+}
+
+// isLocalPath reports whether the relay state can be followed without an open redirect.
+func isLocalPath(relayState string) bool {
+    return strings.HasPrefix(relayState, "/") &&
+        !strings.HasPrefix(relayState, "//") &&
+        !strings.HasPrefix(relayState, "/\\")
+}
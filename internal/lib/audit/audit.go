@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"log/slog"
+	"time"
+)
+
+var ErrChainBroken = errors.New("audit chain is broken")
+
+type Store interface {
+    AppendAuditEvent(ctx context.Context, event models.AuditEvent) (models.AuditEvent, error)
+}
+
+// Publisher receives events once they are stored. Publish must not block.
+type Publisher interface {
+    Publish(event models.AuditEvent)
+}
+
+// Recorder writes audit events to the store.
+type Recorder struct {
+    log        *slog.Logger
+    store      Store
+    publishers []Publisher
+}
+
+// New returns a recorder writing to the store and publishing stored events to the publishers.
+func New(log *slog.Logger, store Store, publishers ...Publisher) *Recorder {
+    return &Recorder{
+        log:        log,
+        store:      store,
+        publishers: publishers,
+    }
+}
+
+// Record writes the event. The actor and client of the request are taken from the context
+// unless the event sets them. Failures are logged, they do not fail the audited operation.
+func (r *Recorder) Record(ctx context.Context, event models.AuditEvent) {
+    const op = "audit.Record"
+
+    md := fromContext(ctx)
+    if event.Actor == "" {
+        event.Actor = md.actor
+    }
+    if event.IP == "" && event.UserAgent == "" {
+        event.IP = md.client.IP
+        event.UserAgent = md.client.UserAgent
+    }
+    if event.Outcome == "" {
+        event.Outcome = models.OutcomeSuccess
+    }
+    if event.CreatedAt.IsZero() {
+        event.CreatedAt = time.Now()
+    }
+
+    // The event is written even if the request is canceled meanwhile.
+    stored, err := r.store.AppendAuditEvent(context.WithoutCancel(ctx), event)
+    if err != nil {
+        r.log.Error("failed to record audit event",
+            slog.String("op", op),
+            slog.String("type", event.Type),
+            slog.String("actor", event.Actor),
+            slog.String("subject", event.Subject),
+            slog.String("err", err.Error()),
+        )
+        return
+    }
+
+    for _, p := range r.publishers {
+        p.Publish(stored)
+    }
+}
+
+// Verify checks that the events, ordered oldest first, form an unbroken hash chain.
+// The first event may continue a chain that starts before it.
+func Verify(events []models.AuditEvent) error {
+    for i, event := range events {
+        if i > 0 && !bytes.Equal(event.PrevHash, events[i-1].Hash) {
+            return fmt.Errorf("%w: event %d does not follow event %d", ErrChainBroken, event.ID, events[i-1].ID)
+        }
+        if !bytes.Equal(event.Hash, event.ComputeHash()) {
+            return fmt.Errorf("%w: event %d was modified", ErrChainBroken, event.ID)
+        }
+    }
+
+    return nil
+}
+
+type metadataKey struct{}
+
+type metadata struct {
+    actor  string
+    client models.ClientInfo
+}
+
+// WithActor returns a context whose audit events are attributed to the actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+    md := fromContext(ctx)
+    md.actor = actor
+    return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// WithClient returns a context whose audit events are attributed to the client.
+func WithClient(ctx context.Context, client models.ClientInfo) context.Context {
+    md := fromContext(ctx)
+    md.client = client
+    return context.WithValue(ctx, metadataKey{}, md)
+}
+
+func fromContext(ctx context.Context) metadata {
+    md, _ := ctx.Value(metadataKey{}).(metadata)
+    return md
+}
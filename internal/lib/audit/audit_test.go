@@ -0,0 +1,184 @@
+package audit_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord_ChainsEvents(t *testing.T) {
+    store := &chainStore{}
+    recorder := audit.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+
+    ctx := audit.WithClient(context.Background(), models.ClientInfo{IP: "10.0.0.1", UserAgent: "test"})
+    ctx = audit.WithActor(ctx, "user:1")
+
+    recorder.Record(ctx, models.AuditEvent{Type: models.EventSessionRevoked, Subject: "user:1"})
+    recorder.Record(ctx, models.AuditEvent{Type: models.EventUserRolesChanged, Subject: "user:2",
+        Details: map[string]string{"roles": "admin"}})
+    recorder.Record(ctx, models.AuditEvent{Type: models.EventUserStatusChanged, Actor: models.ActorSystem})
+
+    require.Len(t, store.events, 3)
+    assert.Equal(t, "user:1", store.events[0].Actor)
+    assert.Equal(t, "10.0.0.1", store.events[0].IP)
+    assert.Equal(t, "test", store.events[0].UserAgent)
+    assert.Equal(t, models.OutcomeSuccess, store.events[0].Outcome)
+    assert.Equal(t, models.ActorSystem, store.events[2].Actor)
+    require.NoError(t, audit.Verify(store.events))
+}
+
+func TestRecord_PublishesStoredEvents(t *testing.T) {
+    store := &chainStore{}
+    published := &eventLog{}
+    recorder := audit.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, published)
+
+    recorder.Record(context.Background(), models.AuditEvent{Type: models.EventSessionRevoked, Subject: "user:1"})
+
+    require.Len(t, published.events, 1)
+    assert.Equal(t, store.events, published.events)
+    assert.Equal(t, int64(1), published.events[0].ID)
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+    tests := []struct {
+        name   string
+        tamper func(events []models.AuditEvent) []models.AuditEvent
+    }{
+        {
+            name: "Modified field",
+            tamper: func(events []models.AuditEvent) []models.AuditEvent {
+                events[1].Subject = "user:3"
+                return events
+            },
+        },
+        {
+            name: "Modified details",
+            tamper: func(events []models.AuditEvent) []models.AuditEvent {
+                events[1].Details["roles"] = "support"
+                return events
+            },
+        },
+        {
+            name: "Removed event",
+            tamper: func(events []models.AuditEvent) []models.AuditEvent {
+                return append(events[:1], events[2:]...)
+            },
+        },
+        {
+            name: "Reordered events",
+            tamper: func(events []models.AuditEvent) []models.AuditEvent {
+                events[1], events[2] = events[2], events[1]
+                return events
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            store := &chainStore{}
+            recorder := audit.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+            for _, subject := range []string{"user:1", "user:2", "user:1"} {
+                recorder.Record(context.Background(), models.AuditEvent{
+                    Type:    models.EventUserRolesChanged,
+                    Subject: subject,
+                    Details: map[string]string{"roles": "admin"},
+                })
+            }
+            require.NoError(t, audit.Verify(store.events))
+
+            err := audit.Verify(tt.tamper(store.events))
+            require.ErrorIs(t, err, audit.ErrChainBroken)
+        })
+    }
+}
+
+func TestSeal(t *testing.T) {
+    event := models.AuditEvent{
+        Type:      models.EventUserRegistered,
+        Actor:     "user:1",
+        Subject:   "user:1",
+        IP:        "10.0.0.1",
+        UserAgent: "test",
+        Outcome:   models.OutcomeSuccess,
+        Details:   map[string]string{"email": "user@example.com", "invite_code_id": "3"},
+        CreatedAt: time.Now(),
+    }
+    assert.Equal(t, map[string]string{
+        models.SealedIP:                     "user:1",
+        models.SealedUserAgent:              "user:1",
+        models.SealedDetailPrefix + "email": "user:1",
+    }, audit.KeyRefs(event))
+
+    key, err := audit.NewKey()
+    require.NoError(t, err)
+    keys := map[string][]byte{"user:1": key}
+
+    sealed, err := audit.Seal(event, keys)
+    require.NoError(t, err)
+    assert.Empty(t, sealed.IP)
+    assert.Empty(t, sealed.UserAgent)
+    assert.Equal(t, map[string]string{"invite_code_id": "3"}, sealed.Details)
+    assert.Equal(t, []string{"user:1"}, audit.SealedRefs(sealed))
+    sealed.Hash = sealed.ComputeHash()
+
+    opened := audit.Open(sealed, keys)
+    assert.Equal(t, event.IP, opened.IP)
+    assert.Equal(t, event.UserAgent, opened.UserAgent)
+    assert.Equal(t, event.Details, opened.Details)
+    require.NoError(t, audit.Verify([]models.AuditEvent{opened}))
+
+    erased := audit.Open(sealed, nil)
+    assert.Empty(t, erased.IP)
+    assert.Equal(t, map[string]string{"invite_code_id": "3"}, erased.Details)
+    require.NoError(t, audit.Verify([]models.AuditEvent{erased}), "the hash survives erasure")
+
+    _, err = audit.Seal(event, nil)
+    require.ErrorIs(t, err, audit.ErrMissingKey)
+}
+
+func TestKeyRefs_EventAboutNoUser(t *testing.T) {
+    event := models.AuditEvent{
+        Type:    models.EventLoginFailed,
+        IP:      "10.0.0.1",
+        Details: map[string]string{"phone": "+14155550123"},
+    }
+    ref := audit.IdentifierRef("phone", "+14155550123")
+    assert.Equal(t, map[string]string{
+        models.SealedIP:                     ref,
+        models.SealedDetailPrefix + "phone": ref,
+    }, audit.KeyRefs(event))
+    assert.Contains(t, audit.ErasureRefs(1, models.UserIdentifiers{Phone: "+14155550123"}), ref)
+
+    assert.Empty(t, audit.KeyRefs(models.AuditEvent{Type: models.EventServiceAccountLogin, Actor: "service_account:1", IP: "10.0.0.1"}))
+}
+
+// chainStore appends events the way the database does.
+type chainStore struct {
+    events []models.AuditEvent
+}
+
+func (s *chainStore) AppendAuditEvent(_ context.Context, event models.AuditEvent) (models.AuditEvent, error) {
+    event.ID = int64(len(s.events) + 1)
+    if len(s.events) > 0 {
+        event.PrevHash = s.events[len(s.events)-1].Hash
+    }
+    event.Hash = event.ComputeHash()
+    s.events = append(s.events, event)
+    return event, nil
+}
+
+type eventLog struct {
+    events []models.AuditEvent
+}
+
+func (l *eventLog) Publish(event models.AuditEvent) {
+    l.events = append(l.events, event)
+}
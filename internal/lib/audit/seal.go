@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// KeySize is the size of the keys sealing personal data in bytes.
+const KeySize = 32
+
+var (
+    ErrMissingKey = errors.New("missing sealing key")
+    ErrMalformed  = errors.New("malformed sealed value")
+)
+
+// NewKey returns a new key sealing personal data.
+func NewKey() ([]byte, error) {
+    key := make([]byte, KeySize)
+    if _, err := rand.Read(key); err != nil {
+        return nil, err
+    }
+    return key, nil
+}
+
+// IdentifierRef returns the reference of the key sealing an identifier of the kind, one of
+// models.PersonalDetails, in events about no user, such as failed logins with unknown identifiers.
+// Identifiers are compared case-insensitively, as the storage compares them.
+func IdentifierRef(kind, value string) string {
+    sum := sha256.Sum256([]byte(strings.ToLower(value)))
+    return kind + ":" + hex.EncodeToString(sum[:])
+}
+
+// ErasureRefs returns the references of the keys erasing the user destroys: the key of the
+// user and the keys of its identifiers.
+func ErasureRefs(userID int64, ids models.UserIdentifiers) []string {
+    refs := []string{models.Principal{Type: models.PrincipalUser, ID: userID}.String()}
+    for kind, value := range map[string]string{"email": ids.Email, "username": ids.Username, "phone": ids.Phone} {
+        if value != "" {
+            refs = append(refs, IdentifierRef(kind, value))
+        }
+    }
+    slices.Sort(refs[1:])
+    return refs
+}
+
+// KeyRefs returns the references of the keys sealing the personal data of the event by field.
+// The client of the event belongs to the actor, or to the subject when the actor is no user.
+// Personal details belong to the subject; in events about no user they are sealed with the keys
+// of the identifiers themselves. Fields of events about no user and of no user are not sealed.
+func KeyRefs(event models.AuditEvent) map[string]string {
+    refs := make(map[string]string)
+
+    subject := userRef(event.Subject)
+    for _, kind := range models.PersonalDetails {
+        value, ok := event.Details[kind]
+        if !ok || value == "" {
+            continue
+        }
+        ref := subject
+        if ref == "" {
+            ref = IdentifierRef(kind, value)
+        }
+        refs[models.SealedDetailPrefix+kind] = ref
+    }
+
+    client := userRef(event.Actor)
+    if client == "" {
+        client = subject
+    }
+    if client == "" {
+        // A failed login with an unknown identifier: the client belongs to whoever owns it.
+        for _, kind := range models.PersonalDetails {
+            if ref, ok := refs[models.SealedDetailPrefix+kind]; ok {
+                client = ref
+                break
+            }
+        }
+    }
+    if client != "" {
+        if event.IP != "" {
+            refs[models.SealedIP] = client
+        }
+        if event.UserAgent != "" {
+            refs[models.SealedUserAgent] = client
+        }
+    }
+
+    return refs
+}
+
+// Seal returns the event as stored: its personal data, the fields KeyRefs returns, is moved
+// into Sealed, encrypted with the keys by reference.
+func Seal(event models.AuditEvent, keys map[string][]byte) (models.AuditEvent, error) {
+    refs := KeyRefs(event)
+    if len(refs) == 0 {
+        return event, nil
+    }
+
+    event.Details = maps.Clone(event.Details)
+    event.Sealed = make(map[string]string, len(refs))
+    for field, ref := range refs {
+        key, ok := keys[ref]
+        if !ok {
+            return models.AuditEvent{}, fmt.Errorf("%w: %s", ErrMissingKey, ref)
+        }
+
+        sealed, err := seal(key, field, ref, fieldValue(event, field))
+        if err != nil {
+            return models.AuditEvent{}, err
+        }
+        event.Sealed[field] = ref + "#" + sealed
+        setField(&event, field, "")
+    }
+
+    return event, nil
+}
+
+// SealedRefs returns the references of the keys of the sealed fields of the events.
+func SealedRefs(events ...models.AuditEvent) []string {
+    var refs []string
+    for _, event := range events {
+        for _, sealed := range event.Sealed {
+            if ref, _, ok := cutSealed(sealed); ok && !slices.Contains(refs, ref) {
+                refs = append(refs, ref)
+            }
+        }
+    }
+    slices.Sort(refs)
+    return refs
+}
+
+// Open returns the event with its sealed fields opened with the keys by reference.
+// Fields whose keys were destroyed, and fields that can't be opened, stay empty.
+func Open(event models.AuditEvent, keys map[string][]byte) models.AuditEvent {
+    if len(event.Sealed) == 0 {
+        return event
+    }
+
+    event.Details = maps.Clone(event.Details)
+    if event.Details == nil {
+        event.Details = map[string]string{}
+    }
+    for field, sealed := range event.Sealed {
+        ref, ciphertext, ok := cutSealed(sealed)
+        if !ok {
+            continue
+        }
+        key, ok := keys[ref]
+        if !ok {
+            continue
+        }
+        value, err := open(key, field, ref, ciphertext)
+        if err != nil {
+            continue
+        }
+        setField(&event, field, value)
+    }
+
+    return event
+}
+
+// userRef returns the principal if it is a user and an empty string otherwise.
+func userRef(principal string) string {
+    p, err := models.ParsePrincipal(principal)
+    if err != nil || !p.IsUser() {
+        return ""
+    }
+    return principal
+}
+
+func fieldValue(event models.AuditEvent, field string) string {
+    switch field {
+    case models.SealedIP:
+        return event.IP
+    case models.SealedUserAgent:
+        return event.UserAgent
+    default:
+        return event.Details[strings.TrimPrefix(field, models.SealedDetailPrefix)]
+    }
+}
+
+func setField(event *models.AuditEvent, field, value string) {
+    switch field {
+    case models.SealedIP:
+        event.IP = value
+    case models.SealedUserAgent:
+        event.UserAgent = value
+    default:
+        key := strings.TrimPrefix(field, models.SealedDetailPrefix)
+        if value == "" {
+            delete(event.Details, key)
+        } else {
+            event.Details[key] = value
+        }
+    }
+}
+
+// cutSealed splits a sealed field into the reference of its key and the ciphertext.
+func cutSealed(sealed string) (ref, ciphertext string, ok bool) {
+    i := strings.LastIndexByte(sealed, '#')
+    if i <= 0 {
+        return "", "", false
+    }
+    return sealed[:i], sealed[i+1:], true
+}
+
+// seal encrypts the value with AES-256-GCM, binding it to the field and the key reference.
+func seal(key []byte, field, ref, value string) (string, error) {
+    aead, err := newAEAD(key)
+    if err != nil {
+        return "", err
+    }
+
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    sealed := aead.Seal(nonce, nonce, []byte(value), []byte(field+"|"+ref))
+
+    return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func open(key []byte, field, ref, ciphertext string) (string, error) {
+    aead, err := newAEAD(key)
+    if err != nil {
+        return "", err
+    }
+
+    sealed, err := base64.RawURLEncoding.DecodeString(ciphertext)
+    if err != nil || len(sealed) < aead.NonceSize() {
+        return "", ErrMalformed
+    }
+    nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+    plain, err := aead.Open(nil, nonce, sealed, []byte(field+"|"+ref))
+    if err != nil {
+        return "", ErrMalformed
+    }
+
+    return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"slices"
+	"sync"
+)
+
+var (
+    ErrSlowConsumer = errors.New("subscriber is too slow")
+    ErrClosed       = errors.New("broker is closed")
+)
+
+// Filter selects the events a subscriber receives. Empty lists match any value.
+type Filter struct {
+    // Principals whose events are received, as actors or as subjects.
+    Principals []string
+    // Apps whose events are received, see models.AuditEvent.App.
+    Apps  []string
+    Types []string
+}
+
+// Match reports whether the event passes the filter.
+func (f Filter) Match(event models.AuditEvent) bool {
+    if len(f.Principals) > 0 &&
+        !slices.Contains(f.Principals, event.Actor) && !slices.Contains(f.Principals, event.Subject) {
+        return false
+    }
+    if len(f.Apps) > 0 && !slices.Contains(f.Apps, event.App()) {
+        return false
+    }
+    if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+        return false
+    }
+
+    return true
+}
+
+// Broker fans audit events out to subscribers in the process.
+// Publishing never blocks: every subscriber has its own buffer,
+// and a subscriber whose buffer is full is disconnected with ErrSlowConsumer.
+type Broker struct {
+    mu     sync.Mutex
+    subs   map[*Subscription]struct{}
+    buffer int
+    closed bool
+}
+
+// New returns a broker buffering up to buffer events per subscriber.
+func New(buffer int) *Broker {
+    if buffer <= 0 {
+        buffer = 1
+    }
+
+    return &Broker{
+        subs:   make(map[*Subscription]struct{}),
+        buffer: buffer,
+    }
+}
+
+// Subscribe returns a subscription to the events passing the filter
+// that are published from now on.
+func (b *Broker) Subscribe(filter Filter) (*Subscription, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.closed {
+        return nil, ErrClosed
+    }
+
+    sub := &Subscription{
+        broker: b,
+        filter: filter,
+        events: make(chan models.AuditEvent, b.buffer),
+        done:   make(chan struct{}),
+    }
+    b.subs[sub] = struct{}{}
+
+    return sub, nil
+}
+
+// Publish sends the event to the subscribers whose filter it passes.
+func (b *Broker) Publish(event models.AuditEvent) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    for sub := range b.subs {
+        if !sub.filter.Match(event) {
+            continue
+        }
+        select {
+        case sub.events <- event:
+        default:
+            b.drop(sub, ErrSlowConsumer)
+        }
+    }
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    return len(b.subs)
+}
+
+// Close ends every subscription with ErrClosed and rejects new ones.
+func (b *Broker) Close() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.closed = true
+    for sub := range b.subs {
+        b.drop(sub, ErrClosed)
+    }
+}
+
+// drop ends the subscription with the error. b.mu must be held.
+func (b *Broker) drop(sub *Subscription, err error) {
+    if _, ok := b.subs[sub]; !ok {
+        return
+    }
+    delete(b.subs, sub)
+    sub.err = err
+    close(sub.done)
+}
+
+// Subscription receives the events of a broker until it is closed or dropped.
+type Subscription struct {
+    broker *Broker
+    filter Filter
+    events chan models.AuditEvent
+    done   chan struct{}
+    err    error
+}
+
+// Events returns the channel of the received events.
+func (s *Subscription) Events() <-chan models.AuditEvent {
+    return s.events
+}
+
+// Done returns a channel closed when the subscription ends.
+// Events still buffered at that point are not delivered.
+func (s *Subscription) Done() <-chan struct{} {
+    return s.done
+}
+
+// Err returns why the subscription ended: ErrSlowConsumer, ErrClosed,
+// or nil if it was closed by the subscriber. It is valid once Done is closed.
+func (s *Subscription) Err() error {
+    return s.err
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+    s.broker.mu.Lock()
+    defer s.broker.mu.Unlock()
+
+    s.broker.drop(s, nil)
+}
@@ -0,0 +1,87 @@
+package broker_test
+
+import (
+	"testing"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/broker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublish_Filters(t *testing.T) {
+    b := broker.New(10)
+
+    byUser, err := b.Subscribe(broker.Filter{Principals: []string{"user:1"}})
+    require.NoError(t, err)
+    byApp, err := b.Subscribe(broker.Filter{Apps: []string{"billing"}})
+    require.NoError(t, err)
+    byType, err := b.Subscribe(broker.Filter{Types: []string{models.EventLoginFailed}})
+    require.NoError(t, err)
+
+    b.Publish(models.AuditEvent{ID: 1, Type: models.EventLoginSucceeded, Actor: "user:1", Subject: "user:1"})
+    b.Publish(models.AuditEvent{ID: 2, Type: models.EventUserStatusChanged, Actor: "user:2", Subject: "user:1"})
+    b.Publish(models.AuditEvent{ID: 3, Type: models.EventLoginFailed, Subject: "user:3",
+        Details: map[string]string{models.DetailApp: "billing"}})
+
+    assert.Equal(t, []int64{1, 2}, drain(byUser))
+    assert.Equal(t, []int64{3}, drain(byApp))
+    assert.Equal(t, []int64{3}, drain(byType))
+}
+
+func TestPublish_DropsSlowConsumer(t *testing.T) {
+    b := broker.New(2)
+
+    slow, err := b.Subscribe(broker.Filter{})
+    require.NoError(t, err)
+    fast, err := b.Subscribe(broker.Filter{})
+    require.NoError(t, err)
+
+    for id := int64(1); id <= 3; id++ {
+        b.Publish(models.AuditEvent{ID: id})
+        if id < 3 {
+            <-fast.Events()
+        }
+    }
+
+    <-slow.Done()
+    assert.ErrorIs(t, slow.Err(), broker.ErrSlowConsumer)
+
+    assert.Equal(t, []int64{3}, drain(fast))
+    assert.Equal(t, 1, b.Subscribers())
+}
+
+func TestClose(t *testing.T) {
+    b := broker.New(1)
+
+    sub, err := b.Subscribe(broker.Filter{})
+    require.NoError(t, err)
+    closed, err := b.Subscribe(broker.Filter{})
+    require.NoError(t, err)
+
+    closed.Close()
+    closed.Close()
+    <-closed.Done()
+    assert.NoError(t, closed.Err())
+
+    b.Close()
+    <-sub.Done()
+    assert.ErrorIs(t, sub.Err(), broker.ErrClosed)
+
+    _, err = b.Subscribe(broker.Filter{})
+    assert.ErrorIs(t, err, broker.ErrClosed)
+}
+
+// drain returns the ids of the buffered events of the subscription.
+func drain(sub *broker.Subscription) []int64 {
+    var ids []int64
+    for {
+        select {
+        case event := <-sub.Events():
+            ids = append(ids, event.ID)
+        default:
+            return ids
+        }
+    }
+}
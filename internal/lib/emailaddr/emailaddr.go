@@ -0,0 +1,93 @@
+package emailaddr
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalid = errors.New("invalid email address")
+
+// Normalizer brings email addresses to the form they are stored and looked up in.
+// The zero value is ready to use and applies no provider rules.
+type Normalizer struct {
+    // ProviderRules enables the address rules of well-known mailbox providers, such as
+    // Gmail ignoring dots and "+tag" suffixes, so that aliases of a mailbox normalize
+    // to the same address.
+    ProviderRules bool
+}
+
+// Normalize trims the address, lowercases its domain and converts an internationalized domain
+// to punycode. The case of the local part is kept, as the storage compares addresses case-insensitively.
+func (n Normalizer) Normalize(addr string) (string, error) {
+    addr = strings.TrimSpace(addr)
+
+    at := strings.LastIndexByte(addr, '@')
+    if at <= 0 || at == len(addr)-1 {
+        return "", ErrInvalid
+    }
+    local, domain := addr[:at], addr[at+1:]
+
+    domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+    if err != nil {
+        return "", ErrInvalid
+    }
+    domain = strings.ToLower(domain)
+
+    if n.ProviderRules {
+        local, domain = applyProviderRules(local, domain)
+    }
+
+    normalized := local + "@" + domain
+
+    // Rejects display names, comments and malformed local parts.
+    parsed, err := mail.ParseAddress(normalized)
+    if err != nil || parsed.Address != normalized {
+        return "", ErrInvalid
+    }
+
+    return normalized, nil
+}
+
+type provider struct {
+    // domain is the canonical domain of the provider.
+    domain string
+    // ignoreDots drops dots from the local part.
+    ignoreDots bool
+    // subaddress separates the mailbox from the tag of a subaddress, zero if the provider has none.
+    subaddress byte
+}
+
+var providers = map[string]provider{
+    "gmail.com":      {domain: "gmail.com", ignoreDots: true, subaddress: '+'},
+    "googlemail.com": {domain: "gmail.com", ignoreDots: true, subaddress: '+'},
+    "outlook.com":    {domain: "outlook.com", subaddress: '+'},
+    "hotmail.com":    {domain: "hotmail.com", subaddress: '+'},
+    "live.com":       {domain: "live.com", subaddress: '+'},
+    "icloud.com":     {domain: "icloud.com", subaddress: '+'},
+    "fastmail.com":   {domain: "fastmail.com", subaddress: '+'},
+    "proton.me":      {domain: "proton.me", subaddress: '+'},
+    "protonmail.com": {domain: "proton.me", subaddress: '+'},
+}
+
+// applyProviderRules canonicalizes addresses of known providers, whose local parts are case-insensitive.
+func applyProviderRules(local, domain string) (string, string) {
+    p, ok := providers[domain]
+    if !ok {
+        return local, domain
+    }
+
+    local = strings.ToLower(local)
+    if p.subaddress != 0 {
+        if i := strings.IndexByte(local, p.subaddress); i > 0 {
+            local = local[:i]
+        }
+    }
+    if p.ignoreDots {
+        local = strings.ReplaceAll(local, ".", "")
+    }
+
+    return local, p.domain
+}
@@ -0,0 +1,51 @@
+package emailaddr_test
+
+import (
+	"testing"
+
+	"grpc-service-ref/internal/lib/emailaddr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+    tests := []struct {
+        name  string
+        rules bool
+        addr  string
+        want  string
+    }{
+        {name: "trims", addr: "  bob@example.com\n", want: "bob@example.com"},
+        {name: "lowercases domain", addr: "Bob@Example.COM", want: "Bob@example.com"},
+        {name: "punycodes domain", addr: "bob@Bücher.example", want: "bob@xn--bcher-kva.example"},
+        {name: "drops root dot", addr: "bob@example.com.", want: "bob@example.com"},
+        {name: "keeps gmail aliases without rules", addr: "B.o.b+news@GoogleMail.com", want: "B.o.b+news@googlemail.com"},
+        {name: "gmail rules", rules: true, addr: "B.o.b+news@GoogleMail.com", want: "bob@gmail.com"},
+        {name: "outlook rules keep dots", rules: true, addr: "Bob.Smith+x@outlook.com", want: "bob.smith@outlook.com"},
+        {name: "unknown provider keeps local part", rules: true, addr: "Bob+x@example.com", want: "Bob+x@example.com"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := emailaddr.Normalizer{ProviderRules: tt.rules}.Normalize(tt.addr)
+            require.NoError(t, err)
+            assert.Equal(t, tt.want, got)
+        })
+    }
+}
+
+func TestNormalize_Invalid(t *testing.T) {
+    for _, addr := range []string{
+        "",
+        "bob",
+        "@example.com",
+        "bob@",
+        "Bob <bob@example.com>",
+        "bob smith@example.com",
+        "bob@exa mple.com",
+    } {
+        _, err := emailaddr.Normalizer{}.Normalize(addr)
+        assert.ErrorIs(t, err, emailaddr.ErrInvalid, addr)
+    }
+}
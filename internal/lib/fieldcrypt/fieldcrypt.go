@@ -0,0 +1,247 @@
+// Package fieldcrypt encrypts single values, such as database columns, with envelope encryption:
+// values are sealed with AES-256-GCM data keys, and data keys are stored wrapped by a versioned
+// master key. Blind indexes, keyed HMACs of the values, let encrypted values be looked up.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// KeySize is the size of master, data and index keys in bytes.
+const KeySize = 32
+
+// format is the first byte of sealed values, followed by the id of the data key,
+// the nonce and the ciphertext with the tag.
+const format = 1
+
+// headerSize is the size of the format byte and the data key id.
+const headerSize = 1 + 8
+
+var (
+    ErrInvalidKey       = errors.New("invalid key")
+    ErrUnknownMaster    = errors.New("unknown master key version")
+    ErrMalformed        = errors.New("malformed sealed value")
+    ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// Keyring holds the master keys by version and the key of the blind indexes.
+type Keyring struct {
+    masters map[int]cipher.AEAD
+    current int
+    index   []byte
+}
+
+// NewKeyring returns a keyring wrapping new data keys with the master key of the current version.
+// Older versions are kept to unwrap the data keys they wrapped until these are re-encrypted.
+func NewKeyring(masters map[int][]byte, current int, indexKey []byte) (*Keyring, error) {
+    const op = "fieldcrypt.NewKeyring"
+
+    if _, ok := masters[current]; !ok {
+        return nil, fmt.Errorf("%s: %w %d", op, ErrUnknownMaster, current)
+    }
+    if len(indexKey) != KeySize {
+        return nil, fmt.Errorf("%s: %w: index key must be %d bytes", op, ErrInvalidKey, KeySize)
+    }
+
+    k := &Keyring{
+        masters: make(map[int]cipher.AEAD, len(masters)),
+        current: current,
+        index:   indexKey,
+    }
+    for version, key := range masters {
+        aead, err := newAEAD(key)
+        if err != nil {
+            return nil, fmt.Errorf("%s: master key %d: %w", op, version, err)
+        }
+        k.masters[version] = aead
+    }
+
+    return k, nil
+}
+
+// ParseKey decodes a base64 encoded key.
+func ParseKey(s string) ([]byte, error) {
+    key, err := base64.StdEncoding.DecodeString(s)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+    }
+    if len(key) != KeySize {
+        return nil, fmt.Errorf("%w: must be %d bytes", ErrInvalidKey, KeySize)
+    }
+
+    return key, nil
+}
+
+// CurrentVersion returns the version of the master key that wraps new data keys.
+func (k *Keyring) CurrentVersion() int {
+    return k.current
+}
+
+// GenerateDataKey returns a new random data key wrapped by the current master key.
+func (k *Keyring) GenerateDataKey() (wrapped []byte, version int, err error) {
+    key := make([]byte, KeySize)
+    if _, err := rand.Read(key); err != nil {
+        return nil, 0, err
+    }
+
+    wrapped, err = seal(k.masters[k.current], key, masterAAD(k.current))
+    if err != nil {
+        return nil, 0, err
+    }
+
+    return wrapped, k.current, nil
+}
+
+// Unwrap returns the data key with the id, wrapped by the master key of the version.
+func (k *Keyring) Unwrap(id int64, version int, wrapped []byte) (*DataKey, error) {
+    const op = "fieldcrypt.Unwrap"
+
+    master, ok := k.masters[version]
+    if !ok {
+        return nil, fmt.Errorf("%s: %w %d", op, ErrUnknownMaster, version)
+    }
+
+    key, err := open(master, wrapped, masterAAD(version))
+    if err != nil {
+        return nil, fmt.Errorf("%s: data key %d: %w", op, id, err)
+    }
+
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return &DataKey{id: id, aead: aead}, nil
+}
+
+// BlindIndex returns the HMAC-SHA256 of the value in the domain, such as the name of its column,
+// so that equal values in different domains have different indexes.
+// Values must be normalized by the caller, e.g. lowercased, for lookups to ignore the differences.
+func (k *Keyring) BlindIndex(domain, value string) []byte {
+    mac := hmac.New(sha256.New, k.index)
+    mac.Write([]byte(domain))
+    mac.Write([]byte{0})
+    mac.Write([]byte(value))
+
+    return mac.Sum(nil)
+}
+
+// DataKey encrypts values.
+type DataKey struct {
+    id   int64
+    aead cipher.AEAD
+}
+
+// ID returns the id of the key, which sealed values carry.
+func (d *DataKey) ID() int64 {
+    return d.id
+}
+
+// Seal encrypts the value. The additional data, such as the name of the column, is authenticated
+// and must be the same to open the value.
+func (d *DataKey) Seal(value, aad string) ([]byte, error) {
+    header := make([]byte, headerSize, headerSize+d.aead.NonceSize()+len(value)+d.aead.Overhead())
+    header[0] = format
+    binary.BigEndian.PutUint64(header[1:], uint64(d.id))
+
+    nonce := make([]byte, d.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+
+    out := append(header, nonce...)
+
+    return d.aead.Seal(out, nonce, []byte(value), append([]byte(aad), header...)), nil
+}
+
+// Open decrypts the value sealed by this key with the additional data.
+func (d *DataKey) Open(sealed []byte, aad string) (string, error) {
+    id, err := KeyID(sealed)
+    if err != nil {
+        return "", err
+    }
+    if id != d.id {
+        return "", fmt.Errorf("%w: sealed by data key %d", ErrDecryptionFailed, id)
+    }
+
+    header, rest := sealed[:headerSize], sealed[headerSize:]
+    if len(rest) < d.aead.NonceSize() {
+        return "", ErrMalformed
+    }
+    nonce, ciphertext := rest[:d.aead.NonceSize()], rest[d.aead.NonceSize():]
+
+    value, err := d.aead.Open(nil, nonce, ciphertext, append([]byte(aad), header...))
+    if err != nil {
+        return "", ErrDecryptionFailed
+    }
+
+    return string(value), nil
+}
+
+// KeyID returns the id of the data key that sealed the value.
+func KeyID(sealed []byte) (int64, error) {
+    if len(sealed) < headerSize || sealed[0] != format {
+        return 0, ErrMalformed
+    }
+
+    return int64(binary.BigEndian.Uint64(sealed[1:headerSize])), nil
+}
+
+// KeyIDPrefix returns the bytes sealed values of the data key start with.
+func KeyIDPrefix(id int64) []byte {
+    prefix := make([]byte, headerSize)
+    prefix[0] = format
+    binary.BigEndian.PutUint64(prefix[1:], uint64(id))
+
+    return prefix
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    if len(key) != KeySize {
+        return nil, fmt.Errorf("%w: must be %d bytes", ErrInvalidKey, KeySize)
+    }
+
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+
+    return cipher.NewGCM(block)
+}
+
+// seal and open wrap data keys as nonce followed by the ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+
+    return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+    if len(sealed) < aead.NonceSize() {
+        return nil, ErrMalformed
+    }
+
+    plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+    if err != nil {
+        return nil, ErrDecryptionFailed
+    }
+
+    return plaintext, nil
+}
+
+// masterAAD binds a wrapped data key to the version of the master key.
+func masterAAD(version int) []byte {
+    return []byte("data key:" + strconv.Itoa(version))
+}
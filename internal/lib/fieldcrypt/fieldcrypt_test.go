@@ -0,0 +1,127 @@
+package fieldcrypt_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"grpc-service-ref/internal/lib/fieldcrypt"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+    keyring := newKeyring(t, 1)
+
+    key := dataKey(t, keyring, 7)
+
+    sealed, err := key.Seal("bob@example.com", "users.email")
+    require.NoError(t, err)
+    assert.NotContains(t, string(sealed), "bob")
+
+    id, err := fieldcrypt.KeyID(sealed)
+    require.NoError(t, err)
+    assert.Equal(t, int64(7), id)
+    assert.True(t, bytes.HasPrefix(sealed, fieldcrypt.KeyIDPrefix(7)))
+
+    value, err := key.Open(sealed, "users.email")
+    require.NoError(t, err)
+    assert.Equal(t, "bob@example.com", value)
+
+    _, err = key.Open(sealed, "users.phone")
+    require.ErrorIs(t, err, fieldcrypt.ErrDecryptionFailed, "values are bound to their column")
+
+    sealed[len(sealed)-1] ^= 1
+    _, err = key.Open(sealed, "users.email")
+    require.ErrorIs(t, err, fieldcrypt.ErrDecryptionFailed)
+
+    _, err = fieldcrypt.KeyID([]byte{9})
+    require.ErrorIs(t, err, fieldcrypt.ErrMalformed)
+}
+
+func TestUnwrap_AfterRotation(t *testing.T) {
+    old := newKeyring(t, 1)
+    wrapped, version, err := old.GenerateDataKey()
+    require.NoError(t, err)
+    assert.Equal(t, 1, version)
+
+    rotated, err := fieldcrypt.NewKeyring(map[int][]byte{1: masterKey(1), 2: masterKey(2)}, 2, indexKey())
+    require.NoError(t, err)
+
+    key, err := rotated.Unwrap(3, version, wrapped)
+    require.NoError(t, err, "older master keys still unwrap their data keys")
+    assert.Equal(t, int64(3), key.ID())
+
+    _, version, err = rotated.GenerateDataKey()
+    require.NoError(t, err)
+    assert.Equal(t, 2, version)
+
+    _, err = rotated.Unwrap(3, 2, wrapped)
+    require.ErrorIs(t, err, fieldcrypt.ErrDecryptionFailed, "data keys are bound to the master key version")
+
+    retired := newKeyring(t, 2)
+    _, err = retired.Unwrap(3, 1, wrapped)
+    require.ErrorIs(t, err, fieldcrypt.ErrUnknownMaster)
+}
+
+func TestBlindIndex(t *testing.T) {
+    keyring := newKeyring(t, 1)
+
+    a := keyring.BlindIndex("users.email", "bob@example.com")
+    assert.Equal(t, a, keyring.BlindIndex("users.email", "bob@example.com"))
+    assert.NotEqual(t, a, keyring.BlindIndex("users.phone", "bob@example.com"))
+    assert.NotEqual(t, a, keyring.BlindIndex("users.email", "ann@example.com"))
+    assert.Len(t, a, 32)
+}
+
+func TestNewKeyring_FailCases(t *testing.T) {
+    _, err := fieldcrypt.NewKeyring(map[int][]byte{1: masterKey(1)}, 2, indexKey())
+    require.ErrorIs(t, err, fieldcrypt.ErrUnknownMaster)
+
+    _, err = fieldcrypt.NewKeyring(map[int][]byte{1: []byte("short")}, 1, indexKey())
+    require.ErrorIs(t, err, fieldcrypt.ErrInvalidKey)
+
+    _, err = fieldcrypt.NewKeyring(map[int][]byte{1: masterKey(1)}, 1, nil)
+    require.ErrorIs(t, err, fieldcrypt.ErrInvalidKey)
+}
+
+func TestParseKey(t *testing.T) {
+    key, err := fieldcrypt.ParseKey(base64.StdEncoding.EncodeToString(masterKey(1)))
+    require.NoError(t, err)
+    assert.Equal(t, masterKey(1), key)
+
+    _, err = fieldcrypt.ParseKey("c2hvcnQ=")
+    require.ErrorIs(t, err, fieldcrypt.ErrInvalidKey)
+
+    _, err = fieldcrypt.ParseKey("not base64")
+    require.ErrorIs(t, err, fieldcrypt.ErrInvalidKey)
+}
+
+func newKeyring(t *testing.T, version int) *fieldcrypt.Keyring {
+    t.Helper()
+
+    keyring, err := fieldcrypt.NewKeyring(map[int][]byte{version: masterKey(version)}, version, indexKey())
+    require.NoError(t, err)
+
+    return keyring
+}
+
+func dataKey(t *testing.T, keyring *fieldcrypt.Keyring, id int64) *fieldcrypt.DataKey {
+    t.Helper()
+
+    wrapped, version, err := keyring.GenerateDataKey()
+    require.NoError(t, err)
+    key, err := keyring.Unwrap(id, version, wrapped)
+    require.NoError(t, err)
+
+    return key
+}
+
+func masterKey(version int) []byte {
+    return bytes.Repeat([]byte{byte(version)}, fieldcrypt.KeySize)
+}
+
+func indexKey() []byte {
+    return bytes.Repeat([]byte{0xff}, fieldcrypt.KeySize)
+}
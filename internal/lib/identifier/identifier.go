@@ -0,0 +1,65 @@
+package identifier
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Kind is the type of identifier a user logs in with.
+type Kind string
+
+const (
+    KindEmail    Kind = "email"
+    KindUsername Kind = "username"
+    KindPhone    Kind = "phone"
+)
+
+var (
+    ErrInvalidUsername = errors.New("invalid username")
+    ErrInvalidPhone    = errors.New("invalid phone number")
+)
+
+var (
+    usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{2,31}$`)
+    phonePattern    = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+    // phoneSeparators are dropped from phone numbers as people write them.
+    phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+)
+
+// Classify tells the kind of the identifier: addresses with an @ are emails, identifiers
+// starting with a + are phone numbers and anything else is a username.
+func Classify(id string) Kind {
+    id = strings.TrimSpace(id)
+
+    switch {
+    case strings.Contains(id, "@"):
+        return KindEmail
+    case strings.HasPrefix(id, "+"):
+        return KindPhone
+    default:
+        return KindUsername
+    }
+}
+
+// NormalizeUsername trims the username and validates it. The case is kept,
+// as the storage compares usernames case-insensitively.
+func NormalizeUsername(username string) (string, error) {
+    username = strings.TrimSpace(username)
+    if !usernamePattern.MatchString(username) {
+        return "", ErrInvalidUsername
+    }
+
+    return username, nil
+}
+
+// NormalizePhone drops separators from the phone number and validates it is in E.164 format,
+// a + followed by the country code and the subscriber number, up to 15 digits in all.
+func NormalizePhone(phone string) (string, error) {
+    phone = phoneSeparators.Replace(strings.TrimSpace(phone))
+    if !phonePattern.MatchString(phone) {
+        return "", ErrInvalidPhone
+    }
+
+    return phone, nil
+}
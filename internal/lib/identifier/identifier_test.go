@@ -0,0 +1,39 @@
+package identifier_test
+
+import (
+	"testing"
+
+	"grpc-service-ref/internal/lib/identifier"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+    assert.Equal(t, identifier.KindEmail, identifier.Classify("bob@example.com"))
+    assert.Equal(t, identifier.KindPhone, identifier.Classify(" +1 415 555 0123"))
+    assert.Equal(t, identifier.KindUsername, identifier.Classify("bob"))
+    assert.Equal(t, identifier.KindUsername, identifier.Classify("14155550123"))
+}
+
+func TestNormalizeUsername(t *testing.T) {
+    got, err := identifier.NormalizeUsername("  Bob.Smith_1 ")
+    require.NoError(t, err)
+    assert.Equal(t, "Bob.Smith_1", got)
+
+    for _, username := range []string{"", "bo", ".bob", "bob smith", "bob@example", "b" + string(make([]byte, 32))} {
+        _, err := identifier.NormalizeUsername(username)
+        assert.ErrorIs(t, err, identifier.ErrInvalidUsername, username)
+    }
+}
+
+func TestNormalizePhone(t *testing.T) {
+    got, err := identifier.NormalizePhone("+1 (415) 555-0123")
+    require.NoError(t, err)
+    assert.Equal(t, "+14155550123", got)
+
+    for _, phone := range []string{"", "4155550123", "+0415550123", "+1234567890123456", "+1 415 CALL"} {
+        _, err := identifier.NormalizePhone(phone)
+        assert.ErrorIs(t, err, identifier.ErrInvalidPhone, phone)
+    }
+}
@@ -1,25 +1,226 @@
 package jwt
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"grpc-service-ref/internal/domain/models"
-  
-  "github.com/golang-jwt/jwt/v5"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+    ErrInvalidToken = errors.New("invalid token")
+    ErrNoSecret     = errors.New("token secret is empty")
 )
 
-func NewToken(user models.User, duration time.Duration) (string, error) {
+// reservedClaims are set by NewToken and never taken from extra claims.
+var reservedClaims = []string{"sub", "roles", "groups", "uid", "sid", "org_id", "org_roles", "exp"}
+
+// Claims are the claims of a token issued by NewToken.
+type Claims struct {
+    Principal models.Principal
+    SessionID string // empty for principals without sessions
+    ExpiresAt time.Time
+}
+
+// NewToken issues a token of the principal.
+// Tokens of users also carry the uid claim, clients read the user id from it.
+// Tokens of principals acting in an organization carry its id and their roles in it
+// in the org_id and org_roles claims. Tokens of principals in groups carry their names
+// in the groups claim; their roles are in the roles claim.
+// Tokens are signed with HS256 using the secret.
+func NewToken(principal models.Principal, sessionID string, duration time.Duration, secret []byte) (string, error) {
+    return NewTokenWithClaims(principal, sessionID, duration, nil, secret)
+}
+
+// NewTokenWithClaims issues a token of the principal carrying the extra claims as well.
+// Extra claims never replace the claims NewToken sets.
+func NewTokenWithClaims(
+    principal models.Principal,
+    sessionID string,
+    duration time.Duration,
+    extra map[string]any,
+    secret []byte,
+) (string, error) {
+    if len(secret) == 0 {
+        return "", ErrNoSecret
+    }
+
     token := jwt.New(jwt.SigningMethodHS256)
-    
+
+    roles := principal.Roles
+    if roles == nil {
+        roles = []string{}
+    }
+
     claims := token.Claims.(jwt.MapClaims)
-    claims["uid"] = user.ID
+    for name, value := range extra {
+        if !slices.Contains(reservedClaims, name) {
+            claims[name] = value
+        }
+    }
+    claims["sub"] = principal.String()
+    claims["roles"] = roles
+    if len(principal.Groups) > 0 {
+        claims["groups"] = principal.Groups
+    }
+    if principal.IsUser() {
+        claims["uid"] = principal.ID
+    }
+    if sessionID != "" {
+        claims["sid"] = sessionID
+    }
+    if principal.OrgID != 0 {
+        orgRoles := principal.OrgRoles
+        if orgRoles == nil {
+            orgRoles = []string{}
+        }
+        claims["org_id"] = principal.OrgID
+        claims["org_roles"] = orgRoles
+    }
     claims["exp"] = time.Now().Add(duration).Unix()
 
-    // TODO: secret
-    tokenString, err := token.SignedString([]byte("secret"))
+    tokenString, err := token.SignedString(secret)
     if err != nil {
         return "", err
     }
-    
+
     return tokenString, nil
 }
+
+// ParseToken verifies the token signature and expiration and returns its claims.
+// Tokens of users must reference a session.
+// The claims describe the principal as of issuing; callers resolve its current access themselves.
+func ParseToken(tokenString string, secret []byte) (Claims, error) {
+    if len(secret) == 0 {
+        return Claims{}, ErrNoSecret
+    }
+
+    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+        return secret, nil
+    }, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+    if err != nil {
+        return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+    }
+
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok {
+        return Claims{}, ErrInvalidToken
+    }
+
+    sub, err := claims.GetSubject()
+    if err != nil || sub == "" {
+        return Claims{}, fmt.Errorf("%w: sub claim is missing", ErrInvalidToken)
+    }
+
+    principal, err := models.ParsePrincipal(sub)
+    if err != nil {
+        return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+    }
+
+    rawRoles, _ := claims["roles"].([]any)
+    for _, raw := range rawRoles {
+        role, ok := raw.(string)
+        if !ok {
+            return Claims{}, fmt.Errorf("%w: roles claim is malformed", ErrInvalidToken)
+        }
+        principal.Roles = append(principal.Roles, role)
+    }
+
+    rawGroups, _ := claims["groups"].([]any)
+    for _, raw := range rawGroups {
+        group, ok := raw.(string)
+        if !ok {
+            return Claims{}, fmt.Errorf("%w: groups claim is malformed", ErrInvalidToken)
+        }
+        principal.Groups = append(principal.Groups, group)
+    }
+
+    if rawOrgID, ok := claims["org_id"]; ok {
+        // Numbers are decoded as float64.
+        orgID, ok := rawOrgID.(float64)
+        if !ok || orgID <= 0 || orgID != float64(int64(orgID)) {
+            return Claims{}, fmt.Errorf("%w: org_id claim is malformed", ErrInvalidToken)
+        }
+        principal.OrgID = int64(orgID)
+
+        rawOrgRoles, _ := claims["org_roles"].([]any)
+        for _, raw := range rawOrgRoles {
+            role, ok := raw.(string)
+            if !ok {
+                return Claims{}, fmt.Errorf("%w: org_roles claim is malformed", ErrInvalidToken)
+            }
+            principal.OrgRoles = append(principal.OrgRoles, role)
+        }
+    }
+
+    sid, _ := claims["sid"].(string)
+    if principal.IsUser() && sid == "" {
+        return Claims{}, fmt.Errorf("%w: sid claim is missing", ErrInvalidToken)
+    }
+
+    exp, err := claims.GetExpirationTime()
+    if err != nil {
+        return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+    }
+
+    return Claims{
+        Principal: principal,
+        SessionID: sid,
+        ExpiresAt: exp.Time,
+    }, nil
+}
+
+// profileClaimNames are the claims of the standard profile fields, named as in OpenID Connect.
+var profileClaimNames = map[string]string{
+    models.ProfileDisplayName: "name",
+    models.ProfileGivenName:   "given_name",
+    models.ProfileFamilyName:  "family_name",
+    models.ProfileLocale:      "locale",
+    models.ProfileTimezone:    "zoneinfo",
+    models.ProfileAvatarURL:   "picture",
+}
+
+// ProfileClaims projects the profile fields named by the field mask paths into claims.
+// Attributes are projected only for the app owning their namespace, under the attributes claim;
+// the attributes path projects the whole namespace of the app. Empty values are left out.
+func ProfileClaims(profile models.Profile, paths []string, app string) map[string]any {
+    claims := map[string]any{}
+    attrs := map[string]any{}
+
+    for _, path := range paths {
+        if name, ok := profileClaimNames[path]; ok {
+            if value := profile.Field(path); value != "" {
+                claims[name] = value
+            }
+            continue
+        }
+
+        if app == "" {
+            continue
+        }
+
+        rest, ok := strings.CutPrefix(path, models.ProfileAttributes)
+        switch {
+        case !ok:
+        case rest == "" || rest == "."+app:
+            maps.Copy(attrs, profile.Attributes[app])
+        case strings.HasPrefix(rest, "."+app+"."):
+            key := strings.TrimPrefix(rest, "."+app+".")
+            if value, ok := profile.Attributes[app][key]; ok {
+                attrs[key] = value
+            }
+        }
+    }
+
+    if len(attrs) > 0 {
+        claims[models.ProfileAttributes] = attrs
+    }
+
+    return claims
+}
@@ -0,0 +1,135 @@
+package jwt_test
+
+import (
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/jwt"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("test-secret")
+
+func TestToken_RoundTrip(t *testing.T) {
+    tests := []struct {
+        name      string
+        principal models.Principal
+        sessionID string
+    }{
+        {
+            name:      "User",
+            principal: models.Principal{Type: models.PrincipalUser, ID: 1, Roles: []string{"admin"}},
+            sessionID: "session",
+        },
+        {
+            name: "User in groups",
+            principal: models.Principal{
+                Type:   models.PrincipalUser,
+                ID:     1,
+                Roles:  []string{"admin", "support"},
+                Groups: []string{"admins", "engineering"},
+            },
+            sessionID: "session",
+        },
+        {
+            name:      "Service account",
+            principal: models.Principal{Type: models.PrincipalServiceAccount, ID: 2, Roles: []string{"billing"}},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            token, err := jwt.NewToken(tt.principal, tt.sessionID, time.Hour, secret)
+            require.NoError(t, err)
+
+            claims, err := jwt.ParseToken(token, secret)
+            require.NoError(t, err)
+
+            assert.Equal(t, tt.principal, claims.Principal)
+            assert.Equal(t, tt.sessionID, claims.SessionID)
+            assert.WithinDuration(t, time.Now().Add(time.Hour), claims.ExpiresAt, time.Minute)
+        })
+    }
+}
+
+func TestParseToken_FailCases(t *testing.T) {
+    userWithoutSession, err := jwt.NewToken(models.Principal{Type: models.PrincipalUser, ID: 1}, "", time.Hour, secret)
+    require.NoError(t, err)
+
+    expired, err := jwt.NewToken(models.Principal{Type: models.PrincipalServiceAccount, ID: 1}, "", -time.Hour, secret)
+    require.NoError(t, err)
+
+    otherKey, err := jwt.NewToken(models.Principal{Type: models.PrincipalServiceAccount, ID: 1}, "", time.Hour, []byte("other-secret"))
+    require.NoError(t, err)
+
+    for name, token := range map[string]string{
+        "User without session":  userWithoutSession,
+        "Expired":               expired,
+        "Signed with other key": otherKey,
+        "Malformed":             "not-a-token",
+    } {
+        t.Run(name, func(t *testing.T) {
+            _, err := jwt.ParseToken(token, secret)
+            require.ErrorIs(t, err, jwt.ErrInvalidToken)
+        })
+    }
+}
+
+func TestNewTokenWithClaims(t *testing.T) {
+    principal := models.Principal{Type: models.PrincipalUser, ID: 1}
+    profile := models.Profile{
+        DisplayName: "Ann",
+        Locale:      "en-US",
+        Attributes: models.Attributes{
+            "shop": {"plan": "pro", "seats": float64(3)},
+            "blog": {"theme": "dark"},
+        },
+    }
+
+    extra := jwt.ProfileClaims(profile, []string{"display_name", "timezone", "attributes.shop.plan", "attributes.blog"}, "shop")
+    assert.Equal(t, map[string]any{
+        "name":       "Ann",
+        "attributes": map[string]any{"plan": "pro"},
+    }, extra, "empty fields and namespaces of other apps are left out")
+
+    extra["sub"] = "user:2"
+    token, err := jwt.NewTokenWithClaims(principal, "session", time.Hour, extra, secret)
+    require.NoError(t, err)
+
+    claims, err := jwt.ParseToken(token, secret)
+    require.NoError(t, err)
+    assert.Equal(t, principal, claims.Principal, "extra claims never replace the subject")
+
+    assert.Empty(t, jwt.ProfileClaims(profile, []string{"attributes"}, ""), "attributes need the app")
+    assert.Equal(t, map[string]any{
+        "attributes": map[string]any{"theme": "dark"},
+    }, jwt.ProfileClaims(profile, []string{"attributes"}, "blog"))
+}
+
+func TestToken_Organization(t *testing.T) {
+    principal := models.Principal{
+        Type:     models.PrincipalUser,
+        ID:       1,
+        OrgID:    7,
+        OrgRoles: []string{models.OrgRoleAdmin},
+    }
+
+    token, err := jwt.NewTokenWithClaims(principal, "session", time.Hour, map[string]any{"org_id": 8}, secret)
+    require.NoError(t, err)
+
+    claims, err := jwt.ParseToken(token, secret)
+    require.NoError(t, err)
+    assert.Equal(t, principal, claims.Principal, "extra claims never replace the organization")
+    assert.True(t, claims.Principal.HasPermission(models.PermissionMembersWrite))
+
+    token, err = jwt.NewToken(models.Principal{Type: models.PrincipalUser, ID: 1}, "session", time.Hour, secret)
+    require.NoError(t, err)
+
+    claims, err = jwt.ParseToken(token, secret)
+    require.NoError(t, err)
+    assert.Zero(t, claims.Principal.OrgID)
+    assert.Nil(t, claims.Principal.OrgRoles)
+}
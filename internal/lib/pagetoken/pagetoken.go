@@ -0,0 +1,31 @@
+package pagetoken
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// Encode returns the token of the page that continues after the record with the id.
+func Encode(lastID int64) string {
+    return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(lastID, 10)))
+}
+
+// Decode returns the id of the last record of the previous page. An empty token starts from the beginning.
+func Decode(token string) (int64, error) {
+    if token == "" {
+        return 0, nil
+    }
+
+    raw, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return 0, err
+    }
+
+    id, err := strconv.ParseInt(string(raw), 10, 64)
+    if err != nil || id < 0 {
+        return 0, fmt.Errorf("malformed page token %q", token)
+    }
+
+    return id, nil
+}
@@ -0,0 +1,136 @@
+// Package sms sends text messages to phone numbers through pluggable providers.
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Provider sends text messages to E.164 phone numbers.
+type Provider interface {
+    Send(ctx context.Context, phone, text string) error
+}
+
+// LogProvider writes messages to the log instead of sending them, for local development.
+// The log holds the messages in the clear, one-time codes included.
+type LogProvider struct {
+    log *slog.Logger
+}
+
+func NewLogProvider(log *slog.Logger) *LogProvider {
+    return &LogProvider{log: log}
+}
+
+func (p *LogProvider) Send(_ context.Context, phone, text string) error {
+    p.log.Info("sms", slog.String("phone", phone), slog.String("text", text))
+
+    return nil
+}
+
+// FileProvider appends messages to a file as JSON lines instead of sending them,
+// for local development and tests that read the messages back.
+type FileProvider struct {
+    mu   sync.Mutex
+    path string
+}
+
+func NewFileProvider(path string) *FileProvider {
+    return &FileProvider{path: path}
+}
+
+// Message is a sent message, as FileProvider writes it and HTTPProvider posts it.
+type Message struct {
+    From   string    `json:"from,omitempty"`
+    To     string    `json:"to"`
+    Text   string    `json:"text"`
+    SentAt time.Time `json:"sent_at"`
+}
+
+func (p *FileProvider) Send(_ context.Context, phone, text string) error {
+    const op = "sms.FileProvider.Send"
+
+    line, err := json.Marshal(Message{To: phone, Text: text, SentAt: time.Now()})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer f.Close()
+
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// HTTPOptions configure an HTTP provider.
+type HTTPOptions struct {
+    URL     string        // endpoint of the gateway messages are posted to
+    Token   string        // bearer token of the gateway, if it needs one
+    From    string        // sender id or number messages are sent from, if the gateway needs one
+    Timeout time.Duration // timeout of a single request
+}
+
+// HTTPProvider posts messages as JSON to an SMS gateway.
+// Any response other than 2xx is a failed send.
+type HTTPProvider struct {
+    url    string
+    token  string
+    from   string
+    client *http.Client
+}
+
+func NewHTTPProvider(opts HTTPOptions) *HTTPProvider {
+    return &HTTPProvider{
+        url:    opts.URL,
+        token:  opts.Token,
+        from:   opts.From,
+        client: &http.Client{Timeout: opts.Timeout},
+    }
+}
+
+func (p *HTTPProvider) Send(ctx context.Context, phone, text string) error {
+    const op = "sms.HTTPProvider.Send"
+
+    body, err := json.Marshal(Message{From: p.from, To: phone, Text: text, SentAt: time.Now()})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if p.token != "" {
+        req.Header.Set("Authorization", "Bearer "+p.token)
+    }
+
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("%s: unexpected status %s", op, resp.Status)
+    }
+
+    return nil
+}
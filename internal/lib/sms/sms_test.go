@@ -0,0 +1,76 @@
+package sms_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/lib/sms"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProvider_Send(t *testing.T) {
+    var got sms.Message
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        assert.Equal(t, http.MethodPost, r.Method)
+        assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+        assert.Equal(t, "Bearer gateway-token", r.Header.Get("Authorization"))
+        assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+        w.WriteHeader(http.StatusAccepted)
+    }))
+    defer srv.Close()
+
+    p := sms.NewHTTPProvider(sms.HTTPOptions{URL: srv.URL, Token: "gateway-token", From: "SSO", Timeout: time.Second})
+
+    require.NoError(t, p.Send(context.Background(), "+14155550123", "Your code is 123456"))
+    assert.Equal(t, "SSO", got.From)
+    assert.Equal(t, "+14155550123", got.To)
+    assert.Equal(t, "Your code is 123456", got.Text)
+}
+
+func TestHTTPProvider_SendFails(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusTooManyRequests)
+    }))
+    defer srv.Close()
+
+    p := sms.NewHTTPProvider(sms.HTTPOptions{URL: srv.URL, Timeout: time.Second})
+
+    err := p.Send(context.Background(), "+14155550123", "Your code is 123456")
+    require.Error(t, err)
+    assert.Contains(t, err.Error(), "429")
+}
+
+func TestFileProvider_Send(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "sms.jsonl")
+    p := sms.NewFileProvider(path)
+
+    require.NoError(t, p.Send(context.Background(), "+14155550123", "first"))
+    require.NoError(t, p.Send(context.Background(), "+14155550124", "second"))
+
+    f, err := os.Open(path)
+    require.NoError(t, err)
+    defer f.Close()
+
+    var messages []sms.Message
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        var m sms.Message
+        require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+        messages = append(messages, m)
+    }
+    require.NoError(t, scanner.Err())
+
+    require.Len(t, messages, 2)
+    assert.Equal(t, "+14155550123", messages[0].To)
+    assert.Equal(t, "first", messages[0].Text)
+    assert.Equal(t, "second", messages[1].Text)
+}
@@ -0,0 +1,457 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/pagetoken"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+type Admin struct {
+	log      *slog.Logger
+	users    UserStore
+	sessions SessionStore
+	auditLog AuditLog
+	auditor  Auditor
+}
+
+type UserStore interface {
+	Users(ctx context.Context, filter models.UserFilter, afterID int64, limit int) ([]models.User, error)
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	ChangeUserStatus(ctx context.Context, t models.StatusTransition) error
+	StatusTransitions(ctx context.Context, userID int64) ([]models.StatusTransition, error)
+	SetUserRoles(ctx context.Context, id int64, roles []string) error
+	UpdatePassword(ctx context.Context, id int64, passHash []byte) error
+	Membership(ctx context.Context, orgID, userID int64) (models.Membership, error)
+}
+
+type SessionStore interface {
+	Sessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID int64, id string) error
+	RevokeSessions(ctx context.Context, userID int64, exceptID string) (int64, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrInvalidStatus    = errors.New("invalid status transition")
+	ErrStatusConflict   = errors.New("user status changed concurrently")
+	ErrSessionNotFound  = errors.New("session not found")
+)
+
+// New returns a new instance of the Admin service.
+func New(
+	log *slog.Logger,
+	users UserStore,
+	sessions SessionStore,
+	auditLog AuditLog,
+	auditor Auditor,
+) *Admin {
+	return &Admin{
+		log:      log,
+		users:    users,
+		sessions: sessions,
+		auditLog: auditLog,
+		auditor:  auditor,
+	}
+}
+
+// ListUsers returns a page of users matching the filter, ordered by id, and the token of the next page.
+// The next page token is empty on the last page.
+func (a *Admin) ListUsers(
+	ctx context.Context,
+	filter models.UserFilter,
+	pageToken string,
+	pageSize int,
+) ([]models.User, string, error) {
+	const op = "admin.ListUsers"
+
+	log := a.log.With(slog.String("op", op))
+
+	afterID, err := pagetoken.Decode(pageToken)
+	if err != nil {
+		log.Warn("invalid page token", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+	}
+
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	pageSize = min(pageSize, MaxPageSize)
+
+	// One extra user tells whether there is a next page.
+	users, err := a.users.Users(ctx, filter, afterID, pageSize+1)
+	if err != nil {
+		log.Error("failed to list users", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		next = pagetoken.Encode(users[pageSize-1].ID)
+	}
+
+	return users, next, nil
+}
+
+// User returns the user by id.
+func (a *Admin) User(ctx context.Context, id int64) (models.User, error) {
+	const op = "admin.User"
+
+	user, err := a.users.UserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		a.log.Error("failed to get user",
+			slog.String("op", op),
+			slog.Int64("uid", id),
+			slog.String("err", err.Error()),
+		)
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// CheckMember returns ErrUserNotFound unless the user is a member of the organization.
+// Callers acting in an organization only reach the users that are its members.
+func (a *Admin) CheckMember(ctx context.Context, orgID, userID int64) error {
+	const op = "admin.CheckMember"
+
+	if _, err := a.users.Membership(ctx, orgID, userID); err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		a.log.Error("failed to get membership",
+			slog.String("op", op),
+			slog.Int64("org_id", orgID),
+			slog.Int64("uid", userID),
+			slog.String("err", err.Error()),
+		)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ChangeUserStatus moves the user to the status on behalf of the actor, recording who made
+// the change and why. until limits a suspension and must be zero for other statuses.
+// Sessions of users that may no longer log in are revoked.
+func (a *Admin) ChangeUserStatus(
+	ctx context.Context,
+	actor string,
+	id int64,
+	status models.UserStatus,
+	reason string,
+	until time.Time,
+) error {
+	const op = "admin.ChangeUserStatus"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", id),
+		slog.String("actor", actor),
+		slog.String("status", string(status)),
+	)
+
+	if !until.IsZero() && (status != models.UserStatusSuspended || !until.After(time.Now())) {
+		log.Warn("invalid suspension end", slog.Time("until", until))
+		return fmt.Errorf("%s: %w: until must be a future time of a suspension", op, ErrInvalidStatus)
+	}
+
+	user, err := a.User(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !models.CanTransition(user.Status, status) {
+		log.Warn("invalid status transition", slog.String("from", string(user.Status)))
+		return fmt.Errorf("%s: %w: %s to %s", op, ErrInvalidStatus, user.Status, status)
+	}
+
+	err = a.users.ChangeUserStatus(ctx, models.StatusTransition{
+		UserID:    id,
+		From:      user.Status,
+		To:        status,
+		Reason:    reason,
+		Until:     until,
+		Actor:     actor,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Warn("user not found")
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		case errors.Is(err, storage.ErrStatusConflict):
+			log.Warn("user status changed concurrently")
+			return fmt.Errorf("%s: %w", op, ErrStatusConflict)
+		}
+		log.Error("failed to change user status", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if status != models.UserStatusActive {
+		if err := a.revokeSessions(ctx, log, id); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("user status changed", slog.String("from", string(user.Status)))
+
+	details := map[string]string{
+		"from":   string(user.Status),
+		"to":     string(status),
+		"reason": reason,
+	}
+	if !until.IsZero() {
+		details["until"] = until.UTC().Format(time.RFC3339)
+	}
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventUserStatusChanged,
+		Actor:   actor,
+		Subject: models.UserPrincipal(user).String(),
+		Details: details,
+	})
+
+	return nil
+}
+
+// StatusTransitions returns the status history of the user, oldest first.
+func (a *Admin) StatusTransitions(ctx context.Context, id int64) ([]models.StatusTransition, error) {
+	const op = "admin.StatusTransitions"
+
+	transitions, err := a.users.StatusTransitions(ctx, id)
+	if err != nil {
+		a.log.Error("failed to list status transitions",
+			slog.String("op", op),
+			slog.Int64("uid", id),
+			slog.String("err", err.Error()),
+		)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return transitions, nil
+}
+
+// ForceResetPassword replaces the password of the user with a random one and revokes its sessions.
+// The temporary password is returned to be handed to the user.
+func (a *Admin) ForceResetPassword(ctx context.Context, id int64) (string, error) {
+	const op = "admin.ForceResetPassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", id),
+	)
+
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		log.Error("failed to generate password", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(raw)
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error("failed to generate password hash", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.users.UpdatePassword(ctx, id, passHash); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return "", fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to update password", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeSessions(ctx, log, id); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password reset")
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventPasswordReset,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: id}.String(),
+	})
+
+	return password, nil
+}
+
+// SetUserRoles replaces the roles of the user. The new roles apply to tokens issued before as
+// well, since token validation reads the roles of the user rather than those of the claims,
+// so sessions are left alone.
+func (a *Admin) SetUserRoles(ctx context.Context, id int64, roles []string) error {
+	const op = "admin.SetUserRoles"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", id),
+	)
+
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role == "" {
+			log.Warn("empty role")
+			return fmt.Errorf("%s: %w: role is empty", op, ErrInvalidRole)
+		}
+		normalized = append(normalized, role)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+
+	if err := a.users.SetUserRoles(ctx, id, normalized); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to update roles", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user roles updated", slog.Any("roles", normalized))
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventUserRolesChanged,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: id}.String(),
+		Details: map[string]string{"roles": strings.Join(normalized, " ")},
+	})
+
+	return nil
+}
+
+// UserSessions returns the active sessions of the user.
+func (a *Admin) UserSessions(ctx context.Context, id int64) ([]models.Session, error) {
+	const op = "admin.UserSessions"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", id),
+	)
+
+	if err := a.checkUser(ctx, log, id); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := a.sessions.Sessions(ctx, id)
+	if err != nil {
+		log.Error("failed to list sessions", slog.String("err", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeUserSession revokes a session of the user on behalf of the actor.
+func (a *Admin) RevokeUserSession(ctx context.Context, actor string, id int64, sessionID string) error {
+	const op = "admin.RevokeUserSession"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", id),
+		slog.String("sid", sessionID),
+	)
+
+	if err := a.sessions.RevokeSession(ctx, id, sessionID); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Warn("session not found")
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		log.Error("failed to revoke session", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session revoked")
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventSessionRevoked,
+		Actor:   actor,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: id}.String(),
+		Details: map[string]string{"session_id": sessionID},
+	})
+
+	return nil
+}
+
+// RevokeUserSessions revokes every session of the user on behalf of the actor and returns
+// the number of revoked sessions.
+func (a *Admin) RevokeUserSessions(ctx context.Context, actor string, id int64) (int64, error) {
+	const op = "admin.RevokeUserSessions"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", id),
+	)
+
+	if err := a.checkUser(ctx, log, id); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := a.sessions.RevokeSessions(ctx, id, "")
+	if err != nil {
+		log.Error("failed to revoke sessions", slog.String("err", err.Error()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("sessions revoked", slog.Int64("count", n))
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventSessionsRevoked,
+		Actor:   actor,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: id}.String(),
+		Details: map[string]string{"count": strconv.FormatInt(n, 10)},
+	})
+
+	return n, nil
+}
+
+// checkUser returns ErrUserNotFound unless the user exists.
+func (a *Admin) checkUser(ctx context.Context, log *slog.Logger, id int64) error {
+	if _, err := a.users.UserByID(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return ErrUserNotFound
+		}
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+func (a *Admin) revokeSessions(ctx context.Context, log *slog.Logger, id int64) error {
+	n, err := a.sessions.RevokeSessions(ctx, id, "")
+	if err != nil {
+		log.Error("failed to revoke sessions", slog.String("err", err.Error()))
+		return err
+	}
+
+	log.Info("sessions revoked", slog.Int64("count", n))
+
+	return nil
+}
@@ -0,0 +1,324 @@
+package admin_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/admin"
+	"grpc-service-ref/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestListUsers_Pagination(t *testing.T) {
+	store := newMemStore()
+	for _, email := range []string{"a@x.io", "b@x.io", "c@y.io", "d@x.io", "e@x.io"} {
+		store.add(models.User{Email: email, Status: models.UserStatusActive})
+	}
+	a := admin.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, store, store)
+
+	var (
+		emails []string
+		token  string
+		pages  int
+	)
+	for {
+		users, next, err := a.ListUsers(context.Background(), models.UserFilter{EmailPrefix: "a"}, token, 2)
+		require.NoError(t, err)
+		pages++
+		for _, user := range users {
+			emails = append(emails, user.Email)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, []string{"a@x.io"}, emails)
+	assert.Equal(t, 1, pages)
+
+	emails, token, pages = nil, "", 0
+	for {
+		users, next, err := a.ListUsers(context.Background(), models.UserFilter{}, token, 2)
+		require.NoError(t, err)
+		pages++
+		for _, user := range users {
+			emails = append(emails, user.Email)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, []string{"a@x.io", "b@x.io", "c@y.io", "d@x.io", "e@x.io"}, emails)
+	assert.Equal(t, 3, pages)
+
+	_, _, err := a.ListUsers(context.Background(), models.UserFilter{}, "%%%", 2)
+	require.ErrorIs(t, err, admin.ErrInvalidPageToken)
+}
+
+func TestChangeUserStatus(t *testing.T) {
+	store := newMemStore()
+	id := store.add(models.User{Email: "a@x.io", Status: models.UserStatusActive})
+	a := admin.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, store, store)
+	ctx := context.Background()
+	until := time.Now().Add(time.Hour)
+
+	require.NoError(t, a.ChangeUserStatus(ctx, "user:1", id, models.UserStatusSuspended, "spam", until))
+	assert.Equal(t, models.UserStatusSuspended, store.users[id].Status)
+	assert.Equal(t, 1, store.revoked[id])
+
+	err := a.ChangeUserStatus(ctx, "user:1", id, models.UserStatusLocked, "compromised", time.Time{})
+	require.ErrorIs(t, err, admin.ErrInvalidStatus)
+
+	err = a.ChangeUserStatus(ctx, "user:1", id, models.UserStatusDeleted, "request", until)
+	require.ErrorIs(t, err, admin.ErrInvalidStatus)
+
+	require.NoError(t, a.ChangeUserStatus(ctx, "user:1", id, models.UserStatusActive, "appeal", time.Time{}))
+	assert.Equal(t, 1, store.revoked[id])
+
+	transitions, err := a.StatusTransitions(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, transitions, 2)
+	assert.Equal(t, models.UserStatusActive, transitions[0].From)
+	assert.Equal(t, models.UserStatusSuspended, transitions[0].To)
+	assert.Equal(t, "spam", transitions[0].Reason)
+	assert.Equal(t, "user:1", transitions[0].Actor)
+	assert.Equal(t, until, transitions[0].Until)
+
+	require.Len(t, store.events, 2)
+	assert.Equal(t, models.EventUserStatusChanged, store.events[0].Type)
+	assert.Equal(t, "user:1", store.events[0].Actor)
+	assert.Equal(t, "user:1", store.events[0].Subject)
+	assert.Equal(t, "suspended", store.events[0].Details["to"])
+
+	err = a.ChangeUserStatus(ctx, "user:1", id+1, models.UserStatusActive, "typo", time.Time{})
+	require.ErrorIs(t, err, admin.ErrUserNotFound)
+}
+
+func TestAuditEvents_Pagination(t *testing.T) {
+	store := newMemStore()
+	a := admin.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, store, store)
+	for _, actor := range []string{"user:1", "user:2", "user:1", "user:1", "user:2"} {
+		store.Record(context.Background(), models.AuditEvent{Type: models.EventLoginSucceeded, Actor: actor})
+	}
+
+	var ids []int64
+	var token string
+	for {
+		events, next, err := a.AuditEvents(context.Background(), models.AuditFilter{Actor: "user:1"}, token, 2)
+		require.NoError(t, err)
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	assert.Equal(t, []int64{4, 3, 1}, ids)
+}
+
+func TestForceResetPassword(t *testing.T) {
+	store := newMemStore()
+	id := store.add(models.User{Email: "a@x.io", Status: models.UserStatusActive})
+	a := admin.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, store, store)
+
+	password, err := a.ForceResetPassword(context.Background(), id)
+	require.NoError(t, err)
+	require.NoError(t, bcrypt.CompareHashAndPassword(store.users[id].PassHash, []byte(password)))
+	assert.Equal(t, 1, store.revoked[id])
+
+	_, err = a.ForceResetPassword(context.Background(), id+1)
+	require.ErrorIs(t, err, admin.ErrUserNotFound)
+}
+
+func TestUserSessions(t *testing.T) {
+	store := newMemStore()
+	id := store.add(models.User{Email: "a@x.io", Status: models.UserStatusActive})
+	store.sessions[id] = []models.Session{{ID: "s1", UserID: id}, {ID: "s2", UserID: id}, {ID: "s3", UserID: id}}
+	a := admin.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, store, store)
+	ctx := context.Background()
+
+	sessions, err := a.UserSessions(ctx, id)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 3)
+
+	require.NoError(t, a.RevokeUserSession(ctx, "user:9", id, "s2"))
+	require.ErrorIs(t, a.RevokeUserSession(ctx, "user:9", id, "s2"), admin.ErrSessionNotFound)
+	require.ErrorIs(t, a.RevokeUserSession(ctx, "user:9", id+1, "s1"), admin.ErrSessionNotFound,
+		"sessions of other users are not found")
+
+	n, err := a.RevokeUserSessions(ctx, "user:9", id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	sessions, err = a.UserSessions(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	require.Len(t, store.events, 2)
+	assert.Equal(t, models.EventSessionRevoked, store.events[0].Type)
+	assert.Equal(t, "user:9", store.events[0].Actor)
+	assert.Equal(t, "s2", store.events[0].Details["session_id"])
+	assert.Equal(t, models.EventSessionsRevoked, store.events[1].Type)
+
+	_, err = a.UserSessions(ctx, id+1)
+	require.ErrorIs(t, err, admin.ErrUserNotFound)
+	_, err = a.RevokeUserSessions(ctx, "user:9", id+1)
+	require.ErrorIs(t, err, admin.ErrUserNotFound)
+}
+
+func TestCheckMember(t *testing.T) {
+	store := newMemStore()
+	member := store.add(models.User{Email: "a@x.io", Status: models.UserStatusActive})
+	outsider := store.add(models.User{Email: "b@x.io", Status: models.UserStatusActive})
+	store.members = map[int64][]int64{7: {member}}
+	a := admin.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store, store, store)
+
+	require.NoError(t, a.CheckMember(context.Background(), 7, member))
+	require.ErrorIs(t, a.CheckMember(context.Background(), 7, outsider), admin.ErrUserNotFound,
+		"users outside of the organization are reported as missing")
+	require.ErrorIs(t, a.CheckMember(context.Background(), 8, member), admin.ErrUserNotFound)
+}
+
+type memStore struct {
+	users       map[int64]models.User
+	transitions []models.StatusTransition
+	revoked     map[int64]int
+	sessions    map[int64][]models.Session
+	events      []models.AuditEvent
+	members     map[int64][]int64 // user ids by organization id
+	nextID      int64
+}
+
+func newMemStore() *memStore {
+	return &memStore{users: map[int64]models.User{}, revoked: map[int64]int{}, sessions: map[int64][]models.Session{}}
+}
+
+func (m *memStore) add(user models.User) int64 {
+	m.nextID++
+	user.ID = m.nextID
+	m.users[user.ID] = user
+	return user.ID
+}
+
+func (m *memStore) Users(_ context.Context, filter models.UserFilter, afterID int64, limit int) ([]models.User, error) {
+	var users []models.User
+	for id := afterID + 1; id <= m.nextID && len(users) < limit; id++ {
+		user, ok := m.users[id]
+		if !ok || !strings.HasPrefix(user.Email, filter.EmailPrefix) {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (m *memStore) UserByID(_ context.Context, id int64) (models.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (m *memStore) update(id int64, f func(*models.User)) error {
+	user, ok := m.users[id]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	f(&user)
+	m.users[id] = user
+	return nil
+}
+
+func (m *memStore) ChangeUserStatus(_ context.Context, t models.StatusTransition) error {
+	user, ok := m.users[t.UserID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	if user.Status != t.From {
+		return storage.ErrStatusConflict
+	}
+	user.Status, user.StatusReason, user.SuspendedUntil = t.To, t.Reason, t.Until
+	m.users[t.UserID] = user
+	m.transitions = append(m.transitions, t)
+	return nil
+}
+
+func (m *memStore) StatusTransitions(_ context.Context, userID int64) ([]models.StatusTransition, error) {
+	var transitions []models.StatusTransition
+	for _, t := range m.transitions {
+		if t.UserID == userID {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions, nil
+}
+
+func (m *memStore) Membership(_ context.Context, orgID, userID int64) (models.Membership, error) {
+	if !slices.Contains(m.members[orgID], userID) {
+		return models.Membership{}, storage.ErrMemberNotFound
+	}
+	return models.Membership{OrgID: orgID, UserID: userID}, nil
+}
+
+func (m *memStore) SetUserRoles(_ context.Context, id int64, roles []string) error {
+	return m.update(id, func(u *models.User) { u.Roles = roles })
+}
+
+func (m *memStore) UpdatePassword(_ context.Context, id int64, passHash []byte) error {
+	return m.update(id, func(u *models.User) { u.PassHash = passHash })
+}
+
+func (m *memStore) Sessions(_ context.Context, userID int64) ([]models.Session, error) {
+	return m.sessions[userID], nil
+}
+
+func (m *memStore) RevokeSession(_ context.Context, userID int64, id string) error {
+	i := slices.IndexFunc(m.sessions[userID], func(s models.Session) bool { return s.ID == id })
+	if i < 0 {
+		return storage.ErrSessionNotFound
+	}
+	m.sessions[userID] = slices.Delete(m.sessions[userID], i, i+1)
+	return nil
+}
+
+func (m *memStore) RevokeSessions(_ context.Context, userID int64, _ string) (int64, error) {
+	m.revoked[userID]++
+	n := len(m.sessions[userID])
+	delete(m.sessions, userID)
+	return int64(n), nil
+}
+
+func (m *memStore) Record(_ context.Context, event models.AuditEvent) {
+	event.ID = int64(len(m.events) + 1)
+	m.events = append(m.events, event)
+}
+
+func (m *memStore) AuditEvents(
+	_ context.Context,
+	filter models.AuditFilter,
+	beforeID int64,
+	limit int,
+) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := m.events[i]
+		if (beforeID != 0 && event.ID >= beforeID) || (filter.Actor != "" && event.Actor != filter.Actor) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
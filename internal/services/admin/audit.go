@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/pagetoken"
+	"log/slog"
+)
+
+type AuditLog interface {
+	AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+// AuditEvents returns a page of audit events matching the filter, newest first, and the token of the next page.
+// The next page token is empty on the last page.
+func (a *Admin) AuditEvents(
+	ctx context.Context,
+	filter models.AuditFilter,
+	pageToken string,
+	pageSize int,
+) ([]models.AuditEvent, string, error) {
+	const op = "admin.AuditEvents"
+
+	log := a.log.With(slog.String("op", op))
+
+	beforeID, err := pagetoken.Decode(pageToken)
+	if err != nil {
+		log.Warn("invalid page token", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+	}
+
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	pageSize = min(pageSize, MaxPageSize)
+
+	// One extra event tells whether there is a next page.
+	events, err := a.auditLog.AuditEvents(ctx, filter, beforeID, pageSize+1)
+	if err != nil {
+		log.Error("failed to list audit events", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		next = pagetoken.Encode(events[pageSize-1].ID)
+	}
+
+	return events, next, nil
+}
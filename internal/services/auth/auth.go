@@ -5,9 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/lib/identifier"
 	"grpc-service-ref/internal/lib/jwt"
 	"grpc-service-ref/internal/storage"
 	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -17,25 +21,69 @@ type Auth struct {
 	log         *slog.Logger
 	usrProvider UserProvider
 	usrSaver    UserSaver
+	sessions    SessionStore
+	apiKeys     APIKeyStore
+	svcAccounts ServiceAccountStore
+	statuses    StatusStore
+	orgs        OrgStore
+	invites     InviteStore
+	groups      GroupStore
+	auditor     Auditor
+	emails      emailaddr.Normalizer
+	register    RegistrationOptions
+	// profileClaims are the field mask paths of the profile fields projected into the tokens of users.
+	profileClaims []string
+	// tokenSecret is the HMAC key signing the issued tokens.
+	tokenSecret []byte
 	tokenTTL    time.Duration
 }
 
+// RegistrationOptions tell who may register and which identifiers new users must register with.
+// Users always need at least one of them.
+type RegistrationOptions struct {
+	// Mode defaults to RegistrationOpen.
+	Mode RegistrationMode
+	// AllowedDomains are the email domains users register with in RegistrationDomainAllowList mode.
+	AllowedDomains []string
+
+	RequireEmail    bool
+	RequireUsername bool
+	RequirePhone    bool
+}
+
 type UserSaver interface {
-  SaveUser(
+	CreateUser(
 		ctx context.Context,
-		email string,
+		ids models.UserIdentifiers,
 		passHash []byte,
 	) (uid int64, err error)
 }
 
+// Auditor records audit events. Recording never fails the audited operation.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	UserByUsername(ctx context.Context, username string) (models.User, error)
+	UserByPhone(ctx context.Context, phone string) (models.User, error)
 }
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidEmail       = errors.New("invalid email")
 	ErrUserExists         = errors.New("user already exists")
     ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidUsername    = errors.New("invalid username")
+	ErrInvalidPhone       = errors.New("invalid phone number")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrPhoneTaken         = errors.New("phone number already taken")
+	ErrEmailRequired      = errors.New("email is required")
+	ErrUsernameRequired   = errors.New("username is required")
+	ErrPhoneRequired      = errors.New("phone number is required")
+	ErrIdentifierRequired = errors.New("email, username or phone number is required")
 )
 
 // New returns a new instance of the Auth service
@@ -43,77 +91,210 @@ func New(
 	log *slog.Logger,
 	userSaver UserSaver,
 	userProvider UserProvider,
+	sessions SessionStore,
+	apiKeys APIKeyStore,
+	serviceAccounts ServiceAccountStore,
+	statuses StatusStore,
+	orgs OrgStore,
+	invites InviteStore,
+	groups GroupStore,
+	auditor Auditor,
+	emails emailaddr.Normalizer,
+	registration RegistrationOptions,
+	profileClaims []string,
+	tokenSecret []byte,
 	tokenTTL time.Duration,
 ) *Auth {
 	return &Auth{
-		log:         log,
-		usrProvider: userProvider,
-		usrSaver:    userSaver,
-		tokenTTL:    tokenTTL,
+		log:           log,
+		usrProvider:   userProvider,
+		usrSaver:      userSaver,
+		sessions:      sessions,
+		apiKeys:       apiKeys,
+		svcAccounts:   serviceAccounts,
+		statuses:      statuses,
+		orgs:          orgs,
+		invites:       invites,
+		groups:        groups,
+		auditor:       auditor,
+		emails:        emails,
+		register:      registration,
+		profileClaims: profileClaims,
+		tokenSecret:   tokenSecret,
+		tokenTTL:      tokenTTL,
 	}
 }
 
 // Login chechs if user with given credentials exists in the system.
+// The user is looked up by the login, which is an email, a username or an E.164 phone number.
+// On success a new session is started for the client.
 //
 // If user exists, but password is incorrect, returns error.
 // If user doesn't exist, returns error
 func (a *Auth) Login(
-  ctx context.Context,
-	email string,
+	ctx context.Context,
+	login string,
 	password string,
+	client models.ClientInfo,
 ) (string, error) {
     const op = "auth.Login"
 
 	log := a.log.With(
 		slog.String("op", op),
-		slog.String("username", email),
+		slog.String("username", login),
 	)
 
 	log.Info("attempting to login user")
 
-	user, err := a.usrProvider.User(ctx, email)
+	kind, id, err := a.normalizeLogin(login)
+	if err != nil {
+		// Identifiers that can't be normalized were never registered.
+		log.Warn("invalid login", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	user, err := a.userByIdentifier(ctx, kind, id, login)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
 			a.log.Warn("user not found", slog.String("err", err.Error()))
+			event := loginEvent("", client, "user_not_found")
+			event.Details[string(kind)] = id
+			a.auditor.Record(ctx, event)
 			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 		}
 		a.log.Error("failed to get user", slog.String("err", err.Error()))
 		return "", fmt.Errorf("%s: %w", op, err)
     }
 
+	// Deleted users are reported as missing.
+	if user.Status == models.UserStatusDeleted {
+		log.Warn("user is deleted")
+		a.auditor.Record(ctx, loginEvent(models.UserPrincipal(user).String(), client, "user_deleted"))
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
 	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
 		a.log.Info("invalid credentials", slog.String("err", err.Error()))
+		a.auditor.Record(ctx, loginEvent(models.UserPrincipal(user).String(), client, "invalid_password"))
 		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 	}
 
 	log.Info("user logged in successfully")
 
-	// TODO: token
-	token, err := jwt.NewToken(user, a.tokenTTL)
+	token, err := a.IssueToken(ctx, user, client)
 	if err != nil {
-		a.log.Error("failed to generate token", slog.String("err", err.Error()))
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	return token, nil
 }
 
-// RegisterNewUser registers new user in the system and returns user ID.
-// If user with given username already exists, returns error.
+// IssueToken starts a new session of the user on the client and returns its token.
+// Users whose status does not allow them to log in get no token. A client logging in
+// to an organization gets a token scoped to it, which only its members get.
+func (a *Auth) IssueToken(
+	ctx context.Context,
+	user models.User,
+	client models.ClientInfo,
+) (string, error) {
+	const op = "auth.IssueToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", user.ID),
+	)
+
+	subject := models.UserPrincipal(user).String()
+
+	if err := a.checkStatus(ctx, log, user); err != nil {
+		log.Info("user may not log in", slog.String("err", err.Error()))
+		if reason := statusReason(err); reason != "" {
+			a.auditor.Record(ctx, loginEvent(subject, client, reason))
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	principal, err := a.loginPrincipal(ctx, log, user, client.Org)
+	if err != nil {
+		if errors.Is(err, ErrNotMember) {
+			log.Info("user is not a member of the organization", slog.String("org", client.Org))
+			a.auditor.Record(ctx, loginEvent(subject, client, "not_member"))
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	sid, err := newSessionID()
+	if err != nil {
+		log.Error("failed to generate session id", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         sid,
+		UserID:     user.ID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(a.tokenTTL),
+	}
+
+	if err := a.sessions.SaveSession(ctx, session); err != nil {
+		log.Error("failed to save session", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims := jwt.ProfileClaims(user.Profile, a.profileClaims, client.App)
+	token, err := jwt.NewTokenWithClaims(principal, sid, a.tokenTTL, claims, a.tokenSecret)
+	if err != nil {
+		log.Error("failed to generate token", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	event := loginEvent(subject, client, "")
+	event.Details["session_id"] = sid
+	if principal.OrgID != 0 {
+		event.Details[models.DetailOrg] = strconv.FormatInt(principal.OrgID, 10)
+	}
+	a.auditor.Record(ctx, event)
+
+	return token, nil
+}
+
+// RegisterNewUser registers new user with the identifiers in the system and returns user ID.
+// The user needs the identifiers the registration options require, and at least one of them.
+// Whether the invite code is required depends on the registration mode; a user registered
+// with one gets the roles and the membership of the code, which can't be used again.
+// If user with any of the identifiers already exists, returns error.
 func (a *Auth) RegisterNewUser(
 	ctx context.Context,
-	email string,
+	ids models.UserIdentifiers,
 	password string,
+	inviteCode string,
 ) (int64, error) {
 	const op = "Auth.RegisterNewUser"
 
 	log := a.log.With(
 		slog.String("op", op),
-		slog.String("email", email),
+		slog.String("email", ids.Email),
+		slog.String("username", ids.Username),
 	)
 
 	log.Info("registering user")
 
+	email := ids.Email
+	ids, err := a.normalizeIdentifiers(ids)
+	if err != nil {
+		log.Warn("invalid identifiers", slog.String("err", err.Error()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := a.admit(ctx, log, ids, inviteCode)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
 	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 
 	if err != nil {
@@ -121,18 +302,191 @@ func (a *Auth) RegisterNewUser(
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	id, err := a.usrSaver.SaveUser(ctx, email, passHash)
+	var id int64
+	if err = a.checkStoredEmail(ctx, email, ids.Email); err == nil {
+		if code.ID != 0 {
+			id, err = a.invites.CreateInvitedUser(ctx, ids, passHash, code.ID, time.Now())
+		} else {
+			id, err = a.usrSaver.CreateUser(ctx, ids, passHash)
+		}
+	}
 	if err != nil {
-		if errors.Is(err, storage.ErrUserExists) {
-			log.Warn("user already exists", slog.String("err", err.Error()))
-		    return 0, fmt.Errorf("%s: %w", op, ErrUserExists)
+		var taken error
+		var reason string
+		switch {
+		case errors.Is(err, storage.ErrInviteCodeNotFound):
+			// The code was used or revoked since it was checked.
+			taken, reason = ErrInvalidInviteCode, "invalid_invite_code"
+		case errors.Is(err, storage.ErrUserExists):
+			taken, reason = ErrUserExists, "user_exists"
+		case errors.Is(err, storage.ErrUsernameTaken):
+			taken, reason = ErrUsernameTaken, "username_taken"
+		case errors.Is(err, storage.ErrPhoneTaken):
+			taken, reason = ErrPhoneTaken, "phone_taken"
+		default:
+			log.Error("failed to save user", slog.String("err", err.Error()))
+			return 0, fmt.Errorf("%s: %w", op, err)
 		}
 
-		log.Error("failed to save user", slog.String("err", err.Error()))
-		return 0, fmt.Errorf("%s: %w", op, err)
+		log.Warn("user not created", slog.String("err", err.Error()))
+		a.auditor.Record(ctx, models.AuditEvent{
+			Type:    models.EventUserRegistered,
+			Outcome: models.OutcomeFailure,
+			Reason:  reason,
+			Details: identifierDetails(ids),
+		})
+		return 0, fmt.Errorf("%s: %w", op, taken)
 	}
 
 	log.Info("user registered")
 
+	subject := models.Principal{Type: models.PrincipalUser, ID: id}.String()
+	details := identifierDetails(ids)
+	if code.ID != 0 {
+		details["invite_code_id"] = strconv.FormatInt(code.ID, 10)
+		if code.OrgID != 0 {
+			details[models.DetailOrg] = strconv.FormatInt(code.OrgID, 10)
+		}
+	}
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventUserRegistered,
+		Actor:   subject,
+		Subject: subject,
+		Details: details,
+	})
+
 	return id, nil
 }
+
+// normalizeLogin tells the kind of identifier the login is and normalizes it.
+func (a *Auth) normalizeLogin(login string) (identifier.Kind, string, error) {
+	kind := identifier.Classify(login)
+
+	var id string
+	var err error
+	switch kind {
+	case identifier.KindEmail:
+		id, err = a.emails.Normalize(login)
+	case identifier.KindPhone:
+		id, err = identifier.NormalizePhone(login)
+	default:
+		id, err = identifier.NormalizeUsername(login)
+	}
+
+	return kind, id, err
+}
+
+// userByIdentifier returns the user with the normalized identifier of the kind.
+// Users with an email stored in a form that isn't the normalized one of today,
+// as after an upgrade left IDN domains unconverted or provider rules were switched,
+// are looked up by the login as given.
+func (a *Auth) userByIdentifier(ctx context.Context, kind identifier.Kind, id, login string) (models.User, error) {
+	switch kind {
+	case identifier.KindEmail:
+		user, err := a.usrProvider.User(ctx, id)
+		if raw := strings.TrimSpace(login); errors.Is(err, storage.ErrUserNotFound) && !strings.EqualFold(raw, id) {
+			return a.usrProvider.User(ctx, raw)
+		}
+		return user, err
+	case identifier.KindPhone:
+		return a.usrProvider.UserByPhone(ctx, id)
+	default:
+		return a.usrProvider.UserByUsername(ctx, id)
+	}
+}
+
+// checkStoredEmail returns storage.ErrUserExists if a user has the email as given
+// stored in place of its normalized form, which the unique index can't tell apart.
+func (a *Auth) checkStoredEmail(ctx context.Context, email, normalized string) error {
+	raw := strings.TrimSpace(email)
+	if normalized == "" || strings.EqualFold(raw, normalized) {
+		return nil
+	}
+
+	_, err := a.usrProvider.User(ctx, raw)
+	switch {
+	case err == nil:
+		return storage.ErrUserExists
+	case errors.Is(err, storage.ErrUserNotFound):
+		return nil
+	default:
+		return err
+	}
+}
+
+// normalizeIdentifiers normalizes the identifiers of a new user and checks
+// the ones the registration options require are there.
+func (a *Auth) normalizeIdentifiers(ids models.UserIdentifiers) (models.UserIdentifiers, error) {
+	var err error
+
+	if ids.Email != "" {
+		if ids.Email, err = a.emails.Normalize(ids.Email); err != nil {
+			return models.UserIdentifiers{}, ErrInvalidEmail
+		}
+	}
+	if ids.Username != "" {
+		if ids.Username, err = identifier.NormalizeUsername(ids.Username); err != nil {
+			return models.UserIdentifiers{}, ErrInvalidUsername
+		}
+	}
+	if ids.Phone != "" {
+		if ids.Phone, err = identifier.NormalizePhone(ids.Phone); err != nil {
+			return models.UserIdentifiers{}, ErrInvalidPhone
+		}
+	}
+
+	switch {
+	case a.register.RequireEmail && ids.Email == "":
+		return models.UserIdentifiers{}, ErrEmailRequired
+	case a.register.RequireUsername && ids.Username == "":
+		return models.UserIdentifiers{}, ErrUsernameRequired
+	case a.register.RequirePhone && ids.Phone == "":
+		return models.UserIdentifiers{}, ErrPhoneRequired
+	case ids == models.UserIdentifiers{}:
+		return models.UserIdentifiers{}, ErrIdentifierRequired
+	}
+
+	return ids, nil
+}
+
+// identifierDetails returns the audit details of the identifiers a user registers with.
+func identifierDetails(ids models.UserIdentifiers) map[string]string {
+	details := map[string]string{}
+	if ids.Email != "" {
+		details["email"] = ids.Email
+	}
+	if ids.Username != "" {
+		details["username"] = ids.Username
+	}
+	if ids.Phone != "" {
+		details["phone"] = ids.Phone
+	}
+
+	return details
+}
+
+// loginEvent returns the audit event of a login of the subject from the client.
+// A non-empty reason makes it the event of a failed login.
+func loginEvent(subject string, client models.ClientInfo, reason string) models.AuditEvent {
+	event := models.AuditEvent{
+		Type:      models.EventLoginSucceeded,
+		Actor:     subject,
+		Subject:   subject,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Details:   map[string]string{},
+	}
+	if client.App != "" {
+		event.Details[models.DetailApp] = client.App
+	}
+	if client.Org != "" {
+		event.Details["org"] = client.Org
+	}
+	if reason != "" {
+		event.Type = models.EventLoginFailed
+		event.Outcome = models.OutcomeFailure
+		event.Reason = reason
+	}
+
+	return event
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/services/auth"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin_ProfileClaims(t *testing.T) {
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store := &memStore{user: models.User{
+		ID:       1,
+		Email:    "user@example.com",
+		PassHash: passHash,
+		Status:   models.UserStatusActive,
+		Profile: models.Profile{
+			DisplayName: "Ann",
+			Locale:      "en-US",
+			Attributes:  models.Attributes{"shop": {"plan": "pro"}},
+		},
+	}}
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, nil, nil, nil, store, emailaddr.Normalizer{}, auth.RegistrationOptions{},
+		[]string{models.ProfileDisplayName, "attributes.shop.plan"}, secret, time.Hour)
+
+	tests := []struct {
+		name     string
+		app      string
+		expected gojwt.MapClaims
+	}{
+		{
+			name:     "Owning app",
+			app:      "shop",
+			expected: gojwt.MapClaims{"name": "Ann", "attributes": map[string]any{"plan": "pro"}},
+		},
+		{
+			name:     "Other app",
+			app:      "blog",
+			expected: gojwt.MapClaims{"name": "Ann"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := a.Login(context.Background(), "user@example.com", "password", models.ClientInfo{App: tt.app})
+			require.NoError(t, err)
+
+			claims := gojwt.MapClaims{}
+			_, _, err = gojwt.NewParser().ParseUnverified(token, claims)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected["name"], claims["name"])
+			assert.Equal(t, tt.expected["attributes"], claims["attributes"])
+			assert.NotContains(t, claims, "locale", "only the configured fields are projected")
+		})
+	}
+}
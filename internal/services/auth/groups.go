@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"grpc-service-ref/internal/domain/models"
+	"log/slog"
+)
+
+// GroupStore resolves the groups of users.
+type GroupStore interface {
+	UserGroups(ctx context.Context, userID int64) ([]models.UserGroup, error)
+}
+
+// userPrincipal returns the principal of the user with the roles of its groups, nested ones included,
+// added to its own and the names of the groups as a summary of its memberships.
+func (a *Auth) userPrincipal(ctx context.Context, log *slog.Logger, user models.User) (models.Principal, error) {
+	principal := models.UserPrincipal(user)
+	if a.groups == nil {
+		return principal, nil
+	}
+
+	groups, err := a.groups.UserGroups(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to get user groups", slog.String("err", err.Error()))
+		return models.Principal{}, err
+	}
+	if len(groups) == 0 {
+		return principal, nil
+	}
+
+	access := models.ResolveAccess(user.Roles, groups)
+	principal.Roles = access.Roles
+	principal.Groups = access.GroupNames()
+
+	return principal, nil
+}
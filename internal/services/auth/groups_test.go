@@ -0,0 +1,46 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin_Groups(t *testing.T) {
+	ctx := context.Background()
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store := memory.New()
+	uid, err := store.CreateUser(ctx, models.UserIdentifiers{Email: "user@example.com"}, passHash)
+	require.NoError(t, err)
+	team, err := store.CreateGroup(ctx, models.Group{Name: "team", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	staff, err := store.CreateGroup(ctx, models.Group{Name: "staff", Roles: []string{models.RoleSupport}, CreatedAt: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, store.AddGroupUser(ctx, team, uid))
+	require.NoError(t, store.AddSubgroup(ctx, staff, team))
+
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, store, nil, store, &memStore{}, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+	token, err := a.Login(ctx, "user@example.com", "password", models.ClientInfo{})
+	require.NoError(t, err)
+
+	caller, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleSupport}, caller.Principal.Roles, "roles of nested groups are inherited")
+	assert.Equal(t, []string{"staff", "team"}, caller.Principal.Groups)
+	assert.True(t, caller.Principal.HasPermission(models.PermissionGroupsRead))
+}
@@ -0,0 +1,171 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin_Identifiers(t *testing.T) {
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		login       string
+		expectedErr error
+	}{
+		{name: "Email", login: "user@Example.COM"},
+		{name: "Username", login: "Jane.Doe"},
+		{name: "Phone", login: "+1 415-555-0123"},
+		{name: "UnknownUsername", login: "john", expectedErr: auth.ErrInvalidCredentials},
+		{name: "InvalidUsername", login: "j", expectedErr: auth.ErrInvalidCredentials},
+		{name: "InvalidPhone", login: "+0123", expectedErr: auth.ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memStore{user: models.User{
+				ID:       1,
+				Email:    "user@example.com",
+				Username: "jane.doe",
+				Phone:    "+14155550123",
+				PassHash: passHash,
+				Status:   models.UserStatusActive,
+			}}
+			a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+				store, store, store, nil, nil, store, nil, nil, nil, store, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+			token, err := a.Login(context.Background(), tt.login, "password", models.ClientInfo{})
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, token)
+		})
+	}
+}
+
+func TestRegisterNewUser_Identifiers(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     auth.RegistrationOptions
+		ids         models.UserIdentifiers
+		expectedErr error
+	}{
+		{
+			name: "EmailOnly",
+			ids:  models.UserIdentifiers{Email: "user@example.com"},
+		},
+		{
+			name: "UsernameOnly",
+			ids:  models.UserIdentifiers{Username: "jane"},
+		},
+		{
+			name:        "NoIdentifier",
+			expectedErr: auth.ErrIdentifierRequired,
+		},
+		{
+			name:        "EmailRequired",
+			options:     auth.RegistrationOptions{RequireEmail: true},
+			ids:         models.UserIdentifiers{Username: "jane"},
+			expectedErr: auth.ErrEmailRequired,
+		},
+		{
+			name:        "PhoneRequired",
+			options:     auth.RegistrationOptions{RequireEmail: true, RequirePhone: true},
+			ids:         models.UserIdentifiers{Email: "user@example.com"},
+			expectedErr: auth.ErrPhoneRequired,
+		},
+		{
+			name:        "InvalidUsername",
+			ids:         models.UserIdentifiers{Email: "user@example.com", Username: "jane@doe"},
+			expectedErr: auth.ErrInvalidUsername,
+		},
+		{
+			name:        "InvalidPhone",
+			ids:         models.UserIdentifiers{Phone: "555-0123"},
+			expectedErr: auth.ErrInvalidPhone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.New()
+			a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+				store, store, store, nil, nil, store, nil, nil, nil, &memStore{}, emailaddr.Normalizer{}, tt.options, nil, secret, time.Hour)
+
+			id, err := a.RegisterNewUser(context.Background(), tt.ids, "password", "")
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotZero(t, id)
+		})
+	}
+}
+
+func TestRegisterNewUser_IdentifierTaken(t *testing.T) {
+	store := memory.New()
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, nil, nil, nil, &memStore{}, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+	_, err := a.RegisterNewUser(context.Background(), models.UserIdentifiers{Username: "jane", Phone: "+14155550123"}, "password", "")
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(context.Background(), models.UserIdentifiers{Username: "JANE"}, "password", "")
+	require.ErrorIs(t, err, auth.ErrUsernameTaken)
+
+	_, err = a.RegisterNewUser(context.Background(), models.UserIdentifiers{Phone: "+1 (415) 555-0123"}, "password", "")
+	require.ErrorIs(t, err, auth.ErrPhoneTaken)
+
+	token, err := a.Login(context.Background(), "+14155550123", "password", models.ClientInfo{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+}
+
+func TestLogin_EmailStoredUnnormalized(t *testing.T) {
+	tests := []struct {
+		name   string
+		emails emailaddr.Normalizer
+		stored string
+		login  string
+	}{
+		{name: "IDNDomain", stored: "user@bücher.de", login: "user@Bücher.de"},
+		{name: "ProviderRules", emails: emailaddr.Normalizer{ProviderRules: true}, stored: "bob+news@gmail.com", login: "Bob+news@gmail.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+			require.NoError(t, err)
+
+			store := memory.New()
+			_, err = store.CreateUser(context.Background(), models.UserIdentifiers{Email: tt.stored}, passHash)
+			require.NoError(t, err)
+
+			a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+				store, store, store, nil, nil, store, nil, nil, nil, &memStore{}, tt.emails, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+			token, err := a.Login(context.Background(), tt.login, "password", models.ClientInfo{})
+			require.NoError(t, err)
+			assert.NotEmpty(t, token)
+
+			_, err = a.RegisterNewUser(context.Background(), models.UserIdentifiers{Email: tt.login}, "password", "")
+			require.ErrorIs(t, err, auth.ErrUserExists)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+)
+
+type OrgStore interface {
+	OrganizationBySlug(ctx context.Context, slug string) (models.Organization, error)
+	Membership(ctx context.Context, orgID, userID int64) (models.Membership, error)
+}
+
+// ErrNotMember is the error of users logging in to an organization they are not a member of.
+// Unknown organizations are reported the same way.
+var ErrNotMember = errors.New("user is not a member of the organization")
+
+// loginPrincipal returns the principal the user logs in as, with the roles of its groups.
+// Logging in to an organization replaces the global roles and groups of the user with the roles
+// of its membership.
+func (a *Auth) loginPrincipal(
+	ctx context.Context,
+	log *slog.Logger,
+	user models.User,
+	orgSlug string,
+) (models.Principal, error) {
+	if orgSlug == "" {
+		return a.userPrincipal(ctx, log, user)
+	}
+
+	org, err := a.orgs.OrganizationBySlug(ctx, orgSlug)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrgNotFound) {
+			return models.Principal{}, ErrNotMember
+		}
+		log.Error("failed to get organization", slog.String("err", err.Error()))
+		return models.Principal{}, err
+	}
+
+	return a.tokenPrincipal(ctx, log, user, org.ID)
+}
+
+// tokenPrincipal returns the current principal of the user for a token issued by loginPrincipal,
+// scoped to the organization unless orgID is zero.
+func (a *Auth) tokenPrincipal(ctx context.Context, log *slog.Logger, user models.User, orgID int64) (models.Principal, error) {
+	if orgID == 0 {
+		return a.userPrincipal(ctx, log, user)
+	}
+
+	membership, err := a.membership(ctx, log, orgID, user.ID)
+	if err != nil {
+		return models.Principal{}, err
+	}
+
+	principal := models.UserPrincipal(user)
+	principal.Roles = nil
+	principal.OrgID = orgID
+	principal.OrgRoles = membership.Roles
+
+	return principal, nil
+}
+
+// membership returns the membership of the user in the organization, ErrNotMember if there is none.
+func (a *Auth) membership(ctx context.Context, log *slog.Logger, orgID, userID int64) (models.Membership, error) {
+	membership, err := a.orgs.Membership(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			return models.Membership{}, ErrNotMember
+		}
+		log.Error("failed to get membership", slog.String("err", err.Error()))
+		return models.Membership{}, err
+	}
+
+	return membership, nil
+}
@@ -0,0 +1,83 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin_Organization(t *testing.T) {
+	ctx := context.Background()
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store := memory.New()
+	uid, err := store.CreateUser(ctx, models.UserIdentifiers{Email: "user@example.com"}, passHash)
+	require.NoError(t, err)
+	require.NoError(t, store.SetUserRoles(ctx, uid, []string{models.RoleAdmin}))
+	orgID, err := store.CreateOrganization(ctx,
+		models.Organization{Slug: "acme", Name: "Acme", CreatedAt: time.Now()},
+		models.Membership{UserID: uid, Roles: []string{models.OrgRoleMember}, CreatedAt: time.Now()},
+	)
+	require.NoError(t, err)
+	_, err = store.CreateOrganization(ctx,
+		models.Organization{Slug: "other", Name: "Other", CreatedAt: time.Now()},
+		models.Membership{UserID: mustCreateUser(t, store, "other@example.com"), CreatedAt: time.Now()},
+	)
+	require.NoError(t, err)
+
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, store, nil, nil, &memStore{}, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+	token, err := a.Login(ctx, "user@example.com", "password", models.ClientInfo{Org: "acme"})
+	require.NoError(t, err)
+
+	caller, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, orgID, caller.Principal.OrgID)
+	assert.Equal(t, []string{models.OrgRoleMember}, caller.Principal.OrgRoles)
+	assert.Empty(t, caller.Principal.Roles, "global roles do not apply within an organization")
+	assert.False(t, caller.Principal.HasPermission(models.PermissionUsersWrite))
+
+	// Roles follow the membership rather than the token.
+	require.NoError(t, store.SetMemberRoles(ctx, orgID, uid, []string{models.OrgRoleAdmin}))
+	caller, err = a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.True(t, caller.Principal.HasPermission(models.PermissionMembersWrite))
+
+	for _, org := range []string{"other", "missing"} {
+		_, err = a.Login(ctx, "user@example.com", "password", models.ClientInfo{Org: org})
+		require.ErrorIs(t, err, auth.ErrNotMember, org)
+	}
+
+	require.NoError(t, store.RemoveMember(ctx, orgID, uid))
+	_, err = a.ValidateToken(ctx, token)
+	require.ErrorIs(t, err, auth.ErrInvalidToken, "tokens end with the membership")
+
+	token, err = a.Login(ctx, "user@example.com", "password", models.ClientInfo{})
+	require.NoError(t, err)
+	caller, err = a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Zero(t, caller.Principal.OrgID)
+	assert.Equal(t, []string{models.RoleAdmin}, caller.Principal.Roles)
+}
+
+func mustCreateUser(t *testing.T, store *memory.Storage, email string) int64 {
+	t.Helper()
+
+	id, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: email}, []byte("hash"))
+	require.NoError(t, err)
+
+	return id
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RegistrationMode tells who may register.
+type RegistrationMode string
+
+const (
+	// RegistrationOpen lets anyone register.
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInviteOnly requires an invite code.
+	RegistrationInviteOnly RegistrationMode = "invite_only"
+	// RegistrationDomainAllowList requires an email in one of the allowed domains, or an invite code.
+	RegistrationDomainAllowList RegistrationMode = "domain_allowlist"
+	// RegistrationClosed rejects every registration, invite codes included.
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+// Valid reports whether the mode is known. The empty mode is RegistrationOpen.
+func (m RegistrationMode) Valid() bool {
+	switch m {
+	case "", RegistrationOpen, RegistrationInviteOnly, RegistrationDomainAllowList, RegistrationClosed:
+		return true
+	default:
+		return false
+	}
+}
+
+// InviteStore redeems invite codes.
+type InviteStore interface {
+	InviteCodeByHash(ctx context.Context, hash []byte) (models.InviteCode, error)
+	CreateInvitedUser(ctx context.Context,
+		ids models.UserIdentifiers,
+		passHash []byte,
+		codeID int64,
+		usedAt time.Time,
+	) (int64, error)
+}
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrInviteRequired     = errors.New("invite code is required")
+	ErrDomainNotAllowed   = errors.New("email domain is not allowed")
+	// ErrInvalidInviteCode is returned for unknown, used, revoked and expired codes alike,
+	// and for codes bound to another email.
+	ErrInvalidInviteCode = errors.New("invalid invite code")
+)
+
+// admit checks that the registration mode lets the user with the normalized identifiers register,
+// and returns the pending invite code the user registers with, if any.
+func (a *Auth) admit(
+	ctx context.Context,
+	log *slog.Logger,
+	ids models.UserIdentifiers,
+	inviteCode string,
+) (models.InviteCode, error) {
+	mode := a.register.Mode
+
+	reject := func(err error, reason string) (models.InviteCode, error) {
+		log.Warn("registration rejected", slog.String("reason", reason))
+		a.auditor.Record(ctx, models.AuditEvent{
+			Type:    models.EventUserRegistered,
+			Outcome: models.OutcomeFailure,
+			Reason:  reason,
+			Details: identifierDetails(ids),
+		})
+		return models.InviteCode{}, err
+	}
+
+	if mode == RegistrationClosed {
+		return reject(ErrRegistrationClosed, "registration_closed")
+	}
+
+	if inviteCode != "" {
+		code, err := a.invites.InviteCodeByHash(ctx, models.HashInviteCode(inviteCode))
+		if err != nil {
+			if errors.Is(err, storage.ErrInviteCodeNotFound) {
+				return reject(ErrInvalidInviteCode, "invalid_invite_code")
+			}
+			log.Error("failed to get invite code", slog.String("err", err.Error()))
+			return models.InviteCode{}, err
+		}
+		if !code.Pending(time.Now()) || (code.Email != "" && !strings.EqualFold(code.Email, ids.Email)) {
+			return reject(ErrInvalidInviteCode, "invalid_invite_code")
+		}
+
+		return code, nil
+	}
+
+	switch mode {
+	case RegistrationInviteOnly:
+		return reject(ErrInviteRequired, "invite_required")
+	case RegistrationDomainAllowList:
+		if !a.domainAllowed(ids.Email) {
+			return reject(ErrDomainNotAllowed, "domain_not_allowed")
+		}
+	}
+
+	return models.InviteCode{}, nil
+}
+
+// AdmitUser checks that the registration mode lets the user with the normalized email register
+// without an invite code, as users provisioned by an identity provider do.
+func (a *Auth) AdmitUser(ctx context.Context, email string) error {
+	const op = "Auth.AdmitUser"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	if _, err := a.admit(ctx, log, models.UserIdentifiers{Email: email}, ""); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// domainAllowed reports whether the normalized email is in one of the allowed domains.
+// Domains match exactly, subdomains are not allowed implicitly.
+func (a *Auth) domainAllowed(email string) bool {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+
+	return slices.ContainsFunc(a.register.AllowedDomains, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimPrefix(allowed, "@"), domain)
+	})
+}
@@ -0,0 +1,195 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterNewUser_Modes(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     auth.RegistrationOptions
+		email       string
+		withCode    bool
+		expectedErr error
+	}{
+		{name: "Open", options: auth.RegistrationOptions{Mode: auth.RegistrationOpen}, email: "user@example.com"},
+		{
+			name:        "Closed",
+			options:     auth.RegistrationOptions{Mode: auth.RegistrationClosed},
+			email:       "user@example.com",
+			withCode:    true,
+			expectedErr: auth.ErrRegistrationClosed,
+		},
+		{
+			name:        "InviteOnlyWithoutCode",
+			options:     auth.RegistrationOptions{Mode: auth.RegistrationInviteOnly},
+			email:       "user@example.com",
+			expectedErr: auth.ErrInviteRequired,
+		},
+		{
+			name:     "InviteOnlyWithCode",
+			options:  auth.RegistrationOptions{Mode: auth.RegistrationInviteOnly},
+			email:    "user@example.com",
+			withCode: true,
+		},
+		{
+			name:    "AllowedDomain",
+			options: auth.RegistrationOptions{Mode: auth.RegistrationDomainAllowList, AllowedDomains: []string{"Example.com"}},
+			email:   "user@EXAMPLE.com",
+		},
+		{
+			name:        "OtherDomain",
+			options:     auth.RegistrationOptions{Mode: auth.RegistrationDomainAllowList, AllowedDomains: []string{"example.com"}},
+			email:       "user@mail.example.com",
+			expectedErr: auth.ErrDomainNotAllowed,
+		},
+		{
+			name:     "OtherDomainWithCode",
+			options:  auth.RegistrationOptions{Mode: auth.RegistrationDomainAllowList, AllowedDomains: []string{"example.com"}},
+			email:    "user@other.com",
+			withCode: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.New()
+			a := newRegistrationAuth(store, tt.options)
+
+			var code string
+			if tt.withCode {
+				code = saveInviteCode(t, store, models.InviteCode{})
+			}
+
+			id, err := a.RegisterNewUser(context.Background(), models.UserIdentifiers{Email: tt.email}, "password", code)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotZero(t, id)
+		})
+	}
+}
+
+func TestAdmitUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     auth.RegistrationOptions
+		email       string
+		expectedErr error
+	}{
+		{name: "Open", options: auth.RegistrationOptions{}, email: "user@example.com"},
+		{
+			name:        "Closed",
+			options:     auth.RegistrationOptions{Mode: auth.RegistrationClosed},
+			email:       "user@example.com",
+			expectedErr: auth.ErrRegistrationClosed,
+		},
+		{
+			name:        "Invite only",
+			options:     auth.RegistrationOptions{Mode: auth.RegistrationInviteOnly},
+			email:       "user@example.com",
+			expectedErr: auth.ErrInviteRequired,
+		},
+		{
+			name:    "Allowed domain",
+			options: auth.RegistrationOptions{Mode: auth.RegistrationDomainAllowList, AllowedDomains: []string{"example.com"}},
+			email:   "user@example.com",
+		},
+		{
+			name:        "Domain not allowed",
+			options:     auth.RegistrationOptions{Mode: auth.RegistrationDomainAllowList, AllowedDomains: []string{"example.com"}},
+			email:       "user@other.com",
+			expectedErr: auth.ErrDomainNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newRegistrationAuth(memory.New(), tt.options)
+
+			err := a.AdmitUser(context.Background(), tt.email)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRegisterNewUser_InviteCode(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	a := newRegistrationAuth(store, auth.RegistrationOptions{Mode: auth.RegistrationInviteOnly})
+
+	orgID, err := store.CreateOrganization(ctx,
+		models.Organization{Slug: "acme", Name: "Acme", CreatedAt: time.Now()},
+		models.Membership{UserID: mustCreateUser(t, store, "owner@example.com"), CreatedAt: time.Now()},
+	)
+	require.NoError(t, err)
+
+	code := saveInviteCode(t, store, models.InviteCode{
+		Email:    "invitee@example.com",
+		Roles:    []string{models.RoleSupport},
+		OrgID:    orgID,
+		OrgRoles: []string{models.OrgRoleMember},
+	})
+
+	_, err = a.RegisterNewUser(ctx, models.UserIdentifiers{Email: "other@example.com"}, "password", code)
+	require.ErrorIs(t, err, auth.ErrInvalidInviteCode, "codes bound to an email register it only")
+	_, err = a.RegisterNewUser(ctx, models.UserIdentifiers{Email: "invitee@example.com"}, "password", "ssoreg_unknown")
+	require.ErrorIs(t, err, auth.ErrInvalidInviteCode)
+
+	id, err := a.RegisterNewUser(ctx, models.UserIdentifiers{Email: "Invitee@Example.com"}, "password", code)
+	require.NoError(t, err)
+
+	user, err := store.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleSupport}, user.Roles)
+	m, err := store.Membership(ctx, orgID, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.OrgRoleMember}, m.Roles)
+
+	_, err = a.RegisterNewUser(ctx, models.UserIdentifiers{Username: "again"}, "password", code)
+	require.ErrorIs(t, err, auth.ErrInvalidInviteCode, "codes are used once")
+
+	expired := saveInviteCode(t, store, models.InviteCode{ExpiresAt: time.Now().Add(-time.Minute)})
+	_, err = a.RegisterNewUser(ctx, models.UserIdentifiers{Username: "late"}, "password", expired)
+	require.ErrorIs(t, err, auth.ErrInvalidInviteCode)
+}
+
+func newRegistrationAuth(store *memory.Storage, options auth.RegistrationOptions) *auth.Auth {
+	return auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, store, store, nil, &memStore{}, emailaddr.Normalizer{}, options, nil, secret, time.Hour)
+}
+
+// saveInviteCode saves the code, pending for an hour unless it has an expiry, and returns it in plain.
+func saveInviteCode(t *testing.T, store *memory.Storage, code models.InviteCode) string {
+	t.Helper()
+
+	plain := "ssoreg_" + t.Name() + time.Now().String()
+	code.Hash = models.HashInviteCode(plain)
+	code.CreatedAt = time.Now()
+	if code.ExpiresAt.IsZero() {
+		code.ExpiresAt = time.Now().Add(time.Hour)
+	}
+
+	_, err := store.SaveInviteCode(context.Background(), code)
+	require.NoError(t, err)
+
+	return plain
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/jwt"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// clientIDPrefix starts the client id of every service account.
+const clientIDPrefix = "sa_"
+
+type ServiceAccountStore interface {
+	SaveServiceAccount(ctx context.Context, sa models.ServiceAccount) (int64, error)
+	ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error)
+	ServiceAccountByClientID(ctx context.Context, clientID string) (models.ServiceAccount, error)
+	ServiceAccounts(ctx context.Context, owner models.Owner) ([]models.ServiceAccount, error)
+	UpdateServiceAccountRoles(ctx context.Context, id int64, roles []string) error
+	UpdateServiceAccountSecret(ctx context.Context, id int64, secretHash []byte) error
+	DisableServiceAccount(ctx context.Context, id int64, disabledAt time.Time) error
+}
+
+var (
+	ErrInvalidRole            = errors.New("invalid role")
+	ErrServiceAccountExists   = errors.New("service account already exists")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+)
+
+// CreateServiceAccount creates a new service account of the owner and returns its client secret
+// along with its record. The secret is not stored, only its hash, so it cannot be retrieved later.
+func (a *Auth) CreateServiceAccount(
+	ctx context.Context,
+	name string,
+	owner models.Owner,
+	roles []string,
+) (string, models.ServiceAccount, error) {
+	const op = "auth.CreateServiceAccount"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("owner", string(owner.Kind)+":"+owner.Name),
+		slog.String("name", name),
+	)
+
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		log.Warn("invalid roles", slog.String("err", err.Error()))
+		return "", models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	clientID, err := randomHex(8)
+	if err != nil {
+		log.Error("failed to generate client id", slog.String("err", err.Error()))
+		return "", models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		log.Error("failed to generate client secret", slog.String("err", err.Error()))
+		return "", models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sa := models.ServiceAccount{
+		Name:       name,
+		Owner:      owner,
+		ClientID:   clientIDPrefix + clientID,
+		SecretHash: hashClientSecret(secret),
+		Roles:      roles,
+		CreatedAt:  time.Now(),
+	}
+
+	id, err := a.svcAccounts.SaveServiceAccount(ctx, sa)
+	if err != nil {
+		if errors.Is(err, storage.ErrServiceAccountExists) {
+			log.Warn("service account already exists")
+			return "", models.ServiceAccount{}, fmt.Errorf("%s: %w", op, ErrServiceAccountExists)
+		}
+		log.Error("failed to save service account", slog.String("err", err.Error()))
+		return "", models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+	sa.ID = id
+
+	log.Info("service account created", slog.Int64("service_account_id", id))
+
+	event := models.AuditEvent{
+		Type:    models.EventServiceAccountCreated,
+		Subject: sa.Principal().String(),
+		Details: map[string]string{
+			"name":  name,
+			"owner": string(owner.Kind) + ":" + owner.Name,
+			"roles": strings.Join(roles, " "),
+		},
+	}
+	if owner.Kind == models.OwnerApp {
+		event.Details[models.DetailApp] = owner.Name
+	}
+	a.auditor.Record(ctx, event)
+
+	return secret, sa, nil
+}
+
+// ServiceAccount returns the service account by id.
+func (a *Auth) ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error) {
+	const op = "auth.ServiceAccount"
+
+	sa, err := a.svcAccounts.ServiceAccount(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, ErrServiceAccountNotFound)
+		}
+		a.log.Error("failed to get service account",
+			slog.String("op", op),
+			slog.Int64("service_account_id", id),
+			slog.String("err", err.Error()),
+		)
+		return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sa, nil
+}
+
+// ListServiceAccounts returns service accounts of the owner,
+// or of every owner if the owner kind is empty.
+func (a *Auth) ListServiceAccounts(ctx context.Context, owner models.Owner) ([]models.ServiceAccount, error) {
+	const op = "auth.ListServiceAccounts"
+
+	accounts, err := a.svcAccounts.ServiceAccounts(ctx, owner)
+	if err != nil {
+		a.log.Error("failed to list service accounts",
+			slog.String("op", op),
+			slog.String("err", err.Error()),
+		)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return accounts, nil
+}
+
+// SetServiceAccountRoles replaces the roles of the service account.
+// Tokens issued before and API keys use the new roles immediately, as validation reads
+// the roles of the service account.
+func (a *Auth) SetServiceAccountRoles(ctx context.Context, id int64, roles []string) error {
+	const op = "auth.SetServiceAccountRoles"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("service_account_id", id),
+	)
+
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		log.Warn("invalid roles", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.svcAccounts.UpdateServiceAccountRoles(ctx, id, roles); err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			log.Warn("service account not found")
+			return fmt.Errorf("%s: %w", op, ErrServiceAccountNotFound)
+		}
+		log.Error("failed to update roles", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("service account roles updated", slog.Any("roles", roles))
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventServiceAccountRolesChanged,
+		Subject: models.Principal{Type: models.PrincipalServiceAccount, ID: id}.String(),
+		Details: map[string]string{"roles": strings.Join(roles, " ")},
+	})
+
+	return nil
+}
+
+// RotateServiceAccountSecret replaces the client secret of the service account and returns the new one.
+func (a *Auth) RotateServiceAccountSecret(ctx context.Context, id int64) (string, error) {
+	const op = "auth.RotateServiceAccountSecret"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("service_account_id", id),
+	)
+
+	secret, err := randomHex(32)
+	if err != nil {
+		log.Error("failed to generate client secret", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.svcAccounts.UpdateServiceAccountSecret(ctx, id, hashClientSecret(secret)); err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			log.Warn("service account not found")
+			return "", fmt.Errorf("%s: %w", op, ErrServiceAccountNotFound)
+		}
+		log.Error("failed to update client secret", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client secret rotated")
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventServiceAccountSecretRotated,
+		Subject: models.Principal{Type: models.PrincipalServiceAccount, ID: id}.String(),
+	})
+
+	return secret, nil
+}
+
+// DisableServiceAccount disables the service account.
+// Its tokens and API keys stop being valid.
+func (a *Auth) DisableServiceAccount(ctx context.Context, id int64) error {
+	const op = "auth.DisableServiceAccount"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("service_account_id", id),
+	)
+
+	if err := a.svcAccounts.DisableServiceAccount(ctx, id, time.Now()); err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			log.Warn("service account not found or already disabled")
+			return fmt.Errorf("%s: %w", op, ErrServiceAccountNotFound)
+		}
+		log.Error("failed to disable service account", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("service account disabled")
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventServiceAccountDisabled,
+		Subject: models.Principal{Type: models.PrincipalServiceAccount, ID: id}.String(),
+	})
+
+	return nil
+}
+
+// LoginServiceAccount checks the client credentials of a service account and returns its token.
+// Service accounts have no sessions, their tokens are valid until they expire
+// or the account is disabled.
+func (a *Auth) LoginServiceAccount(ctx context.Context, clientID, clientSecret string) (string, error) {
+	const op = "auth.LoginServiceAccount"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+	)
+
+	sa, err := a.svcAccounts.ServiceAccountByClientID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			log.Warn("service account not found")
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		log.Error("failed to get service account", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	event := models.AuditEvent{
+		Type:    models.EventServiceAccountLogin,
+		Actor:   sa.Principal().String(),
+		Subject: sa.Principal().String(),
+		Details: map[string]string{"client_id": clientID},
+	}
+	if sa.Owner.Kind == models.OwnerApp {
+		event.Details[models.DetailApp] = sa.Owner.Name
+	}
+
+	if subtle.ConstantTimeCompare(sa.SecretHash, hashClientSecret(clientSecret)) != 1 {
+		log.Info("invalid client secret")
+		event.Outcome, event.Reason = models.OutcomeFailure, "invalid_secret"
+		a.auditor.Record(ctx, event)
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	if !sa.DisabledAt.IsZero() {
+		log.Info("service account is disabled")
+		event.Outcome, event.Reason = models.OutcomeFailure, "service_account_disabled"
+		a.auditor.Record(ctx, event)
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	token, err := jwt.NewToken(sa.Principal(), "", a.tokenTTL, a.tokenSecret)
+	if err != nil {
+		log.Error("failed to generate token", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("service account logged in")
+
+	a.auditor.Record(ctx, event)
+
+	return token, nil
+}
+
+// activeServiceAccount returns the principal of the service account if it is enabled.
+func (a *Auth) activeServiceAccount(ctx context.Context, log *slog.Logger, id int64) (models.Principal, error) {
+	sa, err := a.svcAccounts.ServiceAccount(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			log.Info("service account not found", slog.Int64("service_account_id", id))
+			return models.Principal{}, ErrInvalidToken
+		}
+		log.Error("failed to get service account", slog.String("err", err.Error()))
+		return models.Principal{}, err
+	}
+
+	if !sa.DisabledAt.IsZero() {
+		log.Info("service account is disabled", slog.Int64("service_account_id", id))
+		return models.Principal{}, ErrInvalidToken
+	}
+
+	return sa.Principal(), nil
+}
+
+// normalizeRoles sorts the roles and removes duplicates. The result is never nil.
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role == "" {
+			return nil, fmt.Errorf("%w: role is empty", ErrInvalidRole)
+		}
+		normalized = append(normalized, role)
+	}
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized), nil
+}
+
+// hashClientSecret hashes the client secret for comparison.
+// Secrets carry 256 random bits, so a fast unsalted hash is enough.
+func hashClientSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
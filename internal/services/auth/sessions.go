@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/jwt"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// touchInterval limits how often the last seen time of a session or an API key is written.
+const touchInterval = time.Minute
+
+type SessionStore interface {
+	SaveSession(ctx context.Context, session models.Session) error
+	Session(ctx context.Context, id string) (models.Session, error)
+	Sessions(ctx context.Context, userID int64) ([]models.Session, error)
+	TouchSession(ctx context.Context, id string, lastSeen time.Time) error
+	RevokeSession(ctx context.Context, userID int64, id string) error
+	RevokeSessions(ctx context.Context, userID int64, exceptID string) (int64, error)
+}
+
+var (
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// ValidateToken checks a bearer credential and returns the caller it identifies.
+// Tokens issued by Login are valid while their session is active, tokens of
+// service accounts and API keys while their principal is enabled and API keys
+// while they are neither revoked nor expired. Credentials of users whose status
+// does not allow them to log in are rejected with the error of the status.
+// Tokens scoped to an organization are valid while the user is its member and
+// carry the roles of the membership.
+//
+// The roles and groups claims of user tokens are informational: the caller gets
+// the current roles of the user, so role changes apply to issued tokens at once.
+func (a *Auth) ValidateToken(ctx context.Context, token string) (models.Caller, error) {
+	const op = "auth.ValidateToken"
+
+	log := a.log.With(slog.String("op", op))
+
+	if strings.HasPrefix(token, models.APIKeyPrefix) {
+		caller, err := a.validateAPIKey(ctx, log, token)
+		if err != nil {
+			return models.Caller{}, fmt.Errorf("%s: %w", op, err)
+		}
+		return caller, nil
+	}
+
+	claims, err := jwt.ParseToken(token, a.tokenSecret)
+	if err != nil {
+		log.Info("invalid token", slog.String("err", err.Error()))
+		return models.Caller{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if !claims.Principal.IsUser() {
+		principal, err := a.activeServiceAccount(ctx, log, claims.Principal.ID)
+		if err != nil {
+			return models.Caller{}, fmt.Errorf("%s: %w", op, err)
+		}
+		return models.Caller{Principal: principal}, nil
+	}
+
+	session, err := a.sessions.Session(ctx, claims.SessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Info("session is not active", slog.String("sid", claims.SessionID))
+			return models.Caller{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.Error("failed to get session", slog.String("err", err.Error()))
+		return models.Caller{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if session.UserID != claims.Principal.ID {
+		log.Warn("session belongs to another user", slog.String("sid", claims.SessionID))
+		return models.Caller{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	user, err := a.authenticatedUser(ctx, log, claims.Principal.ID)
+	if err != nil {
+		return models.Caller{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	principal, err := a.tokenPrincipal(ctx, log, user, claims.Principal.OrgID)
+	if err != nil {
+		if errors.Is(err, ErrNotMember) {
+			log.Info("user is no longer a member of the organization", slog.Int64("org_id", claims.Principal.OrgID))
+			return models.Caller{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.Caller{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if now := time.Now(); now.Sub(session.LastSeenAt) > touchInterval {
+		if err := a.sessions.TouchSession(ctx, session.ID, now); err != nil {
+			log.Warn("failed to touch session", slog.String("err", err.Error()))
+		}
+	}
+
+	return models.Caller{
+		Principal: principal,
+		SessionID: claims.SessionID,
+	}, nil
+}
+
+// ListSessions returns active sessions of the user.
+func (a *Auth) ListSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "auth.ListSessions"
+
+	sessions, err := a.sessions.Sessions(ctx, userID)
+	if err != nil {
+		a.log.Error("failed to list sessions",
+			slog.String("op", op),
+			slog.Int64("uid", userID),
+			slog.String("err", err.Error()),
+		)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user, tokens of the session stop being valid.
+func (a *Auth) RevokeSession(ctx context.Context, userID int64, sessionID string) error {
+	const op = "auth.RevokeSession"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.String("sid", sessionID),
+	)
+
+	if err := a.sessions.RevokeSession(ctx, userID, sessionID); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Warn("session not found")
+			return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		log.Error("failed to revoke session", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("session revoked")
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventSessionRevoked,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: userID}.String(),
+		Details: map[string]string{"session_id": sessionID},
+	})
+
+	return nil
+}
+
+// RevokeAllSessions revokes every session of the user except exceptSessionID,
+// which may be empty, and returns the number of revoked sessions.
+func (a *Auth) RevokeAllSessions(ctx context.Context, userID int64, exceptSessionID string) (int64, error) {
+	const op = "auth.RevokeAllSessions"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	n, err := a.sessions.RevokeSessions(ctx, userID, exceptSessionID)
+	if err != nil {
+		log.Error("failed to revoke sessions", slog.String("err", err.Error()))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("sessions revoked", slog.Int64("count", n))
+
+	a.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventSessionsRevoked,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: userID}.String(),
+		Details: map[string]string{"count": strconv.FormatInt(n, 10)},
+	})
+
+	return n, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"time"
+)
+
+type StatusStore interface {
+	ChangeUserStatus(ctx context.Context, t models.StatusTransition) error
+}
+
+// Errors of users whose status does not allow them to authenticate.
+var (
+	ErrUserPending   = errors.New("user is pending verification")
+	ErrUserSuspended = errors.New("user is suspended")
+	ErrUserLocked    = errors.New("user is locked")
+	ErrUserDeleted   = errors.New("user is deleted")
+)
+
+// SuspendedError is the error of users suspended for a limited time. It wraps ErrUserSuspended.
+type SuspendedError struct {
+	Until time.Time
+}
+
+func (e *SuspendedError) Error() string {
+	return ErrUserSuspended.Error() + " until " + e.Until.UTC().Format(time.RFC3339)
+}
+
+func (e *SuspendedError) Unwrap() error {
+	return ErrUserSuspended
+}
+
+// checkStatus returns an error unless the status of the user allows it to authenticate.
+// A suspension that has ended is lifted.
+func (a *Auth) checkStatus(ctx context.Context, log *slog.Logger, user models.User) error {
+	now := time.Now()
+
+	if user.SuspensionExpired(now) {
+		err := a.statuses.ChangeUserStatus(ctx, models.StatusTransition{
+			UserID:    user.ID,
+			From:      models.UserStatusSuspended,
+			To:        models.UserStatusActive,
+			Reason:    "suspension ended",
+			Actor:     models.ActorSystem,
+			CreatedAt: now,
+		})
+		if err != nil && !errors.Is(err, storage.ErrStatusConflict) {
+			log.Error("failed to lift suspension", slog.String("err", err.Error()))
+			return err
+		}
+
+		if err == nil {
+			log.Info("suspension lifted", slog.Int64("uid", user.ID))
+			a.auditor.Record(ctx, models.AuditEvent{
+				Type:    models.EventUserStatusChanged,
+				Actor:   models.ActorSystem,
+				Subject: models.UserPrincipal(user).String(),
+				Details: map[string]string{
+					"from":   string(models.UserStatusSuspended),
+					"to":     string(models.UserStatusActive),
+					"reason": "suspension ended",
+				},
+			})
+		}
+
+		return nil
+	}
+
+	switch user.Status {
+	case models.UserStatusActive:
+		return nil
+	case models.UserStatusPending:
+		return ErrUserPending
+	case models.UserStatusSuspended:
+		if user.SuspendedUntil.IsZero() {
+			return ErrUserSuspended
+		}
+		return &SuspendedError{Until: user.SuspendedUntil}
+	case models.UserStatusLocked:
+		return ErrUserLocked
+	case models.UserStatusDeleted:
+		return ErrUserDeleted
+	default:
+		log.Error("unknown user status", slog.String("status", string(user.Status)))
+		return fmt.Errorf("unknown user status %q", user.Status)
+	}
+}
+
+// authenticatedUser loads the user a credential belongs to and checks its status.
+// Credentials of missing or deleted users are invalid.
+func (a *Auth) authenticatedUser(ctx context.Context, log *slog.Logger, id int64) (models.User, error) {
+	user, err := a.usrProvider.UserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", slog.Int64("uid", id))
+			return models.User{}, ErrInvalidToken
+		}
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return models.User{}, err
+	}
+
+	if err := a.checkStatus(ctx, log, user); err != nil {
+		if errors.Is(err, ErrUserDeleted) {
+			return models.User{}, ErrInvalidToken
+		}
+		log.Info("user may not authenticate", slog.Int64("uid", id), slog.String("err", err.Error()))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// statusReason returns the audit reason of a status error, or an empty string for other errors.
+func statusReason(err error) string {
+	switch {
+	case errors.Is(err, ErrUserPending):
+		return "user_pending"
+	case errors.Is(err, ErrUserSuspended):
+		return "user_suspended"
+	case errors.Is(err, ErrUserLocked):
+		return "user_locked"
+	case errors.Is(err, ErrUserDeleted):
+		return "user_deleted"
+	default:
+		return ""
+	}
+}
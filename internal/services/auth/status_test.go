@@ -0,0 +1,283 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/lib/jwt"
+	"grpc-service-ref/internal/services/auth"
+	"grpc-service-ref/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin_UserStatus(t *testing.T) {
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		user        models.User
+		expectedErr error
+	}{
+		{
+			name:        "Active",
+			user:        models.User{Status: models.UserStatusActive},
+			expectedErr: nil,
+		},
+		{
+			name:        "Pending",
+			user:        models.User{Status: models.UserStatusPending},
+			expectedErr: auth.ErrUserPending,
+		},
+		{
+			name:        "Suspended",
+			user:        models.User{Status: models.UserStatusSuspended},
+			expectedErr: auth.ErrUserSuspended,
+		},
+		{
+			name: "Suspended until later",
+			user: models.User{
+				Status:         models.UserStatusSuspended,
+				SuspendedUntil: time.Now().Add(time.Hour),
+			},
+			expectedErr: auth.ErrUserSuspended,
+		},
+		{
+			name: "Suspension ended",
+			user: models.User{
+				Status:         models.UserStatusSuspended,
+				SuspendedUntil: time.Now().Add(-time.Hour),
+			},
+			expectedErr: nil,
+		},
+		{
+			name:        "Locked",
+			user:        models.User{Status: models.UserStatusLocked},
+			expectedErr: auth.ErrUserLocked,
+		},
+		{
+			name:        "Deleted",
+			user:        models.User{Status: models.UserStatusDeleted},
+			expectedErr: auth.ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.user.ID = 1
+			tt.user.Email = "user@example.com"
+			tt.user.PassHash = passHash
+			store := &memStore{user: tt.user}
+
+			a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+				store, store, store, nil, nil, store, nil, nil, nil, store, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+			token, err := a.Login(context.Background(), tt.user.Email, "password", models.ClientInfo{})
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				assert.Empty(t, store.sessions)
+				return
+			}
+			require.NoError(t, err)
+			require.NotEmpty(t, token)
+			require.Len(t, store.sessions, 1)
+
+			caller, err := a.ValidateToken(context.Background(), token)
+			require.NoError(t, err)
+			assert.Equal(t, tt.user.ID, caller.Principal.ID)
+		})
+	}
+}
+
+func TestLogin_SuspensionEndIsRecorded(t *testing.T) {
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store := &memStore{user: models.User{
+		ID:             1,
+		Email:          "user@example.com",
+		PassHash:       passHash,
+		Status:         models.UserStatusSuspended,
+		SuspendedUntil: time.Now().Add(-time.Minute),
+	}}
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, nil, nil, nil, store, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+	_, err = a.Login(context.Background(), "user@example.com", "password", models.ClientInfo{})
+	require.NoError(t, err)
+
+	require.Len(t, store.transitions, 1)
+	assert.Equal(t, models.UserStatusSuspended, store.transitions[0].From)
+	assert.Equal(t, models.UserStatusActive, store.transitions[0].To)
+	assert.Equal(t, models.ActorSystem, store.transitions[0].Actor)
+	assert.Equal(t, models.UserStatusActive, store.user.Status)
+
+	require.NotEmpty(t, store.events)
+	assert.Equal(t, models.EventUserStatusChanged, store.events[0].Type)
+	assert.Equal(t, models.ActorSystem, store.events[0].Actor)
+	assert.Equal(t, "user:1", store.events[0].Subject)
+}
+
+func TestValidateToken_UserSuspendedAfterLogin(t *testing.T) {
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store := &memStore{user: models.User{
+		ID:       1,
+		Email:    "user@example.com",
+		PassHash: passHash,
+		Status:   models.UserStatusActive,
+	}}
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, nil, nil, nil, store, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+	token, err := a.Login(context.Background(), "user@example.com", "password", models.ClientInfo{})
+	require.NoError(t, err)
+
+	store.user.Status = models.UserStatusSuspended
+
+	_, err = a.ValidateToken(context.Background(), token)
+	require.ErrorIs(t, err, auth.ErrUserSuspended)
+
+	store.user.Status = models.UserStatusDeleted
+
+	_, err = a.ValidateToken(context.Background(), token)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestValidateToken_RolesOfUser(t *testing.T) {
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store := &memStore{user: models.User{
+		ID:       1,
+		Email:    "user@example.com",
+		PassHash: passHash,
+		Status:   models.UserStatusActive,
+		Roles:    []string{models.RoleAdmin},
+	}}
+	a := auth.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store, store, store, nil, nil, store, nil, nil, nil, store, emailaddr.Normalizer{}, auth.RegistrationOptions{}, nil, secret, time.Hour)
+
+	token, err := a.Login(context.Background(), "user@example.com", "password", models.ClientInfo{})
+	require.NoError(t, err)
+
+	store.user.Roles = nil
+
+	caller, err := a.ValidateToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Empty(t, caller.Principal.Roles)
+
+	claims, err := jwt.ParseToken(token, secret)
+	require.NoError(t, err)
+
+	forged := claims.Principal
+	forged.Roles = []string{models.RoleAdmin}
+	token, err = jwt.NewToken(forged, claims.SessionID, time.Hour, secret)
+	require.NoError(t, err)
+
+	caller, err = a.ValidateToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Empty(t, caller.Principal.Roles)
+
+	token, err = jwt.NewToken(forged, claims.SessionID, time.Hour, []byte("secret"))
+	require.NoError(t, err)
+
+	_, err = a.ValidateToken(context.Background(), token)
+	require.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+// secret signs the tokens issued in tests.
+var secret = []byte("test-secret")
+
+// memStore keeps a single user, its sessions and audit events.
+type memStore struct {
+	user        models.User
+	sessions    []models.Session
+	transitions []models.StatusTransition
+	events      []models.AuditEvent
+}
+
+func (m *memStore) CreateUser(context.Context, models.UserIdentifiers, []byte) (int64, error) {
+	return 0, storage.ErrUserExists
+}
+
+func (m *memStore) User(_ context.Context, email string) (models.User, error) {
+	if email != m.user.Email {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (m *memStore) UserByUsername(_ context.Context, username string) (models.User, error) {
+	if m.user.Username == "" || !strings.EqualFold(username, m.user.Username) {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (m *memStore) UserByPhone(_ context.Context, phone string) (models.User, error) {
+	if m.user.Phone == "" || phone != m.user.Phone {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (m *memStore) UserByID(_ context.Context, id int64) (models.User, error) {
+	if id != m.user.ID {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return m.user, nil
+}
+
+func (m *memStore) ChangeUserStatus(_ context.Context, t models.StatusTransition) error {
+	if t.From != m.user.Status {
+		return storage.ErrStatusConflict
+	}
+	m.user.Status, m.user.StatusReason, m.user.SuspendedUntil = t.To, t.Reason, t.Until
+	m.transitions = append(m.transitions, t)
+	return nil
+}
+
+func (m *memStore) SaveSession(_ context.Context, session models.Session) error {
+	m.sessions = append(m.sessions, session)
+	return nil
+}
+
+func (m *memStore) Session(_ context.Context, id string) (models.Session, error) {
+	for _, session := range m.sessions {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return models.Session{}, storage.ErrSessionNotFound
+}
+
+func (m *memStore) Sessions(context.Context, int64) ([]models.Session, error) {
+	return m.sessions, nil
+}
+
+func (m *memStore) TouchSession(context.Context, string, time.Time) error {
+	return nil
+}
+
+func (m *memStore) RevokeSession(context.Context, int64, string) error {
+	return nil
+}
+
+func (m *memStore) RevokeSessions(context.Context, int64, string) (int64, error) {
+	return 0, nil
+}
+
+func (m *memStore) Record(_ context.Context, event models.AuditEvent) {
+	m.events = append(m.events, event)
+}
@@ -0,0 +1,691 @@
+package authz
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MaxUpdates is the number of relationship updates a write may apply.
+const MaxUpdates = 1000
+
+// MaxDepth is the number of levels of relationships a read may follow and MaxNodes the number
+// of relations and permissions on objects it may visit. Reads going further fail with ErrTooComplex.
+const (
+	MaxDepth = 32
+	MaxNodes = 10000
+)
+
+// readBatch is the number of relationship filters read in one round trip.
+const readBatch = 500
+
+// Authz stores relationships between objects and checks the permissions they give,
+// as the schema defines them. Callers are authorized by the gRPC layer.
+//
+// Every write is given a revision. Reads are at least as fresh as the consistency token
+// they are given, which is the token of a write or of an earlier read, and return the token
+// of the revision they are made at.
+type Authz struct {
+	log    *slog.Logger
+	schema *Schema
+	store  Store
+}
+
+type Store interface {
+	WriteRelationships(ctx context.Context, updates []models.RelationshipUpdate) (int64, error)
+	Relationships(ctx context.Context,
+		filters []models.RelationshipFilter,
+		minRevision int64,
+	) ([]models.Relationship, int64, error)
+}
+
+var (
+	ErrInvalidObject       = errors.New("invalid object")
+	ErrInvalidOperation    = errors.New("invalid operation")
+	ErrInvalidRelationship = errors.New("relationship not allowed by the schema")
+	ErrTooManyUpdates      = errors.New("too many updates")
+	ErrUnknownType         = errors.New("type not defined in the schema")
+	ErrUnknownRelation     = errors.New("relation or permission not defined in the schema")
+	ErrTooComplex          = errors.New("relationships too deep or too many to follow")
+	// ErrInvalidToken is returned for malformed consistency tokens and for tokens
+	// of revisions the storage has not reached.
+	ErrInvalidToken = errors.New("invalid consistency token")
+)
+
+var objectIDPattern = regexp.MustCompile(`^[A-Za-z0-9_./|=+@-]{1,128}$`)
+
+// tokenPrefix tells consistency tokens apart from the other opaque tokens of the service.
+const tokenPrefix = "rev:"
+
+// New returns a new instance of the Authz service.
+func New(log *slog.Logger, schema *Schema, store Store) *Authz {
+	return &Authz{
+		log:    log,
+		schema: schema,
+		store:  store,
+	}
+}
+
+// WriteRelationships applies the updates at once and returns the consistency token of the write.
+// Touched relationships must be allowed by the schema, deleted ones need not be,
+// so that relationships the schema no longer allows can be cleaned up.
+func (a *Authz) WriteRelationships(ctx context.Context, updates []models.RelationshipUpdate) (string, error) {
+	const op = "authz.WriteRelationships"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("updates", len(updates)),
+	)
+
+	if len(updates) > MaxUpdates {
+		return "", fmt.Errorf("%s: %w", op, ErrTooManyUpdates)
+	}
+
+	for _, u := range updates {
+		if err := a.checkUpdate(u); err != nil {
+			log.Warn("invalid update", slog.String("relationship", u.Relationship.String()), slog.String("err", err.Error()))
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	revision, err := a.store.WriteRelationships(ctx, updates)
+	if err != nil {
+		log.Error("failed to write relationships", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return encodeToken(revision), nil
+}
+
+// Check reports whether the subject has the permission or the relation on the resource
+// and returns the consistency token of the revision it was checked at.
+func (a *Authz) Check(
+	ctx context.Context,
+	resource models.ObjectRef,
+	permission string,
+	subject models.SubjectRef,
+	token string,
+) (bool, string, error) {
+	const op = "authz.Check"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("resource", resource.String()),
+		slog.String("permission", permission),
+	)
+
+	e, err := a.evaluation(ctx, token)
+	if err != nil {
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkObject(resource, permission); err != nil {
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkObject(subject.Object, subject.Relation); err != nil {
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	allowed, err := e.check(resource, permission, subject)
+	if err != nil {
+		return false, "", fmt.Errorf("%s: %w", op, a.readError(log, err))
+	}
+
+	return allowed, encodeToken(e.revision), nil
+}
+
+// LookupResources returns the ids of the resources of the type the subject has the permission
+// or the relation on, sorted, and the consistency token of the revision they were looked up at.
+func (a *Authz) LookupResources(
+	ctx context.Context,
+	resourceType string,
+	permission string,
+	subject models.SubjectRef,
+	token string,
+) ([]string, string, error) {
+	const op = "authz.LookupResources"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("resource_type", resourceType),
+		slog.String("permission", permission),
+	)
+
+	e, err := a.evaluation(ctx, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkName(resourceType, permission); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkObject(subject.Object, subject.Relation); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	ids, err := e.lookupResources(resourceType, permission, subject)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, a.readError(log, err))
+	}
+
+	return ids, encodeToken(e.revision), nil
+}
+
+// Expand returns the tree of the subjects having the permission or the relation on the resource
+// and the consistency token of the revision it was expanded at.
+func (a *Authz) Expand(
+	ctx context.Context,
+	resource models.ObjectRef,
+	permission string,
+	token string,
+) (models.PermissionTree, string, error) {
+	const op = "authz.Expand"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("resource", resource.String()),
+		slog.String("permission", permission),
+	)
+
+	e, err := a.evaluation(ctx, token)
+	if err != nil {
+		return models.PermissionTree{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkObject(resource, permission); err != nil {
+		return models.PermissionTree{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tree, err := e.expand(resource, permission)
+	if err != nil {
+		return models.PermissionTree{}, "", fmt.Errorf("%s: %w", op, a.readError(log, err))
+	}
+
+	return tree, encodeToken(e.revision), nil
+}
+
+// checkUpdate makes sure the update is a valid operation on a well-formed relationship,
+// allowed by the schema when it is touched.
+func (a *Authz) checkUpdate(u models.RelationshipUpdate) error {
+	r := u.Relationship
+	if err := checkObjectID(r.Resource, r.Subject.Object); err != nil {
+		return err
+	}
+
+	switch u.Operation {
+	case models.RelationshipTouch:
+		st := subjectType{typ: r.Subject.Object.Type, relation: r.Subject.Relation}
+		if err := a.schema.checkRelationship(r.Resource.Type, r.Relation, st); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRelationship, err)
+		}
+	case models.RelationshipDelete:
+		names := []string{r.Resource.Type, r.Relation, r.Subject.Object.Type}
+		if r.Subject.Relation != "" {
+			names = append(names, r.Subject.Relation)
+		}
+		for _, name := range names {
+			if !namePattern.MatchString(name) {
+				return fmt.Errorf("%w: invalid name %q", ErrInvalidRelationship, name)
+			}
+		}
+	default:
+		return ErrInvalidOperation
+	}
+
+	return nil
+}
+
+// checkObject makes sure the object is well-formed and its type has the relation or permission,
+// if it is not empty.
+func (a *Authz) checkObject(object models.ObjectRef, name string) error {
+	if err := checkObjectID(object); err != nil {
+		return err
+	}
+
+	return a.checkName(object.Type, name)
+}
+
+// checkName makes sure the type is defined and has the relation or permission, if it is not empty.
+func (a *Authz) checkName(typ, name string) error {
+	def, ok := a.schema.definitions[typ]
+	if !ok {
+		return ErrUnknownType
+	}
+	if name != "" && !def.has(name) {
+		return ErrUnknownRelation
+	}
+
+	return nil
+}
+
+func checkObjectID(objects ...models.ObjectRef) error {
+	for _, object := range objects {
+		if !objectIDPattern.MatchString(object.ID) {
+			return ErrInvalidObject
+		}
+	}
+
+	return nil
+}
+
+// evaluation starts an evaluation of a read request at least as fresh as the token.
+func (a *Authz) evaluation(ctx context.Context, token string) (*evaluation, error) {
+	revision, err := decodeToken(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &evaluation{ctx: ctx, schema: a.schema, store: a.store, revision: revision}, nil
+}
+
+// readError reports tokens of revisions the storage has not reached as invalid and logs the errors.
+func (a *Authz) readError(log *slog.Logger, err error) error {
+	if errors.Is(err, storage.ErrRevisionNotFound) {
+		log.Warn("consistency token is ahead of the storage")
+		return ErrInvalidToken
+	}
+	if errors.Is(err, ErrTooComplex) {
+		log.Warn("read exceeds the limits of the relationships it may follow")
+		return err
+	}
+
+	log.Error("failed to read relationships", slog.String("err", err.Error()))
+	return err
+}
+
+func encodeToken(revision int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(tokenPrefix + strconv.FormatInt(revision, 10)))
+}
+
+// decodeToken returns the revision of the consistency token. An empty token is at any revision.
+func decodeToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+
+	rest, ok := strings.CutPrefix(string(raw), tokenPrefix)
+	if !ok {
+		return 0, fmt.Errorf("malformed consistency token %q", token)
+	}
+
+	revision, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || revision < 0 {
+		return 0, fmt.Errorf("malformed consistency token %q", token)
+	}
+
+	return revision, nil
+}
+
+// node is a relation or permission on an object, standing for the subjects having it.
+// Without a relation it is the object itself.
+type node struct {
+	object   models.ObjectRef
+	relation string
+}
+
+// evaluation reads the relationships of a request. Every read is at least as fresh as
+// the previous ones, so the request sees the writes up to the revision it ends at.
+//
+// The relationships are walked a level at a time: the reads of the nodes of a level are made
+// at once, and a request follows up to MaxDepth levels and visits up to MaxNodes nodes.
+type evaluation struct {
+	ctx      context.Context
+	schema   *Schema
+	store    Store
+	revision int64
+	depth    int // levels read so far
+	nodes    int // nodes visited so far
+}
+
+// read returns the relationships matching each of the filters, read in batches of readBatch
+// filters. Every call reads a level, counted against MaxDepth.
+func (e *evaluation) read(filters []models.RelationshipFilter) ([][]models.Relationship, error) {
+	e.depth++
+	if e.depth > MaxDepth {
+		return nil, ErrTooComplex
+	}
+
+	matched := make([][]models.Relationship, len(filters))
+	for start := 0; start < len(filters); start += readBatch {
+		batch := filters[start:min(start+readBatch, len(filters))]
+
+		rels, revision, err := e.store.Relationships(e.ctx, batch, e.revision)
+		if err != nil {
+			return nil, err
+		}
+		e.revision = max(e.revision, revision)
+
+		for i, filter := range batch {
+			for _, r := range rels {
+				if filter.Matches(r) {
+					matched[start+i] = append(matched[start+i], r)
+				}
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+// visit marks the node visited and reports whether it was not visited before.
+// Visited nodes are counted against MaxNodes.
+func (e *evaluation) visit(visited map[node]bool, n node) (bool, error) {
+	if visited[n] {
+		return false, nil
+	}
+	visited[n] = true
+
+	e.nodes++
+	if e.nodes > MaxNodes {
+		return false, ErrTooComplex
+	}
+
+	return true, nil
+}
+
+// arrowStep is an arrow operand of a permission on an object, followed from the object.
+type arrowStep struct {
+	object models.ObjectRef
+	op     operand
+}
+
+// check reports whether the subject has the relation or permission on the resource.
+// Permissions are unions, so a node visited before adds nothing: it either was false or is
+// being evaluated, and visiting it again would loop on cyclic relationships.
+func (e *evaluation) check(resource models.ObjectRef, name string, subject models.SubjectRef) (bool, error) {
+	target := node{object: subject.Object, relation: subject.Relation}
+	visited := make(map[node]bool)
+
+	level := []node{{object: resource, relation: name}}
+	for len(level) > 0 {
+		// The operands of permissions are on the same object, so they join the level,
+		// relations and arrows are read.
+		var (
+			relations []node
+			arrows    []arrowStep
+		)
+		for i := 0; i < len(level); i++ {
+			n := level[i]
+			if n == target {
+				return true, nil
+			}
+			first, err := e.visit(visited, n)
+			if err != nil {
+				return false, err
+			}
+			if !first {
+				continue
+			}
+
+			operands, ok := e.schema.definitions[n.object.Type].permissions[n.relation]
+			if !ok {
+				relations = append(relations, n)
+				continue
+			}
+			for _, op := range operands {
+				if op.arrow == "" {
+					level = append(level, node{object: n.object, relation: op.relation})
+				} else {
+					arrows = append(arrows, arrowStep{object: n.object, op: op})
+				}
+			}
+		}
+
+		filters := make([]models.RelationshipFilter, 0, len(relations)+len(arrows))
+		for _, n := range relations {
+			filters = append(filters, relationFilter(n.object, n.relation))
+		}
+		for _, a := range arrows {
+			filters = append(filters, relationFilter(a.object, a.op.relation))
+		}
+		if len(filters) == 0 {
+			break
+		}
+
+		matched, err := e.read(filters)
+		if err != nil {
+			return false, err
+		}
+
+		var next []node
+		for i, rels := range matched {
+			for _, r := range rels {
+				if i < len(relations) {
+					// The subjects of the relation, and those of the relations it points to.
+					n := node{object: r.Subject.Object, relation: r.Subject.Relation}
+					if n == target {
+						return true, nil
+					}
+					if n.relation != "" {
+						next = append(next, n)
+					}
+					continue
+				}
+
+				// The arrow permission on the objects the relation points to.
+				op := arrows[i-len(relations)].op
+				if r.Subject.Relation != "" || !e.schema.definitions[r.Subject.Object.Type].has(op.arrow) {
+					continue
+				}
+				next = append(next, node{object: r.Subject.Object, relation: op.arrow})
+			}
+		}
+		level = next
+	}
+
+	return false, nil
+}
+
+// lookupResources walks the relationships up from the subject, to every relation and permission
+// the subject has, and returns the ids of the resources of the type it has the permission on.
+func (e *evaluation) lookupResources(resourceType, permission string, subject models.SubjectRef) ([]string, error) {
+	visited := make(map[node]bool)
+
+	var (
+		ids   []string
+		level []node
+	)
+	push := func(level []node, n node) ([]node, error) {
+		ok, err := e.visit(visited, n)
+		if err != nil || !ok {
+			return level, err
+		}
+		return append(level, n), nil
+	}
+
+	level, err := push(level, node{object: subject.Object, relation: subject.Relation})
+	if err != nil {
+		return nil, err
+	}
+	for len(level) > 0 {
+		var (
+			filters []models.RelationshipFilter
+			// reached are the permissions the relationships matching the filters give on their
+			// resources, empty for the relations of the relationships themselves.
+			reached []string
+		)
+		for i := 0; i < len(level); i++ {
+			n := level[i]
+			if n.object.Type == resourceType && n.relation == permission {
+				ids = append(ids, n.object.ID)
+			}
+
+			// The subjects of the node have the relations the node is the subject of,
+			filters = append(filters, models.RelationshipFilter{
+				Subject: &models.SubjectRef{Object: n.object, Relation: n.relation},
+			})
+			reached = append(reached, "")
+
+			if n.relation == "" {
+				continue
+			}
+
+			// the permissions of the object the relation of the node is an operand of
+			for _, perm := range e.schema.permissionsWith(n.object.Type, n.relation) {
+				if level, err = push(level, node{object: n.object, relation: perm}); err != nil {
+					return nil, err
+				}
+			}
+
+			// and the permissions of the objects whose relations point to the object with an arrow.
+			for _, a := range e.schema.arrowsTo(n.object.Type, n.relation) {
+				filters = append(filters, models.RelationshipFilter{
+					ResourceType: a.typ,
+					Relation:     a.relation,
+					Subject:      &models.SubjectRef{Object: n.object},
+				})
+				reached = append(reached, a.permission)
+			}
+		}
+
+		matched, err := e.read(filters)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []node
+		for i, rels := range matched {
+			for _, r := range rels {
+				relation := r.Relation
+				if reached[i] != "" {
+					relation = reached[i]
+				}
+				if next, err = push(next, node{object: r.Resource, relation: relation}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		level = next
+	}
+
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// expansion is a node of the tree expand builds, linked to its parent to tell the path from the root.
+// Arrow expansions group the expansions of the arrow permission on the objects the relation points to.
+type expansion struct {
+	node     node
+	arrow    *operand
+	parent   *expansion
+	subjects []models.SubjectRef
+	children []*expansion
+}
+
+// onPath reports whether the node is the one of the expansion or of any of its ancestors.
+func (x *expansion) onPath(n node) bool {
+	for ; x != nil; x = x.parent {
+		if x.node == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *expansion) tree() models.PermissionTree {
+	t := models.PermissionTree{Resource: x.node.object, Relation: x.node.relation, Subjects: x.subjects}
+	for _, child := range x.children {
+		t.Children = append(t.Children, child.tree())
+	}
+	return t
+}
+
+// expand returns the tree of the relation or permission on the resource. A node already
+// on the path from the root is left empty, it would repeat the subtree it is in.
+func (e *evaluation) expand(resource models.ObjectRef, name string) (models.PermissionTree, error) {
+	root := &expansion{node: node{object: resource, relation: name}}
+
+	level := []*expansion{root}
+	for len(level) > 0 {
+		// Permissions are expanded into their operands on the same level,
+		// the subjects of relations and the targets of arrows are read.
+		var reads []*expansion
+		for i := 0; i < len(level); i++ {
+			x := level[i]
+			if x.arrow != nil {
+				reads = append(reads, x)
+				continue
+			}
+			if x.parent.onPath(x.node) {
+				continue
+			}
+
+			e.nodes++
+			if e.nodes > MaxNodes {
+				return models.PermissionTree{}, ErrTooComplex
+			}
+
+			operands, ok := e.schema.definitions[x.node.object.Type].permissions[x.node.relation]
+			if !ok {
+				reads = append(reads, x)
+				continue
+			}
+			for _, op := range operands {
+				child := &expansion{node: node{object: x.node.object, relation: op.relation}, parent: x}
+				if op.arrow != "" {
+					child.node.relation = op.relation + "->" + op.arrow
+					child.arrow = &op
+				}
+				x.children = append(x.children, child)
+				level = append(level, child)
+			}
+		}
+		if len(reads) == 0 {
+			break
+		}
+
+		filters := make([]models.RelationshipFilter, 0, len(reads))
+		for _, x := range reads {
+			relation := x.node.relation
+			if x.arrow != nil {
+				relation = x.arrow.relation
+			}
+			filters = append(filters, relationFilter(x.node.object, relation))
+		}
+
+		matched, err := e.read(filters)
+		if err != nil {
+			return models.PermissionTree{}, err
+		}
+
+		var next []*expansion
+		for i, rels := range matched {
+			x := reads[i]
+			for _, r := range rels {
+				if x.arrow == nil {
+					x.subjects = append(x.subjects, r.Subject)
+					continue
+				}
+				if r.Subject.Relation != "" || !e.schema.definitions[r.Subject.Object.Type].has(x.arrow.arrow) {
+					continue
+				}
+				child := &expansion{node: node{object: r.Subject.Object, relation: x.arrow.arrow}, parent: x}
+				x.children = append(x.children, child)
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+
+	return root.tree(), nil
+}
+
+// relationFilter selects the relationships of the relation on the object.
+func relationFilter(object models.ObjectRef, relation string) models.RelationshipFilter {
+	return models.RelationshipFilter{
+		ResourceType: object.Type,
+		ResourceID:   object.ID,
+		Relation:     relation,
+	}
+}
@@ -0,0 +1,387 @@
+package authz_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/authz"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `
+definition user {}
+
+// Groups nest through their member relation.
+definition group {
+	relation member: user | group#member
+}
+
+definition folder {
+	relation owner: user
+	relation viewer: user | group#member
+	permission view = viewer + owner
+}
+
+definition document {
+	relation parent: folder
+	relation owner: user
+	relation editor: user | group#member
+	permission edit = editor + owner
+	permission view = edit + parent->view
+}
+`
+
+func TestAuthz_Check(t *testing.T) {
+	ctx := context.Background()
+	a := newAuthz(t)
+
+	token, err := a.WriteRelationships(ctx, []models.RelationshipUpdate{
+		touch("group:staff", "member", "group:eng#member"),
+		touch("group:eng", "member", "user:alice"),
+		touch("document:spec", "editor", "group:staff#member"),
+		touch("document:spec", "owner", "user:carol"),
+		touch("document:spec", "parent", "folder:shared"),
+		touch("folder:shared", "viewer", "user:bob"),
+		touch("document:notes", "parent", "folder:shared"),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	tests := []struct {
+		subject    string
+		resource   string
+		permission string
+		allowed    bool
+	}{
+		{"user:alice", "document:spec", "edit", true},
+		{"user:alice", "document:spec", "view", true},
+		{"user:bob", "document:spec", "edit", false},
+		{"user:bob", "document:spec", "view", true},
+		{"user:bob", "document:notes", "view", true},
+		{"user:carol", "document:spec", "edit", true},
+		{"user:carol", "document:notes", "view", false},
+		{"user:alice", "group:staff", "member", true},
+		{"group:eng#member", "document:spec", "edit", true},
+		{"group:eng#member", "group:eng", "member", true},
+		{"user:dave", "document:spec", "view", false},
+	}
+	for _, tt := range tests {
+		allowed, checkedAt, err := a.Check(ctx, object(tt.resource), tt.permission, subject(tt.subject), token)
+		require.NoError(t, err)
+		assert.Equal(t, tt.allowed, allowed, "%s %s %s", tt.subject, tt.permission, tt.resource)
+		assert.Equal(t, token, checkedAt)
+	}
+
+	// Cycles in the relationships end the walk rather than loop.
+	_, err = a.WriteRelationships(ctx, []models.RelationshipUpdate{touch("group:eng", "member", "group:staff#member")})
+	require.NoError(t, err)
+	allowed, _, err := a.Check(ctx, object("document:spec"), "edit", subject("user:dave"), "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, _, err = a.Check(ctx, object("document:spec"), "delete", subject("user:alice"), "")
+	require.ErrorIs(t, err, authz.ErrUnknownRelation)
+	_, _, err = a.Check(ctx, object("page:spec"), "view", subject("user:alice"), "")
+	require.ErrorIs(t, err, authz.ErrUnknownType)
+	_, _, err = a.Check(ctx, object("document:spec"), "view", subject("user:alice"), "not-a-token")
+	require.ErrorIs(t, err, authz.ErrInvalidToken)
+}
+
+func TestAuthz_ConsistencyTokens(t *testing.T) {
+	ctx := context.Background()
+	a := newAuthz(t)
+
+	first, err := a.WriteRelationships(ctx, []models.RelationshipUpdate{touch("document:spec", "owner", "user:alice")})
+	require.NoError(t, err)
+	second, err := a.WriteRelationships(ctx, []models.RelationshipUpdate{
+		{
+			Operation:    models.RelationshipDelete,
+			Relationship: models.Relationship{Resource: object("document:spec"), Relation: "owner", Subject: subject("user:alice")},
+		},
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	allowed, checkedAt, err := a.Check(ctx, object("document:spec"), "edit", subject("user:alice"), first)
+	require.NoError(t, err)
+	assert.False(t, allowed, "reads are at least as fresh as the token")
+	assert.Equal(t, second, checkedAt)
+
+	// Tokens of revisions the storage has not reached are rejected, rather than read stale.
+	third, err := a.WriteRelationships(ctx, nil)
+	require.NoError(t, err)
+	_, _, err = newAuthz(t).Check(ctx, object("document:spec"), "edit", subject("user:alice"), third)
+	require.ErrorIs(t, err, authz.ErrInvalidToken)
+}
+
+func TestAuthz_WriteRelationships(t *testing.T) {
+	ctx := context.Background()
+	a := newAuthz(t)
+
+	tests := []struct {
+		name   string
+		update models.RelationshipUpdate
+		err    error
+	}{
+		{"Unknown type", touch("page:spec", "owner", "user:alice"), authz.ErrInvalidRelationship},
+		{"Permission", touch("document:spec", "edit", "user:alice"), authz.ErrInvalidRelationship},
+		{"Subject type not allowed", touch("document:spec", "owner", "group:eng"), authz.ErrInvalidRelationship},
+		{"Userset not allowed", touch("document:spec", "owner", "group:eng#member"), authz.ErrInvalidRelationship},
+		{"Invalid id", touch("document:a b", "owner", "user:alice"), authz.ErrInvalidObject},
+		{
+			"Unknown operation",
+			models.RelationshipUpdate{Operation: "replace", Relationship: touch("document:spec", "owner", "user:alice").Relationship},
+			authz.ErrInvalidOperation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.WriteRelationships(ctx, []models.RelationshipUpdate{touch("document:spec", "owner", "user:bob"), tt.update})
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	allowed, _, err := a.Check(ctx, object("document:spec"), "edit", subject("user:bob"), "")
+	require.NoError(t, err)
+	assert.False(t, allowed, "writes with an invalid update apply none")
+
+	// Relationships the schema does not allow can still be deleted.
+	_, err = a.WriteRelationships(ctx, []models.RelationshipUpdate{{
+		Operation:    models.RelationshipDelete,
+		Relationship: models.Relationship{Resource: object("page:spec"), Relation: "owner", Subject: subject("user:bob")},
+	}})
+	require.NoError(t, err)
+
+	_, err = a.WriteRelationships(ctx, make([]models.RelationshipUpdate, authz.MaxUpdates+1))
+	require.ErrorIs(t, err, authz.ErrTooManyUpdates)
+}
+
+func TestAuthz_LookupResources(t *testing.T) {
+	ctx := context.Background()
+	a := newAuthz(t)
+
+	token, err := a.WriteRelationships(ctx, []models.RelationshipUpdate{
+		touch("group:staff", "member", "group:eng#member"),
+		touch("group:eng", "member", "user:alice"),
+		touch("group:eng", "member", "group:staff#member"),
+		touch("document:spec", "editor", "group:staff#member"),
+		touch("document:readme", "owner", "user:alice"),
+		touch("document:notes", "parent", "folder:shared"),
+		touch("document:draft", "parent", "folder:private"),
+		touch("folder:shared", "viewer", "group:eng#member"),
+	})
+	require.NoError(t, err)
+
+	ids, lookedUpAt, err := a.LookupResources(ctx, "document", "view", subject("user:alice"), token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"notes", "readme", "spec"}, ids)
+	assert.Equal(t, token, lookedUpAt)
+
+	ids, _, err = a.LookupResources(ctx, "document", "edit", subject("user:alice"), token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"readme", "spec"}, ids)
+
+	ids, _, err = a.LookupResources(ctx, "group", "member", subject("user:alice"), token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eng", "staff"}, ids)
+
+	ids, _, err = a.LookupResources(ctx, "document", "view", subject("user:bob"), token)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	// Every resource found is allowed by Check.
+	for _, id := range []string{"notes", "readme", "spec"} {
+		allowed, _, err := a.Check(ctx, models.ObjectRef{Type: "document", ID: id}, "view", subject("user:alice"), token)
+		require.NoError(t, err)
+		assert.True(t, allowed, id)
+	}
+}
+
+func TestAuthz_Expand(t *testing.T) {
+	ctx := context.Background()
+	a := newAuthz(t)
+
+	_, err := a.WriteRelationships(ctx, []models.RelationshipUpdate{
+		touch("document:spec", "editor", "group:eng#member"),
+		touch("document:spec", "owner", "user:carol"),
+		touch("document:spec", "parent", "folder:shared"),
+		touch("folder:shared", "viewer", "user:bob"),
+	})
+	require.NoError(t, err)
+
+	tree, _, err := a.Expand(ctx, object("document:spec"), "view", "")
+	require.NoError(t, err)
+
+	spec, shared := object("document:spec"), object("folder:shared")
+	assert.Equal(t, models.PermissionTree{
+		Resource: spec,
+		Relation: "view",
+		Children: []models.PermissionTree{
+			{
+				Resource: spec,
+				Relation: "edit",
+				Children: []models.PermissionTree{
+					{Resource: spec, Relation: "editor", Subjects: []models.SubjectRef{subject("group:eng#member")}},
+					{Resource: spec, Relation: "owner", Subjects: []models.SubjectRef{subject("user:carol")}},
+				},
+			},
+			{
+				Resource: spec,
+				Relation: "parent->view",
+				Children: []models.PermissionTree{{
+					Resource: shared,
+					Relation: "view",
+					Children: []models.PermissionTree{
+						{Resource: shared, Relation: "viewer", Subjects: []models.SubjectRef{subject("user:bob")}},
+						{Resource: shared, Relation: "owner"},
+					},
+				}},
+			},
+		},
+	}, tree)
+}
+
+func TestAuthz_ReadsLevelsAtOnce(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Storage: memory.New()}
+	a := newAuthzWith(t, testSchema, store)
+
+	updates := []models.RelationshipUpdate{touch("document:spec", "parent", "folder:shared")}
+	for i := range 20 {
+		team := "group:team" + strconv.Itoa(i)
+		updates = append(updates,
+			touch("folder:shared", "viewer", team+"#member"),
+			touch(team, "member", "user:member"+strconv.Itoa(i)),
+		)
+	}
+	_, err := a.WriteRelationships(ctx, updates)
+	require.NoError(t, err)
+
+	store.reads = 0
+	allowed, _, err := a.Check(ctx, object("document:spec"), "view", subject("user:dave"), "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	// The relations of the document and its parent, the relations of the folder, the teams.
+	assert.Equal(t, 3, store.reads, "the relations of a level are read at once")
+
+	store.reads = 0
+	ids, _, err := a.LookupResources(ctx, "document", "view", subject("user:member7"), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"spec"}, ids)
+	assert.LessOrEqual(t, store.reads, 5)
+}
+
+func TestAuthz_Limits(t *testing.T) {
+	ctx := context.Background()
+	a := newAuthz(t)
+
+	// Groups nested deeper than the levels a read may follow.
+	var updates []models.RelationshipUpdate
+	for i := range authz.MaxDepth + 1 {
+		updates = append(updates, touch("group:deep"+strconv.Itoa(i), "member", "group:deep"+strconv.Itoa(i+1)+"#member"))
+	}
+	updates = append(updates, touch("group:deep"+strconv.Itoa(authz.MaxDepth+1), "member", "user:alice"))
+	_, err := a.WriteRelationships(ctx, updates)
+	require.NoError(t, err)
+
+	_, _, err = a.Check(ctx, object("group:deep0"), "member", subject("user:alice"), "")
+	require.ErrorIs(t, err, authz.ErrTooComplex)
+	_, _, err = a.LookupResources(ctx, "group", "member", subject("user:alice"), "")
+	require.ErrorIs(t, err, authz.ErrTooComplex)
+
+	allowed, _, err := a.Check(ctx, object("group:deep2"), "member", subject("user:alice"), "")
+	require.NoError(t, err, "shallower reads are within the limits")
+	assert.True(t, allowed)
+
+	// A group with more nested groups than the nodes a read may visit.
+	for start := 0; start <= authz.MaxNodes; start += authz.MaxUpdates {
+		updates = updates[:0]
+		for i := start; i < min(start+authz.MaxUpdates, authz.MaxNodes+1); i++ {
+			updates = append(updates, touch("group:wide", "member", "group:wide"+strconv.Itoa(i)+"#member"))
+		}
+		_, err = a.WriteRelationships(ctx, updates)
+		require.NoError(t, err)
+	}
+
+	_, _, err = a.Check(ctx, object("group:wide"), "member", subject("user:alice"), "")
+	require.ErrorIs(t, err, authz.ErrTooComplex)
+
+	// Folders nested deeper than the levels an expansion may follow.
+	a = newAuthzWith(t, `
+definition user {}
+
+definition folder {
+	relation parent: folder
+	relation viewer: user
+	permission view = viewer + parent->view
+}
+`, memory.New())
+	updates = updates[:0]
+	for i := range authz.MaxDepth + 1 {
+		updates = append(updates, touch("folder:f"+strconv.Itoa(i), "parent", "folder:f"+strconv.Itoa(i+1)))
+	}
+	_, err = a.WriteRelationships(ctx, updates)
+	require.NoError(t, err)
+
+	_, _, err = a.Expand(ctx, object("folder:f0"), "view", "")
+	require.ErrorIs(t, err, authz.ErrTooComplex)
+	_, _, err = a.Expand(ctx, object("folder:f20"), "view", "")
+	require.NoError(t, err)
+}
+
+func newAuthz(t *testing.T) *authz.Authz {
+	t.Helper()
+
+	return newAuthzWith(t, testSchema, memory.New())
+}
+
+func newAuthzWith(t *testing.T, src string, store authz.Store) *authz.Authz {
+	t.Helper()
+
+	schema, err := authz.ParseSchema(src)
+	require.NoError(t, err)
+
+	return authz.New(slog.New(slog.NewTextHandler(io.Discard, nil)), schema, store)
+}
+
+// countingStore counts the round trips reading relationships.
+type countingStore struct {
+	*memory.Storage
+	reads int
+}
+
+func (s *countingStore) Relationships(
+	ctx context.Context,
+	filters []models.RelationshipFilter,
+	minRevision int64,
+) ([]models.Relationship, int64, error) {
+	s.reads++
+	return s.Storage.Relationships(ctx, filters, minRevision)
+}
+
+func touch(resource, relation, subj string) models.RelationshipUpdate {
+	return models.RelationshipUpdate{
+		Operation:    models.RelationshipTouch,
+		Relationship: models.Relationship{Resource: object(resource), Relation: relation, Subject: subject(subj)},
+	}
+}
+
+// object parses "type:id".
+func object(s string) models.ObjectRef {
+	typ, id, _ := strings.Cut(s, ":")
+	return models.ObjectRef{Type: typ, ID: id}
+}
+
+// subject parses "type:id" and "type:id#relation".
+func subject(s string) models.SubjectRef {
+	obj, relation, _ := strings.Cut(s, "#")
+	return models.SubjectRef{Object: object(obj), Relation: relation}
+}
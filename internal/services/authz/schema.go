@@ -0,0 +1,401 @@
+package authz
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Schema defines the types of the objects relationships are written for, their relations
+// and the permissions computed from them. It is written in a small language:
+//
+//	definition user {}
+//
+//	definition group {
+//		relation member: user | group#member
+//	}
+//
+//	definition document {
+//		relation parent: folder
+//		relation editor: user | group#member
+//		relation viewer: user | group#member
+//		permission edit = editor
+//		permission view = viewer + edit + parent->view
+//	}
+//
+// A relation lists the subjects it may be written for: objects of a type or, with a relation,
+// every subject having the relation on an object of the type. A permission is the union of relations
+// and permissions of the same object; an arrow takes the permission of the objects a relation points to.
+// Comments run from // to the end of the line.
+type Schema struct {
+	definitions map[string]definition
+}
+
+type definition struct {
+	relations   map[string][]subjectType
+	permissions map[string][]operand
+}
+
+// subjectType is a type of the subjects a relation may be written for, with a relation a userset.
+type subjectType struct {
+	typ      string
+	relation string
+}
+
+// operand is a relation or permission of a permission. With an arrow it is the arrow permission
+// of the objects the relation points to.
+type operand struct {
+	relation string
+	arrow    string
+}
+
+// arrow is an operand following the relation of the type to the permission, in the permission of the type.
+type arrow struct {
+	typ        string
+	relation   string
+	permission string
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// has reports whether the definition has the relation or the permission.
+func (d definition) has(name string) bool {
+	_, isRelation := d.relations[name]
+	_, isPermission := d.permissions[name]
+	return isRelation || isPermission
+}
+
+// ParseSchema parses and checks the schema. An empty schema defines no types.
+func ParseSchema(src string) (*Schema, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	s := &Schema{definitions: make(map[string]definition)}
+
+	for p.more() {
+		if err := p.expect("definition"); err != nil {
+			return nil, err
+		}
+		line := p.peek().line
+		typ, err := p.name("type")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := s.definitions[typ]; ok {
+			return nil, fmt.Errorf("line %d: type %s is defined twice", line, typ)
+		}
+
+		def, err := p.definition()
+		if err != nil {
+			return nil, err
+		}
+		s.definitions[typ] = def
+	}
+
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// check makes sure every name the definitions refer to is defined.
+func (s *Schema) check() error {
+	for _, typ := range slices.Sorted(maps.Keys(s.definitions)) {
+		def := s.definitions[typ]
+
+		for _, rel := range slices.Sorted(maps.Keys(def.relations)) {
+			for _, st := range def.relations[rel] {
+				target, ok := s.definitions[st.typ]
+				if !ok {
+					return fmt.Errorf("%s#%s: type %s is not defined", typ, rel, st.typ)
+				}
+				if st.relation != "" && !target.has(st.relation) {
+					return fmt.Errorf("%s#%s: %s has no relation or permission %s", typ, rel, st.typ, st.relation)
+				}
+			}
+		}
+
+		for _, perm := range slices.Sorted(maps.Keys(def.permissions)) {
+			for _, op := range def.permissions[perm] {
+				if op.arrow == "" {
+					if !def.has(op.relation) {
+						return fmt.Errorf("%s#%s: %s has no relation or permission %s", typ, perm, typ, op.relation)
+					}
+					continue
+				}
+
+				types, ok := def.relations[op.relation]
+				if !ok {
+					return fmt.Errorf("%s#%s: %s has no relation %s to follow", typ, perm, typ, op.relation)
+				}
+				for _, st := range types {
+					if st.relation == "" && !s.definitions[st.typ].has(op.arrow) {
+						return fmt.Errorf("%s#%s: %s has no relation or permission %s", typ, perm, st.typ, op.arrow)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRelationship makes sure the schema allows the relationship.
+func (s *Schema) checkRelationship(resourceType, relation string, subject subjectType) error {
+	def, ok := s.definitions[resourceType]
+	if !ok {
+		return fmt.Errorf("type %s is not defined", resourceType)
+	}
+	types, ok := def.relations[relation]
+	if !ok {
+		return fmt.Errorf("%s has no relation %s", resourceType, relation)
+	}
+	if !slices.Contains(types, subject) {
+		name := subject.typ
+		if subject.relation != "" {
+			name += "#" + subject.relation
+		}
+		return fmt.Errorf("%s#%s does not allow subjects of %s", resourceType, relation, name)
+	}
+
+	return nil
+}
+
+// permissionsWith returns the permissions of the type the relation or permission is an operand of.
+func (s *Schema) permissionsWith(typ, name string) []string {
+	var perms []string
+	for perm, operands := range s.definitions[typ].permissions {
+		if slices.Contains(operands, operand{relation: name}) {
+			perms = append(perms, perm)
+		}
+	}
+
+	return perms
+}
+
+// arrowsTo returns the arrows following a relation to objects of the type to the relation or permission.
+func (s *Schema) arrowsTo(typ, name string) []arrow {
+	var arrows []arrow
+	for resourceType, def := range s.definitions {
+		for perm, operands := range def.permissions {
+			for _, op := range operands {
+				if op.arrow == name && slices.Contains(def.relations[op.relation], subjectType{typ: typ}) {
+					arrows = append(arrows, arrow{typ: resourceType, relation: op.relation, permission: perm})
+				}
+			}
+		}
+	}
+
+	return arrows
+}
+
+type token struct {
+	text string
+	line int
+}
+
+// lex splits the source into names and punctuation.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i, line := range strings.Split(src, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+
+		for j := 0; j < len(line); {
+			c := line[j]
+			switch {
+			case c == ' ' || c == '\t' || c == '\r':
+				j++
+			case strings.HasPrefix(line[j:], "->"):
+				tokens = append(tokens, token{text: "->", line: i + 1})
+				j += 2
+			case strings.IndexByte("{}:|#=+", c) >= 0:
+				tokens = append(tokens, token{text: string(c), line: i + 1})
+				j++
+			case isNameChar(c):
+				k := j
+				for k < len(line) && isNameChar(line[k]) {
+					k++
+				}
+				tokens = append(tokens, token{text: line[j:k], line: i + 1})
+				j = k
+			default:
+				return nil, fmt.Errorf("line %d: unexpected character %q", i+1, c)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) more() bool {
+	return p.pos < len(p.tokens)
+}
+
+// peek returns the next token without consuming it. The end of the source is an empty token.
+func (p *parser) peek() token {
+	if !p.more() {
+		line := 0
+		if len(p.tokens) > 0 {
+			line = p.tokens[len(p.tokens)-1].line
+		}
+		return token{line: line}
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.more() {
+		p.pos++
+	}
+
+	return t
+}
+
+// accept consumes the next token if it is the text.
+func (p *parser) accept(text string) bool {
+	if p.more() && p.peek().text == text {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); t.text != text {
+		return fmt.Errorf("line %d: expected %q, found %s", t.line, text, describe(t))
+	}
+
+	return nil
+}
+
+// name consumes the name of the kind.
+func (p *parser) name(kind string) (string, error) {
+	t := p.next()
+	if !namePattern.MatchString(t.text) {
+		return "", fmt.Errorf("line %d: expected a %s name, found %s", t.line, kind, describe(t))
+	}
+
+	return t.text, nil
+}
+
+// definition parses the body of a definition, from its opening brace to its closing one.
+func (p *parser) definition() (definition, error) {
+	def := definition{
+		relations:   make(map[string][]subjectType),
+		permissions: make(map[string][]operand),
+	}
+
+	if err := p.expect("{"); err != nil {
+		return definition{}, err
+	}
+
+	for !p.accept("}") {
+		t := p.next()
+		if t.text != "relation" && t.text != "permission" {
+			return definition{}, fmt.Errorf("line %d: expected a relation or a permission, found %s", t.line, describe(t))
+		}
+
+		name, err := p.name(t.text)
+		if err != nil {
+			return definition{}, err
+		}
+		if def.has(name) {
+			return definition{}, fmt.Errorf("line %d: %s is defined twice", t.line, name)
+		}
+
+		if t.text == "relation" {
+			types, err := p.subjectTypes()
+			if err != nil {
+				return definition{}, err
+			}
+			def.relations[name] = types
+		} else {
+			operands, err := p.operands()
+			if err != nil {
+				return definition{}, err
+			}
+			def.permissions[name] = operands
+		}
+	}
+
+	return def, nil
+}
+
+// subjectTypes parses the subject types of a relation: ": type | type#relation ...".
+func (p *parser) subjectTypes() ([]subjectType, error) {
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	var types []subjectType
+	for {
+		typ, err := p.name("type")
+		if err != nil {
+			return nil, err
+		}
+		st := subjectType{typ: typ}
+		if p.accept("#") {
+			if st.relation, err = p.name("relation"); err != nil {
+				return nil, err
+			}
+		}
+		if !slices.Contains(types, st) {
+			types = append(types, st)
+		}
+
+		if !p.accept("|") {
+			return types, nil
+		}
+	}
+}
+
+// operands parses the operands of a permission: "= relation + relation->permission ...".
+func (p *parser) operands() ([]operand, error) {
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	var operands []operand
+	for {
+		rel, err := p.name("relation")
+		if err != nil {
+			return nil, err
+		}
+		op := operand{relation: rel}
+		if p.accept("->") {
+			if op.arrow, err = p.name("permission"); err != nil {
+				return nil, err
+			}
+		}
+		operands = append(operands, op)
+
+		if !p.accept("+") {
+			return operands, nil
+		}
+	}
+}
+
+func describe(t token) string {
+	if t.text == "" {
+		return "end of schema"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
@@ -0,0 +1,83 @@
+package authz_test
+
+import (
+	"testing"
+
+	"grpc-service-ref/internal/services/authz"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchema(t *testing.T) {
+	_, err := authz.ParseSchema(testSchema)
+	require.NoError(t, err)
+
+	_, err = authz.ParseSchema("")
+	require.NoError(t, err, "an empty schema defines no types")
+
+	tests := []struct {
+		name   string
+		schema string
+		errMsg string
+	}{
+		{
+			name:   "Unexpected character",
+			schema: "definition user {}\ndefinition doc { relation owner: user* }",
+			errMsg: "line 2: unexpected character '*'",
+		},
+		{
+			name:   "Type defined twice",
+			schema: "definition user {}\ndefinition user {}",
+			errMsg: "line 2: type user is defined twice",
+		},
+		{
+			name:   "Member defined twice",
+			schema: "definition user {}\ndefinition doc {\n relation owner: user\n permission owner = owner\n}",
+			errMsg: "line 4: owner is defined twice",
+		},
+		{
+			name:   "Unclosed definition",
+			schema: "definition user {",
+			errMsg: "line 1: expected a relation or a permission, found end of schema",
+		},
+		{
+			name:   "Invalid name",
+			schema: "definition User {}",
+			errMsg: `line 1: expected a type name, found "User"`,
+		},
+		{
+			name:   "Unknown subject type",
+			schema: "definition doc { relation owner: user }",
+			errMsg: "doc#owner: type user is not defined",
+		},
+		{
+			name:   "Unknown subject relation",
+			schema: "definition user {}\ndefinition doc { relation owner: user#member }",
+			errMsg: "doc#owner: user has no relation or permission member",
+		},
+		{
+			name:   "Unknown operand",
+			schema: "definition user {}\ndefinition doc { relation owner: user\n permission edit = owner + editor }",
+			errMsg: "doc#edit: doc has no relation or permission editor",
+		},
+		{
+			name:   "Arrow over a permission",
+			schema: "definition user {}\ndefinition doc { relation owner: user\n permission edit = owner\n permission view = edit->view }",
+			errMsg: "doc#view: doc has no relation edit to follow",
+		},
+		{
+			name:   "Arrow to an unknown permission",
+			schema: "definition user {}\ndefinition doc { relation parent: user\n permission view = parent->view }",
+			errMsg: "doc#view: user has no relation or permission view",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authz.ParseSchema(tt.schema)
+			require.Error(t, err)
+			assert.Equal(t, tt.errMsg, err.Error())
+		})
+	}
+}
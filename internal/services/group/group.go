@@ -0,0 +1,452 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/pagetoken"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+
+	maxNameLength        = 128
+	maxDescriptionLength = 1024
+)
+
+// Groups manages groups of users and resolves the access users get through them.
+// Callers of the management methods are authorized by the gRPC layer.
+type Groups struct {
+	log     *slog.Logger
+	store   Store
+	auditor Auditor
+}
+
+type Store interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	CreateGroup(ctx context.Context, g models.Group) (int64, error)
+	Group(ctx context.Context, id int64) (models.Group, error)
+	Groups(ctx context.Context, afterID int64, limit int) ([]models.Group, error)
+	SetGroupRoles(ctx context.Context, id int64, roles []string) error
+	DeleteGroup(ctx context.Context, id int64) error
+	AddGroupUser(ctx context.Context, groupID, userID int64) error
+	RemoveGroupUser(ctx context.Context, groupID, userID int64) error
+	AddSubgroup(ctx context.Context, groupID, subgroupID int64) error
+	RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error
+	GroupMembers(ctx context.Context, id int64) (models.GroupMembers, error)
+	UserGroups(ctx context.Context, userID int64) ([]models.UserGroup, error)
+}
+
+// Auditor records audit events. Recording never fails the audited operation.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+var (
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrGroupExists        = errors.New("group already exists")
+	ErrInvalidName        = errors.New("invalid name")
+	ErrInvalidDescription = errors.New("invalid description")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvalidPageToken   = errors.New("invalid page token")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrMemberExists       = errors.New("already a member of the group")
+	ErrMemberNotFound     = errors.New("not a member of the group")
+	// ErrCycle is returned when nesting a group would make it a member of itself.
+	ErrCycle = errors.New("group nesting would form a cycle")
+	// ErrForbidden is returned to callers asking about the access of other users
+	// without the groups:read permission.
+	ErrForbidden = errors.New("not allowed to read the access of the user")
+)
+
+// New returns a new instance of the Groups service.
+func New(log *slog.Logger, store Store, auditor Auditor) *Groups {
+	return &Groups{
+		log:     log,
+		store:   store,
+		auditor: auditor,
+	}
+}
+
+// CreateGroup creates a group granting the roles on behalf of the actor.
+func (g *Groups) CreateGroup(
+	ctx context.Context,
+	actor string,
+	name string,
+	description string,
+	roles []string,
+) (models.Group, error) {
+	const op = "group.CreateGroup"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+	)
+
+	name = strings.TrimSpace(name)
+	if name == "" || !utf8.ValidString(name) || utf8.RuneCountInString(name) > maxNameLength {
+		return models.Group{}, fmt.Errorf("%s: %w: name must be valid UTF-8 of 1 to %d characters", op, ErrInvalidName, maxNameLength)
+	}
+	if !utf8.ValidString(description) || utf8.RuneCountInString(description) > maxDescriptionLength {
+		return models.Group{}, fmt.Errorf("%s: %w: description must be valid UTF-8 of at most %d characters", op, ErrInvalidDescription, maxDescriptionLength)
+	}
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	group := models.Group{Name: name, Description: description, Roles: roles, CreatedAt: time.Now()}
+
+	id, err := g.store.CreateGroup(ctx, group)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupExists) {
+			log.Warn("group already exists", slog.String("name", name))
+			return models.Group{}, fmt.Errorf("%s: %w", op, ErrGroupExists)
+		}
+		log.Error("failed to create group", slog.String("err", err.Error()))
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+	group.ID = id
+
+	log.Info("group created", slog.Int64("group_id", id))
+
+	g.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventGroupCreated,
+		Actor:   actor,
+		Details: map[string]string{"group_id": strconv.FormatInt(id, 10), "name": name, "roles": strings.Join(roles, " ")},
+	})
+
+	return group, nil
+}
+
+// Group returns the group by id along with its direct members.
+func (g *Groups) Group(ctx context.Context, id int64) (models.Group, models.GroupMembers, error) {
+	const op = "group.Group"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.Int64("group_id", id),
+	)
+
+	group, err := g.store.Group(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			return models.Group{}, models.GroupMembers{}, fmt.Errorf("%s: %w", op, ErrGroupNotFound)
+		}
+		log.Error("failed to get group", slog.String("err", err.Error()))
+		return models.Group{}, models.GroupMembers{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	members, err := g.store.GroupMembers(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			return models.Group{}, models.GroupMembers{}, fmt.Errorf("%s: %w", op, ErrGroupNotFound)
+		}
+		log.Error("failed to get group members", slog.String("err", err.Error()))
+		return models.Group{}, models.GroupMembers{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return group, members, nil
+}
+
+// ListGroups returns a page of groups ordered by id and the token of the next page,
+// empty on the last page.
+func (g *Groups) ListGroups(ctx context.Context, pageToken string, pageSize int) ([]models.Group, string, error) {
+	const op = "group.ListGroups"
+
+	log := g.log.With(slog.String("op", op))
+
+	afterID, err := pagetoken.Decode(pageToken)
+	if err != nil {
+		log.Warn("invalid page token", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+	}
+
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	pageSize = min(pageSize, MaxPageSize)
+
+	// One extra group tells whether there is a next page.
+	groups, err := g.store.Groups(ctx, afterID, pageSize+1)
+	if err != nil {
+		log.Error("failed to list groups", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(groups) > pageSize {
+		groups = groups[:pageSize]
+		next = pagetoken.Encode(groups[pageSize-1].ID)
+	}
+
+	return groups, next, nil
+}
+
+// SetGroupRoles replaces the roles the group grants on behalf of the actor.
+// Members get the new roles in the tokens they are issued from then on.
+func (g *Groups) SetGroupRoles(ctx context.Context, actor string, id int64, roles []string) error {
+	const op = "group.SetGroupRoles"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("group_id", id),
+	)
+
+	roles, err := normalizeRoles(roles)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := g.store.SetGroupRoles(ctx, id, roles); err != nil {
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrGroupNotFound)
+		}
+		log.Error("failed to update group roles", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("group roles updated", slog.Any("roles", roles))
+
+	g.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventGroupRolesChanged,
+		Actor:   actor,
+		Details: map[string]string{"group_id": strconv.FormatInt(id, 10), "roles": strings.Join(roles, " ")},
+	})
+
+	return nil
+}
+
+// DeleteGroup deletes the group on behalf of the actor. Its members are left as they are.
+func (g *Groups) DeleteGroup(ctx context.Context, actor string, id int64) error {
+	const op = "group.DeleteGroup"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("group_id", id),
+	)
+
+	if err := g.store.DeleteGroup(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrGroupNotFound)
+		}
+		log.Error("failed to delete group", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("group deleted")
+
+	g.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventGroupDeleted,
+		Actor:   actor,
+		Details: map[string]string{"group_id": strconv.FormatInt(id, 10)},
+	})
+
+	return nil
+}
+
+// AddUser makes the user a direct member of the group on behalf of the actor.
+func (g *Groups) AddUser(ctx context.Context, actor string, groupID, userID int64) error {
+	const op = "group.AddUser"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("group_id", groupID),
+		slog.Int64("uid", userID),
+	)
+
+	if err := g.store.AddGroupUser(ctx, groupID, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, g.memberError(log, err, "failed to add user to group"))
+	}
+
+	log.Info("user added to group")
+
+	g.recordMember(ctx, models.EventGroupMemberAdded, actor, groupID, userPrincipal(userID), "")
+
+	return nil
+}
+
+// RemoveUser removes the user from the direct members of the group on behalf of the actor.
+// The user stays a member through the nested groups it is a member of, if any.
+func (g *Groups) RemoveUser(ctx context.Context, actor string, groupID, userID int64) error {
+	const op = "group.RemoveUser"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("group_id", groupID),
+		slog.Int64("uid", userID),
+	)
+
+	if err := g.store.RemoveGroupUser(ctx, groupID, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, g.memberError(log, err, "failed to remove user from group"))
+	}
+
+	log.Info("user removed from group")
+
+	g.recordMember(ctx, models.EventGroupMemberRemoved, actor, groupID, userPrincipal(userID), "")
+
+	return nil
+}
+
+// AddSubgroup nests the subgroup in the group on behalf of the actor: the members of the subgroup
+// become members of the group. Nesting a group in itself, directly or not, fails with ErrCycle.
+func (g *Groups) AddSubgroup(ctx context.Context, actor string, groupID, subgroupID int64) error {
+	const op = "group.AddSubgroup"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("group_id", groupID),
+		slog.Int64("subgroup_id", subgroupID),
+	)
+
+	if err := g.store.AddSubgroup(ctx, groupID, subgroupID); err != nil {
+		return fmt.Errorf("%s: %w", op, g.memberError(log, err, "failed to add subgroup"))
+	}
+
+	log.Info("subgroup added")
+
+	g.recordMember(ctx, models.EventGroupMemberAdded, actor, groupID, "", strconv.FormatInt(subgroupID, 10))
+
+	return nil
+}
+
+// RemoveSubgroup removes the subgroup from the group on behalf of the actor.
+func (g *Groups) RemoveSubgroup(ctx context.Context, actor string, groupID, subgroupID int64) error {
+	const op = "group.RemoveSubgroup"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("group_id", groupID),
+		slog.Int64("subgroup_id", subgroupID),
+	)
+
+	if err := g.store.RemoveSubgroup(ctx, groupID, subgroupID); err != nil {
+		return fmt.Errorf("%s: %w", op, g.memberError(log, err, "failed to remove subgroup"))
+	}
+
+	log.Info("subgroup removed")
+
+	g.recordMember(ctx, models.EventGroupMemberRemoved, actor, groupID, "", strconv.FormatInt(subgroupID, 10))
+
+	return nil
+}
+
+// Access returns the effective access of the user: its roles along with the roles of its groups,
+// nested ones included, and the permissions they grant. Users read their own access,
+// callers with the groups:read permission the access of any user.
+func (g *Groups) Access(ctx context.Context, caller models.Principal, userID int64) (models.Access, error) {
+	const op = "group.Access"
+
+	log := g.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	if !(caller.IsUser() && caller.ID == userID) && !caller.HasPermission(models.PermissionGroupsRead) {
+		log.Warn("caller may not read the access of the user", slog.String("principal", caller.String()))
+		return models.Access{}, fmt.Errorf("%s: %w", op, ErrForbidden)
+	}
+
+	user, err := g.store.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Access{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return models.Access{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	groups, err := g.store.UserGroups(ctx, userID)
+	if err != nil {
+		log.Error("failed to get user groups", slog.String("err", err.Error()))
+		return models.Access{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.ResolveAccess(user.Roles, groups), nil
+}
+
+// CheckPermission reports whether the user has the permission and returns the grants of the roles
+// that give it, each with the path of groups it comes through. The user is authorized as by Access.
+func (g *Groups) CheckPermission(
+	ctx context.Context,
+	caller models.Principal,
+	userID int64,
+	permission string,
+) ([]models.RoleGrant, error) {
+	const op = "group.CheckPermission"
+
+	access, err := g.Access(ctx, caller, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return access.PermissionGrants(permission), nil
+}
+
+// memberError maps a storage error of a membership change to the error of the service.
+func (g *Groups) memberError(log *slog.Logger, err error, msg string) error {
+	switch {
+	case errors.Is(err, storage.ErrGroupNotFound):
+		return ErrGroupNotFound
+	case errors.Is(err, storage.ErrUserNotFound):
+		return ErrUserNotFound
+	case errors.Is(err, storage.ErrGroupMemberExists):
+		return ErrMemberExists
+	case errors.Is(err, storage.ErrGroupMemberNotFound):
+		return ErrMemberNotFound
+	case errors.Is(err, storage.ErrGroupCycle):
+		log.Warn("group nesting would form a cycle")
+		return ErrCycle
+	}
+	log.Error(msg, slog.String("err", err.Error()))
+
+	return err
+}
+
+// recordMember records a membership change of a user, the subject, or of a subgroup.
+func (g *Groups) recordMember(ctx context.Context, typ, actor string, groupID int64, subject, subgroupID string) {
+	details := map[string]string{"group_id": strconv.FormatInt(groupID, 10)}
+	if subgroupID != "" {
+		details["subgroup_id"] = subgroupID
+	}
+
+	g.auditor.Record(ctx, models.AuditEvent{
+		Type:    typ,
+		Actor:   actor,
+		Subject: subject,
+		Details: details,
+	})
+}
+
+func userPrincipal(id int64) string {
+	return models.Principal{Type: models.PrincipalUser, ID: id}.String()
+}
+
+// normalizeRoles sorts the roles and drops duplicates. Roles must not be empty.
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role == "" {
+			return nil, fmt.Errorf("%w: role is empty", ErrInvalidRole)
+		}
+		normalized = append(normalized, role)
+	}
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized), nil
+}
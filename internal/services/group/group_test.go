@@ -0,0 +1,107 @@
+package group_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/group"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroups_Access(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	g := group.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, nopAuditor{})
+
+	userID, err := store.CreateUser(ctx, models.UserIdentifiers{Email: "user@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+	require.NoError(t, store.SetUserRoles(ctx, userID, []string{"billing"}))
+
+	eng, err := g.CreateGroup(ctx, "user:1", " eng ", "Engineering", []string{models.RoleSupport, models.RoleSupport})
+	require.NoError(t, err)
+	assert.Equal(t, "eng", eng.Name)
+	assert.Equal(t, []string{models.RoleSupport}, eng.Roles)
+	staff, err := g.CreateGroup(ctx, "user:1", "staff", "", nil)
+	require.NoError(t, err)
+	admins, err := g.CreateGroup(ctx, "user:1", "admins", "", []string{models.RoleAdmin})
+	require.NoError(t, err)
+
+	_, err = g.CreateGroup(ctx, "user:1", "eng", "", nil)
+	require.ErrorIs(t, err, group.ErrGroupExists)
+
+	require.NoError(t, g.AddUser(ctx, "user:1", eng.ID, userID))
+	require.NoError(t, g.AddSubgroup(ctx, "user:1", staff.ID, eng.ID))
+	require.NoError(t, g.AddSubgroup(ctx, "user:1", admins.ID, staff.ID))
+	require.ErrorIs(t, g.AddSubgroup(ctx, "user:1", eng.ID, admins.ID), group.ErrCycle)
+	require.ErrorIs(t, g.AddUser(ctx, "user:1", eng.ID, userID), group.ErrMemberExists)
+
+	self := models.Principal{Type: models.PrincipalUser, ID: userID}
+	access, err := g.Access(ctx, self, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleAdmin, "billing", models.RoleSupport}, access.Roles)
+	assert.Contains(t, access.Permissions, models.PermissionUsersWrite)
+	assert.Equal(t, []string{"admins", "eng", "staff"}, access.GroupNames())
+
+	grants, err := g.CheckPermission(ctx, self, userID, models.PermissionUsersRead)
+	require.NoError(t, err)
+	require.Len(t, grants, 2)
+	assert.Equal(t, models.RoleSupport, grants[0].Role)
+	assert.Equal(t, []models.Group{eng}, grants[0].Path)
+	assert.Equal(t, models.RoleAdmin, grants[1].Role)
+	require.Len(t, grants[1].Path, 3, "the path runs from the group of the user to the granting group")
+	assert.Equal(t, []string{"eng", "staff", "admins"},
+		[]string{grants[1].Path[0].Name, grants[1].Path[1].Name, grants[1].Path[2].Name})
+
+	grants, err = g.CheckPermission(ctx, self, userID, "unknown:permission")
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+
+	require.NoError(t, g.RemoveSubgroup(ctx, "user:1", admins.ID, staff.ID))
+	grants, err = g.CheckPermission(ctx, self, userID, models.PermissionUsersWrite)
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+
+	other := models.Principal{Type: models.PrincipalUser, ID: userID + 1}
+	_, err = g.Access(ctx, other, userID)
+	require.ErrorIs(t, err, group.ErrForbidden)
+
+	support := models.Principal{Type: models.PrincipalUser, ID: userID + 1, Roles: []string{models.RoleSupport}}
+	_, err = g.Access(ctx, support, userID)
+	require.NoError(t, err)
+	_, err = g.Access(ctx, support, -1)
+	require.ErrorIs(t, err, group.ErrUserNotFound)
+}
+
+func TestGroups_ListGroups(t *testing.T) {
+	ctx := context.Background()
+	g := group.New(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.New(), nopAuditor{})
+
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := g.CreateGroup(ctx, "user:1", name, "", nil)
+		require.NoError(t, err)
+	}
+
+	page, next, err := g.ListGroups(ctx, "", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.NotEmpty(t, next)
+
+	page, next, err = g.ListGroups(ctx, next, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "c", page[0].Name)
+	assert.Empty(t, next)
+
+	_, _, err = g.ListGroups(ctx, "!", 2)
+	require.ErrorIs(t, err, group.ErrInvalidPageToken)
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, models.AuditEvent) {}
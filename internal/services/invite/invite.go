@@ -0,0 +1,211 @@
+package invite
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const codePrefix = "ssoreg_"
+
+// Invites creates the codes users register with while registration is restricted.
+// Codes are redeemed by the Auth service on registration.
+type Invites struct {
+	log     *slog.Logger
+	store   Store
+	auditor Auditor
+	opts    Options
+}
+
+type Store interface {
+	SaveInviteCode(ctx context.Context, code models.InviteCode) (int64, error)
+	RevokeInviteCode(ctx context.Context, id int64, revokedAt time.Time) error
+}
+
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+// Options configure invite codes.
+type Options struct {
+	// CodeTTL is how long codes created without an expiry can be registered with.
+	CodeTTL time.Duration
+	// Emails normalizes the emails codes are bound to, the way emails of users are normalized.
+	Emails emailaddr.Normalizer
+}
+
+// CodeOptions describe the code to create. Every field is optional.
+type CodeOptions struct {
+	// Email is the only email the code registers.
+	Email string
+	// Roles are given to the registered user.
+	Roles []string
+	// OrgID is the organization the registered user joins with OrgRoles, member by default.
+	OrgID    int64
+	OrgRoles []string
+	// ExpiresAt defaults to CodeTTL from now.
+	ExpiresAt time.Time
+}
+
+var (
+	ErrInvalidEmail  = errors.New("invalid email")
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrInvalidExpiry = errors.New("invalid expiry")
+	ErrOrgNotFound   = errors.New("organization not found")
+	ErrCodeNotFound  = errors.New("invite code not found")
+)
+
+// New returns a new instance of the Invites service.
+func New(log *slog.Logger, opts Options, store Store, auditor Auditor) *Invites {
+	if opts.CodeTTL <= 0 {
+		opts.CodeTTL = 7 * 24 * time.Hour
+	}
+
+	return &Invites{
+		log:     log,
+		store:   store,
+		auditor: auditor,
+		opts:    opts,
+	}
+}
+
+// CreateInviteCode creates a single-use invite code on behalf of the creator.
+// The code itself is returned once; only its hash is stored.
+func (i *Invites) CreateInviteCode(
+	ctx context.Context,
+	creator string,
+	opts CodeOptions,
+) (models.InviteCode, string, error) {
+	const op = "invite.CreateInviteCode"
+
+	log := i.log.With(
+		slog.String("op", op),
+		slog.String("creator", creator),
+	)
+
+	now := time.Now()
+	code := models.InviteCode{
+		OrgID:     opts.OrgID,
+		Creator:   creator,
+		CreatedAt: now,
+		ExpiresAt: opts.ExpiresAt,
+	}
+
+	if opts.Email != "" {
+		email, err := i.opts.Emails.Normalize(opts.Email)
+		if err != nil {
+			return models.InviteCode{}, "", fmt.Errorf("%s: %w", op, ErrInvalidEmail)
+		}
+		code.Email = email
+	}
+
+	var err error
+	if code.Roles, err = normalizeRoles(opts.Roles); err != nil {
+		return models.InviteCode{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if code.OrgRoles, err = normalizeRoles(opts.OrgRoles); err != nil {
+		return models.InviteCode{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	switch {
+	case code.OrgID == 0 && len(code.OrgRoles) > 0:
+		return models.InviteCode{}, "", fmt.Errorf("%s: %w: organization roles without an organization", op, ErrInvalidRole)
+	case code.OrgID != 0 && len(code.OrgRoles) == 0:
+		code.OrgRoles = []string{models.OrgRoleMember}
+	}
+
+	if code.ExpiresAt.IsZero() {
+		code.ExpiresAt = now.Add(i.opts.CodeTTL)
+	} else if !code.ExpiresAt.After(now) {
+		return models.InviteCode{}, "", fmt.Errorf("%s: %w: expiry must be in the future", op, ErrInvalidExpiry)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		log.Error("failed to generate invite code", slog.String("err", err.Error()))
+		return models.InviteCode{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	plain := codePrefix + hex.EncodeToString(secret)
+	code.Hash = models.HashInviteCode(plain)
+
+	id, err := i.store.SaveInviteCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrgNotFound) {
+			log.Warn("organization not found", slog.Int64("org_id", code.OrgID))
+			return models.InviteCode{}, "", fmt.Errorf("%s: %w", op, ErrOrgNotFound)
+		}
+		log.Error("failed to save invite code", slog.String("err", err.Error()))
+		return models.InviteCode{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+	code.ID = id
+
+	log.Info("invite code created", slog.Int64("code_id", id))
+
+	details := map[string]string{"code_id": strconv.FormatInt(id, 10)}
+	if len(code.Roles) > 0 {
+		details["roles"] = strings.Join(code.Roles, " ")
+	}
+	if code.OrgID != 0 {
+		details[models.DetailOrg] = strconv.FormatInt(code.OrgID, 10)
+	}
+	i.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventInviteCodeCreated,
+		Actor:   creator,
+		Details: details,
+	})
+
+	return code, plain, nil
+}
+
+// RevokeInviteCode revokes the unused code on behalf of the actor.
+func (i *Invites) RevokeInviteCode(ctx context.Context, actor string, id int64) error {
+	const op = "invite.RevokeInviteCode"
+
+	log := i.log.With(
+		slog.String("op", op),
+		slog.String("actor", actor),
+		slog.Int64("code_id", id),
+	)
+
+	if err := i.store.RevokeInviteCode(ctx, id, time.Now()); err != nil {
+		if errors.Is(err, storage.ErrInviteCodeNotFound) {
+			log.Warn("invite code not found")
+			return fmt.Errorf("%s: %w", op, ErrCodeNotFound)
+		}
+		log.Error("failed to revoke invite code", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("invite code revoked")
+
+	i.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventInviteCodeRevoked,
+		Actor:   actor,
+		Details: map[string]string{"code_id": strconv.FormatInt(id, 10)},
+	})
+
+	return nil
+}
+
+// normalizeRoles sorts the roles and drops duplicates. Roles must not be empty.
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role == "" {
+			return nil, fmt.Errorf("%w: role is empty", ErrInvalidRole)
+		}
+		normalized = append(normalized, role)
+	}
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized), nil
+}
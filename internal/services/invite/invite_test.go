@@ -0,0 +1,74 @@
+package invite_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/invite"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateInviteCode(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	i := invite.New(slog.New(slog.NewTextHandler(io.Discard, nil)), invite.Options{CodeTTL: time.Hour}, store, nopAuditor{})
+
+	ownerID, err := store.CreateUser(ctx, models.UserIdentifiers{Email: "owner@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+	orgID, err := store.CreateOrganization(ctx,
+		models.Organization{Slug: "acme", Name: "Acme", CreatedAt: time.Now()},
+		models.Membership{UserID: ownerID, CreatedAt: time.Now()},
+	)
+	require.NoError(t, err)
+
+	code, plain, err := i.CreateInviteCode(ctx, "user:1", invite.CodeOptions{
+		Email: "Invitee@Example.com",
+		Roles: []string{"support", "admin", "support"},
+		OrgID: orgID,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plain, "ssoreg_"))
+	assert.Equal(t, "Invitee@example.com", code.Email)
+	assert.Equal(t, []string{"admin", "support"}, code.Roles)
+	assert.Equal(t, []string{models.OrgRoleMember}, code.OrgRoles, "codes join organizations as members by default")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), code.ExpiresAt, time.Minute)
+
+	stored, err := store.InviteCodeByHash(ctx, models.HashInviteCode(plain))
+	require.NoError(t, err)
+	assert.Equal(t, code.ID, stored.ID)
+	assert.Equal(t, "user:1", stored.Creator)
+
+	require.NoError(t, i.RevokeInviteCode(ctx, "user:1", code.ID))
+	require.ErrorIs(t, i.RevokeInviteCode(ctx, "user:1", code.ID), invite.ErrCodeNotFound)
+
+	tests := []struct {
+		name        string
+		opts        invite.CodeOptions
+		expectedErr error
+	}{
+		{name: "Invalid email", opts: invite.CodeOptions{Email: "invitee"}, expectedErr: invite.ErrInvalidEmail},
+		{name: "Empty role", opts: invite.CodeOptions{Roles: []string{""}}, expectedErr: invite.ErrInvalidRole},
+		{name: "Org roles without org", opts: invite.CodeOptions{OrgRoles: []string{"admin"}}, expectedErr: invite.ErrInvalidRole},
+		{name: "Unknown org", opts: invite.CodeOptions{OrgID: -1}, expectedErr: invite.ErrOrgNotFound},
+		{name: "Past expiry", opts: invite.CodeOptions{ExpiresAt: time.Now().Add(-time.Minute)}, expectedErr: invite.ErrInvalidExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := i.CreateInviteCode(ctx, "user:1", tt.opts)
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, models.AuditEvent) {}
@@ -0,0 +1,226 @@
+package organization_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/organization"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOrganization(t *testing.T) {
+	ctx := context.Background()
+	o, store := newOrganizations(t)
+	ownerID := createUser(t, store, "owner@example.com")
+	admin := models.Principal{Type: models.PrincipalUser, ID: createUser(t, store, "admin@example.com"), Roles: []string{models.RoleAdmin}}
+
+	org, err := o.CreateOrganization(ctx, admin, "acme", " Acme Inc ", ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, "Acme Inc", org.Name)
+
+	orgs, err := o.UserOrganizations(ctx, models.Principal{Type: models.PrincipalUser, ID: ownerID})
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, org.ID, orgs[0].Organization.ID)
+	assert.Equal(t, []string{models.OrgRoleAdmin}, orgs[0].Roles, "owners are admins")
+
+	tests := []struct {
+		name        string
+		caller      models.Principal
+		slug        string
+		orgName     string
+		ownerID     int64
+		expectedErr error
+	}{
+		{
+			name:        "Taken slug",
+			caller:      admin,
+			slug:        "acme",
+			orgName:     "Acme",
+			ownerID:     ownerID,
+			expectedErr: organization.ErrOrgExists,
+		},
+		{
+			name:        "Invalid slug",
+			caller:      admin,
+			slug:        "Acme Inc",
+			orgName:     "Acme",
+			ownerID:     ownerID,
+			expectedErr: organization.ErrInvalidSlug,
+		},
+		{
+			name:        "Empty name",
+			caller:      admin,
+			slug:        "other",
+			orgName:     " ",
+			ownerID:     ownerID,
+			expectedErr: organization.ErrInvalidName,
+		},
+		{
+			name:        "Unknown owner",
+			caller:      admin,
+			slug:        "other",
+			orgName:     "Other",
+			ownerID:     -1,
+			expectedErr: organization.ErrUserNotFound,
+		},
+		{
+			name:        "Not an admin",
+			caller:      models.Principal{Type: models.PrincipalUser, ID: ownerID},
+			slug:        "other",
+			orgName:     "Other",
+			ownerID:     ownerID,
+			expectedErr: organization.ErrForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := o.CreateOrganization(ctx, tt.caller, tt.slug, tt.orgName, tt.ownerID)
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	ctx := context.Background()
+	o, store := newOrganizations(t)
+	owner, org := createOrganization(t, o, store, "acme")
+
+	inv, token, err := o.CreateInvitation(ctx, owner, org.ID, "Invitee@Example.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Invitee@example.com", inv.Email)
+	assert.Equal(t, []string{models.OrgRoleMember}, inv.Roles)
+
+	other := models.Principal{Type: models.PrincipalUser, ID: createUser(t, store, "other@example.com")}
+	_, _, err = o.AcceptInvitation(ctx, other, token)
+	require.ErrorIs(t, err, organization.ErrInvitationNotFound, "invitations are accepted by their invitee only")
+
+	invitee := models.Principal{Type: models.PrincipalUser, ID: createUser(t, store, "invitee@example.com")}
+	accepted, membership, err := o.AcceptInvitation(ctx, invitee, token)
+	require.NoError(t, err)
+	assert.Equal(t, org, accepted)
+	assert.Equal(t, []string{models.OrgRoleMember}, membership.Roles)
+
+	_, _, err = o.AcceptInvitation(ctx, invitee, token)
+	require.ErrorIs(t, err, organization.ErrInvitationNotFound)
+
+	// Members read the members but do not manage them.
+	members, next, err := o.Members(ctx, invitee, org.ID, "", 1)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, owner.ID, members[0].UserID)
+	members, next, err = o.Members(ctx, invitee, org.ID, next, 1)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, invitee.ID, members[0].UserID)
+	assert.Empty(t, next)
+
+	_, _, err = o.CreateInvitation(ctx, invitee, org.ID, "next@example.com", nil)
+	require.ErrorIs(t, err, organization.ErrForbidden)
+	require.ErrorIs(t, o.SetMemberRoles(ctx, invitee, org.ID, invitee.ID, []string{models.OrgRoleAdmin}), organization.ErrForbidden)
+
+	revoked, revokedToken, err := o.CreateInvitation(ctx, owner, org.ID, "other@example.com", []string{"billing"})
+	require.NoError(t, err)
+	require.NoError(t, o.RevokeInvitation(ctx, owner, org.ID, revoked.ID))
+	_, _, err = o.AcceptInvitation(ctx, other, revokedToken)
+	require.ErrorIs(t, err, organization.ErrInvitationNotFound)
+	require.ErrorIs(t, o.RevokeInvitation(ctx, owner, org.ID, revoked.ID), organization.ErrInvitationNotFound)
+
+	require.NoError(t, o.SetMemberRoles(ctx, owner, org.ID, invitee.ID, []string{"billing", models.OrgRoleAdmin, "billing"}))
+	m, err := store.Membership(ctx, org.ID, invitee.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.OrgRoleAdmin, "billing"}, m.Roles)
+
+	// Members may leave.
+	require.NoError(t, o.RemoveMember(ctx, invitee, org.ID, invitee.ID))
+	_, _, err = o.Members(ctx, invitee, org.ID, "", 0)
+	require.ErrorIs(t, err, organization.ErrOrgNotFound)
+}
+
+func TestTenantBoundaries(t *testing.T) {
+	ctx := context.Background()
+	o, store := newOrganizations(t)
+	owner, acme := createOrganization(t, o, store, "acme")
+	_, globex := createOrganization(t, o, store, "globex")
+
+	// The owner of acme acting in it, even though it is also a member of globex.
+	_, token, err := o.CreateInvitation(ctx, models.Principal{Type: models.PrincipalUser, ID: 1, Roles: []string{models.RoleAdmin}},
+		globex.ID, "acme-owner@example.com", nil)
+	require.NoError(t, err)
+	_, _, err = o.AcceptInvitation(ctx, owner, token)
+	require.NoError(t, err)
+	tenant := owner
+	tenant.OrgID, tenant.OrgRoles = acme.ID, []string{models.OrgRoleAdmin}
+
+	_, err = o.Organization(ctx, tenant, acme.ID)
+	require.NoError(t, err)
+	_, err = o.Organization(ctx, tenant, globex.ID)
+	require.ErrorIs(t, err, organization.ErrOrgNotFound, "tokens scoped to an organization reach no other")
+	_, _, err = o.Members(ctx, tenant, globex.ID, "", 0)
+	require.ErrorIs(t, err, organization.ErrOrgNotFound)
+
+	orgs, err := o.UserOrganizations(ctx, tenant)
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, acme.ID, orgs[0].Organization.ID)
+
+	orgs, err = o.UserOrganizations(ctx, owner)
+	require.NoError(t, err)
+	assert.Len(t, orgs, 2)
+
+	outsider := models.Principal{Type: models.PrincipalUser, ID: createUser(t, store, "outsider@example.com")}
+	_, err = o.Organization(ctx, outsider, acme.ID)
+	require.ErrorIs(t, err, organization.ErrOrgNotFound, "organizations are hidden from non-members")
+
+	support := models.Principal{Type: models.PrincipalUser, ID: outsider.ID, Roles: []string{models.RoleSupport}}
+	_, err = o.Organization(ctx, support, acme.ID)
+	require.NoError(t, err, "orgs:read reads every organization")
+	require.ErrorIs(t, o.RemoveMember(ctx, support, acme.ID, owner.ID), organization.ErrOrgNotFound)
+}
+
+func newOrganizations(t *testing.T) (*organization.Organizations, *memory.Storage) {
+	t.Helper()
+
+	store := memory.New()
+	o := organization.New(slog.New(slog.NewTextHandler(io.Discard, nil)), organization.Options{}, store, nopAuditor{})
+
+	return o, store
+}
+
+func createUser(t *testing.T, store *memory.Storage, email string) int64 {
+	t.Helper()
+
+	id, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: email}, []byte("hash"))
+	require.NoError(t, err)
+
+	return id
+}
+
+// createOrganization creates the organization with a new user as its owner.
+func createOrganization(
+	t *testing.T,
+	o *organization.Organizations,
+	store *memory.Storage,
+	slug string,
+) (models.Principal, models.Organization) {
+	t.Helper()
+
+	owner := models.Principal{Type: models.PrincipalUser, ID: createUser(t, store, slug+"-owner@example.com")}
+	admin := models.Principal{Type: models.PrincipalUser, ID: owner.ID, Roles: []string{models.RoleAdmin}}
+
+	org, err := o.CreateOrganization(context.Background(), admin, slug, slug, owner.ID)
+	require.NoError(t, err)
+
+	return owner, org
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, models.AuditEvent) {}
@@ -0,0 +1,161 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"log/slog"
+	"time"
+)
+
+// Store reads outbox events and records their deliveries.
+type Store interface {
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error)
+	MarkOutboxDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+	MarkOutboxFailed(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+	MarkOutboxDead(ctx context.Context, id int64, lastErr string) error
+}
+
+// Sink receives outbox events. Events may be delivered more than once,
+// sinks tell repeated deliveries apart by the event id.
+type Sink interface {
+	Deliver(ctx context.Context, event models.OutboxEvent) error
+}
+
+// Options tune the relay.
+type Options struct {
+	PollInterval time.Duration // how often due events are looked up
+	BatchSize    int           // events claimed at once
+	MaxAttempts  int           // delivery attempts before an event is dead-lettered
+	MinBackoff   time.Duration // delay before the first retry, doubled for every next one
+	MaxBackoff   time.Duration // longest delay between retries
+	Lease        time.Duration // how long claimed events are hidden from other relays
+}
+
+// Relay delivers outbox events to its sinks at least once.
+type Relay struct {
+	log   *slog.Logger
+	store Store
+	sinks []Sink
+	opts  Options
+	now   func() time.Time
+}
+
+// New returns a relay delivering events of the store to every sink.
+func New(log *slog.Logger, store Store, opts Options, sinks ...Sink) *Relay {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = time.Minute
+	}
+
+	return &Relay{
+		log:   log,
+		store: store,
+		sinks: sinks,
+		opts:  opts,
+		now:   time.Now,
+	}
+}
+
+// Run delivers due events until the context is canceled.
+func (r *Relay) Run(ctx context.Context) {
+	const op = "outbox.Run"
+
+	log := r.log.With(slog.String("op", op))
+
+	log.Info("outbox relay is running", slog.Int("sinks", len(r.sinks)))
+
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error("failed to flush outbox", slog.String("err", err.Error()))
+		}
+
+		// A full batch means more events may be due already.
+		if err == nil && n == r.opts.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush claims one batch of due events, delivers them and returns the number of claimed events.
+// Events that fail are retried with exponential back-off until they run out of attempts.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	const op = "outbox.Flush"
+
+	events, err := r.store.ClaimOutboxEvents(ctx, r.opts.BatchSize, r.opts.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, event := range events {
+		if err := r.deliver(ctx, event); err != nil {
+			return len(events), fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return len(events), nil
+}
+
+func (r *Relay) deliver(ctx context.Context, event models.OutboxEvent) error {
+	log := r.log.With(
+		slog.Int64("event_id", event.ID),
+		slog.String("type", event.Type),
+	)
+
+	var errs []error
+	for _, sink := range r.sinks {
+		if err := sink.Deliver(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return r.store.MarkOutboxDelivered(ctx, event.ID, r.now())
+	}
+
+	deliveryErr := errors.Join(errs...).Error()
+	attempt := event.Attempts + 1
+
+	if attempt >= r.opts.MaxAttempts {
+		log.Error("outbox event is dead-lettered",
+			slog.Int("attempts", attempt),
+			slog.String("err", deliveryErr),
+		)
+		return r.store.MarkOutboxDead(ctx, event.ID, deliveryErr)
+	}
+
+	next := r.now().Add(r.backoff(attempt))
+	log.Warn("failed to deliver outbox event",
+		slog.Int("attempts", attempt),
+		slog.Time("next_attempt_at", next),
+		slog.String("err", deliveryErr),
+	)
+
+	return r.store.MarkOutboxFailed(ctx, event.ID, deliveryErr, next)
+}
+
+// backoff returns the delay after the failed attempt, attempts count from 1.
+func (r *Relay) backoff(attempt int) time.Duration {
+	delay := r.opts.MinBackoff
+	for i := 1; i < attempt && delay < r.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, r.opts.MaxBackoff)
+}
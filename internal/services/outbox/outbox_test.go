@@ -0,0 +1,156 @@
+package outbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlush_DeliversToWebhook(t *testing.T) {
+	var received []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, models.DomainEventUserRegistered, r.Header.Get("X-Event-Type"))
+		assert.NotEmpty(t, r.Header.Get("X-Event-Id"))
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = append(received, body)
+	}))
+	defer srv.Close()
+
+	store := newMemStore(
+		models.OutboxEvent{Type: models.DomainEventUserRegistered, AggregateID: "user:1",
+			Payload: []byte(`{"user_id":1,"email":"a@x.io"}`)},
+		models.OutboxEvent{Type: models.DomainEventUserRegistered, AggregateID: "user:2",
+			Payload: []byte(`{"user_id":2,"email":"b@x.io"}`)},
+	)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	relay := outbox.New(log, store, options(), outbox.NewLogSink(log), outbox.NewWebhookSink(srv.URL, time.Second))
+
+	n, err := relay.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	require.Len(t, received, 2)
+	assert.Equal(t, "user:1", received[0]["aggregate_id"])
+	assert.Equal(t, "a@x.io", received[0]["payload"].(map[string]any)["email"])
+	for _, event := range store.events {
+		assert.Equal(t, models.OutboxDelivered, event.Status)
+		assert.Equal(t, 1, event.Attempts)
+	}
+
+	n, err = relay.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestFlush_RetriesThenDeadLetters(t *testing.T) {
+	store := newMemStore(models.OutboxEvent{Type: models.DomainEventPasswordChanged, AggregateID: "user:1"})
+	sink := &failingSink{err: errors.New("unavailable")}
+	relay := outbox.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, options(), sink)
+
+	var backoffs []time.Duration
+	for range 3 {
+		start := time.Now()
+		_, err := relay.Flush(context.Background())
+		require.NoError(t, err)
+		if store.events[0].Status == models.OutboxPending {
+			backoffs = append(backoffs, store.events[0].NextAttemptAt.Sub(start).Round(time.Second))
+		}
+	}
+
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, backoffs)
+	assert.Equal(t, models.OutboxDead, store.events[0].Status)
+	assert.Equal(t, 3, store.events[0].Attempts)
+	assert.Contains(t, store.events[0].LastError, "unavailable")
+	assert.Equal(t, 3, sink.calls)
+
+	n, err := relay.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestWebhookSink_RejectsNon2xx(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := outbox.NewWebhookSink(srv.URL, time.Second).Deliver(context.Background(), models.OutboxEvent{ID: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+}
+
+func options() outbox.Options {
+	return outbox.Options{
+		PollInterval: time.Second,
+		BatchSize:    10,
+		MaxAttempts:  3,
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+	}
+}
+
+type failingSink struct {
+	err   error
+	calls int
+}
+
+func (s *failingSink) Deliver(context.Context, models.OutboxEvent) error {
+	s.calls++
+	return s.err
+}
+
+// memStore claims every pending event regardless of its next attempt time.
+type memStore struct {
+	events []models.OutboxEvent
+}
+
+func newMemStore(events ...models.OutboxEvent) *memStore {
+	for i := range events {
+		events[i].ID = int64(i + 1)
+		events[i].Status = models.OutboxPending
+	}
+	return &memStore{events: events}
+}
+
+func (m *memStore) ClaimOutboxEvents(_ context.Context, limit int, _ time.Duration) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	for _, event := range m.events {
+		if event.Status == models.OutboxPending && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *memStore) MarkOutboxDelivered(_ context.Context, id int64, deliveredAt time.Time) error {
+	event := &m.events[id-1]
+	event.Status, event.Attempts, event.DeliveredAt = models.OutboxDelivered, event.Attempts+1, deliveredAt
+	return nil
+}
+
+func (m *memStore) MarkOutboxFailed(_ context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+	event := &m.events[id-1]
+	event.Attempts, event.LastError, event.NextAttemptAt = event.Attempts+1, lastErr, nextAttemptAt
+	return nil
+}
+
+func (m *memStore) MarkOutboxDead(_ context.Context, id int64, lastErr string) error {
+	event := &m.events[id-1]
+	event.Status, event.Attempts, event.LastError = models.OutboxDead, event.Attempts+1, lastErr
+	return nil
+}
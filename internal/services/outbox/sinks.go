@@ -0,0 +1,99 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LogSink writes events to the log.
+type LogSink struct {
+	log *slog.Logger
+}
+
+func NewLogSink(log *slog.Logger) *LogSink {
+	return &LogSink{log: log}
+}
+
+func (s *LogSink) Deliver(_ context.Context, event models.OutboxEvent) error {
+	s.log.Info("domain event",
+		slog.Int64("event_id", event.ID),
+		slog.String("type", event.Type),
+		slog.String("aggregate_id", event.AggregateID),
+		slog.String("payload", string(event.Payload)),
+	)
+
+	return nil
+}
+
+// WebhookSink posts events as JSON to an HTTP endpoint.
+// Any response other than 2xx is a failed delivery.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// envelope is the JSON encoding of events sent to endpoints.
+type envelope struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, event models.OutboxEvent) error {
+	const op = "outbox.WebhookSink.Deliver"
+
+	body, err := Envelope(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.FormatInt(event.ID, 10))
+	req.Header.Set("X-Event-Type", event.Type)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected status %s", op, resp.Status)
+	}
+
+	return nil
+}
+
+// Envelope encodes the event as JSON with its id, type, aggregate id, creation time and payload.
+// Receivers tell repeated deliveries apart by the id.
+func Envelope(event models.OutboxEvent) ([]byte, error) {
+	return json.Marshal(envelope{
+		ID:          event.ID,
+		Type:        event.Type,
+		AggregateID: event.AggregateID,
+		CreatedAt:   event.CreatedAt,
+		Payload:     event.Payload,
+	})
+}
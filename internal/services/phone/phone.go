@@ -0,0 +1,483 @@
+// Package phone verifies the phone numbers of users and logs them in with one-time codes sent by SMS.
+package phone
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/identifier"
+	"grpc-service-ref/internal/lib/sms"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"math/big"
+	"time"
+)
+
+// CodeStore keeps the one-time codes sent to phone numbers.
+type CodeStore interface {
+	SavePhoneCode(ctx context.Context, code models.PhoneCode) (int64, error)
+	LatestPhoneCode(ctx context.Context, phone string, purpose models.PhoneCodePurpose, userID int64) (models.PhoneCode, error)
+	CountPhoneCodes(ctx context.Context, phone string, since time.Time) (int, error)
+	AddPhoneCodeAttempt(ctx context.Context, id int64) (int, error)
+	ConsumePhoneCode(ctx context.Context, id int64, consumedAt time.Time) error
+}
+
+// UserStore looks users up and records their verified phone numbers.
+type UserStore interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	UserByPhone(ctx context.Context, phone string) (models.User, error)
+	VerifyUserPhone(ctx context.Context, userID int64, phone string, verifiedAt time.Time) error
+}
+
+// TokenIssuer starts a session for the user, it is the same one Auth.Login uses.
+type TokenIssuer interface {
+	IssueToken(ctx context.Context, user models.User, client models.ClientInfo) (string, error)
+}
+
+// Auditor records audit events. Recording never fails the audited operation.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+// Options tune the codes and the limits on sending and guessing them.
+type Options struct {
+	// HashKey keys the hashes of the codes, so that stored hashes can't be reversed
+	// by trying every code without it. Every instance of the service needs the same key.
+	HashKey        []byte
+	CodeLength     int           // digits of a code
+	CodeTTL        time.Duration // how long a code may be used
+	MaxAttempts    int           // guesses of a code before it is void
+	ResendCooldown time.Duration // time between two codes for the same purpose to a number, per user for verification
+	MaxPerWindow   int           // codes sent to a number within the window
+	Window         time.Duration
+	// Message is the text of the SMS, with %s standing for the code.
+	Message string
+}
+
+// Phone verifies phone numbers and logs users in with one-time codes.
+type Phone struct {
+	log     *slog.Logger
+	codes   CodeStore
+	users   UserStore
+	sender  sms.Provider
+	tokens  TokenIssuer
+	auditor Auditor
+	opts    Options
+	now     func() time.Time
+}
+
+var (
+	ErrInvalidPhone    = errors.New("invalid phone number")
+	ErrInvalidCode     = errors.New("invalid code")
+	ErrCodeExpired     = errors.New("code expired")
+	ErrTooManyAttempts = errors.New("too many attempts")
+	ErrResendCooldown  = errors.New("code sent recently")
+	ErrTooManyCodes    = errors.New("too many codes sent to the phone number")
+	ErrPhoneTaken      = errors.New("phone number already taken")
+	ErrUserNotFound    = errors.New("user not found")
+)
+
+// New returns a new instance of the phone service.
+func New(
+	log *slog.Logger,
+	opts Options,
+	codes CodeStore,
+	users UserStore,
+	sender sms.Provider,
+	tokens TokenIssuer,
+	auditor Auditor,
+) *Phone {
+	if opts.CodeLength <= 0 {
+		opts.CodeLength = 6
+	}
+	if opts.CodeTTL <= 0 {
+		opts.CodeTTL = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.MaxPerWindow <= 0 {
+		opts.MaxPerWindow = 5
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Hour
+	}
+	if opts.Message == "" {
+		opts.Message = "Your verification code is %s"
+	}
+
+	return &Phone{
+		log:     log,
+		codes:   codes,
+		users:   users,
+		sender:  sender,
+		tokens:  tokens,
+		auditor: auditor,
+		opts:    opts,
+		now:     time.Now,
+	}
+}
+
+// Sent tells when a sent code expires and when another one may be sent to the number.
+type Sent struct {
+	ExpiresAt   time.Time
+	ResendAfter time.Time
+}
+
+// StartVerification sends a code to the phone number the user adds to their account.
+// Numbers of other users get no code. Codes of other users verifying the same number
+// stay valid, and their cooldowns don't hold back the code of the user.
+func (p *Phone) StartVerification(ctx context.Context, userID int64, phone string) (Sent, error) {
+	const op = "phone.StartVerification"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	phone, err := identifier.NormalizePhone(phone)
+	if err != nil {
+		return Sent{}, fmt.Errorf("%s: %w", op, ErrInvalidPhone)
+	}
+
+	owner, err := p.users.UserByPhone(ctx, phone)
+	switch {
+	case err == nil && owner.ID != userID:
+		log.Warn("phone number belongs to another user")
+		return Sent{}, fmt.Errorf("%s: %w", op, ErrPhoneTaken)
+	case err != nil && !errors.Is(err, storage.ErrUserNotFound):
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return Sent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sent, err := p.send(ctx, log, phone, models.PhoneCodeVerify, userID)
+	if err != nil {
+		return Sent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sent, nil
+}
+
+// ConfirmVerification checks the code sent to the phone number of the user
+// and makes it the verified phone number of the user.
+func (p *Phone) ConfirmVerification(ctx context.Context, userID int64, phone, code string) error {
+	const op = "phone.ConfirmVerification"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	phone, err := identifier.NormalizePhone(phone)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	// Codes sent to another user are as good as no code.
+	if _, err := p.check(ctx, log, phone, models.PhoneCodeVerify, userID, code); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := p.users.VerifyUserPhone(ctx, userID, phone, p.now()); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrPhoneTaken):
+			log.Warn("phone number taken since the code was sent")
+			return fmt.Errorf("%s: %w", op, ErrPhoneTaken)
+		case errors.Is(err, storage.ErrUserNotFound):
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to verify phone number", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("phone number verified")
+
+	subject := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+	p.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventPhoneVerified,
+		Actor:   subject,
+		Subject: subject,
+		Details: map[string]string{"phone": phone},
+	})
+
+	return nil
+}
+
+// SendLoginCode sends a login code to the phone number. Numbers of no user get no code,
+// but the result is the same, so that callers can't tell which numbers are registered.
+// For the same reason, numbers over the resend limits get no code and the same result too.
+func (p *Phone) SendLoginCode(ctx context.Context, phone string) (Sent, error) {
+	const op = "phone.SendLoginCode"
+
+	log := p.log.With(slog.String("op", op))
+
+	phone, err := identifier.NormalizePhone(phone)
+	if err != nil {
+		return Sent{}, fmt.Errorf("%s: %w", op, ErrInvalidPhone)
+	}
+
+	user, err := p.users.UserByPhone(ctx, phone)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("no user with the phone number")
+			return p.unsent(), nil
+		}
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return Sent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sent, err := p.send(ctx, log.With(slog.Int64("uid", user.ID)), phone, models.PhoneCodeLogin, user.ID)
+	if errors.Is(err, ErrResendCooldown) || errors.Is(err, ErrTooManyCodes) {
+		return p.unsent(), nil
+	}
+	if err != nil {
+		return Sent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sent, nil
+}
+
+// unsent returns the result of sending a code that was not sent, as if it had been.
+func (p *Phone) unsent() Sent {
+	now := p.now()
+	return Sent{ExpiresAt: now.Add(p.opts.CodeTTL), ResendAfter: now.Add(p.opts.ResendCooldown)}
+}
+
+// LoginWithCode checks the login code sent to the phone number and starts a session
+// of its user on the client. Logging in proves the user owns the number, so it is
+// verified if it was not yet.
+func (p *Phone) LoginWithCode(ctx context.Context, phone, code string, client models.ClientInfo) (string, error) {
+	const op = "phone.LoginWithCode"
+
+	log := p.log.With(slog.String("op", op))
+
+	phone, err := identifier.NormalizePhone(phone)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	sent, err := p.check(ctx, log, phone, models.PhoneCodeLogin, 0, code)
+	if err != nil {
+		p.auditor.Record(ctx, loginFailed(client, phone, failureReason(err)))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := p.users.UserByID(ctx, sent.UserID)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	// The number may have moved to another user since the code was sent.
+	if err != nil || user.Phone != phone || user.Status == models.UserStatusDeleted {
+		log.Warn("user of the code no longer has the phone number")
+		p.auditor.Record(ctx, loginFailed(client, phone, "user_not_found"))
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	if user.PhoneVerifiedAt.IsZero() {
+		if err := p.users.VerifyUserPhone(ctx, user.ID, phone, p.now()); err != nil {
+			log.Error("failed to verify phone number", slog.String("err", err.Error()))
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	token, err := p.tokens.IssueToken(ctx, user, client)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// send sends a new code for the purpose to the phone number, unless the number got one
+// too recently or too many within the window. Verification codes are too recent only
+// if they were sent for the same user, as users verifying a number don't share codes.
+func (p *Phone) send(
+	ctx context.Context,
+	log *slog.Logger,
+	phone string,
+	purpose models.PhoneCodePurpose,
+	userID int64,
+) (Sent, error) {
+	now := p.now()
+
+	last, err := p.codes.LatestPhoneCode(ctx, phone, purpose, codeOwner(purpose, userID))
+	switch {
+	case err == nil && now.Before(last.CreatedAt.Add(p.opts.ResendCooldown)):
+		log.Info("code sent recently")
+		return Sent{}, ErrResendCooldown
+	case err != nil && !errors.Is(err, storage.ErrPhoneCodeNotFound):
+		log.Error("failed to get last code", slog.String("err", err.Error()))
+		return Sent{}, err
+	}
+
+	n, err := p.codes.CountPhoneCodes(ctx, phone, now.Add(-p.opts.Window))
+	if err != nil {
+		log.Error("failed to count codes", slog.String("err", err.Error()))
+		return Sent{}, err
+	}
+	if n >= p.opts.MaxPerWindow {
+		log.Warn("too many codes sent to the phone number", slog.Int("codes", n))
+		return Sent{}, ErrTooManyCodes
+	}
+
+	code, err := newCode(p.opts.CodeLength)
+	if err != nil {
+		log.Error("failed to generate code", slog.String("err", err.Error()))
+		return Sent{}, err
+	}
+
+	saved := models.PhoneCode{
+		Phone:     phone,
+		Purpose:   purpose,
+		UserID:    userID,
+		Hash:      p.hash(phone, purpose, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(p.opts.CodeTTL),
+	}
+	if _, err := p.codes.SavePhoneCode(ctx, saved); err != nil {
+		log.Error("failed to save code", slog.String("err", err.Error()))
+		return Sent{}, err
+	}
+
+	// The code is saved before it is sent, so failed sends count against the limits too.
+	if err := p.sender.Send(ctx, phone, fmt.Sprintf(p.opts.Message, code)); err != nil {
+		log.Error("failed to send code", slog.String("err", err.Error()))
+		return Sent{}, err
+	}
+
+	log.Info("code sent", slog.String("purpose", string(purpose)))
+
+	p.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventPhoneCodeSent,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: userID}.String(),
+		Details: map[string]string{"phone": phone, "purpose": string(purpose)},
+	})
+
+	return Sent{ExpiresAt: saved.ExpiresAt, ResendAfter: now.Add(p.opts.ResendCooldown)}, nil
+}
+
+// check checks the code against the last one sent for the purpose to the phone number,
+// for the user if it is a verification code, and uses it up if it matches.
+// Every guess counts against the attempts, right ones included.
+func (p *Phone) check(
+	ctx context.Context,
+	log *slog.Logger,
+	phone string,
+	purpose models.PhoneCodePurpose,
+	userID int64,
+	code string,
+) (models.PhoneCode, error) {
+	sent, err := p.codes.LatestPhoneCode(ctx, phone, purpose, codeOwner(purpose, userID))
+	if err != nil {
+		if errors.Is(err, storage.ErrPhoneCodeNotFound) {
+			log.Info("no code sent to the phone number")
+			return models.PhoneCode{}, ErrInvalidCode
+		}
+		log.Error("failed to get code", slog.String("err", err.Error()))
+		return models.PhoneCode{}, err
+	}
+
+	now := p.now()
+	switch {
+	case !sent.ConsumedAt.IsZero():
+		log.Info("code already used")
+		return models.PhoneCode{}, ErrInvalidCode
+	case !now.Before(sent.ExpiresAt):
+		log.Info("code expired")
+		return models.PhoneCode{}, ErrCodeExpired
+	case sent.Attempts >= p.opts.MaxAttempts:
+		log.Warn("code guessed too many times")
+		return models.PhoneCode{}, ErrTooManyAttempts
+	}
+
+	// The guess is counted before it is checked, so concurrent guesses can't exceed the limit.
+	attempts, err := p.codes.AddPhoneCodeAttempt(ctx, sent.ID)
+	if err != nil {
+		log.Error("failed to count attempt", slog.String("err", err.Error()))
+		return models.PhoneCode{}, err
+	}
+	if attempts > p.opts.MaxAttempts {
+		log.Warn("code guessed too many times")
+		return models.PhoneCode{}, ErrTooManyAttempts
+	}
+
+	if !hmac.Equal(sent.Hash, p.hash(phone, purpose, code)) {
+		log.Info("wrong code", slog.Int("attempts", attempts))
+		return models.PhoneCode{}, ErrInvalidCode
+	}
+
+	if err := p.codes.ConsumePhoneCode(ctx, sent.ID, now); err != nil {
+		if errors.Is(err, storage.ErrPhoneCodeNotFound) {
+			log.Info("code used concurrently")
+			return models.PhoneCode{}, ErrInvalidCode
+		}
+		log.Error("failed to use code", slog.String("err", err.Error()))
+		return models.PhoneCode{}, err
+	}
+
+	return sent, nil
+}
+
+// codeOwner returns the user the codes for the purpose are looked up for. Login codes go to
+// whoever has the number, so they are looked up for any user.
+func codeOwner(purpose models.PhoneCodePurpose, userID int64) int64 {
+	if purpose == models.PhoneCodeVerify {
+		return userID
+	}
+	return 0
+}
+
+// hash returns the keyed hash of the code, bound to the number and purpose it was sent for.
+func (p *Phone) hash(phone string, purpose models.PhoneCodePurpose, code string) []byte {
+	mac := hmac.New(sha256.New, p.opts.HashKey)
+	mac.Write([]byte(string(purpose) + "\x00" + phone + "\x00" + code))
+	return mac.Sum(nil)
+}
+
+// newCode returns a random code of the digits.
+func newCode(digits int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*s", digits, n.String()), nil
+}
+
+// loginFailed returns the audit event of a failed login with a code sent to the phone number.
+func loginFailed(client models.ClientInfo, phone, reason string) models.AuditEvent {
+	event := models.AuditEvent{
+		Type:      models.EventLoginFailed,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Outcome:   models.OutcomeFailure,
+		Reason:    reason,
+		Details:   map[string]string{"phone": phone, "method": "sms_code"},
+	}
+	if client.App != "" {
+		event.Details[models.DetailApp] = client.App
+	}
+
+	return event
+}
+
+// failureReason returns the audit reason of a failed code check.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrCodeExpired):
+		return "code_expired"
+	case errors.Is(err, ErrTooManyAttempts):
+		return "too_many_attempts"
+	case errors.Is(err, ErrInvalidCode):
+		return "invalid_code"
+	default:
+		return "internal_error"
+	}
+}
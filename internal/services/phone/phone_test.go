@@ -0,0 +1,244 @@
+package phone_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/phone"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const number = "+14155550123"
+
+func TestLoginWithCode(t *testing.T) {
+	store := memory.New()
+	uid, err := store.CreateUser(context.Background(), models.UserIdentifiers{Phone: number}, []byte("hash"))
+	require.NoError(t, err)
+
+	sender := &smsRecorder{}
+	tokens := &tokenIssuer{}
+	p := phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		phone.Options{HashKey: []byte("key")}, store, store, sender, tokens, nopAuditor{})
+
+	sent, err := p.SendLoginCode(context.Background(), "+1 (415) 555-0123")
+	require.NoError(t, err)
+	assert.True(t, sent.ExpiresAt.After(time.Now()))
+
+	require.Len(t, sender.messages, 1)
+	assert.Equal(t, number, sender.messages[0].phone)
+	code := sender.lastCode()
+	require.Len(t, code, 6)
+
+	_, err = p.LoginWithCode(context.Background(), number, wrong(code), models.ClientInfo{})
+	require.ErrorIs(t, err, phone.ErrInvalidCode)
+
+	token, err := p.LoginWithCode(context.Background(), number, code, models.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+	assert.Equal(t, uid, tokens.user.ID)
+
+	_, err = p.LoginWithCode(context.Background(), number, code, models.ClientInfo{})
+	require.ErrorIs(t, err, phone.ErrInvalidCode, "codes are used once")
+
+	user, err := store.UserByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.False(t, user.PhoneVerifiedAt.IsZero(), "logging in verifies the number")
+}
+
+func TestSendLoginCode_UnknownNumber(t *testing.T) {
+	store := memory.New()
+	sender := &smsRecorder{}
+	p := phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		phone.Options{HashKey: []byte("key")}, store, store, sender, &tokenIssuer{}, nopAuditor{})
+
+	sent, err := p.SendLoginCode(context.Background(), number)
+	require.NoError(t, err, "unknown numbers are not told apart")
+	assert.False(t, sent.ExpiresAt.IsZero())
+	assert.Empty(t, sender.messages)
+
+	_, err = p.SendLoginCode(context.Background(), "555-0123")
+	require.ErrorIs(t, err, phone.ErrInvalidPhone)
+}
+
+func TestLoginWithCode_Limits(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        phone.Options
+		guess       func(t *testing.T, p *phone.Phone, code string)
+		expectedErr error
+	}{
+		{
+			name: "TooManyAttempts",
+			opts: phone.Options{MaxAttempts: 2},
+			guess: func(t *testing.T, p *phone.Phone, code string) {
+				for range 2 {
+					_, err := p.LoginWithCode(context.Background(), number, wrong(code), models.ClientInfo{})
+					require.ErrorIs(t, err, phone.ErrInvalidCode)
+				}
+			},
+			expectedErr: phone.ErrTooManyAttempts,
+		},
+		{
+			name:        "Expired",
+			opts:        phone.Options{CodeTTL: time.Nanosecond},
+			guess:       func(*testing.T, *phone.Phone, string) {},
+			expectedErr: phone.ErrCodeExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memory.New()
+			_, err := store.CreateUser(context.Background(), models.UserIdentifiers{Phone: number}, []byte("hash"))
+			require.NoError(t, err)
+
+			sender := &smsRecorder{}
+			tt.opts.HashKey = []byte("key")
+			p := phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+				tt.opts, store, store, sender, &tokenIssuer{}, nopAuditor{})
+
+			_, err = p.SendLoginCode(context.Background(), number)
+			require.NoError(t, err)
+
+			tt.guess(t, p, sender.lastCode())
+
+			_, err = p.LoginWithCode(context.Background(), number, sender.lastCode(), models.ClientInfo{})
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestSendLoginCode_RateLimits(t *testing.T) {
+	store := memory.New()
+	_, err := store.CreateUser(context.Background(), models.UserIdentifiers{Phone: number}, []byte("hash"))
+	require.NoError(t, err)
+
+	sender := &smsRecorder{}
+	p := phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		phone.Options{HashKey: []byte("key"), ResendCooldown: time.Hour}, store, store, sender, &tokenIssuer{}, nopAuditor{})
+
+	_, err = p.SendLoginCode(context.Background(), number)
+	require.NoError(t, err)
+	sent, err := p.SendLoginCode(context.Background(), number)
+	require.NoError(t, err, "limited numbers are not told apart from unknown ones")
+	assert.False(t, sent.ExpiresAt.IsZero())
+	assert.Len(t, sender.messages, 1, "resends are limited by the cooldown")
+
+	sender = &smsRecorder{}
+	p = phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		phone.Options{HashKey: []byte("key"), MaxPerWindow: 2}, store, store, sender, &tokenIssuer{}, nopAuditor{})
+
+	_, err = p.SendLoginCode(context.Background(), number)
+	require.NoError(t, err)
+	_, err = p.SendLoginCode(context.Background(), number)
+	require.NoError(t, err)
+	assert.Len(t, sender.messages, 1, "codes of any sender count against the number")
+}
+
+func TestVerification(t *testing.T) {
+	store := memory.New()
+	uid, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: "user@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+	otherID, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: "other@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+
+	sender := &smsRecorder{}
+	p := phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		phone.Options{HashKey: []byte("key")}, store, store, sender, &tokenIssuer{}, nopAuditor{})
+
+	_, err = p.StartVerification(context.Background(), uid, number)
+	require.NoError(t, err)
+
+	err = p.ConfirmVerification(context.Background(), otherID, number, sender.lastCode())
+	require.ErrorIs(t, err, phone.ErrInvalidCode, "codes are bound to the user they were sent to")
+
+	_, err = p.StartVerification(context.Background(), uid, number)
+	require.NoError(t, err)
+
+	require.NoError(t, p.ConfirmVerification(context.Background(), uid, number, sender.lastCode()))
+
+	user, err := store.UserByPhone(context.Background(), number)
+	require.NoError(t, err)
+	assert.Equal(t, uid, user.ID)
+	assert.False(t, user.PhoneVerifiedAt.IsZero())
+
+	_, err = p.StartVerification(context.Background(), otherID, number)
+	require.ErrorIs(t, err, phone.ErrPhoneTaken)
+}
+
+func TestVerification_OtherUsers(t *testing.T) {
+	store := memory.New()
+	uid, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: "user@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+	otherID, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: "other@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+
+	sender := &smsRecorder{}
+	p := phone.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		phone.Options{HashKey: []byte("key"), ResendCooldown: time.Minute}, store, store, sender, &tokenIssuer{}, nopAuditor{})
+
+	_, err = p.StartVerification(context.Background(), uid, number)
+	require.NoError(t, err)
+	code := sender.lastCode()
+
+	_, err = p.StartVerification(context.Background(), otherID, number)
+	require.NoError(t, err, "the cooldown of another user doesn't apply")
+	_, err = p.StartVerification(context.Background(), uid, number)
+	require.ErrorIs(t, err, phone.ErrResendCooldown)
+
+	require.NoError(t, p.ConfirmVerification(context.Background(), uid, number, code),
+		"the code of another user doesn't void the code of the user")
+
+	_, err = p.StartVerification(context.Background(), otherID, number)
+	require.ErrorIs(t, err, phone.ErrPhoneTaken)
+	assert.Len(t, sender.messages, 2)
+}
+
+type smsMessage struct {
+	phone string
+	text  string
+}
+
+type smsRecorder struct {
+	messages []smsMessage
+}
+
+func (r *smsRecorder) Send(_ context.Context, phone, text string) error {
+	r.messages = append(r.messages, smsMessage{phone: phone, text: text})
+	return nil
+}
+
+// lastCode returns the code of the last message, which ends with it.
+func (r *smsRecorder) lastCode() string {
+	text := r.messages[len(r.messages)-1].text
+	return text[strings.LastIndex(text, " ")+1:]
+}
+
+type tokenIssuer struct {
+	user models.User
+}
+
+func (i *tokenIssuer) IssueToken(_ context.Context, user models.User, _ models.ClientInfo) (string, error) {
+	i.user = user
+	return "token", nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, models.AuditEvent) {}
+
+// wrong returns a code that differs from the code.
+func wrong(code string) string {
+	if code[0] == '0' {
+		return "1" + code[1:]
+	}
+	return "0" + code[1:]
+}
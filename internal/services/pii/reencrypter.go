@@ -0,0 +1,91 @@
+package pii
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Store re-encrypts the personal data that is not encrypted with the current key.
+type Store interface {
+	ReencryptPII(ctx context.Context, limit int) (int, error)
+}
+
+// Options tune the re-encrypter.
+type Options struct {
+	PollInterval time.Duration // how often outdated data is looked up
+	BatchSize    int           // rows re-encrypted at once
+}
+
+// Reencrypter brings personal data encrypted with older keys, or not encrypted at all,
+// up to the current key, e.g. after the master key rotates.
+type Reencrypter struct {
+	log   *slog.Logger
+	store Store
+	opts  Options
+}
+
+// NewReencrypter returns a re-encrypter of the personal data in the store.
+func NewReencrypter(log *slog.Logger, store Store, opts Options) *Reencrypter {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Minute
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	return &Reencrypter{
+		log:   log,
+		store: store,
+		opts:  opts,
+	}
+}
+
+// Run re-encrypts outdated data until the context is canceled.
+func (r *Reencrypter) Run(ctx context.Context) {
+	const op = "pii.Run"
+
+	log := r.log.With(slog.String("op", op))
+
+	log.Info("pii re-encrypter is running")
+
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error("failed to re-encrypt personal data", slog.String("err", err.Error()))
+		}
+		if n > 0 {
+			log.Info("personal data re-encrypted", slog.Int("rows", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush re-encrypts batches of outdated data until none is left and returns the number of rows
+// re-encrypted.
+func (r *Reencrypter) Flush(ctx context.Context) (int, error) {
+	const op = "pii.Flush"
+
+	var total int
+	for {
+		n, err := r.store.ReencryptPII(ctx, r.opts.BatchSize)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// A full batch means more data may be outdated.
+		if n < r.opts.BatchSize || ctx.Err() != nil {
+			return total, nil
+		}
+	}
+}
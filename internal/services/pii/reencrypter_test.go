@@ -0,0 +1,55 @@
+package pii_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"grpc-service-ref/internal/services/pii"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlush_ReencryptsUntilUpToDate(t *testing.T) {
+	store := &fakeStore{outdated: 25}
+	r := pii.NewReencrypter(slog.New(slog.NewTextHandler(io.Discard, nil)), store, pii.Options{BatchSize: 10})
+
+	n, err := r.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 25, n)
+	assert.Equal(t, 3, store.calls, "batches are taken until one is not full")
+
+	n, err = r.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestFlush_StopsOnError(t *testing.T) {
+	store := &fakeStore{outdated: 25, err: errors.New("unavailable")}
+	r := pii.NewReencrypter(slog.New(slog.NewTextHandler(io.Discard, nil)), store, pii.Options{BatchSize: 10})
+
+	_, err := r.Flush(context.Background())
+	require.ErrorIs(t, err, store.err)
+	assert.Equal(t, 1, store.calls)
+}
+
+type fakeStore struct {
+	outdated int
+	calls    int
+	err      error
+}
+
+func (s *fakeStore) ReencryptPII(_ context.Context, limit int) (int, error) {
+	s.calls++
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n := min(limit, s.outdated)
+	s.outdated -= n
+
+	return n, nil
+}
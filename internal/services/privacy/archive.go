@@ -0,0 +1,211 @@
+package privacy
+
+import (
+	"grpc-service-ref/internal/domain/models"
+	"time"
+)
+
+// Archive is the machine-readable export of the personal data of a user.
+// Secrets, such as the password hash and API key hashes, are left out.
+type Archive struct {
+	ExportedAt    time.Time           `json:"exported_at"`
+	User          ArchiveUser         `json:"user"`
+	Profile       ArchiveProfile      `json:"profile"`
+	Identities    []ArchiveIdentity   `json:"identities"`
+	Sessions      []ArchiveSession    `json:"sessions"`
+	APIKeys       []ArchiveAPIKey     `json:"api_keys"`
+	StatusHistory []ArchiveTransition `json:"status_history"`
+	Memberships   []ArchiveMembership `json:"memberships"`
+	AuditEvents   []ArchiveAuditEvent `json:"audit_events"`
+}
+
+type ArchiveUser struct {
+	ID              int64     `json:"id"`
+	Email           string    `json:"email,omitempty"`
+	Username        string    `json:"username,omitempty"`
+	Phone           string    `json:"phone,omitempty"`
+	PhoneVerifiedAt time.Time `json:"phone_verified_at,omitzero"`
+	Roles           []string  `json:"roles"`
+	Status          string    `json:"status"`
+	StatusReason    string    `json:"status_reason,omitempty"`
+	SuspendedUntil  time.Time `json:"suspended_until,omitzero"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type ArchiveProfile struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	GivenName   string            `json:"given_name,omitempty"`
+	FamilyName  string            `json:"family_name,omitempty"`
+	Locale      string            `json:"locale,omitempty"`
+	Timezone    string            `json:"timezone,omitempty"`
+	AvatarURL   string            `json:"avatar_url,omitempty"`
+	Attributes  models.Attributes `json:"attributes,omitempty"`
+}
+
+type ArchiveIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchiveSession struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+type ArchiveAPIKey struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+type ArchiveTransition struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	Until     time.Time `json:"until,omitzero"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchiveMembership struct {
+	OrgID    int64     `json:"org_id"`
+	OrgSlug  string    `json:"org_slug"`
+	OrgName  string    `json:"org_name"`
+	Roles    []string  `json:"roles"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type ArchiveAuditEvent struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	Actor     string            `json:"actor,omitempty"`
+	Subject   string            `json:"subject,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Reason    string            `json:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// NewArchive returns the archive of the data of a user and the audit events about it.
+func NewArchive(data models.UserData, events []models.AuditEvent, exportedAt time.Time) Archive {
+	user, profile := data.User, data.User.Profile
+
+	archive := Archive{
+		ExportedAt: exportedAt.UTC(),
+		User: ArchiveUser{
+			ID:              user.ID,
+			Email:           user.Email,
+			Username:        user.Username,
+			Phone:           user.Phone,
+			PhoneVerifiedAt: user.PhoneVerifiedAt,
+			Roles:           nonNil(user.Roles),
+			Status:          string(user.Status),
+			StatusReason:    user.StatusReason,
+			SuspendedUntil:  user.SuspendedUntil,
+			CreatedAt:       user.CreatedAt,
+		},
+		Profile: ArchiveProfile{
+			DisplayName: profile.DisplayName,
+			GivenName:   profile.GivenName,
+			FamilyName:  profile.FamilyName,
+			Locale:      profile.Locale,
+			Timezone:    profile.Timezone,
+			AvatarURL:   profile.AvatarURL,
+			Attributes:  profile.Attributes,
+		},
+		Identities:    make([]ArchiveIdentity, 0, len(data.Identities)),
+		Sessions:      make([]ArchiveSession, 0, len(data.Sessions)),
+		APIKeys:       make([]ArchiveAPIKey, 0, len(data.APIKeys)),
+		StatusHistory: make([]ArchiveTransition, 0, len(data.Transitions)),
+		Memberships:   make([]ArchiveMembership, 0, len(data.Memberships)),
+		AuditEvents:   make([]ArchiveAuditEvent, 0, len(events)),
+	}
+
+	for _, ident := range data.Identities {
+		archive.Identities = append(archive.Identities, ArchiveIdentity{
+			Provider:  ident.Provider,
+			Subject:   ident.Subject,
+			CreatedAt: ident.CreatedAt,
+		})
+	}
+	for _, sess := range data.Sessions {
+		archive.Sessions = append(archive.Sessions, ArchiveSession{
+			ID:         sess.ID,
+			UserAgent:  sess.UserAgent,
+			IP:         sess.IP,
+			CreatedAt:  sess.CreatedAt,
+			LastSeenAt: sess.LastSeenAt,
+			ExpiresAt:  sess.ExpiresAt,
+			RevokedAt:  sess.RevokedAt,
+		})
+	}
+	for _, key := range data.APIKeys {
+		archive.APIKeys = append(archive.APIKeys, ArchiveAPIKey{
+			ID:         key.ID,
+			Name:       key.Name,
+			Prefix:     key.Prefix,
+			Scopes:     nonNil(key.Scopes),
+			CreatedAt:  key.CreatedAt,
+			ExpiresAt:  key.ExpiresAt,
+			LastUsedAt: key.LastUsedAt,
+			RevokedAt:  key.RevokedAt,
+		})
+	}
+	for _, t := range data.Transitions {
+		archive.StatusHistory = append(archive.StatusHistory, ArchiveTransition{
+			From:      string(t.From),
+			To:        string(t.To),
+			Reason:    t.Reason,
+			Until:     t.Until,
+			Actor:     t.Actor,
+			CreatedAt: t.CreatedAt,
+		})
+	}
+	for _, m := range data.Memberships {
+		archive.Memberships = append(archive.Memberships, ArchiveMembership{
+			OrgID:    m.Organization.ID,
+			OrgSlug:  m.Organization.Slug,
+			OrgName:  m.Organization.Name,
+			Roles:    nonNil(m.Roles),
+			JoinedAt: m.JoinedAt,
+		})
+	}
+	for _, e := range events {
+		archive.AuditEvents = append(archive.AuditEvents, ArchiveAuditEvent{
+			ID:        e.ID,
+			Type:      e.Type,
+			Actor:     e.Actor,
+			Subject:   e.Subject,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Outcome:   e.Outcome,
+			Reason:    e.Reason,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	return archive
+}
+
+// nonNil returns the strings, or an empty slice in place of nil so that they encode as a JSON array.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
@@ -0,0 +1,110 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"time"
+)
+
+// Privacy accepts data-subject requests. The requests are run as background jobs by the Worker.
+type Privacy struct {
+	log   *slog.Logger
+	store Store
+}
+
+// Store saves data jobs.
+type Store interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	SaveDataJob(ctx context.Context, job models.DataJob) (int64, error)
+	DataJob(ctx context.Context, id int64) (models.DataJob, error)
+}
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrJobNotFound  = errors.New("data job not found")
+)
+
+// New returns a new instance of the Privacy service.
+func New(log *slog.Logger, store Store) *Privacy {
+	return &Privacy{
+		log:   log,
+		store: store,
+	}
+}
+
+// ExportUserData starts a job collecting the personal data of the user into a JSON archive
+// on behalf of the actor. The archive is read from the finished job.
+func (p *Privacy) ExportUserData(ctx context.Context, actor string, userID int64) (models.DataJob, error) {
+	const op = "privacy.ExportUserData"
+
+	return p.start(ctx, op, models.DataJob{
+		Kind:   models.DataJobExport,
+		UserID: userID,
+		Actor:  actor,
+	})
+}
+
+// EraseUser starts a job erasing the personal data of the user on behalf of the actor.
+func (p *Privacy) EraseUser(ctx context.Context, actor string, userID int64, reason string) (models.DataJob, error) {
+	const op = "privacy.EraseUser"
+
+	return p.start(ctx, op, models.DataJob{
+		Kind:   models.DataJobErase,
+		UserID: userID,
+		Actor:  actor,
+		Reason: reason,
+	})
+}
+
+// DataJob returns the job by id, along with the archive of a finished export.
+func (p *Privacy) DataJob(ctx context.Context, id int64) (models.DataJob, error) {
+	const op = "privacy.DataJob"
+
+	job, err := p.store.DataJob(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrDataJobNotFound) {
+			return models.DataJob{}, fmt.Errorf("%s: %w", op, ErrJobNotFound)
+		}
+		p.log.Error("failed to get data job",
+			slog.String("op", op),
+			slog.Int64("job_id", id),
+			slog.String("err", err.Error()),
+		)
+		return models.DataJob{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return job, nil
+}
+
+// start saves the job for an existing user.
+func (p *Privacy) start(ctx context.Context, op string, job models.DataJob) (models.DataJob, error) {
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", job.UserID),
+		slog.String("actor", job.Actor),
+	)
+
+	if _, err := p.store.UserByID(ctx, job.UserID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return models.DataJob{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return models.DataJob{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	job.CreatedAt = time.Now()
+	id, err := p.store.SaveDataJob(ctx, job)
+	if err != nil {
+		log.Error("failed to save data job", slog.String("err", err.Error()))
+		return models.DataJob{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("data job started", slog.Int64("job_id", id), slog.String("kind", string(job.Kind)))
+
+	return p.DataJob(ctx, id)
+}
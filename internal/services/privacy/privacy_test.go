@@ -0,0 +1,188 @@
+package privacy_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/services/privacy"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const actor = "user:1"
+
+func TestExportUserData(t *testing.T) {
+	p, w, store := newPrivacy(t, privacy.WorkerOptions{ArchiveTTL: time.Hour})
+	uid := createUser(t, store)
+	subject := models.Principal{Type: models.PrincipalUser, ID: uid}.String()
+
+	require.NoError(t, store.SaveIdentity(context.Background(), uid, "saml", "ann@idp"))
+	require.NoError(t, store.SaveSession(context.Background(), models.Session{
+		ID:         "s1",
+		UserID:     uid,
+		IP:         "192.0.2.1",
+		CreatedAt:  time.Now(),
+		LastSeenAt: time.Now(),
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}))
+	_, err := store.UpdateProfile(context.Background(), uid, models.ProfileUpdate{
+		Fields:  []string{models.ProfileDisplayName},
+		Profile: models.Profile{DisplayName: "Ann"},
+	})
+	require.NoError(t, err)
+	_, err = store.AppendAuditEvent(context.Background(), models.AuditEvent{
+		Type: models.EventLoginSucceeded, Actor: subject, Subject: subject, CreatedAt: time.Now(),
+	})
+	require.NoError(t, err)
+	_, err = store.AppendAuditEvent(context.Background(), models.AuditEvent{
+		Type: models.EventLoginSucceeded, Actor: "user:999", Subject: "user:999", CreatedAt: time.Now(),
+	})
+	require.NoError(t, err)
+
+	job, err := p.ExportUserData(context.Background(), actor, uid)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataJobPending, job.Status)
+
+	n, err := w.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	job, err = p.DataJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.DataJobSucceeded, job.Status)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), job.ExpiresAt, time.Minute)
+
+	var archive privacy.Archive
+	require.NoError(t, json.Unmarshal(job.Archive, &archive))
+	assert.Equal(t, uid, archive.User.ID)
+	assert.Equal(t, "Ann", archive.Profile.DisplayName)
+	require.Len(t, archive.Identities, 1)
+	assert.Equal(t, "ann@idp", archive.Identities[0].Subject)
+	require.Len(t, archive.Sessions, 1)
+	assert.Equal(t, "192.0.2.1", archive.Sessions[0].IP)
+	require.Len(t, archive.AuditEvents, 1, "only events about the user are exported")
+	assert.Equal(t, subject, archive.AuditEvents[0].Subject)
+	assert.NotContains(t, string(job.Archive), "pass", "secrets are not exported")
+
+	events, err := store.AuditEvents(context.Background(), models.AuditFilter{Type: models.EventUserDataExported}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, actor, events[0].Actor)
+	assert.Equal(t, subject, events[0].Subject)
+}
+
+func TestEraseUser(t *testing.T) {
+	p, w, store := newPrivacy(t, privacy.WorkerOptions{})
+	uid := createUser(t, store)
+	subject := models.Principal{Type: models.PrincipalUser, ID: uid}.String()
+
+	_, err := store.AppendAuditEvent(context.Background(), models.AuditEvent{
+		Type: models.EventLoginSucceeded, Actor: subject, Subject: subject, CreatedAt: time.Now(),
+	})
+	require.NoError(t, err)
+
+	job, err := p.EraseUser(context.Background(), actor, uid, "data-subject request")
+	require.NoError(t, err)
+
+	_, err = w.Flush(context.Background())
+	require.NoError(t, err)
+
+	job, err = p.DataJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataJobSucceeded, job.Status)
+	assert.Nil(t, job.Archive)
+
+	_, err = store.UserByID(context.Background(), uid)
+	require.Error(t, err)
+
+	events, err := store.AuditEvents(context.Background(), models.AuditFilter{Subject: subject}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2, "the audit log keeps the pseudonymous id")
+	assert.Equal(t, models.EventUserErased, events[0].Type)
+	assert.Equal(t, "data-subject request", events[0].Details["reason"])
+	require.NoError(t, audit.Verify([]models.AuditEvent{events[1], events[0]}))
+
+	_, err = p.ExportUserData(context.Background(), actor, uid)
+	require.ErrorIs(t, err, privacy.ErrUserNotFound)
+}
+
+func TestWorker_RetriesFailedJobs(t *testing.T) {
+	store := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	failing := &failingStore{Storage: store, failures: 2}
+	p := privacy.New(log, store)
+	w := privacy.NewWorker(log, failing, store, audit.New(log, store), privacy.WorkerOptions{
+		MaxAttempts: 2,
+		MinBackoff:  -time.Second, // retries are due at once
+		MaxBackoff:  -time.Second,
+	})
+
+	job, err := p.EraseUser(context.Background(), actor, createUser(t, store), "request")
+	require.NoError(t, err)
+
+	_, err = w.Flush(context.Background())
+	require.NoError(t, err)
+	got, err := p.DataJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataJobPending, got.Status)
+	assert.Equal(t, "unavailable", got.LastError)
+
+	_, err = w.Flush(context.Background())
+	require.NoError(t, err)
+	got, err = p.DataJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataJobFailed, got.Status, "jobs fail after max attempts")
+	assert.Equal(t, 2, got.Attempts)
+}
+
+func TestDataJob_NotFound(t *testing.T) {
+	p, _, _ := newPrivacy(t, privacy.WorkerOptions{})
+
+	_, err := p.DataJob(context.Background(), 42)
+	require.ErrorIs(t, err, privacy.ErrJobNotFound)
+
+	_, err = p.EraseUser(context.Background(), actor, 42, "request")
+	require.ErrorIs(t, err, privacy.ErrUserNotFound)
+}
+
+func newPrivacy(t *testing.T, opts privacy.WorkerOptions) (*privacy.Privacy, *privacy.Worker, *memory.Storage) {
+	t.Helper()
+
+	store := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return privacy.New(log, store), privacy.NewWorker(log, store, store, audit.New(log, store), opts), store
+}
+
+func createUser(t *testing.T, store *memory.Storage) int64 {
+	t.Helper()
+
+	id, err := store.CreateUser(context.Background(), models.UserIdentifiers{Email: "ann@example.com"}, []byte("hash"))
+	require.NoError(t, err)
+
+	return id
+}
+
+// failingStore fails the first erasures.
+type failingStore struct {
+	*memory.Storage
+	failures int
+}
+
+func (s *failingStore) EraseUser(ctx context.Context, userID int64) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+
+	return s.Storage.EraseUser(ctx, userID)
+}
@@ -0,0 +1,308 @@
+package privacy
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// auditPageSize is the number of audit events read at once into an archive.
+const auditPageSize = 500
+
+// WorkerStore claims data jobs, records their outcome and reads and erases the data of users.
+type WorkerStore interface {
+	ClaimDataJobs(ctx context.Context, limit int, lease time.Duration) ([]models.DataJob, error)
+	CompleteDataJob(ctx context.Context, id int64, archive []byte, finishedAt, expiresAt time.Time) error
+	RetryDataJob(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+	FailDataJob(ctx context.Context, id int64, lastErr string, finishedAt time.Time) error
+	PurgeDataJobArchives(ctx context.Context, before time.Time) (int64, error)
+	UserData(ctx context.Context, userID int64) (models.UserData, error)
+	EraseUser(ctx context.Context, userID int64) error
+}
+
+// AuditLog reads the audit events about a user into its archive.
+type AuditLog interface {
+	AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+// Auditor records audit events. Recording never fails the audited operation.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+// WorkerOptions tune the worker.
+type WorkerOptions struct {
+	PollInterval time.Duration // how often due jobs are looked up
+	BatchSize    int           // jobs claimed at once
+	Lease        time.Duration // how long claimed jobs are hidden from other workers
+	MaxAttempts  int           // attempts before a job fails
+	MinBackoff   time.Duration // delay before the first retry, doubled for every next one
+	MaxBackoff   time.Duration // longest delay between retries
+	ArchiveTTL   time.Duration // how long the archive of an export is kept
+}
+
+// Worker runs data jobs.
+type Worker struct {
+	log      *slog.Logger
+	store    WorkerStore
+	auditLog AuditLog
+	auditor  Auditor
+	opts     WorkerOptions
+	now      func() time.Time
+}
+
+// NewWorker returns a worker running the data jobs in the store.
+func NewWorker(
+	log *slog.Logger,
+	store WorkerStore,
+	auditLog AuditLog,
+	auditor Auditor,
+	opts WorkerOptions,
+) *Worker {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+
+	return &Worker{
+		log:      log,
+		store:    store,
+		auditLog: auditLog,
+		auditor:  auditor,
+		opts:     opts,
+		now:      time.Now,
+	}
+}
+
+// Run runs due jobs and purges expired archives until the context is canceled.
+func (w *Worker) Run(ctx context.Context) {
+	const op = "privacy.Run"
+
+	log := w.log.With(slog.String("op", op))
+
+	log.Info("data job worker is running")
+
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := w.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error("failed to run data jobs", slog.String("err", err.Error()))
+		}
+
+		// A full batch means more jobs may be due already.
+		if err == nil && n == w.opts.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		if err := w.Purge(ctx); err != nil && ctx.Err() == nil {
+			log.Error("failed to purge data archives", slog.String("err", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush claims one batch of due jobs, runs them and returns the number of claimed jobs.
+// Failed jobs are retried with exponential back-off until they run out of attempts.
+func (w *Worker) Flush(ctx context.Context) (int, error) {
+	const op = "privacy.Flush"
+
+	jobs, err := w.store.ClaimDataJobs(ctx, w.opts.BatchSize, w.opts.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, job := range jobs {
+		if err := w.run(ctx, job); err != nil {
+			return len(jobs), fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return len(jobs), nil
+}
+
+// Purge removes the archives of exports that expired.
+func (w *Worker) Purge(ctx context.Context) error {
+	const op = "privacy.Purge"
+
+	n, err := w.store.PurgeDataJobArchives(ctx, w.now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n > 0 {
+		w.log.Info("expired data archives purged", slog.String("op", op), slog.Int64("count", n))
+	}
+
+	return nil
+}
+
+// run runs the job and records its outcome.
+func (w *Worker) run(ctx context.Context, job models.DataJob) error {
+	log := w.log.With(
+		slog.Int64("job_id", job.ID),
+		slog.String("kind", string(job.Kind)),
+		slog.Int64("uid", job.UserID),
+	)
+
+	var (
+		archive []byte
+		err     error
+	)
+	switch job.Kind {
+	case models.DataJobExport:
+		archive, err = w.export(ctx, job.UserID)
+	case models.DataJobErase:
+		err = w.erase(ctx, job.UserID)
+	default:
+		err = fmt.Errorf("unknown data job kind %q", job.Kind)
+	}
+
+	now := w.now()
+
+	if err == nil {
+		var expiresAt time.Time
+		if archive != nil {
+			expiresAt = now.Add(w.opts.ArchiveTTL)
+		}
+		if err := w.store.CompleteDataJob(ctx, job.ID, archive, now, expiresAt); err != nil {
+			log.Error("failed to complete data job", slog.String("err", err.Error()))
+			return err
+		}
+
+		log.Info("data job succeeded")
+		w.audit(ctx, job)
+
+		return nil
+	}
+
+	// Jobs of users erased meanwhile have nothing left to export.
+	n := job.Attempts + 1
+	if n >= w.opts.MaxAttempts || errors.Is(err, storage.ErrUserNotFound) {
+		log.Warn("data job failed", slog.Int("attempts", n), slog.String("err", err.Error()))
+		if err := w.store.FailDataJob(ctx, job.ID, err.Error(), now); err != nil {
+			log.Error("failed to record data job failure", slog.String("err", err.Error()))
+			return err
+		}
+		return nil
+	}
+
+	next := now.Add(w.backoff(n))
+	log.Info("data job attempt failed",
+		slog.Int("attempts", n),
+		slog.Time("next_attempt_at", next),
+		slog.String("err", err.Error()),
+	)
+	if err := w.store.RetryDataJob(ctx, job.ID, err.Error(), next); err != nil {
+		log.Error("failed to record data job failure", slog.String("err", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// export returns the JSON archive of the personal data of the user.
+func (w *Worker) export(ctx context.Context, userID int64) ([]byte, error) {
+	data, err := w.store.UserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := w.auditEvents(ctx, models.Principal{Type: models.PrincipalUser, ID: userID}.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(NewArchive(data, events, w.now()))
+}
+
+// erase erases the personal data of the user. Users erased by an earlier attempt are done.
+func (w *Worker) erase(ctx context.Context, userID int64) error {
+	if err := w.store.EraseUser(ctx, userID); err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// auditEvents returns the audit events the principal caused or is the subject of, oldest first.
+func (w *Worker) auditEvents(ctx context.Context, principal string) ([]models.AuditEvent, error) {
+	byID := make(map[int64]models.AuditEvent)
+
+	for _, filter := range []models.AuditFilter{{Actor: principal}, {Subject: principal}} {
+		var beforeID int64
+		for {
+			events, err := w.auditLog.AuditEvents(ctx, filter, beforeID, auditPageSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range events {
+				byID[e.ID] = e
+			}
+			if len(events) < auditPageSize {
+				break
+			}
+			beforeID = events[len(events)-1].ID
+		}
+	}
+
+	events := make([]models.AuditEvent, 0, len(byID))
+	for _, e := range byID {
+		events = append(events, e)
+	}
+	slices.SortFunc(events, func(a, b models.AuditEvent) int { return cmp.Compare(a.ID, b.ID) })
+
+	return events, nil
+}
+
+// audit records the finished job. The subject of an erasure no longer exists,
+// it is named by id only.
+func (w *Worker) audit(ctx context.Context, job models.DataJob) {
+	event := models.AuditEvent{
+		Type:    models.EventUserDataExported,
+		Actor:   job.Actor,
+		Subject: models.Principal{Type: models.PrincipalUser, ID: job.UserID}.String(),
+		Details: map[string]string{"job_id": strconv.FormatInt(job.ID, 10)},
+	}
+	if job.Kind == models.DataJobErase {
+		event.Type = models.EventUserErased
+		event.Details["reason"] = job.Reason
+	}
+
+	w.auditor.Record(ctx, event)
+}
+
+// backoff returns the delay after the failed attempt, attempts count from 1.
+func (w *Worker) backoff(attempt int) time.Duration {
+	delay := w.opts.MinBackoff
+	for i := 1; i < attempt && delay < w.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, w.opts.MaxBackoff)
+}
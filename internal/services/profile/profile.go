@@ -0,0 +1,489 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// Limits of profile values.
+const (
+	maxNameLength = 256
+	maxURLLength  = 2048
+	maxKeyLength  = 128
+)
+
+type Profiles struct {
+	log      *slog.Logger
+	users    UserStore
+	accounts ServiceAccountProvider
+	auditor  Auditor
+	opts     Options
+}
+
+// UserStore reads users and updates their profiles.
+type UserStore interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	UpdateProfile(ctx context.Context, userID int64, update models.ProfileUpdate) (models.Profile, error)
+	Membership(ctx context.Context, orgID, userID int64) (models.Membership, error)
+}
+
+// ServiceAccountProvider resolves the app owning a service account.
+type ServiceAccountProvider interface {
+	ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error)
+}
+
+// Auditor records audit events. Recording never fails the audited operation.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+// Options limit the custom attributes.
+type Options struct {
+	// MaxNamespaceSize is the size of the JSON encoded attributes of a namespace, in bytes.
+	MaxNamespaceSize int
+}
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	// ErrForbidden is returned to callers that may not read or update the fields of the profile.
+	ErrForbidden     = errors.New("not allowed to access the profile")
+	ErrInvalidPath   = errors.New("invalid field mask path")
+	ErrInvalidValue  = errors.New("invalid profile value")
+	ErrMaskRequired  = errors.New("update mask is required")
+	ErrNamespaceSize = errors.New("attributes of the namespace are too large")
+)
+
+// New returns a new instance of the Profiles service.
+func New(
+	log *slog.Logger,
+	opts Options,
+	users UserStore,
+	accounts ServiceAccountProvider,
+	auditor Auditor,
+) *Profiles {
+	if opts.MaxNamespaceSize <= 0 {
+		opts.MaxNamespaceSize = 16 << 10
+	}
+
+	return &Profiles{
+		log:      log,
+		users:    users,
+		accounts: accounts,
+		auditor:  auditor,
+		opts:     opts,
+	}
+}
+
+// Profile returns the parts of the profile of the user named by the field mask paths,
+// or every part the caller may read when there are none.
+func (p *Profiles) Profile(
+	ctx context.Context,
+	caller models.Principal,
+	userID int64,
+	paths []string,
+) (models.Profile, error) {
+	const op = "profile.Profile"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.String("principal", caller.String()),
+		slog.Int64("uid", userID),
+	)
+
+	mask, err := ParsePaths(paths)
+	if err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	acc, err := p.access(ctx, log, caller, userID)
+	if err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !mask.empty() && !acc.canRead(mask) {
+		log.Warn("caller may not read the fields", slog.Any("paths", paths))
+		return models.Profile{}, fmt.Errorf("%s: %w", op, ErrForbidden)
+	}
+
+	user, err := p.user(ctx, log, userID)
+	if err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return acc.filter(user.Profile, mask), nil
+}
+
+// UpdateProfile sets the parts of the profile of the user named by the field mask paths
+// to their values in the profile and returns the profile as far as the caller may read it.
+// Attributes named by the paths that the profile has no value for are removed.
+func (p *Profiles) UpdateProfile(
+	ctx context.Context,
+	caller models.Principal,
+	userID int64,
+	paths []string,
+	profile models.Profile,
+) (models.Profile, error) {
+	const op = "profile.UpdateProfile"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.String("principal", caller.String()),
+		slog.Int64("uid", userID),
+	)
+
+	if len(paths) == 0 {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, ErrMaskRequired)
+	}
+
+	mask, err := ParsePaths(paths)
+	if err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	acc, err := p.access(ctx, log, caller, userID)
+	if err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := p.user(ctx, log, userID)
+	if err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	update, err := acc.update(mask, user.Profile, profile)
+	if err != nil {
+		log.Warn("caller may not update the fields", slog.Any("paths", paths))
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := p.validate(&update, user.Profile); err != nil {
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(update.Fields) == 0 && len(update.Attributes) == 0 {
+		return acc.filter(user.Profile, Mask{}), nil
+	}
+
+	updated, err := p.users.UpdateProfile(ctx, userID, update)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Profile{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to update profile", slog.String("err", err.Error()))
+		return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	p.auditor.Record(ctx, models.AuditEvent{
+		Type:    models.EventProfileUpdated,
+		Actor:   caller.String(),
+		Subject: models.Principal{Type: models.PrincipalUser, ID: userID}.String(),
+		Outcome: models.OutcomeSuccess,
+		Details: map[string]string{"fields": strings.Join(update.Paths(), ",")},
+	})
+
+	log.Info("profile updated")
+
+	return acc.filter(updated, Mask{}), nil
+}
+
+func (p *Profiles) user(ctx context.Context, log *slog.Logger, id int64) (models.User, error) {
+	user, err := p.users.UserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, ErrUserNotFound
+		}
+		log.Error("failed to get user", slog.String("err", err.Error()))
+		return models.User{}, err
+	}
+
+	// Deleted users are reported as missing.
+	if user.Status == models.UserStatusDeleted {
+		return models.User{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// access returns what the caller may do with the profile of the user.
+func (p *Profiles) access(
+	ctx context.Context,
+	log *slog.Logger,
+	caller models.Principal,
+	userID int64,
+) (access, error) {
+	var acc access
+
+	if caller.IsUser() && caller.ID == userID {
+		acc.readFields, acc.writeFields, acc.readAll = true, true, true
+	}
+	readUsers := caller.HasPermission(models.PermissionUsersRead)
+	writeUsers := caller.HasPermission(models.PermissionUsersWrite)
+	if (readUsers || writeUsers) && caller.OrgID != 0 {
+		// Within an organization the permissions reach its members only.
+		_, err := p.users.Membership(ctx, caller.OrgID, userID)
+		if err != nil && !errors.Is(err, storage.ErrMemberNotFound) {
+			log.Error("failed to get membership", slog.String("err", err.Error()))
+			return access{}, err
+		}
+		if err != nil {
+			readUsers, writeUsers = false, false
+		}
+	}
+	if readUsers {
+		acc.readFields, acc.readAll = true, true
+	}
+	if writeUsers {
+		acc.readFields, acc.writeFields, acc.readAll, acc.writeAll = true, true, true, true
+	}
+
+	if !caller.IsUser() {
+		sa, err := p.accounts.ServiceAccount(ctx, caller.ID)
+		if err != nil && !errors.Is(err, storage.ErrServiceAccountNotFound) {
+			log.Error("failed to get service account", slog.String("err", err.Error()))
+			return access{}, err
+		}
+		if err == nil && sa.Owner.Kind == models.OwnerApp {
+			acc.readFields = true
+			acc.app = sa.Owner.Name
+		}
+	}
+
+	if !acc.readFields {
+		log.Warn("caller may not access the profile")
+		return access{}, ErrForbidden
+	}
+
+	return acc, nil
+}
+
+// validate checks the values of the update and canonicalizes the locale.
+func (p *Profiles) validate(update *models.ProfileUpdate, current models.Profile) error {
+	for _, field := range update.Fields {
+		value := update.Profile.Field(field)
+		if value == "" {
+			continue
+		}
+
+		switch field {
+		case models.ProfileDisplayName, models.ProfileGivenName, models.ProfileFamilyName:
+			if !utf8.ValidString(value) || utf8.RuneCountInString(value) > maxNameLength {
+				return fmt.Errorf("%w: %s must be valid UTF-8 of at most %d characters", ErrInvalidValue, field, maxNameLength)
+			}
+		case models.ProfileLocale:
+			tag, err := language.Parse(value)
+			if err != nil {
+				return fmt.Errorf("%w: locale must be a BCP 47 language tag", ErrInvalidValue)
+			}
+			update.Profile.Locale = tag.String()
+		case models.ProfileTimezone:
+			if _, err := time.LoadLocation(value); err != nil || value == "Local" {
+				return fmt.Errorf("%w: timezone must be an IANA time zone", ErrInvalidValue)
+			}
+		case models.ProfileAvatarURL:
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(value) > maxURLLength {
+				return fmt.Errorf("%w: avatar_url must be an absolute http or https url", ErrInvalidValue)
+			}
+		}
+	}
+
+	if len(update.Attributes) == 0 {
+		return nil
+	}
+
+	// Sizes are checked against the current profile, the storage applies the update to the latest one.
+	updated := current.Apply(*update)
+	for _, path := range update.Attributes {
+		raw, err := json.Marshal(updated.Attributes[path.Namespace])
+		if err != nil {
+			return fmt.Errorf("%w: attributes must be JSON values", ErrInvalidValue)
+		}
+		if len(raw) > p.opts.MaxNamespaceSize {
+			return fmt.Errorf("%w: %s", ErrNamespaceSize, path.Namespace)
+		}
+	}
+
+	return nil
+}
+
+// Mask is a parsed field mask.
+type Mask struct {
+	Fields []string // standard fields
+	// AllAttributes is set by the attributes path, which names every namespace.
+	AllAttributes bool
+	Attributes    []models.AttributePath
+}
+
+func (m Mask) empty() bool {
+	return len(m.Fields) == 0 && !m.AllAttributes && len(m.Attributes) == 0
+}
+
+// ParsePaths parses field mask paths: the standard field names, "attributes",
+// "attributes.<namespace>" and "attributes.<namespace>.<key>".
+func ParsePaths(paths []string) (Mask, error) {
+	var mask Mask
+
+	for _, path := range paths {
+		if slices.Contains(models.ProfileFields, path) {
+			if !slices.Contains(mask.Fields, path) {
+				mask.Fields = append(mask.Fields, path)
+			}
+			continue
+		}
+
+		if path == models.ProfileAttributes {
+			mask.AllAttributes = true
+			continue
+		}
+
+		rest, ok := strings.CutPrefix(path, models.ProfileAttributes+".")
+		if !ok {
+			return Mask{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+
+		namespace, key, _ := strings.Cut(rest, ".")
+		if namespace == "" || strings.Contains(key, ".") || len(key) > maxKeyLength {
+			return Mask{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		if rest != namespace && key == "" {
+			return Mask{}, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+
+		attr := models.AttributePath{Namespace: namespace, Key: key}
+		if !slices.Contains(mask.Attributes, attr) {
+			mask.Attributes = append(mask.Attributes, attr)
+		}
+	}
+
+	return mask, nil
+}
+
+// access is what a caller may do with a profile. Users read their own profile and update
+// its standard fields; service accounts of an app read the standard fields and the namespace
+// of the app, and update the namespace.
+type access struct {
+	readFields  bool
+	writeFields bool
+	readAll     bool   // every namespace is readable
+	writeAll    bool   // every namespace is writable
+	app         string // namespace of the app of the caller, readable and writable
+}
+
+func (a access) canReadNamespace(namespace string) bool {
+	return a.readAll || (a.app != "" && namespace == a.app)
+}
+
+func (a access) canWriteNamespace(namespace string) bool {
+	return a.writeAll || (a.app != "" && namespace == a.app)
+}
+
+// canRead reports whether the caller may read every part of the profile in the mask.
+// The attributes path names the namespaces the caller may read.
+func (a access) canRead(mask Mask) bool {
+	if len(mask.Fields) > 0 && !a.readFields {
+		return false
+	}
+
+	for _, path := range mask.Attributes {
+		if !a.canReadNamespace(path.Namespace) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filter returns the parts of the profile in the mask that the caller may read,
+// or every part it may read if the mask is empty.
+func (a access) filter(profile models.Profile, mask Mask) models.Profile {
+	var filtered models.Profile
+
+	fields := mask.Fields
+	if mask.empty() {
+		fields = models.ProfileFields
+	}
+	if a.readFields {
+		for _, field := range fields {
+			filtered.SetField(field, profile.Field(field))
+		}
+	}
+
+	for namespace, attrs := range profile.Attributes {
+		if !a.canReadNamespace(namespace) {
+			continue
+		}
+
+		whole := mask.empty() || mask.AllAttributes ||
+			slices.Contains(mask.Attributes, models.AttributePath{Namespace: namespace})
+
+		for key, value := range attrs {
+			if whole || slices.Contains(mask.Attributes, models.AttributePath{Namespace: namespace, Key: key}) {
+				if filtered.Attributes == nil {
+					filtered.Attributes = models.Attributes{}
+				}
+				if filtered.Attributes[namespace] == nil {
+					filtered.Attributes[namespace] = map[string]any{}
+				}
+				filtered.Attributes[namespace][key] = value
+			}
+		}
+	}
+
+	return filtered
+}
+
+// update returns the update of the parts of the current profile in the mask to their values
+// in the profile, or ErrForbidden if the caller may not update any of them.
+// The attributes path names the namespaces the caller may write that either profile has.
+func (a access) update(mask Mask, current, profile models.Profile) (models.ProfileUpdate, error) {
+	if len(mask.Fields) > 0 && !a.writeFields {
+		return models.ProfileUpdate{}, ErrForbidden
+	}
+
+	update := models.ProfileUpdate{
+		Fields:     mask.Fields,
+		Attributes: mask.Attributes,
+		Profile:    profile,
+	}
+
+	for _, path := range mask.Attributes {
+		if !a.canWriteNamespace(path.Namespace) {
+			return models.ProfileUpdate{}, ErrForbidden
+		}
+	}
+
+	if mask.AllAttributes {
+		if !a.writeAll && a.app == "" {
+			return models.ProfileUpdate{}, ErrForbidden
+		}
+
+		namespaces := slices.Collect(maps.Keys(current.Attributes))
+		namespaces = append(namespaces, slices.Collect(maps.Keys(profile.Attributes))...)
+		if a.app != "" {
+			namespaces = append(namespaces, a.app)
+		}
+		slices.Sort(namespaces)
+
+		for _, namespace := range slices.Compact(namespaces) {
+			path := models.AttributePath{Namespace: namespace}
+			if a.canWriteNamespace(namespace) && !slices.Contains(update.Attributes, path) {
+				update.Attributes = append(update.Attributes, path)
+			}
+		}
+	}
+
+	return update, nil
+}
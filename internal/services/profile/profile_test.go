@@ -0,0 +1,219 @@
+package profile_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/profile"
+	"grpc-service-ref/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateProfile_User(t *testing.T) {
+	p, store := newProfiles(t)
+	uid := createUser(t, store)
+	user := models.Principal{Type: models.PrincipalUser, ID: uid}
+
+	updated, err := p.UpdateProfile(context.Background(), user, uid,
+		[]string{"display_name", "locale", "timezone"},
+		models.Profile{DisplayName: "Ann", Locale: "en-us", Timezone: "UTC", GivenName: "not in the mask"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, models.Profile{DisplayName: "Ann", Locale: "en-US", Timezone: "UTC"}, updated,
+		"the locale is canonicalized")
+
+	_, err = p.UpdateProfile(context.Background(), user, uid,
+		[]string{"attributes.shop.plan"}, models.Profile{Attributes: models.Attributes{"shop": {"plan": "pro"}}})
+	require.ErrorIs(t, err, profile.ErrForbidden, "attributes belong to apps")
+
+	other := createUser(t, store)
+	_, err = p.Profile(context.Background(), user, other, nil)
+	require.ErrorIs(t, err, profile.ErrForbidden)
+}
+
+func TestUpdateProfile_App(t *testing.T) {
+	p, store := newProfiles(t)
+	uid := createUser(t, store)
+
+	shop := serviceAccount(t, store, "shop")
+	blog := serviceAccount(t, store, "blog")
+
+	_, err := p.UpdateProfile(context.Background(), shop, uid,
+		[]string{"attributes.shop"}, models.Profile{Attributes: models.Attributes{"shop": {"plan": "pro", "seats": 3.0}}})
+	require.NoError(t, err)
+
+	_, err = p.UpdateProfile(context.Background(), blog, uid,
+		[]string{"attributes"}, models.Profile{Attributes: models.Attributes{"blog": {"theme": "dark"}}})
+	require.NoError(t, err, "the attributes path names the namespace of the app")
+
+	_, err = p.UpdateProfile(context.Background(), blog, uid,
+		[]string{"attributes.shop.plan"}, models.Profile{})
+	require.ErrorIs(t, err, profile.ErrForbidden)
+
+	_, err = p.UpdateProfile(context.Background(), blog, uid,
+		[]string{"display_name"}, models.Profile{DisplayName: "Bob"})
+	require.ErrorIs(t, err, profile.ErrForbidden)
+
+	got, err := p.Profile(context.Background(), shop, uid, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.Attributes{"shop": {"plan": "pro", "seats": 3.0}}, got.Attributes,
+		"apps read their namespace only")
+
+	got, err = p.Profile(context.Background(), shop, uid, []string{"attributes.shop.seats"})
+	require.NoError(t, err)
+	assert.Equal(t, models.Attributes{"shop": {"seats": 3.0}}, got.Attributes)
+
+	_, err = p.Profile(context.Background(), shop, uid, []string{"attributes.blog"})
+	require.ErrorIs(t, err, profile.ErrForbidden)
+
+	got, err = p.Profile(context.Background(), models.Principal{Type: models.PrincipalUser, ID: uid}, uid, []string{"attributes"})
+	require.NoError(t, err)
+	assert.Equal(t, models.Attributes{"shop": {"plan": "pro", "seats": 3.0}, "blog": {"theme": "dark"}}, got.Attributes,
+		"users read every namespace of their profile")
+}
+
+func TestProfile_Organization(t *testing.T) {
+	ctx := context.Background()
+	p, store := newProfiles(t)
+	adminID := createUser(t, store)
+	orgID, err := store.CreateOrganization(ctx,
+		models.Organization{Slug: "acme", Name: "Acme", CreatedAt: time.Now()},
+		models.Membership{UserID: adminID, Roles: []string{models.OrgRoleAdmin}, CreatedAt: time.Now()},
+	)
+	require.NoError(t, err)
+	orgAdmin := models.Principal{Type: models.PrincipalUser, ID: adminID, OrgID: orgID, OrgRoles: []string{models.OrgRoleAdmin}}
+
+	outsider := createUser(t, store)
+	_, err = p.Profile(ctx, orgAdmin, outsider, nil)
+	require.ErrorIs(t, err, profile.ErrForbidden, "admins of an organization only read its members")
+
+	_, err = store.CreateOrganization(ctx,
+		models.Organization{Slug: "other", Name: "Other", CreatedAt: time.Now()},
+		models.Membership{UserID: outsider, Roles: []string{models.OrgRoleMember}, CreatedAt: time.Now()},
+	)
+	require.NoError(t, err)
+	_, err = p.Profile(ctx, orgAdmin, outsider, nil)
+	require.ErrorIs(t, err, profile.ErrForbidden, "members of other organizations are out of reach")
+
+	member := createUser(t, store)
+	_, err = p.UpdateProfile(ctx, models.Principal{Type: models.PrincipalUser, ID: member}, member,
+		[]string{"display_name"}, models.Profile{DisplayName: "Ann"})
+	require.NoError(t, err)
+	invitationID, err := store.SaveOrgInvitation(ctx, models.OrgInvitation{
+		OrgID:     orgID,
+		Roles:     []string{models.OrgRoleMember},
+		Hash:      []byte("hash"),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	_, err = store.AcceptOrgInvitation(ctx, invitationID, member, time.Now())
+	require.NoError(t, err)
+
+	read, err := p.Profile(ctx, orgAdmin, member, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Ann", read.DisplayName)
+	_, err = p.UpdateProfile(ctx, orgAdmin, member, []string{"display_name"}, models.Profile{DisplayName: "Bob"})
+	require.ErrorIs(t, err, profile.ErrForbidden, "admins of an organization only read its members")
+}
+
+func TestUpdateProfile_FailCases(t *testing.T) {
+	p, store := newProfiles(t)
+	uid := createUser(t, store)
+	admin := models.Principal{Type: models.PrincipalUser, ID: createUser(t, store), Roles: []string{models.RoleAdmin}}
+
+	tests := []struct {
+		name        string
+		paths       []string
+		profile     models.Profile
+		expectedErr error
+	}{
+		{
+			name:        "No mask",
+			expectedErr: profile.ErrMaskRequired,
+		},
+		{
+			name:        "Unknown field",
+			paths:       []string{"nickname"},
+			expectedErr: profile.ErrInvalidPath,
+		},
+		{
+			name:        "Nested key",
+			paths:       []string{"attributes.shop.plan.tier"},
+			expectedErr: profile.ErrInvalidPath,
+		},
+		{
+			name:        "Invalid locale",
+			paths:       []string{"locale"},
+			profile:     models.Profile{Locale: "not a locale"},
+			expectedErr: profile.ErrInvalidValue,
+		},
+		{
+			name:        "Invalid timezone",
+			paths:       []string{"timezone"},
+			profile:     models.Profile{Timezone: "Mars/Olympus"},
+			expectedErr: profile.ErrInvalidValue,
+		},
+		{
+			name:        "Invalid avatar",
+			paths:       []string{"avatar_url"},
+			profile:     models.Profile{AvatarURL: "javascript:alert(1)"},
+			expectedErr: profile.ErrInvalidValue,
+		},
+		{
+			name:        "Namespace too large",
+			paths:       []string{"attributes.shop"},
+			profile:     models.Profile{Attributes: models.Attributes{"shop": {"blob": string(make([]byte, 100))}}},
+			expectedErr: profile.ErrNamespaceSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.UpdateProfile(context.Background(), admin, uid, tt.paths, tt.profile)
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func newProfiles(t *testing.T) (*profile.Profiles, *memory.Storage) {
+	t.Helper()
+
+	store := memory.New()
+	p := profile.New(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		profile.Options{MaxNamespaceSize: 64}, store, store, nopAuditor{})
+
+	return p, store
+}
+
+func createUser(t *testing.T, store *memory.Storage) int64 {
+	t.Helper()
+
+	id, err := store.CreateUser(context.Background(), models.UserIdentifiers{}, []byte("hash"))
+	require.NoError(t, err)
+
+	return id
+}
+
+func serviceAccount(t *testing.T, store *memory.Storage, app string) models.Principal {
+	t.Helper()
+
+	id, err := store.SaveServiceAccount(context.Background(), models.ServiceAccount{
+		Name:     app + "-backend",
+		Owner:    models.Owner{Kind: models.OwnerApp, Name: app},
+		ClientID: app,
+	})
+	require.NoError(t, err)
+
+	return models.Principal{Type: models.PrincipalServiceAccount, ID: id}
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, models.AuditEvent) {}
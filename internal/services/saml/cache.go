@@ -0,0 +1,72 @@
+package saml
+
+import (
+	"sync"
+	"time"
+)
+
+// maxEntries bounds the memory used by outstanding requests and seen assertions.
+const maxEntries = 100_000
+
+// expiringSet is a set of keys that are forgotten once they expire.
+// It tracks issued AuthnRequest IDs and the IDs of consumed assertions.
+type expiringSet struct {
+	mu    sync.Mutex
+	items map[string]time.Time
+}
+
+func newExpiringSet() *expiringSet {
+	return &expiringSet{items: make(map[string]time.Time)}
+}
+
+// Add stores key until the given time.
+// It returns false if the key is already present and not expired.
+func (s *expiringSet) Add(key string, until time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if exp, ok := s.items[key]; ok && exp.After(now) {
+		return false
+	}
+
+	if len(s.items) >= maxEntries {
+		s.prune(now)
+	}
+
+	s.items[key] = until
+
+	return true
+}
+
+// Remove deletes key from the set.
+func (s *expiringSet) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, key)
+}
+
+// Keys returns all keys that are not expired.
+func (s *expiringSet) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (s *expiringSet) prune(now time.Time) {
+	for key, exp := range s.items {
+		if !exp.After(now) {
+			delete(s.items, key)
+		}
+	}
+}
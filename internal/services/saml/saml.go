@@ -0,0 +1,336 @@
+package saml
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/emailaddr"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	gosaml "github.com/crewjam/saml"
+)
+
+// requestTTL is how long an issued AuthnRequest may be answered by the IdP.
+const requestTTL = 5 * time.Minute
+
+type SAML struct {
+	log         *slog.Logger
+	sp          *gosaml.ServiceProvider
+	usrSaver    UserSaver
+	usrProvider UserProvider
+	identities  IdentityStore
+	requests    *expiringSet
+	assertions  *expiringSet
+	mapping     AttributeMapping
+	allowCreate bool
+	emails      emailaddr.Normalizer
+	tokens      TokenIssuer
+	admission   Admission
+}
+
+type UserSaver interface {
+	SaveUser(
+		ctx context.Context,
+		email string,
+		passHash []byte,
+	) (uid int64, err error)
+}
+
+type UserProvider interface {
+	User(ctx context.Context, email string) (models.User, error)
+}
+
+// TokenIssuer starts a session for the user, it is the same one Auth.Login uses.
+type TokenIssuer interface {
+	IssueToken(ctx context.Context, user models.User, client models.ClientInfo) (string, error)
+}
+
+// Admission checks that the registration mode lets a user register, it is the same one
+// Auth.RegisterNewUser uses. Users provisioned on their first login have no invite code.
+type Admission interface {
+	AdmitUser(ctx context.Context, email string) error
+}
+
+type IdentityStore interface {
+	UserByIdentity(ctx context.Context, provider, subject string) (models.User, error)
+	SaveIdentity(ctx context.Context, userID int64, provider, subject string) error
+}
+
+// AttributeMapping names the assertion attributes that carry user fields.
+type AttributeMapping struct {
+	Email string
+}
+
+// Options configures the service provider.
+type Options struct {
+	EntityID          string
+	RootURL           *url.URL
+	Key               *rsa.PrivateKey
+	Certificate       *x509.Certificate
+	IDPMetadata       *gosaml.EntityDescriptor
+	AllowIDPInitiated bool
+	AllowCreate       bool
+	Mapping           AttributeMapping
+	// Emails normalizes the email addresses of assertions.
+	Emails emailaddr.Normalizer
+}
+
+var (
+	ErrInvalidAssertion   = errors.New("invalid assertion")
+	ErrAssertionReplayed  = errors.New("assertion already used")
+	ErrUserNotProvisioned = errors.New("user is not provisioned")
+)
+
+// New returns a new instance of the SAML service provider.
+func New(
+	log *slog.Logger,
+	opts Options,
+	userSaver UserSaver,
+	userProvider UserProvider,
+	identities IdentityStore,
+	tokens TokenIssuer,
+	admission Admission,
+) *SAML {
+	metadataURL := opts.RootURL.ResolveReference(&url.URL{Path: "saml/metadata"})
+	acsURL := opts.RootURL.ResolveReference(&url.URL{Path: "saml/acs"})
+
+	sp := &gosaml.ServiceProvider{
+		EntityID:          opts.EntityID,
+		Key:               opts.Key,
+		Certificate:       opts.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       opts.IDPMetadata,
+		AuthnNameIDFormat: gosaml.PersistentNameIDFormat,
+		AllowIDPInitiated: opts.AllowIDPInitiated,
+	}
+
+	mapping := opts.Mapping
+	if mapping.Email == "" {
+		mapping.Email = "email"
+	}
+
+	return &SAML{
+		log:         log,
+		sp:          sp,
+		usrSaver:    userSaver,
+		usrProvider: userProvider,
+		identities:  identities,
+		requests:    newExpiringSet(),
+		assertions:  newExpiringSet(),
+		mapping:     mapping,
+		allowCreate: opts.AllowCreate,
+		emails:      opts.Emails,
+		tokens:      tokens,
+		admission:   admission,
+	}
+}
+
+// Metadata returns the service provider metadata document to be registered at the IdP.
+func (s *SAML) Metadata() ([]byte, error) {
+	const op = "saml.Metadata"
+
+	buf, err := xml.MarshalIndent(s.sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return buf, nil
+}
+
+// AuthnRequestURL creates a new AuthnRequest and returns the IdP URL
+// the user agent should be redirected to.
+func (s *SAML) AuthnRequestURL(relayState string) (string, error) {
+	const op = "saml.AuthnRequestURL"
+
+	req, err := s.sp.MakeAuthenticationRequest(
+		s.sp.GetSSOBindingLocation(gosaml.HTTPRedirectBinding),
+		gosaml.HTTPRedirectBinding,
+		gosaml.HTTPPostBinding,
+	)
+	if err != nil {
+		s.log.Error("failed to make authn request", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	redirectURL, err := req.Redirect(relayState, s.sp)
+	if err != nil {
+		s.log.Error("failed to make authn request redirect", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.requests.Add(req.ID, time.Now().Add(requestTTL))
+
+	return redirectURL.String(), nil
+}
+
+// Login validates the SAML response posted by the IdP and returns a token for the user
+// the assertion is issued for.
+//
+// The assertion subject is resolved through a linked identity first. Otherwise the user
+// with the mapped email is linked to it, or registered if AllowCreate is set.
+func (s *SAML) Login(ctx context.Context, samlResponse string, client models.ClientInfo) (string, error) {
+	const op = "saml.Login"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("idp", s.sp.IDPMetadata.EntityID),
+	)
+
+	log.Info("attempting to login user with saml assertion")
+
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		log.Warn("failed to decode saml response", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidAssertion)
+	}
+
+	assertion, err := s.sp.ParseXMLResponse(raw, s.requests.Keys())
+	if err != nil {
+		var ire *gosaml.InvalidResponseError
+		if errors.As(err, &ire) {
+			err = ire.PrivateErr
+		}
+		log.Warn("invalid saml response", slog.String("err", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidAssertion)
+	}
+
+	if !s.assertions.Add(assertion.ID, assertionExpiry(assertion)) {
+		log.Warn("saml assertion replayed", slog.String("assertion_id", assertion.ID))
+		return "", fmt.Errorf("%s: %w", op, ErrAssertionReplayed)
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		log.Warn("saml assertion has no subject")
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidAssertion)
+	}
+
+	for _, sc := range assertion.Subject.SubjectConfirmations {
+		if sc.SubjectConfirmationData != nil {
+			s.requests.Remove(sc.SubjectConfirmationData.InResponseTo)
+		}
+	}
+
+	user, err := s.resolveUser(ctx, log, assertion)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in successfully", slog.Int64("uid", user.ID))
+
+	token, err := s.tokens.IssueToken(ctx, user, client)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+func (s *SAML) resolveUser(ctx context.Context, log *slog.Logger, assertion *gosaml.Assertion) (models.User, error) {
+	provider := s.sp.IDPMetadata.EntityID
+	subject := assertion.Subject.NameID.Value
+
+	user, err := s.identities.UserByIdentity(ctx, provider, subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		log.Error("failed to get user by identity", slog.String("err", err.Error()))
+		return models.User{}, err
+	}
+
+	email := s.email(assertion)
+	if email == "" {
+		log.Warn("saml assertion has no email attribute", slog.String("attribute", s.mapping.Email))
+		return models.User{}, ErrInvalidAssertion
+	}
+	email, err = s.emails.Normalize(email)
+	if err != nil {
+		log.Warn("saml assertion has an invalid email", slog.String("err", err.Error()))
+		return models.User{}, ErrInvalidAssertion
+	}
+
+	user, err = s.usrProvider.User(ctx, email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to get user", slog.String("err", err.Error()))
+			return models.User{}, err
+		}
+		if !s.allowCreate {
+			log.Warn("user not found", slog.String("email", email))
+			return models.User{}, ErrUserNotProvisioned
+		}
+
+		if err := s.admission.AdmitUser(ctx, email); err != nil {
+			log.Warn("user not admitted", slog.String("err", err.Error()))
+			return models.User{}, ErrUserNotProvisioned
+		}
+
+		// SAML users have no password, an empty hash never matches in Auth.Login.
+		id, err := s.usrSaver.SaveUser(ctx, email, []byte{})
+		if err != nil {
+			log.Error("failed to save user", slog.String("err", err.Error()))
+			return models.User{}, err
+		}
+
+		log.Info("user registered", slog.Int64("uid", id))
+
+		user = models.User{ID: id, Email: email, Status: models.UserStatusActive}
+	}
+
+	if err := s.identities.SaveIdentity(ctx, user.ID, provider, subject); err != nil {
+		if errors.Is(err, storage.ErrIdentityExists) {
+			log.Warn("user is linked to another identity", slog.Int64("uid", user.ID))
+			return models.User{}, ErrUserNotProvisioned
+		}
+		log.Error("failed to link identity", slog.String("err", err.Error()))
+		return models.User{}, err
+	}
+
+	log.Info("identity linked", slog.Int64("uid", user.ID))
+
+	return user, nil
+}
+
+// email returns the user email from the mapped attribute,
+// falling back to the NameID when it is in email format.
+func (s *SAML) email(assertion *gosaml.Assertion) string {
+	for _, stmt := range assertion.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if attr.Name != s.mapping.Email && attr.FriendlyName != s.mapping.Email {
+				continue
+			}
+			for _, v := range attr.Values {
+				if v.Value != "" {
+					return strings.TrimSpace(v.Value)
+				}
+			}
+		}
+	}
+
+	if assertion.Subject.NameID.Format == string(gosaml.EmailAddressNameIDFormat) {
+		return strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+
+	return ""
+}
+
+// assertionExpiry returns the time after which the assertion can no longer be accepted,
+// so it does not have to be remembered by the replay cache any longer.
+func assertionExpiry(assertion *gosaml.Assertion) time.Time {
+	expiry := assertion.IssueInstant.Add(gosaml.MaxIssueDelay)
+	if assertion.Conditions != nil && assertion.Conditions.NotOnOrAfter.After(expiry) {
+		expiry = assertion.Conditions.NotOnOrAfter
+	}
+
+	return expiry.Add(gosaml.MaxClockSkew)
+}
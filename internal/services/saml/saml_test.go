@@ -0,0 +1,366 @@
+package saml_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"errors"
+	"grpc-service-ref/internal/domain/models"
+	ssojwt "grpc-service-ref/internal/lib/jwt"
+	"grpc-service-ref/internal/services/saml"
+	"grpc-service-ref/internal/storage"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/crewjam/saml/logger"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogin_HappyPath(t *testing.T) {
+	st := newMemStore()
+	idp, sp := setup(t, st, saml.Options{AllowCreate: true})
+
+	token, err := sp.Login(context.Background(), idpResponse(t, idp, sp, "subject-1", "bob@example.com"), models.ClientInfo{})
+	require.NoError(t, err)
+
+	uid := tokenUID(t, token)
+	user, err := st.User(context.Background(), "bob@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, uid)
+
+	// The second login resolves the user through the linked identity.
+	token, err = sp.Login(context.Background(), idpResponse(t, idp, sp, "subject-1", "renamed@example.com"), models.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, uid, tokenUID(t, token))
+}
+
+func TestLogin_LinksExistingUser(t *testing.T) {
+	st := newMemStore()
+	uid, err := st.SaveUser(context.Background(), "alice@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	idp, sp := setup(t, st, saml.Options{})
+
+	token, err := sp.Login(context.Background(), idpResponse(t, idp, sp, "subject-2", "alice@example.com"), models.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, uid, tokenUID(t, token))
+
+	linked, err := st.UserByIdentity(context.Background(), idp.Metadata().EntityID, "subject-2")
+	require.NoError(t, err)
+	assert.Equal(t, uid, linked.ID)
+}
+
+func TestLogin_FailCases(t *testing.T) {
+	st := newMemStore()
+	_, err := st.SaveUser(context.Background(), "carol@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	idp, sp := setup(t, st, saml.Options{})
+
+	t.Run("Replayed response", func(t *testing.T) {
+		resp := idpResponse(t, idp, sp, "subject-3", "carol@example.com")
+
+		_, err := sp.Login(context.Background(), resp, models.ClientInfo{})
+		require.NoError(t, err)
+
+		// The AuthnRequest has been answered already.
+		_, err = sp.Login(context.Background(), resp, models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrInvalidAssertion)
+	})
+
+	t.Run("Replayed assertion with IdP-initiated login", func(t *testing.T) {
+		idp, sp := setup(t, st, saml.Options{AllowIDPInitiated: true})
+
+		resp := idpResponse(t, idp, sp, "subject-7", "carol@example.com")
+
+		_, err := sp.Login(context.Background(), resp, models.ClientInfo{})
+		require.NoError(t, err)
+
+		_, err = sp.Login(context.Background(), resp, models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrAssertionReplayed)
+	})
+
+	t.Run("User not provisioned", func(t *testing.T) {
+		_, err := sp.Login(context.Background(), idpResponse(t, idp, sp, "subject-4", "nobody@example.com"), models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrUserNotProvisioned)
+	})
+
+	t.Run("User not admitted", func(t *testing.T) {
+		st := newMemStore()
+		st.rejected = errors.New("registration is closed")
+		idp, sp := setup(t, st, saml.Options{AllowCreate: true})
+
+		_, err := sp.Login(context.Background(), idpResponse(t, idp, sp, "subject-8", "dave@example.com"), models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrUserNotProvisioned)
+		_, err = st.User(context.Background(), "dave@example.com")
+		require.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("Untrusted IdP key", func(t *testing.T) {
+		rogue := newIDP(t)
+		rogue.MetadataURL = idp.MetadataURL
+		rogue.SSOURL = idp.SSOURL
+		rogue.ServiceProviderProvider = idp.ServiceProviderProvider
+
+		_, err := sp.Login(context.Background(), idpResponse(t, rogue, sp, "subject-5", "carol@example.com"), models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrInvalidAssertion)
+	})
+
+	t.Run("Unsolicited response", func(t *testing.T) {
+		other := newSP(t, idp, st, saml.Options{})
+
+		_, err := sp.Login(context.Background(), idpResponse(t, idp, other, "subject-6", "carol@example.com"), models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrInvalidAssertion)
+	})
+
+	t.Run("Malformed response", func(t *testing.T) {
+		_, err := sp.Login(context.Background(), "not base64!", models.ClientInfo{})
+		require.ErrorIs(t, err, saml.ErrInvalidAssertion)
+	})
+}
+
+// setup creates an IdP with locally generated keys and a service provider trusting it.
+func setup(t *testing.T, st *memStore, opts saml.Options) (*gosaml.IdentityProvider, *saml.SAML) {
+	t.Helper()
+
+	idp := newIDP(t)
+	sp := newSP(t, idp, st, opts)
+
+	return idp, sp
+}
+
+func newIDP(t *testing.T) *gosaml.IdentityProvider {
+	t.Helper()
+
+	key, cert := newKeyPair(t, "idp.example.com")
+
+	idp := &gosaml.IdentityProvider{
+		Key:         key,
+		Certificate: cert,
+		Logger:      logger.DefaultLogger,
+		MetadataURL: mustParseURL(t, "https://idp.example.com/metadata"),
+		SSOURL:      mustParseURL(t, "https://idp.example.com/sso"),
+	}
+	idp.ServiceProviderProvider = &spProvider{}
+
+	return idp
+}
+
+func newSP(t *testing.T, idp *gosaml.IdentityProvider, st *memStore, opts saml.Options) *saml.SAML {
+	t.Helper()
+
+	key, cert := newKeyPair(t, "sp.example.com")
+	rootURL := mustParseURL(t, "https://sp.example.com/")
+
+	opts.EntityID = "https://sp.example.com/saml/metadata"
+	opts.RootURL = &rootURL
+	opts.Key = key
+	opts.Certificate = cert
+	opts.IDPMetadata = idp.Metadata()
+	opts.Mapping = saml.AttributeMapping{Email: "email"}
+
+	sp := saml.New(slog.New(slog.NewTextHandler(io.Discard, nil)), opts, st, st, st, st, st)
+
+	raw, err := sp.Metadata()
+	require.NoError(t, err)
+
+	var md gosaml.EntityDescriptor
+	require.NoError(t, xml.Unmarshal(raw, &md))
+	idp.ServiceProviderProvider.(*spProvider).add(&md)
+
+	return sp
+}
+
+// idpResponse runs an SP-initiated flow against the IdP and returns the posted SAMLResponse.
+func idpResponse(t *testing.T, idp *gosaml.IdentityProvider, sp *saml.SAML, subject, email string) string {
+	t.Helper()
+
+	redirectURL, err := sp.AuthnRequestURL("")
+	require.NoError(t, err)
+
+	req, err := gosaml.NewIdpAuthnRequest(idp, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+	require.NoError(t, err)
+	require.NoError(t, req.Validate())
+
+	err = gosaml.DefaultAssertionMaker{}.MakeAssertion(req, &gosaml.Session{
+		ID:           subject + "-session",
+		CreateTime:   time.Now(),
+		ExpireTime:   time.Now().Add(time.Hour),
+		NameID:       subject,
+		NameIDFormat: string(gosaml.PersistentNameIDFormat),
+		CustomAttributes: []gosaml.Attribute{{
+			Name:   "email",
+			Values: []gosaml.AttributeValue{{Type: "xs:string", Value: email}},
+		}},
+	})
+	require.NoError(t, err)
+
+	form, err := req.PostBinding()
+	require.NoError(t, err)
+
+	return form.SAMLResponse
+}
+
+func newKeyPair(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return key, cert
+}
+
+func tokenUID(t *testing.T, token string) int64 {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+
+	return int64(claims["uid"].(float64))
+}
+
+func mustParseURL(t *testing.T, raw string) url.URL {
+	t.Helper()
+
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+
+	return *u
+}
+
+type spProvider struct {
+	mu  sync.Mutex
+	sps map[string]*gosaml.EntityDescriptor
+}
+
+func (p *spProvider) add(md *gosaml.EntityDescriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sps == nil {
+		p.sps = make(map[string]*gosaml.EntityDescriptor)
+	}
+	p.sps[md.EntityID] = md
+}
+
+func (p *spProvider) GetServiceProvider(_ *http.Request, id string) (*gosaml.EntityDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	md, ok := p.sps[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return md, nil
+}
+
+type memStore struct {
+	mu         sync.Mutex
+	users      map[string]models.User
+	identities map[string]int64
+	// rejected is the error AdmitUser returns.
+	rejected error
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		users:      make(map[string]models.User),
+		identities: make(map[string]int64),
+	}
+}
+
+func (s *memStore) SaveUser(_ context.Context, email string, passHash []byte) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[email]; ok {
+		return 0, storage.ErrUserExists
+	}
+
+	user := models.User{ID: int64(len(s.users) + 1), Email: email, PassHash: passHash}
+	s.users[email] = user
+
+	return user.ID, nil
+}
+
+func (s *memStore) User(_ context.Context, email string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[email]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (s *memStore) UserByIdentity(_ context.Context, provider, subject string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid, ok := s.identities[provider+"|"+subject]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	for _, user := range s.users {
+		if user.ID == uid {
+			return user, nil
+		}
+	}
+
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (s *memStore) AdmitUser(_ context.Context, _ string) error {
+	return s.rejected
+}
+
+func (s *memStore) IssueToken(_ context.Context, user models.User, _ models.ClientInfo) (string, error) {
+	return ssojwt.NewToken(models.UserPrincipal(user), "session", time.Hour, []byte("secret"))
+}
+
+func (s *memStore) SaveIdentity(_ context.Context, userID int64, provider, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.identities[provider+"|"+subject]; ok {
+		return storage.ErrIdentityExists
+	}
+	s.identities[provider+"|"+subject] = userID
+
+	return nil
+}
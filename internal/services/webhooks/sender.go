@@ -0,0 +1,254 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/outbox"
+	"grpc-service-ref/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SenderStore claims deliveries and records their attempts.
+type SenderStore interface {
+	EnqueueWebhookDeliveries(ctx context.Context, eventID int64, eventType string, payload []byte) (int64, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	RecordWebhookAttempt(
+		ctx context.Context,
+		attempt models.WebhookAttempt,
+		status models.WebhookDeliveryStatus,
+		nextAttemptAt time.Time,
+		disableAfter int,
+	) (disabled bool, err error)
+	WebhookSubscription(ctx context.Context, id int64) (models.WebhookSubscription, error)
+}
+
+// SenderOptions tune the sender.
+type SenderOptions struct {
+	PollInterval time.Duration // how often due deliveries are looked up
+	BatchSize    int           // deliveries claimed at once
+	Timeout      time.Duration // timeout of a single request
+	MaxAttempts  int           // attempts before a delivery fails
+	MinBackoff   time.Duration // delay before the first retry, doubled for every next one
+	MaxBackoff   time.Duration // longest delay between retries
+	DisableAfter int           // failed attempts in a row that disable a subscription
+	Lease        time.Duration // how long claimed deliveries are hidden from other senders
+}
+
+// Sender posts pending deliveries to the endpoints of their subscriptions.
+type Sender struct {
+	log    *slog.Logger
+	store  SenderStore
+	client *http.Client
+	opts   SenderOptions
+	now    func() time.Time
+}
+
+// NewSender returns a sender of the deliveries in the store.
+func NewSender(log *slog.Logger, store SenderStore, opts SenderOptions) *Sender {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = opts.Timeout + time.Minute
+	}
+
+	return &Sender{
+		log:    log,
+		store:  store,
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+		now:    time.Now,
+	}
+}
+
+// Deliver fans the outbox event out into deliveries to the subscriptions to its type.
+// It makes the sender an outbox sink; events delivered again are not fanned out twice.
+func (s *Sender) Deliver(ctx context.Context, event models.OutboxEvent) error {
+	const op = "webhooks.Deliver"
+
+	body, err := outbox.Envelope(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := s.store.EnqueueWebhookDeliveries(ctx, event.ID, event.Type, body); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Run sends due deliveries until the context is canceled.
+func (s *Sender) Run(ctx context.Context) {
+	const op = "webhooks.Run"
+
+	log := s.log.With(slog.String("op", op))
+
+	log.Info("webhook sender is running")
+
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error("failed to send webhooks", slog.String("err", err.Error()))
+		}
+
+		// A full batch means more deliveries may be due already.
+		if err == nil && n == s.opts.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush claims one batch of due deliveries, sends them and returns the number of claimed deliveries.
+// Failed deliveries are retried with exponential back-off until they run out of attempts.
+func (s *Sender) Flush(ctx context.Context) (int, error) {
+	const op = "webhooks.Flush"
+
+	deliveries, err := s.store.ClaimWebhookDeliveries(ctx, s.opts.BatchSize, s.opts.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subs := make(map[int64]models.WebhookSubscription)
+	for _, d := range deliveries {
+		sub, ok := subs[d.SubscriptionID]
+		if !ok {
+			sub, err = s.store.WebhookSubscription(ctx, d.SubscriptionID)
+			if errors.Is(err, storage.ErrWebhookSubscriptionNotFound) {
+				// Deleted meanwhile along with its deliveries.
+				continue
+			}
+			if err != nil {
+				return len(deliveries), fmt.Errorf("%s: %w", op, err)
+			}
+			subs[sub.ID] = sub
+		}
+
+		if !sub.Enabled() {
+			continue
+		}
+
+		disabled, err := s.send(ctx, sub, d)
+		if err != nil {
+			return len(deliveries), fmt.Errorf("%s: %w", op, err)
+		}
+		if disabled {
+			sub.DisabledAt = s.now()
+			subs[sub.ID] = sub
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// send posts the delivery, records the attempt and reports whether the subscription got disabled.
+func (s *Sender) send(ctx context.Context, sub models.WebhookSubscription, d models.WebhookDelivery) (bool, error) {
+	log := s.log.With(
+		slog.Int64("subscription_id", sub.ID),
+		slog.Int64("delivery_id", d.ID),
+	)
+
+	attempt := s.post(ctx, sub, d)
+
+	status, next := models.WebhookDeliverySucceeded, attempt.AttemptedAt
+	if !attempt.Succeeded() {
+		n := d.Attempts + 1
+		if n >= s.opts.MaxAttempts {
+			status = models.WebhookDeliveryFailed
+			log.Warn("webhook delivery failed", slog.Int("attempts", n), slog.String("err", attemptError(attempt)))
+		} else {
+			status, next = models.WebhookDeliveryPending, attempt.AttemptedAt.Add(s.backoff(n))
+			log.Info("webhook attempt failed",
+				slog.Int("attempts", n),
+				slog.Time("next_attempt_at", next),
+				slog.String("err", attemptError(attempt)),
+			)
+		}
+	}
+
+	disabled, err := s.store.RecordWebhookAttempt(ctx, attempt, status, next, s.opts.DisableAfter)
+	if err != nil {
+		log.Error("failed to record webhook attempt", slog.String("err", err.Error()))
+		return false, err
+	}
+
+	if disabled {
+		log.Warn("webhook subscription disabled after repeated failures",
+			slog.Int("failures", s.opts.DisableAfter),
+		)
+	}
+
+	return disabled, nil
+}
+
+// post makes a single signed request of the delivery.
+func (s *Sender) post(
+	ctx context.Context,
+	sub models.WebhookSubscription,
+	d models.WebhookDelivery,
+) (attempt models.WebhookAttempt) {
+	attempt = models.WebhookAttempt{
+		DeliveryID:  d.ID,
+		AttemptedAt: s.now(),
+	}
+	defer func() { attempt.Duration = s.now().Sub(attempt.AttemptedAt) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, attempt.AttemptedAt, d.Payload))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(EventHeader, d.EventType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+
+	return attempt
+}
+
+// backoff returns the delay after the failed attempt, attempts count from 1.
+func (s *Sender) backoff(attempt int) time.Duration {
+	delay := s.opts.MinBackoff
+	for i := 1; i < attempt && delay < s.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, s.opts.MaxBackoff)
+}
+
+func attemptError(a models.WebhookAttempt) string {
+	if a.Error != "" {
+		return a.Error
+	}
+
+	return "unexpected status " + strconv.Itoa(a.StatusCode)
+}
@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of webhook requests.
+const (
+	// SignatureHeader carries the signature of the body as t=<unix seconds>,v1=<hex HMAC-SHA256>.
+	// The HMAC covers the timestamp and the body joined by a dot, keyed with the subscription secret.
+	SignatureHeader = "X-Webhook-Signature"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrSignatureExpired = errors.New("webhook signature timestamp is out of tolerance")
+)
+
+// Sign returns the signature header value of the body sent at the timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, body))
+}
+
+// VerifySignature checks the signature header of a received body. Signatures made more than
+// tolerance away from now are rejected so that captured requests cannot be replayed later.
+func VerifySignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var t string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+			}
+			signatures = append(signatures, sig)
+		}
+	}
+
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header", ErrInvalidSignature)
+	}
+
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	expected := mac(secret, t, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
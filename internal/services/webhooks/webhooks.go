@@ -0,0 +1,530 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/pagetoken"
+	"grpc-service-ref/internal/storage"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// secretPrefix starts every subscription secret.
+const secretPrefix = "whsec_"
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+type Webhooks struct {
+	log      *slog.Logger
+	store    Store
+	accounts ServiceAccountProvider
+}
+
+type Store interface {
+	SaveWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (int64, error)
+	WebhookSubscription(ctx context.Context, id int64) (models.WebhookSubscription, error)
+	WebhookSubscriptions(ctx context.Context, app string) ([]models.WebhookSubscription, error)
+	UpdateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) error
+	DeleteWebhookSubscription(ctx context.Context, id int64) error
+	SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error)
+	WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error)
+	WebhookDeliveries(ctx context.Context, subscriptionID, beforeID int64, limit int) ([]models.WebhookDelivery, error)
+	WebhookAttempts(ctx context.Context, deliveryID int64) ([]models.WebhookAttempt, error)
+}
+
+// ServiceAccountProvider resolves the app owning a service account.
+type ServiceAccountProvider interface {
+	ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error)
+}
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound     = errors.New("webhook delivery not found")
+	ErrInvalidURL           = errors.New("invalid webhook url")
+	ErrInvalidEventType     = errors.New("invalid event type")
+	ErrInvalidPageToken     = errors.New("invalid page token")
+	ErrSubscriptionDisabled = errors.New("webhook subscription is disabled")
+	// ErrForbidden is returned to callers that may not manage webhooks of the app.
+	ErrForbidden = errors.New("not allowed to manage webhooks of the app")
+)
+
+// New returns a new instance of the Webhooks service.
+func New(log *slog.Logger, store Store, accounts ServiceAccountProvider) *Webhooks {
+	return &Webhooks{
+		log:      log,
+		store:    store,
+		accounts: accounts,
+	}
+}
+
+// CreateSubscription subscribes the endpoint of the app to the event types and returns the
+// subscription along with the secret its payloads are signed with.
+func (w *Webhooks) CreateSubscription(
+	ctx context.Context,
+	caller models.Principal,
+	app string,
+	endpoint string,
+	eventTypes []string,
+) (models.WebhookSubscription, error) {
+	const op = "webhooks.CreateSubscription"
+
+	log := w.log.With(
+		slog.String("op", op),
+		slog.String("app", app),
+	)
+
+	if err := w.authorize(ctx, log, caller, app); err != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	eventTypes, err := validate(endpoint, eventTypes)
+	if err != nil {
+		log.Warn("invalid subscription", slog.String("err", err.Error()))
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		log.Error("failed to generate secret", slog.String("err", err.Error()))
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sub := models.WebhookSubscription{
+		App:        app,
+		URL:        endpoint,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		CreatedAt:  time.Now(),
+	}
+
+	id, err := w.store.SaveWebhookSubscription(ctx, sub)
+	if err != nil {
+		log.Error("failed to save subscription", slog.String("err", err.Error()))
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+	sub.ID = id
+
+	log.Info("webhook subscription created", slog.Int64("subscription_id", id))
+
+	return sub, nil
+}
+
+// Subscriptions returns the subscriptions of the app.
+func (w *Webhooks) Subscriptions(
+	ctx context.Context,
+	caller models.Principal,
+	app string,
+) ([]models.WebhookSubscription, error) {
+	const op = "webhooks.Subscriptions"
+
+	log := w.log.With(
+		slog.String("op", op),
+		slog.String("app", app),
+	)
+
+	if err := w.authorize(ctx, log, caller, app); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subs, err := w.store.WebhookSubscriptions(ctx, app)
+	if err != nil {
+		log.Error("failed to list subscriptions", slog.String("err", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subs, nil
+}
+
+// Subscription returns the subscription by id.
+func (w *Webhooks) Subscription(ctx context.Context, caller models.Principal, id int64) (models.WebhookSubscription, error) {
+	const op = "webhooks.Subscription"
+
+	sub, err := w.subscription(ctx, caller, op, id)
+	if err != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sub, nil
+}
+
+// UpdateSubscription replaces the endpoint and the event types of the subscription.
+func (w *Webhooks) UpdateSubscription(
+	ctx context.Context,
+	caller models.Principal,
+	id int64,
+	endpoint string,
+	eventTypes []string,
+) (models.WebhookSubscription, error) {
+	const op = "webhooks.UpdateSubscription"
+
+	eventTypes, err := validate(endpoint, eventTypes)
+	if err != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sub, err := w.update(ctx, caller, op, id, func(sub *models.WebhookSubscription) error {
+		sub.URL, sub.EventTypes = endpoint, eventTypes
+		return nil
+	})
+	if err != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sub, nil
+}
+
+// SetSubscriptionEnabled enables or disables the subscription. Deliveries of disabled subscriptions
+// wait until they are enabled again, enabling also forgets the failures that disabled it.
+func (w *Webhooks) SetSubscriptionEnabled(
+	ctx context.Context,
+	caller models.Principal,
+	id int64,
+	enabled bool,
+) (models.WebhookSubscription, error) {
+	const op = "webhooks.SetSubscriptionEnabled"
+
+	sub, err := w.update(ctx, caller, op, id, func(sub *models.WebhookSubscription) error {
+		switch {
+		case enabled:
+			sub.DisabledAt, sub.ConsecutiveFailures = time.Time{}, 0
+		case sub.Enabled():
+			sub.DisabledAt = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sub, nil
+}
+
+// RotateSecret replaces the secret of the subscription and returns the subscription with the new one.
+// Deliveries sent afterwards are signed with the new secret.
+func (w *Webhooks) RotateSecret(ctx context.Context, caller models.Principal, id int64) (models.WebhookSubscription, error) {
+	const op = "webhooks.RotateSecret"
+
+	sub, err := w.update(ctx, caller, op, id, func(sub *models.WebhookSubscription) error {
+		secret, err := newSecret()
+		if err != nil {
+			return err
+		}
+		sub.Secret = secret
+		return nil
+	})
+	if err != nil {
+		return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sub, nil
+}
+
+// DeleteSubscription deletes the subscription along with its deliveries.
+func (w *Webhooks) DeleteSubscription(ctx context.Context, caller models.Principal, id int64) error {
+	const op = "webhooks.DeleteSubscription"
+
+	log := w.log.With(
+		slog.String("op", op),
+		slog.Int64("subscription_id", id),
+	)
+
+	if _, err := w.subscription(ctx, caller, op, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := w.store.DeleteWebhookSubscription(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrWebhookSubscriptionNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrSubscriptionNotFound)
+		}
+		log.Error("failed to delete subscription", slog.String("err", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("webhook subscription deleted")
+
+	return nil
+}
+
+// Deliveries returns a page of deliveries of the subscription, newest first, and the token of the next page.
+// The next page token is empty on the last page.
+func (w *Webhooks) Deliveries(
+	ctx context.Context,
+	caller models.Principal,
+	subscriptionID int64,
+	pageToken string,
+	pageSize int,
+) ([]models.WebhookDelivery, string, error) {
+	const op = "webhooks.Deliveries"
+
+	log := w.log.With(
+		slog.String("op", op),
+		slog.Int64("subscription_id", subscriptionID),
+	)
+
+	if _, err := w.subscription(ctx, caller, op, subscriptionID); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	beforeID, err := pagetoken.Decode(pageToken)
+	if err != nil {
+		log.Warn("invalid page token", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+	}
+
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	pageSize = min(pageSize, MaxPageSize)
+
+	// One extra delivery tells whether there is a next page.
+	deliveries, err := w.store.WebhookDeliveries(ctx, subscriptionID, beforeID, pageSize+1)
+	if err != nil {
+		log.Error("failed to list deliveries", slog.String("err", err.Error()))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(deliveries) > pageSize {
+		deliveries = deliveries[:pageSize]
+		next = pagetoken.Encode(deliveries[pageSize-1].ID)
+	}
+
+	return deliveries, next, nil
+}
+
+// Delivery returns the delivery by id along with its attempts, oldest first.
+func (w *Webhooks) Delivery(
+	ctx context.Context,
+	caller models.Principal,
+	id int64,
+) (models.WebhookDelivery, []models.WebhookAttempt, error) {
+	const op = "webhooks.Delivery"
+
+	log := w.log.With(
+		slog.String("op", op),
+		slog.Int64("delivery_id", id),
+	)
+
+	d, err := w.delivery(ctx, caller, op, id)
+	if err != nil {
+		return models.WebhookDelivery{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	attempts, err := w.store.WebhookAttempts(ctx, id)
+	if err != nil {
+		log.Error("failed to list attempts", slog.String("err", err.Error()))
+		return models.WebhookDelivery{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return d, attempts, nil
+}
+
+// ReplayDelivery sends the event of the delivery to its subscription again as a new delivery,
+// whatever the outcome of the original one.
+func (w *Webhooks) ReplayDelivery(ctx context.Context, caller models.Principal, id int64) (models.WebhookDelivery, error) {
+	const op = "webhooks.ReplayDelivery"
+
+	log := w.log.With(
+		slog.String("op", op),
+		slog.Int64("delivery_id", id),
+	)
+
+	d, err := w.delivery(ctx, caller, op, id)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sub, err := w.subscription(ctx, caller, op, d.SubscriptionID)
+	if err != nil {
+		return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !sub.Enabled() {
+		log.Warn("subscription is disabled")
+		return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, ErrSubscriptionDisabled)
+	}
+
+	replay := models.WebhookDelivery{
+		SubscriptionID: d.SubscriptionID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Status:         models.WebhookDeliveryPending,
+		ReplayOf:       d.ID,
+		CreatedAt:      time.Now(),
+	}
+
+	replayID, err := w.store.SaveWebhookDelivery(ctx, replay)
+	if err != nil {
+		log.Error("failed to save delivery", slog.String("err", err.Error()))
+		return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, err)
+	}
+	replay.ID = replayID
+
+	log.Info("webhook delivery replayed", slog.Int64("replay_id", replayID))
+
+	return replay, nil
+}
+
+// subscription returns the subscription if the caller may manage webhooks of its app.
+func (w *Webhooks) subscription(
+	ctx context.Context,
+	caller models.Principal,
+	op string,
+	id int64,
+) (models.WebhookSubscription, error) {
+	log := w.log.With(
+		slog.String("op", op),
+		slog.Int64("subscription_id", id),
+	)
+
+	sub, err := w.store.WebhookSubscription(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrWebhookSubscriptionNotFound) {
+			log.Warn("subscription not found")
+			return models.WebhookSubscription{}, ErrSubscriptionNotFound
+		}
+		log.Error("failed to get subscription", slog.String("err", err.Error()))
+		return models.WebhookSubscription{}, err
+	}
+
+	if err := w.authorize(ctx, log, caller, sub.App); err != nil {
+		// Subscriptions of other apps are not disclosed.
+		if errors.Is(err, ErrForbidden) {
+			return models.WebhookSubscription{}, ErrSubscriptionNotFound
+		}
+		return models.WebhookSubscription{}, err
+	}
+
+	return sub, nil
+}
+
+func (w *Webhooks) delivery(
+	ctx context.Context,
+	caller models.Principal,
+	op string,
+	id int64,
+) (models.WebhookDelivery, error) {
+	d, err := w.store.WebhookDelivery(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrWebhookDeliveryNotFound) {
+			return models.WebhookDelivery{}, ErrDeliveryNotFound
+		}
+		w.log.Error("failed to get delivery",
+			slog.String("op", op),
+			slog.Int64("delivery_id", id),
+			slog.String("err", err.Error()),
+		)
+		return models.WebhookDelivery{}, err
+	}
+
+	if _, err := w.subscription(ctx, caller, op, d.SubscriptionID); err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			return models.WebhookDelivery{}, ErrDeliveryNotFound
+		}
+		return models.WebhookDelivery{}, err
+	}
+
+	return d, nil
+}
+
+// update applies the change to the subscription and saves it.
+func (w *Webhooks) update(
+	ctx context.Context,
+	caller models.Principal,
+	op string,
+	id int64,
+	change func(sub *models.WebhookSubscription) error,
+) (models.WebhookSubscription, error) {
+	log := w.log.With(
+		slog.String("op", op),
+		slog.Int64("subscription_id", id),
+	)
+
+	sub, err := w.subscription(ctx, caller, op, id)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	if err := change(&sub); err != nil {
+		log.Error("failed to change subscription", slog.String("err", err.Error()))
+		return models.WebhookSubscription{}, err
+	}
+
+	if err := w.store.UpdateWebhookSubscription(ctx, sub); err != nil {
+		if errors.Is(err, storage.ErrWebhookSubscriptionNotFound) {
+			return models.WebhookSubscription{}, ErrSubscriptionNotFound
+		}
+		log.Error("failed to update subscription", slog.String("err", err.Error()))
+		return models.WebhookSubscription{}, err
+	}
+
+	log.Info("webhook subscription updated")
+
+	return sub, nil
+}
+
+// authorize allows admins to manage webhooks of every app and service accounts
+// to manage webhooks of the app that owns them.
+func (w *Webhooks) authorize(ctx context.Context, log *slog.Logger, caller models.Principal, app string) error {
+	if caller.HasRole(models.RoleAdmin) {
+		return nil
+	}
+
+	if caller.IsUser() {
+		log.Warn("caller is not an admin", slog.String("principal", caller.String()))
+		return ErrForbidden
+	}
+
+	sa, err := w.accounts.ServiceAccount(ctx, caller.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrServiceAccountNotFound) {
+			return ErrForbidden
+		}
+		log.Error("failed to get service account", slog.String("err", err.Error()))
+		return err
+	}
+
+	if sa.Owner != (models.Owner{Kind: models.OwnerApp, Name: app}) {
+		log.Warn("service account belongs to another owner", slog.String("principal", caller.String()))
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// validate checks the endpoint and returns the event types sorted without duplicates.
+func validate(endpoint string, eventTypes []string) ([]string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("%w: absolute http or https url is required", ErrInvalidURL)
+	}
+
+	if len(eventTypes) == 0 {
+		return nil, fmt.Errorf("%w: at least one event type is required", ErrInvalidEventType)
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(models.DomainEvents, eventType) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEventType, eventType)
+		}
+	}
+
+	return slices.Compact(slices.Sorted(slices.Values(eventTypes))), nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return secretPrefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,434 @@
+package webhooks_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/services/webhooks"
+	"grpc-service-ref/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var admin = models.Principal{Type: models.PrincipalUser, ID: 1, Roles: []string{models.RoleAdmin}}
+
+func TestSender_DeliversSignedEvents(t *testing.T) {
+	store := newMemStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w := webhooks.New(log, store, store)
+
+	var secret string
+	received := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, webhooks.VerifySignature(secret, r.Header.Get(webhooks.SignatureHeader), body,
+			time.Minute, time.Now()))
+		assert.Contains(t, string(body), `"email":"a@x.io"`)
+		received <- r
+	}))
+	defer srv.Close()
+
+	sub, err := w.CreateSubscription(context.Background(), admin, "billing", srv.URL,
+		[]string{models.DomainEventUserRegistered})
+	require.NoError(t, err)
+	secret = sub.Secret
+
+	sender := webhooks.NewSender(log, store, options())
+	event := models.OutboxEvent{
+		ID:          7,
+		Type:        models.DomainEventUserRegistered,
+		AggregateID: "user:1",
+		Payload:     []byte(`{"user_id":1,"email":"a@x.io"}`),
+	}
+	require.NoError(t, sender.Deliver(context.Background(), event))
+	// The outbox may deliver an event again, it is sent once.
+	require.NoError(t, sender.Deliver(context.Background(), event))
+	require.NoError(t, sender.Deliver(context.Background(), models.OutboxEvent{ID: 8, Type: models.DomainEventPasswordChanged}))
+
+	n, err := sender.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	r := <-received
+	assert.Equal(t, models.DomainEventUserRegistered, r.Header.Get(webhooks.EventHeader))
+	assert.Equal(t, "1", r.Header.Get(webhooks.DeliveryHeader))
+
+	d, attempts, err := w.Delivery(context.Background(), admin, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookDeliverySucceeded, d.Status)
+	require.Len(t, attempts, 1)
+	assert.Equal(t, http.StatusOK, attempts[0].StatusCode)
+}
+
+func TestSender_RetriesAndDisablesSubscription(t *testing.T) {
+	store := newMemStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w := webhooks.New(log, store, store)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	sub, err := w.CreateSubscription(context.Background(), admin, "billing", srv.URL,
+		[]string{models.DomainEventPasswordChanged})
+	require.NoError(t, err)
+
+	opts := options()
+	opts.MaxAttempts = 5
+	opts.DisableAfter = 2
+	sender := webhooks.NewSender(log, store, opts)
+	require.NoError(t, sender.Deliver(context.Background(), models.OutboxEvent{ID: 1, Type: models.DomainEventPasswordChanged}))
+
+	var backoffs []time.Duration
+	for range 3 {
+		_, err := sender.Flush(context.Background())
+		require.NoError(t, err)
+		if d := store.deliveries[0]; d.Attempts > len(backoffs) {
+			backoffs = append(backoffs, d.NextAttemptAt.Sub(store.attempts[len(store.attempts)-1].AttemptedAt))
+		}
+	}
+
+	// The third flush finds the subscription disabled.
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, backoffs)
+	assert.Equal(t, models.WebhookDeliveryPending, store.deliveries[0].Status)
+	assert.Equal(t, http.StatusInternalServerError, store.deliveries[0].LastStatusCode)
+
+	sub, err = w.Subscription(context.Background(), admin, sub.ID)
+	require.NoError(t, err)
+	assert.False(t, sub.Enabled())
+
+	failing.Store(false)
+	sub, err = w.SetSubscriptionEnabled(context.Background(), admin, sub.ID, true)
+	require.NoError(t, err)
+	assert.Zero(t, sub.ConsecutiveFailures)
+
+	_, err = sender.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookDeliverySucceeded, store.deliveries[0].Status)
+	assert.Equal(t, 3, store.deliveries[0].Attempts)
+}
+
+func TestSender_FailsAfterMaxAttempts(t *testing.T) {
+	store := newMemStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w := webhooks.New(log, store, store)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	_, err := w.CreateSubscription(context.Background(), admin, "billing", srv.URL,
+		[]string{models.DomainEventPasswordChanged})
+	require.NoError(t, err)
+
+	sender := webhooks.NewSender(log, store, options())
+	require.NoError(t, sender.Deliver(context.Background(), models.OutboxEvent{ID: 1, Type: models.DomainEventPasswordChanged}))
+
+	for range 3 {
+		_, err := sender.Flush(context.Background())
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, models.WebhookDeliveryFailed, store.deliveries[0].Status)
+	assert.Equal(t, 2, store.deliveries[0].Attempts)
+
+	replay, err := w.ReplayDelivery(context.Background(), admin, store.deliveries[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, store.deliveries[0].ID, replay.ReplayOf)
+	assert.Equal(t, models.WebhookDeliveryPending, replay.Status)
+
+	n, err := sender.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestSubscriptions_AppOwnership(t *testing.T) {
+	store := newMemStore()
+	store.accounts[10] = models.ServiceAccount{ID: 10, Owner: models.Owner{Kind: models.OwnerApp, Name: "billing"}}
+	store.accounts[11] = models.ServiceAccount{ID: 11, Owner: models.Owner{Kind: models.OwnerApp, Name: "crm"}}
+	owner := models.Principal{Type: models.PrincipalServiceAccount, ID: 10}
+	other := models.Principal{Type: models.PrincipalServiceAccount, ID: 11}
+	user := models.Principal{Type: models.PrincipalUser, ID: 2}
+
+	w := webhooks.New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, store)
+	ctx := context.Background()
+
+	sub, err := w.CreateSubscription(ctx, owner, "billing", "https://billing.example.com/hooks",
+		[]string{models.DomainEventUserRegistered, models.DomainEventUserRegistered})
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.DomainEventUserRegistered}, sub.EventTypes)
+
+	_, err = w.CreateSubscription(ctx, other, "billing", "https://crm.example.com/hooks",
+		[]string{models.DomainEventUserRegistered})
+	require.ErrorIs(t, err, webhooks.ErrForbidden)
+
+	_, err = w.Subscriptions(ctx, user, "billing")
+	require.ErrorIs(t, err, webhooks.ErrForbidden)
+
+	_, err = w.Subscription(ctx, other, sub.ID)
+	require.ErrorIs(t, err, webhooks.ErrSubscriptionNotFound)
+
+	_, err = w.CreateSubscription(ctx, owner, "billing", "ftp://billing.example.com", []string{models.DomainEventUserRegistered})
+	require.ErrorIs(t, err, webhooks.ErrInvalidURL)
+
+	_, err = w.CreateSubscription(ctx, owner, "billing", "https://billing.example.com", []string{"user.deleted"})
+	require.ErrorIs(t, err, webhooks.ErrInvalidEventType)
+
+	rotated, err := w.RotateSecret(ctx, owner, sub.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, sub.Secret, rotated.Secret)
+
+	require.NoError(t, w.DeleteSubscription(ctx, owner, sub.ID))
+	_, err = w.Subscription(ctx, owner, sub.ID)
+	require.ErrorIs(t, err, webhooks.ErrSubscriptionNotFound)
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	header := webhooks.Sign("secret", now, body)
+
+	require.NoError(t, webhooks.VerifySignature("secret", header, body, time.Minute, now))
+
+	tests := []struct {
+		name        string
+		secret      string
+		header      string
+		body        []byte
+		now         time.Time
+		expectedErr error
+	}{
+		{
+			name:        "Wrong secret",
+			secret:      "other",
+			header:      header,
+			body:        body,
+			now:         now,
+			expectedErr: webhooks.ErrInvalidSignature,
+		},
+		{
+			name:        "Modified body",
+			secret:      "secret",
+			header:      header,
+			body:        []byte(`{"id":2}`),
+			now:         now,
+			expectedErr: webhooks.ErrInvalidSignature,
+		},
+		{
+			name:        "Old timestamp",
+			secret:      "secret",
+			header:      header,
+			body:        body,
+			now:         now.Add(time.Hour),
+			expectedErr: webhooks.ErrSignatureExpired,
+		},
+		{
+			name:        "Malformed header",
+			secret:      "secret",
+			header:      "v1=zz",
+			body:        body,
+			now:         now,
+			expectedErr: webhooks.ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhooks.VerifySignature(tt.secret, tt.header, tt.body, time.Minute, tt.now)
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func options() webhooks.SenderOptions {
+	return webhooks.SenderOptions{
+		PollInterval: time.Second,
+		BatchSize:    10,
+		Timeout:      time.Second,
+		MaxAttempts:  2,
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+		DisableAfter: 10,
+	}
+}
+
+// memStore claims every pending delivery of enabled subscriptions regardless of its next attempt time.
+type memStore struct {
+	subs       []models.WebhookSubscription
+	deliveries []models.WebhookDelivery
+	attempts   []models.WebhookAttempt
+	accounts   map[int64]models.ServiceAccount
+}
+
+func newMemStore() *memStore {
+	return &memStore{accounts: map[int64]models.ServiceAccount{}}
+}
+
+func (m *memStore) ServiceAccount(_ context.Context, id int64) (models.ServiceAccount, error) {
+	sa, ok := m.accounts[id]
+	if !ok {
+		return models.ServiceAccount{}, storage.ErrServiceAccountNotFound
+	}
+	return sa, nil
+}
+
+func (m *memStore) SaveWebhookSubscription(_ context.Context, sub models.WebhookSubscription) (int64, error) {
+	sub.ID = int64(len(m.subs) + 1)
+	m.subs = append(m.subs, sub)
+	return sub.ID, nil
+}
+
+func (m *memStore) sub(id int64) *models.WebhookSubscription {
+	if id < 1 || id > int64(len(m.subs)) || m.subs[id-1].ID == 0 {
+		return nil
+	}
+	return &m.subs[id-1]
+}
+
+func (m *memStore) WebhookSubscription(_ context.Context, id int64) (models.WebhookSubscription, error) {
+	sub := m.sub(id)
+	if sub == nil {
+		return models.WebhookSubscription{}, storage.ErrWebhookSubscriptionNotFound
+	}
+	return *sub, nil
+}
+
+func (m *memStore) WebhookSubscriptions(_ context.Context, app string) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	for _, sub := range m.subs {
+		if sub.ID != 0 && sub.App == app {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (m *memStore) UpdateWebhookSubscription(_ context.Context, sub models.WebhookSubscription) error {
+	s := m.sub(sub.ID)
+	if s == nil {
+		return storage.ErrWebhookSubscriptionNotFound
+	}
+	*s = sub
+	return nil
+}
+
+func (m *memStore) DeleteWebhookSubscription(_ context.Context, id int64) error {
+	s := m.sub(id)
+	if s == nil {
+		return storage.ErrWebhookSubscriptionNotFound
+	}
+	*s = models.WebhookSubscription{}
+	return nil
+}
+
+func (m *memStore) EnqueueWebhookDeliveries(_ context.Context, eventID int64, eventType string, payload []byte) (int64, error) {
+	var n int64
+	for _, sub := range m.subs {
+		if sub.ID == 0 || !sub.Enabled() || !slices.Contains(sub.EventTypes, eventType) {
+			continue
+		}
+		exists := slices.ContainsFunc(m.deliveries, func(d models.WebhookDelivery) bool {
+			return d.SubscriptionID == sub.ID && d.EventID == eventID && d.ReplayOf == 0
+		})
+		if exists {
+			continue
+		}
+		_, _ = m.SaveWebhookDelivery(context.Background(), models.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        eventID,
+			EventType:      eventType,
+			Payload:        payload,
+		})
+		n++
+	}
+	return n, nil
+}
+
+func (m *memStore) SaveWebhookDelivery(_ context.Context, d models.WebhookDelivery) (int64, error) {
+	d.ID = int64(len(m.deliveries) + 1)
+	d.Status = models.WebhookDeliveryPending
+	m.deliveries = append(m.deliveries, d)
+	return d.ID, nil
+}
+
+func (m *memStore) ClaimWebhookDeliveries(_ context.Context, limit int, _ time.Duration) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	for _, d := range m.deliveries {
+		sub := m.sub(d.SubscriptionID)
+		if d.Status == models.WebhookDeliveryPending && sub != nil && sub.Enabled() && len(deliveries) < limit {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *memStore) RecordWebhookAttempt(
+	_ context.Context,
+	attempt models.WebhookAttempt,
+	status models.WebhookDeliveryStatus,
+	nextAttemptAt time.Time,
+	disableAfter int,
+) (bool, error) {
+	attempt.ID = int64(len(m.attempts) + 1)
+	m.attempts = append(m.attempts, attempt)
+
+	d := &m.deliveries[attempt.DeliveryID-1]
+	d.Status, d.Attempts, d.NextAttemptAt = status, d.Attempts+1, nextAttemptAt
+	d.LastStatusCode, d.LastError = attempt.StatusCode, attempt.Error
+
+	sub := m.sub(d.SubscriptionID)
+	if attempt.Succeeded() {
+		sub.ConsecutiveFailures = 0
+		return false, nil
+	}
+	sub.ConsecutiveFailures++
+	if sub.Enabled() && sub.ConsecutiveFailures >= disableAfter {
+		sub.DisabledAt = attempt.AttemptedAt
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *memStore) WebhookDelivery(_ context.Context, id int64) (models.WebhookDelivery, error) {
+	if id < 1 || id > int64(len(m.deliveries)) {
+		return models.WebhookDelivery{}, storage.ErrWebhookDeliveryNotFound
+	}
+	return m.deliveries[id-1], nil
+}
+
+func (m *memStore) WebhookDeliveries(_ context.Context, subscriptionID, beforeID int64, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := m.deliveries[i]
+		if d.SubscriptionID == subscriptionID && (beforeID == 0 || d.ID < beforeID) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *memStore) WebhookAttempts(_ context.Context, deliveryID int64) ([]models.WebhookAttempt, error) {
+	var attempts []models.WebhookAttempt
+	for _, a := range m.attempts {
+		if a.DeliveryID == deliveryID {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts, nil
+}
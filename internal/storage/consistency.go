@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads returns a context whose reads are served by the primary database
+// rather than by read replicas, which may lag behind it.
+func WithPrimaryReads(ctx context.Context) context.Context {
+    return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// PrimaryReads reports whether reads of the context must be served by the primary database.
+func PrimaryReads(ctx context.Context) bool {
+    primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+    return primary
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"maps"
+	"slices"
+)
+
+// AppendAuditEvent appends the event to the audit log, chaining its hash to the last entry,
+// and returns the stored event. Personal data is sealed with the audit keys of the users it
+// belongs to, created as needed.
+func (s *Storage) AppendAuditEvent(_ context.Context, event models.AuditEvent) (models.AuditEvent, error) {
+    const op = "storage.memory.AppendAuditEvent"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    keys := make(map[string][]byte)
+    for _, ref := range audit.KeyRefs(event) {
+        if _, ok := s.auditKeys[ref]; !ok {
+            key, err := audit.NewKey()
+            if err != nil {
+                return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+            }
+            s.auditKeys[ref] = key
+        }
+        keys[ref] = s.auditKeys[ref]
+    }
+    event, err := audit.Seal(event, keys)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    event.PrevHash = []byte{}
+    if n := len(s.auditLog); n > 0 {
+        event.PrevHash = slices.Clone(s.auditLog[n-1].Hash)
+    }
+    if event.Details == nil {
+        event.Details = map[string]string{}
+    }
+    event.Details = maps.Clone(event.Details)
+    event.Hash = event.ComputeHash()
+    event.ID = s.nextID("audit_log")
+
+    s.auditLog = append(s.auditLog, event)
+
+    return audit.Open(cloneAuditEvent(event), keys), nil
+}
+
+// AuditEvents returns up to limit audit events matching the filter with ids less than beforeID,
+// newest first. A zero beforeID starts from the newest event. Personal data whose audit keys
+// were deleted is left out.
+func (s *Storage) AuditEvents(
+    _ context.Context,
+    filter models.AuditFilter,
+    beforeID int64,
+    limit int,
+) ([]models.AuditEvent, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var events []models.AuditEvent
+    for i := len(s.auditLog) - 1; i >= 0 && len(events) < limit; i-- {
+        event := s.auditLog[i]
+        if (beforeID != 0 && event.ID >= beforeID) ||
+            (filter.Type != "" && event.Type != filter.Type) ||
+            (filter.Actor != "" && event.Actor != filter.Actor) ||
+            (filter.Subject != "" && event.Subject != filter.Subject) ||
+            (!filter.Since.IsZero() && event.CreatedAt.Before(filter.Since)) ||
+            (!filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until)) {
+            continue
+        }
+        events = append(events, audit.Open(cloneAuditEvent(event), s.auditKeys))
+    }
+
+    return events, nil
+}
+
+func cloneAuditEvent(event models.AuditEvent) models.AuditEvent {
+    event.Details = maps.Clone(event.Details)
+    event.Sealed = maps.Clone(event.Sealed)
+    event.PrevHash = slices.Clone(event.PrevHash)
+    event.Hash = slices.Clone(event.Hash)
+    return event
+}
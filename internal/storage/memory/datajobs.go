@@ -0,0 +1,323 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SaveDataJob saves a pending job and returns its id.
+func (s *Storage) SaveDataJob(_ context.Context, job models.DataJob) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    job.ID = s.nextID("data_jobs")
+    job.Status = models.DataJobPending
+    job.Attempts = 0
+    job.NextAttemptAt = job.CreatedAt
+    job.LastError = ""
+    job.Archive = nil
+    job.StartedAt, job.FinishedAt, job.ExpiresAt = time.Time{}, time.Time{}, time.Time{}
+    s.dataJobs[job.ID] = job
+
+    return job.ID, nil
+}
+
+// DataJob returns the job by id along with its archive.
+func (s *Storage) DataJob(_ context.Context, id int64) (models.DataJob, error) {
+    const op = "storage.memory.DataJob"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    job, ok := s.dataJobs[id]
+    if !ok {
+        return models.DataJob{}, fmt.Errorf("%s: %w", op, storage.ErrDataJobNotFound)
+    }
+
+    return cloneDataJob(job), nil
+}
+
+// ClaimDataJobs returns up to limit unfinished jobs that are due, oldest first, marks them running
+// and postpones their next attempt by the lease so that concurrent workers skip them.
+func (s *Storage) ClaimDataJobs(_ context.Context, limit int, lease time.Duration) ([]models.DataJob, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+
+    var due []models.DataJob
+    for _, job := range s.dataJobs {
+        if !job.Finished() && !job.NextAttemptAt.After(now) {
+            due = append(due, job)
+        }
+    }
+    slices.SortFunc(due, func(a, b models.DataJob) int { return cmp.Compare(a.ID, b.ID) })
+    if len(due) > limit {
+        due = due[:limit]
+    }
+
+    jobs := make([]models.DataJob, 0, len(due))
+    for _, job := range due {
+        job.Status = models.DataJobRunning
+        job.NextAttemptAt = now.Add(lease)
+        if job.StartedAt.IsZero() {
+            job.StartedAt = now
+        }
+        s.dataJobs[job.ID] = job
+        jobs = append(jobs, cloneDataJob(job))
+    }
+
+    return jobs, nil
+}
+
+// CompleteDataJob records the success of the job with the archive of an export,
+// which is kept until expiresAt.
+func (s *Storage) CompleteDataJob(_ context.Context, id int64, archive []byte, finishedAt, expiresAt time.Time) error {
+    s.updateDataJob(id, func(job *models.DataJob) {
+        job.Status = models.DataJobSucceeded
+        job.Attempts++
+        job.LastError = ""
+        job.Archive = slices.Clone(archive)
+        job.FinishedAt = finishedAt
+        job.ExpiresAt = expiresAt
+    })
+
+    return nil
+}
+
+// RetryDataJob records a failed run of the job and schedules the next attempt.
+func (s *Storage) RetryDataJob(_ context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+    s.updateDataJob(id, func(job *models.DataJob) {
+        job.Status = models.DataJobPending
+        job.Attempts++
+        job.LastError = lastErr
+        job.NextAttemptAt = nextAttemptAt
+    })
+
+    return nil
+}
+
+// FailDataJob records the last failed run of the job and stops retrying it.
+func (s *Storage) FailDataJob(_ context.Context, id int64, lastErr string, finishedAt time.Time) error {
+    s.updateDataJob(id, func(job *models.DataJob) {
+        job.Status = models.DataJobFailed
+        job.Attempts++
+        job.LastError = lastErr
+        job.FinishedAt = finishedAt
+    })
+
+    return nil
+}
+
+// PurgeDataJobArchives removes archives that expired before the time and returns their number.
+func (s *Storage) PurgeDataJobArchives(_ context.Context, before time.Time) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var n int64
+    for id, job := range s.dataJobs {
+        if job.Archive != nil && !job.ExpiresAt.After(before) {
+            job.Archive = nil
+            s.dataJobs[id] = job
+            n++
+        }
+    }
+
+    return n, nil
+}
+
+// UserData returns the personal data stored about the user.
+func (s *Storage) UserData(_ context.Context, userID int64) (models.UserData, error) {
+    const op = "storage.memory.UserData"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    data := models.UserData{User: cloneUser(user)}
+
+    for _, ident := range s.identities {
+        if ident.userID == userID {
+            data.Identities = append(data.Identities, models.Identity{
+                ID:        ident.id,
+                UserID:    ident.userID,
+                Provider:  ident.provider,
+                Subject:   ident.subject,
+                CreatedAt: ident.createdAt,
+            })
+        }
+    }
+
+    for _, sess := range s.sessions {
+        if sess.UserID == userID {
+            data.Sessions = append(data.Sessions, models.UserSession{Session: sess.Session, RevokedAt: sess.revokedAt})
+        }
+    }
+    slices.SortFunc(data.Sessions, func(a, b models.UserSession) int {
+        if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+            return c
+        }
+        return cmp.Compare(a.ID, b.ID)
+    })
+
+    for _, key := range s.apiKeys {
+        if key.Principal.IsUser() && key.Principal.ID == userID {
+            data.APIKeys = append(data.APIKeys, models.UserAPIKey{APIKey: cloneAPIKey(key.APIKey), RevokedAt: key.revokedAt})
+        }
+    }
+    slices.SortFunc(data.APIKeys, func(a, b models.UserAPIKey) int { return cmp.Compare(a.ID, b.ID) })
+
+    for _, t := range s.transitions {
+        if t.UserID == userID {
+            data.Transitions = append(data.Transitions, t)
+        }
+    }
+    slices.SortFunc(data.Transitions, func(a, b models.StatusTransition) int { return cmp.Compare(a.ID, b.ID) })
+
+    data.Memberships = s.userOrganizations(userID)
+
+    return data, nil
+}
+
+// EraseUser deletes the user along with its identities, sessions, API keys, phone codes, memberships
+// and status history, and the invitations and invite codes it accepted or that are for its email.
+// It removes the archives of its exports and reduces the payloads of outbox events and webhook
+// deliveries about it to the user id. A user.erased event is published. The audit log is
+// append-only: its entries are kept and the audit keys sealing the personal data in them are
+// deleted, along with the keys of the identifiers of the user.
+func (s *Storage) EraseUser(_ context.Context, userID int64) error {
+    const op = "storage.memory.EraseUser"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+    redacted, err := json.Marshal(models.UserErased{UserID: userID})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    events := make(map[int64]bool)
+    for _, e := range s.outbox {
+        if e.AggregateID == aggregate {
+            events[e.ID] = true
+        }
+    }
+
+    deliveries := make(map[int64][]byte)
+    for id, d := range s.webhookDeliveries {
+        if !events[d.EventID] {
+            continue
+        }
+        var envelope map[string]json.RawMessage
+        if err := json.Unmarshal(d.Payload, &envelope); err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+        envelope["payload"] = redacted
+        if deliveries[id], err = json.Marshal(envelope); err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+    // Nothing is changed until every envelope is rewritten.
+    for i := range s.outbox {
+        if events[s.outbox[i].ID] {
+            s.outbox[i].Payload = slices.Clone(redacted)
+        }
+    }
+    for id, payload := range deliveries {
+        d := s.webhookDeliveries[id]
+        d.Payload = payload
+        s.webhookDeliveries[id] = d
+    }
+
+    for id, job := range s.dataJobs {
+        if job.UserID == userID && job.Archive != nil {
+            job.Archive = nil
+            s.dataJobs[id] = job
+        }
+    }
+
+    for _, ref := range audit.ErasureRefs(userID, models.UserIdentifiers{Email: user.Email, Username: user.Username, Phone: user.Phone}) {
+        delete(s.auditKeys, ref)
+    }
+
+    delete(s.users, userID)
+    s.identities = slices.DeleteFunc(s.identities, func(ident identity) bool { return ident.userID == userID })
+    s.transitions = slices.DeleteFunc(s.transitions, func(t models.StatusTransition) bool { return t.UserID == userID })
+    for id, sess := range s.sessions {
+        if sess.UserID == userID {
+            delete(s.sessions, id)
+        }
+    }
+    for id, key := range s.apiKeys {
+        if key.Principal.IsUser() && key.Principal.ID == userID {
+            delete(s.apiKeys, id)
+        }
+    }
+    for id, code := range s.phoneCodes {
+        if code.UserID == userID {
+            delete(s.phoneCodes, id)
+        }
+    }
+    for key := range s.members {
+        if key.userID == userID {
+            delete(s.members, key)
+        }
+    }
+    for edge := range s.groupUsers {
+        if edge.memberID == userID {
+            delete(s.groupUsers, edge)
+        }
+    }
+    // Invite codes for anyone have no email.
+    forEmail := func(email string) bool { return user.Email != "" && strings.EqualFold(email, user.Email) }
+    for id, inv := range s.orgInvitations {
+        if inv.AcceptedBy == userID || forEmail(inv.Email) {
+            delete(s.orgInvitations, id)
+        }
+    }
+    for id, code := range s.inviteCodes {
+        if code.UsedBy == userID || forEmail(code.Email) {
+            delete(s.inviteCodes, id)
+        }
+    }
+
+    if err := s.enqueue(models.DomainEventUserErased, userID, models.UserErased{UserID: userID}); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// updateDataJob applies the update to the job with the id, if there is one.
+func (s *Storage) updateDataJob(id int64, update func(job *models.DataJob)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if job, ok := s.dataJobs[id]; ok {
+        update(&job)
+        s.dataJobs[id] = job
+    }
+}
+
+func cloneDataJob(job models.DataJob) models.DataJob {
+    job.Archive = slices.Clone(job.Archive)
+    return job
+}
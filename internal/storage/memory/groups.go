@@ -0,0 +1,286 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+)
+
+// groupEdge makes a user or a group a direct member of a group.
+type groupEdge struct {
+    groupID  int64
+    memberID int64
+}
+
+// CreateGroup creates the group and returns its id.
+func (s *Storage) CreateGroup(_ context.Context, g models.Group) (int64, error) {
+    const op = "storage.memory.CreateGroup"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, other := range s.groups {
+        if other.Name == g.Name {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+        }
+    }
+
+    g.ID = s.nextID("groups")
+    g.Roles = cloneStrings(g.Roles)
+    s.groups[g.ID] = g
+
+    return g.ID, nil
+}
+
+// Group returns the group by id.
+func (s *Storage) Group(_ context.Context, id int64) (models.Group, error) {
+    const op = "storage.memory.Group"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    g, ok := s.groups[id]
+    if !ok {
+        return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+    g.Roles = cloneStrings(g.Roles)
+
+    return g, nil
+}
+
+// Groups returns up to limit groups with ids greater than afterID, ordered by id.
+func (s *Storage) Groups(_ context.Context, afterID int64, limit int) ([]models.Group, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var groups []models.Group
+    for id, g := range s.groups {
+        if id > afterID {
+            g.Roles = cloneStrings(g.Roles)
+            groups = append(groups, g)
+        }
+    }
+    slices.SortFunc(groups, func(a, b models.Group) int { return cmp.Compare(a.ID, b.ID) })
+    if len(groups) > limit {
+        groups = groups[:limit]
+    }
+
+    return groups, nil
+}
+
+// SetGroupRoles replaces the roles the group grants.
+func (s *Storage) SetGroupRoles(_ context.Context, id int64, roles []string) error {
+    const op = "storage.memory.SetGroupRoles"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    g, ok := s.groups[id]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+    g.Roles = cloneStrings(roles)
+    s.groups[id] = g
+
+    return nil
+}
+
+// DeleteGroup deletes the group. Its members stay, they are no longer members through it.
+func (s *Storage) DeleteGroup(_ context.Context, id int64) error {
+    const op = "storage.memory.DeleteGroup"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.groups[id]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+    delete(s.groups, id)
+
+    for edge := range s.groupUsers {
+        if edge.groupID == id {
+            delete(s.groupUsers, edge)
+        }
+    }
+    for edge := range s.groupSubgroups {
+        if edge.groupID == id || edge.memberID == id {
+            delete(s.groupSubgroups, edge)
+        }
+    }
+
+    return nil
+}
+
+// AddGroupUser makes the user a direct member of the group.
+func (s *Storage) AddGroupUser(_ context.Context, groupID, userID int64) error {
+    const op = "storage.memory.AddGroupUser"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.groups[groupID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+    if _, ok := s.users[userID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+    edge := groupEdge{groupID, userID}
+    if _, ok := s.groupUsers[edge]; ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberExists)
+    }
+    s.groupUsers[edge] = struct{}{}
+
+    return nil
+}
+
+// RemoveGroupUser removes the user from the direct members of the group.
+func (s *Storage) RemoveGroupUser(_ context.Context, groupID, userID int64) error {
+    const op = "storage.memory.RemoveGroupUser"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    edge := groupEdge{groupID, userID}
+    if _, ok := s.groupUsers[edge]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberNotFound)
+    }
+    delete(s.groupUsers, edge)
+
+    return nil
+}
+
+// AddSubgroup nests the subgroup in the group, unless the group is the subgroup
+// or is already nested in it, which would form a cycle.
+func (s *Storage) AddSubgroup(_ context.Context, groupID, subgroupID int64) error {
+    const op = "storage.memory.AddSubgroup"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.groups[groupID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+    if _, ok := s.groups[subgroupID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+    edge := groupEdge{groupID, subgroupID}
+    if _, ok := s.groupSubgroups[edge]; ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberExists)
+    }
+
+    // The group must not be reachable from the subgroup.
+    seen := map[int64]bool{subgroupID: true}
+    queue := []int64{subgroupID}
+    for len(queue) > 0 {
+        id := queue[0]
+        queue = queue[1:]
+        if id == groupID {
+            return fmt.Errorf("%s: %w", op, storage.ErrGroupCycle)
+        }
+        for e := range s.groupSubgroups {
+            if e.groupID == id && !seen[e.memberID] {
+                seen[e.memberID] = true
+                queue = append(queue, e.memberID)
+            }
+        }
+    }
+
+    s.groupSubgroups[edge] = struct{}{}
+
+    return nil
+}
+
+// RemoveSubgroup removes the subgroup from the group.
+func (s *Storage) RemoveSubgroup(_ context.Context, groupID, subgroupID int64) error {
+    const op = "storage.memory.RemoveSubgroup"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    edge := groupEdge{groupID, subgroupID}
+    if _, ok := s.groupSubgroups[edge]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberNotFound)
+    }
+    delete(s.groupSubgroups, edge)
+
+    return nil
+}
+
+// GroupMembers returns the direct members of the group.
+func (s *Storage) GroupMembers(_ context.Context, id int64) (models.GroupMembers, error) {
+    const op = "storage.memory.GroupMembers"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.groups[id]; !ok {
+        return models.GroupMembers{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+
+    var members models.GroupMembers
+    for edge := range s.groupUsers {
+        if edge.groupID == id {
+            members.UserIDs = append(members.UserIDs, edge.memberID)
+        }
+    }
+    for edge := range s.groupSubgroups {
+        if edge.groupID == id {
+            members.GroupIDs = append(members.GroupIDs, edge.memberID)
+        }
+    }
+    slices.Sort(members.UserIDs)
+    slices.Sort(members.GroupIDs)
+
+    return members, nil
+}
+
+// UserGroups returns the groups the user is a member of, directly or through nested groups,
+// ordered by id, each with one of the shortest paths that make the user a member.
+func (s *Storage) UserGroups(_ context.Context, userID int64) ([]models.UserGroup, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    paths := make(map[int64][]int64)
+    var queue []int64
+    for edge := range s.groupUsers {
+        if edge.memberID == userID {
+            paths[edge.groupID] = []int64{edge.groupID}
+            queue = append(queue, edge.groupID)
+        }
+    }
+    // Visiting groups in order of id makes the paths picked among the shortest ones deterministic.
+    slices.Sort(queue)
+
+    for len(queue) > 0 {
+        id := queue[0]
+        queue = queue[1:]
+
+        var parents []int64
+        for edge := range s.groupSubgroups {
+            if edge.memberID == id {
+                parents = append(parents, edge.groupID)
+            }
+        }
+        slices.Sort(parents)
+        for _, parent := range parents {
+            if _, ok := paths[parent]; ok {
+                continue
+            }
+            paths[parent] = append(slices.Clone(paths[id]), parent)
+            queue = append(queue, parent)
+        }
+    }
+
+    groups := make([]models.UserGroup, 0, len(paths))
+    for id, path := range paths {
+        g := s.groups[id]
+        g.Roles = cloneStrings(g.Roles)
+        groups = append(groups, models.UserGroup{Group: g, Path: path})
+    }
+    slices.SortFunc(groups, func(a, b models.UserGroup) int { return cmp.Compare(a.Group.ID, b.Group.ID) })
+
+    return groups, nil
+}
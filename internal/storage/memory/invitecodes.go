@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"time"
+)
+
+// SaveInviteCode saves an unused invite code and returns its id.
+func (s *Storage) SaveInviteCode(_ context.Context, code models.InviteCode) (int64, error) {
+    const op = "storage.memory.SaveInviteCode"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.orgs[code.OrgID]; code.OrgID != 0 && !ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+    }
+
+    code.ID = s.nextID("invite_codes")
+    code = cloneInviteCode(code)
+    code.UsedAt, code.UsedBy, code.RevokedAt = time.Time{}, 0, time.Time{}
+    s.inviteCodes[code.ID] = code
+
+    return code.ID, nil
+}
+
+// InviteCodeByHash returns the invite code with the hash, whether it is pending or not.
+func (s *Storage) InviteCodeByHash(_ context.Context, hash []byte) (models.InviteCode, error) {
+    const op = "storage.memory.InviteCodeByHash"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, code := range s.inviteCodes {
+        if slices.Equal(code.Hash, hash) {
+            return cloneInviteCode(code), nil
+        }
+    }
+
+    return models.InviteCode{}, fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+}
+
+// RevokeInviteCode revokes the pending invite code.
+func (s *Storage) RevokeInviteCode(_ context.Context, id int64, revokedAt time.Time) error {
+    const op = "storage.memory.RevokeInviteCode"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    code, ok := s.inviteCodes[id]
+    if !ok || !code.Pending(revokedAt) {
+        return fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+    }
+    code.RevokedAt = revokedAt
+    s.inviteCodes[id] = code
+
+    return nil
+}
+
+// CreateInvitedUser creates the user like CreateUser and marks the pending invite code used by it,
+// giving the user the roles and the membership of the code. Nothing is created unless the code is pending.
+func (s *Storage) CreateInvitedUser(
+    _ context.Context,
+    ids models.UserIdentifiers,
+    passHash []byte,
+    codeID int64,
+    usedAt time.Time,
+) (int64, error) {
+    const op = "storage.memory.CreateInvitedUser"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    code, ok := s.inviteCodes[codeID]
+    if !ok || !code.Pending(usedAt) {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+    }
+
+    id, err := s.createUser(ids, passHash)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user := s.users[id]
+    user.Roles = append(user.Roles, code.Roles...)
+    s.users[id] = user
+
+    if code.OrgID != 0 {
+        s.members[memberKey{code.OrgID, id}] = models.Membership{
+            OrgID:     code.OrgID,
+            UserID:    id,
+            Roles:     cloneStrings(code.OrgRoles),
+            CreatedAt: usedAt,
+        }
+    }
+
+    code.UsedAt, code.UsedBy = usedAt, id
+    s.inviteCodes[codeID] = code
+
+    return id, nil
+}
+
+func cloneInviteCode(code models.InviteCode) models.InviteCode {
+    code.Roles = cloneStrings(code.Roles)
+    code.OrgRoles = cloneStrings(code.OrgRoles)
+    code.Hash = slices.Clone(code.Hash)
+    return code
+}
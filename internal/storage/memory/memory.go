@@ -0,0 +1,542 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage keeps its data in the memory of the process, which loses it on exit.
+// It is meant for tests and local development and behaves like the Postgres storage,
+// including its errors.
+type Storage struct {
+    mu sync.Mutex
+
+    // ids holds the last id given out per table.
+    ids map[string]int64
+
+    users       map[int64]models.User
+    identities  []identity
+    transitions []models.StatusTransition
+    sessions    map[string]session
+    apiKeys     map[int64]apiKey
+
+    serviceAccounts map[int64]models.ServiceAccount
+
+    auditLog  []models.AuditEvent
+    auditKeys map[string][]byte
+    outbox    []models.OutboxEvent
+
+    webhookSubscriptions map[int64]models.WebhookSubscription
+    webhookDeliveries    map[int64]models.WebhookDelivery
+    webhookAttempts      []models.WebhookAttempt
+
+    phoneCodes map[int64]models.PhoneCode
+
+    dataJobs map[int64]models.DataJob
+
+    orgs           map[int64]models.Organization
+    members        map[memberKey]models.Membership
+    orgInvitations map[int64]models.OrgInvitation
+
+    inviteCodes map[int64]models.InviteCode
+
+    groups         map[int64]models.Group
+    groupUsers     map[groupEdge]struct{}
+    groupSubgroups map[groupEdge]struct{}
+
+    relationships        map[models.Relationship]struct{}
+    relationshipRevision int64
+}
+
+type identity struct {
+    id        int64
+    userID    int64
+    provider  string
+    subject   string
+    createdAt time.Time
+}
+
+type session struct {
+    models.Session
+    revokedAt time.Time
+}
+
+type apiKey struct {
+    models.APIKey
+    revokedAt time.Time
+}
+
+// New creates a new empty in-memory storage.
+func New() *Storage {
+    return &Storage{
+        ids:                  make(map[string]int64),
+        users:                make(map[int64]models.User),
+        sessions:             make(map[string]session),
+        apiKeys:              make(map[int64]apiKey),
+        serviceAccounts:      make(map[int64]models.ServiceAccount),
+        auditKeys:            make(map[string][]byte),
+        webhookSubscriptions: make(map[int64]models.WebhookSubscription),
+        webhookDeliveries:    make(map[int64]models.WebhookDelivery),
+        phoneCodes:           make(map[int64]models.PhoneCode),
+        dataJobs:             make(map[int64]models.DataJob),
+        orgs:                 make(map[int64]models.Organization),
+        members:              make(map[memberKey]models.Membership),
+        orgInvitations:       make(map[int64]models.OrgInvitation),
+        inviteCodes:          make(map[int64]models.InviteCode),
+        groups:               make(map[int64]models.Group),
+        groupUsers:           make(map[groupEdge]struct{}),
+        groupSubgroups:       make(map[groupEdge]struct{}),
+        relationships:        make(map[models.Relationship]struct{}),
+    }
+}
+
+// Close does nothing, the data stays available until the storage is garbage collected.
+func (s *Storage) Close() error {
+    return nil
+}
+
+// SaveUser creates the user with the email and publishes a user.registered event.
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (int64, error) {
+    return s.CreateUser(ctx, models.UserIdentifiers{Email: email}, passHash)
+}
+
+// CreateUser creates the user with the identifiers and publishes a user.registered event.
+func (s *Storage) CreateUser(_ context.Context, ids models.UserIdentifiers, passHash []byte) (int64, error) {
+    const op = "storage.memory.CreateUser"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    id, err := s.createUser(ids, passHash)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// createUser creates the user and publishes a user.registered event. The caller holds the lock.
+func (s *Storage) createUser(ids models.UserIdentifiers, passHash []byte) (int64, error) {
+    for _, user := range s.users {
+        switch {
+        case sameIdentifier(user.Email, ids.Email, true):
+            return 0, storage.ErrUserExists
+        case sameIdentifier(user.Username, ids.Username, true):
+            return 0, storage.ErrUsernameTaken
+        case sameIdentifier(user.Phone, ids.Phone, false):
+            return 0, storage.ErrPhoneTaken
+        }
+    }
+
+    id := s.nextID("users")
+    s.users[id] = models.User{
+        ID:        id,
+        Email:     ids.Email,
+        Username:  ids.Username,
+        Phone:     ids.Phone,
+        PassHash:  slices.Clone(passHash),
+        Roles:     []string{},
+        Status:    models.UserStatusActive,
+        CreatedAt: time.Now(),
+    }
+
+    err := s.enqueue(models.DomainEventUserRegistered, id, models.UserRegistered{UserID: id})
+    if err != nil {
+        return 0, err
+    }
+
+    return id, nil
+}
+
+// User returns user by email, compared case-insensitively.
+func (s *Storage) User(_ context.Context, email string) (models.User, error) {
+    const op = "storage.memory.User"
+
+    return s.userBy(op, func(user models.User) bool { return sameIdentifier(user.Email, email, true) })
+}
+
+// UserByUsername returns user by username, compared case-insensitively.
+func (s *Storage) UserByUsername(_ context.Context, username string) (models.User, error) {
+    const op = "storage.memory.UserByUsername"
+
+    return s.userBy(op, func(user models.User) bool { return sameIdentifier(user.Username, username, true) })
+}
+
+// UserByPhone returns user by E.164 phone number.
+func (s *Storage) UserByPhone(_ context.Context, phone string) (models.User, error) {
+    const op = "storage.memory.UserByPhone"
+
+    return s.userBy(op, func(user models.User) bool { return sameIdentifier(user.Phone, phone, false) })
+}
+
+func (s *Storage) userBy(op string, match func(user models.User) bool) (models.User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, user := range s.users {
+        if match(user) {
+            return cloneUser(user), nil
+        }
+    }
+
+    return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+}
+
+// sameIdentifier reports whether the identifiers match. Missing identifiers match nothing,
+// like the NULLs of the SQL storages.
+func sameIdentifier(a, b string, foldCase bool) bool {
+    if a == "" || b == "" {
+        return false
+    }
+    if foldCase {
+        return strings.EqualFold(a, b)
+    }
+    return a == b
+}
+
+// UserByIdentity returns the user linked to the subject at the external identity provider.
+func (s *Storage) UserByIdentity(_ context.Context, provider, subject string) (models.User, error) {
+    const op = "storage.memory.UserByIdentity"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, ident := range s.identities {
+        if ident.provider == provider && ident.subject == subject {
+            if user, ok := s.users[ident.userID]; ok {
+                return cloneUser(user), nil
+            }
+        }
+    }
+
+    return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+}
+
+// UserByID returns user by id.
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+    const op = "storage.memory.UserByID"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[id]
+    if !ok || !s.inTenant(ctx, id) {
+        return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return cloneUser(user), nil
+}
+
+// SaveIdentity links the subject at the external identity provider to the user.
+func (s *Storage) SaveIdentity(_ context.Context, userID int64, provider, subject string) error {
+    const op = "storage.memory.SaveIdentity"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[userID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    for _, ident := range s.identities {
+        if ident.provider == provider && (ident.subject == subject || ident.userID == userID) {
+            return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+        }
+    }
+
+    s.identities = append(s.identities, identity{
+        id:        s.nextID("user_identities"),
+        userID:    userID,
+        provider:  provider,
+        subject:   subject,
+        createdAt: time.Now(),
+    })
+
+    return nil
+}
+
+func (s *Storage) SaveSession(_ context.Context, sess models.Session) error {
+    const op = "storage.memory.SaveSession"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[sess.UserID]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+    if _, ok := s.sessions[sess.ID]; ok {
+        return fmt.Errorf("%s: session %s already exists", op, sess.ID)
+    }
+
+    s.sessions[sess.ID] = session{Session: sess}
+
+    return nil
+}
+
+// Session returns the session by id if it is neither revoked nor expired.
+func (s *Storage) Session(_ context.Context, id string) (models.Session, error) {
+    const op = "storage.memory.Session"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sess, ok := s.sessions[id]
+    if !ok || !sess.active(time.Now()) {
+        return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+
+    return sess.Session, nil
+}
+
+// Sessions returns active sessions of the user, most recently used first.
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]models.Session, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !s.inTenant(ctx, userID) {
+        return nil, nil
+    }
+
+    now := time.Now()
+
+    var sessions []models.Session
+    for _, sess := range s.sessions {
+        if sess.UserID == userID && sess.active(now) {
+            sessions = append(sessions, sess.Session)
+        }
+    }
+    slices.SortFunc(sessions, func(a, b models.Session) int { return b.LastSeenAt.Compare(a.LastSeenAt) })
+
+    return sessions, nil
+}
+
+// TouchSession sets the last seen time of the session.
+func (s *Storage) TouchSession(_ context.Context, id string, lastSeen time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if sess, ok := s.sessions[id]; ok {
+        sess.LastSeenAt = lastSeen
+        s.sessions[id] = sess
+    }
+
+    return nil
+}
+
+// RevokeSession revokes an active session of the user.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id string) error {
+    const op = "storage.memory.RevokeSession"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+
+    sess, ok := s.sessions[id]
+    if !ok || sess.UserID != userID || !sess.active(now) || !s.inTenant(ctx, userID) {
+        return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+    sess.revokedAt = now
+    s.sessions[id] = sess
+
+    return nil
+}
+
+// RevokeSessions revokes all active sessions of the user except the given one
+// and returns the number of revoked sessions.
+func (s *Storage) RevokeSessions(ctx context.Context, userID int64, exceptID string) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !s.inTenant(ctx, userID) {
+        return 0, nil
+    }
+
+    now := time.Now()
+
+    var n int64
+    for id, sess := range s.sessions {
+        if sess.UserID == userID && id != exceptID && sess.active(now) {
+            sess.revokedAt = now
+            s.sessions[id] = sess
+            n++
+        }
+    }
+
+    return n, nil
+}
+
+// SaveAPIKey saves the API key and returns its id.
+func (s *Storage) SaveAPIKey(_ context.Context, key models.APIKey) (int64, error) {
+    const op = "storage.memory.SaveAPIKey"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !s.principalExists(key.Principal) {
+        return 0, fmt.Errorf("%s: owner %s does not exist", op, key.Principal)
+    }
+    for _, k := range s.apiKeys {
+        if slices.Equal(k.Hash, key.Hash) {
+            return 0, fmt.Errorf("%s: api key hash already exists", op)
+        }
+    }
+
+    key.ID = s.nextID("api_keys")
+    key.Principal = models.Principal{Type: key.Principal.Type, ID: key.Principal.ID}
+    key.Hash = slices.Clone(key.Hash)
+    key.Scopes = cloneStrings(key.Scopes)
+    key.LastUsedAt = time.Time{}
+    s.apiKeys[key.ID] = apiKey{APIKey: key}
+
+    return key.ID, nil
+}
+
+// APIKeyByHash returns the API key with the hash if it is not revoked.
+func (s *Storage) APIKeyByHash(_ context.Context, hash []byte) (models.APIKey, error) {
+    const op = "storage.memory.APIKeyByHash"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, key := range s.apiKeys {
+        if key.revokedAt.IsZero() && slices.Equal(key.Hash, hash) {
+            return cloneAPIKey(key.APIKey), nil
+        }
+    }
+
+    return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+}
+
+// APIKeys returns API keys of the principal that are not revoked, newest first.
+func (s *Storage) APIKeys(ctx context.Context, owner models.Principal) ([]models.APIKey, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var keys []models.APIKey
+    for _, key := range s.apiKeys {
+        if key.revokedAt.IsZero() && sameOwner(key.Principal, owner) && s.keyInTenant(ctx, owner) {
+            keys = append(keys, cloneAPIKey(key.APIKey))
+        }
+    }
+    slices.SortFunc(keys, func(a, b models.APIKey) int {
+        if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+            return c
+        }
+        return int(b.ID - a.ID)
+    })
+
+    return keys, nil
+}
+
+// TouchAPIKey sets the last used time of the API key.
+func (s *Storage) TouchAPIKey(_ context.Context, id int64, lastUsed time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if key, ok := s.apiKeys[id]; ok {
+        key.LastUsedAt = lastUsed
+        s.apiKeys[id] = key
+    }
+
+    return nil
+}
+
+// RevokeAPIKey revokes an API key of the principal.
+func (s *Storage) RevokeAPIKey(ctx context.Context, owner models.Principal, id int64) error {
+    const op = "storage.memory.RevokeAPIKey"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key, ok := s.apiKeys[id]
+    if !ok || !key.revokedAt.IsZero() || !sameOwner(key.Principal, owner) || !s.keyInTenant(ctx, owner) {
+        return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+    }
+    key.revokedAt = time.Now()
+    s.apiKeys[id] = key
+
+    return nil
+}
+
+// keyInTenant reports whether the owner of API keys is a user of the tenant of the context;
+// service accounts belong to none. s.mu must be held.
+func (s *Storage) keyInTenant(ctx context.Context, owner models.Principal) bool {
+    if !owner.IsUser() {
+        return storage.Tenant(ctx) == 0
+    }
+    return s.inTenant(ctx, owner.ID)
+}
+
+// nextID returns the next id of the table. s.mu must be held.
+func (s *Storage) nextID(table string) int64 {
+    s.ids[table]++
+    return s.ids[table]
+}
+
+// principalExists reports whether the user or the service account exists. s.mu must be held.
+func (s *Storage) principalExists(p models.Principal) bool {
+    if p.IsUser() {
+        _, ok := s.users[p.ID]
+        return ok
+    }
+    _, ok := s.serviceAccounts[p.ID]
+    return ok
+}
+
+// enqueue writes a domain event about the user to the outbox. s.mu must be held.
+func (s *Storage) enqueue(eventType string, userID int64, payload any) error {
+    raw, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    s.outbox = append(s.outbox, models.OutboxEvent{
+        ID:            s.nextID("outbox"),
+        Type:          eventType,
+        AggregateID:   models.Principal{Type: models.PrincipalUser, ID: userID}.String(),
+        Payload:       raw,
+        Status:        models.OutboxPending,
+        NextAttemptAt: now,
+        CreatedAt:     now,
+    })
+
+    return nil
+}
+
+func (sess session) active(now time.Time) bool {
+    return sess.revokedAt.IsZero() && sess.ExpiresAt.After(now)
+}
+
+func sameOwner(a, b models.Principal) bool {
+    return a.IsUser() == b.IsUser() && a.ID == b.ID
+}
+
+// cloneStrings copies the slice, keeping a nil one empty the way the database does.
+func cloneStrings(s []string) []string {
+    if s == nil {
+        return []string{}
+    }
+    return slices.Clone(s)
+}
+
+func cloneUser(user models.User) models.User {
+    user.PassHash = slices.Clone(user.PassHash)
+    user.Roles = cloneStrings(user.Roles)
+    user.Profile.Attributes = user.Profile.Attributes.Clone()
+    return user
+}
+
+func cloneAPIKey(key models.APIKey) models.APIKey {
+    key.Hash = slices.Clone(key.Hash)
+    key.Scopes = cloneStrings(key.Scopes)
+    return key
+}
@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"testing"
+
+	"grpc-service-ref/internal/storage/memory"
+	"grpc-service-ref/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+    storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+        return memory.New()
+    })
+}
@@ -0,0 +1,261 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"time"
+)
+
+// memberKey identifies the membership of a user in an organization.
+type memberKey struct {
+    orgID  int64
+    userID int64
+}
+
+// CreateOrganization creates the organization with the owner as its first member and returns its id.
+func (s *Storage) CreateOrganization(_ context.Context, org models.Organization, owner models.Membership) (int64, error) {
+    const op = "storage.memory.CreateOrganization"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, o := range s.orgs {
+        if o.Slug == org.Slug {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgExists)
+        }
+    }
+    if _, ok := s.users[owner.UserID]; !ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    org.ID = s.nextID("organizations")
+    s.orgs[org.ID] = org
+
+    owner.OrgID = org.ID
+    owner.Roles = cloneStrings(owner.Roles)
+    s.members[memberKey{org.ID, owner.UserID}] = owner
+
+    return org.ID, nil
+}
+
+// Organization returns the organization by id.
+func (s *Storage) Organization(_ context.Context, id int64) (models.Organization, error) {
+    const op = "storage.memory.Organization"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    org, ok := s.orgs[id]
+    if !ok {
+        return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+    }
+
+    return org, nil
+}
+
+// OrganizationBySlug returns the organization by slug.
+func (s *Storage) OrganizationBySlug(_ context.Context, slug string) (models.Organization, error) {
+    const op = "storage.memory.OrganizationBySlug"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, org := range s.orgs {
+        if org.Slug == slug {
+            return org, nil
+        }
+    }
+
+    return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+}
+
+// UserOrganizations returns the organizations the user is a member of, ordered by id.
+func (s *Storage) UserOrganizations(_ context.Context, userID int64) ([]models.UserOrganization, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.userOrganizations(userID), nil
+}
+
+// userOrganizations returns the organizations the user is a member of. s.mu must be held.
+func (s *Storage) userOrganizations(userID int64) []models.UserOrganization {
+    var orgs []models.UserOrganization
+    for key, m := range s.members {
+        if key.userID == userID {
+            orgs = append(orgs, models.UserOrganization{
+                Organization: s.orgs[key.orgID],
+                Roles:        cloneStrings(m.Roles),
+                JoinedAt:     m.CreatedAt,
+            })
+        }
+    }
+    slices.SortFunc(orgs, func(a, b models.UserOrganization) int {
+        return cmp.Compare(a.Organization.ID, b.Organization.ID)
+    })
+
+    return orgs
+}
+
+// Membership returns the membership of the user in the organization.
+func (s *Storage) Membership(_ context.Context, orgID, userID int64) (models.Membership, error) {
+    const op = "storage.memory.Membership"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    m, ok := s.members[memberKey{orgID, userID}]
+    if !ok {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+    }
+    m.Roles = cloneStrings(m.Roles)
+
+    return m, nil
+}
+
+// Members returns up to limit members of the organization with user ids greater than afterUserID,
+// ordered by user id.
+func (s *Storage) Members(_ context.Context, orgID, afterUserID int64, limit int) ([]models.Membership, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var members []models.Membership
+    for key, m := range s.members {
+        if key.orgID == orgID && key.userID > afterUserID {
+            m.Roles = cloneStrings(m.Roles)
+            members = append(members, m)
+        }
+    }
+    slices.SortFunc(members, func(a, b models.Membership) int { return cmp.Compare(a.UserID, b.UserID) })
+    if len(members) > limit {
+        members = members[:limit]
+    }
+
+    return members, nil
+}
+
+// SetMemberRoles replaces the roles of the member of the organization.
+func (s *Storage) SetMemberRoles(_ context.Context, orgID, userID int64, roles []string) error {
+    const op = "storage.memory.SetMemberRoles"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key := memberKey{orgID, userID}
+    m, ok := s.members[key]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+    }
+    m.Roles = cloneStrings(roles)
+    s.members[key] = m
+
+    return nil
+}
+
+// RemoveMember removes the user from the organization.
+func (s *Storage) RemoveMember(_ context.Context, orgID, userID int64) error {
+    const op = "storage.memory.RemoveMember"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key := memberKey{orgID, userID}
+    if _, ok := s.members[key]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+    }
+    delete(s.members, key)
+
+    return nil
+}
+
+// SaveOrgInvitation saves a pending invitation to the organization and returns its id.
+func (s *Storage) SaveOrgInvitation(_ context.Context, inv models.OrgInvitation) (int64, error) {
+    const op = "storage.memory.SaveOrgInvitation"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.orgs[inv.OrgID]; !ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+    }
+
+    inv.ID = s.nextID("org_invitations")
+    inv.Roles = cloneStrings(inv.Roles)
+    inv.Hash = slices.Clone(inv.Hash)
+    inv.AcceptedAt, inv.AcceptedBy, inv.RevokedAt = time.Time{}, 0, time.Time{}
+    s.orgInvitations[inv.ID] = inv
+
+    return inv.ID, nil
+}
+
+// OrgInvitationByHash returns the invitation with the token hash, whether it is pending or not.
+func (s *Storage) OrgInvitationByHash(_ context.Context, hash []byte) (models.OrgInvitation, error) {
+    const op = "storage.memory.OrgInvitationByHash"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, inv := range s.orgInvitations {
+        if slices.Equal(inv.Hash, hash) {
+            return cloneOrgInvitation(inv), nil
+        }
+    }
+
+    return models.OrgInvitation{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+}
+
+// RevokeOrgInvitation revokes the pending invitation to the organization.
+func (s *Storage) RevokeOrgInvitation(_ context.Context, orgID, id int64, revokedAt time.Time) error {
+    const op = "storage.memory.RevokeOrgInvitation"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    inv, ok := s.orgInvitations[id]
+    if !ok || inv.OrgID != orgID || !inv.Pending(revokedAt) {
+        return fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+    }
+    inv.RevokedAt = revokedAt
+    s.orgInvitations[id] = inv
+
+    return nil
+}
+
+// AcceptOrgInvitation marks the pending invitation accepted by the user and makes the user a member
+// of the organization with the roles of the invitation. Invitations of members stay pending.
+func (s *Storage) AcceptOrgInvitation(_ context.Context, id, userID int64, acceptedAt time.Time) (models.Membership, error) {
+    const op = "storage.memory.AcceptOrgInvitation"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    inv, ok := s.orgInvitations[id]
+    if !ok || !inv.Pending(acceptedAt) {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+    }
+    if _, ok := s.users[userID]; !ok {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+    key := memberKey{inv.OrgID, userID}
+    if _, ok := s.members[key]; ok {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+    }
+
+    inv.AcceptedAt, inv.AcceptedBy = acceptedAt, userID
+    s.orgInvitations[id] = inv
+
+    m := models.Membership{OrgID: inv.OrgID, UserID: userID, Roles: cloneStrings(inv.Roles), CreatedAt: acceptedAt}
+    s.members[key] = m
+    m.Roles = cloneStrings(m.Roles)
+
+    return m, nil
+}
+
+func cloneOrgInvitation(inv models.OrgInvitation) models.OrgInvitation {
+    inv.Roles = cloneStrings(inv.Roles)
+    inv.Hash = slices.Clone(inv.Hash)
+    return inv
+}
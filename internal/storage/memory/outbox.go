@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"grpc-service-ref/internal/domain/models"
+	"slices"
+	"time"
+)
+
+// ClaimOutboxEvents returns up to limit pending events that are due, oldest first, and
+// postpones their next attempt by the lease so that concurrent relays skip them while
+// they are being delivered.
+func (s *Storage) ClaimOutboxEvents(_ context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+
+    var events []models.OutboxEvent
+    for i := range s.outbox {
+        if len(events) == limit {
+            break
+        }
+        e := &s.outbox[i]
+        if e.Status != models.OutboxPending || e.NextAttemptAt.After(now) {
+            continue
+        }
+        e.NextAttemptAt = now.Add(lease)
+        claimed := *e
+        claimed.Payload = slices.Clone(e.Payload)
+        events = append(events, claimed)
+    }
+
+    return events, nil
+}
+
+// MarkOutboxDelivered records a successful delivery of the event.
+func (s *Storage) MarkOutboxDelivered(_ context.Context, id int64, deliveredAt time.Time) error {
+    s.updateOutbox(id, func(e *models.OutboxEvent) {
+        e.Status = models.OutboxDelivered
+        e.Attempts++
+        e.LastError = ""
+        e.DeliveredAt = deliveredAt
+    })
+
+    return nil
+}
+
+// MarkOutboxFailed records a failed delivery of the event and schedules the next attempt.
+func (s *Storage) MarkOutboxFailed(_ context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+    s.updateOutbox(id, func(e *models.OutboxEvent) {
+        e.Attempts++
+        e.LastError = lastErr
+        e.NextAttemptAt = nextAttemptAt
+    })
+
+    return nil
+}
+
+// MarkOutboxDead records the last failed delivery of the event and stops retrying it.
+func (s *Storage) MarkOutboxDead(_ context.Context, id int64, lastErr string) error {
+    s.updateOutbox(id, func(e *models.OutboxEvent) {
+        e.Status = models.OutboxDead
+        e.Attempts++
+        e.LastError = lastErr
+    })
+
+    return nil
+}
+
+// updateOutbox applies the update to the event with the id, if there is one.
+func (s *Storage) updateOutbox(id int64, update func(e *models.OutboxEvent)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    // Events are appended in id order, ids start at 1.
+    if i := int(id) - 1; i >= 0 && i < len(s.outbox) {
+        update(&s.outbox[i])
+    }
+}
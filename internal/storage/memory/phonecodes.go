@@ -0,0 +1,137 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"time"
+)
+
+// SavePhoneCode saves the one-time code and returns its id.
+func (s *Storage) SavePhoneCode(_ context.Context, code models.PhoneCode) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    code.ID = s.nextID("phone_codes")
+    code.Hash = slices.Clone(code.Hash)
+    code.Attempts = 0
+    code.ConsumedAt = time.Time{}
+    s.phoneCodes[code.ID] = code
+
+    return code.ID, nil
+}
+
+// LatestPhoneCode returns the code for the purpose last sent to the phone number, used or not.
+// With a user id, it returns the code last sent for that user only.
+func (s *Storage) LatestPhoneCode(
+    _ context.Context,
+    phone string,
+    purpose models.PhoneCodePurpose,
+    userID int64,
+) (models.PhoneCode, error) {
+    const op = "storage.memory.LatestPhoneCode"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var latest models.PhoneCode
+    for _, code := range s.phoneCodes {
+        if code.Phone != phone || code.Purpose != purpose || (userID != 0 && code.UserID != userID) {
+            continue
+        }
+        if code.ID > latest.ID {
+            latest = code
+        }
+    }
+    if latest.ID == 0 {
+        return models.PhoneCode{}, fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+    }
+
+    latest.Hash = slices.Clone(latest.Hash)
+
+    return latest, nil
+}
+
+// CountPhoneCodes returns the number of codes sent to the phone number since the time, for any purpose.
+func (s *Storage) CountPhoneCodes(_ context.Context, phone string, since time.Time) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var n int
+    for _, code := range s.phoneCodes {
+        if code.Phone == phone && !code.CreatedAt.Before(since) {
+            n++
+        }
+    }
+
+    return n, nil
+}
+
+// AddPhoneCodeAttempt counts a guess of the code and returns the number of guesses so far.
+func (s *Storage) AddPhoneCodeAttempt(_ context.Context, id int64) (int, error) {
+    const op = "storage.memory.AddPhoneCodeAttempt"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    code, ok := s.phoneCodes[id]
+    if !ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+    }
+
+    code.Attempts++
+    s.phoneCodes[id] = code
+
+    return code.Attempts, nil
+}
+
+// ConsumePhoneCode marks the code used. Codes are used once, so a code already used is not found.
+func (s *Storage) ConsumePhoneCode(_ context.Context, id int64, consumedAt time.Time) error {
+    const op = "storage.memory.ConsumePhoneCode"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    code, ok := s.phoneCodes[id]
+    if !ok || !code.ConsumedAt.IsZero() {
+        return fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+    }
+
+    code.ConsumedAt = consumedAt
+    s.phoneCodes[id] = code
+
+    return nil
+}
+
+// VerifyUserPhone sets the phone number of the user, verified at the time,
+// and publishes a user.phone_verified event.
+func (s *Storage) VerifyUserPhone(_ context.Context, userID int64, phone string, verifiedAt time.Time) error {
+    const op = "storage.memory.VerifyUserPhone"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    for _, other := range s.users {
+        if other.ID != userID && sameIdentifier(other.Phone, phone, false) {
+            return fmt.Errorf("%s: %w", op, storage.ErrPhoneTaken)
+        }
+    }
+
+    err := s.enqueue(models.DomainEventPhoneVerified, userID, models.PhoneVerified{UserID: userID})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    user.Phone = phone
+    user.PhoneVerifiedAt = verifiedAt
+    s.users[userID] = user
+
+    return nil
+}
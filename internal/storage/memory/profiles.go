@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+)
+
+// UpdateProfile applies the update to the profile of the user, publishes a user.profile_updated event
+// and returns the updated profile.
+func (s *Storage) UpdateProfile(_ context.Context, userID int64, update models.ProfileUpdate) (models.Profile, error) {
+    const op = "storage.memory.UpdateProfile"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[userID]
+    if !ok {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    err := s.enqueue(models.DomainEventProfileUpdated, userID, models.ProfileUpdated{
+        UserID: userID,
+        Fields: update.Paths(),
+    })
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    user.Profile = user.Profile.Apply(update)
+    s.users[userID] = user
+
+    profile := user.Profile
+    profile.Attributes = profile.Attributes.Clone()
+
+    return profile, nil
+}
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"strings"
+)
+
+// WriteRelationships applies the updates at once and returns the revision of the write.
+func (s *Storage) WriteRelationships(_ context.Context, updates []models.RelationshipUpdate) (int64, error) {
+    const op = "storage.memory.WriteRelationships"
+
+    for _, u := range updates {
+        if u.Operation != models.RelationshipTouch && u.Operation != models.RelationshipDelete {
+            return 0, fmt.Errorf("%s: unknown operation %q", op, u.Operation)
+        }
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, u := range updates {
+        if u.Operation == models.RelationshipTouch {
+            s.relationships[u.Relationship] = struct{}{}
+        } else {
+            delete(s.relationships, u.Relationship)
+        }
+    }
+    s.relationshipRevision++
+
+    return s.relationshipRevision, nil
+}
+
+// Relationships returns the relationships matching any of the filters, ordered by their columns,
+// and the revision they are read at, which is never older than minRevision.
+func (s *Storage) Relationships(
+    _ context.Context,
+    filters []models.RelationshipFilter,
+    minRevision int64,
+) ([]models.Relationship, int64, error) {
+    const op = "storage.memory.Relationships"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if minRevision > s.relationshipRevision {
+        return nil, 0, fmt.Errorf("%s: %w", op, storage.ErrRevisionNotFound)
+    }
+
+    var rels []models.Relationship
+    for r := range s.relationships {
+        if slices.ContainsFunc(filters, func(f models.RelationshipFilter) bool { return f.Matches(r) }) {
+            rels = append(rels, r)
+        }
+    }
+    slices.SortFunc(rels, func(a, b models.Relationship) int {
+        return cmp.Or(
+            strings.Compare(a.Resource.Type, b.Resource.Type),
+            strings.Compare(a.Resource.ID, b.Resource.ID),
+            strings.Compare(a.Relation, b.Relation),
+            strings.Compare(a.Subject.Object.Type, b.Subject.Object.Type),
+            strings.Compare(a.Subject.Object.ID, b.Subject.Object.ID),
+            strings.Compare(a.Subject.Relation, b.Subject.Relation),
+        )
+    })
+
+    return rels, s.relationshipRevision, nil
+}
@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"maps"
+	"slices"
+	"time"
+)
+
+// SaveServiceAccount saves the service account and returns its id.
+func (s *Storage) SaveServiceAccount(_ context.Context, sa models.ServiceAccount) (int64, error) {
+    const op = "storage.memory.SaveServiceAccount"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, other := range s.serviceAccounts {
+        if other.ClientID == sa.ClientID || (other.Owner == sa.Owner && other.Name == sa.Name) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountExists)
+        }
+    }
+
+    sa.ID = s.nextID("service_accounts")
+    sa.DisabledAt = time.Time{}
+    s.serviceAccounts[sa.ID] = cloneServiceAccount(sa)
+
+    return sa.ID, nil
+}
+
+// ServiceAccount returns the service account by id.
+func (s *Storage) ServiceAccount(_ context.Context, id int64) (models.ServiceAccount, error) {
+    const op = "storage.memory.ServiceAccount"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sa, ok := s.serviceAccounts[id]
+    if !ok {
+        return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+    }
+
+    return cloneServiceAccount(sa), nil
+}
+
+// ServiceAccountByClientID returns the service account by its client id.
+func (s *Storage) ServiceAccountByClientID(_ context.Context, clientID string) (models.ServiceAccount, error) {
+    const op = "storage.memory.ServiceAccountByClientID"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, sa := range s.serviceAccounts {
+        if sa.ClientID == clientID {
+            return cloneServiceAccount(sa), nil
+        }
+    }
+
+    return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+}
+
+// ServiceAccounts returns service accounts ordered by id.
+// An owner with an empty kind returns service accounts of every owner.
+func (s *Storage) ServiceAccounts(_ context.Context, owner models.Owner) ([]models.ServiceAccount, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var accounts []models.ServiceAccount
+    for _, id := range slices.Sorted(maps.Keys(s.serviceAccounts)) {
+        sa := s.serviceAccounts[id]
+        if owner.Kind == "" || sa.Owner == owner {
+            accounts = append(accounts, cloneServiceAccount(sa))
+        }
+    }
+
+    return accounts, nil
+}
+
+// UpdateServiceAccountRoles replaces the roles of the service account.
+func (s *Storage) UpdateServiceAccountRoles(_ context.Context, id int64, roles []string) error {
+    const op = "storage.memory.UpdateServiceAccountRoles"
+
+    return s.updateServiceAccount(op, id, func(sa *models.ServiceAccount) bool {
+        sa.Roles = cloneStrings(roles)
+        return true
+    })
+}
+
+// UpdateServiceAccountSecret replaces the client secret hash of the service account.
+func (s *Storage) UpdateServiceAccountSecret(_ context.Context, id int64, secretHash []byte) error {
+    const op = "storage.memory.UpdateServiceAccountSecret"
+
+    return s.updateServiceAccount(op, id, func(sa *models.ServiceAccount) bool {
+        sa.SecretHash = slices.Clone(secretHash)
+        return true
+    })
+}
+
+// DisableServiceAccount disables the service account if it is enabled.
+func (s *Storage) DisableServiceAccount(_ context.Context, id int64, disabledAt time.Time) error {
+    const op = "storage.memory.DisableServiceAccount"
+
+    return s.updateServiceAccount(op, id, func(sa *models.ServiceAccount) bool {
+        if !sa.DisabledAt.IsZero() {
+            return false
+        }
+        sa.DisabledAt = disabledAt
+        return true
+    })
+}
+
+// updateServiceAccount applies the update to the service account.
+// An update that reports false leaves the account as it was and fails with ErrServiceAccountNotFound.
+func (s *Storage) updateServiceAccount(op string, id int64, update func(sa *models.ServiceAccount) bool) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sa, ok := s.serviceAccounts[id]
+    if !ok || !update(&sa) {
+        return fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+    }
+    s.serviceAccounts[id] = sa
+
+    return nil
+}
+
+func cloneServiceAccount(sa models.ServiceAccount) models.ServiceAccount {
+    sa.SecretHash = slices.Clone(sa.SecretHash)
+    sa.Roles = cloneStrings(sa.Roles)
+    return sa
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Users returns up to limit users matching the filter with ids greater than afterID, ordered by id.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter, afterID int64, limit int) ([]models.User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var users []models.User
+    for _, id := range slices.Sorted(maps.Keys(s.users)) {
+        if len(users) == limit {
+            break
+        }
+        user := s.users[id]
+        if id <= afterID ||
+            !strings.HasPrefix(strings.ToLower(user.Email), strings.ToLower(filter.EmailPrefix)) ||
+            (filter.Status != "" && user.Status != filter.Status) ||
+            (filter.Role != "" && !slices.Contains(user.Roles, filter.Role)) ||
+            (filter.OrgID != 0 && !s.isMember(filter.OrgID, id)) ||
+            !s.inTenant(ctx, id) {
+            continue
+        }
+        users = append(users, cloneUser(user))
+    }
+
+    return users, nil
+}
+
+// isMember reports whether the user is a member of the organization. s.mu must be held.
+func (s *Storage) isMember(orgID, userID int64) bool {
+    _, ok := s.members[memberKey{orgID, userID}]
+    return ok
+}
+
+// inTenant reports whether the user is a member of the tenant of the context, see storage.WithTenant.
+// Without a tenant it holds for every user. s.mu must be held.
+func (s *Storage) inTenant(ctx context.Context, userID int64) bool {
+    orgID := storage.Tenant(ctx)
+    return orgID == 0 || s.isMember(orgID, userID)
+}
+
+// ChangeUserStatus applies the status transition to the user and records it.
+// The transition is applied only if the user still has the status it starts from,
+// otherwise ErrStatusConflict is returned.
+func (s *Storage) ChangeUserStatus(ctx context.Context, t models.StatusTransition) error {
+    const op = "storage.memory.ChangeUserStatus"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[t.UserID]
+    if !ok || !s.inTenant(ctx, t.UserID) {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+    if user.Status != t.From {
+        return fmt.Errorf("%s: %w", op, storage.ErrStatusConflict)
+    }
+
+    err := s.enqueue(models.DomainEventUserStatusChanged, t.UserID, models.UserStatusChanged{
+        UserID: t.UserID,
+        From:   t.From,
+        To:     t.To,
+        Reason: t.Reason,
+    })
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    user.Status = t.To
+    user.StatusReason = t.Reason
+    user.SuspendedUntil = t.Until
+    s.users[t.UserID] = user
+
+    t.ID = s.nextID("user_status_transitions")
+    s.transitions = append(s.transitions, t)
+
+    return nil
+}
+
+// StatusTransitions returns the status transitions of the user, oldest first.
+func (s *Storage) StatusTransitions(ctx context.Context, userID int64) ([]models.StatusTransition, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !s.inTenant(ctx, userID) {
+        return nil, nil
+    }
+
+    var transitions []models.StatusTransition
+    for _, t := range s.transitions {
+        if t.UserID == userID {
+            transitions = append(transitions, t)
+        }
+    }
+    slices.SortFunc(transitions, func(a, b models.StatusTransition) int { return cmp.Compare(a.ID, b.ID) })
+
+    return transitions, nil
+}
+
+// SetUserRoles replaces the roles of the user and publishes a user.roles_changed event.
+func (s *Storage) SetUserRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.memory.SetUserRoles"
+
+    return s.updateUser(ctx, op, id,
+        models.DomainEventUserRolesChanged, models.UserRolesChanged{UserID: id, Roles: roles},
+        func(user *models.User) { user.Roles = cloneStrings(roles) },
+    )
+}
+
+// UpdatePassword replaces the password hash of the user and publishes a user.password_changed event.
+func (s *Storage) UpdatePassword(ctx context.Context, id int64, passHash []byte) error {
+    const op = "storage.memory.UpdatePassword"
+
+    return s.updateUser(ctx, op, id,
+        models.DomainEventPasswordChanged, models.PasswordChanged{UserID: id},
+        func(user *models.User) { user.PassHash = slices.Clone(passHash) },
+    )
+}
+
+// updateUser applies the update to the user and publishes the event along with it.
+func (s *Storage) updateUser(
+    ctx context.Context,
+    op string,
+    id int64,
+    eventType string,
+    payload any,
+    update func(user *models.User),
+) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    user, ok := s.users[id]
+    if !ok || !s.inTenant(ctx, id) {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    if err := s.enqueue(eventType, id, payload); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    update(&user)
+    s.users[id] = user
+
+    return nil
+}
@@ -0,0 +1,338 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"maps"
+	"slices"
+	"time"
+)
+
+// SaveWebhookSubscription saves the subscription and returns its id.
+func (s *Storage) SaveWebhookSubscription(_ context.Context, sub models.WebhookSubscription) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sub.ID = s.nextID("webhook_subscriptions")
+    sub.ConsecutiveFailures = 0
+    sub.DisabledAt = time.Time{}
+    s.webhookSubscriptions[sub.ID] = cloneWebhookSubscription(sub)
+
+    return sub.ID, nil
+}
+
+// WebhookSubscription returns the subscription by id.
+func (s *Storage) WebhookSubscription(_ context.Context, id int64) (models.WebhookSubscription, error) {
+    const op = "storage.memory.WebhookSubscription"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sub, ok := s.webhookSubscriptions[id]
+    if !ok {
+        return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookSubscriptionNotFound)
+    }
+
+    return cloneWebhookSubscription(sub), nil
+}
+
+// WebhookSubscriptions returns subscriptions of the app ordered by id.
+func (s *Storage) WebhookSubscriptions(_ context.Context, app string) ([]models.WebhookSubscription, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var subs []models.WebhookSubscription
+    for _, id := range slices.Sorted(maps.Keys(s.webhookSubscriptions)) {
+        if sub := s.webhookSubscriptions[id]; sub.App == app {
+            subs = append(subs, cloneWebhookSubscription(sub))
+        }
+    }
+
+    return subs, nil
+}
+
+// UpdateWebhookSubscription replaces the URL, the event types, the secret, the failure count
+// and the disabled time of the subscription.
+func (s *Storage) UpdateWebhookSubscription(_ context.Context, sub models.WebhookSubscription) error {
+    const op = "storage.memory.UpdateWebhookSubscription"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    stored, ok := s.webhookSubscriptions[sub.ID]
+    if !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrWebhookSubscriptionNotFound)
+    }
+    stored.URL = sub.URL
+    stored.EventTypes = cloneStrings(sub.EventTypes)
+    stored.Secret = sub.Secret
+    stored.ConsecutiveFailures = sub.ConsecutiveFailures
+    stored.DisabledAt = sub.DisabledAt
+    s.webhookSubscriptions[sub.ID] = stored
+
+    return nil
+}
+
+// DeleteWebhookSubscription deletes the subscription along with its deliveries.
+func (s *Storage) DeleteWebhookSubscription(_ context.Context, id int64) error {
+    const op = "storage.memory.DeleteWebhookSubscription"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.webhookSubscriptions[id]; !ok {
+        return fmt.Errorf("%s: %w", op, storage.ErrWebhookSubscriptionNotFound)
+    }
+    delete(s.webhookSubscriptions, id)
+
+    deleted := make(map[int64]bool)
+    for deliveryID, d := range s.webhookDeliveries {
+        if d.SubscriptionID == id {
+            delete(s.webhookDeliveries, deliveryID)
+            deleted[deliveryID] = true
+        }
+    }
+    for deliveryID, d := range s.webhookDeliveries {
+        if deleted[d.ReplayOf] {
+            d.ReplayOf = 0
+            s.webhookDeliveries[deliveryID] = d
+        }
+    }
+    s.webhookAttempts = slices.DeleteFunc(s.webhookAttempts, func(a models.WebhookAttempt) bool {
+        return deleted[a.DeliveryID]
+    })
+
+    return nil
+}
+
+// EnqueueWebhookDeliveries creates a delivery of the event for every enabled subscription to its type
+// and returns the number of created deliveries. Events that were already enqueued are skipped.
+func (s *Storage) EnqueueWebhookDeliveries(
+    _ context.Context,
+    eventID int64,
+    eventType string,
+    payload []byte,
+) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var n int64
+    for _, subID := range slices.Sorted(maps.Keys(s.webhookSubscriptions)) {
+        sub := s.webhookSubscriptions[subID]
+        if !sub.Enabled() || !slices.Contains(sub.EventTypes, eventType) || s.delivered(subID, eventID) {
+            continue
+        }
+        s.saveWebhookDelivery(models.WebhookDelivery{
+            SubscriptionID: subID,
+            EventID:        eventID,
+            EventType:      eventType,
+            Payload:        payload,
+        })
+        n++
+    }
+
+    return n, nil
+}
+
+// SaveWebhookDelivery saves a pending delivery and returns its id.
+func (s *Storage) SaveWebhookDelivery(_ context.Context, d models.WebhookDelivery) (int64, error) {
+    const op = "storage.memory.SaveWebhookDelivery"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.webhookSubscriptions[d.SubscriptionID]; !ok {
+        return 0, fmt.Errorf("%s: %w", op, storage.ErrWebhookSubscriptionNotFound)
+    }
+    if d.ReplayOf == 0 && s.delivered(d.SubscriptionID, d.EventID) {
+        return 0, fmt.Errorf("%s: event %d is already delivered to subscription %d", op, d.EventID, d.SubscriptionID)
+    }
+
+    return s.saveWebhookDelivery(d), nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries to enabled subscriptions that are due,
+// oldest first, and postpones their next attempt by the lease so that concurrent senders skip them.
+func (s *Storage) ClaimWebhookDeliveries(
+    _ context.Context,
+    limit int,
+    lease time.Duration,
+) ([]models.WebhookDelivery, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+
+    var deliveries []models.WebhookDelivery
+    for _, id := range slices.Sorted(maps.Keys(s.webhookDeliveries)) {
+        if len(deliveries) == limit {
+            break
+        }
+        d := s.webhookDeliveries[id]
+        if d.Status != models.WebhookDeliveryPending || d.NextAttemptAt.After(now) ||
+            !s.webhookSubscriptions[d.SubscriptionID].Enabled() {
+            continue
+        }
+        d.NextAttemptAt = now.Add(lease)
+        s.webhookDeliveries[id] = d
+        deliveries = append(deliveries, cloneWebhookDelivery(d))
+    }
+
+    return deliveries, nil
+}
+
+// RecordWebhookAttempt saves the attempt and moves its delivery to the status, scheduling the next
+// attempt of pending deliveries. Failed attempts are counted against the subscription, which is
+// disabled once disableAfter attempts in a row failed; successful ones reset the count.
+// It reports whether the subscription got disabled.
+func (s *Storage) RecordWebhookAttempt(
+    _ context.Context,
+    attempt models.WebhookAttempt,
+    status models.WebhookDeliveryStatus,
+    nextAttemptAt time.Time,
+    disableAfter int,
+) (bool, error) {
+    const op = "storage.memory.RecordWebhookAttempt"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    d, ok := s.webhookDeliveries[attempt.DeliveryID]
+    if !ok {
+        return false, fmt.Errorf("%s: %w", op, storage.ErrWebhookDeliveryNotFound)
+    }
+
+    attempt.ID = s.nextID("webhook_delivery_attempts")
+    // The database keeps the duration in milliseconds.
+    attempt.Duration = attempt.Duration.Truncate(time.Millisecond)
+    s.webhookAttempts = append(s.webhookAttempts, attempt)
+
+    d.Status = status
+    d.Attempts++
+    d.NextAttemptAt = nextAttemptAt
+    d.LastStatusCode = attempt.StatusCode
+    d.LastError = attempt.Error
+    d.DeliveredAt = time.Time{}
+    if status == models.WebhookDeliverySucceeded {
+        d.DeliveredAt = attempt.AttemptedAt
+    }
+    s.webhookDeliveries[d.ID] = d
+
+    sub := s.webhookSubscriptions[d.SubscriptionID]
+
+    var disabled bool
+    if attempt.Succeeded() {
+        sub.ConsecutiveFailures = 0
+    } else {
+        sub.ConsecutiveFailures++
+        if sub.Enabled() && sub.ConsecutiveFailures >= disableAfter {
+            sub.DisabledAt = attempt.AttemptedAt
+            disabled = true
+        }
+    }
+    s.webhookSubscriptions[sub.ID] = sub
+
+    return disabled, nil
+}
+
+// WebhookDelivery returns the delivery by id.
+func (s *Storage) WebhookDelivery(_ context.Context, id int64) (models.WebhookDelivery, error) {
+    const op = "storage.memory.WebhookDelivery"
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    d, ok := s.webhookDeliveries[id]
+    if !ok {
+        return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookDeliveryNotFound)
+    }
+
+    return cloneWebhookDelivery(d), nil
+}
+
+// WebhookDeliveries returns up to limit deliveries of the subscription with ids below beforeID, newest first.
+// A zero beforeID starts from the newest delivery.
+func (s *Storage) WebhookDeliveries(
+    _ context.Context,
+    subscriptionID int64,
+    beforeID int64,
+    limit int,
+) ([]models.WebhookDelivery, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    ids := slices.Sorted(maps.Keys(s.webhookDeliveries))
+    slices.Reverse(ids)
+
+    var deliveries []models.WebhookDelivery
+    for _, id := range ids {
+        if len(deliveries) == limit {
+            break
+        }
+        d := s.webhookDeliveries[id]
+        if d.SubscriptionID != subscriptionID || (beforeID != 0 && id >= beforeID) {
+            continue
+        }
+        deliveries = append(deliveries, cloneWebhookDelivery(d))
+    }
+
+    return deliveries, nil
+}
+
+// WebhookAttempts returns the attempts of the delivery, oldest first.
+func (s *Storage) WebhookAttempts(_ context.Context, deliveryID int64) ([]models.WebhookAttempt, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var attempts []models.WebhookAttempt
+    for _, a := range s.webhookAttempts {
+        if a.DeliveryID == deliveryID {
+            attempts = append(attempts, a)
+        }
+    }
+    slices.SortFunc(attempts, func(a, b models.WebhookAttempt) int { return cmp.Compare(a.ID, b.ID) })
+
+    return attempts, nil
+}
+
+// delivered reports whether the event has an original, not replayed, delivery to the subscription.
+// s.mu must be held.
+func (s *Storage) delivered(subscriptionID, eventID int64) bool {
+    for _, d := range s.webhookDeliveries {
+        if d.SubscriptionID == subscriptionID && d.EventID == eventID && d.ReplayOf == 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// saveWebhookDelivery stores a new pending delivery and returns its id. s.mu must be held.
+func (s *Storage) saveWebhookDelivery(d models.WebhookDelivery) int64 {
+    now := time.Now()
+
+    d.ID = s.nextID("webhook_deliveries")
+    d.Payload = slices.Clone(d.Payload)
+    d.Status = models.WebhookDeliveryPending
+    d.Attempts = 0
+    d.NextAttemptAt = now
+    d.LastStatusCode = 0
+    d.LastError = ""
+    d.CreatedAt = now
+    d.DeliveredAt = time.Time{}
+    s.webhookDeliveries[d.ID] = d
+
+    return d.ID
+}
+
+func cloneWebhookSubscription(sub models.WebhookSubscription) models.WebhookSubscription {
+    sub.EventTypes = cloneStrings(sub.EventTypes)
+    return sub
+}
+
+func cloneWebhookDelivery(d models.WebhookDelivery) models.WebhookDelivery {
+    d.Payload = slices.Clone(d.Payload)
+    return d
+}
@@ -0,0 +1,228 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// auditChainLock is the advisory lock key serializing appends to the audit log hash chain.
+const auditChainLock = 0x61756474
+
+// AppendAuditEvent appends the event to the audit log, chaining its hash to the last entry,
+// and returns the stored event. Personal data is sealed with the audit keys of the users it
+// belongs to, created as needed.
+func (s *Storage) AppendAuditEvent(ctx context.Context, event models.AuditEvent) (models.AuditEvent, error) {
+    const op = "storage.postgres.AppendAuditEvent"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock); err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = tx.QueryRow(ctx, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&event.PrevHash)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if event.PrevHash == nil {
+        event.PrevHash = []byte{}
+    }
+
+    keys, err := ensureAuditKeys(ctx, tx, audit.KeyRefs(event))
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    event, err = audit.Seal(event, keys)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    event.Hash = event.ComputeHash()
+
+    details := event.Details
+    if details == nil {
+        details = map[string]string{}
+    }
+    rawDetails, err := json.Marshal(details)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    rawSealed, err := json.Marshal(sealedOrEmpty(event.Sealed))
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = tx.QueryRow(ctx, `
+        INSERT INTO audit_log(type, actor, subject, ip, user_agent, outcome, reason, details, sealed, created_at, prev_hash, hash)
+        VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+        RETURNING id`,
+        event.Type, event.Actor, event.Subject, event.IP, event.UserAgent, event.Outcome, event.Reason,
+        rawDetails, rawSealed, event.CreatedAt, event.PrevHash, event.Hash,
+    ).Scan(&event.ID)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return audit.Open(event, keys), nil
+}
+
+// AuditEvents returns up to limit audit events matching the filter with ids less than beforeID,
+// newest first. A zero beforeID starts from the newest event. Personal data whose audit keys
+// were deleted is left out.
+func (s *Storage) AuditEvents(
+    ctx context.Context,
+    filter models.AuditFilter,
+    beforeID int64,
+    limit int,
+) ([]models.AuditEvent, error) {
+    const op = "storage.postgres.AuditEvents"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT id, type, actor, subject, ip, user_agent, outcome, reason, details, sealed, created_at, prev_hash, hash
+        FROM audit_log
+        WHERE ($1::bigint = 0 OR id < $1::bigint)
+          AND ($2::text = '' OR type = $2::text)
+          AND ($3::text = '' OR actor = $3::text)
+          AND ($4::text = '' OR subject = $4::text)
+          AND ($5::timestamptz IS NULL OR created_at >= $5::timestamptz)
+          AND ($6::timestamptz IS NULL OR created_at < $6::timestamptz)
+        ORDER BY id DESC
+        LIMIT $7`,
+        beforeID, filter.Type, filter.Actor, filter.Subject, nullTime(filter.Since), nullTime(filter.Until), limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var events []models.AuditEvent
+    for rows.Next() {
+        var (
+            event                 models.AuditEvent
+            rawDetails, rawSealed []byte
+        )
+        err := rows.Scan(
+            &event.ID, &event.Type, &event.Actor, &event.Subject, &event.IP, &event.UserAgent,
+            &event.Outcome, &event.Reason, &rawDetails, &rawSealed, &event.CreatedAt, &event.PrevHash, &event.Hash,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        if err := json.Unmarshal(rawDetails, &event.Details); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        if err := unmarshalSealed(rawSealed, &event); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        events = append(events, event)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    keys, err := auditKeys(ctx, s.pool, audit.SealedRefs(events...))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    for i := range events {
+        events[i] = audit.Open(events[i], keys)
+    }
+
+    return events, nil
+}
+
+// ensureAuditKeys returns the audit keys of the references by field, creating the missing ones.
+func ensureAuditKeys(ctx context.Context, tx pgx.Tx, refs map[string]string) (map[string][]byte, error) {
+    if len(refs) == 0 {
+        return nil, nil
+    }
+
+    var (
+        unique  []string
+        newKeys [][]byte
+    )
+    for _, ref := range refs {
+        if slices.Contains(unique, ref) {
+            continue
+        }
+        key, err := audit.NewKey()
+        if err != nil {
+            return nil, err
+        }
+        unique = append(unique, ref)
+        newKeys = append(newKeys, key)
+    }
+
+    _, err := tx.Exec(ctx, `
+        INSERT INTO audit_keys(ref, key)
+        SELECT * FROM unnest($1::text[], $2::bytea[])
+        ON CONFLICT (ref) DO NOTHING`,
+        unique, newKeys,
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    return auditKeys(ctx, tx, unique)
+}
+
+// auditKeys returns the existing audit keys of the references.
+func auditKeys(ctx context.Context, q querier, refs []string) (map[string][]byte, error) {
+    keys := make(map[string][]byte, len(refs))
+    if len(refs) == 0 {
+        return keys, nil
+    }
+
+    rows, err := q.Query(ctx, "SELECT ref, key FROM audit_keys WHERE ref = ANY($1)", refs)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var (
+            ref string
+            key []byte
+        )
+        if err := rows.Scan(&ref, &key); err != nil {
+            return nil, err
+        }
+        keys[ref] = key
+    }
+
+    return keys, rows.Err()
+}
+
+// sealedOrEmpty returns the sealed fields of an event, an empty map for events without any.
+func sealedOrEmpty(sealed map[string]string) map[string]string {
+    if sealed == nil {
+        return map[string]string{}
+    }
+    return sealed
+}
+
+// unmarshalSealed decodes the sealed fields of the event, which stays without them if there are none.
+func unmarshalSealed(raw []byte, event *models.AuditEvent) error {
+    var sealed map[string]string
+    if err := json.Unmarshal(raw, &sealed); err != nil {
+        return err
+    }
+    if len(sealed) > 0 {
+        event.Sealed = sealed
+    }
+    return nil
+}
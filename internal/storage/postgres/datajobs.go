@@ -0,0 +1,426 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const dataJobColumns = `id, kind, user_id, status, actor, reason, attempts, next_attempt_at, last_error, archive,
+    created_at, started_at, finished_at, expires_at`
+
+// SaveDataJob saves a pending job and returns its id.
+func (s *Storage) SaveDataJob(ctx context.Context, job models.DataJob) (int64, error) {
+    const op = "storage.postgres.SaveDataJob"
+
+    var id int64
+    err := s.pool.QueryRow(ctx, `
+        INSERT INTO data_jobs(kind, user_id, actor, reason, next_attempt_at, created_at)
+        VALUES($1, $2, $3, $4, $5, $5)
+        RETURNING id`,
+        job.Kind, job.UserID, job.Actor, job.Reason, job.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// DataJob returns the job by id along with its archive.
+func (s *Storage) DataJob(ctx context.Context, id int64) (models.DataJob, error) {
+    const op = "storage.postgres.DataJob"
+
+    job, err := scanDataJob(s.pool.QueryRow(ctx, "SELECT "+dataJobColumns+" FROM data_jobs WHERE id = $1", id))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.DataJob{}, fmt.Errorf("%s: %w", op, storage.ErrDataJobNotFound)
+        }
+
+        return models.DataJob{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return job, nil
+}
+
+// ClaimDataJobs returns up to limit unfinished jobs that are due, oldest first, marks them running
+// and postpones their next attempt by the lease so that concurrent workers skip them.
+// Jobs of a worker that stops mid-run are run again once the lease ends.
+func (s *Storage) ClaimDataJobs(ctx context.Context, limit int, lease time.Duration) ([]models.DataJob, error) {
+    const op = "storage.postgres.ClaimDataJobs"
+
+    rows, err := s.pool.Query(ctx, `
+        UPDATE data_jobs
+        SET status = 'running',
+            next_attempt_at = now() + $2 * interval '1 millisecond',
+            started_at = coalesce(started_at, now())
+        WHERE id IN (
+            SELECT id FROM data_jobs
+            WHERE status IN ('pending', 'running') AND next_attempt_at <= now()
+            ORDER BY id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+dataJobColumns,
+        limit, lease.Milliseconds(),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var jobs []models.DataJob
+    for rows.Next() {
+        job, err := scanDataJob(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        jobs = append(jobs, job)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    slices.SortFunc(jobs, func(a, b models.DataJob) int { return cmp.Compare(a.ID, b.ID) })
+
+    return jobs, nil
+}
+
+// CompleteDataJob records the success of the job with the archive of an export,
+// which is kept until expiresAt.
+func (s *Storage) CompleteDataJob(ctx context.Context, id int64, archive []byte, finishedAt, expiresAt time.Time) error {
+    const op = "storage.postgres.CompleteDataJob"
+
+    _, err := s.pool.Exec(ctx, `
+        UPDATE data_jobs
+        SET status = 'succeeded', attempts = attempts + 1, last_error = '', archive = $2,
+            finished_at = $3, expires_at = $4
+        WHERE id = $1`,
+        id, nullJSON(archive), finishedAt, nullTime(expiresAt),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RetryDataJob records a failed run of the job and schedules the next attempt.
+func (s *Storage) RetryDataJob(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+    const op = "storage.postgres.RetryDataJob"
+
+    _, err := s.pool.Exec(ctx, `
+        UPDATE data_jobs SET status = 'pending', attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+        WHERE id = $1`,
+        id, lastErr, nextAttemptAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// FailDataJob records the last failed run of the job and stops retrying it.
+func (s *Storage) FailDataJob(ctx context.Context, id int64, lastErr string, finishedAt time.Time) error {
+    const op = "storage.postgres.FailDataJob"
+
+    _, err := s.pool.Exec(ctx, `
+        UPDATE data_jobs SET status = 'failed', attempts = attempts + 1, last_error = $2, finished_at = $3
+        WHERE id = $1`,
+        id, lastErr, finishedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// PurgeDataJobArchives removes archives that expired before the time and returns their number.
+func (s *Storage) PurgeDataJobArchives(ctx context.Context, before time.Time) (int64, error) {
+    const op = "storage.postgres.PurgeDataJobArchives"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE data_jobs SET archive = NULL
+        WHERE archive IS NOT NULL AND expires_at <= $1`,
+        before,
+    )
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return tag.RowsAffected(), nil
+}
+
+// UserData returns the personal data stored about the user, read from a single snapshot of the primary.
+func (s *Storage) UserData(ctx context.Context, userID int64) (models.UserData, error) {
+    const op = "storage.postgres.UserData"
+
+    tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+    if err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var data models.UserData
+
+    data.User, err = s.scanUser(ctx, tx.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", userID))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.UserData{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if data.Identities, err = userIdentities(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.Sessions, err = userSessions(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.APIKeys, err = userAPIKeys(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.Transitions, err = statusTransitions(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.Memberships, err = userOrganizations(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return data, nil
+}
+
+// EraseUser deletes the user along with its identities, sessions, API keys, phone codes, memberships
+// and status history, and the invitations and invite codes it accepted or that are for its email.
+// It removes the archives of its exports and reduces the payloads of outbox events and webhook
+// deliveries about it to the user id. A user.erased event is published. The audit log is
+// append-only: its entries are kept and the audit keys sealing the personal data in them are
+// deleted, along with the keys of the identifiers of the user.
+func (s *Storage) EraseUser(ctx context.Context, userID int64) error {
+    const op = "storage.postgres.EraseUser"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var (
+        email, phone piiValue
+        username     string
+    )
+    err = tx.QueryRow(ctx, `
+        SELECT `+identifierColumns+`
+        FROM users
+        WHERE id = $1
+        FOR UPDATE`,
+        userID,
+    ).Scan(&email.plain, &email.sealed, &username, &phone.plain, &phone.sealed)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    ids := models.UserIdentifiers{Username: username}
+    if ids.Email, err = s.pii.open(ctx, email, columnEmail); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if ids.Phone, err = s.pii.open(ctx, phone, columnPhone); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+    redacted, err := json.Marshal(models.UserErased{UserID: userID})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    batch := &pgx.Batch{}
+    batch.Queue(`
+        UPDATE webhook_deliveries SET payload = jsonb_set(payload, '{payload}', $2::jsonb)
+        WHERE event_id IN (SELECT id FROM outbox WHERE aggregate_id = $1)`,
+        aggregate, redacted,
+    )
+    batch.Queue("UPDATE outbox SET payload = $2 WHERE aggregate_id = $1", aggregate, redacted)
+    batch.Queue("UPDATE data_jobs SET archive = NULL WHERE user_id = $1 AND archive IS NOT NULL", userID)
+    // The audit log keeps the entries of the user, with its personal data sealed by these keys.
+    batch.Queue("DELETE FROM audit_keys WHERE ref = ANY($1)", audit.ErasureRefs(userID, ids))
+    // Invitations keep their email after the user is gone, and invite codes for anyone have none.
+    batch.Queue(`
+        DELETE FROM org_invitations
+        WHERE accepted_by = $1 OR ($2 <> '' AND lower(email) = lower($2)) OR email_idx = $3`,
+        userID, ids.Email, s.pii.index(ids.Email, columnInvitationEmail),
+    )
+    batch.Queue(`
+        DELETE FROM invite_codes
+        WHERE used_by = $1 OR ($2 <> '' AND lower(email) = lower($2)) OR email_idx = $3`,
+        userID, ids.Email, s.pii.index(ids.Email, columnInviteCodeEmail),
+    )
+    // Identities, sessions, API keys, phone codes, memberships and status transitions are deleted by cascade.
+    batch.Queue("DELETE FROM users WHERE id = $1", userID)
+    if err := queueEnqueue(batch, models.DomainEventUserErased, userID, models.UserErased{UserID: userID}); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        for range batch.Len() {
+            if _, err := br.Exec(); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.wroteIdentifiers(ctx, userID, email, username, phone)
+
+    return nil
+}
+
+func userIdentities(ctx context.Context, q querier, userID int64) ([]models.Identity, error) {
+    rows, err := q.Query(ctx, `
+        SELECT id, user_id, provider, subject, created_at
+        FROM user_identities
+        WHERE user_id = $1
+        ORDER BY id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var identities []models.Identity
+    for rows.Next() {
+        var ident models.Identity
+        if err := rows.Scan(&ident.ID, &ident.UserID, &ident.Provider, &ident.Subject, &ident.CreatedAt); err != nil {
+            return nil, err
+        }
+        identities = append(identities, ident)
+    }
+
+    return identities, rows.Err()
+}
+
+func userSessions(ctx context.Context, q querier, userID int64) ([]models.UserSession, error) {
+    rows, err := q.Query(ctx, `
+        SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at
+        FROM sessions
+        WHERE user_id = $1
+        ORDER BY created_at, id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sessions []models.UserSession
+    for rows.Next() {
+        var (
+            session   models.UserSession
+            revokedAt pgtype.Timestamptz
+        )
+        err := rows.Scan(
+            &session.ID, &session.UserID, &session.UserAgent, &session.IP,
+            &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &revokedAt,
+        )
+        if err != nil {
+            return nil, err
+        }
+        session.RevokedAt = revokedAt.Time
+        sessions = append(sessions, session)
+    }
+
+    return sessions, rows.Err()
+}
+
+func userAPIKeys(ctx context.Context, q querier, userID int64) ([]models.UserAPIKey, error) {
+    rows, err := q.Query(ctx, `
+        SELECT revoked_at, `+apiKeyColumns+`
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var keys []models.UserAPIKey
+    for rows.Next() {
+        var revokedAt pgtype.Timestamptz
+        key, err := scanAPIKey(prefixedRow{Row: rows, dest: []any{&revokedAt}})
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, models.UserAPIKey{APIKey: key, RevokedAt: revokedAt.Time})
+    }
+
+    return keys, rows.Err()
+}
+
+// prefixedRow scans the leading columns of the row into dest and the rest into the destinations
+// of the Scan call, so that scan functions of a table read rows with extra columns.
+type prefixedRow struct {
+    pgx.Row
+    dest []any
+}
+
+func (r prefixedRow) Scan(dest ...any) error {
+    return r.Row.Scan(append(slices.Clip(r.dest), dest...)...)
+}
+
+func scanDataJob(row pgx.Row) (models.DataJob, error) {
+    var (
+        job                              models.DataJob
+        startedAt, finishedAt, expiresAt pgtype.Timestamptz
+    )
+
+    err := row.Scan(
+        &job.ID, &job.Kind, &job.UserID, &job.Status, &job.Actor, &job.Reason, &job.Attempts,
+        &job.NextAttemptAt, &job.LastError, &job.Archive, &job.CreatedAt, &startedAt, &finishedAt, &expiresAt,
+    )
+    if err != nil {
+        return models.DataJob{}, err
+    }
+
+    job.StartedAt = startedAt.Time
+    job.FinishedAt = finishedAt.Time
+    job.ExpiresAt = expiresAt.Time
+
+    return job, nil
+}
+
+// nullJSON stores a missing JSON document as NULL.
+func nullJSON(raw []byte) any {
+    if raw == nil {
+        return nil
+    }
+    return raw
+}
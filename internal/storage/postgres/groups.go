@@ -0,0 +1,321 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateGroup creates the group and returns its id.
+func (s *Storage) CreateGroup(ctx context.Context, g models.Group) (int64, error) {
+    const op = "storage.postgres.CreateGroup"
+
+    var id int64
+    err := s.pool.QueryRow(ctx, `
+        INSERT INTO groups(name, description, roles, created_at)
+        VALUES($1, $2, $3, $4)
+        RETURNING id`,
+        g.Name, g.Description, textArray(g.Roles), g.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// Group returns the group by id.
+func (s *Storage) Group(ctx context.Context, id int64) (models.Group, error) {
+    const op = "storage.postgres.Group"
+
+    g, err := scanGroup(s.pool.QueryRow(ctx,
+        "SELECT id, name, description, roles, created_at FROM groups WHERE id = $1", id))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+        }
+        return models.Group{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return g, nil
+}
+
+// Groups returns up to limit groups with ids greater than afterID, ordered by id.
+func (s *Storage) Groups(ctx context.Context, afterID int64, limit int) ([]models.Group, error) {
+    const op = "storage.postgres.Groups"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT id, name, description, roles, created_at
+        FROM groups
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2`,
+        afterID, limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var groups []models.Group
+    for rows.Next() {
+        g, err := scanGroup(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        groups = append(groups, g)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return groups, nil
+}
+
+// SetGroupRoles replaces the roles the group grants.
+func (s *Storage) SetGroupRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.postgres.SetGroupRoles"
+
+    tag, err := s.pool.Exec(ctx, "UPDATE groups SET roles = $2 WHERE id = $1", id, textArray(roles))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+
+    return nil
+}
+
+// DeleteGroup deletes the group. Its members stay, they are no longer members through it.
+func (s *Storage) DeleteGroup(ctx context.Context, id int64) error {
+    const op = "storage.postgres.DeleteGroup"
+
+    tag, err := s.pool.Exec(ctx, "DELETE FROM groups WHERE id = $1", id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+    }
+
+    return nil
+}
+
+// AddGroupUser makes the user a direct member of the group.
+func (s *Storage) AddGroupUser(ctx context.Context, groupID, userID int64) error {
+    const op = "storage.postgres.AddGroupUser"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    // Locking the group keeps it from being deleted, so a missing row the insert references is the user.
+    if err := lockGroups(ctx, tx, groupID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.Exec(ctx, "INSERT INTO group_users(group_id, user_id) VALUES($1, $2)", groupID, userID)
+    if err != nil {
+        switch {
+        case isUniqueViolation(err):
+            return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberExists)
+        case isForeignKeyViolation(err):
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RemoveGroupUser removes the user from the direct members of the group.
+func (s *Storage) RemoveGroupUser(ctx context.Context, groupID, userID int64) error {
+    const op = "storage.postgres.RemoveGroupUser"
+
+    tag, err := s.pool.Exec(ctx, "DELETE FROM group_users WHERE group_id = $1 AND user_id = $2", groupID, userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberNotFound)
+    }
+
+    return nil
+}
+
+// AddSubgroup nests the subgroup in the group, unless the group is the subgroup
+// or is already nested in it, which would form a cycle.
+// Additions of subgroups are serialized, so concurrent ones cannot form a cycle either.
+func (s *Storage) AddSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+    const op = "storage.postgres.AddSubgroup"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    // The lock conflicts with itself and with writes, not with reads.
+    if _, err := tx.Exec(ctx, "LOCK TABLE group_subgroups IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := lockGroups(ctx, tx, groupID, subgroupID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    var cycle bool
+    err = tx.QueryRow(ctx, `
+        WITH RECURSIVE nested(id) AS (
+            SELECT $1::BIGINT
+            UNION
+            SELECT s.subgroup_id FROM group_subgroups s JOIN nested n ON s.group_id = n.id
+        )
+        SELECT EXISTS (SELECT 1 FROM nested WHERE id = $2)`,
+        subgroupID, groupID,
+    ).Scan(&cycle)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if cycle {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupCycle)
+    }
+
+    _, err = tx.Exec(ctx, "INSERT INTO group_subgroups(group_id, subgroup_id) VALUES($1, $2)", groupID, subgroupID)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberExists)
+        }
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RemoveSubgroup removes the subgroup from the group.
+func (s *Storage) RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+    const op = "storage.postgres.RemoveSubgroup"
+
+    tag, err := s.pool.Exec(ctx,
+        "DELETE FROM group_subgroups WHERE group_id = $1 AND subgroup_id = $2", groupID, subgroupID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberNotFound)
+    }
+
+    return nil
+}
+
+// GroupMembers returns the direct members of the group.
+func (s *Storage) GroupMembers(ctx context.Context, id int64) (models.GroupMembers, error) {
+    const op = "storage.postgres.GroupMembers"
+
+    var members models.GroupMembers
+    err := s.pool.QueryRow(ctx, `
+        SELECT
+            ARRAY(SELECT user_id::BIGINT FROM group_users WHERE group_id = g.id ORDER BY user_id),
+            ARRAY(SELECT subgroup_id FROM group_subgroups WHERE group_id = g.id ORDER BY subgroup_id)
+        FROM groups g
+        WHERE g.id = $1`,
+        id,
+    ).Scan(&members.UserIDs, &members.GroupIDs)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.GroupMembers{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+        }
+        return models.GroupMembers{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if len(members.UserIDs) == 0 {
+        members.UserIDs = nil
+    }
+    if len(members.GroupIDs) == 0 {
+        members.GroupIDs = nil
+    }
+
+    return members, nil
+}
+
+// UserGroups returns the groups the user is a member of, directly or through nested groups,
+// ordered by id, each with one of the shortest paths that make the user a member.
+func (s *Storage) UserGroups(ctx context.Context, userID int64) ([]models.UserGroup, error) {
+    const op = "storage.postgres.UserGroups"
+
+    // Nesting has no cycles, the check on the path only keeps the query finite should one slip in.
+    rows, err := s.pool.Query(ctx, `
+        WITH RECURSIVE reachable(group_id, path) AS (
+            SELECT group_id, ARRAY[group_id] FROM group_users WHERE user_id = $1
+            UNION ALL
+            SELECT s.group_id, r.path || s.group_id
+            FROM reachable r
+            JOIN group_subgroups s ON s.subgroup_id = r.group_id
+            WHERE s.group_id <> ALL (r.path)
+        )
+        SELECT DISTINCT ON (g.id) g.id, g.name, g.description, g.roles, g.created_at, r.path
+        FROM reachable r
+        JOIN groups g ON g.id = r.group_id
+        ORDER BY g.id, cardinality(r.path), r.path`,
+        userID,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var groups []models.UserGroup
+    for rows.Next() {
+        var ug models.UserGroup
+        g := &ug.Group
+        if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.Roles, &g.CreatedAt, &ug.Path); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        groups = append(groups, ug)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return groups, nil
+}
+
+// lockGroups keeps the groups from being deleted until the transaction ends,
+// and returns storage.ErrGroupNotFound unless every group exists.
+func lockGroups(ctx context.Context, tx pgx.Tx, ids ...int64) error {
+    for _, id := range ids {
+        var found int64
+        err := tx.QueryRow(ctx, "SELECT id FROM groups WHERE id = $1 FOR KEY SHARE", id).Scan(&found)
+        if err != nil {
+            if errors.Is(err, pgx.ErrNoRows) {
+                return storage.ErrGroupNotFound
+            }
+            return err
+        }
+    }
+
+    return nil
+}
+
+func scanGroup(row pgx.Row) (models.Group, error) {
+    var g models.Group
+    err := row.Scan(&g.ID, &g.Name, &g.Description, &g.Roles, &g.CreatedAt)
+    return g, err
+}
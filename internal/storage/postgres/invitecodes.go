@@ -0,0 +1,169 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const inviteCodeColumns = `id, code_hash, ` + emailColumns + `, roles, org_id, org_roles, creator, created_at, expires_at,
+    used_at, used_by, revoked_at`
+
+// SaveInviteCode saves an unused invite code and returns its id.
+func (s *Storage) SaveInviteCode(ctx context.Context, code models.InviteCode) (int64, error) {
+    const op = "storage.postgres.SaveInviteCode"
+
+    email, err := s.pii.seal(code.Email, columnInviteCodeEmail)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.pool.QueryRow(ctx, `
+        INSERT INTO invite_codes(
+            code_hash, email, email_enc, email_idx, roles, org_id, org_roles, creator, created_at, expires_at
+        )
+        VALUES($1, NULLIF($2, ''), $3, $4, $5, NULLIF($6, 0), $7, $8, $9, $10)
+        RETURNING id`,
+        code.Hash, email.plain, email.sealed, email.index, textArray(code.Roles), code.OrgID, textArray(code.OrgRoles),
+        code.Creator, code.CreatedAt, code.ExpiresAt,
+    ).Scan(&id)
+    if err != nil {
+        if isForeignKeyViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// InviteCodeByHash returns the invite code with the hash, whether it is pending or not.
+func (s *Storage) InviteCodeByHash(ctx context.Context, hash []byte) (models.InviteCode, error) {
+    const op = "storage.postgres.InviteCodeByHash"
+
+    code, err := s.scanInviteCode(ctx, s.pool.QueryRow(ctx,
+        "SELECT "+inviteCodeColumns+" FROM invite_codes WHERE code_hash = $1", hash))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.InviteCode{}, fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+        }
+        return models.InviteCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return code, nil
+}
+
+// RevokeInviteCode revokes the pending invite code.
+func (s *Storage) RevokeInviteCode(ctx context.Context, id int64, revokedAt time.Time) error {
+    const op = "storage.postgres.RevokeInviteCode"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE invite_codes SET revoked_at = $2
+        WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2`,
+        id, revokedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+    }
+
+    return nil
+}
+
+// CreateInvitedUser creates the user like CreateUser and marks the pending invite code used by it,
+// giving the user the roles and the membership of the code. Nothing is created unless the code is pending.
+func (s *Storage) CreateInvitedUser(
+    ctx context.Context,
+    ids models.UserIdentifiers,
+    passHash []byte,
+    codeID int64,
+    usedAt time.Time,
+) (int64, error) {
+    const op = "storage.postgres.CreateInvitedUser"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    id, err := s.createUser(ctx, tx, ids, passHash)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        roles, orgRoles []string
+        orgID           pgtype.Int8
+    )
+    err = tx.QueryRow(ctx, `
+        UPDATE invite_codes SET used_at = $3, used_by = $2
+        WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $3
+        RETURNING roles, org_id, org_roles`,
+        codeID, id, usedAt,
+    ).Scan(&roles, &orgID, &orgRoles)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if _, err := tx.Exec(ctx, "UPDATE users SET roles = $2 WHERE id = $1", id, textArray(roles)); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if orgID.Valid {
+        _, err = tx.Exec(ctx, `
+            INSERT INTO org_members(org_id, user_id, roles, created_at)
+            VALUES($1, $2, $3, $4)`,
+            orgID.Int64, id, textArray(orgRoles), usedAt,
+        )
+        if err != nil {
+            return 0, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.replicas.wrote(userKey(id), emailKey(ids.Email), usernameKey(ids.Username), phoneKey(ids.Phone))
+
+    return id, nil
+}
+
+func (s *Storage) scanInviteCode(ctx context.Context, row pgx.Row) (models.InviteCode, error) {
+    var (
+        code              models.InviteCode
+        email             piiValue
+        orgID, usedBy     pgtype.Int8
+        usedAt, revokedAt pgtype.Timestamptz
+    )
+
+    err := row.Scan(
+        &code.ID, &code.Hash, &email.plain, &email.sealed, &code.Roles, &orgID, &code.OrgRoles, &code.Creator,
+        &code.CreatedAt, &code.ExpiresAt, &usedAt, &usedBy, &revokedAt,
+    )
+    if err != nil {
+        return models.InviteCode{}, err
+    }
+    if code.Email, err = s.pii.open(ctx, email, columnInviteCodeEmail); err != nil {
+        return models.InviteCode{}, err
+    }
+    code.OrgID = orgID.Int64
+    code.UsedAt = usedAt.Time
+    code.UsedBy = usedBy.Int64
+    code.RevokedAt = revokedAt.Time
+
+    return code, nil
+}
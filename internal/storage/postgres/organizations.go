@@ -0,0 +1,376 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const orgInvitationColumns = `id, org_id, ` + emailColumns + `, roles, token_hash, inviter, created_at, expires_at,
+    accepted_at, accepted_by, revoked_at`
+
+// CreateOrganization creates the organization with the owner as its first member and returns its id.
+func (s *Storage) CreateOrganization(ctx context.Context, org models.Organization, owner models.Membership) (int64, error) {
+    const op = "storage.postgres.CreateOrganization"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var id int64
+    err = tx.QueryRow(ctx, `
+        INSERT INTO organizations(slug, name, created_at)
+        VALUES($1, $2, $3)
+        RETURNING id`,
+        org.Slug, org.Name, org.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgExists)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO org_members(org_id, user_id, roles, created_at)
+        VALUES($1, $2, $3, $4)`,
+        id, owner.UserID, textArray(owner.Roles), owner.CreatedAt,
+    )
+    if err != nil {
+        if isForeignKeyViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.replicas.wrote(userKey(owner.UserID))
+
+    return id, nil
+}
+
+// Organization returns the organization by id.
+func (s *Storage) Organization(ctx context.Context, id int64) (models.Organization, error) {
+    const op = "storage.postgres.Organization"
+
+    org, err := scanOrganization(s.pool.QueryRow(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE id = $1", id))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return org, nil
+}
+
+// OrganizationBySlug returns the organization by slug.
+func (s *Storage) OrganizationBySlug(ctx context.Context, slug string) (models.Organization, error) {
+    const op = "storage.postgres.OrganizationBySlug"
+
+    org, err := scanOrganization(s.pool.QueryRow(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE slug = $1", slug))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return org, nil
+}
+
+// UserOrganizations returns the organizations the user is a member of, ordered by id.
+func (s *Storage) UserOrganizations(ctx context.Context, userID int64) ([]models.UserOrganization, error) {
+    const op = "storage.postgres.UserOrganizations"
+
+    orgs, err := userOrganizations(ctx, s.pool, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return orgs, nil
+}
+
+// Membership returns the membership of the user in the organization.
+// It is read on every call made within the organization, so it may come from a replica.
+func (s *Storage) Membership(ctx context.Context, orgID, userID int64) (models.Membership, error) {
+    const op = "storage.postgres.Membership"
+
+    var m models.Membership
+    err := s.read(ctx, func(q querier) error {
+        var err error
+        m, err = scanMembership(q.QueryRow(ctx, `
+            SELECT org_id, user_id, roles, created_at
+            FROM org_members
+            WHERE org_id = $1 AND user_id = $2`,
+            orgID, userID,
+        ))
+        return err
+    }, userKey(userID))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+        }
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return m, nil
+}
+
+// Members returns up to limit members of the organization with user ids greater than afterUserID,
+// ordered by user id.
+func (s *Storage) Members(ctx context.Context, orgID, afterUserID int64, limit int) ([]models.Membership, error) {
+    const op = "storage.postgres.Members"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT org_id, user_id, roles, created_at
+        FROM org_members
+        WHERE org_id = $1 AND user_id > $2
+        ORDER BY user_id
+        LIMIT $3`,
+        orgID, afterUserID, limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var members []models.Membership
+    for rows.Next() {
+        m, err := scanMembership(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        members = append(members, m)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return members, nil
+}
+
+// SetMemberRoles replaces the roles of the member of the organization.
+func (s *Storage) SetMemberRoles(ctx context.Context, orgID, userID int64, roles []string) error {
+    const op = "storage.postgres.SetMemberRoles"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE org_members SET roles = $3
+        WHERE org_id = $1 AND user_id = $2`,
+        orgID, userID, textArray(roles),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+    }
+
+    s.replicas.wrote(userKey(userID))
+
+    return nil
+}
+
+// RemoveMember removes the user from the organization.
+func (s *Storage) RemoveMember(ctx context.Context, orgID, userID int64) error {
+    const op = "storage.postgres.RemoveMember"
+
+    tag, err := s.pool.Exec(ctx, "DELETE FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+    }
+
+    s.replicas.wrote(userKey(userID))
+
+    return nil
+}
+
+// SaveOrgInvitation saves a pending invitation to the organization and returns its id.
+func (s *Storage) SaveOrgInvitation(ctx context.Context, inv models.OrgInvitation) (int64, error) {
+    const op = "storage.postgres.SaveOrgInvitation"
+
+    email, err := s.pii.seal(inv.Email, columnInvitationEmail)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.pool.QueryRow(ctx, `
+        INSERT INTO org_invitations(org_id, email, email_enc, email_idx, roles, token_hash, inviter, created_at, expires_at)
+        VALUES($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id`,
+        inv.OrgID, email.plain, email.sealed, email.index, textArray(inv.Roles), inv.Hash, inv.Inviter,
+        inv.CreatedAt, inv.ExpiresAt,
+    ).Scan(&id)
+    if err != nil {
+        if isForeignKeyViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// OrgInvitationByHash returns the invitation with the token hash, whether it is pending or not.
+func (s *Storage) OrgInvitationByHash(ctx context.Context, hash []byte) (models.OrgInvitation, error) {
+    const op = "storage.postgres.OrgInvitationByHash"
+
+    inv, err := s.scanOrgInvitation(ctx, s.pool.QueryRow(ctx,
+        "SELECT "+orgInvitationColumns+" FROM org_invitations WHERE token_hash = $1", hash))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.OrgInvitation{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+        }
+        return models.OrgInvitation{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return inv, nil
+}
+
+// RevokeOrgInvitation revokes the pending invitation to the organization.
+func (s *Storage) RevokeOrgInvitation(ctx context.Context, orgID, id int64, revokedAt time.Time) error {
+    const op = "storage.postgres.RevokeOrgInvitation"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE org_invitations SET revoked_at = $3
+        WHERE id = $2 AND org_id = $1
+          AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $3`,
+        orgID, id, revokedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+    }
+
+    return nil
+}
+
+// AcceptOrgInvitation marks the pending invitation accepted by the user and makes the user a member
+// of the organization with the roles of the invitation. Invitations of members stay pending.
+func (s *Storage) AcceptOrgInvitation(ctx context.Context, id, userID int64, acceptedAt time.Time) (models.Membership, error) {
+    const op = "storage.postgres.AcceptOrgInvitation"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    m := models.Membership{UserID: userID, CreatedAt: acceptedAt}
+    err = tx.QueryRow(ctx, `
+        UPDATE org_invitations SET accepted_at = $3, accepted_by = $2
+        WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $3
+        RETURNING org_id, roles`,
+        id, userID, acceptedAt,
+    ).Scan(&m.OrgID, &m.Roles)
+    if err != nil {
+        switch {
+        case errors.Is(err, pgx.ErrNoRows):
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+        case isForeignKeyViolation(err):
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO org_members(org_id, user_id, roles, created_at)
+        VALUES($1, $2, $3, $4)`,
+        m.OrgID, m.UserID, textArray(m.Roles), m.CreatedAt,
+    )
+    if err != nil {
+        if isUniqueViolation(err) {
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+        }
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.replicas.wrote(userKey(userID))
+
+    return m, nil
+}
+
+func userOrganizations(ctx context.Context, q querier, userID int64) ([]models.UserOrganization, error) {
+    rows, err := q.Query(ctx, `
+        SELECT o.id, o.slug, o.name, o.created_at, m.roles, m.created_at
+        FROM org_members m
+        JOIN organizations o ON o.id = m.org_id
+        WHERE m.user_id = $1
+        ORDER BY o.id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var orgs []models.UserOrganization
+    for rows.Next() {
+        var uo models.UserOrganization
+        org := &uo.Organization
+        if err := rows.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt, &uo.Roles, &uo.JoinedAt); err != nil {
+            return nil, err
+        }
+        orgs = append(orgs, uo)
+    }
+
+    return orgs, rows.Err()
+}
+
+func scanOrganization(row pgx.Row) (models.Organization, error) {
+    var org models.Organization
+    err := row.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt)
+    return org, err
+}
+
+func scanMembership(row pgx.Row) (models.Membership, error) {
+    var m models.Membership
+    err := row.Scan(&m.OrgID, &m.UserID, &m.Roles, &m.CreatedAt)
+    return m, err
+}
+
+func (s *Storage) scanOrgInvitation(ctx context.Context, row pgx.Row) (models.OrgInvitation, error) {
+    var (
+        inv                   models.OrgInvitation
+        email                 piiValue
+        acceptedAt, revokedAt pgtype.Timestamptz
+        acceptedBy            pgtype.Int8
+    )
+
+    err := row.Scan(
+        &inv.ID, &inv.OrgID, &email.plain, &email.sealed, &inv.Roles, &inv.Hash, &inv.Inviter, &inv.CreatedAt, &inv.ExpiresAt,
+        &acceptedAt, &acceptedBy, &revokedAt,
+    )
+    if err != nil {
+        return models.OrgInvitation{}, err
+    }
+    if inv.Email, err = s.pii.open(ctx, email, columnInvitationEmail); err != nil {
+        return models.OrgInvitation{}, err
+    }
+    inv.AcceptedAt = acceptedAt.Time
+    inv.AcceptedBy = acceptedBy.Int64
+    inv.RevokedAt = revokedAt.Time
+
+    return inv, nil
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const outboxColumns = `id, type, aggregate_id, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at`
+
+const insertOutbox = "INSERT INTO outbox(type, aggregate_id, payload) VALUES($1, $2, $3)"
+
+// enqueue writes a domain event about the user to the outbox within the transaction of the change.
+func enqueue(ctx context.Context, tx pgx.Tx, eventType string, userID int64, payload any) error {
+    args, err := outboxArgs(eventType, userID, payload)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(ctx, insertOutbox, args...)
+
+    return err
+}
+
+// queueEnqueue queues the write of a domain event about the user to the outbox on the batch of the change.
+func queueEnqueue(batch *pgx.Batch, eventType string, userID int64, payload any) error {
+    args, err := outboxArgs(eventType, userID, payload)
+    if err != nil {
+        return err
+    }
+
+    batch.Queue(insertOutbox, args...)
+
+    return nil
+}
+
+func outboxArgs(eventType string, userID int64, payload any) ([]any, error) {
+    raw, err := json.Marshal(payload)
+    if err != nil {
+        return nil, err
+    }
+
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+
+    return []any{eventType, aggregate, raw}, nil
+}
+
+// ClaimOutboxEvents returns up to limit pending events that are due, oldest first, and
+// postpones their next attempt by the lease so that concurrent relays skip them while
+// they are being delivered. Events of a relay that stops mid-delivery are retried
+// once the lease ends.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+    const op = "storage.postgres.ClaimOutboxEvents"
+
+    rows, err := s.pool.Query(ctx, `
+        UPDATE outbox SET next_attempt_at = now() + $2 * interval '1 millisecond'
+        WHERE id IN (
+            SELECT id FROM outbox
+            WHERE status = 'pending' AND next_attempt_at <= now()
+            ORDER BY id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+outboxColumns,
+        limit, lease.Milliseconds(),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var events []models.OutboxEvent
+    for rows.Next() {
+        var (
+            e           models.OutboxEvent
+            deliveredAt pgtype.Timestamptz
+        )
+        err := rows.Scan(
+            &e.ID, &e.Type, &e.AggregateID, &e.Payload, &e.Status, &e.Attempts,
+            &e.NextAttemptAt, &e.LastError, &e.CreatedAt, &deliveredAt,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        e.DeliveredAt = deliveredAt.Time
+        events = append(events, e)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    slices.SortFunc(events, func(a, b models.OutboxEvent) int { return cmp.Compare(a.ID, b.ID) })
+
+    return events, nil
+}
+
+// MarkOutboxDelivered records a successful delivery of the event.
+func (s *Storage) MarkOutboxDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+    const op = "storage.postgres.MarkOutboxDelivered"
+
+    _, err := s.pool.Exec(ctx, `
+        UPDATE outbox SET status = 'delivered', attempts = attempts + 1, last_error = '', delivered_at = $2
+        WHERE id = $1`,
+        id, deliveredAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// MarkOutboxFailed records a failed delivery of the event and schedules the next attempt.
+func (s *Storage) MarkOutboxFailed(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+    const op = "storage.postgres.MarkOutboxFailed"
+
+    _, err := s.pool.Exec(ctx, `
+        UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+        WHERE id = $1`,
+        id, lastErr, nextAttemptAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// MarkOutboxDead records the last failed delivery of the event and stops retrying it.
+func (s *Storage) MarkOutboxDead(ctx context.Context, id int64, lastErr string) error {
+    const op = "storage.postgres.MarkOutboxDead"
+
+    _, err := s.pool.Exec(ctx, `
+        UPDATE outbox SET status = 'dead', attempts = attempts + 1, last_error = $2
+        WHERE id = $1`,
+        id, lastErr,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const phoneCodeColumns = `id, ` + phoneColumns + `, purpose, user_id, code_hash, attempts, created_at, expires_at, consumed_at`
+
+// SavePhoneCode saves the one-time code and returns its id.
+func (s *Storage) SavePhoneCode(ctx context.Context, code models.PhoneCode) (int64, error) {
+    const op = "storage.postgres.SavePhoneCode"
+
+    phone, err := s.pii.seal(code.Phone, columnCodePhone)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.pool.QueryRow(ctx, `
+        INSERT INTO phone_codes(phone, phone_enc, phone_idx, purpose, user_id, code_hash, created_at, expires_at)
+        VALUES(NULLIF($1, ''), $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id`,
+        phone.plain, phone.sealed, phone.index, code.Purpose, code.UserID, code.Hash, code.CreatedAt, code.ExpiresAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// LatestPhoneCode returns the code for the purpose last sent to the phone number, used or not.
+// With a user id, it returns the code last sent for that user only.
+// Codes are read from the primary, as they are checked right after being sent.
+func (s *Storage) LatestPhoneCode(
+    ctx context.Context,
+    phone string,
+    purpose models.PhoneCodePurpose,
+    userID int64,
+) (models.PhoneCode, error) {
+    const op = "storage.postgres.LatestPhoneCode"
+
+    row := s.pool.QueryRow(ctx, `
+        SELECT `+phoneCodeColumns+`
+        FROM phone_codes
+        WHERE (phone = $1 OR phone_idx = $3) AND purpose = $2 AND ($4::bigint = 0 OR user_id = $4)
+        ORDER BY id DESC
+        LIMIT 1`,
+        phone, purpose, s.pii.index(phone, columnCodePhone), userID,
+    )
+
+    code, err := s.scanPhoneCode(ctx, row)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.PhoneCode{}, fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+        }
+
+        return models.PhoneCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return code, nil
+}
+
+// CountPhoneCodes returns the number of codes sent to the phone number since the time, for any purpose.
+func (s *Storage) CountPhoneCodes(ctx context.Context, phone string, since time.Time) (int, error) {
+    const op = "storage.postgres.CountPhoneCodes"
+
+    var n int
+    err := s.pool.QueryRow(ctx,
+        "SELECT count(*) FROM phone_codes WHERE (phone = $1 OR phone_idx = $3) AND created_at >= $2",
+        phone, since, s.pii.index(phone, columnCodePhone),
+    ).Scan(&n)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return n, nil
+}
+
+// AddPhoneCodeAttempt counts a guess of the code and returns the number of guesses so far.
+func (s *Storage) AddPhoneCodeAttempt(ctx context.Context, id int64) (int, error) {
+    const op = "storage.postgres.AddPhoneCodeAttempt"
+
+    var attempts int
+    err := s.pool.QueryRow(ctx,
+        "UPDATE phone_codes SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts",
+        id,
+    ).Scan(&attempts)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return attempts, nil
+}
+
+// ConsumePhoneCode marks the code used. Codes are used once, so a code already used is not found.
+func (s *Storage) ConsumePhoneCode(ctx context.Context, id int64, consumedAt time.Time) error {
+    const op = "storage.postgres.ConsumePhoneCode"
+
+    tag, err := s.pool.Exec(ctx,
+        "UPDATE phone_codes SET consumed_at = $2 WHERE id = $1 AND consumed_at IS NULL",
+        id, consumedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+    }
+
+    return nil
+}
+
+// VerifyUserPhone sets the phone number of the user, verified at the time,
+// and publishes a user.phone_verified event.
+func (s *Storage) VerifyUserPhone(ctx context.Context, userID int64, phone string, verifiedAt time.Time) error {
+    const op = "storage.postgres.VerifyUserPhone"
+
+    sealed, err := s.pii.seal(phone, columnPhone)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    batch := &pgx.Batch{}
+    batch.Queue(`
+        UPDATE users SET phone = NULLIF($2, ''), phone_enc = $3, phone_idx = $4, phone_verified_at = $5
+        WHERE id = $1
+        RETURNING `+emailColumns,
+        userID, sealed.plain, sealed.sealed, sealed.index, verifiedAt,
+    )
+    err = queueEnqueue(batch, models.DomainEventPhoneVerified, userID, models.PhoneVerified{UserID: userID})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var email piiValue
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        if err := br.QueryRow().Scan(email.dest()...); err != nil {
+            switch {
+            case errors.Is(err, pgx.ErrNoRows):
+                return storage.ErrUserNotFound
+            case isUniqueViolation(err):
+                return storage.ErrPhoneTaken
+            }
+            return err
+        }
+        _, err := br.Exec()
+        return err
+    })
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.wroteIdentifiers(ctx, userID, email, "", piiValue{plain: phone})
+
+    return nil
+}
+
+func (s *Storage) scanPhoneCode(ctx context.Context, row pgx.Row) (models.PhoneCode, error) {
+    var (
+        code       models.PhoneCode
+        phone      piiValue
+        consumedAt pgtype.Timestamptz
+    )
+
+    err := row.Scan(
+        &code.ID, &phone.plain, &phone.sealed, &code.Purpose, &code.UserID, &code.Hash, &code.Attempts,
+        &code.CreatedAt, &code.ExpiresAt, &consumedAt,
+    )
+    if err != nil {
+        return models.PhoneCode{}, err
+    }
+    if code.Phone, err = s.pii.open(ctx, phone, columnCodePhone); err != nil {
+        return models.PhoneCode{}, err
+    }
+
+    code.ConsumedAt = consumedAt.Time
+
+    return code, nil
+}
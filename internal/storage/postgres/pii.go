@@ -0,0 +1,448 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/lib/fieldcrypt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Columns of personal data. Their names are the additional data their values are sealed with,
+// and the domains of their blind indexes.
+const (
+    columnEmail           = "users.email"
+    columnPhone           = "users.phone"
+    columnCodePhone       = "phone_codes.phone"
+    columnInvitationEmail = "org_invitations.email"
+    columnInviteCodeEmail = "invite_codes.email"
+)
+
+// emailColumns and phoneColumns read a personal value in plaintext along with its encrypted form,
+// from any of the tables keeping one. One of them is set.
+const (
+    emailColumns = `coalesce(email, ''), email_enc`
+    phoneColumns = `coalesce(phone, ''), phone_enc`
+)
+
+// identifierColumns read the email, username and phone number of a user, which writes of the user
+// pass to wroteIdentifiers.
+const identifierColumns = emailColumns + `, coalesce(username, ''), ` + phoneColumns
+
+// errNoKeyring is returned for encrypted values read without a keyring to decrypt them.
+var errNoKeyring = errors.New("personal data is encrypted but no keyring is configured")
+
+// piiValue is a personal value as it is stored: in plaintext, or encrypted along with its blind index.
+type piiValue struct {
+    plain  string
+    sealed []byte
+    index  []byte // set for writes only
+}
+
+// dest returns the scan destinations of emailColumns or phoneColumns.
+func (v *piiValue) dest() []any {
+    return []any{&v.plain, &v.sealed}
+}
+
+// piiCipher encrypts personal data with a data key kept in the data_keys table, wrapped by the
+// current master key of the keyring. Values written before encryption was turned on stay readable
+// in plaintext, and values of older data keys stay readable while their master key is configured,
+// until ReencryptPII brings them up to date.
+type piiCipher struct {
+    pool    *pgxpool.Pool
+    keyring *fieldcrypt.Keyring // nil if encryption is not configured
+    current *fieldcrypt.DataKey // nil if new values are stored in plaintext
+
+    mu   sync.RWMutex
+    keys map[int64]*fieldcrypt.DataKey
+}
+
+// newPIICipher returns the cipher of the keyring, which encrypts new values unless decrypt is set.
+func newPIICipher(ctx context.Context, pool *pgxpool.Pool, keyring *fieldcrypt.Keyring, decrypt bool) (*piiCipher, error) {
+    c := &piiCipher{
+        pool:    pool,
+        keyring: keyring,
+        keys:    make(map[int64]*fieldcrypt.DataKey),
+    }
+    if keyring == nil || decrypt {
+        return c, nil
+    }
+
+    key, err := c.currentKey(ctx)
+    if err != nil {
+        return nil, err
+    }
+    c.current = key
+    c.keys[key.ID()] = key
+
+    return c, nil
+}
+
+// currentKey returns the newest data key wrapped by the current master key, creating one if there
+// is none. The lock keeps instances starting at once from creating one each.
+func (c *piiCipher) currentKey(ctx context.Context) (*fieldcrypt.DataKey, error) {
+    tx, err := c.pool.Begin(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('data_keys'))"); err != nil {
+        return nil, err
+    }
+
+    var (
+        id      int64
+        wrapped []byte
+        version = c.keyring.CurrentVersion()
+    )
+    err = tx.QueryRow(ctx,
+        "SELECT id, wrapped_key FROM data_keys WHERE master_version = $1 ORDER BY id DESC LIMIT 1", version,
+    ).Scan(&id, &wrapped)
+    if errors.Is(err, pgx.ErrNoRows) {
+        if wrapped, _, err = c.keyring.GenerateDataKey(); err != nil {
+            return nil, err
+        }
+        err = tx.QueryRow(ctx,
+            "INSERT INTO data_keys(master_version, wrapped_key) VALUES($1, $2) RETURNING id", version, wrapped,
+        ).Scan(&id)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+
+    return c.keyring.Unwrap(id, version, wrapped)
+}
+
+// seal returns the value of the column as it is written.
+func (c *piiCipher) seal(value, column string) (piiValue, error) {
+    if value == "" || c.current == nil {
+        return piiValue{plain: value}, nil
+    }
+
+    sealed, err := c.current.Seal(value, column)
+    if err != nil {
+        return piiValue{}, err
+    }
+
+    return piiValue{sealed: sealed, index: c.index(value, column)}, nil
+}
+
+// open returns the value of the column as it was written.
+func (c *piiCipher) open(ctx context.Context, v piiValue, column string) (string, error) {
+    if v.sealed == nil {
+        return v.plain, nil
+    }
+    if c.keyring == nil {
+        return "", errNoKeyring
+    }
+
+    id, err := fieldcrypt.KeyID(v.sealed)
+    if err != nil {
+        return "", err
+    }
+
+    key, err := c.key(ctx, id)
+    if err != nil {
+        return "", err
+    }
+
+    return key.Open(v.sealed, column)
+}
+
+// index returns the blind index looking up the value of the column, nil if there is no keyring.
+// Emails are compared case-insensitively.
+func (c *piiCipher) index(value, column string) []byte {
+    if value == "" || c.keyring == nil {
+        return nil
+    }
+
+    if column == columnEmail || column == columnInvitationEmail || column == columnInviteCodeEmail {
+        value = strings.ToLower(value)
+    }
+
+    return c.keyring.BlindIndex(column, value)
+}
+
+// key returns the data key with the id, unwrapping it on first use.
+func (c *piiCipher) key(ctx context.Context, id int64) (*fieldcrypt.DataKey, error) {
+    c.mu.RLock()
+    key, ok := c.keys[id]
+    c.mu.RUnlock()
+    if ok {
+        return key, nil
+    }
+
+    var (
+        version int
+        wrapped []byte
+    )
+    err := c.pool.QueryRow(ctx, "SELECT master_version, wrapped_key FROM data_keys WHERE id = $1", id).
+        Scan(&version, &wrapped)
+    if err != nil {
+        return nil, fmt.Errorf("data key %d: %w", id, err)
+    }
+
+    key, err = c.keyring.Unwrap(id, version, wrapped)
+    if err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    c.keys[id] = key
+    c.mu.Unlock()
+
+    return key, nil
+}
+
+// wroteIdentifiers routes reads of the user written by id and by its identifiers to the primary.
+// The user is written already, so personal data that fails to decrypt only misses the routing.
+func (s *Storage) wroteIdentifiers(ctx context.Context, userID int64, email piiValue, username string, phone piiValue) {
+    plainEmail, _ := s.pii.open(ctx, email, columnEmail)
+    plainPhone, _ := s.pii.open(ctx, phone, columnPhone)
+
+    s.replicas.wrote(userKey(userID), emailKey(plainEmail), usernameKey(username), phoneKey(plainPhone))
+}
+
+// piiColumns are the personal data kept outside users: phone numbers of one-time codes and emails
+// of invitations. ReencryptPII brings them up to date after users, blind indexes included.
+var piiColumns = []struct {
+    table, column, aad string
+    indexed            bool
+}{
+    {table: "phone_codes", column: "phone", aad: columnCodePhone, indexed: true},
+    {table: "org_invitations", column: "email", aad: columnInvitationEmail, indexed: true},
+    {table: "invite_codes", column: "email", aad: columnInviteCodeEmail, indexed: true},
+}
+
+// ReencryptPII brings up to limit rows of personal data in line with the configuration: plaintext
+// values and values of older data keys are encrypted with the current data key, or, with encryption
+// turned off, encrypted values are decrypted. Users come first, then piiColumns. It returns the
+// number of rows updated, which is less than limit once all of them are up to date.
+func (s *Storage) ReencryptPII(ctx context.Context, limit int) (int, error) {
+    const op = "storage.postgres.ReencryptPII"
+
+    if s.pii.keyring == nil {
+        return 0, nil
+    }
+
+    n, err := s.reencryptUsers(ctx, limit)
+    if err != nil {
+        return n, fmt.Errorf("%s: %w", op, err)
+    }
+    for _, c := range piiColumns {
+        if n >= limit {
+            break
+        }
+        m, err := s.reencryptColumn(ctx, c.table, c.column, c.aad, c.indexed, limit-n)
+        n += m
+        if err != nil {
+            return n, fmt.Errorf("%s: %s: %w", op, c.table, err)
+        }
+    }
+
+    return n, nil
+}
+
+// reencryptUsers brings the emails and phone numbers of up to limit users up to date.
+func (s *Storage) reencryptUsers(ctx context.Context, limit int) (int, error) {
+
+    stale, args := "email_enc IS NOT NULL OR phone_enc IS NOT NULL", []any{limit}
+    if s.pii.current != nil {
+        stale = `email IS NOT NULL OR phone IS NOT NULL
+                OR substring(email_enc FROM 1 FOR $2) <> $3 OR substring(phone_enc FROM 1 FOR $2) <> $3`
+        prefix := fieldcrypt.KeyIDPrefix(s.pii.current.ID())
+        args = append(args, len(prefix), prefix)
+    }
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    type user struct {
+        id           int64
+        email, phone piiValue
+    }
+
+    rows, err := tx.Query(ctx, `
+        SELECT id, `+emailColumns+`, `+phoneColumns+`
+        FROM users
+        WHERE `+stale+`
+        ORDER BY id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED`,
+        args...,
+    )
+    if err != nil {
+        return 0, err
+    }
+
+    var users []user
+    for rows.Next() {
+        var u user
+        if err := rows.Scan(append([]any{&u.id}, append(u.email.dest(), u.phone.dest()...)...)...); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        users = append(users, u)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+    if len(users) == 0 {
+        return 0, nil
+    }
+
+    batch := &pgx.Batch{}
+    for _, u := range users {
+        email, err := s.reseal(ctx, u.email, columnEmail)
+        if err != nil {
+            return 0, fmt.Errorf("user %d: %w", u.id, err)
+        }
+        phone, err := s.reseal(ctx, u.phone, columnPhone)
+        if err != nil {
+            return 0, fmt.Errorf("user %d: %w", u.id, err)
+        }
+
+        batch.Queue(`
+            UPDATE users
+            SET email = NULLIF($2, ''), email_enc = $3, email_idx = $4,
+                phone = NULLIF($5, ''), phone_enc = $6, phone_idx = $7
+            WHERE id = $1`,
+            u.id, email.plain, email.sealed, email.index, phone.plain, phone.sealed, phone.index,
+        )
+    }
+
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        for range batch.Len() {
+            if _, err := br.Exec(); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return 0, err
+    }
+
+    return len(users), nil
+}
+
+// reencryptColumn brings up to limit values of the column of the table up to date, along with
+// its blind index if indexed.
+func (s *Storage) reencryptColumn(ctx context.Context, table, column, aad string, indexed bool, limit int) (int, error) {
+    enc := column + "_enc"
+
+    stale, args := enc+" IS NOT NULL", []any{limit}
+    if s.pii.current != nil {
+        stale = "NULLIF(" + column + ", '') IS NOT NULL OR substring(" + enc + " FROM 1 FOR $2) <> $3"
+        if indexed {
+            // Values encrypted before their column got a blind index.
+            stale += " OR " + column + "_idx IS NULL AND " + enc + " IS NOT NULL"
+        }
+        prefix := fieldcrypt.KeyIDPrefix(s.pii.current.ID())
+        args = append(args, len(prefix), prefix)
+    }
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    rows, err := tx.Query(ctx, `
+        SELECT id, coalesce(`+column+`, ''), `+enc+`
+        FROM `+table+`
+        WHERE `+stale+`
+        ORDER BY id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED`,
+        args...,
+    )
+    if err != nil {
+        return 0, err
+    }
+
+    ids, values := []int64{}, []piiValue{}
+    for rows.Next() {
+        var (
+            id int64
+            v  piiValue
+        )
+        if err := rows.Scan(append([]any{&id}, v.dest()...)...); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        ids, values = append(ids, id), append(values, v)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+    if len(ids) == 0 {
+        return 0, nil
+    }
+
+    update := "UPDATE " + table + " SET " + column + " = NULLIF($2, ''), " + enc + " = $3 WHERE id = $1"
+    if indexed {
+        update = "UPDATE " + table + " SET " + column + " = NULLIF($2, ''), " + enc + " = $3, " +
+            column + "_idx = $4 WHERE id = $1"
+    }
+
+    batch := &pgx.Batch{}
+    for i, id := range ids {
+        v, err := s.reseal(ctx, values[i], aad)
+        if err != nil {
+            return 0, fmt.Errorf("row %d: %w", id, err)
+        }
+
+        args := []any{id, v.plain, v.sealed}
+        if indexed {
+            args = append(args, v.index)
+        }
+        batch.Queue(update, args...)
+    }
+
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        for range batch.Len() {
+            if _, err := br.Exec(); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return 0, err
+    }
+
+    return len(ids), nil
+}
+
+// reseal returns the stored value of the column as it is written now.
+func (s *Storage) reseal(ctx context.Context, v piiValue, column string) (piiValue, error) {
+    value, err := s.pii.open(ctx, v, column)
+    if err != nil {
+        return piiValue{}, err
+    }
+
+    return s.pii.seal(value, column)
+}
@@ -2,68 +2,296 @@ package postgres
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/fieldcrypt"
 	"grpc-service-ref/internal/storage"
+	"time"
 
-	"github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Storage keeps its data in Postgres. Statements are prepared and cached
+// per connection by pgx, so hot queries are parsed once.
+// User lookups may be served by read replicas. Emails and phone numbers of users are encrypted
+// when a keyring is configured.
 type Storage struct {
-    db *sql.DB
+    pool     *pgxpool.Pool
+    replicas *replicaSet
+    pii      *piiCipher
 }
 
-// New creates a new instance of the Postgres storage.
-func New(connStr string) (*Storage, error) {
+// Options tune the connection pool and the startup connectivity check.
+// Zero pool settings keep the pgxpool defaults.
+type Options struct {
+    MaxConns        int
+    MinConns        int
+    MaxConnLifetime time.Duration
+    MaxConnIdleTime time.Duration
+    // ConnectAttempts is the number of pings before New gives up. At least one ping is made.
+    ConnectAttempts int
+    // ConnectRetryDelay is the wait after the first failed ping, doubled after every next one.
+    ConnectRetryDelay time.Duration
+    // Tracer, if set, is called around every query, for logging and metrics.
+    Tracer pgx.QueryTracer
+
+    // Replicas are connection strings of read replicas of the database. They share the pool settings.
+    Replicas []string
+    // ReplicaCheckInterval is the interval between health checks of the replicas.
+    ReplicaCheckInterval time.Duration
+    // ReadYourWritesWindow is how long reads of a user go to the primary after the user is written,
+    // covering the replication lag.
+    ReadYourWritesWindow time.Duration
+
+    // Keyring, if set, encrypts emails and phone numbers at rest: those of users, phone codes and invitations.
+    Keyring *fieldcrypt.Keyring
+    // DecryptPII writes personal data in plaintext even though Keyring is set, and makes ReencryptPII
+    // decrypt the values encrypted before, which turns encryption off.
+    DecryptPII bool
+}
+
+// pingTimeout bounds a single ping of the database.
+const pingTimeout = 5 * time.Second
+
+// New creates a new instance of the Postgres storage and waits for the database to answer a ping.
+func New(connStr string, opts Options) (*Storage, error) {
     const op = "storage.postgres.New"
 
-    db, err := sql.Open("postgres", connStr)
+    pool, err := newPool(connStr, opts)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := ping(pool, opts.ConnectAttempts, opts.ConnectRetryDelay); err != nil {
+        pool.Close()
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+    defer cancel()
+
+    pii, err := newPIICipher(ctx, pool, opts.Keyring, opts.DecryptPII)
+    if err != nil {
+        pool.Close()
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    replicas, err := newReplicaSet(opts)
     if err != nil {
+        pool.Close()
         return nil, fmt.Errorf("%s: %w", op, err)
     }
 
-    return &Storage{db: db}, nil
+    return &Storage{pool: pool, replicas: replicas, pii: pii}, nil
 }
 
+// newPool creates a connection pool. Connections are opened lazily.
+func newPool(connStr string, opts Options) (*pgxpool.Pool, error) {
+    cfg, err := pgxpool.ParseConfig(connStr)
+    if err != nil {
+        return nil, err
+    }
+
+    if opts.MaxConns > 0 {
+        cfg.MaxConns = int32(opts.MaxConns)
+    }
+    if opts.MinConns > 0 {
+        cfg.MinConns = int32(opts.MinConns)
+    }
+    if opts.MaxConnLifetime > 0 {
+        cfg.MaxConnLifetime = opts.MaxConnLifetime
+    }
+    if opts.MaxConnIdleTime > 0 {
+        cfg.MaxConnIdleTime = opts.MaxConnIdleTime
+    }
+    cfg.ConnConfig.Tracer = opts.Tracer
+
+    return pgxpool.NewWithConfig(context.Background(), cfg)
+}
+
+// ping pings the database until it answers or the attempts run out.
+func ping(pool *pgxpool.Pool, attempts int, delay time.Duration) error {
+    var err error
+    for attempt := 1; ; attempt++ {
+        ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+        err = pool.Ping(ctx)
+        cancel()
+
+        if err == nil || attempt >= attempts {
+            return err
+        }
+
+        time.Sleep(delay)
+        delay *= 2
+    }
+}
+
+// Close stops the replica health checks, waits for acquired connections to be released
+// and closes the pools.
+func (s *Storage) Close() error {
+    s.replicas.close()
+    s.pool.Close()
+    return nil
+}
+
+// sendBatch sends the batch within the transaction and hands its results to read, which consumes
+// them in the order the statements were queued. The batch is closed before sendBatch returns;
+// an error returned by read takes precedence over the one of closing it.
+func sendBatch(ctx context.Context, tx pgx.Tx, batch *pgx.Batch, read func(pgx.BatchResults) error) error {
+    br := tx.SendBatch(ctx, batch)
+    if err := read(br); err != nil {
+        br.Close()
+        return err
+    }
+
+    return br.Close()
+}
+
+// SaveUser creates the user with the email and publishes a user.registered event.
 func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (int64, error) {
-    const op = "storage.postgres.SaveUser"
+    return s.CreateUser(ctx, models.UserIdentifiers{Email: email}, passHash)
+}
 
-    stmt, err :=  s.db.Prepare("INSERT INTO users(email, pass_hash) VALUES($1, $2) RETURNING id")
+// CreateUser creates the user with the identifiers and publishes a user.registered event.
+func (s *Storage) CreateUser(ctx context.Context, ids models.UserIdentifiers, passHash []byte) (int64, error) {
+    const op = "storage.postgres.CreateUser"
+
+    tx, err := s.pool.Begin(ctx)
     if err != nil {
         return 0, fmt.Errorf("%s: %w", op, err)
     }
+    defer tx.Rollback(ctx)
 
-    var id int64
-    err = stmt.QueryRowContext(ctx, email, passHash).Scan(&id)
+    id, err := s.createUser(ctx, tx, ids, passHash)
     if err != nil {
-        var pgErr *pq.Error
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
 
-        if errors.As(err, &pgErr) && pgErr.Code.Name() == "unique_violation" {
-            return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+    if err := tx.Commit(ctx); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.replicas.wrote(userKey(id), emailKey(ids.Email), usernameKey(ids.Username), phoneKey(ids.Phone))
+
+    return id, nil
+}
+
+// createUser creates the user within the transaction and publishes a user.registered event.
+func (s *Storage) createUser(ctx context.Context, tx pgx.Tx, ids models.UserIdentifiers, passHash []byte) (int64, error) {
+    email, err := s.pii.seal(ids.Email, columnEmail)
+    if err != nil {
+        return 0, err
+    }
+    phone, err := s.pii.seal(ids.Phone, columnPhone)
+    if err != nil {
+        return 0, err
+    }
+
+    var id int64
+    err = tx.QueryRow(ctx, `
+        INSERT INTO users(email, email_enc, email_idx, username, phone, phone_enc, phone_idx, pass_hash)
+        VALUES(NULLIF($1, ''), $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8)
+        RETURNING id`,
+        email.plain, email.sealed, email.index, ids.Username, phone.plain, phone.sealed, phone.index, passHash,
+    ).Scan(&id)
+    if err != nil {
+        var pgErr *pgconn.PgError
+        if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+            return 0, userExists(pgErr.ConstraintName)
         }
 
-        return 0, fmt.Errorf("%s: %w", op, err)
+        return 0, err
+    }
+
+    err = enqueue(ctx, tx, models.DomainEventUserRegistered, id, models.UserRegistered{UserID: id})
+    if err != nil {
+        return 0, err
     }
 
     return id, nil
 }
 
-// User returns user by email
+// userExists returns the error of a new user violating the unique constraint.
+func userExists(constraint string) error {
+    switch constraint {
+    case "users_username_lower_key":
+        return storage.ErrUsernameTaken
+    case "users_phone_key", "users_phone_idx_key":
+        return storage.ErrPhoneTaken
+    default:
+        return storage.ErrUserExists
+    }
+}
+
+// User returns user by email, compared case-insensitively.
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
     const op = "storage.postgres.User"
-    stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email=$1")
+
+    var user models.User
+    err := s.read(ctx, func(q querier) error {
+        var err error
+        user, err = s.scanUser(ctx, q.QueryRow(ctx,
+            "SELECT "+userColumns+" FROM users WHERE lower(email) = lower($1) OR email_idx = $2",
+            email, s.pii.index(email, columnEmail),
+        ))
+        return err
+    }, emailKey(email))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// UserByIdentity returns the user linked to the subject at the external identity provider.
+func (s *Storage) UserByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+    const op = "storage.postgres.UserByIdentity"
+
+    var user models.User
+    err := s.read(ctx, func(q querier) error {
+        var err error
+        user, err = s.scanUser(ctx, q.QueryRow(ctx, `
+            SELECT `+userColumns+`
+            FROM users
+            WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)`,
+            provider, subject,
+        ))
+        return err
+    })
     if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
         return models.User{}, fmt.Errorf("%s: %w", op, err)
     }
 
-    row := stmt.QueryRowContext(ctx, email)
+    return user, nil
+}
+
+// UserByID returns user by id.
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+    const op = "storage.postgres.UserByID"
 
     var user models.User
-    err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+    err := s.read(ctx, func(q querier) error {
+        var err error
+        user, err = s.scanUser(ctx, q.QueryRow(ctx,
+            "SELECT "+userColumns+" FROM users WHERE id = $1 AND "+tenantCondition("users.id", 2),
+            id, storage.Tenant(ctx),
+        ))
+        return err
+    }, userKey(id))
     if err != nil {
-        if errors.Is(err, sql.ErrNoRows) {
+        if errors.Is(err, pgx.ErrNoRows) {
             return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
         }
 
@@ -72,3 +300,383 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 
     return user, nil
 }
+
+// UserByUsername returns user by username, compared case-insensitively.
+func (s *Storage) UserByUsername(ctx context.Context, username string) (models.User, error) {
+    const op = "storage.postgres.UserByUsername"
+
+    var user models.User
+    err := s.read(ctx, func(q querier) error {
+        var err error
+        user, err = s.scanUser(ctx, q.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE lower(username) = lower($1)", username))
+        return err
+    }, usernameKey(username))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// UserByPhone returns user by E.164 phone number.
+func (s *Storage) UserByPhone(ctx context.Context, phone string) (models.User, error) {
+    const op = "storage.postgres.UserByPhone"
+
+    var user models.User
+    err := s.read(ctx, func(q querier) error {
+        var err error
+        user, err = s.scanUser(ctx, q.QueryRow(ctx,
+            "SELECT "+userColumns+" FROM users WHERE phone = $1 OR phone_idx = $2",
+            phone, s.pii.index(phone, columnPhone),
+        ))
+        return err
+    }, phoneKey(phone))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// SaveIdentity links the subject at the external identity provider to the user.
+func (s *Storage) SaveIdentity(ctx context.Context, userID int64, provider, subject string) error {
+    const op = "storage.postgres.SaveIdentity"
+
+    _, err := s.pool.Exec(ctx,
+        "INSERT INTO user_identities(user_id, provider, subject) VALUES($1, $2, $3)",
+        userID, provider, subject,
+    )
+    if err != nil {
+        if isUniqueViolation(err) {
+            return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+    const op = "storage.postgres.SaveSession"
+
+    _, err := s.pool.Exec(ctx, `
+        INSERT INTO sessions(id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)`,
+        session.ID, session.UserID, session.UserAgent, session.IP,
+        session.CreatedAt, session.LastSeenAt, session.ExpiresAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// Session returns the session by id if it is neither revoked nor expired.
+func (s *Storage) Session(ctx context.Context, id string) (models.Session, error) {
+    const op = "storage.postgres.Session"
+
+    row := s.pool.QueryRow(ctx, `
+        SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()`,
+        id,
+    )
+
+    var session models.Session
+    err := row.Scan(
+        &session.ID, &session.UserID, &session.UserAgent, &session.IP,
+        &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+    )
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+        }
+
+        return models.Session{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return session, nil
+}
+
+// Sessions returns active sessions of the user, most recently used first.
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]models.Session, error) {
+    const op = "storage.postgres.Sessions"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+          AND `+tenantCondition("sessions.user_id", 2)+`
+        ORDER BY last_seen_at DESC`,
+        userID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var sessions []models.Session
+    for rows.Next() {
+        var session models.Session
+        err := rows.Scan(
+            &session.ID, &session.UserID, &session.UserAgent, &session.IP,
+            &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        sessions = append(sessions, session)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sessions, nil
+}
+
+// TouchSession sets the last seen time of the session.
+func (s *Storage) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+    const op = "storage.postgres.TouchSession"
+
+    _, err := s.pool.Exec(ctx, "UPDATE sessions SET last_seen_at = $2 WHERE id = $1", id, lastSeen)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RevokeSession revokes an active session of the user.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id string) error {
+    const op = "storage.postgres.RevokeSession"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE sessions SET revoked_at = now()
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()
+          AND `+tenantCondition("sessions.user_id", 3),
+        id, userID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+
+    return nil
+}
+
+// RevokeSessions revokes all active sessions of the user except the given one
+// and returns the number of revoked sessions.
+func (s *Storage) RevokeSessions(ctx context.Context, userID int64, exceptID string) (int64, error) {
+    const op = "storage.postgres.RevokeSessions"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE sessions SET revoked_at = now()
+        WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > now()
+          AND `+tenantCondition("sessions.user_id", 3),
+        userID, exceptID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return tag.RowsAffected(), nil
+}
+
+// SaveAPIKey saves the API key and returns its id.
+func (s *Storage) SaveAPIKey(ctx context.Context, key models.APIKey) (int64, error) {
+    const op = "storage.postgres.SaveAPIKey"
+
+    var userID, serviceAccountID pgtype.Int8
+    if key.Principal.IsUser() {
+        userID = pgtype.Int8{Int64: key.Principal.ID, Valid: true}
+    } else {
+        serviceAccountID = pgtype.Int8{Int64: key.Principal.ID, Valid: true}
+    }
+
+    var id int64
+    err := s.pool.QueryRow(ctx, `
+        INSERT INTO api_keys(user_id, service_account_id, name, prefix, key_hash, scopes, expires_at, created_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id`,
+        userID, serviceAccountID, key.Name, key.Prefix, key.Hash, textArray(key.Scopes),
+        nullTime(key.ExpiresAt), key.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// APIKeyByHash returns the API key with the hash if it is not revoked.
+func (s *Storage) APIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error) {
+    const op = "storage.postgres.APIKeyByHash"
+
+    row := s.pool.QueryRow(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE key_hash = $1 AND revoked_at IS NULL`,
+        hash,
+    )
+
+    key, err := scanAPIKey(row)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+        }
+
+        return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return key, nil
+}
+
+// APIKeys returns API keys of the principal that are not revoked, newest first.
+func (s *Storage) APIKeys(ctx context.Context, owner models.Principal) ([]models.APIKey, error) {
+    const op = "storage.postgres.APIKeys"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE `+ownerColumn(owner)+` = $1 AND revoked_at IS NULL
+          AND `+tenantCondition("api_keys.user_id", 2)+`
+        ORDER BY created_at DESC`,
+        owner.ID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var keys []models.APIKey
+    for rows.Next() {
+        key, err := scanAPIKey(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        keys = append(keys, key)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return keys, nil
+}
+
+// TouchAPIKey sets the last used time of the API key.
+func (s *Storage) TouchAPIKey(ctx context.Context, id int64, lastUsed time.Time) error {
+    const op = "storage.postgres.TouchAPIKey"
+
+    _, err := s.pool.Exec(ctx, "UPDATE api_keys SET last_used_at = $2 WHERE id = $1", id, lastUsed)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RevokeAPIKey revokes an API key of the principal.
+func (s *Storage) RevokeAPIKey(ctx context.Context, owner models.Principal, id int64) error {
+    const op = "storage.postgres.RevokeAPIKey"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE api_keys SET revoked_at = now()
+        WHERE id = $1 AND `+ownerColumn(owner)+` = $2 AND revoked_at IS NULL
+          AND `+tenantCondition("api_keys.user_id", 3),
+        id, owner.ID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+    }
+
+    return nil
+}
+
+const apiKeyColumns = `id, user_id, service_account_id, name, prefix, key_hash, scopes,
+    expires_at, last_used_at, created_at`
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+    var (
+        key              models.APIKey
+        userID           pgtype.Int8
+        serviceAccountID pgtype.Int8
+        expiresAt        pgtype.Timestamptz
+        lastUsedAt       pgtype.Timestamptz
+    )
+
+    err := row.Scan(
+        &key.ID, &userID, &serviceAccountID, &key.Name, &key.Prefix, &key.Hash, &key.Scopes,
+        &expiresAt, &lastUsedAt, &key.CreatedAt,
+    )
+    if err != nil {
+        return models.APIKey{}, err
+    }
+
+    if userID.Valid {
+        key.Principal = models.Principal{Type: models.PrincipalUser, ID: userID.Int64}
+    } else {
+        key.Principal = models.Principal{Type: models.PrincipalServiceAccount, ID: serviceAccountID.Int64}
+    }
+
+    key.ExpiresAt = expiresAt.Time
+    key.LastUsedAt = lastUsedAt.Time
+
+    return key, nil
+}
+
+// ownerColumn returns the api_keys column referencing the principal.
+func ownerColumn(p models.Principal) string {
+    if p.IsUser() {
+        return "user_id"
+    }
+    return "service_account_id"
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) pgtype.Timestamptz {
+    return pgtype.Timestamptz{Time: t, Valid: !t.IsZero()}
+}
+
+// textArray stores a nil list as an empty array, as the NOT NULL array columns require.
+func textArray(s []string) []string {
+    if s == nil {
+        return []string{}
+    }
+    return s
+}
+
+// SQLSTATEs of constraint violations.
+const (
+    uniqueViolation     = "23505"
+    foreignKeyViolation = "23503"
+)
+
+// isUniqueViolation reports whether the error is a violation of a unique constraint.
+func isUniqueViolation(err error) bool {
+    var pgErr *pgconn.PgError
+    return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// isForeignKeyViolation reports whether the error is a violation of a foreign key, which rows
+// that reference missing ones cause.
+func isForeignKeyViolation(err error) bool {
+    var pgErr *pgconn.PgError
+    return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
@@ -0,0 +1,363 @@
+package postgres_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/fieldcrypt"
+	"grpc-service-ref/internal/storage"
+	"grpc-service-ref/internal/storage/postgres"
+	"grpc-service-ref/internal/storage/storagetest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConformance runs against the migrated database of the TEST_POSTGRES_DSN connection string.
+func TestConformance(t *testing.T) {
+    dsn := os.Getenv("TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("TEST_POSTGRES_DSN is not set")
+    }
+
+    storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+        s, err := postgres.New(dsn, postgres.Options{})
+        require.NoError(t, err)
+        return s
+    })
+}
+
+func TestNew_GivesUpOnUnreachableDatabase(t *testing.T) {
+    start := time.Now()
+
+    _, err := postgres.New("host=127.0.0.1 port=1 user=postgres dbname=postgres sslmode=disable", postgres.Options{
+        ConnectAttempts:   3,
+        ConnectRetryDelay: 10 * time.Millisecond,
+    })
+    require.Error(t, err)
+    assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond, "retries back off")
+}
+
+// TestConformance_WithReplicas reads through a replica, which is the primary itself, next to
+// an unreachable one that reads fail over from.
+func TestConformance_WithReplicas(t *testing.T) {
+    dsn := os.Getenv("TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("TEST_POSTGRES_DSN is not set")
+    }
+
+    storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+        s, err := postgres.New(dsn, postgres.Options{
+            Replicas: []string{dsn, "host=127.0.0.1 port=1 user=postgres dbname=postgres sslmode=disable"},
+        })
+        require.NoError(t, err)
+        t.Cleanup(func() { s.Close() })
+        return s
+    })
+}
+
+// TestReplicas_ReadYourWritesByIdentifiers reads a user by username and phone number right after
+// writes that return the identifiers, which must go to the primary rather than to a lagging replica.
+func TestReplicas_ReadYourWritesByIdentifiers(t *testing.T) {
+    dsn := os.Getenv("TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("TEST_POSTGRES_DSN is not set")
+    }
+    ctx := context.Background()
+
+    writes := []struct {
+        name  string
+        write func(s *postgres.Storage, id int64) error
+    }{
+        {name: "ChangeUserStatus", write: func(s *postgres.Storage, id int64) error {
+            return s.ChangeUserStatus(ctx, models.StatusTransition{
+                UserID: id, From: models.UserStatusActive, To: models.UserStatusSuspended,
+                Actor: models.ActorSystem, CreatedAt: time.Now(),
+            })
+        }},
+        {name: "SetUserRoles", write: func(s *postgres.Storage, id int64) error {
+            return s.SetUserRoles(ctx, id, []string{"admin"})
+        }},
+        {name: "UpdatePassword", write: func(s *postgres.Storage, id int64) error {
+            return s.UpdatePassword(ctx, id, []byte("new hash"))
+        }},
+    }
+
+    for _, tt := range writes {
+        t.Run(tt.name, func(t *testing.T) {
+            // The user is created by another instance, which routes nothing of this one.
+            nano := time.Now().UnixNano()
+            username := fmt.Sprintf("replica-%d", nano)
+            phone := fmt.Sprintf("+1%010d", nano%1e10)
+            id, err := open(t, dsn, postgres.Options{}).
+                CreateUser(ctx, models.UserIdentifiers{Username: username, Phone: phone}, []byte("hash"))
+            require.NoError(t, err)
+
+            reads := &replicaReads{}
+            s := open(t, dsn, postgres.Options{
+                Replicas:             []string{withApplicationName(dsn, replicaApplication)},
+                ReadYourWritesWindow: time.Hour,
+                Tracer:               reads,
+            })
+
+            _, err = s.UserByUsername(ctx, username)
+            require.NoError(t, err)
+            require.Equal(t, 1, reads.count(), "reads go to the replica before the write")
+
+            require.NoError(t, tt.write(s, id))
+
+            _, err = s.UserByUsername(ctx, username)
+            require.NoError(t, err)
+            _, err = s.UserByPhone(ctx, phone)
+            require.NoError(t, err)
+            assert.Equal(t, 1, reads.count(), "reads by username and phone go to the primary after the write")
+        })
+    }
+}
+
+// TestReencryptPII encrypts a plaintext user and phone code, rotates the master key and turns
+// encryption off again.
+func TestReencryptPII(t *testing.T) {
+    dsn := os.Getenv("TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("TEST_POSTGRES_DSN is not set")
+    }
+    ctx := context.Background()
+
+    raw, err := pgxpool.New(ctx, dsn)
+    require.NoError(t, err)
+    defer raw.Close()
+
+    plain := open(t, dsn, postgres.Options{})
+    email := fmt.Sprintf("Reencrypt-%d@example.com", time.Now().UnixNano())
+    phone := fmt.Sprintf("+1%010d", time.Now().UnixNano()%1e10)
+    id, err := plain.CreateUser(ctx, models.UserIdentifiers{Email: email, Phone: phone}, []byte("hash"))
+    require.NoError(t, err)
+    now := time.Now().Truncate(time.Microsecond)
+    codeID, err := plain.SavePhoneCode(ctx, models.PhoneCode{
+        Phone: phone, Purpose: models.PhoneCodeVerify, UserID: id, Hash: []byte("code"),
+        CreatedAt: now, ExpiresAt: now.Add(time.Minute),
+    })
+    require.NoError(t, err)
+
+    encrypted := open(t, dsn, postgres.Options{Keyring: keyring(t, 1)})
+    reencrypt(t, encrypted)
+    assertStored(t, raw, id, true)
+    assertReadable(t, encrypted, id, email, phone)
+    assertCodeStored(t, raw, codeID, true)
+    assertCodeReadable(t, encrypted, codeID, phone)
+
+    rotated := open(t, dsn, postgres.Options{Keyring: keyring(t, 1, 2)})
+    reencrypt(t, rotated)
+    assertReadable(t, open(t, dsn, postgres.Options{Keyring: keyring(t, 2)}), id, email, phone)
+    assertCodeReadable(t, open(t, dsn, postgres.Options{Keyring: keyring(t, 2)}), codeID, phone)
+
+    decrypted := open(t, dsn, postgres.Options{Keyring: keyring(t, 2), DecryptPII: true})
+    reencrypt(t, decrypted)
+    assertStored(t, raw, id, false)
+    assertReadable(t, plain, id, email, phone)
+    assertCodeStored(t, raw, codeID, false)
+    assertCodeReadable(t, plain, codeID, phone)
+}
+
+// TestUsers_EmailPrefixOfEncryptedEmails matches encrypted emails by whole address only.
+func TestUsers_EmailPrefixOfEncryptedEmails(t *testing.T) {
+    dsn := os.Getenv("TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("TEST_POSTGRES_DSN is not set")
+    }
+    ctx := context.Background()
+
+    s := open(t, dsn, postgres.Options{Keyring: keyring(t, 1)})
+    local := fmt.Sprintf("prefix-%d", time.Now().UnixNano())
+    email := local + "@example.com"
+    id, err := s.CreateUser(ctx, models.UserIdentifiers{Email: email}, []byte("hash"))
+    require.NoError(t, err)
+
+    users, err := s.Users(ctx, models.UserFilter{EmailPrefix: local}, id-1, 10)
+    require.NoError(t, err)
+    assert.Empty(t, users, "encrypted emails don't match by prefix")
+
+    users, err = s.Users(ctx, models.UserFilter{EmailPrefix: strings.ToUpper(email)}, id-1, 10)
+    require.NoError(t, err)
+    require.Len(t, users, 1, "encrypted emails match whole addresses case-insensitively")
+    assert.Equal(t, id, users[0].ID)
+}
+
+// TestEraseUser_EncryptedInvitations matches encrypted emails of invitations by their blind index.
+func TestEraseUser_EncryptedInvitations(t *testing.T) {
+    dsn := os.Getenv("TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("TEST_POSTGRES_DSN is not set")
+    }
+    ctx := context.Background()
+
+    s := open(t, dsn, postgres.Options{Keyring: keyring(t, 1)})
+    email := fmt.Sprintf("erase-%d@example.com", time.Now().UnixNano())
+    id, err := s.CreateUser(ctx, models.UserIdentifiers{Email: email}, []byte("hash"))
+    require.NoError(t, err)
+    orgID, err := s.CreateOrganization(ctx,
+        models.Organization{Slug: fmt.Sprintf("erase-%d", id), Name: "Org", CreatedAt: time.Now()},
+        models.Membership{UserID: id, Roles: []string{models.OrgRoleAdmin}, CreatedAt: time.Now()},
+    )
+    require.NoError(t, err)
+
+    invitationHash := []byte(email + "-invitation")
+    _, err = s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID: orgID, Email: strings.ToUpper(email), Hash: invitationHash,
+        CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+    codeHash := []byte(email + "-code")
+    _, err = s.SaveInviteCode(ctx, models.InviteCode{
+        Hash: codeHash, Email: email, Creator: "user:1", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+
+    require.NoError(t, s.EraseUser(ctx, id))
+
+    _, err = s.OrgInvitationByHash(ctx, invitationHash)
+    require.ErrorIs(t, err, storage.ErrInvitationNotFound)
+    _, err = s.InviteCodeByHash(ctx, codeHash)
+    require.ErrorIs(t, err, storage.ErrInviteCodeNotFound)
+}
+
+func open(t *testing.T, dsn string, opts postgres.Options) *postgres.Storage {
+    t.Helper()
+
+    s, err := postgres.New(dsn, opts)
+    require.NoError(t, err)
+    t.Cleanup(func() { s.Close() })
+
+    return s
+}
+
+// keyring returns a keyring of the master key versions, the last of which is the current one.
+func keyring(t *testing.T, versions ...int) *fieldcrypt.Keyring {
+    t.Helper()
+
+    masters := make(map[int][]byte)
+    for _, v := range versions {
+        masters[v] = bytes.Repeat([]byte{byte(v)}, fieldcrypt.KeySize)
+    }
+
+    k, err := fieldcrypt.NewKeyring(masters, versions[len(versions)-1], bytes.Repeat([]byte{0xff}, fieldcrypt.KeySize))
+    require.NoError(t, err)
+
+    return k
+}
+
+func reencrypt(t *testing.T, s *postgres.Storage) {
+    t.Helper()
+
+    for {
+        n, err := s.ReencryptPII(context.Background(), 100)
+        require.NoError(t, err)
+        if n < 100 {
+            return
+        }
+    }
+}
+
+func assertStored(t *testing.T, raw *pgxpool.Pool, id int64, encrypted bool) {
+    t.Helper()
+
+    var plaintext, sealed bool
+    err := raw.QueryRow(context.Background(),
+        "SELECT email IS NOT NULL AND phone IS NOT NULL, email_enc IS NOT NULL AND phone_enc IS NOT NULL FROM users WHERE id = $1",
+        id,
+    ).Scan(&plaintext, &sealed)
+    require.NoError(t, err)
+    assert.Equal(t, !encrypted, plaintext)
+    assert.Equal(t, encrypted, sealed)
+}
+
+func assertReadable(t *testing.T, s *postgres.Storage, id int64, email, phone string) {
+    t.Helper()
+
+    user, err := s.UserByID(context.Background(), id)
+    require.NoError(t, err)
+    assert.Equal(t, email, user.Email)
+    assert.Equal(t, phone, user.Phone)
+
+    user, err = s.User(context.Background(), strings.ToUpper(email))
+    require.NoError(t, err, "emails are looked up case-insensitively")
+    assert.Equal(t, id, user.ID)
+
+    user, err = s.UserByPhone(context.Background(), phone)
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+}
+
+func assertCodeStored(t *testing.T, raw *pgxpool.Pool, id int64, encrypted bool) {
+    t.Helper()
+
+    var plaintext, sealed bool
+    err := raw.QueryRow(context.Background(),
+        "SELECT phone IS NOT NULL, phone_enc IS NOT NULL AND phone_idx IS NOT NULL FROM phone_codes WHERE id = $1",
+        id,
+    ).Scan(&plaintext, &sealed)
+    require.NoError(t, err)
+    assert.Equal(t, !encrypted, plaintext)
+    assert.Equal(t, encrypted, sealed)
+}
+
+// assertCodeReadable looks the code up by its phone number, which takes the blind index once it is encrypted.
+func assertCodeReadable(t *testing.T, s *postgres.Storage, id int64, phone string) {
+    t.Helper()
+
+    code, err := s.LatestPhoneCode(context.Background(), phone, models.PhoneCodeVerify, 0)
+    require.NoError(t, err)
+    assert.Equal(t, id, code.ID)
+    assert.Equal(t, phone, code.Phone)
+
+    n, err := s.CountPhoneCodes(context.Background(), phone, code.CreatedAt)
+    require.NoError(t, err)
+    assert.Equal(t, 1, n)
+}
+
+// replicaApplication names the connections of the replica in TestReplicas_ReadYourWritesByIdentifiers.
+const replicaApplication = "replica"
+
+// withApplicationName sets the application name of the connection string, of either format.
+func withApplicationName(dsn, name string) string {
+    if !strings.Contains(dsn, "://") {
+        return dsn + " application_name=" + name
+    }
+    if strings.Contains(dsn, "?") {
+        return dsn + "&application_name=" + name
+    }
+    return dsn + "?application_name=" + name
+}
+
+// replicaReads counts the reads of users run on connections of replicaApplication.
+type replicaReads struct {
+    mu sync.Mutex
+    n  int
+}
+
+func (r *replicaReads) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+    if conn.Config().RuntimeParams["application_name"] == replicaApplication && strings.Contains(data.SQL, "FROM users") {
+        r.mu.Lock()
+        r.n++
+        r.mu.Unlock()
+    }
+    return ctx
+}
+
+func (r *replicaReads) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func (r *replicaReads) count() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.n
+}
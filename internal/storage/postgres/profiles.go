@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UpdateProfile applies the update to the profile of the user, publishes a user.profile_updated event
+// and returns the updated profile. The user is locked while the update is applied, so concurrent
+// updates of other fields are not lost.
+func (s *Storage) UpdateProfile(ctx context.Context, userID int64, update models.ProfileUpdate) (models.Profile, error) {
+    const op = "storage.postgres.UpdateProfile"
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var (
+        email, phone piiValue
+        username     string
+        profile      models.Profile
+    )
+    err = scanProfile(tx.QueryRow(ctx, `
+        SELECT `+identifierColumns+`, `+profileColumns+`
+        FROM users
+        WHERE id = $1
+        FOR UPDATE`,
+        userID,
+    ), &profile, &email.plain, &email.sealed, &username, &phone.plain, &phone.sealed)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    profile = profile.Apply(update)
+
+    attributes, err := attributesJSON(profile.Attributes)
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    batch := &pgx.Batch{}
+    batch.Queue(`
+        UPDATE users
+        SET display_name = $2, given_name = $3, family_name = $4, locale = $5, timezone = $6, avatar_url = $7,
+            attributes = $8
+        WHERE id = $1`,
+        userID, profile.DisplayName, profile.GivenName, profile.FamilyName, profile.Locale, profile.Timezone,
+        profile.AvatarURL, attributes,
+    )
+    err = queueEnqueue(batch, models.DomainEventProfileUpdated, userID, models.ProfileUpdated{
+        UserID: userID,
+        Fields: update.Paths(),
+    })
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        for range batch.Len() {
+            if _, err := br.Exec(); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.wroteIdentifiers(ctx, userID, email, username, phone)
+
+    return profile, nil
+}
+
+// profileColumns are the columns of the profile, read by scanProfile.
+const profileColumns = `display_name, given_name, family_name, locale, timezone, avatar_url, attributes`
+
+// scanProfile scans the profile columns into the profile, after the other destinations.
+func scanProfile(row pgx.Row, profile *models.Profile, dest ...any) error {
+    var attributes []byte
+
+    dest = append(dest,
+        &profile.DisplayName, &profile.GivenName, &profile.FamilyName, &profile.Locale, &profile.Timezone,
+        &profile.AvatarURL, &attributes,
+    )
+    if err := row.Scan(dest...); err != nil {
+        return err
+    }
+
+    if err := json.Unmarshal(attributes, &profile.Attributes); err != nil {
+        return err
+    }
+    if len(profile.Attributes) == 0 {
+        profile.Attributes = nil
+    }
+
+    return nil
+}
+
+// attributesJSON encodes the attributes as a JSON object. Missing attributes are stored as an empty one.
+func attributesJSON(attributes models.Attributes) ([]byte, error) {
+    if attributes == nil {
+        attributes = models.Attributes{}
+    }
+
+    return json.Marshal(attributes)
+}
@@ -0,0 +1,256 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"grpc-service-ref/internal/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+    defaultReplicaCheckInterval = 5 * time.Second
+    defaultReadYourWritesWindow = 5 * time.Second
+)
+
+// querier runs reads on the primary or on a replica.
+type querier interface {
+    Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+    QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// replicaSet routes reads to healthy replicas in turn.
+type replicaSet struct {
+    replicas []*replica
+    next     atomic.Uint64
+    written  *recentWrites
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+type replica struct {
+    pool    *pgxpool.Pool
+    healthy atomic.Bool
+}
+
+// newReplicaSet opens pools to the replicas of the options and starts checking their health.
+// It returns nil without replicas. Replicas that are down at startup are skipped until they answer.
+func newReplicaSet(opts Options) (*replicaSet, error) {
+    if len(opts.Replicas) == 0 {
+        return nil, nil
+    }
+
+    checkInterval := opts.ReplicaCheckInterval
+    if checkInterval <= 0 {
+        checkInterval = defaultReplicaCheckInterval
+    }
+    window := opts.ReadYourWritesWindow
+    if window <= 0 {
+        window = defaultReadYourWritesWindow
+    }
+
+    rs := &replicaSet{
+        written: &recentWrites{window: window, until: make(map[string]time.Time)},
+        stop:    make(chan struct{}),
+        done:    make(chan struct{}),
+    }
+
+    for _, connStr := range opts.Replicas {
+        pool, err := newPool(connStr, opts)
+        if err != nil {
+            for _, r := range rs.replicas {
+                r.pool.Close()
+            }
+            return nil, err
+        }
+        rs.replicas = append(rs.replicas, &replica{pool: pool})
+    }
+
+    rs.check()
+    go rs.run(checkInterval)
+
+    return rs, nil
+}
+
+func (rs *replicaSet) run(interval time.Duration) {
+    defer close(rs.done)
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-rs.stop:
+            return
+        case <-ticker.C:
+            rs.check()
+        }
+    }
+}
+
+// check pings every replica and marks it healthy if it answers.
+func (rs *replicaSet) check() {
+    var wg sync.WaitGroup
+    for _, r := range rs.replicas {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+
+            ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+            defer cancel()
+
+            r.healthy.Store(r.pool.Ping(ctx) == nil)
+        }()
+    }
+    wg.Wait()
+}
+
+// pick returns the next healthy replica to read from, or nil if the read must go to the primary:
+// when the context demands primary reads, when one of the keys was written recently
+// or when no replica is healthy.
+func (rs *replicaSet) pick(ctx context.Context, keys []string) *replica {
+    if rs == nil || storage.PrimaryReads(ctx) || rs.written.contains(keys) {
+        return nil
+    }
+
+    start := rs.next.Add(1)
+    for i := range uint64(len(rs.replicas)) {
+        r := rs.replicas[(start+i)%uint64(len(rs.replicas))]
+        if r.healthy.Load() {
+            return r
+        }
+    }
+
+    return nil
+}
+
+// wrote makes reads of the keys go to the primary until the replicas have likely caught up.
+func (rs *replicaSet) wrote(keys ...string) {
+    if rs == nil {
+        return
+    }
+    rs.written.add(keys)
+}
+
+func (rs *replicaSet) close() {
+    if rs == nil {
+        return
+    }
+
+    close(rs.stop)
+    <-rs.done
+
+    for _, r := range rs.replicas {
+        r.pool.Close()
+    }
+}
+
+// read runs fn on a replica picked for the keys, or on the primary. A read that fails on the replica
+// is retried on the primary if the replica is unreachable or misses the row, which it may not have
+// replayed yet; unreachable replicas are skipped until the next health check finds them answering.
+func (s *Storage) read(ctx context.Context, fn func(q querier) error, keys ...string) error {
+    r := s.replicas.pick(ctx, keys)
+    if r == nil {
+        return fn(s.pool)
+    }
+
+    err := fn(r.pool)
+
+    var pgErr *pgconn.PgError
+    switch {
+    case err == nil:
+        return nil
+    case errors.Is(err, pgx.ErrNoRows):
+    case ctx.Err() != nil, errors.As(err, &pgErr):
+        return err
+    default:
+        r.healthy.Store(false)
+    }
+
+    return fn(s.pool)
+}
+
+// emailKey, usernameKey, phoneKey and userKey identify reads and writes of a user
+// for read-your-writes routing. Missing identifiers have empty keys, which are never written.
+func emailKey(email string) string {
+    return identifierKey("email:", strings.ToLower(email))
+}
+
+func usernameKey(username string) string {
+    return identifierKey("username:", strings.ToLower(username))
+}
+
+func phoneKey(phone string) string {
+    return identifierKey("phone:", phone)
+}
+
+func identifierKey(prefix, id string) string {
+    if id == "" {
+        return ""
+    }
+    return prefix + id
+}
+
+func userKey(id int64) string {
+    return "user:" + strconv.FormatInt(id, 10)
+}
+
+// recentWrites remembers keys written within the window. The memory is local to the process,
+// so reads of another instance rely on the retry on the primary when the replica misses the row.
+type recentWrites struct {
+    window time.Duration
+
+    mu    sync.Mutex
+    until map[string]time.Time
+}
+
+// recentWritesPruneSize is the number of remembered keys above which expired ones are dropped.
+const recentWritesPruneSize = 1024
+
+func (w *recentWrites) add(keys []string) {
+    now := time.Now()
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if len(w.until) >= recentWritesPruneSize {
+        for key, until := range w.until {
+            if !now.Before(until) {
+                delete(w.until, key)
+            }
+        }
+    }
+
+    for _, key := range keys {
+        if key != "" {
+            w.until[key] = now.Add(w.window)
+        }
+    }
+}
+
+func (w *recentWrites) contains(keys []string) bool {
+    if len(keys) == 0 {
+        return false
+    }
+
+    now := time.Now()
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    for _, key := range keys {
+        if until, ok := w.until[key]; ok && now.Before(until) {
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const serviceAccountColumns = `id, name, owner_kind, owner, client_id, secret_hash, roles, created_at, disabled_at`
+
+// SaveServiceAccount saves the service account and returns its id.
+func (s *Storage) SaveServiceAccount(ctx context.Context, sa models.ServiceAccount) (int64, error) {
+    const op = "storage.postgres.SaveServiceAccount"
+
+    var id int64
+    err := s.pool.QueryRow(ctx, `
+        INSERT INTO service_accounts(name, owner_kind, owner, client_id, secret_hash, roles, created_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id`,
+        sa.Name, sa.Owner.Kind, sa.Owner.Name, sa.ClientID, sa.SecretHash, textArray(sa.Roles), sa.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountExists)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// ServiceAccount returns the service account by id.
+func (s *Storage) ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error) {
+    const op = "storage.postgres.ServiceAccount"
+
+    row := s.pool.QueryRow(ctx,
+        "SELECT "+serviceAccountColumns+" FROM service_accounts WHERE id = $1",
+        id,
+    )
+
+    sa, err := scanServiceAccount(row)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+        }
+
+        return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sa, nil
+}
+
+// ServiceAccountByClientID returns the service account by its client id.
+func (s *Storage) ServiceAccountByClientID(ctx context.Context, clientID string) (models.ServiceAccount, error) {
+    const op = "storage.postgres.ServiceAccountByClientID"
+
+    row := s.pool.QueryRow(ctx,
+        "SELECT "+serviceAccountColumns+" FROM service_accounts WHERE client_id = $1",
+        clientID,
+    )
+
+    sa, err := scanServiceAccount(row)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+        }
+
+        return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sa, nil
+}
+
+// ServiceAccounts returns service accounts ordered by id.
+// An owner with an empty kind returns service accounts of every owner.
+func (s *Storage) ServiceAccounts(ctx context.Context, owner models.Owner) ([]models.ServiceAccount, error) {
+    const op = "storage.postgres.ServiceAccounts"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT `+serviceAccountColumns+`
+        FROM service_accounts
+        WHERE $1 = '' OR (owner_kind = $1 AND owner = $2)
+        ORDER BY id`,
+        owner.Kind, owner.Name,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var accounts []models.ServiceAccount
+    for rows.Next() {
+        sa, err := scanServiceAccount(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        accounts = append(accounts, sa)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return accounts, nil
+}
+
+// UpdateServiceAccountRoles replaces the roles of the service account.
+func (s *Storage) UpdateServiceAccountRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.postgres.UpdateServiceAccountRoles"
+
+    tag, err := s.pool.Exec(ctx, "UPDATE service_accounts SET roles = $2 WHERE id = $1", id, textArray(roles))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return serviceAccountAffected(op, tag)
+}
+
+// UpdateServiceAccountSecret replaces the client secret hash of the service account.
+func (s *Storage) UpdateServiceAccountSecret(ctx context.Context, id int64, secretHash []byte) error {
+    const op = "storage.postgres.UpdateServiceAccountSecret"
+
+    tag, err := s.pool.Exec(ctx, "UPDATE service_accounts SET secret_hash = $2 WHERE id = $1", id, secretHash)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return serviceAccountAffected(op, tag)
+}
+
+// DisableServiceAccount disables the service account if it is enabled.
+func (s *Storage) DisableServiceAccount(ctx context.Context, id int64, disabledAt time.Time) error {
+    const op = "storage.postgres.DisableServiceAccount"
+
+    tag, err := s.pool.Exec(ctx,
+        "UPDATE service_accounts SET disabled_at = $2 WHERE id = $1 AND disabled_at IS NULL",
+        id, disabledAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return serviceAccountAffected(op, tag)
+}
+
+func serviceAccountAffected(op string, tag pgconn.CommandTag) error {
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+    }
+
+    return nil
+}
+
+func scanServiceAccount(row pgx.Row) (models.ServiceAccount, error) {
+    var (
+        sa         models.ServiceAccount
+        disabledAt pgtype.Timestamptz
+    )
+
+    err := row.Scan(
+        &sa.ID, &sa.Name, &sa.Owner.Kind, &sa.Owner.Name, &sa.ClientID, &sa.SecretHash,
+        &sa.Roles, &sa.CreatedAt, &disabledAt,
+    )
+    if err != nil {
+        return models.ServiceAccount{}, err
+    }
+
+    sa.DisabledAt = disabledAt.Time
+
+    return sa, nil
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryStats describe an executed query, or a whole batch of them.
+type QueryStats struct {
+    SQL      string
+    Duration time.Duration
+    Err      error
+    // Batch is the number of queries sent in the batch, zero for single queries.
+    Batch int
+}
+
+// Tracer logs the queries run by the storage and hands their stats to an observer,
+// for example to record metrics. Query arguments are never logged, as they hold
+// emails and password hashes.
+type Tracer struct {
+    log       *slog.Logger
+    slowQuery time.Duration
+    observe   func(QueryStats)
+}
+
+var (
+    _ pgx.QueryTracer = (*Tracer)(nil)
+    _ pgx.BatchTracer = (*Tracer)(nil)
+)
+
+// NewTracer creates a new tracer. Queries are logged at the debug level, or at the warn level
+// if they take longer than slowQuery; a zero slowQuery logs every query at the debug level.
+// The observe callback may be nil.
+func NewTracer(log *slog.Logger, slowQuery time.Duration, observe func(QueryStats)) *Tracer {
+    return &Tracer{
+        log:       log,
+        slowQuery: slowQuery,
+        observe:   observe,
+    }
+}
+
+type traceKey struct{}
+
+type trace struct {
+    sql   string
+    start time.Time
+    batch int
+    err   error
+}
+
+func (t *Tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+    return context.WithValue(ctx, traceKey{}, &trace{sql: data.SQL, start: time.Now()})
+}
+
+func (t *Tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+    tr, ok := ctx.Value(traceKey{}).(*trace)
+    if !ok {
+        return
+    }
+    tr.err = data.Err
+
+    t.finish(ctx, tr)
+}
+
+func (t *Tracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+    return context.WithValue(ctx, traceKey{}, &trace{start: time.Now()})
+}
+
+func (t *Tracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+    tr, ok := ctx.Value(traceKey{}).(*trace)
+    if !ok {
+        return
+    }
+
+    if tr.batch == 0 {
+        tr.sql = data.SQL
+    }
+    tr.batch++
+    if tr.err == nil {
+        tr.err = data.Err
+    }
+}
+
+func (t *Tracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+    tr, ok := ctx.Value(traceKey{}).(*trace)
+    if !ok {
+        return
+    }
+    if tr.err == nil {
+        tr.err = data.Err
+    }
+
+    t.finish(ctx, tr)
+}
+
+func (t *Tracer) finish(ctx context.Context, tr *trace) {
+    stats := QueryStats{
+        SQL:      tr.sql,
+        Duration: time.Since(tr.start),
+        Err:      tr.err,
+        Batch:    tr.batch,
+    }
+
+    if t.observe != nil {
+        t.observe(stats)
+    }
+
+    level := slog.LevelDebug
+    msg := "query executed"
+    if t.slowQuery > 0 && stats.Duration > t.slowQuery {
+        level = slog.LevelWarn
+        msg = "slow query"
+    }
+    // Failures are reported by the callers, and some are expected, such as unique violations.
+    if stats.Err != nil {
+        msg = "query failed"
+    }
+
+    if !t.log.Enabled(ctx, level) {
+        return
+    }
+
+    attrs := []slog.Attr{
+        slog.String("sql", stats.SQL),
+        slog.Duration("duration", stats.Duration),
+    }
+    if stats.Batch > 0 {
+        attrs = append(attrs, slog.Int("batch", stats.Batch))
+    }
+    if stats.Err != nil {
+        attrs = append(attrs, slog.String("err", stats.Err.Error()))
+    }
+
+    t.log.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,72 @@
+package postgres_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"grpc-service-ref/internal/storage/postgres"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer_Query(t *testing.T) {
+    var buf bytes.Buffer
+    log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+    var stats []postgres.QueryStats
+    tracer := postgres.NewTracer(log, time.Hour, func(s postgres.QueryStats) { stats = append(stats, s) })
+
+    ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{
+        SQL:  "SELECT id FROM users WHERE email = $1",
+        Args: []any{"secret@example.com"},
+    })
+    tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+    require.Len(t, stats, 1)
+    assert.Equal(t, "SELECT id FROM users WHERE email = $1", stats[0].SQL)
+    assert.NoError(t, stats[0].Err)
+    assert.Zero(t, stats[0].Batch)
+
+    assert.Contains(t, buf.String(), "level=DEBUG")
+    assert.Contains(t, buf.String(), "query executed")
+    assert.NotContains(t, buf.String(), "secret@example.com")
+}
+
+func TestTracer_SlowQuery(t *testing.T) {
+    var buf bytes.Buffer
+    log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+    tracer := postgres.NewTracer(log, time.Millisecond, nil)
+
+    ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT pg_sleep(1)"})
+    time.Sleep(5 * time.Millisecond)
+    tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+    assert.Contains(t, buf.String(), "level=WARN")
+    assert.Contains(t, buf.String(), "slow query")
+}
+
+func TestTracer_Batch(t *testing.T) {
+    log := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+
+    var stats []postgres.QueryStats
+    tracer := postgres.NewTracer(log, 0, func(s postgres.QueryStats) { stats = append(stats, s) })
+
+    errUnique := errors.New("unique violation")
+
+    ctx := tracer.TraceBatchStart(context.Background(), nil, pgx.TraceBatchStartData{})
+    tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: "UPDATE users SET roles = $2 WHERE id = $1"})
+    tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: "INSERT INTO outbox", Err: errUnique})
+    tracer.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{})
+
+    require.Len(t, stats, 1)
+    assert.Equal(t, "UPDATE users SET roles = $2 WHERE id = $1", stats[0].SQL)
+    assert.Equal(t, 2, stats[0].Batch)
+    assert.ErrorIs(t, stats[0].Err, errUnique)
+}
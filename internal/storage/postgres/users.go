@@ -0,0 +1,308 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// userColumns store missing identifiers as NULL, which keeps them out of the unique indexes.
+const userColumns = `id, ` + emailColumns + `, coalesce(username, ''), ` + phoneColumns + `, phone_verified_at,
+    pass_hash, roles, status, status_reason, suspended_until, created_at, ` + profileColumns
+
+// likeEscaper escapes LIKE wildcards in user input.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Users returns up to limit users matching the filter with ids greater than afterID, ordered by id.
+// Encrypted emails match the email prefix of the filter only if it is the whole address.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter, afterID int64, limit int) ([]models.User, error) {
+    const op = "storage.postgres.Users"
+
+    var users []models.User
+    err := s.read(ctx, func(q querier) error {
+        rows, err := q.Query(ctx, `
+            SELECT `+userColumns+`
+            FROM users
+            WHERE id > $1
+              AND ($2::text = '' OR lower(email) LIKE lower($2::text) || '%' OR email_idx = $6)
+              AND ($3::text = '' OR status = $3::text)
+              AND ($4::text = '' OR roles @> ARRAY[$4::text])
+              AND ($7::bigint = 0 OR EXISTS (
+                  SELECT 1 FROM org_members m WHERE m.org_id = $7 AND m.user_id = users.id
+              ))
+              AND `+tenantCondition("users.id", 8)+`
+            ORDER BY id
+            LIMIT $5`,
+            afterID, likeEscaper.Replace(filter.EmailPrefix), filter.Status, filter.Role, limit,
+            s.pii.index(filter.EmailPrefix, columnEmail), filter.OrgID, storage.Tenant(ctx),
+        )
+        if err != nil {
+            return err
+        }
+        defer rows.Close()
+
+        users = nil
+        for rows.Next() {
+            user, err := s.scanUser(ctx, rows)
+            if err != nil {
+                return err
+            }
+            users = append(users, user)
+        }
+
+        return rows.Err()
+    })
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return users, nil
+}
+
+// ChangeUserStatus applies the status transition to the user and records it.
+// The transition is applied only if the user still has the status it starts from,
+// otherwise ErrStatusConflict is returned.
+func (s *Storage) ChangeUserStatus(ctx context.Context, t models.StatusTransition) error {
+    const op = "storage.postgres.ChangeUserStatus"
+
+    batch := &pgx.Batch{}
+    batch.Queue(`
+        UPDATE users SET status = $3, status_reason = $4, suspended_until = $5
+        WHERE id = $1 AND status = $2 AND `+tenantCondition("users.id", 6)+`
+        RETURNING `+identifierColumns,
+        t.UserID, t.From, t.To, t.Reason, nullTime(t.Until), storage.Tenant(ctx),
+    )
+    batch.Queue(`
+        INSERT INTO user_status_transitions(user_id, from_status, to_status, reason, until, actor, created_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)`,
+        t.UserID, t.From, t.To, t.Reason, nullTime(t.Until), t.Actor, t.CreatedAt,
+    )
+    err := queueEnqueue(batch, models.DomainEventUserStatusChanged, t.UserID, models.UserStatusChanged{
+        UserID: t.UserID,
+        From:   t.From,
+        To:     t.To,
+        Reason: t.Reason,
+    })
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var (
+        email, phone piiValue
+        username     string
+    )
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        if err := br.QueryRow().Scan(&email.plain, &email.sealed, &username, &phone.plain, &phone.sealed); err != nil {
+            if errors.Is(err, pgx.ErrNoRows) {
+                return storage.ErrStatusConflict
+            }
+            return err
+        }
+
+        for range batch.Len() - 1 {
+            if _, err := br.Exec(); err != nil {
+                return err
+            }
+        }
+
+        return nil
+    })
+    if errors.Is(err, storage.ErrStatusConflict) {
+        // The user either changed the status concurrently or doesn't exist.
+        if err := tx.Rollback(ctx); err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+
+        var exists bool
+        err := s.pool.QueryRow(ctx,
+            "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND "+tenantCondition("users.id", 2)+")",
+            t.UserID, storage.Tenant(ctx),
+        ).Scan(&exists)
+        if err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+        if !exists {
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return fmt.Errorf("%s: %w", op, storage.ErrStatusConflict)
+    }
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.wroteIdentifiers(ctx, t.UserID, email, username, phone)
+
+    return nil
+}
+
+// StatusTransitions returns the status transitions of the user, oldest first.
+func (s *Storage) StatusTransitions(ctx context.Context, userID int64) ([]models.StatusTransition, error) {
+    const op = "storage.postgres.StatusTransitions"
+
+    transitions, err := statusTransitions(ctx, s.pool, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return transitions, nil
+}
+
+func statusTransitions(ctx context.Context, q querier, userID int64) ([]models.StatusTransition, error) {
+    rows, err := q.Query(ctx, `
+        SELECT id, user_id, from_status, to_status, reason, until, actor, created_at
+        FROM user_status_transitions
+        WHERE user_id = $1 AND `+tenantCondition("user_status_transitions.user_id", 2)+`
+        ORDER BY id`,
+        userID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var transitions []models.StatusTransition
+    for rows.Next() {
+        var (
+            t     models.StatusTransition
+            until pgtype.Timestamptz
+        )
+        err := rows.Scan(&t.ID, &t.UserID, &t.From, &t.To, &t.Reason, &until, &t.Actor, &t.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+        t.Until = until.Time
+        transitions = append(transitions, t)
+    }
+
+    return transitions, rows.Err()
+}
+
+// SetUserRoles replaces the roles of the user and publishes a user.roles_changed event.
+func (s *Storage) SetUserRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.postgres.SetUserRoles"
+
+    return s.updateUser(ctx, op, id,
+        models.DomainEventUserRolesChanged, models.UserRolesChanged{UserID: id, Roles: roles},
+        `UPDATE users SET roles = $2
+        WHERE id = $1 AND `+tenantCondition("users.id", 3)+`
+        RETURNING `+identifierColumns,
+        textArray(roles),
+    )
+}
+
+// UpdatePassword replaces the password hash of the user and publishes a user.password_changed event.
+func (s *Storage) UpdatePassword(ctx context.Context, id int64, passHash []byte) error {
+    const op = "storage.postgres.UpdatePassword"
+
+    return s.updateUser(ctx, op, id,
+        models.DomainEventPasswordChanged, models.PasswordChanged{UserID: id},
+        `UPDATE users SET pass_hash = $2
+        WHERE id = $1 AND `+tenantCondition("users.id", 3)+`
+        RETURNING `+identifierColumns,
+        passHash,
+    )
+}
+
+// updateUser runs the update of the user with the id as $1, the value as $2 and the tenant of the context
+// as $3, returning identifierColumns, and publishes the event in the same transaction, sending both in one batch.
+func (s *Storage) updateUser(
+    ctx context.Context,
+    op string,
+    id int64,
+    eventType string,
+    payload any,
+    query string,
+    value any,
+) error {
+    batch := &pgx.Batch{}
+    batch.Queue(query, id, value, storage.Tenant(ctx))
+    if err := queueEnqueue(batch, eventType, id, payload); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var (
+        email, phone piiValue
+        username     string
+    )
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        if err := br.QueryRow().Scan(&email.plain, &email.sealed, &username, &phone.plain, &phone.sealed); err != nil {
+            if errors.Is(err, pgx.ErrNoRows) {
+                return storage.ErrUserNotFound
+            }
+            return err
+        }
+        _, err := br.Exec()
+        return err
+    })
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    s.wroteIdentifiers(ctx, id, email, username, phone)
+
+    return nil
+}
+
+// tenantCondition is the condition of the user referenced by the column being a member of the tenant
+// of the context, see storage.WithTenant, passed as the parameter n. Without a tenant it always holds.
+func tenantCondition(column string, n int) string {
+    return fmt.Sprintf(`($%[1]d::bigint = 0 OR EXISTS (
+        SELECT 1 FROM org_members m WHERE m.org_id = $%[1]d AND m.user_id = %[2]s
+    ))`, n, column)
+}
+
+// scanUser scans the user of userColumns, decrypting its personal data.
+func (s *Storage) scanUser(ctx context.Context, row pgx.Row) (models.User, error) {
+    var (
+        user            models.User
+        email, phone    piiValue
+        phoneVerifiedAt pgtype.Timestamptz
+        suspendedUntil  pgtype.Timestamptz
+    )
+
+    err := scanProfile(row, &user.Profile,
+        &user.ID, &email.plain, &email.sealed, &user.Username, &phone.plain, &phone.sealed, &phoneVerifiedAt,
+        &user.PassHash, &user.Roles, &user.Status, &user.StatusReason, &suspendedUntil, &user.CreatedAt,
+    )
+    if err != nil {
+        return models.User{}, err
+    }
+
+    if user.Email, err = s.pii.open(ctx, email, columnEmail); err != nil {
+        return models.User{}, err
+    }
+    if user.Phone, err = s.pii.open(ctx, phone, columnPhone); err != nil {
+        return models.User{}, err
+    }
+
+    user.PhoneVerifiedAt = phoneVerifiedAt.Time
+    user.SuspendedUntil = suspendedUntil.Time
+
+    return user, nil
+}
@@ -0,0 +1,429 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+    webhookSubscriptionColumns = `id, app, url, event_types, secret, consecutive_failures, created_at, disabled_at`
+    webhookDeliveryColumns     = `id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+        last_status_code, last_error, created_at, delivered_at, replay_of`
+)
+
+// SaveWebhookSubscription saves the subscription and returns its id.
+func (s *Storage) SaveWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (int64, error) {
+    const op = "storage.postgres.SaveWebhookSubscription"
+
+    var id int64
+    err := s.pool.QueryRow(ctx, `
+        INSERT INTO webhook_subscriptions(app, url, event_types, secret, created_at)
+        VALUES($1, $2, $3, $4, $5)
+        RETURNING id`,
+        sub.App, sub.URL, textArray(sub.EventTypes), sub.Secret, sub.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// WebhookSubscription returns the subscription by id.
+func (s *Storage) WebhookSubscription(ctx context.Context, id int64) (models.WebhookSubscription, error) {
+    const op = "storage.postgres.WebhookSubscription"
+
+    row := s.pool.QueryRow(ctx,
+        "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE id = $1",
+        id,
+    )
+
+    sub, err := scanWebhookSubscription(row)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookSubscriptionNotFound)
+        }
+
+        return models.WebhookSubscription{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sub, nil
+}
+
+// WebhookSubscriptions returns subscriptions of the app ordered by id.
+func (s *Storage) WebhookSubscriptions(ctx context.Context, app string) ([]models.WebhookSubscription, error) {
+    const op = "storage.postgres.WebhookSubscriptions"
+
+    rows, err := s.pool.Query(ctx,
+        "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE app = $1 ORDER BY id",
+        app,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var subs []models.WebhookSubscription
+    for rows.Next() {
+        sub, err := scanWebhookSubscription(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        subs = append(subs, sub)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return subs, nil
+}
+
+// UpdateWebhookSubscription replaces the URL, the event types, the secret, the failure count
+// and the disabled time of the subscription.
+func (s *Storage) UpdateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) error {
+    const op = "storage.postgres.UpdateWebhookSubscription"
+
+    tag, err := s.pool.Exec(ctx, `
+        UPDATE webhook_subscriptions
+        SET url = $2, event_types = $3, secret = $4, consecutive_failures = $5, disabled_at = $6
+        WHERE id = $1`,
+        sub.ID, sub.URL, textArray(sub.EventTypes), sub.Secret, sub.ConsecutiveFailures, nullTime(sub.DisabledAt),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return webhookSubscriptionAffected(op, tag)
+}
+
+// DeleteWebhookSubscription deletes the subscription along with its deliveries.
+func (s *Storage) DeleteWebhookSubscription(ctx context.Context, id int64) error {
+    const op = "storage.postgres.DeleteWebhookSubscription"
+
+    tag, err := s.pool.Exec(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return webhookSubscriptionAffected(op, tag)
+}
+
+// EnqueueWebhookDeliveries creates a delivery of the event for every enabled subscription to its type
+// and returns the number of created deliveries. Events that were already enqueued are skipped.
+func (s *Storage) EnqueueWebhookDeliveries(
+    ctx context.Context,
+    eventID int64,
+    eventType string,
+    payload []byte,
+) (int64, error) {
+    const op = "storage.postgres.EnqueueWebhookDeliveries"
+
+    tag, err := s.pool.Exec(ctx, `
+        INSERT INTO webhook_deliveries(subscription_id, event_id, event_type, payload)
+        SELECT id, $1, $2, $3
+        FROM webhook_subscriptions
+        WHERE disabled_at IS NULL AND $2 = ANY(event_types)
+        ON CONFLICT (subscription_id, event_id) WHERE replay_of IS NULL DO NOTHING`,
+        eventID, eventType, payload,
+    )
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return tag.RowsAffected(), nil
+}
+
+// SaveWebhookDelivery saves a pending delivery and returns its id.
+func (s *Storage) SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error) {
+    const op = "storage.postgres.SaveWebhookDelivery"
+
+    var replayOf pgtype.Int8
+    if d.ReplayOf != 0 {
+        replayOf = pgtype.Int8{Int64: d.ReplayOf, Valid: true}
+    }
+
+    var id int64
+    err := s.pool.QueryRow(ctx, `
+        INSERT INTO webhook_deliveries(subscription_id, event_id, event_type, payload, replay_of)
+        VALUES($1, $2, $3, $4, $5)
+        RETURNING id`,
+        d.SubscriptionID, d.EventID, d.EventType, d.Payload, replayOf,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries to enabled subscriptions that are due,
+// oldest first, and postpones their next attempt by the lease so that concurrent senders skip them.
+func (s *Storage) ClaimWebhookDeliveries(
+    ctx context.Context,
+    limit int,
+    lease time.Duration,
+) ([]models.WebhookDelivery, error) {
+    const op = "storage.postgres.ClaimWebhookDeliveries"
+
+    rows, err := s.pool.Query(ctx, `
+        UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 millisecond'
+        WHERE id IN (
+            SELECT d.id FROM webhook_deliveries d
+            JOIN webhook_subscriptions s ON s.id = d.subscription_id
+            WHERE d.status = 'pending' AND d.next_attempt_at <= now() AND s.disabled_at IS NULL
+            ORDER BY d.id
+            LIMIT $1
+            FOR UPDATE OF d SKIP LOCKED
+        )
+        RETURNING `+webhookDeliveryColumns,
+        limit, lease.Milliseconds(),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    deliveries, err := scanWebhookDeliveries(rows)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    slices.SortFunc(deliveries, func(a, b models.WebhookDelivery) int { return cmp.Compare(a.ID, b.ID) })
+
+    return deliveries, nil
+}
+
+// RecordWebhookAttempt saves the attempt and moves its delivery to the status, scheduling the next
+// attempt of pending deliveries. Failed attempts are counted against the subscription, which is
+// disabled once disableAfter attempts in a row failed; successful ones reset the count.
+// It reports whether the subscription got disabled.
+func (s *Storage) RecordWebhookAttempt(
+    ctx context.Context,
+    attempt models.WebhookAttempt,
+    status models.WebhookDeliveryStatus,
+    nextAttemptAt time.Time,
+    disableAfter int,
+) (bool, error) {
+    const op = "storage.postgres.RecordWebhookAttempt"
+
+    var deliveredAt pgtype.Timestamptz
+    if status == models.WebhookDeliverySucceeded {
+        deliveredAt = pgtype.Timestamptz{Time: attempt.AttemptedAt, Valid: true}
+    }
+
+    batch := &pgx.Batch{}
+    batch.Queue(`
+        UPDATE webhook_deliveries
+        SET status = $2, attempts = attempts + 1, next_attempt_at = $3,
+            last_status_code = $4, last_error = $5, delivered_at = $6
+        WHERE id = $1`,
+        attempt.DeliveryID, status, nextAttemptAt, attempt.StatusCode, attempt.Error, deliveredAt,
+    )
+    batch.Queue(`
+        INSERT INTO webhook_delivery_attempts(delivery_id, status_code, error, duration_ms, attempted_at)
+        VALUES($1, $2, $3, $4, $5)`,
+        attempt.DeliveryID, attempt.StatusCode, attempt.Error, attempt.Duration.Milliseconds(), attempt.AttemptedAt,
+    )
+    if attempt.Succeeded() {
+        batch.Queue(`
+            UPDATE webhook_subscriptions SET consecutive_failures = 0
+            WHERE id = (SELECT subscription_id FROM webhook_deliveries WHERE id = $1)`,
+            attempt.DeliveryID,
+        )
+    } else {
+        batch.Queue(`
+            UPDATE webhook_subscriptions
+            SET consecutive_failures = consecutive_failures + 1,
+                disabled_at = CASE
+                    WHEN disabled_at IS NULL AND consecutive_failures + 1 >= $2 THEN $3
+                    ELSE disabled_at
+                END
+            WHERE id = (SELECT subscription_id FROM webhook_deliveries WHERE id = $1)
+            RETURNING disabled_at IS NOT NULL AND disabled_at = $3`,
+            attempt.DeliveryID, disableAfter, attempt.AttemptedAt,
+        )
+    }
+
+    tx, err := s.pool.Begin(ctx)
+    if err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback(ctx)
+
+    var disabled bool
+    err = sendBatch(ctx, tx, batch, func(br pgx.BatchResults) error {
+        tag, err := br.Exec()
+        if err != nil {
+            return err
+        }
+        if tag.RowsAffected() == 0 {
+            return storage.ErrWebhookDeliveryNotFound
+        }
+        if _, err := br.Exec(); err != nil {
+            return err
+        }
+        if attempt.Succeeded() {
+            _, err := br.Exec()
+            return err
+        }
+        return br.QueryRow().Scan(&disabled)
+    })
+    if err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return false, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return disabled, nil
+}
+
+// WebhookDelivery returns the delivery by id.
+func (s *Storage) WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+    const op = "storage.postgres.WebhookDelivery"
+
+    rows, err := s.pool.Query(ctx,
+        "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = $1",
+        id,
+    )
+    if err != nil {
+        return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    deliveries, err := scanWebhookDeliveries(rows)
+    if err != nil {
+        return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if len(deliveries) == 0 {
+        return models.WebhookDelivery{}, fmt.Errorf("%s: %w", op, storage.ErrWebhookDeliveryNotFound)
+    }
+
+    return deliveries[0], nil
+}
+
+// WebhookDeliveries returns up to limit deliveries of the subscription with ids below beforeID, newest first.
+// A zero beforeID starts from the newest delivery.
+func (s *Storage) WebhookDeliveries(
+    ctx context.Context,
+    subscriptionID int64,
+    beforeID int64,
+    limit int,
+) ([]models.WebhookDelivery, error) {
+    const op = "storage.postgres.WebhookDeliveries"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT `+webhookDeliveryColumns+`
+        FROM webhook_deliveries
+        WHERE subscription_id = $1 AND ($2::bigint = 0 OR id < $2::bigint)
+        ORDER BY id DESC
+        LIMIT $3`,
+        subscriptionID, beforeID, limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    deliveries, err := scanWebhookDeliveries(rows)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return deliveries, nil
+}
+
+// WebhookAttempts returns the attempts of the delivery, oldest first.
+func (s *Storage) WebhookAttempts(ctx context.Context, deliveryID int64) ([]models.WebhookAttempt, error) {
+    const op = "storage.postgres.WebhookAttempts"
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT id, delivery_id, status_code, error, duration_ms, attempted_at
+        FROM webhook_delivery_attempts
+        WHERE delivery_id = $1
+        ORDER BY id`,
+        deliveryID,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var attempts []models.WebhookAttempt
+    for rows.Next() {
+        var (
+            a          models.WebhookAttempt
+            durationMs int64
+        )
+        if err := rows.Scan(&a.ID, &a.DeliveryID, &a.StatusCode, &a.Error, &durationMs, &a.AttemptedAt); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        a.Duration = time.Duration(durationMs) * time.Millisecond
+        attempts = append(attempts, a)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return attempts, nil
+}
+
+func webhookSubscriptionAffected(op string, tag pgconn.CommandTag) error {
+    if tag.RowsAffected() == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrWebhookSubscriptionNotFound)
+    }
+
+    return nil
+}
+
+func scanWebhookSubscription(row pgx.Row) (models.WebhookSubscription, error) {
+    var (
+        sub        models.WebhookSubscription
+        disabledAt pgtype.Timestamptz
+    )
+
+    err := row.Scan(
+        &sub.ID, &sub.App, &sub.URL, &sub.EventTypes, &sub.Secret,
+        &sub.ConsecutiveFailures, &sub.CreatedAt, &disabledAt,
+    )
+    if err != nil {
+        return models.WebhookSubscription{}, err
+    }
+
+    sub.DisabledAt = disabledAt.Time
+
+    return sub, nil
+}
+
+func scanWebhookDeliveries(rows pgx.Rows) ([]models.WebhookDelivery, error) {
+    defer rows.Close()
+
+    var deliveries []models.WebhookDelivery
+    for rows.Next() {
+        var (
+            d           models.WebhookDelivery
+            deliveredAt pgtype.Timestamptz
+            replayOf    pgtype.Int8
+        )
+        err := rows.Scan(
+            &d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+            &d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &deliveredAt, &replayOf,
+        )
+        if err != nil {
+            return nil, err
+        }
+        d.DeliveredAt = deliveredAt.Time
+        d.ReplayOf = replayOf.Int64
+        deliveries = append(deliveries, d)
+    }
+
+    return deliveries, rows.Err()
+}
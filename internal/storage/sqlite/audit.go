@@ -0,0 +1,199 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"slices"
+)
+
+// AppendAuditEvent appends the event to the audit log, chaining its hash to the last entry,
+// and returns the stored event. The write lock the transaction takes serializes appends.
+// Personal data is sealed with the audit keys of the users it belongs to, created as needed.
+func (s *Storage) AppendAuditEvent(ctx context.Context, event models.AuditEvent) (models.AuditEvent, error) {
+    const op = "storage.sqlite.AppendAuditEvent"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&event.PrevHash)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if event.PrevHash == nil {
+        event.PrevHash = []byte{}
+    }
+
+    keys, err := ensureAuditKeys(ctx, tx, audit.KeyRefs(event))
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    event, err = audit.Seal(event, keys)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    event.Hash = event.ComputeHash()
+
+    details := event.Details
+    if details == nil {
+        details = map[string]string{}
+    }
+    rawDetails, err := json.Marshal(details)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+    sealed := event.Sealed
+    if sealed == nil {
+        sealed = map[string]string{}
+    }
+    rawSealed, err := json.Marshal(sealed)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = tx.QueryRowContext(ctx, `
+        INSERT INTO audit_log(type, actor, subject, ip, user_agent, outcome, reason, details, sealed, created_at, prev_hash, hash)
+        VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+        RETURNING id`,
+        event.Type, event.Actor, event.Subject, event.IP, event.UserAgent, event.Outcome, event.Reason,
+        string(rawDetails), string(rawSealed), event.CreatedAt, event.PrevHash, event.Hash,
+    ).Scan(&event.ID)
+    if err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return models.AuditEvent{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return audit.Open(event, keys), nil
+}
+
+// AuditEvents returns up to limit audit events matching the filter with ids less than beforeID,
+// newest first. A zero beforeID starts from the newest event. Personal data whose audit keys
+// were deleted is left out.
+func (s *Storage) AuditEvents(
+    ctx context.Context,
+    filter models.AuditFilter,
+    beforeID int64,
+    limit int,
+) ([]models.AuditEvent, error) {
+    const op = "storage.sqlite.AuditEvents"
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT id, type, actor, subject, ip, user_agent, outcome, reason, details, sealed, created_at, prev_hash, hash
+        FROM audit_log
+        WHERE ($1 = 0 OR id < $1)
+          AND ($2 = '' OR type = $2)
+          AND ($3 = '' OR actor = $3)
+          AND ($4 = '' OR subject = $4)
+          AND ($5 IS NULL OR created_at >= $5)
+          AND ($6 IS NULL OR created_at < $6)
+        ORDER BY id DESC
+        LIMIT $7`,
+        beforeID, filter.Type, filter.Actor, filter.Subject, nullTime(filter.Since), nullTime(filter.Until), limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var events []models.AuditEvent
+    for rows.Next() {
+        var (
+            event                 models.AuditEvent
+            rawDetails, rawSealed string
+        )
+        err := rows.Scan(
+            &event.ID, &event.Type, &event.Actor, &event.Subject, &event.IP, &event.UserAgent,
+            &event.Outcome, &event.Reason, &rawDetails, &rawSealed, timestamp(&event.CreatedAt), &event.PrevHash, &event.Hash,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        if err := json.Unmarshal([]byte(rawDetails), &event.Details); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        if err := json.Unmarshal([]byte(rawSealed), &event.Sealed); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        if len(event.Sealed) == 0 {
+            event.Sealed = nil
+        }
+        events = append(events, event)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    rows.Close()
+
+    keys, err := auditKeys(ctx, s.db, audit.SealedRefs(events...))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    for i := range events {
+        events[i] = audit.Open(events[i], keys)
+    }
+
+    return events, nil
+}
+
+// ensureAuditKeys returns the audit keys of the references by field, creating the missing ones.
+func ensureAuditKeys(ctx context.Context, tx *sql.Tx, refs map[string]string) (map[string][]byte, error) {
+    var unique []string
+    for _, ref := range refs {
+        if slices.Contains(unique, ref) {
+            continue
+        }
+        key, err := audit.NewKey()
+        if err != nil {
+            return nil, err
+        }
+        _, err = tx.ExecContext(ctx, "INSERT INTO audit_keys(ref, key) VALUES($1, $2) ON CONFLICT (ref) DO NOTHING", ref, key)
+        if err != nil {
+            return nil, err
+        }
+        unique = append(unique, ref)
+    }
+
+    return auditKeys(ctx, tx, unique)
+}
+
+// auditKeys returns the existing audit keys of the references.
+func auditKeys(ctx context.Context, q querier, refs []string) (map[string][]byte, error) {
+    keys := make(map[string][]byte, len(refs))
+    if len(refs) == 0 {
+        return keys, nil
+    }
+
+    rawRefs, err := json.Marshal(refs)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := q.QueryContext(ctx, "SELECT ref, key FROM audit_keys WHERE ref IN (SELECT value FROM json_each($1))", string(rawRefs))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var (
+            ref string
+            key []byte
+        )
+        if err := rows.Scan(&ref, &key); err != nil {
+            return nil, err
+        }
+        keys[ref] = key
+    }
+
+    return keys, rows.Err()
+}
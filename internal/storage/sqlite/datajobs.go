@@ -0,0 +1,451 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/storage"
+	"slices"
+	"time"
+)
+
+const dataJobColumns = `id, kind, user_id, status, actor, reason, attempts, next_attempt_at, last_error, archive,
+    created_at, started_at, finished_at, expires_at`
+
+// querier runs queries on the database or within a transaction.
+type querier interface {
+    QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SaveDataJob saves a pending job and returns its id.
+func (s *Storage) SaveDataJob(ctx context.Context, job models.DataJob) (int64, error) {
+    const op = "storage.sqlite.SaveDataJob"
+
+    var id int64
+    err := s.db.QueryRowContext(ctx, `
+        INSERT INTO data_jobs(kind, user_id, actor, reason, next_attempt_at, created_at)
+        VALUES($1, $2, $3, $4, $5, $5)
+        RETURNING id`,
+        job.Kind, job.UserID, job.Actor, job.Reason, job.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// DataJob returns the job by id along with its archive.
+func (s *Storage) DataJob(ctx context.Context, id int64) (models.DataJob, error) {
+    const op = "storage.sqlite.DataJob"
+
+    job, err := scanDataJob(s.db.QueryRowContext(ctx, "SELECT "+dataJobColumns+" FROM data_jobs WHERE id = $1", id))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.DataJob{}, fmt.Errorf("%s: %w", op, storage.ErrDataJobNotFound)
+        }
+
+        return models.DataJob{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return job, nil
+}
+
+// ClaimDataJobs returns up to limit unfinished jobs that are due, oldest first, marks them running
+// and postpones their next attempt by the lease so that concurrent workers skip them.
+// Jobs of a worker that stops mid-run are run again once the lease ends.
+func (s *Storage) ClaimDataJobs(ctx context.Context, limit int, lease time.Duration) ([]models.DataJob, error) {
+    const op = "storage.sqlite.ClaimDataJobs"
+
+    now := time.Now()
+
+    // A single statement is atomic, so no other worker claims the same jobs.
+    rows, err := s.db.QueryContext(ctx, `
+        UPDATE data_jobs
+        SET status = 'running', next_attempt_at = $3, started_at = coalesce(started_at, $2)
+        WHERE id IN (
+            SELECT id FROM data_jobs
+            WHERE status IN ('pending', 'running') AND next_attempt_at <= $2
+            ORDER BY id
+            LIMIT $1
+        )
+        RETURNING `+dataJobColumns,
+        limit, now, now.Add(lease),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var jobs []models.DataJob
+    for rows.Next() {
+        job, err := scanDataJob(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        jobs = append(jobs, job)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    slices.SortFunc(jobs, func(a, b models.DataJob) int { return cmp.Compare(a.ID, b.ID) })
+
+    return jobs, nil
+}
+
+// CompleteDataJob records the success of the job with the archive of an export,
+// which is kept until expiresAt.
+func (s *Storage) CompleteDataJob(ctx context.Context, id int64, archive []byte, finishedAt, expiresAt time.Time) error {
+    const op = "storage.sqlite.CompleteDataJob"
+
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE data_jobs
+        SET status = 'succeeded', attempts = attempts + 1, last_error = '', archive = $2,
+            finished_at = $3, expires_at = $4
+        WHERE id = $1`,
+        id, archive, finishedAt, nullTime(expiresAt),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RetryDataJob records a failed run of the job and schedules the next attempt.
+func (s *Storage) RetryDataJob(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+    const op = "storage.sqlite.RetryDataJob"
+
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE data_jobs SET status = 'pending', attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+        WHERE id = $1`,
+        id, lastErr, nextAttemptAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// FailDataJob records the last failed run of the job and stops retrying it.
+func (s *Storage) FailDataJob(ctx context.Context, id int64, lastErr string, finishedAt time.Time) error {
+    const op = "storage.sqlite.FailDataJob"
+
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE data_jobs SET status = 'failed', attempts = attempts + 1, last_error = $2, finished_at = $3
+        WHERE id = $1`,
+        id, lastErr, finishedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// PurgeDataJobArchives removes archives that expired before the time and returns their number.
+func (s *Storage) PurgeDataJobArchives(ctx context.Context, before time.Time) (int64, error) {
+    const op = "storage.sqlite.PurgeDataJobArchives"
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE data_jobs SET archive = NULL
+        WHERE archive IS NOT NULL AND expires_at <= $1`,
+        before,
+    )
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return n, nil
+}
+
+// UserData returns the personal data stored about the user, read within a single transaction.
+func (s *Storage) UserData(ctx context.Context, userID int64) (models.UserData, error) {
+    const op = "storage.sqlite.UserData"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var data models.UserData
+
+    data.User, err = scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", userID))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.UserData{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if data.Identities, err = userIdentities(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.Sessions, err = userSessions(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.APIKeys, err = userAPIKeys(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.Transitions, err = statusTransitions(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+    if data.Memberships, err = userOrganizations(ctx, tx, userID); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return models.UserData{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return data, nil
+}
+
+// EraseUser deletes the user along with its identities, sessions, API keys, phone codes, memberships
+// and status history, and the invitations and invite codes it accepted or that are for its email.
+// It removes the archives of its exports and reduces the payloads of outbox events and webhook
+// deliveries about it to the user id. A user.erased event is published. The audit log is
+// append-only: its entries are kept and the audit keys sealing the personal data in them are
+// deleted, along with the keys of the identifiers of the user.
+func (s *Storage) EraseUser(ctx context.Context, userID int64) error {
+    const op = "storage.sqlite.EraseUser"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var ids models.UserIdentifiers
+    err = tx.QueryRowContext(ctx, `
+        SELECT coalesce(email, ''), coalesce(username, ''), coalesce(phone, '')
+        FROM users
+        WHERE id = $1`,
+        userID,
+    ).Scan(&ids.Email, &ids.Username, &ids.Phone)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+    redacted, err := json.Marshal(models.UserErased{UserID: userID})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := redactWebhookDeliveries(ctx, tx, aggregate, redacted); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, "UPDATE outbox SET payload = $2 WHERE aggregate_id = $1", aggregate, redacted)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, "UPDATE data_jobs SET archive = NULL WHERE user_id = $1 AND archive IS NOT NULL", userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    // The audit log keeps the entries of the user, with its personal data sealed by these keys.
+    rawRefs, err := json.Marshal(audit.ErasureRefs(userID, ids))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    _, err = tx.ExecContext(ctx, "DELETE FROM audit_keys WHERE ref IN (SELECT value FROM json_each($1))", string(rawRefs))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    // Invitations keep their email after the user is gone, and invite codes for anyone have none.
+    for _, query := range []string{
+        "DELETE FROM org_invitations WHERE accepted_by = $1 OR ($2 <> '' AND lower(email) = lower($2))",
+        "DELETE FROM invite_codes WHERE used_by = $1 OR ($2 <> '' AND lower(email) = lower($2))",
+    } {
+        if _, err := tx.ExecContext(ctx, query, userID, ids.Email); err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    // Identities, sessions, API keys, phone codes, memberships and status transitions are deleted by cascade.
+    res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if err := userAffected(op, res); err != nil {
+        return err
+    }
+
+    if err := enqueue(ctx, tx, models.DomainEventUserErased, userID, models.UserErased{UserID: userID}); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// redactWebhookDeliveries replaces the event payload in the envelopes of the deliveries
+// of events about the aggregate.
+func redactWebhookDeliveries(ctx context.Context, tx *sql.Tx, aggregate string, payload []byte) error {
+    rows, err := tx.QueryContext(ctx, `
+        SELECT id, payload
+        FROM webhook_deliveries
+        WHERE event_id IN (SELECT id FROM outbox WHERE aggregate_id = $1)`,
+        aggregate,
+    )
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    redacted := make(map[int64][]byte)
+    for rows.Next() {
+        var (
+            id       int64
+            envelope map[string]json.RawMessage
+            raw      []byte
+        )
+        if err := rows.Scan(&id, &raw); err != nil {
+            return err
+        }
+        if err := json.Unmarshal(raw, &envelope); err != nil {
+            return err
+        }
+        envelope["payload"] = payload
+        if redacted[id], err = json.Marshal(envelope); err != nil {
+            return err
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for id, raw := range redacted {
+        if _, err := tx.ExecContext(ctx, "UPDATE webhook_deliveries SET payload = $2 WHERE id = $1", id, raw); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func userIdentities(ctx context.Context, q querier, userID int64) ([]models.Identity, error) {
+    rows, err := q.QueryContext(ctx, `
+        SELECT id, user_id, provider, subject, created_at
+        FROM user_identities
+        WHERE user_id = $1
+        ORDER BY id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var identities []models.Identity
+    for rows.Next() {
+        var ident models.Identity
+        err := rows.Scan(&ident.ID, &ident.UserID, &ident.Provider, &ident.Subject, timestamp(&ident.CreatedAt))
+        if err != nil {
+            return nil, err
+        }
+        identities = append(identities, ident)
+    }
+
+    return identities, rows.Err()
+}
+
+func userSessions(ctx context.Context, q querier, userID int64) ([]models.UserSession, error) {
+    rows, err := q.QueryContext(ctx, `
+        SELECT revoked_at, id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE user_id = $1
+        ORDER BY created_at, id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sessions []models.UserSession
+    for rows.Next() {
+        var revokedAt time.Time
+        session, err := scanSession(prefixedRow{scanner: rows, dest: []any{timestamp(&revokedAt)}})
+        if err != nil {
+            return nil, err
+        }
+        sessions = append(sessions, models.UserSession{Session: session, RevokedAt: revokedAt})
+    }
+
+    return sessions, rows.Err()
+}
+
+func userAPIKeys(ctx context.Context, q querier, userID int64) ([]models.UserAPIKey, error) {
+    rows, err := q.QueryContext(ctx, `
+        SELECT revoked_at, `+apiKeyColumns+`
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var keys []models.UserAPIKey
+    for rows.Next() {
+        var revokedAt time.Time
+        key, err := scanAPIKey(prefixedRow{scanner: rows, dest: []any{timestamp(&revokedAt)}})
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, models.UserAPIKey{APIKey: key, RevokedAt: revokedAt})
+    }
+
+    return keys, rows.Err()
+}
+
+// prefixedRow scans the leading columns of the row into dest and the rest into the destinations
+// of the Scan call, so that scan functions of a table read rows with extra columns.
+type prefixedRow struct {
+    scanner
+    dest []any
+}
+
+func (r prefixedRow) Scan(dest ...any) error {
+    return r.scanner.Scan(append(slices.Clip(r.dest), dest...)...)
+}
+
+func scanDataJob(row scanner) (models.DataJob, error) {
+    var job models.DataJob
+
+    err := row.Scan(
+        &job.ID, &job.Kind, &job.UserID, &job.Status, &job.Actor, &job.Reason, &job.Attempts,
+        timestamp(&job.NextAttemptAt), &job.LastError, &job.Archive, timestamp(&job.CreatedAt),
+        timestamp(&job.StartedAt), timestamp(&job.FinishedAt), timestamp(&job.ExpiresAt),
+    )
+
+    return job, err
+}
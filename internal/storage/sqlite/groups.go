@@ -0,0 +1,342 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"strconv"
+	"strings"
+)
+
+// CreateGroup creates the group and returns its id.
+func (s *Storage) CreateGroup(ctx context.Context, g models.Group) (int64, error) {
+    const op = "storage.sqlite.CreateGroup"
+
+    roles, err := jsonArray(g.Roles)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.db.QueryRowContext(ctx, `
+        INSERT INTO groups(name, description, roles, created_at)
+        VALUES($1, $2, $3, $4)
+        RETURNING id`,
+        g.Name, g.Description, roles, g.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// Group returns the group by id.
+func (s *Storage) Group(ctx context.Context, id int64) (models.Group, error) {
+    const op = "storage.sqlite.Group"
+
+    g, err := scanGroup(s.db.QueryRowContext(ctx,
+        "SELECT id, name, description, roles, created_at FROM groups WHERE id = $1", id))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+        }
+        return models.Group{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return g, nil
+}
+
+// Groups returns up to limit groups with ids greater than afterID, ordered by id.
+func (s *Storage) Groups(ctx context.Context, afterID int64, limit int) ([]models.Group, error) {
+    const op = "storage.sqlite.Groups"
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT id, name, description, roles, created_at
+        FROM groups
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2`,
+        afterID, limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var groups []models.Group
+    for rows.Next() {
+        g, err := scanGroup(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        groups = append(groups, g)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return groups, nil
+}
+
+// SetGroupRoles replaces the roles the group grants.
+func (s *Storage) SetGroupRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.sqlite.SetGroupRoles"
+
+    encoded, err := jsonArray(roles)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := s.db.ExecContext(ctx, "UPDATE groups SET roles = $2 WHERE id = $1", id, encoded)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrGroupNotFound)
+}
+
+// DeleteGroup deletes the group. Its members stay, they are no longer members through it.
+func (s *Storage) DeleteGroup(ctx context.Context, id int64) error {
+    const op = "storage.sqlite.DeleteGroup"
+
+    res, err := s.db.ExecContext(ctx, "DELETE FROM groups WHERE id = $1", id)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrGroupNotFound)
+}
+
+// AddGroupUser makes the user a direct member of the group.
+func (s *Storage) AddGroupUser(ctx context.Context, groupID, userID int64) error {
+    const op = "storage.sqlite.AddGroupUser"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    if err := groupsExist(ctx, tx, groupID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, "INSERT INTO group_users(group_id, user_id) VALUES($1, $2)", groupID, userID)
+    if err != nil {
+        switch {
+        case isUniqueViolation(err):
+            return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberExists)
+        case isForeignKeyViolation(err):
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RemoveGroupUser removes the user from the direct members of the group.
+func (s *Storage) RemoveGroupUser(ctx context.Context, groupID, userID int64) error {
+    const op = "storage.sqlite.RemoveGroupUser"
+
+    res, err := s.db.ExecContext(ctx, "DELETE FROM group_users WHERE group_id = $1 AND user_id = $2", groupID, userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrGroupMemberNotFound)
+}
+
+// AddSubgroup nests the subgroup in the group, unless the group is the subgroup
+// or is already nested in it, which would form a cycle.
+// Transactions take the write lock up front, so concurrent additions cannot form one either.
+func (s *Storage) AddSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+    const op = "storage.sqlite.AddSubgroup"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    if err := groupsExist(ctx, tx, groupID, subgroupID); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    var cycle bool
+    err = tx.QueryRowContext(ctx, `
+        WITH RECURSIVE nested(id) AS (
+            SELECT $1
+            UNION
+            SELECT s.subgroup_id FROM group_subgroups s JOIN nested n ON s.group_id = n.id
+        )
+        SELECT EXISTS (SELECT 1 FROM nested WHERE id = $2)`,
+        subgroupID, groupID,
+    ).Scan(&cycle)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if cycle {
+        return fmt.Errorf("%s: %w", op, storage.ErrGroupCycle)
+    }
+
+    _, err = tx.ExecContext(ctx, "INSERT INTO group_subgroups(group_id, subgroup_id) VALUES($1, $2)", groupID, subgroupID)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return fmt.Errorf("%s: %w", op, storage.ErrGroupMemberExists)
+        }
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RemoveSubgroup removes the subgroup from the group.
+func (s *Storage) RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error {
+    const op = "storage.sqlite.RemoveSubgroup"
+
+    res, err := s.db.ExecContext(ctx,
+        "DELETE FROM group_subgroups WHERE group_id = $1 AND subgroup_id = $2", groupID, subgroupID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrGroupMemberNotFound)
+}
+
+// GroupMembers returns the direct members of the group.
+func (s *Storage) GroupMembers(ctx context.Context, id int64) (models.GroupMembers, error) {
+    const op = "storage.sqlite.GroupMembers"
+
+    if err := groupsExist(ctx, s.db, id); err != nil {
+        return models.GroupMembers{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        members models.GroupMembers
+        err     error
+    )
+    members.UserIDs, err = queryIDs(ctx, s.db, "SELECT user_id FROM group_users WHERE group_id = $1 ORDER BY user_id", id)
+    if err != nil {
+        return models.GroupMembers{}, fmt.Errorf("%s: %w", op, err)
+    }
+    members.GroupIDs, err = queryIDs(ctx, s.db, "SELECT subgroup_id FROM group_subgroups WHERE group_id = $1 ORDER BY subgroup_id", id)
+    if err != nil {
+        return models.GroupMembers{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return members, nil
+}
+
+// UserGroups returns the groups the user is a member of, directly or through nested groups,
+// ordered by id, each with one of the shortest paths that make the user a member.
+func (s *Storage) UserGroups(ctx context.Context, userID int64) ([]models.UserGroup, error) {
+    const op = "storage.sqlite.UserGroups"
+
+    // Paths are comma separated group ids. Nesting has no cycles, the check on the path
+    // only keeps the query finite should one slip in.
+    rows, err := s.db.QueryContext(ctx, `
+        WITH RECURSIVE reachable(group_id, path, depth) AS (
+            SELECT group_id, CAST(group_id AS TEXT), 1 FROM group_users WHERE user_id = $1
+            UNION ALL
+            SELECT s.group_id, r.path || ',' || s.group_id, r.depth + 1
+            FROM reachable r
+            JOIN group_subgroups s ON s.subgroup_id = r.group_id
+            WHERE instr(',' || r.path || ',', ',' || s.group_id || ',') = 0
+        ),
+        shortest AS (
+            SELECT group_id, path, ROW_NUMBER() OVER (PARTITION BY group_id ORDER BY depth, path) AS n
+            FROM reachable
+        )
+        SELECT g.id, g.name, g.description, g.roles, g.created_at, s.path
+        FROM shortest s
+        JOIN groups g ON g.id = s.group_id
+        WHERE s.n = 1
+        ORDER BY g.id`,
+        userID,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var groups []models.UserGroup
+    for rows.Next() {
+        var (
+            ug   models.UserGroup
+            path string
+        )
+        g := &ug.Group
+        if err := rows.Scan(&g.ID, &g.Name, &g.Description, jsonStrings(&g.Roles), timestamp(&g.CreatedAt), &path); err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        for _, raw := range strings.Split(path, ",") {
+            id, err := strconv.ParseInt(raw, 10, 64)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %w", op, err)
+            }
+            ug.Path = append(ug.Path, id)
+        }
+        groups = append(groups, ug)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return groups, nil
+}
+
+// groupsExist returns storage.ErrGroupNotFound unless every group exists.
+func groupsExist(ctx context.Context, q querier, ids ...int64) error {
+    for _, id := range ids {
+        var exists bool
+        if err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM groups WHERE id = $1)", id).Scan(&exists); err != nil {
+            return err
+        }
+        if !exists {
+            return storage.ErrGroupNotFound
+        }
+    }
+
+    return nil
+}
+
+func queryIDs(ctx context.Context, q querier, query string, args ...any) ([]int64, error) {
+    rows, err := q.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+
+    return ids, rows.Err()
+}
+
+func scanGroup(row scanner) (models.Group, error) {
+    var g models.Group
+    err := row.Scan(&g.ID, &g.Name, &g.Description, jsonStrings(&g.Roles), timestamp(&g.CreatedAt))
+    return g, err
+}
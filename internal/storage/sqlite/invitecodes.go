@@ -0,0 +1,157 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+)
+
+const inviteCodeColumns = `id, code_hash, email, roles, org_id, org_roles, creator, created_at, expires_at,
+    used_at, used_by, revoked_at`
+
+// SaveInviteCode saves an unused invite code and returns its id.
+func (s *Storage) SaveInviteCode(ctx context.Context, code models.InviteCode) (int64, error) {
+    const op = "storage.sqlite.SaveInviteCode"
+
+    roles, err := jsonArray(code.Roles)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    orgRoles, err := jsonArray(code.OrgRoles)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.db.QueryRowContext(ctx, `
+        INSERT INTO invite_codes(code_hash, email, roles, org_id, org_roles, creator, created_at, expires_at)
+        VALUES($1, $2, $3, NULLIF($4, 0), $5, $6, $7, $8)
+        RETURNING id`,
+        code.Hash, code.Email, roles, code.OrgID, orgRoles, code.Creator, code.CreatedAt, code.ExpiresAt,
+    ).Scan(&id)
+    if err != nil {
+        if isForeignKeyViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// InviteCodeByHash returns the invite code with the hash, whether it is pending or not.
+func (s *Storage) InviteCodeByHash(ctx context.Context, hash []byte) (models.InviteCode, error) {
+    const op = "storage.sqlite.InviteCodeByHash"
+
+    code, err := scanInviteCode(s.db.QueryRowContext(ctx,
+        "SELECT "+inviteCodeColumns+" FROM invite_codes WHERE code_hash = $1", hash))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.InviteCode{}, fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+        }
+        return models.InviteCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return code, nil
+}
+
+// RevokeInviteCode revokes the pending invite code.
+func (s *Storage) RevokeInviteCode(ctx context.Context, id int64, revokedAt time.Time) error {
+    const op = "storage.sqlite.RevokeInviteCode"
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE invite_codes SET revoked_at = $2
+        WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2`,
+        id, revokedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrInviteCodeNotFound)
+}
+
+// CreateInvitedUser creates the user like CreateUser and marks the pending invite code used by it,
+// giving the user the roles and the membership of the code. Nothing is created unless the code is pending.
+func (s *Storage) CreateInvitedUser(
+    ctx context.Context,
+    ids models.UserIdentifiers,
+    passHash []byte,
+    codeID int64,
+    usedAt time.Time,
+) (int64, error) {
+    const op = "storage.sqlite.CreateInvitedUser"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    id, err := createUser(ctx, tx, ids, passHash)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var (
+        roles, orgRoles string
+        orgID           sql.NullInt64
+    )
+    err = tx.QueryRowContext(ctx, `
+        UPDATE invite_codes SET used_at = $3, used_by = $2
+        WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $3
+        RETURNING roles, org_id, org_roles`,
+        codeID, id, usedAt,
+    ).Scan(&roles, &orgID, &orgRoles)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrInviteCodeNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if _, err := tx.ExecContext(ctx, "UPDATE users SET roles = $2 WHERE id = $1", id, roles); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if orgID.Valid {
+        _, err = tx.ExecContext(ctx, `
+            INSERT INTO org_members(org_id, user_id, roles, created_at)
+            VALUES($1, $2, $3, $4)`,
+            orgID.Int64, id, orgRoles, usedAt,
+        )
+        if err != nil {
+            return 0, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+func scanInviteCode(row scanner) (models.InviteCode, error) {
+    var (
+        code   models.InviteCode
+        orgID  sql.NullInt64
+        usedBy sql.NullInt64
+    )
+
+    err := row.Scan(
+        &code.ID, &code.Hash, &code.Email, jsonStrings(&code.Roles), &orgID, jsonStrings(&code.OrgRoles), &code.Creator,
+        timestamp(&code.CreatedAt), timestamp(&code.ExpiresAt),
+        timestamp(&code.UsedAt), &usedBy, timestamp(&code.RevokedAt),
+    )
+    if err != nil {
+        return models.InviteCode{}, err
+    }
+    code.OrgID, code.UsedBy = orgID.Int64, usedBy.Int64
+
+    return code, nil
+}
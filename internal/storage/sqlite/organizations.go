@@ -0,0 +1,373 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+)
+
+const orgInvitationColumns = `id, org_id, email, roles, token_hash, inviter, created_at, expires_at,
+    accepted_at, accepted_by, revoked_at`
+
+// CreateOrganization creates the organization with the owner as its first member and returns its id.
+func (s *Storage) CreateOrganization(ctx context.Context, org models.Organization, owner models.Membership) (int64, error) {
+    const op = "storage.sqlite.CreateOrganization"
+
+    roles, err := jsonArray(owner.Roles)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var id int64
+    err = tx.QueryRowContext(ctx, `
+        INSERT INTO organizations(slug, name, created_at)
+        VALUES($1, $2, $3)
+        RETURNING id`,
+        org.Slug, org.Name, org.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgExists)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        INSERT INTO org_members(org_id, user_id, roles, created_at)
+        VALUES($1, $2, $3, $4)`,
+        id, owner.UserID, roles, owner.CreatedAt,
+    )
+    if err != nil {
+        if isForeignKeyViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// Organization returns the organization by id.
+func (s *Storage) Organization(ctx context.Context, id int64) (models.Organization, error) {
+    const op = "storage.sqlite.Organization"
+
+    org, err := scanOrganization(s.db.QueryRowContext(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE id = $1", id))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return org, nil
+}
+
+// OrganizationBySlug returns the organization by slug.
+func (s *Storage) OrganizationBySlug(ctx context.Context, slug string) (models.Organization, error) {
+    const op = "storage.sqlite.OrganizationBySlug"
+
+    org, err := scanOrganization(s.db.QueryRowContext(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE slug = $1", slug))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Organization{}, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return models.Organization{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return org, nil
+}
+
+// UserOrganizations returns the organizations the user is a member of, ordered by id.
+func (s *Storage) UserOrganizations(ctx context.Context, userID int64) ([]models.UserOrganization, error) {
+    const op = "storage.sqlite.UserOrganizations"
+
+    orgs, err := userOrganizations(ctx, s.db, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return orgs, nil
+}
+
+// Membership returns the membership of the user in the organization.
+func (s *Storage) Membership(ctx context.Context, orgID, userID int64) (models.Membership, error) {
+    const op = "storage.sqlite.Membership"
+
+    m, err := scanMembership(s.db.QueryRowContext(ctx, `
+        SELECT org_id, user_id, roles, created_at
+        FROM org_members
+        WHERE org_id = $1 AND user_id = $2`,
+        orgID, userID,
+    ))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+        }
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return m, nil
+}
+
+// Members returns up to limit members of the organization with user ids greater than afterUserID,
+// ordered by user id.
+func (s *Storage) Members(ctx context.Context, orgID, afterUserID int64, limit int) ([]models.Membership, error) {
+    const op = "storage.sqlite.Members"
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT org_id, user_id, roles, created_at
+        FROM org_members
+        WHERE org_id = $1 AND user_id > $2
+        ORDER BY user_id
+        LIMIT $3`,
+        orgID, afterUserID, limit,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var members []models.Membership
+    for rows.Next() {
+        m, err := scanMembership(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        members = append(members, m)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return members, nil
+}
+
+// SetMemberRoles replaces the roles of the member of the organization.
+func (s *Storage) SetMemberRoles(ctx context.Context, orgID, userID int64, roles []string) error {
+    const op = "storage.sqlite.SetMemberRoles"
+
+    encoded, err := jsonArray(roles)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE org_members SET roles = $3
+        WHERE org_id = $1 AND user_id = $2`,
+        orgID, userID, encoded,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrMemberNotFound)
+}
+
+// RemoveMember removes the user from the organization.
+func (s *Storage) RemoveMember(ctx context.Context, orgID, userID int64) error {
+    const op = "storage.sqlite.RemoveMember"
+
+    res, err := s.db.ExecContext(ctx, "DELETE FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrMemberNotFound)
+}
+
+// SaveOrgInvitation saves a pending invitation to the organization and returns its id.
+func (s *Storage) SaveOrgInvitation(ctx context.Context, inv models.OrgInvitation) (int64, error) {
+    const op = "storage.sqlite.SaveOrgInvitation"
+
+    roles, err := jsonArray(inv.Roles)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.db.QueryRowContext(ctx, `
+        INSERT INTO org_invitations(org_id, email, roles, token_hash, inviter, created_at, expires_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id`,
+        inv.OrgID, inv.Email, roles, inv.Hash, inv.Inviter, inv.CreatedAt, inv.ExpiresAt,
+    ).Scan(&id)
+    if err != nil {
+        if isForeignKeyViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrOrgNotFound)
+        }
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// OrgInvitationByHash returns the invitation with the token hash, whether it is pending or not.
+func (s *Storage) OrgInvitationByHash(ctx context.Context, hash []byte) (models.OrgInvitation, error) {
+    const op = "storage.sqlite.OrgInvitationByHash"
+
+    inv, err := scanOrgInvitation(s.db.QueryRowContext(ctx,
+        "SELECT "+orgInvitationColumns+" FROM org_invitations WHERE token_hash = $1", hash))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.OrgInvitation{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+        }
+        return models.OrgInvitation{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return inv, nil
+}
+
+// RevokeOrgInvitation revokes the pending invitation to the organization.
+func (s *Storage) RevokeOrgInvitation(ctx context.Context, orgID, id int64, revokedAt time.Time) error {
+    const op = "storage.sqlite.RevokeOrgInvitation"
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE org_invitations SET revoked_at = $3
+        WHERE id = $2 AND org_id = $1
+          AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $3`,
+        orgID, id, revokedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return affected(op, res, storage.ErrInvitationNotFound)
+}
+
+// AcceptOrgInvitation marks the pending invitation accepted by the user and makes the user a member
+// of the organization with the roles of the invitation. Invitations of members stay pending.
+func (s *Storage) AcceptOrgInvitation(ctx context.Context, id, userID int64, acceptedAt time.Time) (models.Membership, error) {
+    const op = "storage.sqlite.AcceptOrgInvitation"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    m := models.Membership{UserID: userID, CreatedAt: acceptedAt}
+    err = tx.QueryRowContext(ctx, `
+        UPDATE org_invitations SET accepted_at = $3, accepted_by = $2
+        WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $3
+        RETURNING org_id, roles`,
+        id, userID, acceptedAt,
+    ).Scan(&m.OrgID, jsonStrings(&m.Roles))
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrInvitationNotFound)
+        case isForeignKeyViolation(err):
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    roles, err := jsonArray(m.Roles)
+    if err != nil {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        INSERT INTO org_members(org_id, user_id, roles, created_at)
+        VALUES($1, $2, $3, $4)`,
+        m.OrgID, m.UserID, roles, m.CreatedAt,
+    )
+    if err != nil {
+        if isUniqueViolation(err) {
+            return models.Membership{}, fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+        }
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return models.Membership{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return m, nil
+}
+
+func userOrganizations(ctx context.Context, q querier, userID int64) ([]models.UserOrganization, error) {
+    rows, err := q.QueryContext(ctx, `
+        SELECT o.id, o.slug, o.name, o.created_at, m.roles, m.created_at
+        FROM org_members m
+        JOIN organizations o ON o.id = m.org_id
+        WHERE m.user_id = $1
+        ORDER BY o.id`,
+        userID,
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var orgs []models.UserOrganization
+    for rows.Next() {
+        var uo models.UserOrganization
+        org := &uo.Organization
+        err := rows.Scan(&org.ID, &org.Slug, &org.Name, timestamp(&org.CreatedAt), jsonStrings(&uo.Roles), timestamp(&uo.JoinedAt))
+        if err != nil {
+            return nil, err
+        }
+        orgs = append(orgs, uo)
+    }
+
+    return orgs, rows.Err()
+}
+
+// affected returns the error if the statement changed no rows.
+func affected(op string, res sql.Result, notFound error) error {
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        return fmt.Errorf("%s: %w", op, notFound)
+    }
+
+    return nil
+}
+
+func scanOrganization(row scanner) (models.Organization, error) {
+    var org models.Organization
+    err := row.Scan(&org.ID, &org.Slug, &org.Name, timestamp(&org.CreatedAt))
+    return org, err
+}
+
+func scanMembership(row scanner) (models.Membership, error) {
+    var m models.Membership
+    err := row.Scan(&m.OrgID, &m.UserID, jsonStrings(&m.Roles), timestamp(&m.CreatedAt))
+    return m, err
+}
+
+func scanOrgInvitation(row scanner) (models.OrgInvitation, error) {
+    var (
+        inv        models.OrgInvitation
+        acceptedBy sql.NullInt64
+    )
+
+    err := row.Scan(
+        &inv.ID, &inv.OrgID, &inv.Email, jsonStrings(&inv.Roles), &inv.Hash, &inv.Inviter,
+        timestamp(&inv.CreatedAt), timestamp(&inv.ExpiresAt),
+        timestamp(&inv.AcceptedAt), &acceptedBy, timestamp(&inv.RevokedAt),
+    )
+    if err != nil {
+        return models.OrgInvitation{}, err
+    }
+    inv.AcceptedBy = acceptedBy.Int64
+
+    return inv, nil
+}
@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"slices"
+	"time"
+)
+
+const outboxColumns = `id, type, aggregate_id, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at`
+
+// enqueue writes a domain event about the user to the outbox within the transaction of the change.
+func enqueue(ctx context.Context, tx *sql.Tx, eventType string, userID int64, payload any) error {
+    raw, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+
+    _, err = tx.ExecContext(ctx,
+        "INSERT INTO outbox(type, aggregate_id, payload, next_attempt_at, created_at) VALUES($1, $2, $3, $4, $4)",
+        eventType, aggregate, raw, time.Now(),
+    )
+
+    return err
+}
+
+// ClaimOutboxEvents returns up to limit pending events that are due, oldest first, and
+// postpones their next attempt by the lease so that concurrent relays skip them while
+// they are being delivered. Events of a relay that stops mid-delivery are retried
+// once the lease ends.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+    const op = "storage.sqlite.ClaimOutboxEvents"
+
+    now := time.Now()
+
+    // A single statement is atomic, so no other relay claims the same events.
+    rows, err := s.db.QueryContext(ctx, `
+        UPDATE outbox SET next_attempt_at = $3
+        WHERE id IN (
+            SELECT id FROM outbox
+            WHERE status = 'pending' AND next_attempt_at <= $2
+            ORDER BY id
+            LIMIT $1
+        )
+        RETURNING `+outboxColumns,
+        limit, now, now.Add(lease),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var events []models.OutboxEvent
+    for rows.Next() {
+        var e models.OutboxEvent
+        err := rows.Scan(
+            &e.ID, &e.Type, &e.AggregateID, &e.Payload, &e.Status, &e.Attempts,
+            timestamp(&e.NextAttemptAt), &e.LastError, timestamp(&e.CreatedAt), timestamp(&e.DeliveredAt),
+        )
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        events = append(events, e)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    slices.SortFunc(events, func(a, b models.OutboxEvent) int { return cmp.Compare(a.ID, b.ID) })
+
+    return events, nil
+}
+
+// MarkOutboxDelivered records a successful delivery of the event.
+func (s *Storage) MarkOutboxDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+    const op = "storage.sqlite.MarkOutboxDelivered"
+
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE outbox SET status = 'delivered', attempts = attempts + 1, last_error = '', delivered_at = $2
+        WHERE id = $1`,
+        id, deliveredAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// MarkOutboxFailed records a failed delivery of the event and schedules the next attempt.
+func (s *Storage) MarkOutboxFailed(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+    const op = "storage.sqlite.MarkOutboxFailed"
+
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+        WHERE id = $1`,
+        id, lastErr, nextAttemptAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// MarkOutboxDead records the last failed delivery of the event and stops retrying it.
+func (s *Storage) MarkOutboxDead(ctx context.Context, id int64, lastErr string) error {
+    const op = "storage.sqlite.MarkOutboxDead"
+
+    _, err := s.db.ExecContext(ctx, `
+        UPDATE outbox SET status = 'dead', attempts = attempts + 1, last_error = $2
+        WHERE id = $1`,
+        id, lastErr,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
@@ -0,0 +1,174 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+)
+
+const phoneCodeColumns = `id, phone, purpose, user_id, code_hash, attempts, created_at, expires_at, consumed_at`
+
+// SavePhoneCode saves the one-time code and returns its id.
+func (s *Storage) SavePhoneCode(ctx context.Context, code models.PhoneCode) (int64, error) {
+    const op = "storage.sqlite.SavePhoneCode"
+
+    var id int64
+    err := s.db.QueryRowContext(ctx, `
+        INSERT INTO phone_codes(phone, purpose, user_id, code_hash, created_at, expires_at)
+        VALUES($1, $2, $3, $4, $5, $6)
+        RETURNING id`,
+        code.Phone, code.Purpose, code.UserID, code.Hash, code.CreatedAt, code.ExpiresAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// LatestPhoneCode returns the code for the purpose last sent to the phone number, used or not.
+// With a user id, it returns the code last sent for that user only.
+func (s *Storage) LatestPhoneCode(
+    ctx context.Context,
+    phone string,
+    purpose models.PhoneCodePurpose,
+    userID int64,
+) (models.PhoneCode, error) {
+    const op = "storage.sqlite.LatestPhoneCode"
+
+    row := s.db.QueryRowContext(ctx, `
+        SELECT `+phoneCodeColumns+`
+        FROM phone_codes
+        WHERE phone = $1 AND purpose = $2 AND ($3 = 0 OR user_id = $3)
+        ORDER BY id DESC
+        LIMIT 1`,
+        phone, purpose, userID,
+    )
+
+    code, err := scanPhoneCode(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.PhoneCode{}, fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+        }
+
+        return models.PhoneCode{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return code, nil
+}
+
+// CountPhoneCodes returns the number of codes sent to the phone number since the time, for any purpose.
+func (s *Storage) CountPhoneCodes(ctx context.Context, phone string, since time.Time) (int, error) {
+    const op = "storage.sqlite.CountPhoneCodes"
+
+    var n int
+    err := s.db.QueryRowContext(ctx,
+        "SELECT count(*) FROM phone_codes WHERE phone = $1 AND created_at >= $2",
+        phone, since,
+    ).Scan(&n)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return n, nil
+}
+
+// AddPhoneCodeAttempt counts a guess of the code and returns the number of guesses so far.
+func (s *Storage) AddPhoneCodeAttempt(ctx context.Context, id int64) (int, error) {
+    const op = "storage.sqlite.AddPhoneCodeAttempt"
+
+    var attempts int
+    err := s.db.QueryRowContext(ctx,
+        "UPDATE phone_codes SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts",
+        id,
+    ).Scan(&attempts)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return attempts, nil
+}
+
+// ConsumePhoneCode marks the code used. Codes are used once, so a code already used is not found.
+func (s *Storage) ConsumePhoneCode(ctx context.Context, id int64, consumedAt time.Time) error {
+    const op = "storage.sqlite.ConsumePhoneCode"
+
+    res, err := s.db.ExecContext(ctx,
+        "UPDATE phone_codes SET consumed_at = $2 WHERE id = $1 AND consumed_at IS NULL",
+        id, consumedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrPhoneCodeNotFound)
+    }
+
+    return nil
+}
+
+// VerifyUserPhone sets the phone number of the user, verified at the time,
+// and publishes a user.phone_verified event.
+func (s *Storage) VerifyUserPhone(ctx context.Context, userID int64, phone string, verifiedAt time.Time) error {
+    const op = "storage.sqlite.VerifyUserPhone"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx,
+        "UPDATE users SET phone = $2, phone_verified_at = $3 WHERE id = $1",
+        userID, phone, verifiedAt,
+    )
+    if err != nil {
+        if isUniqueViolation(err) {
+            return fmt.Errorf("%s: %w", op, storage.ErrPhoneTaken)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := userAffected(op, res); err != nil {
+        return err
+    }
+
+    err = enqueue(ctx, tx, models.DomainEventPhoneVerified, userID, models.PhoneVerified{UserID: userID})
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func scanPhoneCode(row scanner) (models.PhoneCode, error) {
+    var code models.PhoneCode
+
+    err := row.Scan(
+        &code.ID, &code.Phone, &code.Purpose, &code.UserID, &code.Hash, &code.Attempts,
+        timestamp(&code.CreatedAt), timestamp(&code.ExpiresAt), timestamp(&code.ConsumedAt),
+    )
+    if err != nil {
+        return models.PhoneCode{}, err
+    }
+
+    return code, nil
+}
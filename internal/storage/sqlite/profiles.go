@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+)
+
+// UpdateProfile applies the update to the profile of the user, publishes a user.profile_updated event
+// and returns the updated profile. Transactions take the write lock when they begin,
+// so concurrent updates of other fields are not lost.
+func (s *Storage) UpdateProfile(ctx context.Context, userID int64, update models.ProfileUpdate) (models.Profile, error) {
+    const op = "storage.sqlite.UpdateProfile"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var profile models.Profile
+    err = scanProfile(tx.QueryRowContext(ctx, "SELECT "+profileColumns+" FROM users WHERE id = $1", userID), &profile)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Profile{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    profile = profile.Apply(update)
+
+    attributes, err := attributesJSON(profile.Attributes)
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE users
+        SET display_name = $2, given_name = $3, family_name = $4, locale = $5, timezone = $6, avatar_url = $7,
+            attributes = $8
+        WHERE id = $1`,
+        userID, profile.DisplayName, profile.GivenName, profile.FamilyName, profile.Locale, profile.Timezone,
+        profile.AvatarURL, attributes,
+    )
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = enqueue(ctx, tx, models.DomainEventProfileUpdated, userID, models.ProfileUpdated{
+        UserID: userID,
+        Fields: update.Paths(),
+    })
+    if err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return models.Profile{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return profile, nil
+}
+
+// profileColumns are the columns of the profile, read by scanProfile.
+const profileColumns = `display_name, given_name, family_name, locale, timezone, avatar_url, attributes`
+
+// scanProfile scans the profile columns into the profile, after the other destinations.
+func scanProfile(row scanner, profile *models.Profile, dest ...any) error {
+    var attributes string
+
+    dest = append(dest,
+        &profile.DisplayName, &profile.GivenName, &profile.FamilyName, &profile.Locale, &profile.Timezone,
+        &profile.AvatarURL, &attributes,
+    )
+    if err := row.Scan(dest...); err != nil {
+        return err
+    }
+
+    if err := json.Unmarshal([]byte(attributes), &profile.Attributes); err != nil {
+        return err
+    }
+    if len(profile.Attributes) == 0 {
+        profile.Attributes = nil
+    }
+
+    return nil
+}
+
+// attributesJSON encodes the attributes as a JSON object. Missing attributes are stored as an empty one.
+func attributesJSON(attributes models.Attributes) (string, error) {
+    if attributes == nil {
+        attributes = models.Attributes{}
+    }
+
+    raw, err := json.Marshal(attributes)
+
+    return string(raw), err
+}
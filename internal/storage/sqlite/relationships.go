@@ -0,0 +1,149 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"strconv"
+	"strings"
+)
+
+// WriteRelationships applies the updates at once and returns the revision of the write.
+func (s *Storage) WriteRelationships(ctx context.Context, updates []models.RelationshipUpdate) (int64, error) {
+    const op = "storage.sqlite.WriteRelationships"
+
+    for _, u := range updates {
+        if u.Operation != models.RelationshipTouch && u.Operation != models.RelationshipDelete {
+            return 0, fmt.Errorf("%s: unknown operation %q", op, u.Operation)
+        }
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    var revision int64
+    err = tx.QueryRowContext(ctx,
+        "UPDATE relationship_revision SET revision = revision + 1 RETURNING revision").Scan(&revision)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    for _, u := range updates {
+        r := u.Relationship
+        query := `
+            INSERT INTO relationships(resource_type, resource_id, relation, subject_type, subject_id, subject_relation)
+            VALUES($1, $2, $3, $4, $5, $6)
+            ON CONFLICT DO NOTHING`
+        if u.Operation == models.RelationshipDelete {
+            query = `
+                DELETE FROM relationships
+                WHERE resource_type = $1 AND resource_id = $2 AND relation = $3
+                    AND subject_type = $4 AND subject_id = $5 AND subject_relation = $6`
+        }
+
+        _, err := tx.ExecContext(ctx, query,
+            r.Resource.Type, r.Resource.ID, r.Relation, r.Subject.Object.Type, r.Subject.Object.ID, r.Subject.Relation)
+        if err != nil {
+            return 0, fmt.Errorf("%s: %w", op, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return revision, nil
+}
+
+// Relationships returns the relationships matching any of the filters, ordered by their columns,
+// and the revision they are read at, which is never older than minRevision.
+func (s *Storage) Relationships(
+    ctx context.Context,
+    filters []models.RelationshipFilter,
+    minRevision int64,
+) ([]models.Relationship, int64, error) {
+    const op = "storage.sqlite.Relationships"
+
+    var revision int64
+    if err := s.db.QueryRowContext(ctx, "SELECT revision FROM relationship_revision").Scan(&revision); err != nil {
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+    if revision < minRevision {
+        return nil, 0, fmt.Errorf("%s: %w", op, storage.ErrRevisionNotFound)
+    }
+
+    where, args := relationshipConditions(filters)
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT resource_type, resource_id, relation, subject_type, subject_id, subject_relation
+        FROM relationships
+        WHERE `+where+`
+        ORDER BY resource_type, resource_id, relation, subject_type, subject_id, subject_relation`,
+        args...,
+    )
+    if err != nil {
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var rels []models.Relationship
+    for rows.Next() {
+        var r models.Relationship
+        err := rows.Scan(&r.Resource.Type, &r.Resource.ID, &r.Relation,
+            &r.Subject.Object.Type, &r.Subject.Object.ID, &r.Subject.Relation)
+        if err != nil {
+            return nil, 0, fmt.Errorf("%s: %w", op, err)
+        }
+        rels = append(rels, r)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return rels, revision, nil
+}
+
+// relationshipConditions returns the conditions of the WHERE clause selecting the relationships
+// matching any of the filters and their arguments. No filters select nothing.
+func relationshipConditions(filters []models.RelationshipFilter) (string, []any) {
+    var (
+        alts []string
+        args []any
+    )
+    for _, filter := range filters {
+        var conds []string
+        add := func(column, value string) {
+            args = append(args, value)
+            conds = append(conds, column+" = $"+strconv.Itoa(len(args)))
+        }
+
+        if filter.ResourceType != "" {
+            add("resource_type", filter.ResourceType)
+        }
+        if filter.ResourceID != "" {
+            add("resource_id", filter.ResourceID)
+        }
+        if filter.Relation != "" {
+            add("relation", filter.Relation)
+        }
+        if filter.Subject != nil {
+            add("subject_type", filter.Subject.Object.Type)
+            add("subject_id", filter.Subject.Object.ID)
+            add("subject_relation", filter.Subject.Relation)
+        }
+
+        if len(conds) == 0 {
+            return "TRUE", nil
+        }
+        alts = append(alts, "("+strings.Join(conds, " AND ")+")")
+    }
+
+    if len(alts) == 0 {
+        return "FALSE", nil
+    }
+
+    return strings.Join(alts, " OR "), args
+}
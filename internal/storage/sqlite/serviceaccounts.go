@@ -0,0 +1,184 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"time"
+)
+
+const serviceAccountColumns = `id, name, owner_kind, owner, client_id, secret_hash, roles, created_at, disabled_at`
+
+// SaveServiceAccount saves the service account and returns its id.
+func (s *Storage) SaveServiceAccount(ctx context.Context, sa models.ServiceAccount) (int64, error) {
+    const op = "storage.sqlite.SaveServiceAccount"
+
+    roles, err := jsonArray(sa.Roles)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.db.QueryRowContext(ctx, `
+        INSERT INTO service_accounts(name, owner_kind, owner, client_id, secret_hash, roles, created_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id`,
+        sa.Name, sa.Owner.Kind, sa.Owner.Name, sa.ClientID, sa.SecretHash, roles, sa.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountExists)
+        }
+
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// ServiceAccount returns the service account by id.
+func (s *Storage) ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error) {
+    const op = "storage.sqlite.ServiceAccount"
+
+    row := s.db.QueryRowContext(ctx,
+        "SELECT "+serviceAccountColumns+" FROM service_accounts WHERE id = $1",
+        id,
+    )
+
+    sa, err := scanServiceAccount(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+        }
+
+        return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sa, nil
+}
+
+// ServiceAccountByClientID returns the service account by its client id.
+func (s *Storage) ServiceAccountByClientID(ctx context.Context, clientID string) (models.ServiceAccount, error) {
+    const op = "storage.sqlite.ServiceAccountByClientID"
+
+    row := s.db.QueryRowContext(ctx,
+        "SELECT "+serviceAccountColumns+" FROM service_accounts WHERE client_id = $1",
+        clientID,
+    )
+
+    sa, err := scanServiceAccount(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+        }
+
+        return models.ServiceAccount{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sa, nil
+}
+
+// ServiceAccounts returns service accounts ordered by id.
+// An owner with an empty kind returns service accounts of every owner.
+func (s *Storage) ServiceAccounts(ctx context.Context, owner models.Owner) ([]models.ServiceAccount, error) {
+    const op = "storage.sqlite.ServiceAccounts"
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT `+serviceAccountColumns+`
+        FROM service_accounts
+        WHERE $1 = '' OR (owner_kind = $1 AND owner = $2)
+        ORDER BY id`,
+        owner.Kind, owner.Name,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var accounts []models.ServiceAccount
+    for rows.Next() {
+        sa, err := scanServiceAccount(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        accounts = append(accounts, sa)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return accounts, nil
+}
+
+// UpdateServiceAccountRoles replaces the roles of the service account.
+func (s *Storage) UpdateServiceAccountRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.sqlite.UpdateServiceAccountRoles"
+
+    rawRoles, err := jsonArray(roles)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    res, err := s.db.ExecContext(ctx, "UPDATE service_accounts SET roles = $2 WHERE id = $1", id, rawRoles)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return serviceAccountAffected(op, res)
+}
+
+// UpdateServiceAccountSecret replaces the client secret hash of the service account.
+func (s *Storage) UpdateServiceAccountSecret(ctx context.Context, id int64, secretHash []byte) error {
+    const op = "storage.sqlite.UpdateServiceAccountSecret"
+
+    res, err := s.db.ExecContext(ctx, "UPDATE service_accounts SET secret_hash = $2 WHERE id = $1", id, secretHash)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return serviceAccountAffected(op, res)
+}
+
+// DisableServiceAccount disables the service account if it is enabled.
+func (s *Storage) DisableServiceAccount(ctx context.Context, id int64, disabledAt time.Time) error {
+    const op = "storage.sqlite.DisableServiceAccount"
+
+    res, err := s.db.ExecContext(ctx,
+        "UPDATE service_accounts SET disabled_at = $2 WHERE id = $1 AND disabled_at IS NULL",
+        id, disabledAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return serviceAccountAffected(op, res)
+}
+
+func serviceAccountAffected(op string, res sql.Result) error {
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrServiceAccountNotFound)
+    }
+
+    return nil
+}
+
+func scanServiceAccount(row scanner) (models.ServiceAccount, error) {
+    var sa models.ServiceAccount
+
+    err := row.Scan(
+        &sa.ID, &sa.Name, &sa.Owner.Kind, &sa.Owner.Name, &sa.ClientID, &sa.SecretHash,
+        jsonStrings(&sa.Roles), timestamp(&sa.CreatedAt), timestamp(&sa.DisabledAt),
+    )
+    if err != nil {
+        return models.ServiceAccount{}, err
+    }
+
+    return sa, nil
+}
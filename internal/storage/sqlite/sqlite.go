@@ -0,0 +1,628 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+	"net/url"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Storage keeps its data in a single SQLite database file, for single-node and edge deployments.
+// The schema is created by the migrations in migrations/sqlite.
+type Storage struct {
+    db *sql.DB
+}
+
+// New creates a new instance of the SQLite storage on the database file at storagePath.
+func New(storagePath string) (*Storage, error) {
+    const op = "storage.sqlite.New"
+
+    params := url.Values{}
+    params.Add("_pragma", "foreign_keys(1)")
+    params.Add("_pragma", "journal_mode(WAL)")
+    params.Add("_pragma", "busy_timeout(5000)")
+    // Transactions take the write lock up front, so concurrent writers wait
+    // for each other instead of failing when they upgrade a read lock.
+    params.Set("_txlock", "immediate")
+    params.Set("_time_integer_format", "unix_micro")
+
+    db, err := sql.Open("sqlite", "file:"+storagePath+"?"+params.Encode())
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return &Storage{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+    const op = "storage.sqlite.Close"
+
+    if err := s.db.Close(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// SaveUser creates the user with the email and publishes a user.registered event.
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (int64, error) {
+    return s.CreateUser(ctx, models.UserIdentifiers{Email: email}, passHash)
+}
+
+// CreateUser creates the user with the identifiers and publishes a user.registered event.
+func (s *Storage) CreateUser(ctx context.Context, ids models.UserIdentifiers, passHash []byte) (int64, error) {
+    const op = "storage.sqlite.CreateUser"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    id, err := createUser(ctx, tx, ids, passHash)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// createUser creates the user within the transaction and publishes a user.registered event.
+func createUser(ctx context.Context, tx *sql.Tx, ids models.UserIdentifiers, passHash []byte) (int64, error) {
+    var id int64
+    err := tx.QueryRowContext(ctx, `
+        INSERT INTO users(email, username, phone, pass_hash, created_at)
+        VALUES(NULLIF($1, ''), NULLIF($2, ''), NULLIF($3, ''), $4, $5)
+        RETURNING id`,
+        ids.Email, ids.Username, ids.Phone, passHash, time.Now(),
+    ).Scan(&id)
+    if err != nil {
+        if isUniqueViolation(err) {
+            return 0, userExists(err)
+        }
+
+        return 0, err
+    }
+
+    err = enqueue(ctx, tx, models.DomainEventUserRegistered, id, models.UserRegistered{UserID: id})
+    if err != nil {
+        return 0, err
+    }
+
+    return id, nil
+}
+
+// userExists returns the error of a new user violating the unique constraint.
+// SQLite names the column of the violated index only in the error message.
+func userExists(err error) error {
+    msg := err.Error()
+    switch {
+    case strings.Contains(msg, "users.username"):
+        return storage.ErrUsernameTaken
+    case strings.Contains(msg, "users.phone"):
+        return storage.ErrPhoneTaken
+    default:
+        return storage.ErrUserExists
+    }
+}
+
+// User returns user by email, compared case-insensitively.
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+    const op = "storage.sqlite.User"
+
+    row := s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1 COLLATE NOCASE", email)
+
+    user, err := scanUser(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// UserByIdentity returns the user linked to the subject at the external identity provider.
+func (s *Storage) UserByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+    const op = "storage.sqlite.UserByIdentity"
+
+    row := s.db.QueryRowContext(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)`,
+        provider, subject,
+    )
+
+    user, err := scanUser(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// UserByID returns user by id.
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+    const op = "storage.sqlite.UserByID"
+
+    row := s.db.QueryRowContext(ctx,
+        "SELECT "+userColumns+" FROM users WHERE id = $1 AND "+tenantCondition("users.id", 2),
+        id, storage.Tenant(ctx),
+    )
+
+    user, err := scanUser(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// UserByUsername returns user by username, compared case-insensitively.
+func (s *Storage) UserByUsername(ctx context.Context, username string) (models.User, error) {
+    const op = "storage.sqlite.UserByUsername"
+
+    row := s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1 COLLATE NOCASE", username)
+
+    user, err := scanUser(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// UserByPhone returns user by E.164 phone number.
+func (s *Storage) UserByPhone(ctx context.Context, phone string) (models.User, error) {
+    const op = "storage.sqlite.UserByPhone"
+
+    row := s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE phone = $1", phone)
+
+    user, err := scanUser(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+
+        return models.User{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return user, nil
+}
+
+// SaveIdentity links the subject at the external identity provider to the user.
+func (s *Storage) SaveIdentity(ctx context.Context, userID int64, provider, subject string) error {
+    const op = "storage.sqlite.SaveIdentity"
+
+    _, err := s.db.ExecContext(ctx,
+        "INSERT INTO user_identities(user_id, provider, subject, created_at) VALUES($1, $2, $3, $4)",
+        userID, provider, subject, time.Now(),
+    )
+    if err != nil {
+        if isUniqueViolation(err) {
+            return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+        }
+
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+    const op = "storage.sqlite.SaveSession"
+
+    _, err := s.db.ExecContext(ctx, `
+        INSERT INTO sessions(id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)`,
+        session.ID, session.UserID, session.UserAgent, session.IP,
+        session.CreatedAt, session.LastSeenAt, session.ExpiresAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// Session returns the session by id if it is neither revoked nor expired.
+func (s *Storage) Session(ctx context.Context, id string) (models.Session, error) {
+    const op = "storage.sqlite.Session"
+
+    row := s.db.QueryRowContext(ctx, `
+        SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2`,
+        id, time.Now(),
+    )
+
+    session, err := scanSession(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+        }
+
+        return models.Session{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return session, nil
+}
+
+// Sessions returns active sessions of the user, most recently used first.
+func (s *Storage) Sessions(ctx context.Context, userID int64) ([]models.Session, error) {
+    const op = "storage.sqlite.Sessions"
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+        FROM sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+          AND `+tenantCondition("sessions.user_id", 3)+`
+        ORDER BY last_seen_at DESC`,
+        userID, time.Now(), storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var sessions []models.Session
+    for rows.Next() {
+        session, err := scanSession(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        sessions = append(sessions, session)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return sessions, nil
+}
+
+// TouchSession sets the last seen time of the session.
+func (s *Storage) TouchSession(ctx context.Context, id string, lastSeen time.Time) error {
+    const op = "storage.sqlite.TouchSession"
+
+    _, err := s.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = $2 WHERE id = $1", id, lastSeen)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RevokeSession revokes an active session of the user.
+func (s *Storage) RevokeSession(ctx context.Context, userID int64, id string) error {
+    const op = "storage.sqlite.RevokeSession"
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE sessions SET revoked_at = $3
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > $3
+          AND `+tenantCondition("sessions.user_id", 4),
+        id, userID, time.Now(), storage.Tenant(ctx),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+    }
+
+    return nil
+}
+
+// RevokeSessions revokes all active sessions of the user except the given one
+// and returns the number of revoked sessions.
+func (s *Storage) RevokeSessions(ctx context.Context, userID int64, exceptID string) (int64, error) {
+    const op = "storage.sqlite.RevokeSessions"
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE sessions SET revoked_at = $3
+        WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > $3
+          AND `+tenantCondition("sessions.user_id", 4),
+        userID, exceptID, time.Now(), storage.Tenant(ctx),
+    )
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return n, nil
+}
+
+// SaveAPIKey saves the API key and returns its id.
+func (s *Storage) SaveAPIKey(ctx context.Context, key models.APIKey) (int64, error) {
+    const op = "storage.sqlite.SaveAPIKey"
+
+    var userID, serviceAccountID sql.NullInt64
+    if key.Principal.IsUser() {
+        userID = sql.NullInt64{Int64: key.Principal.ID, Valid: true}
+    } else {
+        serviceAccountID = sql.NullInt64{Int64: key.Principal.ID, Valid: true}
+    }
+
+    scopes, err := jsonArray(key.Scopes)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    var id int64
+    err = s.db.QueryRowContext(ctx, `
+        INSERT INTO api_keys(user_id, service_account_id, name, prefix, key_hash, scopes, expires_at, created_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id`,
+        userID, serviceAccountID, key.Name, key.Prefix, key.Hash, scopes,
+        nullTime(key.ExpiresAt), key.CreatedAt,
+    ).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return id, nil
+}
+
+// APIKeyByHash returns the API key with the hash if it is not revoked.
+func (s *Storage) APIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error) {
+    const op = "storage.sqlite.APIKeyByHash"
+
+    row := s.db.QueryRowContext(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE key_hash = $1 AND revoked_at IS NULL`,
+        hash,
+    )
+
+    key, err := scanAPIKey(row)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return models.APIKey{}, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+        }
+
+        return models.APIKey{}, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return key, nil
+}
+
+// APIKeys returns API keys of the principal that are not revoked, newest first.
+func (s *Storage) APIKeys(ctx context.Context, owner models.Principal) ([]models.APIKey, error) {
+    const op = "storage.sqlite.APIKeys"
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE `+ownerColumn(owner)+` = $1 AND revoked_at IS NULL
+          AND `+tenantCondition("api_keys.user_id", 2)+`
+        ORDER BY created_at DESC`,
+        owner.ID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var keys []models.APIKey
+    for rows.Next() {
+        key, err := scanAPIKey(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        keys = append(keys, key)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return keys, nil
+}
+
+// TouchAPIKey sets the last used time of the API key.
+func (s *Storage) TouchAPIKey(ctx context.Context, id int64, lastUsed time.Time) error {
+    const op = "storage.sqlite.TouchAPIKey"
+
+    _, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = $2 WHERE id = $1", id, lastUsed)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// RevokeAPIKey revokes an API key of the principal.
+func (s *Storage) RevokeAPIKey(ctx context.Context, owner models.Principal, id int64) error {
+    const op = "storage.sqlite.RevokeAPIKey"
+
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE api_keys SET revoked_at = $3
+        WHERE id = $1 AND `+ownerColumn(owner)+` = $2 AND revoked_at IS NULL
+          AND `+tenantCondition("api_keys.user_id", 4),
+        id, owner.ID, time.Now(), storage.Tenant(ctx),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+    }
+
+    return nil
+}
+
+type scanner interface {
+    Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (models.Session, error) {
+    var session models.Session
+
+    err := row.Scan(
+        &session.ID, &session.UserID, &session.UserAgent, &session.IP,
+        timestamp(&session.CreatedAt), timestamp(&session.LastSeenAt), timestamp(&session.ExpiresAt),
+    )
+
+    return session, err
+}
+
+const apiKeyColumns = `id, user_id, service_account_id, name, prefix, key_hash, scopes,
+    expires_at, last_used_at, created_at`
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+    var (
+        key              models.APIKey
+        userID           sql.NullInt64
+        serviceAccountID sql.NullInt64
+    )
+
+    err := row.Scan(
+        &key.ID, &userID, &serviceAccountID, &key.Name, &key.Prefix, &key.Hash, jsonStrings(&key.Scopes),
+        timestamp(&key.ExpiresAt), timestamp(&key.LastUsedAt), timestamp(&key.CreatedAt),
+    )
+    if err != nil {
+        return models.APIKey{}, err
+    }
+
+    if userID.Valid {
+        key.Principal = models.Principal{Type: models.PrincipalUser, ID: userID.Int64}
+    } else {
+        key.Principal = models.Principal{Type: models.PrincipalServiceAccount, ID: serviceAccountID.Int64}
+    }
+
+    return key, nil
+}
+
+// ownerColumn returns the api_keys column referencing the principal.
+func ownerColumn(p models.Principal) string {
+    if p.IsUser() {
+        return "user_id"
+    }
+    return "service_account_id"
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+    return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// timeScanner scans times, which are stored as microseconds since the epoch.
+// NULL scans as the zero time.
+type timeScanner struct {
+    t *time.Time
+}
+
+func timestamp(t *time.Time) timeScanner {
+    return timeScanner{t: t}
+}
+
+func (s timeScanner) Scan(src any) error {
+    switch v := src.(type) {
+    case nil:
+        *s.t = time.Time{}
+    case int64:
+        *s.t = time.UnixMicro(v)
+    default:
+        return fmt.Errorf("cannot scan %T into time", src)
+    }
+
+    return nil
+}
+
+// stringsScanner scans string lists, which are stored as JSON arrays.
+type stringsScanner struct {
+    s *[]string
+}
+
+func jsonStrings(s *[]string) stringsScanner {
+    return stringsScanner{s: s}
+}
+
+func (s stringsScanner) Scan(src any) error {
+    var raw []byte
+    switch v := src.(type) {
+    case string:
+        raw = []byte(v)
+    case []byte:
+        raw = v
+    default:
+        return fmt.Errorf("cannot scan %T into strings", src)
+    }
+
+    *s.s = []string{}
+
+    return json.Unmarshal(raw, s.s)
+}
+
+// jsonArray encodes the strings as a JSON array. A nil list is stored as an empty one.
+func jsonArray(s []string) (string, error) {
+    if s == nil {
+        s = []string{}
+    }
+
+    raw, err := json.Marshal(s)
+
+    return string(raw), err
+}
+
+// isUniqueViolation reports whether the error is a violation of a unique constraint or primary key.
+func isUniqueViolation(err error) bool {
+    var sqliteErr *sqlite.Error
+    if !errors.As(err, &sqliteErr) {
+        return false
+    }
+
+    code := sqliteErr.Code()
+
+    return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// isForeignKeyViolation reports whether the error is a violation of a foreign key constraint.
+func isForeignKeyViolation(err error) bool {
+    var sqliteErr *sqlite.Error
+    if !errors.As(err, &sqliteErr) {
+        return false
+    }
+
+    return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
@@ -0,0 +1,38 @@
+package sqlite_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"grpc-service-ref/internal/storage/sqlite"
+	"grpc-service-ref/internal/storage/storagetest"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConformance runs against a fresh database migrated with migrations/sqlite.
+func TestConformance(t *testing.T) {
+    migrations, err := filepath.Abs("../../../migrations/sqlite")
+    require.NoError(t, err)
+
+    storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+        path := filepath.Join(t.TempDir(), "sso.db")
+
+        m, err := migrate.New("file://"+migrations, "sqlite://"+path)
+        require.NoError(t, err)
+        if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+            require.NoError(t, err)
+        }
+        srcErr, dbErr := m.Close()
+        require.NoError(t, srcErr)
+        require.NoError(t, dbErr)
+
+        s, err := sqlite.New(path)
+        require.NoError(t, err)
+        return s
+    })
+}
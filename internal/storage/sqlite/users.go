@@ -0,0 +1,252 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/storage"
+)
+
+// userColumns store missing identifiers as NULL, which keeps them out of the unique indexes.
+const userColumns = `id, coalesce(email, ''), coalesce(username, ''), coalesce(phone, ''), phone_verified_at,
+    pass_hash, roles, status, status_reason, suspended_until, created_at, ` + profileColumns
+
+// Users returns up to limit users matching the filter with ids greater than afterID, ordered by id.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter, afterID int64, limit int) ([]models.User, error) {
+    const op = "storage.sqlite.Users"
+
+    // The prefix is compared with substr rather than LIKE, which would need its wildcards escaped.
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        WHERE id > $1
+          AND substr(lower(coalesce(email, '')), 1, length($2)) = lower($2)
+          AND ($3 = '' OR status = $3)
+          AND ($4 = '' OR EXISTS (SELECT 1 FROM json_each(roles) WHERE value = $4))
+          AND ($6 = 0 OR EXISTS (SELECT 1 FROM org_members m WHERE m.org_id = $6 AND m.user_id = users.id))
+          AND `+tenantCondition("users.id", 7)+`
+        ORDER BY id
+        LIMIT $5`,
+        afterID, filter.EmailPrefix, filter.Status, filter.Role, limit, filter.OrgID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+    defer rows.Close()
+
+    var users []models.User
+    for rows.Next() {
+        user, err := scanUser(rows)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", op, err)
+        }
+        users = append(users, user)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return users, nil
+}
+
+// ChangeUserStatus applies the status transition to the user and records it.
+// The transition is applied only if the user still has the status it starts from,
+// otherwise ErrStatusConflict is returned.
+func (s *Storage) ChangeUserStatus(ctx context.Context, t models.StatusTransition) error {
+    const op = "storage.sqlite.ChangeUserStatus"
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx, `
+        UPDATE users SET status = $3, status_reason = $4, suspended_until = $5
+        WHERE id = $1 AND status = $2 AND `+tenantCondition("users.id", 6),
+        t.UserID, t.From, t.To, t.Reason, nullTime(t.Until), storage.Tenant(ctx),
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        var exists bool
+        err := tx.QueryRowContext(ctx,
+            "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND "+tenantCondition("users.id", 2)+")",
+            t.UserID, storage.Tenant(ctx),
+        ).Scan(&exists)
+        if err != nil {
+            return fmt.Errorf("%s: %w", op, err)
+        }
+        if !exists {
+            return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+        }
+        return fmt.Errorf("%s: %w", op, storage.ErrStatusConflict)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        INSERT INTO user_status_transitions(user_id, from_status, to_status, reason, until, actor, created_at)
+        VALUES($1, $2, $3, $4, $5, $6, $7)`,
+        t.UserID, t.From, t.To, t.Reason, nullTime(t.Until), t.Actor, t.CreatedAt,
+    )
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    err = enqueue(ctx, tx, models.DomainEventUserStatusChanged, t.UserID, models.UserStatusChanged{
+        UserID: t.UserID,
+        From:   t.From,
+        To:     t.To,
+        Reason: t.Reason,
+    })
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+// StatusTransitions returns the status transitions of the user, oldest first.
+func (s *Storage) StatusTransitions(ctx context.Context, userID int64) ([]models.StatusTransition, error) {
+    const op = "storage.sqlite.StatusTransitions"
+
+    transitions, err := statusTransitions(ctx, s.db, userID)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", op, err)
+    }
+
+    return transitions, nil
+}
+
+func statusTransitions(ctx context.Context, q querier, userID int64) ([]models.StatusTransition, error) {
+    rows, err := q.QueryContext(ctx, `
+        SELECT id, user_id, from_status, to_status, reason, until, actor, created_at
+        FROM user_status_transitions
+        WHERE user_id = $1 AND `+tenantCondition("user_status_transitions.user_id", 2)+`
+        ORDER BY id`,
+        userID, storage.Tenant(ctx),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var transitions []models.StatusTransition
+    for rows.Next() {
+        var t models.StatusTransition
+        err := rows.Scan(
+            &t.ID, &t.UserID, &t.From, &t.To, &t.Reason, timestamp(&t.Until), &t.Actor, timestamp(&t.CreatedAt),
+        )
+        if err != nil {
+            return nil, err
+        }
+        transitions = append(transitions, t)
+    }
+
+    return transitions, rows.Err()
+}
+
+// SetUserRoles replaces the roles of the user and publishes a user.roles_changed event.
+func (s *Storage) SetUserRoles(ctx context.Context, id int64, roles []string) error {
+    const op = "storage.sqlite.SetUserRoles"
+
+    rawRoles, err := jsonArray(roles)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return s.updateUser(ctx, op, id,
+        models.DomainEventUserRolesChanged, models.UserRolesChanged{UserID: id, Roles: roles},
+        "UPDATE users SET roles = $2 WHERE id = $1 AND "+tenantCondition("users.id", 3), rawRoles,
+    )
+}
+
+// UpdatePassword replaces the password hash of the user and publishes a user.password_changed event.
+func (s *Storage) UpdatePassword(ctx context.Context, id int64, passHash []byte) error {
+    const op = "storage.sqlite.UpdatePassword"
+
+    return s.updateUser(ctx, op, id,
+        models.DomainEventPasswordChanged, models.PasswordChanged{UserID: id},
+        "UPDATE users SET pass_hash = $2 WHERE id = $1 AND "+tenantCondition("users.id", 3), passHash,
+    )
+}
+
+// updateUser runs the update of the user with the id as $1, the value as $2 and the tenant
+// of the context as $3, and publishes the event in the same transaction.
+func (s *Storage) updateUser(
+    ctx context.Context,
+    op string,
+    id int64,
+    eventType string,
+    payload any,
+    query string,
+    value any,
+) error {
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx, query, id, value, storage.Tenant(ctx))
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := userAffected(op, res); err != nil {
+        return err
+    }
+
+    if err := enqueue(ctx, tx, eventType, id, payload); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+
+    return nil
+}
+
+func userAffected(op string, res sql.Result) error {
+    n, err := res.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("%s: %w", op, err)
+    }
+    if n == 0 {
+        return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+    }
+
+    return nil
+}
+
+func scanUser(row scanner) (models.User, error) {
+    var user models.User
+
+    err := scanProfile(row, &user.Profile,
+        &user.ID, &user.Email, &user.Username, &user.Phone, timestamp(&user.PhoneVerifiedAt),
+        &user.PassHash, jsonStrings(&user.Roles),
+        &user.Status, &user.StatusReason, timestamp(&user.SuspendedUntil), timestamp(&user.CreatedAt),
+    )
+    if err != nil {
+        return models.User{}, err
+    }
+
+    return user, nil
+}
+
+// tenantCondition is the condition of the user referenced by the column being a member of the tenant
+// of the context, see storage.WithTenant, passed as the parameter n. Without a tenant it always holds.
+func tenantCondition(column string, n int) string {
+    return fmt.Sprintf("($%[1]d = 0 OR EXISTS (SELECT 1 FROM org_members m WHERE m.org_id = $%[1]d AND m.user_id = %[2]s))", n, column)
+}
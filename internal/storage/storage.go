@@ -4,6 +4,43 @@ import "errors"
 
 var (
     ErrUserExists    = errors.New("user already exists")
+    ErrUsernameTaken = errors.New("username already taken")
+    ErrPhoneTaken    = errors.New("phone number already taken")
     ErrUserNotFound  = errors.New("user not found")
     ErrAppNotFound   = errors.New("app not found")
+
+    ErrStatusConflict = errors.New("user status changed concurrently")
+
+    ErrIdentityExists = errors.New("identity already linked")
+
+    ErrSessionNotFound = errors.New("session not found")
+
+    ErrAPIKeyNotFound = errors.New("api key not found")
+
+    ErrServiceAccountExists   = errors.New("service account already exists")
+    ErrServiceAccountNotFound = errors.New("service account not found")
+
+    ErrWebhookSubscriptionNotFound = errors.New("webhook subscription not found")
+    ErrWebhookDeliveryNotFound     = errors.New("webhook delivery not found")
+
+    ErrPhoneCodeNotFound = errors.New("phone code not found")
+
+    ErrDataJobNotFound = errors.New("data job not found")
+
+    ErrOrgExists          = errors.New("organization already exists")
+    ErrOrgNotFound        = errors.New("organization not found")
+    ErrMemberExists       = errors.New("user is already a member")
+    ErrMemberNotFound     = errors.New("member not found")
+    ErrInvitationNotFound = errors.New("invitation not found")
+    ErrInviteCodeNotFound = errors.New("invite code not found")
+
+    ErrGroupExists         = errors.New("group already exists")
+    ErrGroupNotFound       = errors.New("group not found")
+    ErrGroupMemberExists   = errors.New("already a member of the group")
+    ErrGroupMemberNotFound = errors.New("group member not found")
+    ErrGroupCycle          = errors.New("group nesting would form a cycle")
+
+    // ErrRevisionNotFound is returned by reads of relationships asked to be at least as fresh
+    // as a revision the storage has not reached.
+    ErrRevisionNotFound = errors.New("revision not found")
 )
@@ -0,0 +1,2018 @@
+// Package storagetest is the conformance suite every storage backend passes.
+// The suite only adds data under unique names, so it runs against databases
+// that are shared or already have data in them.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"grpc-service-ref/internal/domain/models"
+	"grpc-service-ref/internal/lib/audit"
+	"grpc-service-ref/internal/storage"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage is the storage under test.
+type Storage interface {
+    SaveUser(ctx context.Context, email string, passHash []byte) (int64, error)
+    CreateUser(ctx context.Context, ids models.UserIdentifiers, passHash []byte) (int64, error)
+    User(ctx context.Context, email string) (models.User, error)
+    UserByUsername(ctx context.Context, username string) (models.User, error)
+    UserByPhone(ctx context.Context, phone string) (models.User, error)
+    UserByID(ctx context.Context, id int64) (models.User, error)
+    UserByIdentity(ctx context.Context, provider, subject string) (models.User, error)
+    SaveIdentity(ctx context.Context, userID int64, provider, subject string) error
+
+    Users(ctx context.Context, filter models.UserFilter, afterID int64, limit int) ([]models.User, error)
+    ChangeUserStatus(ctx context.Context, t models.StatusTransition) error
+    StatusTransitions(ctx context.Context, userID int64) ([]models.StatusTransition, error)
+    SetUserRoles(ctx context.Context, id int64, roles []string) error
+    UpdatePassword(ctx context.Context, id int64, passHash []byte) error
+
+    SaveSession(ctx context.Context, session models.Session) error
+    Session(ctx context.Context, id string) (models.Session, error)
+    Sessions(ctx context.Context, userID int64) ([]models.Session, error)
+    TouchSession(ctx context.Context, id string, lastSeen time.Time) error
+    RevokeSession(ctx context.Context, userID int64, id string) error
+    RevokeSessions(ctx context.Context, userID int64, exceptID string) (int64, error)
+
+    SaveAPIKey(ctx context.Context, key models.APIKey) (int64, error)
+    APIKeyByHash(ctx context.Context, hash []byte) (models.APIKey, error)
+    APIKeys(ctx context.Context, owner models.Principal) ([]models.APIKey, error)
+    TouchAPIKey(ctx context.Context, id int64, lastUsed time.Time) error
+    RevokeAPIKey(ctx context.Context, owner models.Principal, id int64) error
+
+    SaveServiceAccount(ctx context.Context, sa models.ServiceAccount) (int64, error)
+    ServiceAccount(ctx context.Context, id int64) (models.ServiceAccount, error)
+    ServiceAccountByClientID(ctx context.Context, clientID string) (models.ServiceAccount, error)
+    ServiceAccounts(ctx context.Context, owner models.Owner) ([]models.ServiceAccount, error)
+    UpdateServiceAccountRoles(ctx context.Context, id int64, roles []string) error
+    UpdateServiceAccountSecret(ctx context.Context, id int64, secretHash []byte) error
+    DisableServiceAccount(ctx context.Context, id int64, disabledAt time.Time) error
+
+    AppendAuditEvent(ctx context.Context, event models.AuditEvent) (models.AuditEvent, error)
+    AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error)
+
+    ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error)
+    MarkOutboxDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+    MarkOutboxFailed(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+    MarkOutboxDead(ctx context.Context, id int64, lastErr string) error
+
+    SaveWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (int64, error)
+    WebhookSubscription(ctx context.Context, id int64) (models.WebhookSubscription, error)
+    WebhookSubscriptions(ctx context.Context, app string) ([]models.WebhookSubscription, error)
+    UpdateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) error
+    DeleteWebhookSubscription(ctx context.Context, id int64) error
+    EnqueueWebhookDeliveries(ctx context.Context, eventID int64, eventType string, payload []byte) (int64, error)
+    SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error)
+    ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+    RecordWebhookAttempt(
+        ctx context.Context,
+        attempt models.WebhookAttempt,
+        status models.WebhookDeliveryStatus,
+        nextAttemptAt time.Time,
+        disableAfter int,
+    ) (bool, error)
+    WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error)
+    WebhookDeliveries(ctx context.Context, subscriptionID, beforeID int64, limit int) ([]models.WebhookDelivery, error)
+    WebhookAttempts(ctx context.Context, deliveryID int64) ([]models.WebhookAttempt, error)
+
+    SavePhoneCode(ctx context.Context, code models.PhoneCode) (int64, error)
+    LatestPhoneCode(ctx context.Context, phone string, purpose models.PhoneCodePurpose, userID int64) (models.PhoneCode, error)
+    CountPhoneCodes(ctx context.Context, phone string, since time.Time) (int, error)
+    AddPhoneCodeAttempt(ctx context.Context, id int64) (int, error)
+    ConsumePhoneCode(ctx context.Context, id int64, consumedAt time.Time) error
+    VerifyUserPhone(ctx context.Context, userID int64, phone string, verifiedAt time.Time) error
+    UpdateProfile(ctx context.Context, userID int64, update models.ProfileUpdate) (models.Profile, error)
+
+    CreateOrganization(ctx context.Context, org models.Organization, owner models.Membership) (int64, error)
+    Organization(ctx context.Context, id int64) (models.Organization, error)
+    OrganizationBySlug(ctx context.Context, slug string) (models.Organization, error)
+    UserOrganizations(ctx context.Context, userID int64) ([]models.UserOrganization, error)
+    Membership(ctx context.Context, orgID, userID int64) (models.Membership, error)
+    Members(ctx context.Context, orgID, afterUserID int64, limit int) ([]models.Membership, error)
+    SetMemberRoles(ctx context.Context, orgID, userID int64, roles []string) error
+    RemoveMember(ctx context.Context, orgID, userID int64) error
+    SaveOrgInvitation(ctx context.Context, inv models.OrgInvitation) (int64, error)
+    OrgInvitationByHash(ctx context.Context, hash []byte) (models.OrgInvitation, error)
+    RevokeOrgInvitation(ctx context.Context, orgID, id int64, revokedAt time.Time) error
+    AcceptOrgInvitation(ctx context.Context, id, userID int64, acceptedAt time.Time) (models.Membership, error)
+    SaveInviteCode(ctx context.Context, code models.InviteCode) (int64, error)
+    InviteCodeByHash(ctx context.Context, hash []byte) (models.InviteCode, error)
+    RevokeInviteCode(ctx context.Context, id int64, revokedAt time.Time) error
+    CreateInvitedUser(ctx context.Context,
+        ids models.UserIdentifiers,
+        passHash []byte,
+        codeID int64,
+        usedAt time.Time,
+    ) (int64, error)
+
+    CreateGroup(ctx context.Context, g models.Group) (int64, error)
+    Group(ctx context.Context, id int64) (models.Group, error)
+    Groups(ctx context.Context, afterID int64, limit int) ([]models.Group, error)
+    SetGroupRoles(ctx context.Context, id int64, roles []string) error
+    DeleteGroup(ctx context.Context, id int64) error
+    AddGroupUser(ctx context.Context, groupID, userID int64) error
+    RemoveGroupUser(ctx context.Context, groupID, userID int64) error
+    AddSubgroup(ctx context.Context, groupID, subgroupID int64) error
+    RemoveSubgroup(ctx context.Context, groupID, subgroupID int64) error
+    GroupMembers(ctx context.Context, id int64) (models.GroupMembers, error)
+    UserGroups(ctx context.Context, userID int64) ([]models.UserGroup, error)
+
+    WriteRelationships(ctx context.Context, updates []models.RelationshipUpdate) (int64, error)
+    Relationships(ctx context.Context,
+        filters []models.RelationshipFilter,
+        minRevision int64,
+    ) ([]models.Relationship, int64, error)
+
+    SaveDataJob(ctx context.Context, job models.DataJob) (int64, error)
+    DataJob(ctx context.Context, id int64) (models.DataJob, error)
+    ClaimDataJobs(ctx context.Context, limit int, lease time.Duration) ([]models.DataJob, error)
+    CompleteDataJob(ctx context.Context, id int64, archive []byte, finishedAt, expiresAt time.Time) error
+    RetryDataJob(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+    FailDataJob(ctx context.Context, id int64, lastErr string, finishedAt time.Time) error
+    PurgeDataJobArchives(ctx context.Context, before time.Time) (int64, error)
+    UserData(ctx context.Context, userID int64) (models.UserData, error)
+    EraseUser(ctx context.Context, userID int64) error
+}
+
+// claimLimit is large enough for claims to include the items of the suite
+// next to the ones a shared database already has.
+const claimLimit = 10000
+
+// Run runs the suite against the storage that open returns.
+func Run(t *testing.T, open func(t *testing.T) Storage) {
+    tests := []struct {
+        name string
+        test func(t *testing.T, s Storage)
+    }{
+        {"Users", testUsers},
+        {"EmailCase", testEmailCase},
+        {"UserIdentifiers", testUserIdentifiers},
+        {"Identities", testIdentities},
+        {"ListUsers", testListUsers},
+        {"UserStatus", testUserStatus},
+        {"UpdateUser", testUpdateUser},
+        {"Sessions", testSessions},
+        {"APIKeys", testAPIKeys},
+        {"ServiceAccounts", testServiceAccounts},
+        {"AuditLog", testAuditLog},
+        {"Outbox", testOutbox},
+        {"WebhookSubscriptions", testWebhookSubscriptions},
+        {"WebhookDeliveries", testWebhookDeliveries},
+        {"PhoneCodes", testPhoneCodes},
+        {"VerifyUserPhone", testVerifyUserPhone},
+        {"Profiles", testProfiles},
+        {"Organizations", testOrganizations},
+        {"Memberships", testMemberships},
+        {"Tenant", testTenant},
+        {"OrgInvitations", testOrgInvitations},
+        {"InviteCodes", testInviteCodes},
+        {"Groups", testGroups},
+        {"GroupNesting", testGroupNesting},
+        {"Relationships", testRelationships},
+        {"DataJobs", testDataJobs},
+        {"UserData", testUserData},
+        {"EraseUser", testEraseUser},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.test(t, open(t))
+        })
+    }
+}
+
+func testUsers(t *testing.T, s Storage) {
+    ctx := context.Background()
+    email := unique("user") + "@example.com"
+
+    id, err := s.SaveUser(ctx, email, []byte("hash"))
+    require.NoError(t, err)
+
+    _, err = s.SaveUser(ctx, email, []byte("other"))
+    require.ErrorIs(t, err, storage.ErrUserExists)
+
+    user, err := s.User(ctx, email)
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+    assert.Equal(t, email, user.Email)
+    assert.Equal(t, []byte("hash"), user.PassHash)
+    assert.Empty(t, user.Roles)
+    assert.Equal(t, models.UserStatusActive, user.Status)
+    assert.WithinDuration(t, time.Now(), user.CreatedAt, time.Minute)
+
+    byID, err := s.UserByID(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, user, byID)
+
+    _, err = s.User(ctx, unique("missing")+"@example.com")
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    _, err = s.UserByID(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testEmailCase(t *testing.T, s Storage) {
+    ctx := context.Background()
+    local := unique("Case")
+
+    id, err := s.SaveUser(ctx, local+"@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    _, err = s.SaveUser(ctx, strings.ToLower(local)+"@example.com", []byte("other"))
+    require.ErrorIs(t, err, storage.ErrUserExists, "emails differing in case are the same")
+
+    user, err := s.User(ctx, strings.ToUpper(local)+"@EXAMPLE.COM")
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+    assert.Equal(t, local+"@example.com", user.Email, "the email is stored as given")
+
+    users, err := s.Users(ctx, models.UserFilter{EmailPrefix: strings.ToLower(local)}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, users, 1)
+    assert.Equal(t, id, users[0].ID)
+}
+
+func testUserIdentifiers(t *testing.T, s Storage) {
+    ctx := context.Background()
+    username := unique("Name")
+    phone := uniquePhone()
+
+    id, err := s.CreateUser(ctx, models.UserIdentifiers{Username: username, Phone: phone}, []byte("hash"))
+    require.NoError(t, err, "users may have no email")
+
+    user, err := s.UserByUsername(ctx, strings.ToLower(username))
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+    assert.Equal(t, username, user.Username)
+    assert.Equal(t, phone, user.Phone)
+    assert.Empty(t, user.Email)
+
+    user, err = s.UserByPhone(ctx, phone)
+    require.NoError(t, err)
+    assert.Equal(t, id, user.ID)
+
+    _, err = s.CreateUser(ctx, models.UserIdentifiers{Username: strings.ToUpper(username)}, []byte("other"))
+    require.ErrorIs(t, err, storage.ErrUsernameTaken, "usernames differing in case are the same")
+
+    _, err = s.CreateUser(ctx, models.UserIdentifiers{Email: unique("user") + "@example.com", Phone: phone}, []byte("other"))
+    require.ErrorIs(t, err, storage.ErrPhoneTaken)
+
+    _, err = s.CreateUser(ctx, models.UserIdentifiers{Phone: uniquePhone()}, []byte("hash"))
+    require.NoError(t, err, "users without email or username don't collide")
+
+    _, err = s.User(ctx, "")
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    _, err = s.UserByUsername(ctx, unique("name"))
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testIdentities(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    subject := unique("subject")
+
+    require.NoError(t, s.SaveIdentity(ctx, userID, "idp", subject))
+
+    user, err := s.UserByIdentity(ctx, "idp", subject)
+    require.NoError(t, err)
+    assert.Equal(t, userID, user.ID)
+
+    err = s.SaveIdentity(ctx, saveUser(t, s), "idp", subject)
+    require.ErrorIs(t, err, storage.ErrIdentityExists)
+
+    err = s.SaveIdentity(ctx, userID, "idp", unique("subject"))
+    require.ErrorIs(t, err, storage.ErrIdentityExists)
+
+    _, err = s.UserByIdentity(ctx, "idp", unique("subject"))
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testListUsers(t *testing.T, s Storage) {
+    ctx := context.Background()
+    prefix := unique("list")
+
+    var ids []int64
+    for i := range 3 {
+        id, err := s.SaveUser(ctx, fmt.Sprintf("%s-%d@example.com", prefix, i), []byte("hash"))
+        require.NoError(t, err)
+        ids = append(ids, id)
+    }
+    require.NoError(t, s.SetUserRoles(ctx, ids[1], []string{models.RoleSupport}))
+
+    users, err := s.Users(ctx, models.UserFilter{EmailPrefix: prefix}, 0, 2)
+    require.NoError(t, err)
+    require.Len(t, users, 2)
+    assert.Equal(t, ids[0], users[0].ID)
+    assert.Equal(t, ids[1], users[1].ID)
+
+    users, err = s.Users(ctx, models.UserFilter{EmailPrefix: prefix}, users[1].ID, 2)
+    require.NoError(t, err)
+    require.Len(t, users, 1)
+    assert.Equal(t, ids[2], users[0].ID)
+
+    users, err = s.Users(ctx, models.UserFilter{EmailPrefix: prefix, Role: models.RoleSupport}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, users, 1)
+    assert.Equal(t, ids[1], users[0].ID)
+
+    users, err = s.Users(ctx, models.UserFilter{EmailPrefix: prefix, Status: models.UserStatusLocked}, 0, 10)
+    require.NoError(t, err)
+    assert.Empty(t, users)
+
+    // Wildcards in the prefix are matched literally.
+    users, err = s.Users(ctx, models.UserFilter{EmailPrefix: prefix + "%"}, 0, 10)
+    require.NoError(t, err)
+    assert.Empty(t, users)
+
+    orgID := saveOrganization(t, s, ids[2])
+    users, err = s.Users(ctx, models.UserFilter{EmailPrefix: prefix, OrgID: orgID}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, users, 1)
+    assert.Equal(t, ids[2], users[0].ID)
+}
+
+func testUserStatus(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+    err := s.ChangeUserStatus(ctx, models.StatusTransition{
+        UserID:    userID,
+        From:      models.UserStatusActive,
+        To:        models.UserStatusSuspended,
+        Reason:    "spam",
+        Until:     until,
+        Actor:     "user:1",
+        CreatedAt: time.Now(),
+    })
+    require.NoError(t, err)
+
+    user, err := s.UserByID(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, models.UserStatusSuspended, user.Status)
+    assert.Equal(t, "spam", user.StatusReason)
+    assert.True(t, until.Equal(user.SuspendedUntil))
+
+    err = s.ChangeUserStatus(ctx, models.StatusTransition{
+        UserID:    userID,
+        From:      models.UserStatusActive,
+        To:        models.UserStatusLocked,
+        Actor:     "user:1",
+        CreatedAt: time.Now(),
+    })
+    require.ErrorIs(t, err, storage.ErrStatusConflict)
+
+    err = s.ChangeUserStatus(ctx, models.StatusTransition{
+        UserID:    -1,
+        From:      models.UserStatusActive,
+        To:        models.UserStatusLocked,
+        Actor:     "user:1",
+        CreatedAt: time.Now(),
+    })
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    transitions, err := s.StatusTransitions(ctx, userID)
+    require.NoError(t, err)
+    require.Len(t, transitions, 1)
+    assert.NotZero(t, transitions[0].ID)
+    assert.Equal(t, models.UserStatusActive, transitions[0].From)
+    assert.Equal(t, models.UserStatusSuspended, transitions[0].To)
+    assert.Equal(t, "spam", transitions[0].Reason)
+    assert.True(t, until.Equal(transitions[0].Until))
+    assert.Equal(t, "user:1", transitions[0].Actor)
+}
+
+func testUpdateUser(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+
+    require.NoError(t, s.SetUserRoles(ctx, userID, []string{models.RoleAdmin, models.RoleSupport}))
+    require.NoError(t, s.UpdatePassword(ctx, userID, []byte("new hash")))
+
+    user, err := s.UserByID(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, []string{models.RoleAdmin, models.RoleSupport}, user.Roles)
+    assert.Equal(t, []byte("new hash"), user.PassHash)
+
+    require.ErrorIs(t, s.SetUserRoles(ctx, -1, nil), storage.ErrUserNotFound)
+    require.ErrorIs(t, s.UpdatePassword(ctx, -1, []byte("hash")), storage.ErrUserNotFound)
+}
+
+func testSessions(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    now := time.Now().Truncate(time.Millisecond)
+
+    newSession := func(lastSeen, expires time.Time) models.Session {
+        session := models.Session{
+            ID:         unique("sid"),
+            UserID:     userID,
+            UserAgent:  "test",
+            IP:         "10.0.0.1",
+            CreatedAt:  now.Add(-time.Hour),
+            LastSeenAt: lastSeen,
+            ExpiresAt:  expires,
+        }
+        require.NoError(t, s.SaveSession(ctx, session))
+        return session
+    }
+
+    older := newSession(now.Add(-time.Minute), now.Add(time.Hour))
+    newer := newSession(now, now.Add(time.Hour))
+    newSession(now, now.Add(-time.Second))
+
+    session, err := s.Session(ctx, older.ID)
+    require.NoError(t, err)
+    assert.Equal(t, "test", session.UserAgent)
+    assert.Equal(t, "10.0.0.1", session.IP)
+    assert.True(t, older.ExpiresAt.Equal(session.ExpiresAt))
+
+    sessions, err := s.Sessions(ctx, userID)
+    require.NoError(t, err)
+    require.Len(t, sessions, 2, "expired sessions are not listed")
+    assert.Equal(t, newer.ID, sessions[0].ID)
+    assert.Equal(t, older.ID, sessions[1].ID)
+
+    require.NoError(t, s.TouchSession(ctx, older.ID, now.Add(time.Minute)))
+    sessions, err = s.Sessions(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, older.ID, sessions[0].ID)
+
+    err = s.RevokeSession(ctx, userID+1, older.ID)
+    require.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+    require.NoError(t, s.RevokeSession(ctx, userID, older.ID))
+    _, err = s.Session(ctx, older.ID)
+    require.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+    err = s.RevokeSession(ctx, userID, older.ID)
+    require.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+    kept := newSession(now, now.Add(time.Hour))
+    n, err := s.RevokeSessions(ctx, userID, kept.ID)
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), n)
+
+    sessions, err = s.Sessions(ctx, userID)
+    require.NoError(t, err)
+    require.Len(t, sessions, 1)
+    assert.Equal(t, kept.ID, sessions[0].ID)
+}
+
+func testAPIKeys(t *testing.T, s Storage) {
+    ctx := context.Background()
+    owner := models.Principal{Type: models.PrincipalUser, ID: saveUser(t, s)}
+    other := models.Principal{Type: models.PrincipalUser, ID: saveUser(t, s)}
+    now := time.Now().Truncate(time.Millisecond)
+
+    newKey := func(createdAt, expiresAt time.Time) models.APIKey {
+        key := models.APIKey{
+            Principal: owner,
+            Name:      "ci",
+            Prefix:    "sso_1234",
+            Hash:      []byte(unique("hash")),
+            Scopes:    []string{models.ScopeSessionsRead},
+            ExpiresAt: expiresAt,
+            CreatedAt: createdAt,
+        }
+        id, err := s.SaveAPIKey(ctx, key)
+        require.NoError(t, err)
+        key.ID = id
+        return key
+    }
+
+    older := newKey(now.Add(-time.Minute), time.Time{})
+    newer := newKey(now, now.Add(time.Hour))
+
+    key, err := s.APIKeyByHash(ctx, older.Hash)
+    require.NoError(t, err)
+    assert.Equal(t, older.ID, key.ID)
+    assert.Equal(t, owner.ID, key.Principal.ID)
+    assert.True(t, key.Principal.IsUser())
+    assert.Equal(t, []string{models.ScopeSessionsRead}, key.Scopes)
+    assert.True(t, key.ExpiresAt.IsZero())
+    assert.True(t, key.LastUsedAt.IsZero())
+
+    require.NoError(t, s.TouchAPIKey(ctx, older.ID, now))
+    key, err = s.APIKeyByHash(ctx, older.Hash)
+    require.NoError(t, err)
+    assert.True(t, now.Equal(key.LastUsedAt))
+
+    keys, err := s.APIKeys(ctx, owner)
+    require.NoError(t, err)
+    require.Len(t, keys, 2)
+    assert.Equal(t, newer.ID, keys[0].ID)
+    assert.True(t, newer.ExpiresAt.Equal(keys[0].ExpiresAt))
+    assert.Equal(t, older.ID, keys[1].ID)
+
+    keys, err = s.APIKeys(ctx, other)
+    require.NoError(t, err)
+    assert.Empty(t, keys)
+
+    err = s.RevokeAPIKey(ctx, other, older.ID)
+    require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+    require.NoError(t, s.RevokeAPIKey(ctx, owner, older.ID))
+    _, err = s.APIKeyByHash(ctx, older.Hash)
+    require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+    err = s.RevokeAPIKey(ctx, owner, older.ID)
+    require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+}
+
+func testServiceAccounts(t *testing.T, s Storage) {
+    ctx := context.Background()
+    owner := models.Owner{Kind: models.OwnerApp, Name: unique("app")}
+
+    sa := models.ServiceAccount{
+        Name:       "worker",
+        Owner:      owner,
+        ClientID:   unique("client"),
+        SecretHash: []byte("secret"),
+        Roles:      []string{models.RoleSupport},
+        CreatedAt:  time.Now(),
+    }
+    id, err := s.SaveServiceAccount(ctx, sa)
+    require.NoError(t, err)
+
+    _, err = s.SaveServiceAccount(ctx, sa)
+    require.ErrorIs(t, err, storage.ErrServiceAccountExists)
+
+    got, err := s.ServiceAccount(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, "worker", got.Name)
+    assert.Equal(t, owner, got.Owner)
+    assert.Equal(t, []byte("secret"), got.SecretHash)
+    assert.Equal(t, []string{models.RoleSupport}, got.Roles)
+    assert.True(t, got.DisabledAt.IsZero())
+
+    byClientID, err := s.ServiceAccountByClientID(ctx, sa.ClientID)
+    require.NoError(t, err)
+    assert.Equal(t, id, byClientID.ID)
+
+    accounts, err := s.ServiceAccounts(ctx, owner)
+    require.NoError(t, err)
+    require.Len(t, accounts, 1)
+    assert.Equal(t, id, accounts[0].ID)
+
+    require.NoError(t, s.UpdateServiceAccountRoles(ctx, id, []string{models.RoleAdmin}))
+    require.NoError(t, s.UpdateServiceAccountSecret(ctx, id, []byte("rotated")))
+    require.NoError(t, s.DisableServiceAccount(ctx, id, time.Now()))
+
+    got, err = s.ServiceAccount(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, []string{models.RoleAdmin}, got.Roles)
+    assert.Equal(t, []byte("rotated"), got.SecretHash)
+    assert.False(t, got.DisabledAt.IsZero())
+
+    err = s.DisableServiceAccount(ctx, id, time.Now())
+    require.ErrorIs(t, err, storage.ErrServiceAccountNotFound)
+
+    _, err = s.ServiceAccount(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrServiceAccountNotFound)
+    _, err = s.ServiceAccountByClientID(ctx, unique("client"))
+    require.ErrorIs(t, err, storage.ErrServiceAccountNotFound)
+    require.ErrorIs(t, s.UpdateServiceAccountRoles(ctx, -1, nil), storage.ErrServiceAccountNotFound)
+    require.ErrorIs(t, s.UpdateServiceAccountSecret(ctx, -1, nil), storage.ErrServiceAccountNotFound)
+}
+
+func testAuditLog(t *testing.T, s Storage) {
+    ctx := context.Background()
+    subject := unique("user")
+
+    var appended []models.AuditEvent
+    for _, eventType := range []string{models.EventLoginFailed, models.EventLoginSucceeded} {
+        event, err := s.AppendAuditEvent(ctx, models.AuditEvent{
+            Type:      eventType,
+            Actor:     subject,
+            Subject:   subject,
+            IP:        "10.0.0.1",
+            Outcome:   models.OutcomeSuccess,
+            Details:   map[string]string{"session_id": "sid"},
+            CreatedAt: time.Now(),
+        })
+        require.NoError(t, err)
+        require.NotZero(t, event.ID)
+        appended = append(appended, event)
+    }
+    assert.Greater(t, appended[1].ID, appended[0].ID)
+    require.NoError(t, audit.Verify(appended[1:]))
+
+    events, err := s.AuditEvents(ctx, models.AuditFilter{Subject: subject}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, events, 2)
+    assert.Equal(t, appended[1].ID, events[0].ID)
+    assert.Equal(t, appended[0].ID, events[1].ID)
+    assert.Equal(t, map[string]string{"session_id": "sid"}, events[0].Details)
+    assert.Equal(t, appended[1].Hash, events[0].Hash)
+    require.NoError(t, audit.Verify([]models.AuditEvent{events[0]}), "stored events keep their hash")
+
+    events, err = s.AuditEvents(ctx, models.AuditFilter{Subject: subject}, appended[1].ID, 10)
+    require.NoError(t, err)
+    require.Len(t, events, 1)
+    assert.Equal(t, appended[0].ID, events[0].ID)
+
+    events, err = s.AuditEvents(ctx, models.AuditFilter{Subject: subject, Type: models.EventLoginFailed}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, events, 1)
+    assert.Equal(t, appended[0].ID, events[0].ID)
+
+    events, err = s.AuditEvents(ctx, models.AuditFilter{Subject: subject, Since: time.Now().Add(time.Minute)}, 0, 10)
+    require.NoError(t, err)
+    assert.Empty(t, events)
+}
+
+func testOutbox(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+
+    event, ok := claimOutbox(t, s, aggregate)
+    require.True(t, ok, "registration is published")
+    assert.Equal(t, models.DomainEventUserRegistered, event.Type)
+    assert.Equal(t, models.OutboxPending, event.Status)
+    assert.JSONEq(t, fmt.Sprintf(`{"user_id":%d}`, userID), string(event.Payload), "payloads carry no personal data")
+
+    _, ok = claimOutbox(t, s, aggregate)
+    require.False(t, ok, "claimed events are leased")
+
+    require.NoError(t, s.MarkOutboxFailed(ctx, event.ID, "unavailable", time.Now().Add(-time.Second)))
+    retried, ok := claimOutbox(t, s, aggregate)
+    require.True(t, ok, "failed events are retried when due")
+    assert.Equal(t, 1, retried.Attempts)
+    assert.Equal(t, "unavailable", retried.LastError)
+
+    require.NoError(t, s.MarkOutboxDelivered(ctx, event.ID, time.Now()))
+
+    require.NoError(t, s.SetUserRoles(ctx, userID, []string{models.RoleSupport}))
+    event, ok = claimOutbox(t, s, aggregate)
+    require.True(t, ok)
+    assert.Equal(t, models.DomainEventUserRolesChanged, event.Type)
+
+    require.NoError(t, s.MarkOutboxDead(ctx, event.ID, "rejected"))
+    require.NoError(t, s.MarkOutboxFailed(ctx, event.ID, "rejected", time.Now().Add(-time.Second)))
+    _, ok = claimOutbox(t, s, aggregate)
+    require.False(t, ok, "dead events are not retried")
+}
+
+func testWebhookSubscriptions(t *testing.T, s Storage) {
+    ctx := context.Background()
+    app := unique("app")
+
+    sub := models.WebhookSubscription{
+        App:        app,
+        URL:        "https://example.com/hook",
+        EventTypes: []string{models.DomainEventUserRegistered},
+        Secret:     "secret",
+        CreatedAt:  time.Now(),
+    }
+    id, err := s.SaveWebhookSubscription(ctx, sub)
+    require.NoError(t, err)
+
+    got, err := s.WebhookSubscription(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, app, got.App)
+    assert.Equal(t, sub.URL, got.URL)
+    assert.Equal(t, sub.EventTypes, got.EventTypes)
+    assert.Equal(t, "secret", got.Secret)
+    assert.True(t, got.Enabled())
+
+    got.URL = "https://example.com/other"
+    got.EventTypes = []string{models.DomainEventUserRolesChanged}
+    got.ConsecutiveFailures = 2
+    got.DisabledAt = time.Now()
+    require.NoError(t, s.UpdateWebhookSubscription(ctx, got))
+
+    subs, err := s.WebhookSubscriptions(ctx, app)
+    require.NoError(t, err)
+    require.Len(t, subs, 1)
+    assert.Equal(t, "https://example.com/other", subs[0].URL)
+    assert.Equal(t, []string{models.DomainEventUserRolesChanged}, subs[0].EventTypes)
+    assert.Equal(t, 2, subs[0].ConsecutiveFailures)
+    assert.False(t, subs[0].Enabled())
+
+    require.NoError(t, s.DeleteWebhookSubscription(ctx, id))
+    _, err = s.WebhookSubscription(ctx, id)
+    require.ErrorIs(t, err, storage.ErrWebhookSubscriptionNotFound)
+    require.ErrorIs(t, s.DeleteWebhookSubscription(ctx, id), storage.ErrWebhookSubscriptionNotFound)
+    require.ErrorIs(t, s.UpdateWebhookSubscription(ctx, got), storage.ErrWebhookSubscriptionNotFound)
+}
+
+func testWebhookDeliveries(t *testing.T, s Storage) {
+    ctx := context.Background()
+    eventType := models.DomainEventUserStatusChanged
+    eventID := time.Now().UnixNano()
+
+    saveSub := func() int64 {
+        id, err := s.SaveWebhookSubscription(ctx, models.WebhookSubscription{
+            App:        unique("app"),
+            URL:        "https://example.com/hook",
+            EventTypes: []string{eventType},
+            Secret:     "secret",
+            CreatedAt:  time.Now(),
+        })
+        require.NoError(t, err)
+        return id
+    }
+    subID := saveSub()
+    disabledSubID := saveSub()
+    disabledSub, err := s.WebhookSubscription(ctx, disabledSubID)
+    require.NoError(t, err)
+    disabledSub.DisabledAt = time.Now()
+    require.NoError(t, s.UpdateWebhookSubscription(ctx, disabledSub))
+
+    n, err := s.EnqueueWebhookDeliveries(ctx, eventID, eventType, []byte(`{"id":1}`))
+    require.NoError(t, err)
+    assert.GreaterOrEqual(t, n, int64(1))
+
+    n, err = s.EnqueueWebhookDeliveries(ctx, eventID, eventType, []byte(`{"id":1}`))
+    require.NoError(t, err)
+    assert.Zero(t, n, "events are enqueued once")
+
+    deliveries, err := s.WebhookDeliveries(ctx, subID, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, deliveries, 1)
+    delivery := deliveries[0]
+    assert.Equal(t, eventID, delivery.EventID)
+    assert.Equal(t, eventType, delivery.EventType)
+    assert.JSONEq(t, `{"id":1}`, string(delivery.Payload))
+    assert.Equal(t, models.WebhookDeliveryPending, delivery.Status)
+
+    deliveries, err = s.WebhookDeliveries(ctx, disabledSubID, 0, 10)
+    require.NoError(t, err)
+    assert.Empty(t, deliveries, "disabled subscriptions get no deliveries")
+
+    claimed, ok := claimWebhookDelivery(t, s, delivery.ID)
+    require.True(t, ok)
+    assert.Equal(t, subID, claimed.SubscriptionID)
+    _, ok = claimWebhookDelivery(t, s, delivery.ID)
+    require.False(t, ok, "claimed deliveries are leased")
+
+    disabled, err := s.RecordWebhookAttempt(ctx, models.WebhookAttempt{
+        DeliveryID:  delivery.ID,
+        StatusCode:  500,
+        Duration:    20 * time.Millisecond,
+        AttemptedAt: time.Now(),
+    }, models.WebhookDeliveryPending, time.Now().Add(-time.Second), 2)
+    require.NoError(t, err)
+    assert.False(t, disabled)
+
+    disabled, err = s.RecordWebhookAttempt(ctx, models.WebhookAttempt{
+        DeliveryID:  delivery.ID,
+        Error:       "timeout",
+        Duration:    time.Second,
+        AttemptedAt: time.Now(),
+    }, models.WebhookDeliveryFailed, time.Now(), 2)
+    require.NoError(t, err)
+    assert.True(t, disabled, "subscriptions are disabled after repeated failures")
+
+    delivery, err = s.WebhookDelivery(ctx, delivery.ID)
+    require.NoError(t, err)
+    assert.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+    assert.Equal(t, 2, delivery.Attempts)
+    assert.Equal(t, "timeout", delivery.LastError)
+
+    sub, err := s.WebhookSubscription(ctx, subID)
+    require.NoError(t, err)
+    assert.Equal(t, 2, sub.ConsecutiveFailures)
+    assert.False(t, sub.Enabled())
+
+    attempts, err := s.WebhookAttempts(ctx, delivery.ID)
+    require.NoError(t, err)
+    require.Len(t, attempts, 2)
+    assert.Equal(t, 500, attempts[0].StatusCode)
+    assert.Equal(t, 20*time.Millisecond, attempts[0].Duration)
+    assert.Equal(t, "timeout", attempts[1].Error)
+
+    sub.DisabledAt = time.Time{}
+    sub.ConsecutiveFailures = 0
+    require.NoError(t, s.UpdateWebhookSubscription(ctx, sub))
+
+    replayID, err := s.SaveWebhookDelivery(ctx, models.WebhookDelivery{
+        SubscriptionID: subID,
+        EventID:        eventID,
+        EventType:      eventType,
+        Payload:        delivery.Payload,
+        ReplayOf:       delivery.ID,
+    })
+    require.NoError(t, err)
+
+    disabled, err = s.RecordWebhookAttempt(ctx, models.WebhookAttempt{
+        DeliveryID:  replayID,
+        StatusCode:  204,
+        AttemptedAt: time.Now(),
+    }, models.WebhookDeliverySucceeded, time.Now(), 2)
+    require.NoError(t, err)
+    assert.False(t, disabled)
+
+    replay, err := s.WebhookDelivery(ctx, replayID)
+    require.NoError(t, err)
+    assert.Equal(t, delivery.ID, replay.ReplayOf)
+    assert.Equal(t, models.WebhookDeliverySucceeded, replay.Status)
+    assert.False(t, replay.DeliveredAt.IsZero())
+
+    deliveries, err = s.WebhookDeliveries(ctx, subID, 0, 1)
+    require.NoError(t, err)
+    require.Len(t, deliveries, 1)
+    assert.Equal(t, replayID, deliveries[0].ID)
+    deliveries, err = s.WebhookDeliveries(ctx, subID, replayID, 1)
+    require.NoError(t, err)
+    require.Len(t, deliveries, 1)
+    assert.Equal(t, delivery.ID, deliveries[0].ID)
+
+    require.NoError(t, s.DeleteWebhookSubscription(ctx, subID))
+    _, err = s.WebhookDelivery(ctx, delivery.ID)
+    require.ErrorIs(t, err, storage.ErrWebhookDeliveryNotFound)
+}
+
+var counter atomic.Int64
+
+// unique returns a name no other test run uses.
+func testPhoneCodes(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    phone := uniquePhone()
+    now := time.Now().Truncate(time.Microsecond)
+
+    _, err := s.LatestPhoneCode(ctx, phone, models.PhoneCodeLogin, 0)
+    require.ErrorIs(t, err, storage.ErrPhoneCodeNotFound)
+
+    var ids []int64
+    for _, purpose := range []models.PhoneCodePurpose{models.PhoneCodeLogin, models.PhoneCodeLogin, models.PhoneCodeVerify} {
+        id, err := s.SavePhoneCode(ctx, models.PhoneCode{
+            Phone:     phone,
+            Purpose:   purpose,
+            UserID:    userID,
+            Hash:      []byte("hash"),
+            CreatedAt: now,
+            ExpiresAt: now.Add(5 * time.Minute),
+        })
+        require.NoError(t, err)
+        ids = append(ids, id)
+    }
+
+    code, err := s.LatestPhoneCode(ctx, phone, models.PhoneCodeLogin, 0)
+    require.NoError(t, err)
+    assert.Equal(t, ids[1], code.ID, "the latest code of the purpose")
+    assert.Equal(t, userID, code.UserID)
+    assert.Equal(t, []byte("hash"), code.Hash)
+    assert.True(t, now.Add(5*time.Minute).Equal(code.ExpiresAt))
+    assert.Zero(t, code.Attempts)
+    assert.True(t, code.ConsumedAt.IsZero())
+
+    otherID := saveUser(t, s)
+    otherCode, err := s.SavePhoneCode(ctx, models.PhoneCode{
+        Phone:     phone,
+        Purpose:   models.PhoneCodeVerify,
+        UserID:    otherID,
+        Hash:      []byte("other"),
+        CreatedAt: now,
+        ExpiresAt: now.Add(5 * time.Minute),
+    })
+    require.NoError(t, err)
+
+    latest, err := s.LatestPhoneCode(ctx, phone, models.PhoneCodeVerify, userID)
+    require.NoError(t, err)
+    assert.Equal(t, ids[2], latest.ID, "the latest code of the purpose for the user")
+    latest, err = s.LatestPhoneCode(ctx, phone, models.PhoneCodeVerify, 0)
+    require.NoError(t, err)
+    assert.Equal(t, otherCode, latest.ID, "the latest code of the purpose for any user")
+    _, err = s.LatestPhoneCode(ctx, phone, models.PhoneCodeLogin, otherID)
+    require.ErrorIs(t, err, storage.ErrPhoneCodeNotFound)
+
+    n, err := s.CountPhoneCodes(ctx, phone, now.Add(-time.Minute))
+    require.NoError(t, err)
+    assert.Equal(t, 4, n)
+
+    n, err = s.CountPhoneCodes(ctx, phone, now.Add(time.Minute))
+    require.NoError(t, err)
+    assert.Zero(t, n)
+
+    attempts, err := s.AddPhoneCodeAttempt(ctx, code.ID)
+    require.NoError(t, err)
+    assert.Equal(t, 1, attempts)
+    attempts, err = s.AddPhoneCodeAttempt(ctx, code.ID)
+    require.NoError(t, err)
+    assert.Equal(t, 2, attempts)
+
+    require.NoError(t, s.ConsumePhoneCode(ctx, code.ID, now))
+    err = s.ConsumePhoneCode(ctx, code.ID, now)
+    require.ErrorIs(t, err, storage.ErrPhoneCodeNotFound, "codes are used once")
+
+    code, err = s.LatestPhoneCode(ctx, phone, models.PhoneCodeLogin, 0)
+    require.NoError(t, err)
+    assert.Equal(t, 2, code.Attempts)
+    assert.True(t, now.Equal(code.ConsumedAt))
+}
+
+func testVerifyUserPhone(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    phone := uniquePhone()
+    now := time.Now().Truncate(time.Microsecond)
+
+    require.NoError(t, s.VerifyUserPhone(ctx, userID, phone, now))
+
+    user, err := s.UserByPhone(ctx, phone)
+    require.NoError(t, err)
+    assert.Equal(t, userID, user.ID)
+    assert.True(t, now.Equal(user.PhoneVerifiedAt))
+
+    err = s.VerifyUserPhone(ctx, saveUser(t, s), phone, now)
+    require.ErrorIs(t, err, storage.ErrPhoneTaken)
+
+    err = s.VerifyUserPhone(ctx, -1, uniquePhone(), now)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testProfiles(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+
+    profile, err := s.UpdateProfile(ctx, userID, models.ProfileUpdate{
+        Fields:     []string{models.ProfileDisplayName, models.ProfileLocale},
+        Attributes: []models.AttributePath{{Namespace: "shop"}, {Namespace: "blog", Key: "theme"}},
+        Profile: models.Profile{
+            DisplayName: "Ann",
+            Locale:      "en-US",
+            Timezone:    "ignored, not in the fields",
+            Attributes: models.Attributes{
+                "shop": {"plan": "pro", "seats": float64(3)},
+                "blog": {"theme": "dark", "ignored": true},
+            },
+        },
+    })
+    require.NoError(t, err)
+    assert.Equal(t, "Ann", profile.DisplayName)
+    assert.Empty(t, profile.Timezone)
+
+    _, err = s.UpdateProfile(ctx, userID, models.ProfileUpdate{
+        Fields:     []string{models.ProfileDisplayName},
+        Attributes: []models.AttributePath{{Namespace: "shop", Key: "seats"}, {Namespace: "blog", Key: "theme"}},
+        Profile: models.Profile{
+            Attributes: models.Attributes{"shop": {"seats": float64(5)}},
+        },
+    })
+    require.NoError(t, err)
+
+    user, err := s.UserByID(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, models.Profile{
+        Locale:     "en-US",
+        Attributes: models.Attributes{"shop": {"plan": "pro", "seats": float64(5)}},
+    }, user.Profile, "cleared fields are empty, namespaces without attributes are removed")
+
+    events, err := s.ClaimOutboxEvents(ctx, claimLimit, time.Minute)
+    require.NoError(t, err)
+
+    aggregate := models.Principal{Type: models.PrincipalUser, ID: userID}.String()
+
+    var updates int
+    for _, event := range events {
+        if event.AggregateID == aggregate && event.Type == models.DomainEventProfileUpdated {
+            updates++
+        }
+    }
+    assert.Equal(t, 2, updates)
+
+    _, err = s.UpdateProfile(ctx, -1, models.ProfileUpdate{Fields: []string{models.ProfileLocale}})
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testOrganizations(t *testing.T, s Storage) {
+    ctx := context.Background()
+    ownerID := saveUser(t, s)
+    slug := unique("org")
+
+    id, err := s.CreateOrganization(ctx,
+        models.Organization{Slug: slug, Name: "Acme", CreatedAt: time.Now()},
+        models.Membership{UserID: ownerID, Roles: []string{models.OrgRoleAdmin}, CreatedAt: time.Now()},
+    )
+    require.NoError(t, err)
+
+    _, err = s.CreateOrganization(ctx,
+        models.Organization{Slug: slug, Name: "Other", CreatedAt: time.Now()},
+        models.Membership{UserID: ownerID, Roles: []string{models.OrgRoleAdmin}, CreatedAt: time.Now()},
+    )
+    require.ErrorIs(t, err, storage.ErrOrgExists)
+
+    _, err = s.CreateOrganization(ctx,
+        models.Organization{Slug: unique("org"), Name: "Orphan", CreatedAt: time.Now()},
+        models.Membership{UserID: -1, Roles: []string{models.OrgRoleAdmin}, CreatedAt: time.Now()},
+    )
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    org, err := s.Organization(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, slug, org.Slug)
+    assert.Equal(t, "Acme", org.Name)
+    assert.WithinDuration(t, time.Now(), org.CreatedAt, time.Minute)
+
+    bySlug, err := s.OrganizationBySlug(ctx, slug)
+    require.NoError(t, err)
+    assert.Equal(t, org, bySlug)
+
+    _, err = s.Organization(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrOrgNotFound)
+    _, err = s.OrganizationBySlug(ctx, unique("missing"))
+    require.ErrorIs(t, err, storage.ErrOrgNotFound)
+
+    orgs, err := s.UserOrganizations(ctx, ownerID)
+    require.NoError(t, err)
+    require.Len(t, orgs, 1)
+    assert.Equal(t, org, orgs[0].Organization)
+    assert.Equal(t, []string{models.OrgRoleAdmin}, orgs[0].Roles)
+    assert.False(t, orgs[0].JoinedAt.IsZero())
+}
+
+func testMemberships(t *testing.T, s Storage) {
+    ctx := context.Background()
+    ownerID := saveUser(t, s)
+    orgID := saveOrganization(t, s, ownerID)
+    otherOrgID := saveOrganization(t, s, ownerID)
+
+    m, err := s.Membership(ctx, orgID, ownerID)
+    require.NoError(t, err)
+    assert.Equal(t, orgID, m.OrgID)
+    assert.Equal(t, ownerID, m.UserID)
+    assert.Equal(t, []string{models.OrgRoleAdmin}, m.Roles)
+
+    memberID := saveUser(t, s)
+    _, err = s.Membership(ctx, orgID, memberID)
+    require.ErrorIs(t, err, storage.ErrMemberNotFound)
+
+    inviteMember(t, s, orgID, memberID, models.OrgRoleMember)
+
+    members, err := s.Members(ctx, orgID, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, members, 2)
+    assert.Equal(t, ownerID, members[0].UserID)
+    assert.Equal(t, memberID, members[1].UserID)
+
+    members, err = s.Members(ctx, orgID, ownerID, 1)
+    require.NoError(t, err)
+    require.Len(t, members, 1)
+    assert.Equal(t, memberID, members[0].UserID)
+
+    require.NoError(t, s.SetMemberRoles(ctx, orgID, memberID, []string{models.OrgRoleAdmin, "billing"}))
+    m, err = s.Membership(ctx, orgID, memberID)
+    require.NoError(t, err)
+    assert.Equal(t, []string{models.OrgRoleAdmin, "billing"}, m.Roles)
+    require.ErrorIs(t, s.SetMemberRoles(ctx, otherOrgID, memberID, nil), storage.ErrMemberNotFound)
+
+    require.NoError(t, s.RemoveMember(ctx, orgID, memberID))
+    _, err = s.Membership(ctx, orgID, memberID)
+    require.ErrorIs(t, err, storage.ErrMemberNotFound)
+    require.ErrorIs(t, s.RemoveMember(ctx, orgID, memberID), storage.ErrMemberNotFound)
+
+    orgs, err := s.UserOrganizations(ctx, ownerID)
+    require.NoError(t, err)
+    require.Len(t, orgs, 2)
+    assert.Equal(t, orgID, orgs[0].Organization.ID)
+    assert.Equal(t, otherOrgID, orgs[1].Organization.ID)
+}
+
+// testTenant reads and changes users within a tenant, which hides the users outside of it.
+func testTenant(t *testing.T, s Storage) {
+    ctx := context.Background()
+    memberID := saveUser(t, s)
+    outsiderID := saveUser(t, s)
+    orgID := saveOrganization(t, s, memberID)
+    saveOrganization(t, s, outsiderID)
+    now := time.Now().Truncate(time.Millisecond)
+
+    sid := unique("sid")
+    require.NoError(t, s.SaveSession(ctx, models.Session{
+        ID: sid, UserID: outsiderID, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour),
+    }))
+    outsider := models.Principal{Type: models.PrincipalUser, ID: outsiderID}
+    keyID, err := s.SaveAPIKey(ctx, models.APIKey{
+        Principal: outsider, Name: "ci", Prefix: "sso_1234", Hash: []byte(unique("hash")), CreatedAt: now,
+    })
+    require.NoError(t, err)
+
+    scoped := storage.WithTenant(ctx, orgID)
+
+    user, err := s.UserByID(scoped, memberID)
+    require.NoError(t, err)
+    assert.Equal(t, memberID, user.ID)
+    _, err = s.UserByID(scoped, outsiderID)
+    require.ErrorIs(t, err, storage.ErrUserNotFound, "users of other organizations are missing")
+
+    users, err := s.Users(scoped, models.UserFilter{}, memberID-1, 100)
+    require.NoError(t, err)
+    var ids []int64
+    for _, u := range users {
+        ids = append(ids, u.ID)
+    }
+    assert.Contains(t, ids, memberID)
+    assert.NotContains(t, ids, outsiderID)
+
+    sessions, err := s.Sessions(scoped, outsiderID)
+    require.NoError(t, err)
+    assert.Empty(t, sessions)
+    require.ErrorIs(t, s.RevokeSession(scoped, outsiderID, sid), storage.ErrSessionNotFound)
+    n, err := s.RevokeSessions(scoped, outsiderID, "")
+    require.NoError(t, err)
+    assert.Zero(t, n)
+
+    keys, err := s.APIKeys(scoped, outsider)
+    require.NoError(t, err)
+    assert.Empty(t, keys)
+    require.ErrorIs(t, s.RevokeAPIKey(scoped, outsider, keyID), storage.ErrAPIKeyNotFound)
+
+    err = s.ChangeUserStatus(scoped, models.StatusTransition{
+        UserID: outsiderID, From: models.UserStatusActive, To: models.UserStatusSuspended,
+        Reason: "spam", Actor: models.ActorSystem, CreatedAt: now,
+    })
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+    require.ErrorIs(t, s.SetUserRoles(scoped, outsiderID, []string{"admin"}), storage.ErrUserNotFound)
+    require.ErrorIs(t, s.UpdatePassword(scoped, outsiderID, []byte("new hash")), storage.ErrUserNotFound)
+    transitions, err := s.StatusTransitions(scoped, outsiderID)
+    require.NoError(t, err)
+    assert.Empty(t, transitions)
+
+    require.NoError(t, s.SetUserRoles(scoped, memberID, []string{"admin"}))
+
+    user, err = s.UserByID(ctx, outsiderID)
+    require.NoError(t, err, "users are not hidden outside of a tenant")
+    assert.Equal(t, models.UserStatusActive, user.Status)
+    assert.Empty(t, user.Roles)
+    sessions, err = s.Sessions(ctx, outsiderID)
+    require.NoError(t, err)
+    assert.Len(t, sessions, 1, "sessions of other organizations stay active")
+    keys, err = s.APIKeys(ctx, outsider)
+    require.NoError(t, err)
+    assert.Len(t, keys, 1)
+}
+
+func testOrgInvitations(t *testing.T, s Storage) {
+    ctx := context.Background()
+    ownerID := saveUser(t, s)
+    orgID := saveOrganization(t, s, ownerID)
+    hash := []byte(unique("hash"))
+
+    id, err := s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID:     orgID,
+        Email:     "invitee@example.com",
+        Roles:     []string{models.OrgRoleMember},
+        Hash:      hash,
+        Inviter:   "user:1",
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+
+    _, err = s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID:     -1,
+        Hash:      []byte(unique("hash")),
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.ErrorIs(t, err, storage.ErrOrgNotFound)
+
+    inv, err := s.OrgInvitationByHash(ctx, hash)
+    require.NoError(t, err)
+    assert.Equal(t, id, inv.ID)
+    assert.Equal(t, orgID, inv.OrgID)
+    assert.Equal(t, "invitee@example.com", inv.Email)
+    assert.Equal(t, []string{models.OrgRoleMember}, inv.Roles)
+    assert.Equal(t, "user:1", inv.Inviter)
+    assert.True(t, inv.Pending(time.Now()))
+
+    _, err = s.OrgInvitationByHash(ctx, []byte(unique("missing")))
+    require.ErrorIs(t, err, storage.ErrInvitationNotFound)
+
+    // Members cannot accept invitations, which stay pending for the invitee.
+    _, err = s.AcceptOrgInvitation(ctx, id, ownerID, time.Now())
+    require.ErrorIs(t, err, storage.ErrMemberExists)
+    _, err = s.AcceptOrgInvitation(ctx, id, -1, time.Now())
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+
+    userID := saveUser(t, s)
+    m, err := s.AcceptOrgInvitation(ctx, id, userID, time.Now())
+    require.NoError(t, err)
+    assert.Equal(t, orgID, m.OrgID)
+    assert.Equal(t, userID, m.UserID)
+    assert.Equal(t, []string{models.OrgRoleMember}, m.Roles)
+
+    stored, err := s.Membership(ctx, orgID, userID)
+    require.NoError(t, err)
+    assert.Equal(t, m.Roles, stored.Roles)
+
+    inv, err = s.OrgInvitationByHash(ctx, hash)
+    require.NoError(t, err)
+    assert.Equal(t, userID, inv.AcceptedBy)
+    assert.False(t, inv.Pending(time.Now()))
+
+    _, err = s.AcceptOrgInvitation(ctx, id, saveUser(t, s), time.Now())
+    require.ErrorIs(t, err, storage.ErrInvitationNotFound, "invitations are accepted once")
+    require.ErrorIs(t, s.RevokeOrgInvitation(ctx, orgID, id, time.Now()), storage.ErrInvitationNotFound)
+
+    revokedHash := []byte(unique("hash"))
+    revokedID, err := s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID:     orgID,
+        Email:     "other@example.com",
+        Hash:      revokedHash,
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+    require.ErrorIs(t, s.RevokeOrgInvitation(ctx, orgID+1, revokedID, time.Now()), storage.ErrInvitationNotFound)
+    require.NoError(t, s.RevokeOrgInvitation(ctx, orgID, revokedID, time.Now()))
+
+    inv, err = s.OrgInvitationByHash(ctx, revokedHash)
+    require.NoError(t, err)
+    assert.False(t, inv.RevokedAt.IsZero())
+    _, err = s.AcceptOrgInvitation(ctx, revokedID, saveUser(t, s), time.Now())
+    require.ErrorIs(t, err, storage.ErrInvitationNotFound)
+
+    expiredID, err := s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID:     orgID,
+        Email:     "late@example.com",
+        Hash:      []byte(unique("hash")),
+        CreatedAt: time.Now().Add(-2 * time.Hour),
+        ExpiresAt: time.Now().Add(-time.Hour),
+    })
+    require.NoError(t, err)
+    _, err = s.AcceptOrgInvitation(ctx, expiredID, saveUser(t, s), time.Now())
+    require.ErrorIs(t, err, storage.ErrInvitationNotFound)
+}
+
+func testInviteCodes(t *testing.T, s Storage) {
+    ctx := context.Background()
+    orgID := saveOrganization(t, s, saveUser(t, s))
+    hash := []byte(unique("hash"))
+
+    id, err := s.SaveInviteCode(ctx, models.InviteCode{
+        Hash:      hash,
+        Email:     "invitee@example.com",
+        Roles:     []string{"support"},
+        OrgID:     orgID,
+        OrgRoles:  []string{models.OrgRoleMember},
+        Creator:   "user:1",
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+
+    _, err = s.SaveInviteCode(ctx, models.InviteCode{
+        Hash:      []byte(unique("hash")),
+        OrgID:     -1,
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.ErrorIs(t, err, storage.ErrOrgNotFound)
+
+    code, err := s.InviteCodeByHash(ctx, hash)
+    require.NoError(t, err)
+    assert.Equal(t, id, code.ID)
+    assert.Equal(t, "invitee@example.com", code.Email)
+    assert.Equal(t, []string{"support"}, code.Roles)
+    assert.Equal(t, orgID, code.OrgID)
+    assert.Equal(t, []string{models.OrgRoleMember}, code.OrgRoles)
+    assert.Equal(t, "user:1", code.Creator)
+    assert.True(t, code.Pending(time.Now()))
+
+    _, err = s.InviteCodeByHash(ctx, []byte(unique("missing")))
+    require.ErrorIs(t, err, storage.ErrInviteCodeNotFound)
+
+    email := unique("invitee") + "@example.com"
+    userID, err := s.CreateInvitedUser(ctx, models.UserIdentifiers{Email: email}, []byte("hash"), id, time.Now())
+    require.NoError(t, err)
+
+    user, err := s.UserByID(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, email, user.Email)
+    assert.Equal(t, []string{"support"}, user.Roles)
+    m, err := s.Membership(ctx, orgID, userID)
+    require.NoError(t, err)
+    assert.Equal(t, []string{models.OrgRoleMember}, m.Roles)
+
+    code, err = s.InviteCodeByHash(ctx, hash)
+    require.NoError(t, err)
+    assert.Equal(t, userID, code.UsedBy)
+    assert.False(t, code.Pending(time.Now()))
+
+    // Used codes create no users.
+    other := models.UserIdentifiers{Email: unique("other") + "@example.com"}
+    _, err = s.CreateInvitedUser(ctx, other, []byte("hash"), id, time.Now())
+    require.ErrorIs(t, err, storage.ErrInviteCodeNotFound, "codes are used once")
+    _, err = s.User(ctx, other.Email)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+    require.ErrorIs(t, s.RevokeInviteCode(ctx, id, time.Now()), storage.ErrInviteCodeNotFound)
+
+    // Codes without an organization give no membership, and taken identifiers leave codes unused.
+    plainHash := []byte(unique("hash"))
+    plainID, err := s.SaveInviteCode(ctx, models.InviteCode{
+        Hash:      plainHash,
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+    _, err = s.CreateInvitedUser(ctx, models.UserIdentifiers{Email: email}, []byte("hash"), plainID, time.Now())
+    require.ErrorIs(t, err, storage.ErrUserExists)
+
+    plainUserID, err := s.CreateInvitedUser(ctx, other, []byte("hash"), plainID, time.Now())
+    require.NoError(t, err)
+    user, err = s.UserByID(ctx, plainUserID)
+    require.NoError(t, err)
+    assert.Empty(t, user.Roles)
+    orgs, err := s.UserOrganizations(ctx, plainUserID)
+    require.NoError(t, err)
+    assert.Empty(t, orgs)
+
+    revokedHash := []byte(unique("hash"))
+    revokedID, err := s.SaveInviteCode(ctx, models.InviteCode{
+        Hash:      revokedHash,
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+    require.NoError(t, s.RevokeInviteCode(ctx, revokedID, time.Now()))
+
+    code, err = s.InviteCodeByHash(ctx, revokedHash)
+    require.NoError(t, err)
+    assert.False(t, code.RevokedAt.IsZero())
+    _, err = s.CreateInvitedUser(ctx, models.UserIdentifiers{Email: unique("late") + "@example.com"}, []byte("hash"), revokedID, time.Now())
+    require.ErrorIs(t, err, storage.ErrInviteCodeNotFound)
+
+    expiredID, err := s.SaveInviteCode(ctx, models.InviteCode{
+        Hash:      []byte(unique("hash")),
+        CreatedAt: time.Now().Add(-2 * time.Hour),
+        ExpiresAt: time.Now().Add(-time.Hour),
+    })
+    require.NoError(t, err)
+    _, err = s.CreateInvitedUser(ctx, models.UserIdentifiers{Email: unique("late") + "@example.com"}, []byte("hash"), expiredID, time.Now())
+    require.ErrorIs(t, err, storage.ErrInviteCodeNotFound)
+}
+
+func testGroups(t *testing.T, s Storage) {
+    ctx := context.Background()
+    name := unique("group")
+
+    id, err := s.CreateGroup(ctx, models.Group{
+        Name:        name,
+        Description: "Engineering",
+        Roles:       []string{models.RoleSupport},
+        CreatedAt:   time.Now(),
+    })
+    require.NoError(t, err)
+    _, err = s.CreateGroup(ctx, models.Group{Name: name, CreatedAt: time.Now()})
+    require.ErrorIs(t, err, storage.ErrGroupExists)
+    otherID := saveGroup(t, s)
+
+    g, err := s.Group(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, name, g.Name)
+    assert.Equal(t, "Engineering", g.Description)
+    assert.Equal(t, []string{models.RoleSupport}, g.Roles)
+    assert.WithinDuration(t, time.Now(), g.CreatedAt, time.Minute)
+    _, err = s.Group(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrGroupNotFound)
+
+    groups, err := s.Groups(ctx, id-1, 2)
+    require.NoError(t, err)
+    require.Len(t, groups, 2)
+    assert.Equal(t, g, groups[0])
+    assert.Equal(t, otherID, groups[1].ID)
+
+    require.NoError(t, s.SetGroupRoles(ctx, id, []string{models.RoleAdmin, "billing"}))
+    g, err = s.Group(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, []string{models.RoleAdmin, "billing"}, g.Roles)
+    require.ErrorIs(t, s.SetGroupRoles(ctx, -1, nil), storage.ErrGroupNotFound)
+
+    userID := saveUser(t, s)
+    require.NoError(t, s.AddGroupUser(ctx, id, userID))
+    require.ErrorIs(t, s.AddGroupUser(ctx, id, userID), storage.ErrGroupMemberExists)
+    require.ErrorIs(t, s.AddGroupUser(ctx, -1, userID), storage.ErrGroupNotFound)
+    require.ErrorIs(t, s.AddGroupUser(ctx, id, -1), storage.ErrUserNotFound)
+
+    members, err := s.GroupMembers(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, models.GroupMembers{UserIDs: []int64{userID}}, members)
+    _, err = s.GroupMembers(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrGroupNotFound)
+
+    userGroups, err := s.UserGroups(ctx, userID)
+    require.NoError(t, err)
+    require.Len(t, userGroups, 1)
+    assert.Equal(t, g, userGroups[0].Group)
+    assert.Equal(t, []int64{id}, userGroups[0].Path)
+
+    require.NoError(t, s.RemoveGroupUser(ctx, id, userID))
+    require.ErrorIs(t, s.RemoveGroupUser(ctx, id, userID), storage.ErrGroupMemberNotFound)
+    userGroups, err = s.UserGroups(ctx, userID)
+    require.NoError(t, err)
+    assert.Empty(t, userGroups)
+
+    require.NoError(t, s.AddGroupUser(ctx, id, userID))
+    require.NoError(t, s.DeleteGroup(ctx, id))
+    _, err = s.Group(ctx, id)
+    require.ErrorIs(t, err, storage.ErrGroupNotFound)
+    require.ErrorIs(t, s.DeleteGroup(ctx, id), storage.ErrGroupNotFound)
+    userGroups, err = s.UserGroups(ctx, userID)
+    require.NoError(t, err)
+    assert.Empty(t, userGroups, "members of deleted groups stay")
+}
+
+func testGroupNesting(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    engID := saveGroup(t, s)
+    platformID := saveGroup(t, s)
+    staffID := saveGroup(t, s)
+
+    require.NoError(t, s.AddGroupUser(ctx, engID, userID))
+    require.NoError(t, s.AddSubgroup(ctx, platformID, engID))
+    require.NoError(t, s.AddSubgroup(ctx, staffID, platformID))
+    require.NoError(t, s.AddSubgroup(ctx, staffID, engID))
+
+    require.ErrorIs(t, s.AddSubgroup(ctx, staffID, engID), storage.ErrGroupMemberExists)
+    require.ErrorIs(t, s.AddSubgroup(ctx, engID, staffID), storage.ErrGroupCycle)
+    require.ErrorIs(t, s.AddSubgroup(ctx, engID, platformID), storage.ErrGroupCycle)
+    require.ErrorIs(t, s.AddSubgroup(ctx, engID, engID), storage.ErrGroupCycle)
+    require.ErrorIs(t, s.AddSubgroup(ctx, engID, -1), storage.ErrGroupNotFound)
+    require.ErrorIs(t, s.AddSubgroup(ctx, -1, engID), storage.ErrGroupNotFound)
+
+    members, err := s.GroupMembers(ctx, staffID)
+    require.NoError(t, err)
+    assert.Equal(t, models.GroupMembers{GroupIDs: []int64{engID, platformID}}, members)
+
+    paths := func() map[int64][]int64 {
+        t.Helper()
+        groups, err := s.UserGroups(ctx, userID)
+        require.NoError(t, err)
+        paths := make(map[int64][]int64)
+        for _, g := range groups {
+            paths[g.Group.ID] = g.Path
+        }
+        return paths
+    }
+
+    assert.Equal(t, map[int64][]int64{
+        engID:      {engID},
+        platformID: {engID, platformID},
+        staffID:    {engID, staffID},
+    }, paths(), "the shortest path is taken")
+
+    require.NoError(t, s.RemoveSubgroup(ctx, staffID, engID))
+    require.ErrorIs(t, s.RemoveSubgroup(ctx, staffID, engID), storage.ErrGroupMemberNotFound)
+    assert.Equal(t, []int64{engID, platformID, staffID}, paths()[staffID])
+
+    require.NoError(t, s.DeleteGroup(ctx, platformID))
+    assert.Equal(t, map[int64][]int64{engID: {engID}}, paths())
+    members, err = s.GroupMembers(ctx, staffID)
+    require.NoError(t, err)
+    assert.Empty(t, members.GroupIDs)
+}
+
+func testRelationships(t *testing.T, s Storage) {
+    ctx := context.Background()
+    docType := unique("document")
+    user := models.SubjectRef{Object: models.ObjectRef{Type: "user", ID: unique("user")}}
+    team := models.SubjectRef{Object: models.ObjectRef{Type: "group", ID: unique("group")}, Relation: "member"}
+    readme := models.ObjectRef{Type: docType, ID: "readme"}
+    notes := models.ObjectRef{Type: docType, ID: "notes"}
+
+    touch := func(resource models.ObjectRef, relation string, subject models.SubjectRef) models.RelationshipUpdate {
+        return models.RelationshipUpdate{
+            Operation:    models.RelationshipTouch,
+            Relationship: models.Relationship{Resource: resource, Relation: relation, Subject: subject},
+        }
+    }
+
+    first, err := s.WriteRelationships(ctx, []models.RelationshipUpdate{
+        touch(readme, "editor", user),
+        touch(readme, "viewer", team),
+        touch(notes, "viewer", user),
+    })
+    require.NoError(t, err)
+
+    rels, revision, err := s.Relationships(ctx, []models.RelationshipFilter{{ResourceType: docType}}, first)
+    require.NoError(t, err)
+    assert.GreaterOrEqual(t, revision, first)
+    assert.Len(t, rels, 3)
+
+    rels, _, err = s.Relationships(ctx, []models.RelationshipFilter{{ResourceType: docType, Subject: &user}}, first)
+    require.NoError(t, err)
+    assert.ElementsMatch(t, []models.Relationship{
+        {Resource: readme, Relation: "editor", Subject: user},
+        {Resource: notes, Relation: "viewer", Subject: user},
+    }, rels)
+
+    teamObject := models.SubjectRef{Object: team.Object}
+    rels, _, err = s.Relationships(ctx, []models.RelationshipFilter{{Subject: &teamObject}}, first)
+    require.NoError(t, err)
+    assert.Empty(t, rels, "subjects match with their relation")
+
+    rels, _, err = s.Relationships(ctx, []models.RelationshipFilter{{
+        ResourceType: docType,
+        ResourceID:   readme.ID,
+        Relation:     "viewer",
+    }}, 0)
+    require.NoError(t, err)
+    assert.Equal(t, []models.Relationship{{Resource: readme, Relation: "viewer", Subject: team}}, rels)
+
+    rels, _, err = s.Relationships(ctx, []models.RelationshipFilter{
+        {ResourceType: docType, ResourceID: readme.ID, Relation: "viewer"},
+        {ResourceType: docType, ResourceID: notes.ID},
+        {ResourceType: docType, Subject: &user},
+    }, 0)
+    require.NoError(t, err)
+    assert.Equal(t, []models.Relationship{
+        {Resource: notes, Relation: "viewer", Subject: user},
+        {Resource: readme, Relation: "editor", Subject: user},
+        {Resource: readme, Relation: "viewer", Subject: team},
+    }, rels, "relationships matching any of the filters are read once, in order")
+
+    rels, _, err = s.Relationships(ctx, nil, 0)
+    require.NoError(t, err)
+    assert.Empty(t, rels, "no filters match nothing")
+
+    second, err := s.WriteRelationships(ctx, []models.RelationshipUpdate{
+        touch(readme, "editor", user),
+        {
+            Operation:    models.RelationshipDelete,
+            Relationship: models.Relationship{Resource: notes, Relation: "viewer", Subject: user},
+        },
+        {
+            Operation:    models.RelationshipDelete,
+            Relationship: models.Relationship{Resource: notes, Relation: "owner", Subject: user},
+        },
+    })
+    require.NoError(t, err)
+    assert.Greater(t, second, first)
+
+    rels, _, err = s.Relationships(ctx, []models.RelationshipFilter{{ResourceType: docType}}, second)
+    require.NoError(t, err)
+    assert.ElementsMatch(t, []models.Relationship{
+        {Resource: readme, Relation: "editor", Subject: user},
+        {Resource: readme, Relation: "viewer", Subject: team},
+    }, rels, "touching an existing relationship keeps it once, deleting a missing one does nothing")
+
+    _, err = s.WriteRelationships(ctx, []models.RelationshipUpdate{
+        touch(notes, "owner", user),
+        {Operation: "replace", Relationship: models.Relationship{Resource: notes, Relation: "owner", Subject: user}},
+    })
+    require.Error(t, err)
+    rels, _, err = s.Relationships(ctx, []models.RelationshipFilter{{ResourceType: docType, ResourceID: notes.ID}}, 0)
+    require.NoError(t, err)
+    assert.Empty(t, rels, "failed writes apply no update")
+
+    _, _, err = s.Relationships(ctx, []models.RelationshipFilter{{ResourceType: docType}}, second+1<<40)
+    require.ErrorIs(t, err, storage.ErrRevisionNotFound)
+}
+
+func testDataJobs(t *testing.T, s Storage) {
+    ctx := context.Background()
+
+    id, err := s.SaveDataJob(ctx, models.DataJob{
+        Kind:      models.DataJobExport,
+        UserID:    saveUser(t, s),
+        Actor:     "user:1",
+        Reason:    "request",
+        CreatedAt: time.Now(),
+    })
+    require.NoError(t, err)
+
+    job, err := s.DataJob(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, models.DataJobPending, job.Status)
+    assert.Equal(t, "request", job.Reason)
+    assert.True(t, job.StartedAt.IsZero())
+
+    claimed, ok := claimDataJob(t, s, id)
+    require.True(t, ok)
+    assert.Equal(t, models.DataJobRunning, claimed.Status)
+    assert.False(t, claimed.StartedAt.IsZero())
+    _, ok = claimDataJob(t, s, id)
+    require.False(t, ok, "claimed jobs are leased")
+
+    require.NoError(t, s.RetryDataJob(ctx, id, "unavailable", time.Now().Add(-time.Second)))
+    claimed, ok = claimDataJob(t, s, id)
+    require.True(t, ok, "failed jobs are retried when due")
+    assert.Equal(t, 1, claimed.Attempts)
+    assert.Equal(t, "unavailable", claimed.LastError)
+
+    archive := []byte(`{"user":{"id":1}}`)
+    require.NoError(t, s.CompleteDataJob(ctx, id, archive, time.Now(), time.Now().Add(-time.Second)))
+    job, err = s.DataJob(ctx, id)
+    require.NoError(t, err)
+    assert.Equal(t, models.DataJobSucceeded, job.Status)
+    assert.Equal(t, 2, job.Attempts)
+    assert.Empty(t, job.LastError)
+    assert.JSONEq(t, string(archive), string(job.Archive))
+    assert.False(t, job.FinishedAt.IsZero())
+
+    require.NoError(t, s.RetryDataJob(ctx, id, "", time.Now().Add(-time.Second)))
+    require.NoError(t, s.CompleteDataJob(ctx, id, archive, time.Now(), time.Now().Add(-time.Second)))
+    _, ok = claimDataJob(t, s, id)
+    require.False(t, ok, "finished jobs are not claimed")
+
+    n, err := s.PurgeDataJobArchives(ctx, time.Now())
+    require.NoError(t, err)
+    assert.GreaterOrEqual(t, n, int64(1))
+    job, err = s.DataJob(ctx, id)
+    require.NoError(t, err)
+    assert.Nil(t, job.Archive, "expired archives are purged")
+
+    failedID, err := s.SaveDataJob(ctx, models.DataJob{
+        Kind:      models.DataJobErase,
+        UserID:    saveUser(t, s),
+        Actor:     "user:1",
+        CreatedAt: time.Now(),
+    })
+    require.NoError(t, err)
+    require.NoError(t, s.FailDataJob(ctx, failedID, "broken", time.Now()))
+    job, err = s.DataJob(ctx, failedID)
+    require.NoError(t, err)
+    assert.Equal(t, models.DataJobFailed, job.Status)
+    assert.Equal(t, "broken", job.LastError)
+    _, ok = claimDataJob(t, s, failedID)
+    require.False(t, ok, "failed jobs are not retried")
+
+    _, err = s.DataJob(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrDataJobNotFound)
+}
+
+func testUserData(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    subject := unique("subject")
+
+    require.NoError(t, s.SaveIdentity(ctx, userID, "saml", subject))
+    require.NoError(t, s.SaveSession(ctx, models.Session{
+        ID:         unique("active"),
+        UserID:     userID,
+        IP:         "192.0.2.1",
+        CreatedAt:  time.Now().Add(-time.Minute),
+        LastSeenAt: time.Now(),
+        ExpiresAt:  time.Now().Add(time.Hour),
+    }))
+    revoked := unique("revoked")
+    require.NoError(t, s.SaveSession(ctx, models.Session{
+        ID:         revoked,
+        UserID:     userID,
+        CreatedAt:  time.Now(),
+        LastSeenAt: time.Now(),
+        ExpiresAt:  time.Now().Add(time.Hour),
+    }))
+    require.NoError(t, s.RevokeSession(ctx, userID, revoked))
+    owner := models.Principal{Type: models.PrincipalUser, ID: userID}
+    keyID, err := s.SaveAPIKey(ctx, models.APIKey{
+        Principal: owner,
+        Name:      "ci",
+        Prefix:    "sso_ab",
+        Hash:      []byte(unique("hash")),
+        CreatedAt: time.Now(),
+    })
+    require.NoError(t, err)
+    require.NoError(t, s.RevokeAPIKey(ctx, owner, keyID))
+    require.NoError(t, s.ChangeUserStatus(ctx, models.StatusTransition{
+        UserID:    userID,
+        From:      models.UserStatusActive,
+        To:        models.UserStatusLocked,
+        Reason:    "suspicious logins",
+        Actor:     models.ActorSystem,
+        CreatedAt: time.Now(),
+    }))
+
+    orgID := saveOrganization(t, s, userID)
+
+    data, err := s.UserData(ctx, userID)
+    require.NoError(t, err)
+    assert.Equal(t, userID, data.User.ID)
+    assert.Equal(t, models.UserStatusLocked, data.User.Status)
+
+    require.Len(t, data.Identities, 1)
+    assert.Equal(t, subject, data.Identities[0].Subject)
+    assert.False(t, data.Identities[0].CreatedAt.IsZero())
+
+    require.Len(t, data.Sessions, 2, "revoked sessions are included")
+    assert.Equal(t, "192.0.2.1", data.Sessions[0].IP)
+    assert.True(t, data.Sessions[0].RevokedAt.IsZero())
+    assert.Equal(t, revoked, data.Sessions[1].ID)
+    assert.False(t, data.Sessions[1].RevokedAt.IsZero())
+
+    require.Len(t, data.APIKeys, 1, "revoked keys are included")
+    assert.Equal(t, "ci", data.APIKeys[0].Name)
+    assert.False(t, data.APIKeys[0].RevokedAt.IsZero())
+
+    require.Len(t, data.Transitions, 1)
+    assert.Equal(t, "suspicious logins", data.Transitions[0].Reason)
+
+    require.Len(t, data.Memberships, 1)
+    assert.Equal(t, orgID, data.Memberships[0].Organization.ID)
+    assert.Equal(t, []string{models.OrgRoleAdmin}, data.Memberships[0].Roles)
+
+    _, err = s.UserData(ctx, -1)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testEraseUser(t *testing.T, s Storage) {
+    ctx := context.Background()
+    userID := saveUser(t, s)
+    email := userEmail(t, s, userID)
+    owner := models.Principal{Type: models.PrincipalUser, ID: userID}
+    aggregate := owner.String()
+    subject := unique("subject")
+    sessionID := unique("session")
+    keyHash := []byte(unique("hash"))
+
+    require.NoError(t, s.SaveIdentity(ctx, userID, "saml", subject))
+    require.NoError(t, s.SaveSession(ctx, models.Session{
+        ID:         sessionID,
+        UserID:     userID,
+        CreatedAt:  time.Now(),
+        LastSeenAt: time.Now(),
+        ExpiresAt:  time.Now().Add(time.Hour),
+    }))
+    _, err := s.SaveAPIKey(ctx, models.APIKey{Principal: owner, Name: "ci", Prefix: "sso_ab", Hash: keyHash, CreatedAt: time.Now()})
+    require.NoError(t, err)
+    phone := uniquePhone()
+    _, err = s.SavePhoneCode(ctx, models.PhoneCode{
+        Phone:     phone,
+        Purpose:   models.PhoneCodeVerify,
+        UserID:    userID,
+        Hash:      []byte("code"),
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Minute),
+    })
+    require.NoError(t, err)
+    _, err = s.AppendAuditEvent(ctx, models.AuditEvent{
+        Type:      models.EventLoginSucceeded,
+        Actor:     aggregate,
+        Subject:   aggregate,
+        IP:        "10.0.0.1",
+        UserAgent: "test",
+        Outcome:   models.OutcomeSuccess,
+        Details:   map[string]string{"email": email},
+        CreatedAt: time.Now(),
+    })
+    require.NoError(t, err)
+    // A failed login with the email before it was registered is about no user.
+    failed, err := s.AppendAuditEvent(ctx, models.AuditEvent{
+        Type:      models.EventLoginFailed,
+        IP:        "10.0.0.2",
+        Outcome:   models.OutcomeFailure,
+        Reason:    "user_not_found",
+        Details:   map[string]string{"email": email},
+        CreatedAt: time.Now(),
+    })
+    require.NoError(t, err)
+    assert.Equal(t, "10.0.0.2", failed.IP, "appended events are returned opened")
+    assert.Equal(t, email, failed.Details["email"])
+
+    audited, err := s.AuditEvents(ctx, models.AuditFilter{Subject: aggregate}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, audited, 1)
+    assert.Equal(t, "10.0.0.1", audited[0].IP)
+    assert.Equal(t, "test", audited[0].UserAgent)
+    assert.Equal(t, map[string]string{"email": email}, audited[0].Details)
+    assert.NotEmpty(t, audited[0].Sealed, "personal data is stored sealed")
+    require.NoError(t, audit.Verify(audited))
+
+    registered, ok := claimOutbox(t, s, aggregate)
+    require.True(t, ok)
+    subID, err := s.SaveWebhookSubscription(ctx, models.WebhookSubscription{
+        App:        unique("app"),
+        URL:        "https://example.com/hook",
+        EventTypes: []string{models.DomainEventUserRegistered},
+        Secret:     "secret",
+        CreatedAt:  time.Now(),
+    })
+    require.NoError(t, err)
+    envelope := fmt.Sprintf(`{"id":%d,"type":"user.registered","payload":%s}`, registered.ID, registered.Payload)
+    _, err = s.EnqueueWebhookDeliveries(ctx, registered.ID, registered.Type, []byte(envelope))
+    require.NoError(t, err)
+
+    orgID := saveOrganization(t, s, userID)
+    groupID := saveGroup(t, s)
+    require.NoError(t, s.AddGroupUser(ctx, groupID, userID))
+
+    jobID, err := s.SaveDataJob(ctx, models.DataJob{Kind: models.DataJobExport, UserID: userID, Actor: aggregate, CreatedAt: time.Now()})
+    require.NoError(t, err)
+    require.NoError(t, s.CompleteDataJob(ctx, jobID, []byte(`{"email":"`+email+`"}`), time.Now(), time.Now().Add(time.Hour)))
+
+    invitationHash := []byte(unique("hash"))
+    _, err = s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID:     saveOrganization(t, s, saveUser(t, s)),
+        Email:     strings.ToUpper(email),
+        Hash:      invitationHash,
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+    inviteCode := func(email string) []byte {
+        hash := []byte(unique("hash"))
+        _, err := s.SaveInviteCode(ctx, models.InviteCode{
+            Hash:      hash,
+            Email:     email,
+            Creator:   "user:1",
+            CreatedAt: time.Now(),
+            ExpiresAt: time.Now().Add(time.Hour),
+        })
+        require.NoError(t, err)
+        return hash
+    }
+    codeHash := inviteCode(email)
+    anyoneHash := inviteCode("")
+
+    require.NoError(t, s.EraseUser(ctx, userID))
+
+    _, err = s.UserByID(ctx, userID)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+    _, err = s.User(ctx, email)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+    _, err = s.UserByIdentity(ctx, "saml", subject)
+    require.ErrorIs(t, err, storage.ErrUserNotFound)
+    _, err = s.Session(ctx, sessionID)
+    require.ErrorIs(t, err, storage.ErrSessionNotFound)
+    _, err = s.APIKeyByHash(ctx, keyHash)
+    require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+    _, err = s.LatestPhoneCode(ctx, phone, models.PhoneCodeVerify, 0)
+    require.ErrorIs(t, err, storage.ErrPhoneCodeNotFound)
+    _, err = s.Membership(ctx, orgID, userID)
+    require.ErrorIs(t, err, storage.ErrMemberNotFound)
+    _, err = s.Organization(ctx, orgID)
+    require.NoError(t, err, "organizations outlive their members")
+    _, err = s.OrgInvitationByHash(ctx, invitationHash)
+    require.ErrorIs(t, err, storage.ErrInvitationNotFound, "invitations for the email are deleted")
+    _, err = s.InviteCodeByHash(ctx, codeHash)
+    require.ErrorIs(t, err, storage.ErrInviteCodeNotFound, "invite codes for the email are deleted")
+    _, err = s.InviteCodeByHash(ctx, anyoneHash)
+    require.NoError(t, err, "invite codes for anyone are kept")
+    members, err := s.GroupMembers(ctx, groupID)
+    require.NoError(t, err)
+    assert.Empty(t, members.UserIDs)
+
+    job, err := s.DataJob(ctx, jobID)
+    require.NoError(t, err)
+    assert.Nil(t, job.Archive, "archives of erased users are removed")
+    assert.Equal(t, models.DataJobSucceeded, job.Status, "the job itself is kept")
+
+    redacted := fmt.Sprintf(`{"user_id":%d}`, userID)
+
+    deliveries, err := s.WebhookDeliveries(ctx, subID, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, deliveries, 1)
+    assert.JSONEq(t, fmt.Sprintf(`{"id":%d,"type":"user.registered","payload":%s}`, registered.ID, redacted),
+        string(deliveries[0].Payload), "only the payload of the envelope is reduced")
+
+    require.NoError(t, s.MarkOutboxFailed(ctx, registered.ID, "", time.Now().Add(-time.Second)))
+    events, err := s.ClaimOutboxEvents(ctx, claimLimit, time.Minute)
+    require.NoError(t, err)
+    var types []string
+    for _, event := range events {
+        if event.AggregateID == aggregate {
+            types = append(types, event.Type)
+            assert.JSONEq(t, redacted, string(event.Payload))
+        }
+    }
+    assert.Equal(t, []string{models.DomainEventUserRegistered, models.DomainEventUserErased}, types)
+
+    audited, err = s.AuditEvents(ctx, models.AuditFilter{Subject: aggregate}, 0, 10)
+    require.NoError(t, err)
+    require.Len(t, audited, 1, "the audit log is kept")
+    assert.Empty(t, audited[0].IP, "personal data can no longer be opened")
+    assert.Empty(t, audited[0].UserAgent)
+    assert.Empty(t, audited[0].Details)
+    require.NoError(t, audit.Verify(audited))
+
+    audited, err = s.AuditEvents(ctx, models.AuditFilter{Type: models.EventLoginFailed}, failed.ID+1, 1)
+    require.NoError(t, err)
+    require.Len(t, audited, 1)
+    assert.Equal(t, failed.ID, audited[0].ID)
+    assert.Empty(t, audited[0].IP, "identifiers of events about no user are erased too")
+    assert.Empty(t, audited[0].Details)
+    require.NoError(t, audit.Verify(audited))
+
+    require.ErrorIs(t, s.EraseUser(ctx, userID), storage.ErrUserNotFound)
+}
+
+func unique(prefix string) string {
+    return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), counter.Add(1))
+}
+
+// uniquePhone returns an E.164 phone number no other test uses.
+func uniquePhone() string {
+    return fmt.Sprintf("+1%09d%04d", time.Now().UnixNano()%1e9, counter.Add(1)%1e4)
+}
+
+func saveUser(t *testing.T, s Storage) int64 {
+    t.Helper()
+
+    id, err := s.SaveUser(context.Background(), unique("user")+"@example.com", []byte("hash"))
+    require.NoError(t, err)
+
+    return id
+}
+
+// saveOrganization creates an organization with the user as its admin.
+func saveOrganization(t *testing.T, s Storage, ownerID int64) int64 {
+    t.Helper()
+
+    id, err := s.CreateOrganization(context.Background(),
+        models.Organization{Slug: unique("org"), Name: "Org", CreatedAt: time.Now()},
+        models.Membership{UserID: ownerID, Roles: []string{models.OrgRoleAdmin}, CreatedAt: time.Now()},
+    )
+    require.NoError(t, err)
+
+    return id
+}
+
+// saveGroup creates a group that grants no roles.
+func saveGroup(t *testing.T, s Storage) int64 {
+    t.Helper()
+
+    id, err := s.CreateGroup(context.Background(), models.Group{Name: unique("group"), CreatedAt: time.Now()})
+    require.NoError(t, err)
+
+    return id
+}
+
+// inviteMember makes the user a member of the organization with the role through an invitation.
+func inviteMember(t *testing.T, s Storage, orgID, userID int64, role string) {
+    t.Helper()
+
+    ctx := context.Background()
+    id, err := s.SaveOrgInvitation(ctx, models.OrgInvitation{
+        OrgID:     orgID,
+        Email:     userEmail(t, s, userID),
+        Roles:     []string{role},
+        Hash:      []byte(unique("hash")),
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Hour),
+    })
+    require.NoError(t, err)
+    _, err = s.AcceptOrgInvitation(ctx, id, userID, time.Now())
+    require.NoError(t, err)
+}
+
+func userEmail(t *testing.T, s Storage, id int64) string {
+    t.Helper()
+
+    user, err := s.UserByID(context.Background(), id)
+    require.NoError(t, err)
+
+    return user.Email
+}
+
+// claimOutbox claims due outbox events and returns the one about the aggregate, if any.
+func claimOutbox(t *testing.T, s Storage, aggregate string) (models.OutboxEvent, bool) {
+    t.Helper()
+
+    events, err := s.ClaimOutboxEvents(context.Background(), claimLimit, time.Minute)
+    require.NoError(t, err)
+
+    for _, event := range events {
+        if event.AggregateID == aggregate {
+            return event, true
+        }
+    }
+
+    return models.OutboxEvent{}, false
+}
+
+// claimDataJob claims due data jobs and returns the one with the id, if any.
+func claimDataJob(t *testing.T, s Storage, id int64) (models.DataJob, bool) {
+    t.Helper()
+
+    jobs, err := s.ClaimDataJobs(context.Background(), claimLimit, time.Minute)
+    require.NoError(t, err)
+
+    for _, job := range jobs {
+        if job.ID == id {
+            return job, true
+        }
+    }
+
+    return models.DataJob{}, false
+}
+
+// claimWebhookDelivery claims due deliveries and returns the one with the id, if any.
+func claimWebhookDelivery(t *testing.T, s Storage, id int64) (models.WebhookDelivery, bool) {
+    t.Helper()
+
+    deliveries, err := s.ClaimWebhookDeliveries(context.Background(), claimLimit, time.Minute)
+    require.NoError(t, err)
+
+    for _, d := range deliveries {
+        if d.ID == id {
+            return d, true
+        }
+    }
+
+    return models.WebhookDelivery{}, false
+}
@@ -0,0 +1,21 @@
+package storage
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose reads and writes of users, and of their sessions, status
+// transitions and API keys, are limited to the members of the organization. Users outside of it
+// are reported as missing. A zero orgID leaves the context unlimited.
+func WithTenant(ctx context.Context, orgID int64) context.Context {
+    if orgID == 0 {
+        return ctx
+    }
+    return context.WithValue(ctx, tenantKey{}, orgID)
+}
+
+// Tenant returns the organization the users of the context are limited to, or zero.
+func Tenant(ctx context.Context) int64 {
+    orgID, _ := ctx.Value(tenantKey{}).(int64)
+    return orgID
+}
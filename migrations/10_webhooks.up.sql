@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions
+(
+    id                   BIGSERIAL   PRIMARY KEY,
+    app                  TEXT        NOT NULL,
+    url                  TEXT        NOT NULL,
+    event_types          TEXT[]      NOT NULL,
+    secret               TEXT        NOT NULL,
+    consecutive_failures INTEGER     NOT NULL DEFAULT 0,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT now(),
+    disabled_at          TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_app ON webhook_subscriptions (app);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_event_types ON webhook_subscriptions USING GIN (event_types);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id               BIGSERIAL   PRIMARY KEY,
+    subscription_id  BIGINT      NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id         BIGINT      NOT NULL,
+    event_type       TEXT        NOT NULL,
+    payload          JSONB       NOT NULL,
+    status           TEXT        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts         INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_status_code INTEGER     NOT NULL DEFAULT 0,
+    last_error       TEXT        NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at     TIMESTAMPTZ,
+    -- Replays are new deliveries of the same event.
+    replay_of        BIGINT      REFERENCES webhook_deliveries (id) ON DELETE SET NULL
+);
+-- An event is fanned out to a subscription once even if the outbox delivers it again.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_event
+    ON webhook_deliveries (subscription_id, event_id) WHERE replay_of IS NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending
+    ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts
+(
+    id           BIGSERIAL   PRIMARY KEY,
+    delivery_id  BIGINT      NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    status_code  INTEGER     NOT NULL DEFAULT 0,
+    error        TEXT        NOT NULL DEFAULT '',
+    duration_ms  INTEGER     NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts (delivery_id, id);
@@ -0,0 +1,35 @@
+-- Emails are unique regardless of case. Accounts whose emails differ only in case can't be
+-- told apart anymore, so the migration stops with a report of them; merge or rename them
+-- and run it again.
+DO $$
+DECLARE
+    report TEXT;
+BEGIN
+    SELECT string_agg(format('%s: user ids %s', email, ids), E'\n' ORDER BY email)
+    INTO report
+    FROM (
+        SELECT lower(email) AS email, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY lower(email)
+        HAVING count(*) > 1
+    ) duplicates;
+
+    IF report IS NOT NULL THEN
+        RAISE EXCEPTION E'users with emails that differ only in case:\n%', report
+            USING HINT = 'merge or rename the accounts and run the migration again';
+    END IF;
+END
+$$;
+
+-- Domains are stored lowercased, as the auth service normalizes them. IDN domains are not
+-- converted to punycode here; the auth service looks users up by the address as given when
+-- the normalized one isn't found, so they keep logging in with the address they registered.
+UPDATE users
+SET email = substring(email FROM '^(.*)@') || '@' || lower(substring(email FROM '@([^@]*)$'))
+WHERE substring(email FROM '@([^@]*)$') <> lower(substring(email FROM '@([^@]*)$'));
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+DROP INDEX IF EXISTS idx_email;
+
+-- text_pattern_ops serves both lookups and the prefix filter of ListUsers.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email) text_pattern_ops);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS users_phone_key;
+DROP INDEX IF EXISTS users_username_lower_key;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_identifier_check;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
+ALTER TABLE users DROP COLUMN IF EXISTS username;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
@@ -0,0 +1,10 @@
+-- Users may log in by username or phone number, and may register without an email.
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone TEXT;
+
+ALTER TABLE users ADD CONSTRAINT users_identifier_check
+    CHECK (email IS NOT NULL OR username IS NOT NULL OR phone IS NOT NULL);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users (lower(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_key ON users (phone);
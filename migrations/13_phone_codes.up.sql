@@ -0,0 +1,16 @@
+-- Users prove they own their phone number with one-time codes sent by SMS, which also log them in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_verified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS phone_codes
+(
+    id          BIGSERIAL   PRIMARY KEY,
+    phone       TEXT        NOT NULL,
+    purpose     TEXT        NOT NULL CHECK (purpose IN ('verify', 'login')),
+    user_id     INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash   BYTEA       NOT NULL,
+    attempts    INTEGER     NOT NULL DEFAULT 0,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at  TIMESTAMPTZ NOT NULL,
+    consumed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_phone_codes_phone ON phone_codes (phone, created_at);
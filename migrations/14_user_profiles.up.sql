@@ -0,0 +1,9 @@
+-- Profile data shared by every app, and custom attributes in namespaces of the apps that own them.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS display_name TEXT  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS given_name   TEXT  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS family_name  TEXT  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS locale       TEXT  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS timezone     TEXT  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS avatar_url   TEXT  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS attributes   JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,23 @@
+-- Jobs keep no reference to the user row, which erasure deletes.
+CREATE TABLE IF NOT EXISTS data_jobs
+(
+    id              BIGSERIAL   PRIMARY KEY,
+    kind            TEXT        NOT NULL CHECK (kind IN ('export', 'erase')),
+    user_id         BIGINT      NOT NULL,
+    status          TEXT        NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    actor           TEXT        NOT NULL,
+    reason          TEXT        NOT NULL DEFAULT '',
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT        NOT NULL DEFAULT '',
+    archive         JSONB,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at      TIMESTAMPTZ,
+    finished_at     TIMESTAMPTZ,
+    expires_at      TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_data_jobs_pending
+    ON data_jobs (next_attempt_at, id) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_data_jobs_user_id ON data_jobs (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_data_jobs_expires_at ON data_jobs (expires_at) WHERE archive IS NOT NULL;
@@ -0,0 +1,24 @@
+-- Encrypted values can't be decrypted here. Run the service with pii.decrypt set to true
+-- until the re-encrypter has decrypted them, then roll back.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users WHERE email_enc IS NOT NULL OR phone_enc IS NOT NULL) THEN
+        RAISE EXCEPTION 'users have encrypted emails or phone numbers'
+            USING HINT = 'decrypt them by running the service with pii.decrypt set to true';
+    END IF;
+END
+$$;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_identifier_check;
+ALTER TABLE users ADD CONSTRAINT users_identifier_check
+    CHECK (email IS NOT NULL OR username IS NOT NULL OR phone IS NOT NULL);
+
+DROP INDEX IF EXISTS users_phone_idx_key;
+DROP INDEX IF EXISTS users_email_idx_key;
+
+ALTER TABLE users DROP COLUMN IF EXISTS phone_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_enc;
+ALTER TABLE users DROP COLUMN IF EXISTS email_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS email_enc;
+
+DROP TABLE IF EXISTS data_keys;
@@ -0,0 +1,26 @@
+-- Data keys encrypt personal data with AES-256-GCM. They are stored wrapped by a master key
+-- of the version kept next to them, so that master keys can be rotated.
+CREATE TABLE IF NOT EXISTS data_keys
+(
+    id             BIGSERIAL   PRIMARY KEY,
+    master_version INTEGER     NOT NULL,
+    wrapped_key    BYTEA       NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_data_keys_master_version ON data_keys (master_version, id);
+
+-- Encrypted emails and phone numbers are kept in the _enc columns, which start with the id of
+-- their data key, and their plaintext columns are NULL. The _idx columns hold blind indexes,
+-- HMACs of the normalized values, which serve lookups and keep the values unique.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_enc BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_idx BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_enc BYTEA;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_idx BYTEA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx_key ON users (email_idx);
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_idx_key ON users (phone_idx);
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_identifier_check;
+ALTER TABLE users ADD CONSTRAINT users_identifier_check
+    CHECK (email IS NOT NULL OR email_enc IS NOT NULL OR username IS NOT NULL
+        OR phone IS NOT NULL OR phone_enc IS NOT NULL);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS relationship_revision;
+DROP TABLE IF EXISTS relationships;
@@ -0,0 +1,23 @@
+-- Relationships give subjects relations on resources, objects of the types of the authorization schema.
+-- Subjects with a relation stand for every subject having the relation on the subject object.
+CREATE TABLE IF NOT EXISTS relationships
+(
+    resource_type    TEXT NOT NULL,
+    resource_id      TEXT NOT NULL,
+    relation         TEXT NOT NULL,
+    subject_type     TEXT NOT NULL,
+    subject_id       TEXT NOT NULL,
+    subject_relation TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (resource_type, resource_id, relation, subject_type, subject_id, subject_relation)
+);
+CREATE INDEX IF NOT EXISTS idx_relationships_subject
+    ON relationships (subject_type, subject_id, subject_relation, resource_type, relation);
+
+-- The revision counts the writes of relationships. Writers lock its only row, so revisions
+-- are committed in order and a read seeing a revision sees every write up to it.
+CREATE TABLE IF NOT EXISTS relationship_revision
+(
+    id       BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    revision BIGINT  NOT NULL
+);
+INSERT INTO relationship_revision(revision) VALUES(0) ON CONFLICT DO NOTHING;
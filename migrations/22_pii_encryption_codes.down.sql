@@ -0,0 +1,24 @@
+-- Encrypted values can't be decrypted here. Run the service with pii.decrypt set to true
+-- until the re-encrypter has decrypted them, then roll back.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM phone_codes WHERE phone_enc IS NOT NULL)
+        OR EXISTS (SELECT 1 FROM org_invitations WHERE email_enc IS NOT NULL)
+        OR EXISTS (SELECT 1 FROM invite_codes WHERE email_enc IS NOT NULL) THEN
+        RAISE EXCEPTION 'codes or invitations have encrypted phone numbers or emails'
+            USING HINT = 'decrypt them by running the service with pii.decrypt set to true';
+    END IF;
+END
+$$;
+
+ALTER TABLE invite_codes DROP COLUMN IF EXISTS email_enc;
+UPDATE invite_codes SET email = '' WHERE email IS NULL;
+ALTER TABLE invite_codes ALTER COLUMN email SET NOT NULL;
+
+ALTER TABLE org_invitations DROP COLUMN IF EXISTS email_enc;
+ALTER TABLE org_invitations ALTER COLUMN email SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_phone_codes_phone_idx;
+ALTER TABLE phone_codes DROP COLUMN IF EXISTS phone_idx;
+ALTER TABLE phone_codes DROP COLUMN IF EXISTS phone_enc;
+ALTER TABLE phone_codes ALTER COLUMN phone SET NOT NULL;
@@ -0,0 +1,14 @@
+-- Phone numbers of one-time codes and emails of invitations and invite codes are encrypted like
+-- the personal data of users: the _enc columns hold the encrypted values and their plaintext
+-- columns are NULL. Codes are looked up by phone number, by the blind index in phone_idx.
+ALTER TABLE phone_codes ALTER COLUMN phone DROP NOT NULL;
+ALTER TABLE phone_codes ADD COLUMN IF NOT EXISTS phone_enc BYTEA;
+ALTER TABLE phone_codes ADD COLUMN IF NOT EXISTS phone_idx BYTEA;
+CREATE INDEX IF NOT EXISTS idx_phone_codes_phone_idx ON phone_codes (phone_idx, created_at);
+
+ALTER TABLE org_invitations ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE org_invitations ADD COLUMN IF NOT EXISTS email_enc BYTEA;
+
+-- Invite codes for anyone have no email: empty before this migration, NULL after it.
+ALTER TABLE invite_codes ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS email_enc BYTEA;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_invite_codes_email_idx;
+ALTER TABLE invite_codes DROP COLUMN IF EXISTS email_idx;
+
+DROP INDEX IF EXISTS idx_org_invitations_email_idx;
+ALTER TABLE org_invitations DROP COLUMN IF EXISTS email_idx;
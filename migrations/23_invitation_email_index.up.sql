@@ -0,0 +1,7 @@
+-- Encrypted emails of invitations and invite codes get a blind index, which erasure of a user
+-- matches them by. The re-encrypter fills it in for the emails encrypted before.
+ALTER TABLE org_invitations ADD COLUMN IF NOT EXISTS email_idx BYTEA;
+CREATE INDEX IF NOT EXISTS idx_org_invitations_email_idx ON org_invitations (email_idx);
+
+ALTER TABLE invite_codes ADD COLUMN IF NOT EXISTS email_idx BYTEA;
+CREATE INDEX IF NOT EXISTS idx_invite_codes_email_idx ON invite_codes (email_idx);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    id         SERIAL      PRIMARY KEY,
+    user_id    INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider   TEXT        NOT NULL,
+    subject    TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
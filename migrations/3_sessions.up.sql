@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           TEXT        PRIMARY KEY,
+    user_id      INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT        NOT NULL DEFAULT '',
+    ip           TEXT        NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
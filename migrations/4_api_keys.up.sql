@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           SERIAL      PRIMARY KEY,
+    user_id      INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT        NOT NULL,
+    prefix       TEXT        NOT NULL,
+    key_hash     BYTEA       NOT NULL UNIQUE,
+    scopes       TEXT[]      NOT NULL DEFAULT '{}',
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
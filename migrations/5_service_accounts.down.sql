@@ -0,0 +1,6 @@
+DELETE FROM api_keys WHERE service_account_id IS NOT NULL;
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_owner_check;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS service_account_id;
+ALTER TABLE api_keys ALTER COLUMN user_id SET NOT NULL;
+DROP TABLE IF EXISTS service_accounts;
+ALTER TABLE users DROP COLUMN IF EXISTS roles;
@@ -0,0 +1,20 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS service_accounts
+(
+    id          SERIAL      PRIMARY KEY,
+    name        TEXT        NOT NULL,
+    owner_kind  TEXT        NOT NULL CHECK (owner_kind IN ('app', 'team')),
+    owner       TEXT        NOT NULL,
+    client_id   TEXT        NOT NULL UNIQUE,
+    secret_hash BYTEA       NOT NULL,
+    roles       TEXT[]      NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    disabled_at TIMESTAMPTZ,
+    UNIQUE (owner_kind, owner, name)
+);
+
+ALTER TABLE api_keys ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS service_account_id INTEGER REFERENCES service_accounts (id) ON DELETE CASCADE;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_owner_check CHECK ((user_id IS NULL) <> (service_account_id IS NULL));
+CREATE INDEX IF NOT EXISTS idx_api_keys_service_account_id ON api_keys (service_account_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_roles;
+DROP INDEX IF EXISTS idx_users_status;
+DROP INDEX IF EXISTS idx_users_email_pattern;
+ALTER TABLE users DROP COLUMN IF EXISTS created_at;
+ALTER TABLE users DROP COLUMN IF EXISTS status;
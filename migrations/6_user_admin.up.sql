@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Email prefix search uses LIKE, which needs an index with pattern operators.
+CREATE INDEX IF NOT EXISTS idx_users_email_pattern ON users (email text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_status ON users (status, id);
+CREATE INDEX IF NOT EXISTS idx_users_roles ON users USING GIN (roles);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS user_status_transitions;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+UPDATE users SET status = 'disabled' WHERE status <> 'active';
+ALTER TABLE users ADD CONSTRAINT users_status_check CHECK (status IN ('active', 'disabled'));
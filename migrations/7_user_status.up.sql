@@ -0,0 +1,19 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+UPDATE users SET status = 'suspended' WHERE status = 'disabled';
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deleted'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS user_status_transitions
+(
+    id          SERIAL      PRIMARY KEY,
+    user_id     INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    from_status TEXT        NOT NULL,
+    to_status   TEXT        NOT NULL,
+    reason      TEXT        NOT NULL DEFAULT '',
+    until       TIMESTAMPTZ,
+    actor       TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_user_status_transitions_user_id ON user_status_transitions (user_id, id);
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id         BIGSERIAL   PRIMARY KEY,
+    type       TEXT        NOT NULL,
+    actor      TEXT        NOT NULL DEFAULT '',
+    subject    TEXT        NOT NULL DEFAULT '',
+    ip         TEXT        NOT NULL DEFAULT '',
+    user_agent TEXT        NOT NULL DEFAULT '',
+    outcome    TEXT        NOT NULL,
+    reason     TEXT        NOT NULL DEFAULT '',
+    details    JSONB       NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL,
+    prev_hash  BYTEA       NOT NULL,
+    hash       BYTEA       NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_type ON audit_log (type, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_subject ON audit_log (subject, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+-- The audit log is append-only.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
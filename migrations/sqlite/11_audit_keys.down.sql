@@ -0,0 +1,2 @@
+ALTER TABLE audit_log DROP COLUMN sealed;
+DROP TABLE IF EXISTS audit_keys;
@@ -0,0 +1,11 @@
+-- Audit keys seal the personal data in the audit log: the client address, user agent and identifiers
+-- of events. Keys are referenced by the user they belong to, or by a hash of an identifier in events
+-- about no user. Erasing a user deletes its keys, which leaves its data in the log unreadable
+-- without changing the hashes of the entries. Entries appended before this migration are not sealed.
+CREATE TABLE IF NOT EXISTS audit_keys
+(
+    ref TEXT PRIMARY KEY,
+    key BLOB NOT NULL
+);
+
+ALTER TABLE audit_log ADD COLUMN sealed TEXT NOT NULL DEFAULT '{}';
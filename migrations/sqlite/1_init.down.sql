@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS service_accounts;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS user_status_transitions;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS users;
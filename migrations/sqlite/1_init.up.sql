@@ -0,0 +1,180 @@
+-- Times are stored as microseconds since the epoch and string lists as JSON arrays.
+CREATE TABLE IF NOT EXISTS users
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    email           TEXT    NOT NULL UNIQUE,
+    pass_hash       BLOB    NOT NULL,
+    roles           TEXT    NOT NULL DEFAULT '[]',
+    status          TEXT    NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deleted')),
+    status_reason   TEXT    NOT NULL DEFAULT '',
+    suspended_until INTEGER,
+    created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_users_status ON users (status, id);
+
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider   TEXT    NOT NULL,
+    subject    TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+CREATE TABLE IF NOT EXISTS user_status_transitions
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    from_status TEXT    NOT NULL,
+    to_status   TEXT    NOT NULL,
+    reason      TEXT    NOT NULL DEFAULT '',
+    until       INTEGER,
+    actor       TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_user_status_transitions_user_id ON user_status_transitions (user_id, id);
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id           TEXT    PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT    NOT NULL DEFAULT '',
+    ip           TEXT    NOT NULL DEFAULT '',
+    created_at   INTEGER NOT NULL,
+    last_seen_at INTEGER NOT NULL,
+    expires_at   INTEGER NOT NULL,
+    revoked_at   INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS service_accounts
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        TEXT    NOT NULL,
+    owner_kind  TEXT    NOT NULL CHECK (owner_kind IN ('app', 'team')),
+    owner       TEXT    NOT NULL,
+    client_id   TEXT    NOT NULL UNIQUE,
+    secret_hash BLOB    NOT NULL,
+    roles       TEXT    NOT NULL DEFAULT '[]',
+    created_at  INTEGER NOT NULL,
+    disabled_at INTEGER,
+    UNIQUE (owner_kind, owner, name)
+);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id            INTEGER REFERENCES users (id) ON DELETE CASCADE,
+    service_account_id INTEGER REFERENCES service_accounts (id) ON DELETE CASCADE,
+    name               TEXT    NOT NULL,
+    prefix             TEXT    NOT NULL,
+    key_hash           BLOB    NOT NULL UNIQUE,
+    scopes             TEXT    NOT NULL DEFAULT '[]',
+    expires_at         INTEGER,
+    last_used_at       INTEGER,
+    created_at         INTEGER NOT NULL,
+    revoked_at         INTEGER,
+    CHECK ((user_id IS NULL) <> (service_account_id IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_service_account_id ON api_keys (service_account_id);
+
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    type       TEXT    NOT NULL,
+    actor      TEXT    NOT NULL DEFAULT '',
+    subject    TEXT    NOT NULL DEFAULT '',
+    ip         TEXT    NOT NULL DEFAULT '',
+    user_agent TEXT    NOT NULL DEFAULT '',
+    outcome    TEXT    NOT NULL,
+    reason     TEXT    NOT NULL DEFAULT '',
+    details    TEXT    NOT NULL DEFAULT '{}',
+    created_at INTEGER NOT NULL,
+    prev_hash  BLOB    NOT NULL,
+    hash       BLOB    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_type ON audit_log (type, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_subject ON audit_log (subject, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+
+-- The audit log is append-only.
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+    BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete
+    BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    type            TEXT    NOT NULL,
+    aggregate_id    TEXT    NOT NULL,
+    payload         BLOB    NOT NULL,
+    status          TEXT    NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_error      TEXT    NOT NULL DEFAULT '',
+    created_at      INTEGER NOT NULL,
+    delivered_at    INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_dead ON outbox (id) WHERE status = 'dead';
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions
+(
+    id                   INTEGER PRIMARY KEY AUTOINCREMENT,
+    app                  TEXT    NOT NULL,
+    url                  TEXT    NOT NULL,
+    event_types          TEXT    NOT NULL,
+    secret               TEXT    NOT NULL,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    created_at           INTEGER NOT NULL,
+    disabled_at          INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_app ON webhook_subscriptions (app);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id  INTEGER NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id         INTEGER NOT NULL,
+    event_type       TEXT    NOT NULL,
+    payload          BLOB    NOT NULL,
+    status           TEXT    NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  INTEGER NOT NULL,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT    NOT NULL DEFAULT '',
+    created_at       INTEGER NOT NULL,
+    delivered_at     INTEGER,
+    -- Replays are new deliveries of the same event.
+    replay_of        INTEGER REFERENCES webhook_deliveries (id) ON DELETE SET NULL
+);
+-- An event is fanned out to a subscription once even if the outbox delivers it again.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_event
+    ON webhook_deliveries (subscription_id, event_id) WHERE replay_of IS NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending
+    ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    delivery_id  INTEGER NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    status_code  INTEGER NOT NULL DEFAULT 0,
+    error        TEXT    NOT NULL DEFAULT '',
+    duration_ms  INTEGER NOT NULL,
+    attempted_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts (delivery_id, id);
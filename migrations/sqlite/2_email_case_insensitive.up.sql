@@ -0,0 +1,9 @@
+-- Emails are unique regardless of case. NOCASE folds ASCII letters only. Creating the index
+-- fails if emails of existing accounts differ only in case; list them with
+--   SELECT lower(email), group_concat(id) FROM users GROUP BY lower(email) HAVING count(*) > 1;
+-- then merge or rename the accounts and run the migration again.
+UPDATE users
+SET email = substr(email, 1, instr(email, '@')) || lower(substr(email, instr(email, '@') + 1))
+WHERE instr(email, '@') > 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_nocase ON users (email COLLATE NOCASE);
@@ -0,0 +1,22 @@
+-- Fails if users without an email registered since.
+CREATE TABLE users_old
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    email           TEXT    NOT NULL UNIQUE,
+    pass_hash       BLOB    NOT NULL,
+    roles           TEXT    NOT NULL DEFAULT '[]',
+    status          TEXT    NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deleted')),
+    status_reason   TEXT    NOT NULL DEFAULT '',
+    suspended_until INTEGER,
+    created_at      INTEGER NOT NULL
+);
+
+INSERT INTO users_old(id, email, pass_hash, roles, status, status_reason, suspended_until, created_at)
+SELECT id, email, pass_hash, roles, status, status_reason, suspended_until, created_at FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_status ON users (status, id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_nocase ON users (email COLLATE NOCASE);
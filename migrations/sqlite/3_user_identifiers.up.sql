@@ -0,0 +1,30 @@
+-- Users may log in by username or phone number, and may register without an email.
+-- SQLite can't drop the NOT NULL of the email, so the table is rebuilt. Foreign keys
+-- must be off while it runs, as they are on connections of the migrator, or dropping
+-- the old table deletes the rows referencing it.
+CREATE TABLE users_new
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    email           TEXT,
+    username        TEXT,
+    phone           TEXT,
+    pass_hash       BLOB    NOT NULL,
+    roles           TEXT    NOT NULL DEFAULT '[]',
+    status          TEXT    NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deleted')),
+    status_reason   TEXT    NOT NULL DEFAULT '',
+    suspended_until INTEGER,
+    created_at      INTEGER NOT NULL,
+    CHECK (email IS NOT NULL OR username IS NOT NULL OR phone IS NOT NULL)
+);
+
+INSERT INTO users_new(id, email, pass_hash, roles, status, status_reason, suspended_until, created_at)
+SELECT id, email, pass_hash, roles, status, status_reason, suspended_until, created_at FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_status ON users (status, id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_nocase ON users (email COLLATE NOCASE);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_nocase ON users (username COLLATE NOCASE);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone ON users (phone);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS phone_codes;
+ALTER TABLE users DROP COLUMN phone_verified_at;
@@ -0,0 +1,7 @@
+ALTER TABLE users DROP COLUMN attributes;
+ALTER TABLE users DROP COLUMN avatar_url;
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE users DROP COLUMN locale;
+ALTER TABLE users DROP COLUMN family_name;
+ALTER TABLE users DROP COLUMN given_name;
+ALTER TABLE users DROP COLUMN display_name;
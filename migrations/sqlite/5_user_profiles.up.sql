@@ -0,0 +1,8 @@
+-- Profile data shared by every app, and custom attributes in namespaces of the apps that own them.
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN given_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN family_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}';
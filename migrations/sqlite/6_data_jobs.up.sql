@@ -0,0 +1,23 @@
+-- Data-subject requests run in the background. Jobs keep no reference to the user row,
+-- which erasure deletes.
+CREATE TABLE IF NOT EXISTS data_jobs
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind            TEXT    NOT NULL CHECK (kind IN ('export', 'erase')),
+    user_id         INTEGER NOT NULL,
+    status          TEXT    NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    actor           TEXT    NOT NULL,
+    reason          TEXT    NOT NULL DEFAULT '',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_error      TEXT    NOT NULL DEFAULT '',
+    archive         BLOB,
+    created_at      INTEGER NOT NULL,
+    started_at      INTEGER,
+    finished_at     INTEGER,
+    expires_at      INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_data_jobs_pending
+    ON data_jobs (next_attempt_at, id) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_data_jobs_user_id ON data_jobs (user_id, id);
@@ -0,0 +1,35 @@
+-- Organizations are tenants. Users are their members with roles that apply within the organization,
+-- and join them by accepting invitations sent to their email.
+CREATE TABLE IF NOT EXISTS organizations
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    slug       TEXT    NOT NULL UNIQUE,
+    name       TEXT    NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS org_members
+(
+    org_id     INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    roles      TEXT    NOT NULL DEFAULT '[]',
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id      INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email       TEXT    NOT NULL,
+    roles       TEXT    NOT NULL DEFAULT '[]',
+    token_hash  BLOB    NOT NULL UNIQUE,
+    inviter     TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL,
+    expires_at  INTEGER NOT NULL,
+    accepted_at INTEGER,
+    accepted_by INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    revoked_at  INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_org_invitations_org_id ON org_invitations (org_id, id);
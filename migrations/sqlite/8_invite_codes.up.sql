@@ -0,0 +1,17 @@
+-- Invite codes let their holders register while registration is restricted. Users registered
+-- with a code get its roles and join its organization, if any.
+CREATE TABLE IF NOT EXISTS invite_codes
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    code_hash  BLOB    NOT NULL UNIQUE,
+    email      TEXT    NOT NULL DEFAULT '',
+    roles      TEXT    NOT NULL DEFAULT '[]',
+    org_id     INTEGER REFERENCES organizations (id) ON DELETE CASCADE,
+    org_roles  TEXT    NOT NULL DEFAULT '[]',
+    creator    TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER,
+    used_by    INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    revoked_at INTEGER
+);
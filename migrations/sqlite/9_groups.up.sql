@@ -0,0 +1,27 @@
+-- Groups contain users and other groups and grant their roles to all of their members,
+-- members of nested groups included. Nesting never forms cycles.
+CREATE TABLE IF NOT EXISTS groups
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT '',
+    roles       TEXT    NOT NULL DEFAULT '[]',
+    created_at  INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS group_users
+(
+    group_id INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    user_id  INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_group_users_user_id ON group_users (user_id);
+
+CREATE TABLE IF NOT EXISTS group_subgroups
+(
+    group_id    INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    subgroup_id INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, subgroup_id),
+    CHECK (group_id <> subgroup_id)
+);
+CREATE INDEX IF NOT EXISTS idx_group_subgroups_subgroup_id ON group_subgroups (subgroup_id);
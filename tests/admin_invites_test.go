@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmin_InviteCodesRequirePermission(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    _, err = st.AdminClient.CreateInviteCode(ctx, &ssov1.CreateInviteCodeRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    _, err = st.AdminClient.CreateInviteCode(withToken(ctx, token), &ssov1.CreateInviteCodeRequest{
+        Roles: []string{"admin"},
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users don't invite themselves into roles")
+
+    _, err = st.AdminClient.RevokeInviteCode(withToken(ctx, token), &ssov1.RevokeInviteCodeRequest{Id: 1})
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestRegister_InvalidInviteCode(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:      gofakeit.Email(),
+        Password:   randomFakePassword(),
+        InviteCode: "ssoreg_unknown",
+    })
+    require.Equal(t, codes.InvalidArgument, status.Code(err), "unknown codes are rejected even when registration is open")
+}
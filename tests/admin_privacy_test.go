@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmin_DataJobsRequirePermission(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    _, err = st.AdminClient.ExportUserData(ctx, &ssov1.ExportUserDataRequest{UserId: respReg.GetUserId()})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    _, err = st.AdminClient.ExportUserData(withToken(ctx, token), &ssov1.ExportUserDataRequest{
+        UserId: respReg.GetUserId(),
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users request their data through admins")
+
+    _, err = st.AdminClient.EraseUser(withToken(ctx, token), &ssov1.EraseUserRequest{
+        UserId: respReg.GetUserId(),
+        Reason: "test",
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.AdminClient.GetDataJob(withToken(ctx, token), &ssov1.GetDataJobRequest{JobId: 1})
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
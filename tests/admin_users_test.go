@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmin_RequirePermission(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    _, err = st.AdminClient.ListUsers(ctx, &ssov1.ListUsersRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    _, err = st.AdminClient.ListUsers(withToken(ctx, token), &ssov1.ListUsersRequest{})
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.AdminClient.SetUserStatus(withToken(ctx, token), &ssov1.SetUserStatusRequest{
+        UserId: respReg.GetUserId(),
+        Status: "suspended",
+        Reason: "test",
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.AdminClient.QueryAuditLog(withToken(ctx, token), &ssov1.QueryAuditLogRequest{})
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIKeys_CreateUseRevoke(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    respCreate, err := st.AuthClient.CreateAPIKey(withToken(ctx, token), &ssov1.CreateAPIKeyRequest{
+        Name:   "ci",
+        Scopes: []string{"api_keys:read"},
+    })
+    require.NoError(t, err)
+
+    key := respCreate.GetKey()
+    require.True(t, strings.HasPrefix(key, "sso_"))
+    assert.True(t, strings.HasPrefix(key, respCreate.GetApiKey().GetPrefix()))
+
+    respList, err := st.AuthClient.ListAPIKeys(withToken(ctx, key), &ssov1.ListAPIKeysRequest{})
+    require.NoError(t, err)
+    require.Len(t, respList.GetApiKeys(), 1)
+    assert.Equal(t, "ci", respList.GetApiKeys()[0].GetName())
+    assert.NotNil(t, respList.GetApiKeys()[0].GetLastUsedAt())
+
+    _, err = st.AuthClient.ListSessions(withToken(ctx, key), &ssov1.ListSessionsRequest{})
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.AuthClient.CreateAPIKey(withToken(ctx, key), &ssov1.CreateAPIKeyRequest{
+        Name:   "escalated",
+        Scopes: []string{"sessions:write"},
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.AuthClient.RevokeAPIKey(withToken(ctx, token), &ssov1.RevokeAPIKeyRequest{
+        ApiKeyId: respCreate.GetApiKey().GetId(),
+    })
+    require.NoError(t, err)
+
+    _, err = st.AuthClient.ListAPIKeys(withToken(ctx, key), &ssov1.ListAPIKeysRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAPIKeys_CreateFailCases(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    tests := []struct {
+        name        string
+        keyName     string
+        scopes      []string
+        expectedErr string
+    }{
+        {
+            name:        "Create without name",
+            keyName:     "",
+            scopes:      []string{"api_keys:read"},
+            expectedErr: "name is required",
+        },
+        {
+            name:        "Create without scopes",
+            keyName:     "ci",
+            scopes:      nil,
+            expectedErr: "at least one scope is required",
+        },
+        {
+            name:        "Create with unknown scope",
+            keyName:     "ci",
+            scopes:      []string{"everything"},
+            expectedErr: "invalid scope",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := st.AuthClient.CreateAPIKey(withToken(ctx, token), &ssov1.CreateAPIKeyRequest{
+                Name:   tt.keyName,
+                Scopes: tt.scopes,
+            })
+            require.Error(t, err)
+            require.Contains(t, err.Error(), tt.expectedErr)
+        })
+    }
+}
@@ -0,0 +1,37 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWatchAuthEvents_RequirePermission(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    stream, err := st.AuthClient.WatchAuthEvents(ctx, &ssov1.WatchAuthEventsRequest{})
+    require.NoError(t, err)
+    _, err = stream.Recv()
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    stream, err = st.AuthClient.WatchAuthEvents(withToken(ctx, token), &ssov1.WatchAuthEventsRequest{})
+    require.NoError(t, err)
+    _, err = stream.Recv()
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
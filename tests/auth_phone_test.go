@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSendLoginCode_UnknownNumber(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    // Unknown numbers get the same answer as known ones, so numbers can't be enumerated.
+    resp, err := st.AuthClient.SendLoginCode(ctx, &ssov1.SendLoginCodeRequest{
+        Phone: "+1415555" + gofakeit.Numerify("####"),
+    })
+    require.NoError(t, err)
+    assert.True(t, resp.GetExpiresAt().AsTime().After(time.Now()))
+}
+
+func TestLoginWithCode_FailCases(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    tests := []struct {
+        name        string
+        req         *ssov1.LoginWithCodeRequest
+        expectedErr string
+    }{
+        {
+            name:        "Without phone",
+            req:         &ssov1.LoginWithCodeRequest{Code: "123456"},
+            expectedErr: "phone is required",
+        },
+        {
+            name:        "Without code",
+            req:         &ssov1.LoginWithCodeRequest{Phone: "+14155550123"},
+            expectedErr: "code is required",
+        },
+        {
+            name:        "Never sent",
+            req:         &ssov1.LoginWithCodeRequest{Phone: "+1415555" + gofakeit.Numerify("####"), Code: "123456"},
+            expectedErr: "invalid code",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := st.AuthClient.LoginWithCode(ctx, tt.req)
+            require.Error(t, err)
+            assert.Equal(t, codes.InvalidArgument, status.Code(err))
+            assert.Contains(t, err.Error(), tt.expectedErr)
+        })
+    }
+}
+
+func TestStartPhoneVerification_RequiresUser(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    _, err := st.AuthClient.StartPhoneVerification(ctx, &ssov1.StartPhoneVerificationRequest{
+        Phone: "+14155550123",
+    })
+    require.Error(t, err)
+    assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"grpc-service-ref/tests/suite"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,7 +14,6 @@ import (
 )
 
 const (
-    secret = "test-secret"
     passDefaultLen = 10
 )
 
@@ -43,9 +43,8 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
     token := respLogin.GetToken()
     require.NotEmpty(t, token)
     
-    // TODO: secret
     tokenParsed, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
-        return []byte(secret), nil
+        return []byte(st.Cfg.TokenSecret), nil
     })
     require.NoError(t, err)
 
@@ -145,13 +144,13 @@ func TestLogin_FailCases(t *testing.T) {
 			      name:        "Login with Empty Email",
 			      email:       "",
 			      password:    randomFakePassword(),
-			      expectedErr: "email is required",
+			      expectedErr: "identifier is required",
 		    },
 		    {
 			      name:        "Login with Both Empty Email and Password",
 			      email:       "",
 			      password:    "",
-			      expectedErr: "email is required",
+			      expectedErr: "identifier is required",
 		    },
 		    {
 			      name:        "Login with Non-Matching Password",
@@ -184,3 +183,40 @@ func TestLogin_FailCases(t *testing.T) {
 func randomFakePassword() string {
     return gofakeit.Password(true, true, true, true, false, passDefaultLen)
 }
+
+func TestRegisterLogin_LoginWithUsername(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    username := "user_" + gofakeit.Password(true, false, true, false, false, 12)
+    pass := randomFakePassword()
+
+    respRegister, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    gofakeit.Email(),
+        Username: username,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Identifier: strings.ToUpper(username),
+        Password:   pass,
+    })
+    require.NoError(t, err)
+
+    tokenParsed, err := jwt.Parse(respLogin.GetToken(), func(token *jwt.Token) (any, error) {
+        return []byte(st.Cfg.TokenSecret), nil
+    })
+    require.NoError(t, err)
+
+    claims, ok := tokenParsed.Claims.(jwt.MapClaims)
+    require.True(t, ok)
+    assert.Equal(t, respRegister.GetUserId(), int64(claims["uid"].(float64)))
+
+    _, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    gofakeit.Email(),
+        Username: username,
+        Password: pass,
+    })
+    require.Error(t, err)
+    assert.Contains(t, err.Error(), "username already taken")
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"context"
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestSessions_ListAndRevoke(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    first := login(ctx, t, st, email, pass)
+    second := login(ctx, t, st, email, pass)
+
+    respList, err := st.AuthClient.ListSessions(withToken(ctx, first), &ssov1.ListSessionsRequest{})
+    require.NoError(t, err)
+    require.Len(t, respList.GetSessions(), 2)
+
+    var secondID string
+    for _, session := range respList.GetSessions() {
+        assert.NotEmpty(t, session.GetIp())
+        if !session.GetCurrent() {
+            secondID = session.GetId()
+        }
+    }
+    require.NotEmpty(t, secondID)
+
+    _, err = st.AuthClient.RevokeSession(withToken(ctx, first), &ssov1.RevokeSessionRequest{
+        SessionId: secondID,
+    })
+    require.NoError(t, err)
+
+    _, err = st.AuthClient.ListSessions(withToken(ctx, second), &ssov1.ListSessionsRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    respRevoke, err := st.AuthClient.RevokeAllSessions(withToken(ctx, first), &ssov1.RevokeAllSessionsRequest{})
+    require.NoError(t, err)
+    assert.Equal(t, int64(1), respRevoke.GetRevoked())
+
+    _, err = st.AuthClient.ListSessions(withToken(ctx, first), &ssov1.ListSessionsRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestSessions_RequireToken(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    _, err := st.AuthClient.ListSessions(ctx, &ssov1.ListSessionsRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    _, err = st.AuthClient.ListSessions(withToken(ctx, "not-a-token"), &ssov1.ListSessionsRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func login(ctx context.Context, t *testing.T, st *suite.Suite, email, pass string) string {
+    t.Helper()
+
+    resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    return resp.GetToken()
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+    return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
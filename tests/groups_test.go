@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGroups_RequirePermission(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+    require.NoError(t, err)
+
+    authCtx := withToken(ctx, login(ctx, t, st, email, pass))
+
+    _, err = st.GroupsClient.CreateGroup(authCtx, &ssov1.CreateGroupRequest{Name: gofakeit.Word()})
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users without groups:write don't create groups")
+
+    _, err = st.GroupsClient.ListGroups(authCtx, &ssov1.ListGroupsRequest{})
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    respAccess, err := st.GroupsClient.GetEffectiveAccess(authCtx, &ssov1.GetEffectiveAccessRequest{})
+    require.NoError(t, err, "users read their own access")
+    assert.Empty(t, respAccess.GetGroups())
+
+    respCheck, err := st.GroupsClient.CheckPermission(authCtx, &ssov1.CheckPermissionRequest{
+        Permission: "users:write",
+    })
+    require.NoError(t, err)
+    assert.False(t, respCheck.GetAllowed())
+    assert.Empty(t, respCheck.GetGrants())
+
+    _, err = st.GroupsClient.CheckPermission(authCtx, &ssov1.CheckPermissionRequest{
+        UserId:     respReg.GetUserId() + 1,
+        Permission: "users:write",
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "the access of other users needs groups:read")
+
+    _, err = st.GroupsClient.CheckPermission(ctx, &ssov1.CheckPermissionRequest{Permission: "users:write"})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
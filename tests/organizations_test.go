@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOrganizations_RequireMembership(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+    require.NoError(t, err)
+
+    authCtx := withToken(ctx, login(ctx, t, st, email, pass))
+
+    _, err = st.OrganizationsClient.CreateOrganization(authCtx, &ssov1.CreateOrganizationRequest{
+        Slug: "acme",
+        Name: "Acme",
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users without orgs:write don't create organizations")
+
+    respList, err := st.OrganizationsClient.ListOrganizations(authCtx, &ssov1.ListOrganizationsRequest{})
+    require.NoError(t, err)
+    assert.Empty(t, respList.GetOrganizations())
+
+    _, err = st.OrganizationsClient.ListMembers(authCtx, &ssov1.ListMembersRequest{OrganizationId: 1})
+    require.Equal(t, codes.NotFound, status.Code(err), "organizations are hidden from non-members")
+
+    _, err = st.OrganizationsClient.AcceptInvitation(authCtx, &ssov1.AcceptInvitationRequest{Token: "ssoinv_unknown"})
+    require.Equal(t, codes.NotFound, status.Code(err))
+
+    _, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+        Email:        email,
+        Password:     pass,
+        Organization: gofakeit.Word(),
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users log in to organizations they are members of only")
+
+    _, err = st.OrganizationsClient.ListOrganizations(ctx, &ssov1.ListOrganizationsRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPermissions_RequirePermission(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+    require.NoError(t, err)
+
+    authCtx := withToken(ctx, login(ctx, t, st, email, pass))
+
+    check := &ssov1.CheckRequest{
+        Resource:   &ssov1.ObjectReference{Type: "document", Id: "readme"},
+        Permission: "view",
+        Subject:    &ssov1.SubjectReference{Object: &ssov1.ObjectReference{Type: "user", Id: "1"}},
+    }
+
+    _, err = st.PermissionsClient.Check(authCtx, check)
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users without relationships:read don't check permissions")
+
+    _, err = st.PermissionsClient.WriteRelationships(authCtx, &ssov1.WriteRelationshipsRequest{
+        Updates: []*ssov1.RelationshipUpdate{{
+            Operation: "touch",
+            Relationship: &ssov1.Relationship{
+                Resource: check.GetResource(),
+                Relation: "viewer",
+                Subject:  check.GetSubject(),
+            },
+        }},
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.PermissionsClient.Check(ctx, check)
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
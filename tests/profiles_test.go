@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestProfiles_UpdateOwnProfile(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+    require.NoError(t, err)
+
+    authCtx := withToken(ctx, login(ctx, t, st, email, pass))
+
+    respUpdate, err := st.ProfilesClient.UpdateProfile(authCtx, &ssov1.UpdateProfileRequest{
+        Profile: &ssov1.Profile{
+            DisplayName: "Ann",
+            Locale:      "en-us",
+            FamilyName:  "not in the mask",
+        },
+        UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"display_name", "locale"}},
+    })
+    require.NoError(t, err)
+    assert.Equal(t, "Ann", respUpdate.GetProfile().GetDisplayName())
+    assert.Equal(t, "en-US", respUpdate.GetProfile().GetLocale())
+    assert.Empty(t, respUpdate.GetProfile().GetFamilyName())
+
+    respGet, err := st.ProfilesClient.GetProfile(authCtx, &ssov1.GetProfileRequest{
+        ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"display_name"}},
+    })
+    require.NoError(t, err)
+    assert.Equal(t, "Ann", respGet.GetProfile().GetDisplayName())
+    assert.Empty(t, respGet.GetProfile().GetLocale(), "only the fields in the read mask are returned")
+
+    _, err = st.ProfilesClient.UpdateProfile(authCtx, &ssov1.UpdateProfileRequest{
+        Profile:    &ssov1.Profile{},
+        UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"attributes.shop.plan"}},
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err), "users don't write attributes of apps")
+}
+
+func TestProfiles_FailCases(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+    require.NoError(t, err)
+
+    authCtx := withToken(ctx, login(ctx, t, st, email, pass))
+
+    tests := []struct {
+        name        string
+        req         *ssov1.UpdateProfileRequest
+        expectedErr string
+    }{
+        {
+            name:        "Without mask",
+            req:         &ssov1.UpdateProfileRequest{Profile: &ssov1.Profile{DisplayName: "Ann"}},
+            expectedErr: "update_mask is required",
+        },
+        {
+            name: "Unknown field",
+            req: &ssov1.UpdateProfileRequest{
+                UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+            },
+            expectedErr: "invalid field mask path",
+        },
+        {
+            name: "Invalid timezone",
+            req: &ssov1.UpdateProfileRequest{
+                Profile:    &ssov1.Profile{Timezone: "Mars/Olympus"},
+                UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"timezone"}},
+            },
+            expectedErr: "timezone must be an IANA time zone",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := st.ProfilesClient.UpdateProfile(authCtx, tt.req)
+            require.Error(t, err)
+            assert.Equal(t, codes.InvalidArgument, status.Code(err))
+            assert.Contains(t, err.Error(), tt.expectedErr)
+        })
+    }
+
+    _, err = st.ProfilesClient.GetProfile(ctx, &ssov1.GetProfileRequest{})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
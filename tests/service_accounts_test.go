@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServiceAccounts_RequireAdmin(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    _, err = st.ServiceAccountsClient.CreateServiceAccount(withToken(ctx, token), &ssov1.CreateServiceAccountRequest{
+        Name:      "ci",
+        OwnerKind: "team",
+        Owner:     "platform",
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.AuthClient.ListAPIKeys(withToken(ctx, token), &ssov1.ListAPIKeysRequest{
+        ServiceAccountId: 1,
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestLoginServiceAccount_FailCases(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    tests := []struct {
+        name         string
+        clientID     string
+        clientSecret string
+        expectedCode codes.Code
+    }{
+        {
+            name:         "Login without client id",
+            clientID:     "",
+            clientSecret: "secret",
+            expectedCode: codes.InvalidArgument,
+        },
+        {
+            name:         "Login without client secret",
+            clientID:     "sa_0123456789abcdef",
+            clientSecret: "",
+            expectedCode: codes.InvalidArgument,
+        },
+        {
+            name:         "Login with unknown client",
+            clientID:     "sa_0123456789abcdef",
+            clientSecret: "secret",
+            expectedCode: codes.Unauthenticated,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := st.AuthClient.LoginServiceAccount(ctx, &ssov1.LoginServiceAccountRequest{
+                ClientId:     tt.clientID,
+                ClientSecret: tt.clientSecret,
+            })
+            require.Equal(t, tt.expectedCode, status.Code(err))
+        })
+    }
+}
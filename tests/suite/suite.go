@@ -14,8 +14,15 @@ import (
 
 type Suite struct {
     *testing.T
-    Cfg        *config.Config
-    AuthClient ssov1.AuthClient
+    Cfg                   *config.Config
+    AuthClient            ssov1.AuthClient
+    ServiceAccountsClient ssov1.ServiceAccountsClient
+    AdminClient           ssov1.AdminClient
+    WebhooksClient        ssov1.WebhooksClient
+    ProfilesClient        ssov1.ProfilesClient
+    OrganizationsClient   ssov1.OrganizationsClient
+    GroupsClient          ssov1.GroupsClient
+    PermissionsClient     ssov1.PermissionsClient
 }
 
 const (
@@ -45,9 +52,16 @@ func New(t *testing.T) (context.Context, *Suite) {
     }
 
     return ctx, &Suite{
-    	T:          t,
-      	Cfg:        cfg,
-    	AuthClient: ssov1.NewAuthClient(cc),
+    	T:                     t,
+      	Cfg:                   cfg,
+    	AuthClient:            ssov1.NewAuthClient(cc),
+    	ServiceAccountsClient: ssov1.NewServiceAccountsClient(cc),
+    	AdminClient:           ssov1.NewAdminClient(cc),
+    	WebhooksClient:        ssov1.NewWebhooksClient(cc),
+    	ProfilesClient:        ssov1.NewProfilesClient(cc),
+    	OrganizationsClient:   ssov1.NewOrganizationsClient(cc),
+    	GroupsClient:          ssov1.NewGroupsClient(cc),
+    	PermissionsClient:     ssov1.NewPermissionsClient(cc),
     }
 }
 
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"grpc-service-ref/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit"
+	ssov1 "github.com/nonam00/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWebhooks_RequireAppOwner(t *testing.T) {
+    ctx, st := suite.New(t)
+
+    email := gofakeit.Email()
+    pass := randomFakePassword()
+
+    _, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+        Email:    email,
+        Password: pass,
+    })
+    require.NoError(t, err)
+
+    token := login(ctx, t, st, email, pass)
+
+    _, err = st.WebhooksClient.ListWebhookSubscriptions(ctx, &ssov1.ListWebhookSubscriptionsRequest{App: "billing"})
+    require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+    _, err = st.WebhooksClient.CreateWebhookSubscription(withToken(ctx, token), &ssov1.CreateWebhookSubscriptionRequest{
+        App:        "billing",
+        Url:        "https://billing.example.com/hooks",
+        EventTypes: []string{"user.registered"},
+    })
+    require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+    _, err = st.WebhooksClient.GetWebhookDelivery(withToken(ctx, token), &ssov1.GetWebhookDeliveryRequest{
+        DeliveryId: 1,
+    })
+    require.Equal(t, codes.NotFound, status.Code(err))
+}